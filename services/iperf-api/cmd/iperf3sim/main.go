@@ -0,0 +1,126 @@
+// Command iperf3sim stands in for iperf3 -s on machines without iperf3. It
+// accepts the flags the API launches iperf3 with and prints the output of a
+// scripted scenario of tests, so the API and dashboard can be developed and
+// end-to-end tests run without iperf3 or any clients. Point the API at it
+// with IPERF_SIMULATOR.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Tom-Oram/fak/backend/internal/iperfsim"
+)
+
+func main() {
+	defaults := iperfsim.DefaultConfig()
+	fs := flag.NewFlagSet("iperf3sim", flag.ExitOnError)
+
+	// Flags iperf3 -s is launched with
+	fs.Bool("s", true, "run in server mode (always)")
+	fs.Bool("forceflush", false, "flush output at every interval (always)")
+	verbose := fs.Bool("V", false, "more detailed output")
+	port := fs.Int("p", defaults.Port, "server port to listen on")
+	bind := fs.String("B", "", "bind to the interface with this address")
+	oneOff := fs.Bool("1", false, "handle one client connection, then exit")
+	fs.Bool("4", false, "only use IPv4")
+	ipv6 := fs.Bool("6", false, "only use IPv6")
+	reportInterval := fs.Float64("i", defaults.ReportInterval, "seconds between periodic throughput reports")
+	fs.Bool("debug", false, "emit debugging output (ignored)")
+	jsonOut := fs.Bool("J", false, "output in JSON format")
+	version := fs.Bool("v", false, "show version information and quit")
+
+	// Simulator flags
+	scenario := fs.String("sim-scenario", string(iperfsim.TCP), "comma-separated tests to play in turn: "+kindNames())
+	duration := fs.Int("sim-duration", defaults.Duration, "length of each test in seconds")
+	streams := fs.Int("sim-streams", defaults.Streams, "streams of a parallel test")
+	bitrate := fs.Float64("sim-bitrate", defaults.Bitrate/1e6, "mean TCP rate in Mbits/sec")
+	udpBitrate := fs.Float64("sim-udp-bitrate", defaults.UDPBitrate/1e6, "mean UDP rate in Mbits/sec")
+	interval := fs.Duration("sim-interval", defaults.Interval, "real time between interval reports")
+	pause := fs.Duration("sim-pause", defaults.Pause, "real time before each client connects")
+	seed := fs.Int64("sim-seed", defaults.Seed, "seed for repeatable measurements")
+	listen := fs.Bool("sim-listen", true, "hold the port open, accepting and closing connections")
+	fs.Parse(os.Args[1:])
+
+	if *version {
+		fmt.Println(iperfsim.Version + " (cJSON 1.7.15)")
+		fmt.Println(iperfsim.SystemInfo)
+		return
+	}
+
+	kinds, err := iperfsim.ParseScenario(*scenario)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iperf3: parameter error - %v\n", err)
+		os.Exit(1)
+	}
+
+	if *listen {
+		ln, err := net.Listen("tcp", net.JoinHostPort(*bind, strconv.Itoa(*port)))
+		if err != nil {
+			reason := err.Error()
+			if errors.Is(err, syscall.EADDRINUSE) {
+				reason = "Address already in use"
+			}
+			fmt.Fprintln(os.Stderr, "iperf3: error - unable to start listener for connections: "+reason)
+			os.Exit(1)
+		}
+		defer ln.Close()
+		go refuse(ln)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tests := 0
+	if *oneOff {
+		tests = 1
+	}
+	sim := iperfsim.New(iperfsim.Config{
+		Port:           *port,
+		BindAddress:    *bind,
+		IPv6:           *ipv6,
+		Verbose:        *verbose,
+		JSON:           *jsonOut,
+		Scenario:       kinds,
+		Duration:       *duration,
+		Streams:        *streams,
+		Bitrate:        *bitrate * 1e6,
+		UDPBitrate:     *udpBitrate * 1e6,
+		ReportInterval: *reportInterval,
+		Interval:       *interval,
+		Pause:          *pause,
+		Seed:           *seed,
+	}, os.Stdout, os.Stderr)
+	if sim.Run(ctx, tests) {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// refuse accepts and closes connections until ln is closed, so the port
+// shows as taken as it would with iperf3.
+func refuse(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+}
+
+func kindNames() string {
+	names := make([]string, len(iperfsim.Kinds))
+	for i, k := range iperfsim.Kinds {
+		names[i] = string(k)
+	}
+	return strings.Join(names, ", ")
+}
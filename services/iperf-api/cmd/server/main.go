@@ -1,13 +1,41 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Tom-Oram/fak/backend/internal/alerts"
 	"github.com/Tom-Oram/fak/backend/internal/api"
+	"github.com/Tom-Oram/fak/backend/internal/auth"
+	"github.com/Tom-Oram/fak/backend/internal/backup"
+	"github.com/Tom-Oram/fak/backend/internal/community"
+	"github.com/Tom-Oram/fak/backend/internal/drift"
+	"github.com/Tom-Oram/fak/backend/internal/energy"
+	"github.com/Tom-Oram/fak/backend/internal/federation"
+	"github.com/Tom-Oram/fak/backend/internal/geoip"
+	"github.com/Tom-Oram/fak/backend/internal/httpserver"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/iperfbin"
+	"github.com/Tom-Oram/fak/backend/internal/linkload"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/neighbor"
+	"github.com/Tom-Oram/fak/backend/internal/oui"
+	"github.com/Tom-Oram/fak/backend/internal/quality"
+	"github.com/Tom-Oram/fak/backend/internal/queue"
+	"github.com/Tom-Oram/fak/backend/internal/report"
+	"github.com/Tom-Oram/fak/backend/internal/slo"
 	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/Tom-Oram/fak/backend/internal/telemetry"
+	"github.com/Tom-Oram/fak/backend/internal/tunnel"
+	"github.com/Tom-Oram/fak/backend/internal/webui"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -21,23 +49,473 @@ func main() {
 		dataDir = "./data"
 	}
 
-	// Create data directory
-	os.MkdirAll(dataDir, 0755)
+	// DATA_DIR=:memory: or STORAGE=memory keeps results and settings in
+	// memory, for disposable demo instances
+	inMemory := dataDir == ":memory:" || os.Getenv("STORAGE") == "memory"
+	if dataDir == ":memory:" {
+		// Files that are still needed, such as an extracted iperf3 binary,
+		// go to a scratch directory
+		tmp, err := os.MkdirTemp("", "iperf-api-")
+		if err != nil {
+			log.Fatalf("Failed to create scratch directory: %v", err)
+		}
+		dataDir = tmp
+	}
+
+	// Optional OpenTelemetry export, configured by the standard OTEL_*
+	// variables. Batches are exported periodically, so little is lost when
+	// the process is killed.
+	otelTraces, otelMetrics := telemetry.Signals(os.Getenv)
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), os.Getenv)
+	if err != nil {
+		log.Fatalf("Failed to set up OpenTelemetry: %v", err)
+	}
+	defer shutdownTelemetry(context.Background())
+	if otelTraces || otelMetrics {
+		log.Printf("Exporting OpenTelemetry traces: %t, metrics: %t", otelTraces, otelMetrics)
+	}
+
+	// Storage that cannot be opened is retried in the background, so the API
+	// and /health come up without it
+	store := storage.NewReconnecting(
+		func() (storage.Store, bool, error) { return openStore(dataDir, inMemory) },
+		time.Duration(envInt("STORAGE_RETRY_MIN", 1))*time.Second,
+		time.Duration(envInt("STORAGE_RETRY_MAX", 60))*time.Second,
+	)
+
+	// Bring the statistics rollup up to date once storage opens, without
+	// delaying startup
+	go func() {
+		<-store.Ready()
+		if err := store.WarmResultStats(context.Background()); err != nil {
+			log.Printf("Failed to warm statistics: %v", err)
+			return
+		}
+		log.Printf("Statistics ready with %d hourly rows", store.ResultStatsStatus().Rows)
+	}()
+
+	// Locate iperf3, extracting the embedded binary if the system has none
+	iperfPath, err := iperfbin.Resolve(dataDir)
+	if err != nil {
+		log.Printf("Warning: %v; server start will fail until iperf3 is installed", err)
+		iperfPath = iperfbin.SystemBinary
+	} else {
+		log.Printf("Using iperf3 binary at %s", iperfPath)
+	}
+
+	managerOpts := []iperf.ManagerOption{
+		iperf.WithBinaryPath(iperfPath),
+		iperf.WithIperf2BinaryPath(os.Getenv("IPERF2_BINARY")),
+		iperf.WithClientParameters(envBool("IPERF_CLIENT_PARAMETERS", false)),
+		iperf.WithCollisionDetection(envBool("IPERF_DETECT_COLLISIONS", false)),
+		iperf.WithWarmup(float64(envInt("IPERF_WARMUP_SECONDS", 0))),
+		iperf.WithSmoothing(envInt("IPERF_SMOOTHING_WINDOW", 0)),
+		// Time for iperf to report a test cut short by stopping the server
+		iperf.WithStopGrace(time.Duration(envInt("IPERF_STOP_GRACE", 2)) * time.Second),
+	}
+
+	// Optional watchdog for test sessions that stop producing output
+	if timeout := envInt("IPERF_WATCHDOG_TIMEOUT", 0); timeout > 0 {
+		managerOpts = append(managerOpts, iperf.WithWatchdog(iperf.WatchdogConfig{
+			Timeout:        time.Duration(timeout) * time.Second,
+			Restart:        envBool("IPERF_WATCHDOG_RESTART", false),
+			DiagnosticsDir: filepath.Join(dataDir, "diagnostics"),
+		}))
+		log.Printf("Watchdog enabled with %ds timeout", timeout)
+	}
+
+	// Optional automatic restart of crashed iperf3 servers
+	if retries := envInt("IPERF_RESTART_MAX_RETRIES", 0); retries > 0 {
+		managerOpts = append(managerOpts, iperf.WithRestartPolicy(iperf.RestartPolicy{
+			MaxRetries: retries,
+			Backoff:    time.Duration(envInt("IPERF_RESTART_BACKOFF", 1)) * time.Second,
+			MaxBackoff: time.Duration(envInt("IPERF_RESTART_MAX_BACKOFF", 60)) * time.Second,
+			ResetAfter: time.Duration(envInt("IPERF_RESTART_RESET_AFTER", 300)) * time.Second,
+		}))
+		log.Printf("Automatic restart enabled with up to %d retries", retries)
+	}
+
+	// How iperf3 reports tests for servers that do not choose
+	if mode := models.OutputMode(os.Getenv("IPERF_OUTPUT_MODE")); mode != "" {
+		switch mode {
+		case models.OutputModeText, models.OutputModeJSON, models.OutputModeAuto:
+		default:
+			log.Fatalf("Invalid IPERF_OUTPUT_MODE %q: must be text, json or auto", mode)
+		}
+		managerOpts = append(managerOpts, iperf.WithOutputMode(mode))
+		log.Printf("iperf3 output mode %s", mode)
+	}
+
+	// Optional iperf3 simulator, for development and end-to-end tests on
+	// machines without iperf3
+	if simulator := os.Getenv("IPERF_SIMULATOR"); simulator != "" {
+		managerOpts = append(managerOpts, iperf.WithSimulator(iperf.SimulatorConfig{
+			Binary:   simulator,
+			Scenario: os.Getenv("IPERF_SIMULATOR_SCENARIO"),
+			Interval: time.Duration(envInt("IPERF_SIMULATOR_INTERVAL_MS", 0)) * time.Millisecond,
+			Pause:    time.Duration(envInt("IPERF_SIMULATOR_PAUSE_MS", 0)) * time.Millisecond,
+		}))
+		log.Printf("Simulating iperf3 with %s", simulator)
+	}
+
+	// Thresholds for flagging suspect results
+	qualityOpts := quality.DefaultOptions()
+	qualityOpts.ExpectedDuration = float64(envInt("IPERF_QUALITY_EXPECTED_DURATION", 0))
+	qualityOpts.MaxClockSkew = time.Duration(envInt("IPERF_QUALITY_MAX_CLOCK_SKEW", 300)) * time.Second
+
+	serverOpts := []api.Option{
+		api.WithStorageStatus(store.Status),
+		api.WithManagerOptions(managerOpts...),
+		api.WithQualityOptions(qualityOpts),
+		// The line rate goodput ratios are measured against, defaulting to
+		// the link capacity used for utilization
+		api.WithLinkSpeed(envFloat("LINK_SPEED_MBPS", envFloat("LINK_CAPACITY_MBPS", 0)) * 1e6),
+		api.WithRawOutputArchive(envBool("IPERF_ARCHIVE_RAW_OUTPUT", false)),
+		api.WithTestSummaries(envBool("WS_TEST_SUMMARY", false)),
+		// Coalesce bandwidth updates for clients that cannot keep up with
+		// sub-second intervals or many parallel streams
+		api.WithUpdateRate(envInt("WS_MAX_UPDATES_PER_SEC", 0)),
+		api.WithClientBuffer(envInt("WS_CLIENT_BUFFER", api.DefaultClientBuffer)),
+		// Trade CPU for bandwidth to remote dashboards
+		api.WithCompression(envBool("WS_COMPRESSION", false)),
+	}
+
+	// Execution queue priorities and job timeout
+	queueOpts := queue.DefaultOptions()
+	if spec := os.Getenv("QUEUE_PRIORITIES"); spec != "" {
+		priorities, err := queue.ParsePriorities(spec)
+		if err != nil {
+			log.Fatalf("Invalid QUEUE_PRIORITIES: %v", err)
+		}
+		queueOpts.Priorities = priorities
+	}
+	queueOpts.JobTimeout = time.Duration(envInt("QUEUE_JOB_TIMEOUT", 600)) * time.Second
+	queueOpts.DeferInterval = time.Duration(envInt("QUEUE_DEFER_INTERVAL", 300)) * time.Second
+	queueOpts.MaxDeferrals = envInt("QUEUE_MAX_DEFERRALS", 12)
+	serverOpts = append(serverOpts, api.WithQueueOptions(queueOpts))
+
+	// How session, result and job IDs are generated
+	newID, err := ids.Parse(os.Getenv("ID_FORMAT"))
+	if err != nil {
+		log.Fatalf("Invalid ID_FORMAT: %v", err)
+	}
+	serverOpts = append(serverOpts, api.WithIDGenerator(newID))
+
+	// The name results and WebSocket messages carry, defaulting to the host
+	// name
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		nodeName, _ = os.Hostname()
+	}
+	serverOpts = append(serverOpts, api.WithNodeName(nodeName))
+	log.Printf("Node name: %s", nodeName)
+
+	// How often the server is checked against its declared desired state
+	serverOpts = append(serverOpts, api.WithDriftOptions(drift.Options{
+		Interval: time.Duration(envInt("DRIFT_CHECK_INTERVAL", 60)) * time.Second,
+	}))
+
+	// Optional API keys with viewer and operator roles
+	keys, err := auth.ParseKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid API_KEYS: %v", err)
+	}
+	if keys.Enabled() {
+		serverOpts = append(serverOpts, api.WithAPIKeys(keys))
+		log.Println("API key authentication enabled")
+	}
+
+	// Optional federation with peer deployments, from the peers file and
+	// registered through /api/peers
+	if peersFile := os.Getenv("FEDERATION_PEERS_FILE"); peersFile != "" || envBool("FEDERATION_ENABLED", false) {
+		var peers []models.Peer
+		if peersFile != "" {
+			if peers, err = federation.LoadPeers(peersFile); err != nil {
+				log.Fatalf("Failed to load federation peers: %v", err)
+			}
+		}
+		localName := os.Getenv("FEDERATION_NAME")
+		if localName == "" {
+			localName = "local"
+		}
+		timeout := time.Duration(envInt("FEDERATION_TIMEOUT", 5)) * time.Second
+		client := federation.NewClient(peers, timeout)
+		go func() {
+			<-store.Ready()
+			registered, err := store.ListPeers(context.Background())
+			if err != nil {
+				log.Printf("Failed to load registered federation peers: %v", err)
+				return
+			}
+			client.SetRegistered(registered)
+			log.Printf("Loaded %d registered federation peers", len(registered))
+		}()
+		serverOpts = append(serverOpts, api.WithFederation(client, localName))
+		log.Printf("Federation enabled with %d peers from the peers file", len(peers))
+
+		if interval := envInt("FEDERATION_PULL_INTERVAL", 0); interval > 0 {
+			puller := federation.NewPuller(client, store, time.Duration(interval)*time.Second)
+			go puller.Run()
+			serverOpts = append(serverOpts, api.WithPeerPulling(puller))
+			log.Printf("Pulling peer history every %ds", interval)
+		}
+	}
+
+	// Optional sharing of anonymised aggregates with a community endpoint
+	if endpoint := os.Getenv("COMMUNITY_ENDPOINT"); endpoint != "" {
+		cfg := community.Config{
+			Endpoint: endpoint,
+			Token:    os.Getenv("COMMUNITY_TOKEN"),
+			Region:   os.Getenv("COMMUNITY_REGION"),
+			ISP:      os.Getenv("COMMUNITY_ISP"),
+			Interval: time.Duration(envInt("COMMUNITY_INTERVAL", 86400)) * time.Second,
+			MinTests: envInt("COMMUNITY_MIN_TESTS", 10),
+			Epsilon:  envFloat("COMMUNITY_EPSILON", 0),
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid community configuration: %v", err)
+		}
+		reporter := community.NewReporter(cfg, store.GetTestResultsBetween)
+		go reporter.Run()
+		serverOpts = append(serverOpts, api.WithCommunity(reporter))
+		log.Printf("Sharing aggregates for %s/%s with %s every %s", cfg.Region, cfg.ISP, endpoint, cfg.Interval)
+	}
+
+	// Optional scheduled backups of the database to a directory or bucket
+	if dir, bucket := os.Getenv("BACKUP_DIR"), os.Getenv("BACKUP_S3_BUCKET"); dir != "" || bucket != "" {
+		if inMemory {
+			log.Fatal("Backups need SQLite storage, not in-memory storage")
+		}
+		if dir != "" && bucket != "" {
+			log.Fatal("Invalid backup configuration: set BACKUP_DIR or BACKUP_S3_BUCKET, not both")
+		}
+		cfg := backup.Config{
+			Interval: time.Duration(envInt("BACKUP_INTERVAL", 86400)) * time.Second,
+			Keep:     envInt("BACKUP_KEEP", 7),
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid backup configuration: %v", err)
+		}
+		var target backup.Target
+		if bucket != "" {
+			target, err = backup.NewS3(backup.S3Config{
+				Endpoint:     os.Getenv("BACKUP_S3_ENDPOINT"),
+				Region:       os.Getenv("BACKUP_S3_REGION"),
+				Bucket:       bucket,
+				Prefix:       os.Getenv("BACKUP_S3_PREFIX"),
+				AccessKey:    os.Getenv("BACKUP_S3_ACCESS_KEY"),
+				SecretKey:    os.Getenv("BACKUP_S3_SECRET_KEY"),
+				SessionToken: os.Getenv("BACKUP_S3_SESSION_TOKEN"),
+				PathStyle:    envBool("BACKUP_S3_PATH_STYLE", false),
+			}, cfg.Timeout)
+		} else {
+			target, err = backup.NewDir(dir)
+		}
+		if err != nil {
+			log.Fatalf("Invalid backup configuration: %v", err)
+		}
+		scheduler := backup.NewScheduler(cfg, store.Backup, target)
+		go scheduler.Run()
+		serverOpts = append(serverOpts, api.WithBackups(scheduler))
+		log.Printf("Backing up to %s every %s, keeping %d", target.Name(), cfg.Interval, cfg.Keep)
+	}
+
+	// Optional GeoIP enrichment of client addresses
+	if paths := envList("GEOIP_DATABASES"); len(paths) > 0 {
+		resolver, err := geoip.Open(paths...)
+		if err != nil {
+			log.Fatalf("Failed to load GeoIP databases: %v", err)
+		}
+		serverOpts = append(serverOpts, api.WithGeoIP(resolver))
+		log.Printf("GeoIP enrichment enabled with %v", resolver.Types())
+	}
+
+	// Optional MAC address and vendor lookup of directly attached clients
+	if envBool("NEIGHBOR_LOOKUP", false) {
+		vendors := oui.Builtin()
+		if path := os.Getenv("OUI_DATABASE"); path != "" {
+			var err error
+			if vendors, err = oui.Load(path); err != nil {
+				log.Fatalf("Failed to load OUI database: %v", err)
+			}
+		}
+		table := os.Getenv("NEIGHBOR_TABLE")
+		if table == "" {
+			table = neighbor.DefaultPath
+		}
+		serverOpts = append(serverOpts, api.WithNeighbors(neighbor.NewTable(table, vendors)))
+		log.Printf("Neighbor lookup enabled from %s with %d OUI assignments", table, vendors.Len())
+	}
+
+	// Optional position of this site on the results map
+	if value := os.Getenv("SITE_LOCATION"); value != "" {
+		location, err := models.ParseLocation(value)
+		if err != nil {
+			log.Fatalf("Invalid SITE_LOCATION: %v", err)
+		}
+		serverOpts = append(serverOpts, api.WithSiteLocation(location))
+	}
+
+	// Optional service level objectives
+	if sloFile := os.Getenv("SLO_FILE"); sloFile != "" {
+		objectives, err := slo.LoadObjectives(sloFile)
+		if err != nil {
+			log.Fatalf("Failed to load service level objectives: %v", err)
+		}
+		serverOpts = append(serverOpts, api.WithObjectives(objectives))
+		log.Printf("Loaded %d service level objectives", len(objectives))
+	}
 
-	// Initialize SQLite storage
-	dbPath := filepath.Join(dataDir, "iperf.db")
-	store, err := storage.NewSQLiteStorage(dbPath)
+	// Optional email notifications for alerts and server errors
+	email, err := alerts.NewEmailNotifier(alerts.EmailConfig{
+		Host:            os.Getenv("SMTP_HOST"),
+		Port:            envInt("SMTP_PORT", 0),
+		Username:        os.Getenv("SMTP_USERNAME"),
+		Password:        os.Getenv("SMTP_PASSWORD"),
+		From:            os.Getenv("SMTP_FROM"),
+		To:              envList("SMTP_TO"),
+		TLS:             alerts.EmailTLSMode(os.Getenv("SMTP_TLS")),
+		SubjectTemplate: os.Getenv("SMTP_SUBJECT_TEMPLATE"),
+		BodyTemplate:    os.Getenv("SMTP_BODY_TEMPLATE"),
+	}, 30*time.Second)
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		log.Fatalf("Invalid SMTP configuration: %v", err)
+	}
+	if email.Enabled() {
+		log.Printf("Email notifications enabled via %s", os.Getenv("SMTP_HOST"))
+	}
+	serverOpts = append(serverOpts, api.WithEmailNotifier(email))
+
+	// Optional weekly or monthly reports to opted-in channels
+	if period := os.Getenv("REPORT_SCHEDULE"); period != "" {
+		cfg := report.ScheduleConfig{
+			Period:      report.Period(period),
+			Degradation: envFloat("REPORT_DEGRADATION_PERCENT", 10),
+			Node:        nodeName,
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid report configuration: %v", err)
+		}
+		var channels []report.Channel
+		if envBool("REPORT_EMAIL", false) {
+			if !email.Enabled() {
+				log.Fatal("Invalid report configuration: REPORT_EMAIL needs SMTP_HOST")
+			}
+			channels = append(channels, report.NewEmailChannel(email))
+		}
+		for _, target := range envList("REPORT_WEBHOOKS") {
+			webhook, err := report.NewWebhookChannel(target, 30*time.Second)
+			if err != nil {
+				log.Fatalf("Invalid report configuration: %v", err)
+			}
+			channels = append(channels, webhook)
+		}
+		if len(channels) == 0 {
+			log.Fatal("Invalid report configuration: set REPORT_EMAIL or REPORT_WEBHOOKS")
+		}
+		scheduler := report.NewScheduler(cfg, store.GetTestResultsBetween, channels...)
+		go scheduler.Run()
+		serverOpts = append(serverOpts, api.WithReportSchedule(scheduler))
+		log.Printf("Sending %s reports to %d channels", cfg.Period, len(channels))
+	}
+
+	// Optional power metering during tests
+	if kind := os.Getenv("ENERGY_SOURCE"); kind != "" {
+		interval := time.Duration(envInt("ENERGY_SAMPLE_INTERVAL_MS", 1000)) * time.Millisecond
+		source, err := energy.NewSource(kind, os.Getenv("ENERGY_TARGET"), interval)
+		if err != nil {
+			log.Fatalf("Failed to set up energy metering: %v", err)
+		}
+		serverOpts = append(serverOpts, api.WithEnergyMeter(energy.NewMeter(source, interval)))
+		log.Printf("Energy metering enabled via %s every %s", source.Name(), interval)
+	}
+
+	// Optional deferral of scheduled jobs while the uplink is busy
+	if kind := os.Getenv("LINK_SOURCE"); kind != "" {
+		source, err := linkload.NewSource(linkload.Config{
+			Kind:      kind,
+			Target:    os.Getenv("LINK_TARGET"),
+			Community: os.Getenv("LINK_SNMP_COMMUNITY"),
+			IfIndex:   envInt("LINK_SNMP_IFINDEX", 0),
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up link monitoring: %v", err)
+		}
+		threshold := envFloat("LINK_BUSY_THRESHOLD", 70)
+		monitor := linkload.NewMonitor(source,
+			envFloat("LINK_CAPACITY_MBPS", 0)*1e6,
+			time.Duration(envInt("LINK_SAMPLE_SECONDS", 2))*time.Second,
+			threshold)
+		serverOpts = append(serverOpts, api.WithLinkMonitor(monitor))
+		log.Printf("Scheduled jobs deferred while %s is above %.0f%% utilization", source.Name(), threshold)
+	}
+
+	// Optional outbound management tunnel for probes behind NAT
+	var relay *tunnel.Tunnel
+	if relayURL := os.Getenv("TUNNEL_URL"); relayURL != "" {
+		probeID := os.Getenv("TUNNEL_PROBE_ID")
+		if probeID == "" {
+			probeID, _ = os.Hostname()
+		}
+		cfg := tunnel.Config{
+			URL:          relayURL,
+			ProbeID:      probeID,
+			Token:        os.Getenv("TUNNEL_TOKEN"),
+			PingInterval: time.Duration(envInt("TUNNEL_PING_INTERVAL", 30)) * time.Second,
+			MaxBackoff:   time.Duration(envInt("TUNNEL_MAX_BACKOFF", 60)) * time.Second,
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid tunnel configuration: %v", err)
+		}
+		relay = tunnel.New(cfg)
+		serverOpts = append(serverOpts, api.WithTunnel(relay))
+	}
+
+	// Optional dashboard served alongside the API, from disk or the bundle
+	// built into the binary
+	if dir := os.Getenv("WEBUI_DIR"); dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+			log.Fatalf("Invalid WEBUI_DIR: %v", err)
+		}
+		serverOpts = append(serverOpts, api.WithWebUI(os.DirFS(dir)))
+		log.Printf("Serving the web UI from %s", dir)
+	} else if ui := webui.Embedded(); ui != nil && !envBool("WEBUI_DISABLED", false) {
+		serverOpts = append(serverOpts, api.WithWebUI(ui))
+		log.Println("Serving the embedded web UI")
+	}
+
+	// Message catalogs, optionally extended with <lang>.json files
+	translations := i18n.MustNew()
+	if dir := os.Getenv("I18N_DIR"); dir != "" {
+		if err := translations.LoadDir(dir); err != nil {
+			log.Fatalf("Failed to load translations: %v", err)
+		}
+		log.Printf("Loaded translations from %s: %v", dir, translations.Languages())
+	}
+	serverOpts = append(serverOpts, api.WithTranslations(translations))
+
+	// Trace the API's storage calls and record the server's lifecycle
+	var apiStore storage.Store = store
+	if otelTraces || otelMetrics {
+		apiStore = telemetry.Store(store)
+		serverOpts = append(serverOpts, api.WithTelemetry(telemetry.NewObserver()))
 	}
-	defer store.Close()
-	log.Printf("Database initialized at %s", dbPath)
 
 	// Create API server
-	server := api.NewServer(store)
+	server := api.NewServer(apiStore, serverOpts...)
+	// Stops iperf, disconnects WebSocket clients and closes storage
+	defer server.Close()
 
 	// Setup router
 	r := chi.NewRouter()
+	// Behind a reverse proxy, take the client address from X-Forwarded-For so
+	// logs and the audit log record the real caller
+	if envBool("TRUST_PROXY_HEADERS", false) {
+		r.Use(middleware.RealIP)
+	}
+	if otelTraces || otelMetrics {
+		r.Use(telemetry.Middleware)
+	}
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(corsMiddleware)
@@ -45,24 +523,79 @@ func main() {
 	// Mount routes
 	r.Mount("/", server.Routes())
 
+	// Requests through the tunnel take the same route, so API keys apply
+	if relay != nil {
+		go relay.Run(context.Background(), r, server.Subscribe)
+		log.Printf("Management tunnel enabled via %s", relay.Status().Relay)
+	}
+
 	// Get port from env, default 8080
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("Listening on :%s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	// Optional TLS from a certificate pair or Let's Encrypt
+	httpCfg := httpserver.Config{
+		Addr:             ":" + port,
+		CertFile:         os.Getenv("TLS_CERT_FILE"),
+		KeyFile:          os.Getenv("TLS_KEY_FILE"),
+		AutocertHosts:    envList("TLS_AUTOCERT_HOSTS"),
+		AutocertCacheDir: filepath.Join(dataDir, "autocert"),
+		AutocertEmail:    os.Getenv("TLS_AUTOCERT_EMAIL"),
+	}
+	if redirectPort := os.Getenv("HTTP_REDIRECT_PORT"); redirectPort != "" {
+		httpCfg.RedirectAddr = ":" + redirectPort
+	}
+	if err := httpCfg.Validate(); err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+
+	if httpCfg.TLSEnabled() {
+		log.Printf("Listening with TLS on :%s", port)
+	} else {
+		log.Printf("Listening on :%s", port)
+	}
+	if err := httpserver.ListenAndServe(httpCfg, r); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// openStore returns the in-memory store, or opens the SQLite database in
+// dataDir with the DB_* settings, read-only on a read-only filesystem.
+func openStore(dataDir string, inMemory bool) (storage.Store, bool, error) {
+	if inMemory {
+		log.Println("Using in-memory storage; nothing is kept after exit")
+		return storage.NewMemory(), false, nil
+	}
+
+	storeOpts := []storage.Option{
+		storage.WithQueryTimeout(time.Duration(envInt("DB_QUERY_TIMEOUT", 10)) * time.Second),
+		storage.WithBusyTimeout(time.Duration(envInt("DB_BUSY_TIMEOUT_MS", 5000)) * time.Millisecond),
+		storage.WithMaxOpenConns(envInt("DB_MAX_OPEN_CONNS", storage.DefaultMaxOpenConns)),
+	}
+	if mode := os.Getenv("DB_JOURNAL_MODE"); mode != "" {
+		storeOpts = append(storeOpts, storage.WithJournalMode(mode))
+	}
+	store, readOnly, err := storage.OpenDataDir(dataDir, storeOpts...)
+	if err != nil {
+		return nil, false, err
+	}
+	dbPath := filepath.Join(dataDir, storage.DatabaseFile)
+	if readOnly {
+		log.Printf("Database opened read-only at %s; the filesystem is read-only, so nothing new is saved", dbPath)
+	} else {
+		log.Printf("Database initialized at %s", dbPath)
+	}
+	return store, readOnly, nil
+}
+
 // CORS middleware allowing all origins for development
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -70,3 +603,48 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// envInt returns the integer value of an environment variable, or def if unset or invalid
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		log.Printf("Ignoring invalid %s=%q", key, v)
+	}
+	return def
+}
+
+// envFloat returns the numeric value of an environment variable, or def if unset or invalid
+func envFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+		log.Printf("Ignoring invalid %s=%q", key, v)
+	}
+	return def
+}
+
+// envBool returns the boolean value of an environment variable, or def if unset or invalid
+func envBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+		log.Printf("Ignoring invalid %s=%q", key, v)
+	}
+	return def
+}
+
+// envList returns the comma-separated values of an environment variable,
+// trimmed and with empty entries dropped
+func envList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,11 @@
+// Package integration runs real iperf3 clients against the API and its
+// managed server in Docker, checking stored results and WebSocket event
+// sequences end to end. The tests need the integration build tag and
+// Docker Compose:
+//
+//	go test -tags integration ./integration/
+//
+// INTEGRATION_API_PORT changes the host port the API is published on
+// (default 18080), and INTEGRATION_KEEP=true leaves the containers running
+// afterwards for debugging or a faster rerun.
+package integration
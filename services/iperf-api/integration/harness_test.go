@@ -0,0 +1,307 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// Addresses of the containers on the test network
+const (
+	serverHost      = "server"
+	allowedClient   = "client-allowed"
+	allowedClientIP = "172.30.0.21"
+	deniedClient    = "client-denied"
+	deniedClientIP  = "172.30.0.22"
+	iperfPort       = 5201
+)
+
+// baseURL is where the API is published on the host.
+var baseURL string
+
+func TestMain(m *testing.M) {
+	port := os.Getenv("INTEGRATION_API_PORT")
+	if port == "" {
+		port = "18080"
+	}
+	baseURL = "http://127.0.0.1:" + port
+
+	if err := compose("up", "--build", "--detach", "--wait"); err != nil {
+		log.Printf("Starting the integration environment failed: %v", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	if os.Getenv("INTEGRATION_KEEP") != "true" {
+		if err := compose("down", "--volumes"); err != nil {
+			log.Printf("Stopping the integration environment failed: %v", err)
+		}
+	}
+	os.Exit(code)
+}
+
+// compose runs docker compose against this directory's environment.
+func compose(args ...string) error {
+	cmd := exec.Command("docker", append([]string{"compose", "-f", "docker-compose.yml"}, args...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// clientReport is the part of iperf3's --json output the tests check.
+type clientReport struct {
+	End struct {
+		SumSent struct {
+			Bytes int64 `json:"bytes"`
+		} `json:"sum_sent"`
+		SumReceived struct {
+			Bytes int64 `json:"bytes"`
+		} `json:"sum_received"`
+		// Sum is reported for UDP tests
+		Sum struct {
+			Bytes       int64   `json:"bytes"`
+			LostPercent float64 `json:"lost_percent"`
+		} `json:"sum"`
+	} `json:"end"`
+	Error string `json:"error"`
+}
+
+// runClient runs an iperf3 test from a client container against the
+// managed server, with extra iperf3 arguments. The server reports running
+// as soon as iperf3 is launched, so refused connections are retried
+// briefly while it starts listening.
+func runClient(t *testing.T, client string, args ...string) clientReport {
+	t.Helper()
+	for attempt := 1; ; attempt++ {
+		report := runClientOnce(t, client, args...)
+		if attempt == 5 || !strings.Contains(report.Error, "unable to connect") {
+			return report
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func runClientOnce(t *testing.T, client string, args ...string) clientReport {
+	t.Helper()
+	argv := append([]string{"exec", "-T", client, "iperf3", "--json",
+		"-c", serverHost, "-p", fmt.Sprint(iperfPort)}, args...)
+	cmd := exec.Command("docker", append([]string{"compose", "-f", "docker-compose.yml"}, argv...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+
+	var report clientReport
+	if jsonErr := json.Unmarshal(out, &report); jsonErr != nil {
+		t.Fatalf("iperf3 on %s: %v (%v): %s", client, err, jsonErr, stderr.String())
+	}
+	if err != nil && report.Error == "" {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// call makes an API request with an optional JSON body, decoding the
+// response into out when it is not nil, and returns the status code.
+func call(t *testing.T, method, path string, body, out interface{}) int {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, baseURL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// startServer starts the managed server with cfg, stopping it again when
+// the test ends.
+func startServer(t *testing.T, cfg models.ServerConfig) {
+	t.Helper()
+	// A previous test may have left it running
+	call(t, http.MethodPost, "/api/stop", nil, nil)
+	waitForStatus(t, models.ServerStatusStopped)
+
+	var errResp map[string]interface{}
+	if code := call(t, http.MethodPost, "/api/start", cfg, &errResp); code != http.StatusOK {
+		t.Fatalf("start: status %d: %v", code, errResp)
+	}
+	waitForStatus(t, models.ServerStatusRunning)
+	t.Cleanup(func() {
+		call(t, http.MethodPost, "/api/stop", nil, nil)
+	})
+}
+
+func waitForStatus(t *testing.T, want models.ServerStatus) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var status struct {
+			Status models.ServerStatus `json:"status"`
+		}
+		call(t, http.MethodGet, "/api/status", nil, &status)
+		if status.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server status %q, want %q", status.Status, want)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// defaultConfig is the managed server's configuration for most tests.
+func defaultConfig() models.ServerConfig {
+	cfg := models.DefaultServerConfig()
+	cfg.Port = iperfPort
+	cfg.IdleTimeout = 0
+	return cfg
+}
+
+// event is a WebSocket message with its payload left encoded.
+type event struct {
+	Type    models.WSMessageType `json:"type"`
+	Payload json.RawMessage      `json:"payload"`
+}
+
+// eventLog records the WebSocket messages broadcast during a test.
+type eventLog struct {
+	mu     sync.Mutex
+	events []event
+}
+
+// recordEvents subscribes to the API's WebSocket until the test ends.
+func recordEvents(t *testing.T) *eventLog {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(baseURL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dialing WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	l := &eventLog{}
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var e event
+			if json.Unmarshal(data, &e) == nil {
+				l.mu.Lock()
+				l.events = append(l.events, e)
+				l.mu.Unlock()
+			}
+		}
+	}()
+	return l
+}
+
+// waitFor waits for an event of type typ whose payload contains substr.
+func (l *eventLog) waitFor(t *testing.T, typ models.WSMessageType, substr string) event {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, e := range l.snapshot() {
+			if e.Type == typ && strings.Contains(string(e.Payload), substr) {
+				return e
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no %s event containing %q; saw %v", typ, substr, l.types())
+	return event{}
+}
+
+func (l *eventLog) snapshot() []event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]event(nil), l.events...)
+}
+
+// types lists the types of the test lifecycle and error events in the
+// order they arrived; status, queue and advisory events are left out.
+func (l *eventLog) types() []models.WSMessageType {
+	var types []models.WSMessageType
+	for _, e := range l.snapshot() {
+		switch e.Type {
+		case models.WSMessageTypeClientConnected, models.WSMessageTypeBandwidthUpdate,
+			models.WSMessageTypeTestComplete, models.WSMessageTypeError, models.WSMessageTypeCollision:
+			types = append(types, e.Type)
+		}
+	}
+	return types
+}
+
+// checkSequence checks that a test's events arrived as connect, at least
+// one bandwidth update, then complete.
+func (l *eventLog) checkSequence(t *testing.T) {
+	t.Helper()
+	types := l.types()
+	var compact []models.WSMessageType
+	for _, typ := range types {
+		if len(compact) > 0 && compact[len(compact)-1] == typ {
+			continue
+		}
+		compact = append(compact, typ)
+	}
+	want := []models.WSMessageType{
+		models.WSMessageTypeClientConnected,
+		models.WSMessageTypeBandwidthUpdate,
+		models.WSMessageTypeTestComplete,
+	}
+	if fmt.Sprint(compact) != fmt.Sprint(want) {
+		t.Errorf("event sequence = %v, want %v", types, want)
+	}
+}
+
+// latestResult returns the newest stored result from clientIP.
+func latestResult(t *testing.T, clientIP string) models.TestResult {
+	t.Helper()
+	var history struct {
+		Results []models.TestResult `json:"results"`
+	}
+	call(t, http.MethodGet, "/api/history?limit=1&clientIp="+clientIP, nil, &history)
+	if len(history.Results) == 0 {
+		t.Fatalf("no stored result from %s", clientIP)
+	}
+	return history.Results[0]
+}
+
+// within reports whether got is within tolerance (a fraction) of want.
+func within(got, want int64, tolerance float64) bool {
+	diff := float64(got - want)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance*float64(want)
+}
@@ -0,0 +1,131 @@
+//go:build integration
+
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestTCPUpload(t *testing.T) {
+	startServer(t, defaultConfig())
+	events := recordEvents(t)
+
+	report := runClient(t, allowedClient, "-t", "3")
+	if report.Error != "" {
+		t.Fatalf("client failed: %s", report.Error)
+	}
+	events.waitFor(t, models.WSMessageTypeTestComplete, allowedClientIP)
+	events.checkSequence(t)
+
+	r := latestResult(t, allowedClientIP)
+	if r.Status != models.TestStatusCompleted || r.Protocol != models.ProtocolTCP || r.Direction != "upload" {
+		t.Errorf("result = %+v, want a completed TCP upload", r)
+	}
+	if r.ServerPort != iperfPort || r.Duration < 2.5 {
+		t.Errorf("port %d, duration %.1fs: want %d and about 3s", r.ServerPort, r.Duration, iperfPort)
+	}
+	if !within(r.BytesTransferred, report.End.SumReceived.Bytes, 0.05) {
+		t.Errorf("stored %d bytes, client received %d", r.BytesTransferred, report.End.SumReceived.Bytes)
+	}
+	if r.Client == nil || r.Client.Streams != 1 || r.Client.Duration != 3 {
+		t.Errorf("fingerprint = %+v, want 1 stream for 3s", r.Client)
+	}
+}
+
+func TestTCPReverse(t *testing.T) {
+	startServer(t, defaultConfig())
+	events := recordEvents(t)
+
+	report := runClient(t, allowedClient, "-t", "2", "-R")
+	if report.Error != "" {
+		t.Fatalf("client failed: %s", report.Error)
+	}
+	events.waitFor(t, models.WSMessageTypeTestComplete, allowedClientIP)
+
+	r := latestResult(t, allowedClientIP)
+	if r.Direction != "download" || r.Status != models.TestStatusCompleted {
+		t.Errorf("result = %+v, want a completed download", r)
+	}
+	if !within(r.BytesTransferred, report.End.SumSent.Bytes, 0.05) {
+		t.Errorf("stored %d bytes, client counted %d sent by the server", r.BytesTransferred, report.End.SumSent.Bytes)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Protocol = models.ProtocolUDP
+	startServer(t, cfg)
+	events := recordEvents(t)
+
+	report := runClient(t, allowedClient, "-u", "-b", "20M", "-t", "3")
+	if report.Error != "" {
+		t.Fatalf("client failed: %s", report.Error)
+	}
+	events.waitFor(t, models.WSMessageTypeTestComplete, allowedClientIP)
+	events.checkSequence(t)
+
+	r := latestResult(t, allowedClientIP)
+	if r.Protocol != models.ProtocolUDP || r.Status != models.TestStatusCompleted {
+		t.Errorf("result = %+v, want a completed UDP test", r)
+	}
+	if r.Jitter == nil || r.PacketLoss == nil {
+		t.Errorf("jitter %v, loss %v: want both reported for UDP", r.Jitter, r.PacketLoss)
+	}
+	// 20 Mbit/s for 3s
+	if r.BytesTransferred < 5_000_000 {
+		t.Errorf("stored %d bytes, want about 7.5 MB", r.BytesTransferred)
+	}
+	if r.Client == nil || r.Client.Protocol != models.ProtocolUDP || r.Client.Bandwidth != 20_000_000 {
+		t.Errorf("fingerprint = %+v, want UDP at 20 Mbit/s", r.Client)
+	}
+}
+
+func TestMultiStream(t *testing.T) {
+	startServer(t, defaultConfig())
+	events := recordEvents(t)
+
+	report := runClient(t, allowedClient, "-P", "4", "-t", "3")
+	if report.Error != "" {
+		t.Fatalf("client failed: %s", report.Error)
+	}
+	events.waitFor(t, models.WSMessageTypeTestComplete, allowedClientIP)
+	events.checkSequence(t)
+
+	// The stored result is the sum over all streams
+	r := latestResult(t, allowedClientIP)
+	if !within(r.BytesTransferred, report.End.SumReceived.Bytes, 0.05) {
+		t.Errorf("stored %d bytes, client received %d over 4 streams", r.BytesTransferred, report.End.SumReceived.Bytes)
+	}
+	if r.Client == nil || r.Client.Streams != 4 {
+		t.Errorf("fingerprint = %+v, want 4 streams", r.Client)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Allowlist = []string{allowedClientIP}
+	startServer(t, cfg)
+	events := recordEvents(t)
+
+	// A refused client is reported, and never announced as connected
+	runClient(t, deniedClient, "-t", "1")
+	events.waitFor(t, models.WSMessageTypeError, deniedClientIP)
+	for _, e := range events.snapshot() {
+		if e.Type == models.WSMessageTypeClientConnected && strings.Contains(string(e.Payload), deniedClientIP) {
+			t.Errorf("refused client announced: %s", e.Payload)
+		}
+	}
+
+	report := runClient(t, allowedClient, "-t", "2")
+	if report.Error != "" {
+		t.Fatalf("allowed client failed: %s", report.Error)
+	}
+	events.waitFor(t, models.WSMessageTypeClientConnected, allowedClientIP)
+	events.waitFor(t, models.WSMessageTypeTestComplete, allowedClientIP)
+	if r := latestResult(t, allowedClientIP); r.Status != models.TestStatusCompleted {
+		t.Errorf("allowed client's result = %+v", r)
+	}
+}
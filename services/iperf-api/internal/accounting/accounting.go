@@ -0,0 +1,129 @@
+// Package accounting attributes test usage to cost centers for internal
+// chargeback of lab bandwidth.
+package accounting
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Unassigned is the cost center reported for clients no assignment matches.
+const Unassigned = "unassigned"
+
+// ParseMatch validates an assignment match, which is a single IP address or a
+// CIDR range, and returns it as a network.
+func ParseMatch(match string) (*net.IPNet, error) {
+	match = strings.TrimSpace(match)
+	if _, network, err := net.ParseCIDR(match); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(match)
+	if ip == nil {
+		return nil, i18n.NewError("accounting.invalid_match", i18n.Params{"match": match})
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// CanonicalMatch validates match and returns its normalised form: a bare IP
+// address for single hosts, otherwise the network in CIDR notation.
+func CanonicalMatch(match string) (string, error) {
+	network, err := ParseMatch(match)
+	if err != nil {
+		return "", err
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return network.IP.String(), nil
+	}
+	return network.String(), nil
+}
+
+type rule struct {
+	network    *net.IPNet
+	prefixLen  int
+	costCenter string
+}
+
+// Resolver maps client IPs to cost centers. When several assignments match,
+// the most specific one wins.
+type Resolver struct {
+	rules []rule
+}
+
+// NewResolver builds a Resolver from stored assignments. Assignments whose
+// match does not parse are ignored.
+func NewResolver(assignments []models.CostCenterAssignment) *Resolver {
+	r := &Resolver{}
+	for _, a := range assignments {
+		network, err := ParseMatch(a.Match)
+		if err != nil {
+			continue
+		}
+		ones, _ := network.Mask.Size()
+		r.rules = append(r.rules, rule{network: network, prefixLen: ones, costCenter: a.CostCenter})
+	}
+	sort.SliceStable(r.rules, func(i, j int) bool {
+		return r.rules[i].prefixLen > r.rules[j].prefixLen
+	})
+	return r
+}
+
+// CostCenter returns the cost center for clientIP, or Unassigned.
+func (r *Resolver) CostCenter(clientIP string) string {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return Unassigned
+	}
+	for _, rl := range r.rules {
+		if rl.network.Contains(ip) {
+			return rl.costCenter
+		}
+	}
+	return Unassigned
+}
+
+// Summarise aggregates results per cost center, ordered by cost center name
+// with unassigned usage last.
+func Summarise(results []models.TestResult, resolver *Resolver) []models.AccountingEntry {
+	return SummariseStats(models.HourlyStats(results), resolver)
+}
+
+// SummariseStats aggregates hourly result statistics per cost center like
+// Summarise.
+func SummariseStats(stats []models.ResultStat, resolver *Resolver) []models.AccountingEntry {
+	entries := make(map[string]*models.AccountingEntry)
+	clients := make(map[string]map[string]struct{})
+
+	for _, st := range stats {
+		cc := resolver.CostCenter(st.ClientIP)
+		e, ok := entries[cc]
+		if !ok {
+			e = &models.AccountingEntry{CostCenter: cc}
+			entries[cc] = e
+			clients[cc] = make(map[string]struct{})
+		}
+		e.Tests += st.Tests
+		e.BytesTransferred += st.BytesTransferred
+		clients[cc][st.ClientIP] = struct{}{}
+	}
+
+	summary := make([]models.AccountingEntry, 0, len(entries))
+	for cc, e := range entries {
+		e.Clients = len(clients[cc])
+		summary = append(summary, *e)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		a, b := summary[i].CostCenter, summary[j].CostCenter
+		if (a == Unassigned) != (b == Unassigned) {
+			return b == Unassigned
+		}
+		return a < b
+	})
+	return summary
+}
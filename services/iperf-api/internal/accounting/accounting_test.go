@@ -0,0 +1,84 @@
+package accounting
+
+import (
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestCanonicalMatch(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.5", "10.0.0.5", false},
+		{" 10.0.0.5/24 ", "10.0.0.0/24", false},
+		{"10.0.0.5/32", "10.0.0.5", false},
+		{"2001:db8::1", "2001:db8::1", false},
+		{"2001:db8::/48", "2001:db8::/48", false},
+		{"lab-switch", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := CanonicalMatch(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CanonicalMatch(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CanonicalMatch(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolver_MostSpecificMatchWins(t *testing.T) {
+	r := NewResolver([]models.CostCenterAssignment{
+		{Match: "10.0.0.0/8", CostCenter: "corp"},
+		{Match: "10.1.0.0/16", CostCenter: "lab"},
+		{Match: "10.1.2.3", CostCenter: "perf-team"},
+	})
+
+	tests := map[string]string{
+		"10.1.2.3":    "perf-team",
+		"10.1.9.9":    "lab",
+		"10.200.0.1":  "corp",
+		"192.168.1.1": Unassigned,
+		"not-an-ip":   Unassigned,
+	}
+	for ip, want := range tests {
+		if got := r.CostCenter(ip); got != want {
+			t.Errorf("CostCenter(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	r := NewResolver([]models.CostCenterAssignment{
+		{Match: "10.1.0.0/16", CostCenter: "lab"},
+		{Match: "10.2.0.0/16", CostCenter: "backbone"},
+	})
+	results := []models.TestResult{
+		{ClientIP: "10.1.0.1", BytesTransferred: 100},
+		{ClientIP: "10.1.0.1", BytesTransferred: 50},
+		{ClientIP: "10.1.0.2", BytesTransferred: 25},
+		{ClientIP: "10.2.0.1", BytesTransferred: 10},
+		{ClientIP: "172.16.0.1", BytesTransferred: 5},
+	}
+
+	got := Summarise(results, r)
+	want := []models.AccountingEntry{
+		{CostCenter: "backbone", Tests: 1, BytesTransferred: 10, Clients: 1},
+		{CostCenter: "lab", Tests: 3, BytesTransferred: 175, Clients: 2},
+		{CostCenter: Unassigned, Tests: 1, BytesTransferred: 5, Clients: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Summarise returned %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
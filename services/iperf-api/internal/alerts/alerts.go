@@ -0,0 +1,143 @@
+// Package alerts checks saved test results against user-defined thresholds
+// and delivers webhook and email notifications.
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Validate checks that a rule is complete and its thresholds are sensible.
+func Validate(rule *models.AlertRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return i18n.NewError("alert.name_required", nil)
+	}
+	if rule.ClientIP != "" && net.ParseIP(rule.ClientIP) == nil {
+		return i18n.NewError("alert.invalid_client_ip", i18n.Params{"clientIp": rule.ClientIP})
+	}
+	if rule.MinAvgBandwidth == nil && rule.MaxPacketLoss == nil &&
+		rule.MaxJitter == nil && rule.MaxRetransmits == nil &&
+		rule.MaxRetransmitsPerGB == nil && rule.MinGoodputRatio == nil &&
+		rule.MaxJitterRatio == nil && rule.MinUtilization == nil {
+		return i18n.NewError("alert.threshold_required", nil)
+	}
+	if rule.MinAvgBandwidth != nil && *rule.MinAvgBandwidth <= 0 {
+		return i18n.NewError("alert.min_bandwidth_positive", nil)
+	}
+	if rule.MaxPacketLoss != nil && (*rule.MaxPacketLoss < 0 || *rule.MaxPacketLoss > 100) {
+		return i18n.NewError("alert.packet_loss_range", nil)
+	}
+	if rule.MaxJitter != nil && *rule.MaxJitter < 0 {
+		return i18n.NewError("alert.jitter_negative", nil)
+	}
+	if rule.MaxRetransmits != nil && *rule.MaxRetransmits < 0 {
+		return i18n.NewError("alert.retransmits_negative", nil)
+	}
+	if rule.MaxRetransmitsPerGB != nil && *rule.MaxRetransmitsPerGB < 0 {
+		return i18n.NewError("alert.retransmits_per_gb_negative", nil)
+	}
+	if rule.MinGoodputRatio != nil && (*rule.MinGoodputRatio <= 0 || *rule.MinGoodputRatio > 1) {
+		return i18n.NewError("alert.goodput_ratio_range", nil)
+	}
+	if rule.MaxJitterRatio != nil && *rule.MaxJitterRatio < 0 {
+		return i18n.NewError("alert.jitter_ratio_negative", nil)
+	}
+	if rule.MinUtilization != nil && (*rule.MinUtilization <= 0 || *rule.MinUtilization > 100) {
+		return i18n.NewError("alert.utilization_range", nil)
+	}
+	if rule.WebhookURL != "" {
+		u, err := url.Parse(rule.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return i18n.NewError("alert.invalid_webhook", i18n.Params{"url": rule.WebhookURL})
+		}
+	}
+	return nil
+}
+
+// Evaluate returns a description of each threshold the result breaches.
+// Thresholds for metrics the result does not report (e.g. packet loss on a
+// TCP test) are skipped.
+func Evaluate(rule *models.AlertRule, r *models.TestResult) []string {
+	var violations []string
+
+	if rule.MinAvgBandwidth != nil && r.AvgBandwidth < *rule.MinAvgBandwidth {
+		violations = append(violations, fmt.Sprintf(
+			"average bandwidth %.2f Mbps is below %.2f Mbps",
+			r.AvgBandwidth/1e6, *rule.MinAvgBandwidth/1e6))
+	}
+	if rule.MaxPacketLoss != nil && r.PacketLoss != nil && *r.PacketLoss > *rule.MaxPacketLoss {
+		violations = append(violations, fmt.Sprintf(
+			"packet loss %.2f%% exceeds %.2f%%", *r.PacketLoss, *rule.MaxPacketLoss))
+	}
+	if rule.MaxJitter != nil && r.Jitter != nil && *r.Jitter > *rule.MaxJitter {
+		violations = append(violations, fmt.Sprintf(
+			"jitter %.3f ms exceeds %.3f ms", *r.Jitter, *rule.MaxJitter))
+	}
+	if rule.MaxRetransmits != nil && r.Retransmits != nil && *r.Retransmits > *rule.MaxRetransmits {
+		violations = append(violations, fmt.Sprintf(
+			"%d retransmits exceeds %d", *r.Retransmits, *rule.MaxRetransmits))
+	}
+	if rule.MaxRetransmitsPerGB != nil && r.RetransmitsPerGB != nil && *r.RetransmitsPerGB > *rule.MaxRetransmitsPerGB {
+		violations = append(violations, fmt.Sprintf(
+			"%.1f retransmits per GB exceeds %.1f", *r.RetransmitsPerGB, *rule.MaxRetransmitsPerGB))
+	}
+	if rule.MinGoodputRatio != nil && r.GoodputRatio != nil && *r.GoodputRatio < *rule.MinGoodputRatio {
+		violations = append(violations, fmt.Sprintf(
+			"goodput %.1f%% of line rate is below %.1f%%", *r.GoodputRatio*100, *rule.MinGoodputRatio*100))
+	}
+	if rule.MaxJitterRatio != nil && r.JitterRatio != nil && *r.JitterRatio > *rule.MaxJitterRatio {
+		violations = append(violations, fmt.Sprintf(
+			"jitter %.4f ms per Mbps exceeds %.4f ms per Mbps", *r.JitterRatio, *rule.MaxJitterRatio))
+	}
+	if rule.MinUtilization != nil && r.Utilization != nil && *r.Utilization < *rule.MinUtilization {
+		violations = append(violations, fmt.Sprintf(
+			"utilization %.1f%% of the %.2f Mbps link is below %.1f%%", *r.Utilization, *r.LinkCapacity/1e6, *rule.MinUtilization))
+	}
+
+	return violations
+}
+
+// Notifier posts alerts to webhook URLs as JSON.
+type Notifier struct {
+	client *http.Client
+}
+
+// NewNotifier creates a Notifier whose requests time out after timeout.
+func NewNotifier(timeout time.Duration) *Notifier {
+	return &Notifier{client: &http.Client{Timeout: timeout}}
+}
+
+// Send posts the alert to webhookURL and fails on a non-2xx response.
+func (n *Notifier) Send(ctx context.Context, webhookURL string, alert models.Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func floatPtr(v float64) *float64 { return &v }
+func intPtr(v int) *int           { return &v }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.AlertRule
+		wantErr bool
+	}{
+		{"valid", models.AlertRule{Name: "slow", MinAvgBandwidth: floatPtr(500e6)}, false},
+		{"missing name", models.AlertRule{MinAvgBandwidth: floatPtr(500e6)}, true},
+		{"no thresholds", models.AlertRule{Name: "empty"}, true},
+		{"bad client ip", models.AlertRule{Name: "x", ClientIP: "lab", MaxJitter: floatPtr(1)}, true},
+		{"loss over 100", models.AlertRule{Name: "x", MaxPacketLoss: floatPtr(150)}, true},
+		{"negative retransmits", models.AlertRule{Name: "x", MaxRetransmits: intPtr(-1)}, true},
+		{"goodput ratio only", models.AlertRule{Name: "x", MinGoodputRatio: floatPtr(0.8)}, false},
+		{"goodput ratio over 1", models.AlertRule{Name: "x", MinGoodputRatio: floatPtr(1.5)}, true},
+		{"negative retransmits per GB", models.AlertRule{Name: "x", MaxRetransmitsPerGB: floatPtr(-1)}, true},
+		{"negative jitter ratio", models.AlertRule{Name: "x", MaxJitterRatio: floatPtr(-0.1)}, true},
+		{"utilization only", models.AlertRule{Name: "x", MinUtilization: floatPtr(80)}, false},
+		{"utilization over 100", models.AlertRule{Name: "x", MinUtilization: floatPtr(120)}, true},
+		{"bad webhook", models.AlertRule{Name: "x", MaxJitter: floatPtr(1), WebhookURL: "ftp://host"}, true},
+		{"https webhook", models.AlertRule{Name: "x", MaxJitter: floatPtr(1), WebhookURL: "https://hooks.example.com/a"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rule := &models.AlertRule{
+		Name:            "lab link",
+		MinAvgBandwidth: floatPtr(500e6),
+		MaxPacketLoss:   floatPtr(1),
+		MaxRetransmits:  intPtr(10),
+	}
+
+	healthy := &models.TestResult{AvgBandwidth: 900e6, PacketLoss: floatPtr(0.1), Retransmits: intPtr(2)}
+	if v := Evaluate(rule, healthy); len(v) != 0 {
+		t.Errorf("healthy result violations = %v, want none", v)
+	}
+
+	degraded := &models.TestResult{AvgBandwidth: 420e6, PacketLoss: floatPtr(2.5), Retransmits: intPtr(2)}
+	v := Evaluate(rule, degraded)
+	if len(v) != 2 {
+		t.Fatalf("degraded result violations = %v, want bandwidth and loss", v)
+	}
+	if v[0] != "average bandwidth 420.00 Mbps is below 500.00 Mbps" {
+		t.Errorf("violations[0] = %q", v[0])
+	}
+
+	// TCP results carry no packet loss, so the loss threshold is skipped
+	tcp := &models.TestResult{AvgBandwidth: 900e6}
+	if v := Evaluate(rule, tcp); len(v) != 0 {
+		t.Errorf("tcp result violations = %v, want none", v)
+	}
+}
+
+func TestEvaluate_DerivedMetrics(t *testing.T) {
+	rule := &models.AlertRule{
+		Name:                "efficiency",
+		MaxRetransmitsPerGB: floatPtr(100),
+		MinGoodputRatio:     floatPtr(0.8),
+		MaxJitterRatio:      floatPtr(0.01),
+	}
+
+	r := &models.TestResult{RetransmitsPerGB: floatPtr(250), GoodputRatio: floatPtr(0.5), JitterRatio: floatPtr(0.02)}
+	v := Evaluate(rule, r)
+	if len(v) != 3 {
+		t.Fatalf("violations = %v, want all three", v)
+	}
+	if v[1] != "goodput 50.0% of line rate is below 80.0%" {
+		t.Errorf("violations[1] = %q", v[1])
+	}
+
+	// Utilization is measured against the client's declared capacity
+	utilization := &models.AlertRule{Name: "capacity", MinUtilization: floatPtr(80)}
+	slow := &models.TestResult{LinkCapacity: floatPtr(1e9), Utilization: floatPtr(42)}
+	if v := Evaluate(utilization, slow); len(v) != 1 || v[0] != "utilization 42.0% of the 1000.00 Mbps link is below 80.0%" {
+		t.Errorf("utilization violations = %v", v)
+	}
+
+	// Results saved without a link speed have no goodput ratio to compare
+	if v := Evaluate(rule, &models.TestResult{}); len(v) != 0 {
+		t.Errorf("underived result violations = %v, want none", v)
+	}
+}
+
+func TestNotifierSend(t *testing.T) {
+	received := make(chan models.Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a models.Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		received <- a
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(time.Second)
+	alert := models.Alert{RuleName: "lab link", ClientIP: "10.0.0.1", Violations: []string{"slow"}}
+	if err := n.Send(context.Background(), srv.URL, alert); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := <-received; got.RuleName != "lab link" || len(got.Violations) != 1 {
+		t.Errorf("webhook received %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := n.Send(context.Background(), failing.URL, alert); err == nil {
+		t.Error("Send to failing webhook returned nil error")
+	}
+}
@@ -0,0 +1,301 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// EmailTLSMode selects how the SMTP connection is secured.
+type EmailTLSMode string
+
+const (
+	// EmailTLSStartTLS upgrades a plain connection and fails if the server
+	// does not offer STARTTLS
+	EmailTLSStartTLS EmailTLSMode = "starttls"
+	// EmailTLSImplicit connects over TLS from the start (usually port 465)
+	EmailTLSImplicit EmailTLSMode = "tls"
+	// EmailTLSNone sends in plain text
+	EmailTLSNone EmailTLSMode = "none"
+)
+
+// Default templates used when EmailConfig leaves them empty.
+const (
+	DefaultEmailSubject = `[iPerf] {{if .Alert}}Alert: {{.Alert.RuleName}} ({{.Alert.ClientIP}}){{else}}Server entered {{.Status.Status}} state{{end}}`
+	DefaultEmailBody    = `{{if .Alert}}Test {{.Alert.ResultID}} from {{.Alert.ClientIP}} breached alert rule "{{.Alert.RuleName}}":
+{{range .Alert.Violations}}
+  - {{.}}{{end}}
+{{else}}The iPerf server entered the {{.Status.Status}} state.{{if .Status.ErrorMsg}}
+
+Error: {{.Status.ErrorMsg}}{{end}}
+{{end}}
+Time: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+`
+)
+
+// EmailConfig configures the SMTP notification channel. An empty Host
+// disables it.
+type EmailConfig struct {
+	Host            string       `json:"host"`
+	Port            int          `json:"port"`
+	Username        string       `json:"username,omitempty"`
+	Password        string       `json:"password,omitempty"`
+	From            string       `json:"from"`
+	To              []string     `json:"to"`
+	TLS             EmailTLSMode `json:"tls"`
+	SubjectTemplate string       `json:"subjectTemplate,omitempty"`
+	BodyTemplate    string       `json:"bodyTemplate,omitempty"`
+}
+
+// Enabled reports whether emails should be sent.
+func (c EmailConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// EmailEvent is the data passed to the subject and body templates. Exactly
+// one of Alert and Status is set.
+type EmailEvent struct {
+	Timestamp time.Time
+	Alert     *models.Alert
+	Status    *models.ServerStatusPayload
+}
+
+// EmailNotifier sends templated emails over SMTP. Its configuration can be
+// replaced at runtime.
+type EmailNotifier struct {
+	mu      sync.RWMutex
+	cfg     EmailConfig
+	subject *template.Template
+	body    *template.Template
+	timeout time.Duration
+}
+
+// NewEmailNotifier creates a notifier; a config with no Host yields a
+// disabled notifier.
+func NewEmailNotifier(cfg EmailConfig, timeout time.Duration) (*EmailNotifier, error) {
+	n := &EmailNotifier{timeout: timeout}
+	if err := n.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Config returns the current configuration.
+func (n *EmailNotifier) Config() EmailConfig {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	cfg := n.cfg
+	cfg.To = append([]string(nil), n.cfg.To...)
+	return cfg
+}
+
+// SetConfig validates and applies a new configuration, filling defaults for
+// the port, TLS mode and templates.
+func (n *EmailNotifier) SetConfig(cfg EmailConfig) error {
+	if cfg.TLS == "" {
+		cfg.TLS = EmailTLSStartTLS
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+		if cfg.TLS == EmailTLSImplicit {
+			cfg.Port = 465
+		}
+	}
+	if cfg.SubjectTemplate == "" {
+		cfg.SubjectTemplate = DefaultEmailSubject
+	}
+	if cfg.BodyTemplate == "" {
+		cfg.BodyTemplate = DefaultEmailBody
+	}
+
+	subject, body, err := validateEmailConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg = cfg
+	n.subject = subject
+	n.body = body
+	return nil
+}
+
+// validateEmailConfig checks the config and parses its templates.
+func validateEmailConfig(cfg EmailConfig) (*template.Template, *template.Template, error) {
+	switch cfg.TLS {
+	case EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+	default:
+		return nil, nil, i18n.NewError("email.invalid_tls", i18n.Params{"mode": cfg.TLS})
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return nil, nil, i18n.NewError("email.invalid_port", i18n.Params{"port": cfg.Port})
+	}
+
+	subject, err := template.New("subject").Parse(cfg.SubjectTemplate)
+	if err != nil {
+		return nil, nil, i18n.NewError("email.invalid_subject_template", i18n.Params{"error": err})
+	}
+	body, err := template.New("body").Parse(cfg.BodyTemplate)
+	if err != nil {
+		return nil, nil, i18n.NewError("email.invalid_body_template", i18n.Params{"error": err})
+	}
+
+	if !cfg.Enabled() {
+		return subject, body, nil
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, nil, i18n.NewError("email.invalid_from", i18n.Params{"address": cfg.From})
+	}
+	if len(cfg.To) == 0 {
+		return nil, nil, i18n.NewError("email.recipient_required", nil)
+	}
+	for _, to := range cfg.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, nil, i18n.NewError("email.invalid_recipient", i18n.Params{"address": to})
+		}
+	}
+	return subject, body, nil
+}
+
+// Enabled reports whether the notifier has an SMTP host configured.
+func (n *EmailNotifier) Enabled() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.cfg.Enabled()
+}
+
+// Send renders the templates for ev and delivers the email. It does nothing
+// when the notifier is disabled.
+func (n *EmailNotifier) Send(ctx context.Context, ev EmailEvent) error {
+	n.mu.RLock()
+	cfg := n.cfg
+	subjectTmpl, bodyTmpl := n.subject, n.body
+	n.mu.RUnlock()
+
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	var subject, body bytes.Buffer
+	if err := subjectTmpl.Execute(&subject, ev); err != nil {
+		return fmt.Errorf("rendering subject: %w", err)
+	}
+	if err := bodyTmpl.Execute(&body, ev); err != nil {
+		return fmt.Errorf("rendering body: %w", err)
+	}
+
+	msg := buildMessage(cfg, strings.TrimSpace(subject.String()), body.String())
+	return n.deliver(ctx, cfg, msg)
+}
+
+// SendMessage delivers an email with subject and body as given rather than
+// rendered from the templates, for reports. It does nothing when the
+// notifier is disabled.
+func (n *EmailNotifier) SendMessage(ctx context.Context, subject, body string) error {
+	cfg := n.Config()
+	if !cfg.Enabled() {
+		return nil
+	}
+	return n.deliver(ctx, cfg, buildMessage(cfg, subject, body))
+}
+
+// buildMessage formats an RFC 5322 plain-text message.
+func buildMessage(cfg EmailConfig, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// deliver opens the SMTP session according to the TLS mode and sends msg.
+func (n *EmailNotifier) deliver(ctx context.Context, cfg EmailConfig, msg []byte) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if cfg.TLS == EmailTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.TLS == EmailTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		rcpt, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
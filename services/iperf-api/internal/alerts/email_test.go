@@ -0,0 +1,210 @@
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// fakeSMTP runs a minimal plain-text SMTP server and returns its address and
+// a channel receiving the DATA of each message.
+func fakeSMTP(t *testing.T, extensions ...string) (string, int, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, extensions, messages)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, messages
+}
+
+func serveSMTP(conn net.Conn, extensions []string, messages chan string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			for _, ext := range extensions {
+				reply("250-" + ext)
+			}
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmailNotifier_SendsAlert(t *testing.T) {
+	host, port, messages := fakeSMTP(t)
+	n, err := NewEmailNotifier(EmailConfig{
+		Host: host,
+		Port: port,
+		From: "iPerf <iperf@example.com>",
+		To:   []string{"noc@example.com"},
+		TLS:  EmailTLSNone,
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewEmailNotifier: %v", err)
+	}
+
+	alert := &models.Alert{RuleName: "lab link", ClientIP: "10.0.0.1", ResultID: "r1",
+		Violations: []string{"average bandwidth 420.00 Mbps is below 500.00 Mbps"}}
+	if err := n.Send(context.Background(), EmailEvent{Timestamp: time.Now(), Alert: alert}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msg := <-messages
+	for _, want := range []string{
+		"Subject: [iPerf] Alert: lab link (10.0.0.1)",
+		"To: noc@example.com",
+		"  - average bandwidth 420.00 Mbps is below 500.00 Mbps",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestEmailNotifier_CustomTemplateForServerError(t *testing.T) {
+	host, port, messages := fakeSMTP(t)
+	n, err := NewEmailNotifier(EmailConfig{
+		Host:            host,
+		Port:            port,
+		From:            "iperf@example.com",
+		To:              []string{"noc@example.com"},
+		TLS:             EmailTLSNone,
+		SubjectTemplate: `iperf {{.Status.Status}}`,
+		BodyTemplate:    `status={{.Status.Status}}`,
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewEmailNotifier: %v", err)
+	}
+
+	status := &models.ServerStatusPayload{Status: models.ServerStatusError}
+	if err := n.Send(context.Background(), EmailEvent{Timestamp: time.Now(), Status: status}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msg := <-messages
+	if !strings.Contains(msg, "Subject: iperf error") || !strings.Contains(msg, "status=error") {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+}
+
+func TestEmailNotifier_SendMessage(t *testing.T) {
+	host, port, messages := fakeSMTP(t)
+	n, err := NewEmailNotifier(EmailConfig{
+		Host: host,
+		Port: port,
+		From: "iperf@example.com",
+		To:   []string{"noc@example.com"},
+		TLS:  EmailTLSNone,
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewEmailNotifier: %v", err)
+	}
+
+	if err := n.SendMessage(context.Background(), "[iPerf] Weekly report", "12 tests\n"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	msg := <-messages
+	if !strings.Contains(msg, "Subject: [iPerf] Weekly report") || !strings.Contains(msg, "12 tests\r\n") {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+
+	disabled, _ := NewEmailNotifier(EmailConfig{}, time.Second)
+	if err := disabled.SendMessage(context.Background(), "s", "b"); err != nil {
+		t.Errorf("disabled SendMessage: %v", err)
+	}
+}
+
+func TestEmailNotifier_RequiresStartTLS(t *testing.T) {
+	host, port, _ := fakeSMTP(t)
+	n, err := NewEmailNotifier(EmailConfig{
+		Host: host,
+		Port: port,
+		From: "iperf@example.com",
+		To:   []string{"noc@example.com"},
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewEmailNotifier: %v", err)
+	}
+
+	err = n.Send(context.Background(), EmailEvent{Timestamp: time.Now(), Alert: &models.Alert{}})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Send err = %v, want STARTTLS error", err)
+	}
+}
+
+func TestEmailConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     EmailConfig
+		wantErr bool
+	}{
+		{"disabled", EmailConfig{}, false},
+		{"no recipients", EmailConfig{Host: "smtp", From: "a@example.com"}, true},
+		{"bad from", EmailConfig{Host: "smtp", From: "nobody", To: []string{"b@example.com"}}, true},
+		{"bad tls", EmailConfig{Host: "smtp", From: "a@example.com", To: []string{"b@example.com"}, TLS: "ssl"}, true},
+		{"bad template", EmailConfig{SubjectTemplate: "{{.Alert"}, true},
+		{"valid", EmailConfig{Host: "smtp", From: "a@example.com", To: []string{"b@example.com"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEmailNotifier(tt.cfg, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	n, _ := NewEmailNotifier(EmailConfig{Host: "smtp", From: "a@example.com", To: []string{"b@example.com"}, TLS: EmailTLSImplicit}, time.Second)
+	if got := n.Config().Port; got != 465 {
+		t.Errorf("implicit TLS default port = %d, want 465", got)
+	}
+}
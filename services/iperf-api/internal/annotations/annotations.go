@@ -0,0 +1,63 @@
+// Package annotations derives timeline annotations from configuration
+// versions so shifts in results can be correlated with config changes.
+package annotations
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Diff returns the fields that differ between two configurations. Only fields
+// that can affect measurements are compared; session settings such as one-off
+// mode and idle timeout are ignored.
+func Diff(prev, next models.ServerConfig) []models.ConfigChange {
+	var changes []models.ConfigChange
+	add := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, models.ConfigChange{Field: field, From: from, To: to})
+		}
+	}
+
+	add("port", strconv.Itoa(prev.Port), strconv.Itoa(next.Port))
+	add("bindAddress", prev.BindAddress, next.BindAddress)
+	add("addressFamily", string(prev.AddressFamily), string(next.AddressFamily))
+	add("protocol", string(prev.Protocol), string(next.Protocol))
+	add("version", string(version(prev)), string(version(next)))
+	add("allowlist", strings.Join(prev.Allowlist, ","), strings.Join(next.Allowlist, ","))
+	add("portCount", strconv.Itoa(len(prev.Ports())), strconv.Itoa(len(next.Ports())))
+	add("intervalSeconds", strconv.FormatFloat(prev.ReportInterval(), 'f', -1, 64), strconv.FormatFloat(next.ReportInterval(), 'f', -1, 64))
+
+	return changes
+}
+
+// version returns the configured iperf version, treating empty as iperf3.
+func version(cfg models.ServerConfig) models.IperfVersion {
+	if cfg.Version == "" {
+		return models.IperfVersion3
+	}
+	return cfg.Version
+}
+
+// FromVersions returns a config change annotation for each version applied
+// within [from, to], oldest first. versions must be in the order they were
+// applied and include the version preceding from, if any, so the first
+// change in the period can be described.
+func FromVersions(versions []models.ConfigVersion, from, to time.Time) []models.Annotation {
+	annotations := []models.Annotation{}
+	for i := 1; i < len(versions); i++ {
+		v := versions[i]
+		if v.AppliedAt.Before(from) || v.AppliedAt.After(to) {
+			continue
+		}
+		annotations = append(annotations, models.Annotation{
+			Timestamp: v.AppliedAt,
+			Kind:      models.AnnotationKindConfigChange,
+			Version:   v.ID,
+			Changes:   Diff(versions[i-1].Config, v.Config),
+		})
+	}
+	return annotations
+}
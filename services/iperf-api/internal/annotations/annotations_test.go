@@ -0,0 +1,73 @@
+package annotations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestDiff(t *testing.T) {
+	prev := models.DefaultServerConfig()
+	prev.Version = ""
+
+	next := prev
+	next.Port = 5301
+	next.Protocol = models.ProtocolUDP
+	next.Allowlist = []string{"10.0.0.0/8", "192.168.1.5"}
+	next.OneOff = true
+	next.IdleTimeout = 60
+	next.Version = models.IperfVersion3
+	next.PortCount = 10
+	next.IntervalSeconds = 0.5
+
+	got := Diff(prev, next)
+	want := []models.ConfigChange{
+		{Field: "port", From: "5201", To: "5301"},
+		{Field: "protocol", From: "tcp", To: "udp"},
+		{Field: "allowlist", From: "", To: "10.0.0.0/8,192.168.1.5"},
+		{Field: "portCount", From: "1", To: "10"},
+		{Field: "intervalSeconds", From: "1", To: "0.5"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Diff = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if changes := Diff(prev, prev); len(changes) != 0 {
+		t.Errorf("Diff of identical configs = %+v", changes)
+	}
+}
+
+func TestFromVersions(t *testing.T) {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	cfg := models.DefaultServerConfig()
+	versions := make([]models.ConfigVersion, 3)
+	for i := range versions {
+		cfg.Port = 5201 + i
+		versions[i] = models.ConfigVersion{ID: int64(i + 1), AppliedAt: base.Add(time.Duration(i) * 24 * time.Hour), Config: cfg}
+	}
+
+	// The first version has nothing to compare against
+	all := FromVersions(versions, base, base.Add(72*time.Hour))
+	if len(all) != 2 || all[0].Version != 2 || all[1].Version != 3 {
+		t.Fatalf("annotations = %+v, want versions 2 and 3", all)
+	}
+
+	// A period starting after version 2 still describes version 3 against it
+	late := FromVersions(versions, base.Add(36*time.Hour), base.Add(72*time.Hour))
+	if len(late) != 1 || late[0].Kind != models.AnnotationKindConfigChange {
+		t.Fatalf("annotations = %+v, want one config change", late)
+	}
+	if c := late[0].Changes; len(c) != 1 || c[0].From != "5202" || c[0].To != "5203" {
+		t.Errorf("changes = %+v, want port 5202 -> 5203", c)
+	}
+
+	if none := FromVersions(nil, base, base.Add(time.Hour)); none == nil || len(none) != 0 {
+		t.Errorf("FromVersions(nil) = %#v, want empty slice", none)
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/accounting"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultAccountingPeriod is the window reported when no start is given.
+const defaultAccountingPeriod = 30 * 24 * time.Hour
+
+// handleListAssignments returns all cost center assignments.
+func (s *Server) handleListAssignments(w http.ResponseWriter, r *http.Request) {
+	assignments, err := s.storage.ListCostCenterAssignments(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.assignments_list_failed", i18n.Params{"error": err})
+		return
+	}
+
+	if assignments == nil {
+		assignments = []models.CostCenterAssignment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"assignments": assignments,
+	})
+}
+
+// handleSaveAssignment creates or updates the cost center for a client IP or
+// CIDR range.
+func (s *Server) handleSaveAssignment(w http.ResponseWriter, r *http.Request) {
+	var a models.CostCenterAssignment
+	if err := decodeJSON(w, r, &a); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	match, err := accounting.CanonicalMatch(a.Match)
+	if err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	a.Match = match
+	a.CostCenter = strings.TrimSpace(a.CostCenter)
+	if a.CostCenter == "" {
+		s.writeError(w, r, http.StatusBadRequest, "error.cost_center_required", nil)
+		return
+	}
+	a.ID = 0
+	a.CreatedAt = time.Time{}
+
+	if err := s.storage.SaveCostCenterAssignment(r.Context(), &a); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.assignment_save_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionCostCenterSave, map[string]interface{}{"assignment": a})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// handleDeleteAssignment removes a cost center assignment.
+func (s *Server) handleDeleteAssignment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "error.assignment_invalid_id", nil)
+		return
+	}
+
+	if err := s.storage.DeleteCostCenterAssignment(r.Context(), id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.assignment_not_found", nil)
+			return
+		}
+		s.writeError(w, r, http.StatusInternalServerError, "error.assignment_delete_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionCostCenterDelete, map[string]interface{}{"id": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parsePeriodTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date.
+func parsePeriodTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parsePeriod reads the from and to query parameters, defaulting to the 30
+// days up to now. It writes an error response and returns false if they are
+// invalid.
+func (s *Server) parsePeriod(w http.ResponseWriter, r *http.Request) (from, to time.Time, ok bool) {
+	to = time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := parsePeriodTime(v)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.invalid_to", i18n.Params{"error": err})
+			return from, to, false
+		}
+		to = parsed
+	}
+
+	from = to.Add(-defaultAccountingPeriod)
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := parsePeriodTime(v)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.invalid_from", i18n.Params{"error": err})
+			return from, to, false
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		s.writeError(w, r, http.StatusBadRequest, "error.period_order", nil)
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// handleGetAccounting aggregates bytes transferred and test counts per cost
+// center over a period, as JSON or CSV. The JSON form includes config change
+// annotations for the period.
+func (s *Server) handleGetAccounting(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := s.parsePeriod(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := s.storage.ListCostCenterAssignments(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.assignments_list_failed", i18n.Params{"error": err})
+		return
+	}
+
+	stats, err := s.storage.GetResultStatsBetween(r.Context(), from, to)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	entries := accounting.SummariseStats(stats, accounting.NewResolver(assignments))
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=iperf_accounting.csv")
+
+		writer := csv.NewWriter(w)
+		defer writer.Flush()
+
+		writer.Write([]string{"cost_center", "from", "to", "tests", "bytes_transferred", "clients"})
+		for _, e := range entries {
+			writer.Write([]string{
+				e.CostCenter,
+				from.Format(time.RFC3339),
+				to.Format(time.RFC3339),
+				strconv.Itoa(e.Tests),
+				strconv.FormatInt(e.BytesTransferred, 10),
+				strconv.Itoa(e.Clients),
+			})
+		}
+
+	default:
+		list, err := s.annotationsBetween(r.Context(), from, to)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.annotations_failed", i18n.Params{"error": err})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"from":        from,
+			"to":          to,
+			"entries":     entries,
+			"annotations": list,
+		})
+	}
+}
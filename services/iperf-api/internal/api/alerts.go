@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/alerts"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookTimeout bounds each alert webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+// evaluateAlerts checks a saved result against the enabled rules for its
+// client. Each breach is broadcast, emailed and sent to the rule's webhook.
+func (s *Server) evaluateAlerts(result *models.TestResult) {
+	if result.Status != models.TestStatusCompleted {
+		return
+	}
+
+	rules, err := s.storage.GetEnabledAlertRulesForClient(context.Background(), result.ClientIP)
+	if err != nil {
+		log.Printf("Error loading alert rules: %v", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		violations := alerts.Evaluate(rule, result)
+		if len(violations) == 0 {
+			continue
+		}
+
+		alert := models.Alert{
+			Timestamp:  time.Now(),
+			RuleID:     rule.ID,
+			RuleName:   rule.Name,
+			ResultID:   result.ID,
+			ClientIP:   result.ClientIP,
+			Violations: violations,
+		}
+		s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeAlert, Payload: alert})
+		s.notifyEmail(alerts.EmailEvent{Timestamp: alert.Timestamp, Alert: &alert})
+
+		if rule.WebhookURL != "" {
+			go s.sendWebhook(rule.WebhookURL, alert)
+		}
+	}
+}
+
+// sendWebhook delivers an alert to a webhook, logging failures.
+func (s *Server) sendWebhook(url string, alert models.Alert) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	if err := s.notifier.Send(ctx, url, alert); err != nil {
+		log.Printf("Alert webhook for rule %q failed: %v", alert.RuleName, err)
+	}
+}
+
+// alertRuleID parses the {id} URL parameter.
+func alertRuleID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+}
+
+// writeAlertRuleError maps storage errors to HTTP responses; key names the
+// message for unexpected failures.
+func (s *Server) writeAlertRuleError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusNotFound, "error.alert_not_found", nil)
+		return
+	}
+	s.writeError(w, r, http.StatusInternalServerError, key, i18n.Params{"error": err})
+}
+
+// handleListAlertRules returns all alert rules.
+func (s *Server) handleListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.storage.ListAlertRules(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.alert_list_failed", i18n.Params{"error": err})
+		return
+	}
+
+	if rules == nil {
+		rules = []models.AlertRule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rules": rules,
+	})
+}
+
+// handleCreateAlertRule creates an alert rule. Rules are enabled unless the
+// body sets "enabled": false.
+func (s *Server) handleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	rule := models.AlertRule{Enabled: true}
+	if err := decodeJSON(w, r, &rule); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	if err := alerts.Validate(&rule); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	rule.ID = 0
+	rule.CreatedAt = time.Time{}
+	if err := s.storage.CreateAlertRule(r.Context(), &rule); err != nil {
+		s.writeAlertRuleError(w, r, "error.alert_create_failed", err)
+		return
+	}
+	s.audit(r, models.AuditActionAlertRuleCreate, map[string]interface{}{"rule": rule})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// handleGetAlertRule returns a single alert rule.
+func (s *Server) handleGetAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := alertRuleID(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "error.alert_invalid_id", nil)
+		return
+	}
+
+	rule, err := s.storage.GetAlertRule(r.Context(), id)
+	if err != nil {
+		s.writeAlertRuleError(w, r, "error.alert_get_failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// handleUpdateAlertRule replaces an alert rule.
+func (s *Server) handleUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := alertRuleID(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "error.alert_invalid_id", nil)
+		return
+	}
+
+	rule := models.AlertRule{Enabled: true}
+	if err := decodeJSON(w, r, &rule); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	if err := alerts.Validate(&rule); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	rule.ID = id
+	if err := s.storage.UpdateAlertRule(r.Context(), &rule); err != nil {
+		s.writeAlertRuleError(w, r, "error.alert_update_failed", err)
+		return
+	}
+	s.audit(r, models.AuditActionAlertRuleUpdate, map[string]interface{}{"rule": rule})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// handleDeleteAlertRule removes an alert rule.
+func (s *Server) handleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := alertRuleID(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "error.alert_invalid_id", nil)
+		return
+	}
+
+	if err := s.storage.DeleteAlertRule(r.Context(), id); err != nil {
+		s.writeAlertRuleError(w, r, "error.alert_delete_failed", err)
+		return
+	}
+	s.audit(r, models.AuditActionAlertRuleDelete, map[string]interface{}{"id": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
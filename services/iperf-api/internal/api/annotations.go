@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/annotations"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// recordConfigVersion stores a new config version when the server starts
+// with a configuration whose measured fields differ from the last one.
+func (s *Server) recordConfigVersion(msg models.WSMessage) {
+	status, ok := msg.Payload.(models.ServerStatusPayload)
+	if !ok || status.Status != models.ServerStatusRunning || status.Config == nil {
+		return
+	}
+
+	latest, err := s.storage.LatestConfigVersion(context.Background())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to load config version: %v", err)
+		return
+	}
+	if latest != nil && len(annotations.Diff(latest.Config, *status.Config)) == 0 {
+		return
+	}
+
+	v := &models.ConfigVersion{Config: *status.Config}
+	if err := s.storage.SaveConfigVersion(context.Background(), v); err != nil {
+		log.Printf("Failed to save config version: %v", err)
+	}
+}
+
+// annotationsBetween returns the config change annotations for a period.
+func (s *Server) annotationsBetween(ctx context.Context, from, to time.Time) ([]models.Annotation, error) {
+	versions, err := s.storage.GetConfigVersionsUntil(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	return annotations.FromVersions(versions, from, to), nil
+}
+
+// handleGetAnnotations returns the config change annotations for a period,
+// defaulting to the last 30 days.
+func (s *Server) handleGetAnnotations(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := s.parsePeriod(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := s.annotationsBetween(r.Context(), from, to)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.annotations_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":        from,
+		"to":          to,
+		"annotations": list,
+	})
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/auth"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// apiKeyID identifies the API key presented with a request without storing
+// the key: a prefix of its SHA-256 hash. It returns "" when there is none.
+func apiKeyID(r *http.Request) string {
+	key := auth.KeyFromRequest(r)
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// remoteIP returns the client address of a request without its port.
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// audit records a control-plane action performed by the request's principal.
+// Failures are logged; they never fail the action itself.
+func (s *Server) audit(r *http.Request, action models.AuditAction, params map[string]interface{}) {
+	s.saveAudit(&models.AuditEntry{
+		Action:     action,
+		APIKey:     apiKeyID(r),
+		RemoteIP:   remoteIP(r),
+		Parameters: params,
+	})
+}
+
+// saveAudit stores an audit entry, logging failures. Entries for actions
+// the server takes by itself have no API key or remote IP. The entry is
+// saved even if the client has gone away, since the action already happened.
+func (s *Server) saveAudit(entry *models.AuditEntry) {
+	if err := s.storage.SaveAuditEntry(context.Background(), entry); err != nil {
+		log.Printf("Failed to record audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// handleGetAudit returns audit log entries, newest first, optionally filtered
+// by action, principal (API key hash or IP) and period.
+func (s *Server) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.AuditFilter{
+		Action:    models.AuditAction(q.Get("action")),
+		Principal: q.Get("principal"),
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := parsePeriodTime(v)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.invalid_from", i18n.Params{"error": err})
+			return
+		}
+		filter.From = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := parsePeriodTime(v)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.invalid_to", i18n.Params{"error": err})
+			return
+		}
+		filter.To = to
+	}
+
+	// Default and max limit
+	limit := 50
+	if parsed, err := strconv.Atoi(q.Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset := 0
+	if parsed, err := strconv.Atoi(q.Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+
+	entries, err := s.storage.QueryAuditLog(r.Context(), filter, limit, offset)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.audit_failed", i18n.Params{"error": err})
+		return
+	}
+	total, err := s.storage.CountAuditLog(r.Context(), filter)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.audit_failed", i18n.Params{"error": err})
+		return
+	}
+
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
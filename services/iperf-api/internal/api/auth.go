@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/auth"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+)
+
+// WithAPIKeys requires an API key on every route except the health check,
+// and limits each key to the routes its role allows.
+func WithAPIKeys(keys *auth.Keys) Option {
+	return func(s *Server) {
+		s.apiKeys = keys
+	}
+}
+
+// authenticate resolves the request's API key to a role. Without configured
+// keys every request is an operator.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := auth.RoleOperator
+		if s.apiKeys.Enabled() {
+			var ok bool
+			role, ok = s.apiKeys.Lookup(auth.KeyFromRequest(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				s.writeError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithRole(r.Context(), role)))
+	})
+}
+
+// require rejects requests whose role does not grant the required access.
+func (s *Server) require(required auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := auth.RoleFrom(r.Context())
+			if !role.Allows(required) {
+				s.writeError(w, r, http.StatusForbidden, "error.forbidden", i18n.Params{"role": required})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handleGetAuth returns the caller's role, so clients can hide actions they
+// may not perform.
+func (s *Server) handleGetAuth(w http.ResponseWriter, r *http.Request) {
+	role, _ := auth.RoleFrom(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": s.apiKeys.Enabled(),
+		"role":    role,
+	})
+}
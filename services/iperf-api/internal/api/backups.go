@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/backup"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// WithBackups enables /api/admin/backups. The scheduler takes backups on
+// its own.
+func WithBackups(b *backup.Scheduler) Option {
+	return func(s *Server) {
+		s.backups = b
+	}
+}
+
+// handleGetBackups returns the scheduler's status and the stored backups.
+// An unreachable target is reported in error rather than failing the
+// request, so the status is still shown.
+func (s *Server) handleGetBackups(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"status": s.backups.Status()}
+	if backups, err := s.backups.List(r.Context()); err != nil {
+		resp["error"] = err.Error()
+	} else {
+		resp["backups"] = backups
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCreateBackup takes a backup now, responding once it is stored.
+func (s *Server) handleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	obj, err := s.backups.Backup(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.backup_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionBackupCreate, map[string]interface{}{"name": obj.Name, "size": obj.Size})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(obj)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+)
+
+// MaxBodySize bounds a JSON request body, in bytes. History imports have
+// their own limit, MaxImportSize.
+const MaxBodySize = 1 << 20
+
+// errTrailingData rejects a body holding more than one JSON value
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// limitBody caps r's body at MaxBodySize; reading past it fails with an
+// *http.MaxBytesError.
+func limitBody(w http.ResponseWriter, r *http.Request) io.Reader {
+	return http.MaxBytesReader(w, r.Body, MaxBodySize)
+}
+
+// decodeJSON reads r's body, at most MaxBodySize bytes, into v. A field v
+// does not have is rejected rather than ignored, so a misspelt field fails
+// instead of silently keeping its default.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(limitBody(w, r))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// Reading on may also fail, as when the body is too large past the value
+	_, err := dec.Token()
+	switch {
+	case err == nil:
+		return errTrailingData
+	case errors.Is(err, io.EOF):
+		return nil
+	}
+	return err
+}
+
+// writeBodyError reports a request body that could not be decoded: 413 when
+// it is too large, otherwise 400.
+func (s *Server) writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, "error.body_too_large", i18n.Params{"max": MaxBodySize >> 10})
+		return
+	}
+	s.writeError(w, r, http.StatusBadRequest, "error.invalid_body", i18n.Params{"error": err})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/accounting"
+	"github.com/Tom-Oram/fak/backend/internal/alerts"
+	"github.com/Tom-Oram/fak/backend/internal/capacity"
+	"github.com/Tom-Oram/fak/backend/internal/drift"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// handleExportConfigBundle returns the instance's alert rules, cost center
+// assignments, profiles, link capacities and desired state as one
+// downloadable document.
+func (s *Server) handleExportConfigBundle(w http.ResponseWriter, r *http.Request) {
+	bundle := models.ConfigBundle{
+		Version:    models.ConfigBundleVersion,
+		ExportedAt: time.Now().UTC(),
+	}
+
+	rules, err := s.storage.ListAlertRules(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.alert_list_failed", i18n.Params{"error": err})
+		return
+	}
+	assignments, err := s.storage.ListCostCenterAssignments(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.assignments_list_failed", i18n.Params{"error": err})
+		return
+	}
+	profiles, err := s.storage.ListProfiles(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.profile_list_failed", i18n.Params{"error": err})
+		return
+	}
+	capacities, err := s.storage.ListLinkCapacities(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.capacities_list_failed", i18n.Params{"error": err})
+		return
+	}
+	desired, err := s.storage.LatestDesiredState(r.Context())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusInternalServerError, "error.desired_state_failed", i18n.Params{"error": err})
+		return
+	}
+
+	bundle.AlertRules = rules
+	if bundle.AlertRules == nil {
+		bundle.AlertRules = []models.AlertRule{}
+	}
+	bundle.CostCenterAssignments = assignments
+	if bundle.CostCenterAssignments == nil {
+		bundle.CostCenterAssignments = []models.CostCenterAssignment{}
+	}
+	bundle.Profiles = profiles
+	if bundle.Profiles == nil {
+		bundle.Profiles = []models.Profile{}
+	}
+	bundle.LinkCapacities = capacities
+	if bundle.LinkCapacities == nil {
+		bundle.LinkCapacities = []models.LinkCapacity{}
+	}
+	bundle.DesiredState = desired
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=iperf_config_bundle.json")
+	json.NewEncoder(w).Encode(bundle)
+}
+
+// handleImportConfigBundle replaces the alert rules, cost center assignments
+// and, when the bundle has them, profiles and link capacities with those of
+// an exported bundle
+// and declares its desired state. Every entry is validated before anything is changed. The response
+// is the bundle with the IDs and version the entries were stored under.
+func (s *Server) handleImportConfigBundle(w http.ResponseWriter, r *http.Request) {
+	var bundle models.ConfigBundle
+	if err := decodeJSON(w, r, &bundle); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if bundle.Version != models.ConfigBundleVersion {
+		s.writeError(w, r, http.StatusBadRequest, "error.bundle_version", i18n.Params{
+			"version":  bundle.Version,
+			"expected": models.ConfigBundleVersion,
+		})
+		return
+	}
+
+	for i := range bundle.AlertRules {
+		rule := &bundle.AlertRules[i]
+		if err := alerts.Validate(rule); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.bundle_invalid_rule", i18n.Params{"index": i + 1, "error": s.localize(r, err)})
+			return
+		}
+		rule.ID = 0
+		rule.CreatedAt = time.Time{}
+	}
+
+	for i := range bundle.CostCenterAssignments {
+		a := &bundle.CostCenterAssignments[i]
+		match, err := accounting.CanonicalMatch(a.Match)
+		if err == nil && strings.TrimSpace(a.CostCenter) == "" {
+			err = i18n.NewError("error.cost_center_required", nil)
+		}
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.bundle_invalid_assignment", i18n.Params{"index": i + 1, "error": s.localize(r, err)})
+			return
+		}
+		a.ID = 0
+		a.Match = match
+		a.CostCenter = strings.TrimSpace(a.CostCenter)
+		a.CreatedAt = time.Time{}
+	}
+
+	for i := range bundle.Profiles {
+		p := &bundle.Profiles[i]
+		p.Description = strings.TrimSpace(p.Description)
+		if err := validateProfile(p); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.bundle_invalid_profile", i18n.Params{"index": i + 1, "error": s.localize(r, err)})
+			return
+		}
+	}
+
+	for i := range bundle.LinkCapacities {
+		c := &bundle.LinkCapacities[i]
+		if err := capacity.Validate(c); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.bundle_invalid_capacity", i18n.Params{"index": i + 1, "error": s.localize(r, err)})
+			return
+		}
+		c.ID = 0
+		c.CreatedAt = time.Time{}
+	}
+
+	if d := bundle.DesiredState; d != nil {
+		if err := drift.Validate(*d); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.bundle_invalid_desired_state", i18n.Params{"error": s.localize(r, err)})
+			return
+		}
+		d.Version = 0
+		d.DeclaredAt = time.Time{}
+	}
+
+	if err := s.storage.ReplaceConfiguration(r.Context(), &bundle); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.bundle_import_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionConfigImport, map[string]interface{}{
+		"alertRules":            len(bundle.AlertRules),
+		"costCenterAssignments": len(bundle.CostCenterAssignments),
+		"profiles":              len(bundle.Profiles),
+		"linkCapacities":        len(bundle.LinkCapacities),
+		"desiredState":          bundle.DesiredState,
+	})
+	if bundle.DesiredState != nil {
+		s.drift.Trigger()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bundle)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/capacity"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// handleListCapacities returns the declared link capacities.
+func (s *Server) handleListCapacities(w http.ResponseWriter, r *http.Request) {
+	capacities, err := s.storage.ListLinkCapacities(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.capacities_list_failed", i18n.Params{"error": err})
+		return
+	}
+
+	if capacities == nil {
+		capacities = []models.LinkCapacity{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"capacities": capacities,
+	})
+}
+
+// handleSaveCapacity declares or updates the link capacity of a client IP
+// or CIDR range. Results saved from then on are measured against it.
+func (s *Server) handleSaveCapacity(w http.ResponseWriter, r *http.Request) {
+	var c models.LinkCapacity
+	if err := decodeJSON(w, r, &c); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if err := capacity.Validate(&c); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	c.ID = 0
+	c.CreatedAt = time.Time{}
+
+	if err := s.storage.SaveLinkCapacity(r.Context(), &c); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.capacity_save_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionCapacitySave, map[string]interface{}{"capacity": c})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleDeleteCapacity removes a declared link capacity.
+func (s *Server) handleDeleteCapacity(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "error.capacity_invalid_id", nil)
+		return
+	}
+
+	if err := s.storage.DeleteLinkCapacity(r.Context(), id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.capacity_not_found", nil)
+			return
+		}
+		s.writeError(w, r, http.StatusInternalServerError, "error.capacity_delete_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionCapacityDelete, map[string]interface{}{"id": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyCapacity sets the result's utilization of its client's declared link
+// capacity. A result whose capacities cannot be read is saved without.
+func (s *Server) applyCapacity(ctx context.Context, result *models.TestResult) {
+	capacities, err := s.storage.ListLinkCapacities(ctx)
+	if err != nil {
+		log.Printf("Failed to read link capacities for result %s: %v", result.ID, err)
+		return
+	}
+	capacity.NewResolver(capacities).Apply(result)
+}
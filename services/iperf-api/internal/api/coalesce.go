@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// WithUpdateRate coalesces bandwidth updates so each WebSocket client gets
+// at most perSecond of them per test session; 0 sends every update.
+func WithUpdateRate(perSecond int) Option {
+	return func(s *Server) {
+		if perSecond > 0 {
+			s.hub.updateInterval = time.Second / time.Duration(perSecond)
+		}
+	}
+}
+
+// coalescer merges a client's bandwidth updates between flushes, keeping one
+// per test session and port in arrival order. Omitted intervals, whose times
+// restart when the omit period ends, are kept apart from the rest.
+type coalescer struct {
+	order   []string
+	updates map[string]*models.BandwidthUpdate
+}
+
+// add merges an update into the pending one for its session and port.
+func (c *coalescer) add(u *models.BandwidthUpdate) {
+	key := fmt.Sprintf("%s/%d/%t", u.SessionID, u.ServerPort, u.Omitted)
+	if c.updates == nil {
+		c.updates = make(map[string]*models.BandwidthUpdate)
+	}
+	pending, ok := c.updates[key]
+	if !ok {
+		merged := *u
+		c.updates[key] = &merged
+		c.order = append(c.order, key)
+		return
+	}
+	mergeUpdate(pending, u)
+}
+
+// take returns the pending updates and clears them.
+func (c *coalescer) take() []*models.BandwidthUpdate {
+	if len(c.order) == 0 {
+		return nil
+	}
+	updates := make([]*models.BandwidthUpdate, 0, len(c.order))
+	for _, key := range c.order {
+		updates = append(updates, c.updates[key])
+	}
+	c.reset()
+	return updates
+}
+
+// reset discards the pending updates.
+func (c *coalescer) reset() {
+	c.order = nil
+	c.updates = nil
+}
+
+// mergeUpdate folds u into into: bytes are summed and the interval widened
+// to cover both, so parallel streams add up and consecutive intervals
+// average. The bitrate is recomputed over the merged interval; the smoothed
+// bitrate, already an average, is the latest.
+func mergeUpdate(into, u *models.BandwidthUpdate) {
+	if u.IntervalStart < into.IntervalStart {
+		into.IntervalStart = u.IntervalStart
+	}
+	if u.IntervalEnd > into.IntervalEnd {
+		into.IntervalEnd = u.IntervalEnd
+	}
+	if u.Timestamp.After(into.Timestamp) {
+		into.Timestamp = u.Timestamp
+	}
+	if u.SmoothedBitsPerSecond != nil {
+		into.SmoothedBitsPerSecond = u.SmoothedBitsPerSecond
+	}
+	into.Bytes += u.Bytes
+	if span := into.IntervalEnd - into.IntervalStart; span > 0 {
+		into.BitsPerSecond = float64(into.Bytes) * 8 / span
+	} else {
+		into.BitsPerSecond += u.BitsPerSecond
+	}
+}
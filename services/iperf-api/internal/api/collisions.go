@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/collisions"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// recordCollision counts and stores a connection the server turned away
+// while busy. Failures are logged; the collision has already been broadcast.
+func (s *Server) recordCollision(msg models.WSMessage) {
+	c, ok := msg.Payload.(*models.Collision)
+	if !ok {
+		return
+	}
+	s.collisions.Add(1)
+	if err := s.storage.SaveCollision(context.Background(), c); err != nil {
+		log.Printf("Failed to record collision on port %d: %v", c.ServerPort, err)
+	}
+}
+
+// handleGetCollisions reports tests and collisions over a period, overall,
+// per hour or day (?bucket=hour|day, default day) and per port.
+func (s *Server) handleGetCollisions(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := s.parsePeriod(w, r)
+	if !ok {
+		return
+	}
+	bucket, err := collisions.ParseBucket(r.URL.Query().Get("bucket"))
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "error.invalid_bucket", i18n.Params{"error": err})
+		return
+	}
+
+	list, err := s.storage.GetCollisionsBetween(r.Context(), from, to)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.collisions_failed", i18n.Params{"error": err})
+		return
+	}
+	stats, err := s.storage.GetResultStatsBetween(r.Context(), from, to)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collisions.SummariseStats(list, stats, from, to, bucket))
+}
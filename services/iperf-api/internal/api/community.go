@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/community"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+)
+
+// WithCommunity enables /api/community, comparing this instance's
+// aggregates with the community's. The reporter submits them on its own.
+func WithCommunity(r *community.Reporter) Option {
+	return func(s *Server) {
+		s.community = r
+	}
+}
+
+// handleGetCommunity returns the aggregates this instance shares alongside
+// the community's for the same region and ISP. An unreachable community
+// endpoint is reported in error rather than failing the request.
+func (s *Server) handleGetCommunity(w http.ResponseWriter, r *http.Request) {
+	local, err := s.community.Preview(r.Context(), time.Now())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	resp := map[string]interface{}{
+		"status": s.community.Status(),
+		"local":  local,
+	}
+	if shared, err := s.community.Fetch(r.Context()); err != nil {
+		resp["error"] = err.Error()
+	} else {
+		resp["community"] = shared
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/drift"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// WithDriftOptions overrides how often the desired state is reconciled.
+func WithDriftOptions(opts drift.Options) Option {
+	return func(s *Server) {
+		s.driftOpts = opts
+	}
+}
+
+// desiredStateRequest is the body of PUT /api/desired-state.
+type desiredStateRequest struct {
+	Running     bool                 `json:"running"`
+	Config      *models.ServerConfig `json:"config"`
+	AutoCorrect bool                 `json:"autoCorrect"`
+}
+
+// latestDesiredState returns the current desired state, or nil if none has
+// been declared.
+func (s *Server) latestDesiredState(ctx context.Context) (*models.DesiredState, error) {
+	d, err := s.storage.LatestDesiredState(ctx)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	return d, err
+}
+
+// serverBusy reports whether a test or queued job is using the server.
+func (s *Server) serverBusy() bool {
+	for _, p := range s.manager.Ports() {
+		if p.State == models.PortStateBusy {
+			return true
+		}
+	}
+	snapshot := s.queue.Snapshot()
+	return snapshot.Running != nil || len(snapshot.Pending) > 0
+}
+
+// notifyDrift broadcasts a changed drift report and audits any correction.
+func (s *Server) notifyDrift(report models.DriftReport) {
+	s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeDrift, Payload: report})
+
+	if report.Action == "" {
+		return
+	}
+	if report.Error != "" {
+		log.Printf("Drift correction %s failed: %s", report.Action, report.Error)
+	}
+	s.saveAudit(&models.AuditEntry{
+		Action: models.AuditActionDriftCorrect,
+		Parameters: map[string]interface{}{
+			"action":         report.Action,
+			"desiredVersion": report.DesiredVersion,
+			"drift":          report.Drift,
+			"error":          report.Error,
+		},
+	})
+}
+
+// handleGetDesiredState returns the current desired state.
+func (s *Server) handleGetDesiredState(w http.ResponseWriter, r *http.Request) {
+	d, err := s.latestDesiredState(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.desired_state_failed", i18n.Params{"error": err})
+		return
+	}
+	if d == nil {
+		s.writeError(w, r, http.StatusNotFound, "error.desired_state_not_found", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// handleListDesiredStates returns every declared version, newest first.
+func (s *Server) handleListDesiredStates(w http.ResponseWriter, r *http.Request) {
+	states, err := s.storage.GetDesiredStates(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.desired_state_failed", i18n.Params{"error": err})
+		return
+	}
+	if states == nil {
+		states = []models.DesiredState{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(states)
+}
+
+// handlePutDesiredState declares a new desired state version and schedules
+// a reconciliation against it.
+func (s *Server) handlePutDesiredState(w http.ResponseWriter, r *http.Request) {
+	var req desiredStateRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	// A declared server runs until told otherwise, so no idle timeout by default
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	if req.Config != nil {
+		cfg = *req.Config
+	}
+	d := &models.DesiredState{Running: req.Running, Config: cfg, AutoCorrect: req.AutoCorrect}
+	if err := drift.Validate(*d); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.storage.SaveDesiredState(r.Context(), d); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.desired_state_save_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionDesiredState, map[string]interface{}{"desiredState": d})
+	s.drift.Trigger()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(d)
+}
+
+// handleGetDrift compares the server with the desired state without
+// correcting it.
+func (s *Server) handleGetDrift(w http.ResponseWriter, r *http.Request) {
+	report, err := s.drift.Inspect()
+	s.writeDriftReport(w, r, report, err)
+}
+
+// handleReconcile compares the server with the desired state now and
+// corrects drift if the declaration allows it.
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	report, err := s.drift.Reconcile()
+	s.writeDriftReport(w, r, report, err)
+}
+
+func (s *Server) writeDriftReport(w http.ResponseWriter, r *http.Request, report *models.DriftReport, err error) {
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.desired_state_failed", i18n.Params{"error": err})
+		return
+	}
+	if report == nil {
+		s.writeError(w, r, http.StatusNotFound, "error.desired_state_not_found", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
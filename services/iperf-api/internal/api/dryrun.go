@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// isDryRun reports whether the request asks to check an action without
+// performing it (?dryRun=true).
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// writeDryRun reports a dry run with its errors in the request's language:
+// 200 when every check passed, 422 otherwise.
+func (s *Server) writeDryRun(w http.ResponseWriter, r *http.Request, plan iperf.DryRunPlan) {
+	report := models.DryRunReport{
+		OK:       plan.OK(),
+		Checks:   make([]models.DryRunCheck, 0, len(plan.Checks)),
+		Commands: plan.Commands,
+	}
+	if report.Commands == nil {
+		report.Commands = []models.DryRunCommand{}
+	}
+	for _, c := range plan.Checks {
+		check := models.DryRunCheck{
+			Name:    c.Name,
+			Port:    c.Port,
+			Field:   c.Field,
+			OK:      c.Err == nil,
+			Detail:  c.Detail,
+			Skipped: c.Skipped,
+		}
+		if c.Err != nil {
+			check.Error = s.localize(r, c.Err)
+		}
+		if c.Warning != nil {
+			check.Warning = s.localize(r, c.Warning)
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleValidate checks a configuration for the start form without starting
+// anything: every config error by field, each port's availability and
+// allowlist warnings, reported as a dry run.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	var config models.ServerConfig
+	if err := decodeJSON(w, r, &config); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	s.writeDryRun(w, r, s.manager.Validate(config))
+}
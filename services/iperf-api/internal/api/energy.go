@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/Tom-Oram/fak/backend/internal/energy"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// WithEnergyMeter samples host power draw during each test and records the
+// energy used with its result.
+func WithEnergyMeter(m *energy.Meter) Option {
+	return func(s *Server) {
+		s.energy = m
+	}
+}
+
+// measureEnergy starts a metering session when a client connects and
+// attaches the measurement to the completed result. Sessions are discarded
+// when the server stops without completing a test, and when tests on a port
+// pool overlap, since the host's draw cannot be split between them.
+func (s *Server) measureEnergy(msg models.WSMessage) {
+	if s.energy == nil {
+		return
+	}
+
+	switch msg.Type {
+	case models.WSMessageTypeClientConnected:
+		if !s.energy.Begin() {
+			s.energyShared.Store(true)
+		}
+
+	case models.WSMessageTypeTestComplete:
+		result, ok := msg.Payload.(*models.TestResult)
+		if !ok {
+			return
+		}
+		m, ok := s.energy.End()
+		if shared := s.energyShared.Swap(false); !ok || shared {
+			return
+		}
+		joules := m.Joules
+		result.EnergyJoules = &joules
+		result.JoulesPerGB = m.JoulesPerGB(result.BytesTransferred)
+
+	case models.WSMessageTypeServerStatus:
+		if status, ok := msg.Payload.(models.ServerStatusPayload); ok && status.Status != models.ServerStatusRunning {
+			s.energy.Abort()
+			s.energyShared.Store(false)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// compress gzips history responses, which run to megabytes for an export.
+var compress = middleware.Compress(5, "application/json", "text/csv")
+
+// resultsETag identifies the results a request for r returns, by how many
+// results match filter, which it also returns, and the newest one's
+// timestamp. Edits to stored results and restarts change the revision, so
+// they change it too. It is weak, as compression changes the bytes sent.
+func (s *Server) resultsETag(ctx context.Context, r *http.Request, filter storage.HistoryFilter) (string, int, error) {
+	count, err := s.storage.CountTestResults(ctx, filter)
+	if err != nil {
+		return "", 0, err
+	}
+	latest, err := s.storage.LatestResultTime(ctx, filter)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%d",
+		r.URL.RawQuery, count, latest.UnixNano(), s.started.UnixNano(), s.resultsRevision.Load())))
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`, count, nil
+}
+
+// notModified sets etag on the response and, if the request's
+// If-None-Match has it, answers 304 and reports true.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/federation"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// WithFederation enables the federated endpoints, aggregating this instance
+// (reported as localName) with the client's peers.
+func WithFederation(client *federation.Client, localName string) Option {
+	return func(s *Server) {
+		s.federation = client
+		s.originName = localName
+	}
+}
+
+// handleFederatedOverview returns the status of this instance and every peer.
+func (s *Server) handleFederatedOverview(w http.ResponseWriter, r *http.Request) {
+	local, err := s.localOverview(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.overview_failed", i18n.Params{"error": err})
+		return
+	}
+
+	overviews := append([]federation.PeerOverview{local}, s.federation.Overview(r.Context())...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"overviews": overviews,
+	})
+}
+
+// localOverview summarises this instance in the same shape as a peer.
+func (s *Server) localOverview(ctx context.Context) (federation.PeerOverview, error) {
+	status := s.manager.GetStatus()
+	config := s.manager.GetConfig()
+
+	listenAddr := ""
+	if status == models.ServerStatusRunning {
+		listenAddr = net.JoinHostPort(config.BindAddress, strconv.Itoa(config.Port))
+	}
+
+	total, err := s.storage.GetTotalCount(ctx)
+	if err != nil {
+		return federation.PeerOverview{}, err
+	}
+
+	latest, err := s.storage.QueryTestResults(ctx, storage.HistoryFilter{Local: true}, 1, 0)
+	if err != nil {
+		return federation.PeerOverview{}, err
+	}
+
+	ov := federation.PeerOverview{
+		Origin:    s.originName,
+		Reachable: true,
+		Status: &models.ServerStatusPayload{
+			Status:     status,
+			Config:     &config,
+			ListenAddr: listenAddr,
+		},
+		TotalTests: total,
+	}
+	if len(latest) > 0 {
+		ov.LatestResult = &latest[0]
+	}
+	return ov, nil
+}
+
+// handleFederatedHistory returns recent results from this instance and every
+// peer, merged newest first and tagged with their origin.
+func (s *Server) handleFederatedHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 25
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	clientIP := r.URL.Query().Get("clientIp")
+
+	local, err := s.storage.QueryTestResults(r.Context(), storage.HistoryFilter{ClientIP: clientIP, Local: true}, limit, 0)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	results, peerErrors := s.federation.History(r.Context(), limit, clientIP)
+	for _, res := range local {
+		results = append(results, federation.Result{TestResult: res, Origin: s.originName})
+	}
+	results = federation.MergeResults(results, limit)
+
+	if results == nil {
+		results = []federation.Result{}
+	}
+	if peerErrors == nil {
+		peerErrors = []federation.PeerError{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"errors":  peerErrors,
+		"limit":   limit,
+	})
+}
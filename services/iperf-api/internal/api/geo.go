@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/federation"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// WithSiteLocation places this instance on the results map.
+func WithSiteLocation(location models.Location) Option {
+	return func(s *Server) {
+		s.siteLocation = &location
+	}
+}
+
+// featureCollection and feature are the GeoJSON (RFC 7946) objects served by
+// the results map.
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string         `json:"type"`
+	Geometry   pointGeometry  `json:"geometry"`
+	Properties siteProperties `json:"properties"`
+}
+
+type pointGeometry struct {
+	Type string `json:"type"`
+	// Coordinates are longitude, then latitude
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type siteProperties struct {
+	Site      string `json:"site"`
+	Local     bool   `json:"local"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	federation.SiteSummary
+}
+
+func siteFeature(location models.Location, props siteProperties) feature {
+	return feature{
+		Type: "Feature",
+		Geometry: pointGeometry{
+			Type:        "Point",
+			Coordinates: [2]float64{location.Longitude, location.Latitude},
+		},
+		Properties: props,
+	}
+}
+
+// handleGetResultsGeoJSON returns one point per located site, this instance
+// and any federation peers, with a summary of its most recent results
+// (?limit=, default 25, at most 100 per site).
+func (s *Server) handleGetResultsGeoJSON(w http.ResponseWriter, r *http.Request) {
+	limit := 25
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	collection := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+
+	if s.siteLocation != nil {
+		local, err := s.storage.QueryTestResults(r.Context(), storage.HistoryFilter{Local: true}, limit, 0)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+			return
+		}
+		name := s.originName
+		if name == "" {
+			name = "local"
+		}
+		collection.Features = append(collection.Features, siteFeature(*s.siteLocation, siteProperties{
+			Site:        name,
+			Local:       true,
+			Reachable:   true,
+			SiteSummary: federation.Summarise(local),
+		}))
+	}
+
+	if s.federation != nil {
+		results, peerErrors := s.federation.History(r.Context(), limit, "")
+		bySite := make(map[string][]models.TestResult)
+		for _, res := range results {
+			bySite[res.Origin] = append(bySite[res.Origin], res.TestResult)
+		}
+		failed := make(map[string]string)
+		for _, e := range peerErrors {
+			failed[e.Origin] = e.Error
+		}
+		for _, p := range s.federation.Peers() {
+			if p.Location == nil {
+				continue
+			}
+			peerErr, down := failed[p.Name]
+			collection.Features = append(collection.Features, siteFeature(*p.Location, siteProperties{
+				Site:        p.Name,
+				Reachable:   !down,
+				Error:       peerErr,
+				SiteSummary: federation.Summarise(bySite[p.Name]),
+			}))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(collection)
+}
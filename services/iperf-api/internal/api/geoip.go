@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/Tom-Oram/fak/backend/internal/geoip"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// WithGeoIP attaches the country, ASN and ISP of client addresses to
+// connection events and test results.
+func WithGeoIP(resolver *geoip.Resolver) Option {
+	return func(s *Server) {
+		s.geoip = resolver
+	}
+}
+
+// locate fills in where the client of a connection event or test result is.
+func (s *Server) locate(msg models.WSMessage) {
+	if s.geoip == nil {
+		return
+	}
+	switch p := msg.Payload.(type) {
+	case *models.ConnectionEvent:
+		p.Geo = s.geoip.Lookup(p.ClientIP)
+	case *models.TestResult:
+		p.Geo = s.geoip.Lookup(p.ClientIP)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/grafana"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+)
+
+// grafanaPeriod fills in a query range Grafana left out, defaulting like
+// the other period endpoints, and reports whether it is in order.
+func grafanaPeriod(rng *grafana.Range) bool {
+	if rng.To.IsZero() {
+		rng.To = time.Now()
+	}
+	if rng.From.IsZero() {
+		rng.From = rng.To.Add(-defaultAccountingPeriod)
+	}
+	return rng.From.Before(rng.To)
+}
+
+// handleGrafanaTest answers the datasource's connection test.
+func (s *Server) handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleGrafanaSearch lists the metrics a panel can chart. Per-client
+// targets are offered for clients seen in the default period.
+func (s *Server) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	// Grafana may send no body when listing everything
+	if err := json.NewDecoder(limitBody(w, r)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	var clients []string
+	if strings.Contains(req.Target, ":") {
+		to := time.Now()
+		results, err := s.storage.GetTestResultsBetween(r.Context(), to.Add(-defaultAccountingPeriod), to)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+			return
+		}
+		seen := make(map[string]bool)
+		for _, res := range results {
+			if res.ClientIP != "" && !seen[res.ClientIP] {
+				seen[res.ClientIP] = true
+				clients = append(clients, res.ClientIP)
+			}
+		}
+		sort.Strings(clients)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grafana.Search(req.Target, clients))
+}
+
+// handleGrafanaQuery charts stored results for the panel's range.
+func (s *Server) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafana.QueryRequest
+	if err := json.NewDecoder(limitBody(w, r)).Decode(&req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if !grafanaPeriod(&req.Range) {
+		s.writeError(w, r, http.StatusBadRequest, "error.period_order", nil)
+		return
+	}
+
+	results, err := s.storage.GetTestResultsBetween(r.Context(), req.Range.From, req.Range.To)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+	answers, err := grafana.Query(req, results)
+	if err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answers)
+}
+
+// handleGrafanaAnnotations marks config changes in the panel's range.
+func (s *Server) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req grafana.AnnotationRequest
+	if err := json.NewDecoder(limitBody(w, r)).Decode(&req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if !grafanaPeriod(&req.Range) {
+		s.writeError(w, r, http.StatusBadRequest, "error.period_order", nil)
+		return
+	}
+
+	list, err := s.annotationsBetween(r.Context(), req.Range.From, req.Range.To)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.annotations_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grafana.Annotations(req, list))
+}
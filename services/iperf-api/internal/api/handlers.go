@@ -1,125 +1,693 @@
 package api
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"net/http"
 	"strconv"
-
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/alerts"
+	"github.com/Tom-Oram/fak/backend/internal/annotations"
+	"github.com/Tom-Oram/fak/backend/internal/auth"
+	"github.com/Tom-Oram/fak/backend/internal/backup"
+	"github.com/Tom-Oram/fak/backend/internal/buildinfo"
+	"github.com/Tom-Oram/fak/backend/internal/community"
+	"github.com/Tom-Oram/fak/backend/internal/drift"
+	"github.com/Tom-Oram/fak/backend/internal/energy"
+	"github.com/Tom-Oram/fak/backend/internal/federation"
+	"github.com/Tom-Oram/fak/backend/internal/geoip"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
 	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/linkload"
 	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/neighbor"
+	"github.com/Tom-Oram/fak/backend/internal/netprobe"
+	"github.com/Tom-Oram/fak/backend/internal/quality"
+	"github.com/Tom-Oram/fak/backend/internal/queue"
+	"github.com/Tom-Oram/fak/backend/internal/report"
+	"github.com/Tom-Oram/fak/backend/internal/slo"
 	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/Tom-Oram/fak/backend/internal/telemetry"
 	"github.com/go-chi/chi/v5"
 )
 
 // Server is the HTTP API server that manages the iPerf server lifecycle.
 type Server struct {
 	hub     *Hub
-	manager *iperf.Manager
-	storage *storage.SQLiteStorage
+	manager iperf.ProcessManager
+	storage storage.Store
+
+	managerOpts []iperf.ManagerOption
+	// newManager, when set, creates the manager in place of iperf.Manager
+	newManager  func(iperf.EventHandler) iperf.ProcessManager
+	qualityOpts quality.Options
+
+	federation *federation.Client
+	originName string
+	puller     *federation.Puller
+
+	notifier *alerts.Notifier
+	email    *alerts.EmailNotifier
+
+	i18n *i18n.Bundle
+
+	queue     *queue.Queue
+	queueOpts queue.Options
+	newID     ids.Generator
+
+	energy *energy.Meter
+
+	apiKeys *auth.Keys
+
+	drift     *drift.Reconciler
+	driftOpts drift.Options
+
+	objectives []slo.Objective
+
+	tunnel TunnelStatusProvider
+
+	community *community.Reporter
+	backups   *backup.Scheduler
+	reports   *report.Scheduler
+
+	geoip     *geoip.Resolver
+	neighbors *neighbor.Table
+
+	// latency runs latency probes, whose rounds are stored and streamed
+	latency *netprobe.Prober
+
+	// telemetry records the server's lifecycle and tests as spans and metrics
+	telemetry *telemetry.Observer
+
+	// link is measured before scheduled jobs start, deferring them while
+	// the uplink is busy
+	link *linkload.Monitor
+
+	// webUI is the dashboard bundle served alongside the API
+	webUI fs.FS
+
+	// siteLocation places this instance on the results map
+	siteLocation *models.Location
+	// nodeName identifies this deployment on its results and WebSocket
+	// messages
+	nodeName string
+	// testSummaries sends a test_summary message after each test_complete
+	testSummaries bool
+	// linkSpeed is the line rate, in bits per second, goodput ratios are
+	// measured against; zero leaves them unset
+	linkSpeed float64
+
+	// sessionMu guards liveSessions, the test session in progress on each
+	// listener port, streamed to session channels, and the interval samples
+	// collected for each session until its result is saved
+	sessionMu      sync.Mutex
+	liveSessions   map[int]string
+	sessionSamples map[string][]models.IntervalSample
+
+	// energyShared marks a metering session that overlapped another test
+	energyShared atomic.Bool
+
+	// collisions counts connections turned away while busy, for /metrics
+	collisions atomic.Int64
+
+	// traceroutes counts the traceroutes running
+	traceroutes atomic.Int32
+
+	// started and resultsRevision, bumped when a stored result is edited,
+	// go into history ETags
+	started         time.Time
+	resultsRevision atomic.Int64
+
+	// storageStatus, when set, reports storage health on /health
+	storageStatus func() models.StorageStatus
 }
 
-// NewServer creates a new Server with the given storage backend.
-func NewServer(store *storage.SQLiteStorage) *Server {
-	hub := NewHub()
-	go hub.Run()
+// Option configures optional Server behaviour.
+type Option func(*Server)
 
+// WithManagerOptions passes options through to the underlying iperf.Manager.
+func WithManagerOptions(opts ...iperf.ManagerOption) Option {
+	return func(s *Server) {
+		s.managerOpts = append(s.managerOpts, opts...)
+	}
+}
+
+// WithProcessManager replaces the iperf.Manager with the one newManager
+// returns for the server's event handler, such as a fake in tests. Manager
+// options are not applied to it.
+func WithProcessManager(newManager func(iperf.EventHandler) iperf.ProcessManager) Option {
+	return func(s *Server) {
+		s.newManager = newManager
+	}
+}
+
+// WithNodeName labels every test result and WebSocket message with name, so
+// results from several deployments stay distinguishable once aggregated.
+func WithNodeName(name string) Option {
+	return func(s *Server) {
+		s.nodeName = name
+		s.hub.node = name
+	}
+}
+
+// WithQualityOptions overrides the thresholds used to flag suspect results.
+func WithQualityOptions(opts quality.Options) Option {
+	return func(s *Server) {
+		s.qualityOpts = opts
+	}
+}
+
+// WithLinkSpeed sets the line rate, in bits per second, that each result's
+// goodput ratio is measured against.
+func WithLinkSpeed(bps float64) Option {
+	return func(s *Server) {
+		s.linkSpeed = bps
+	}
+}
+
+// WithStorageStatus reports status on /health, which fails while storage is
+// unavailable.
+func WithStorageStatus(status func() models.StorageStatus) Option {
+	return func(s *Server) {
+		s.storageStatus = status
+	}
+}
+
+// NewServer creates a new Server with the given storage backend.
+func NewServer(store storage.Store, opts ...Option) *Server {
 	s := &Server{
-		hub:     hub,
-		storage: store,
-	}
-
-	// Create manager with handler that broadcasts messages AND saves test results
-	handler := func(msg models.WSMessage) {
-		// Broadcast to WebSocket clients
-		hub.Broadcast(msg)
-
-		// Save test results to storage
-		if msg.Type == models.WSMessageTypeTestComplete {
-			if result, ok := msg.Payload.(*models.TestResult); ok {
-				if err := store.SaveTestResult(result); err != nil {
-					// Log error but don't fail - the broadcast already happened
-					hub.Broadcast(models.WSMessage{
-						Type: models.WSMessageTypeError,
-						Payload: map[string]string{
-							"message": fmt.Sprintf("failed to save test result: %v", err),
-						},
-					})
-				}
-			}
-		}
+		hub:         NewHub(),
+		storage:     store,
+		started:     time.Now(),
+		qualityOpts: quality.DefaultOptions(),
+		notifier:    alerts.NewNotifier(webhookTimeout),
+		queueOpts:   queue.DefaultOptions(),
+
+		liveSessions:   make(map[int]string),
+		sessionSamples: make(map[string][]models.IntervalSample),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.hub.snapshot = s.snapshot
+	go s.hub.Run()
+	if s.i18n == nil {
+		s.i18n = i18n.MustNew()
 	}
+	if s.email == nil {
+		// An empty config cannot fail validation
+		s.email, _ = alerts.NewEmailNotifier(alerts.EmailConfig{}, emailTimeout)
+	}
+
+	if s.newID != nil {
+		s.managerOpts = append(s.managerOpts, iperf.WithIDGenerator(s.newID))
+		s.queueOpts.NewID = s.newID
+	}
+	if s.newManager != nil {
+		s.manager = s.newManager(s.handleManagerEvent)
+	} else {
+		s.manager = iperf.NewManager(s.handleManagerEvent, s.managerOpts...)
+	}
+	if s.queueOpts.Recorded == nil {
+		s.queueOpts.Recorded = s.correlationRecorded
+	}
+	if s.link != nil && s.queueOpts.Defer == nil {
+		s.queueOpts.Defer = s.linkBusy
+	}
+	s.queue = queue.New(s.manager, s.hub.Broadcast, s.queueOpts)
+	go s.queue.Run()
+	s.latency = netprobe.New(s.newID, s.handleLatencyEvent)
 
-	s.manager = iperf.NewManager(handler)
+	if s.driftOpts.Busy == nil {
+		s.driftOpts.Busy = s.serverBusy
+	}
+	desired := func() (*models.DesiredState, error) {
+		return s.latestDesiredState(context.Background())
+	}
+	s.drift = drift.NewReconciler(s.manager, desired, s.notifyDrift, s.driftOpts)
+	go s.drift.Run()
 	return s
 }
 
-// Routes returns a chi.Router with all API routes configured.
+// shutdownWait bounds how long Close waits for iperf to exit, so the result
+// of a test cut short is saved before storage closes.
+const shutdownWait = 5 * time.Second
+
+// Close tears the server down: the queue, drift checks and latency probes
+// stop first so nothing starts iperf again, then iperf is stopped, the hub
+// disconnects every WebSocket client and storage is closed. It is safe to
+// call more than once.
+func (s *Server) Close() error {
+	s.queue.Close()
+	s.drift.Close()
+	s.latency.Close()
+	if err := s.manager.Stop(); err != nil && !errors.Is(err, iperf.ErrNotRunning) {
+		log.Printf("Failed to stop iperf on shutdown: %v", err)
+	}
+	if !s.manager.WaitExited(shutdownWait) {
+		log.Printf("iperf did not exit within %s of shutdown", shutdownWait)
+	}
+	s.hub.Stop()
+	return s.storage.Close()
+}
+
+// handleManagerEvent broadcasts manager messages to WebSocket clients, saves
+// test results to storage along with the energy they used and their interval
+// samples, tracks the test sessions in progress, records config versions and
+// collisions, checks saved results against alert rules and emails when the
+// server enters the error state. The execution queue sees each event last,
+// once results are saved.
+func (s *Server) handleManagerEvent(msg models.WSMessage) {
+	defer s.queue.HandleEvent(msg)
+
+	s.measureEnergy(msg)
+	s.trackSession(msg)
+	s.collectSample(msg)
+	s.locate(msg)
+	s.identify(msg)
+
+	// Flag suspect results and tie them to their queued job before they are
+	// broadcast and stored
+	if msg.Type == models.WSMessageTypeTestComplete {
+		if result, ok := msg.Payload.(*models.TestResult); ok {
+			result.QualityFlags = quality.Assess(result, s.qualityOpts, time.Now())
+			result.Node = s.nodeName
+			result.Percentiles = s.samplePercentiles(result.ID)
+			quality.Derive(result, s.linkSpeed)
+			s.applyCapacity(context.Background(), result)
+			s.queue.Attribute(result)
+		}
+	}
+
+	// Broadcast to WebSocket clients
+	s.hub.Broadcast(msg)
+	s.observe(msg)
+
+	if msg.Type == models.WSMessageTypeServerStatus {
+		s.recordConfigVersion(msg)
+		s.notifyServerError(msg)
+	}
+
+	if msg.Type == models.WSMessageTypeCollision {
+		s.recordCollision(msg)
+	}
+
+	// Save test results to storage
+	if msg.Type == models.WSMessageTypeTestComplete {
+		if result, ok := msg.Payload.(*models.TestResult); ok {
+			// Session channels stay open until the result's alerts are sent
+			defer s.endSession(result)
+			samples := s.takeSamples(result.ID)
+			s.broadcastSummary(result, samples)
+			if err := s.storage.SaveTestResultWithSamples(context.Background(), result, samples); err != nil {
+				// Log error but don't fail - the broadcast already happened
+				s.hub.Broadcast(models.WSMessage{
+					Type: models.WSMessageTypeError,
+					Payload: map[string]string{
+						"message": fmt.Sprintf("failed to save test result: %v", err),
+					},
+				})
+				return
+			}
+			s.evaluateAlerts(result)
+		}
+	}
+}
+
+// Routes returns a chi.Router with all API routes configured, and the web UI
+// if one is set.
 func (s *Server) Routes() chi.Router {
 	r := chi.NewRouter()
+	r.NotFound(s.handleNotFound)
+	r.MethodNotAllowed(s.handleMethodNotAllowed)
+	s.apiRoutes(r)
+	if s.webUI != nil {
+		s.webUIRoutes(r)
+	}
+	return r
+}
 
+// apiRoutes registers the API on r.
+func (s *Server) apiRoutes(r chi.Router) {
 	r.Get("/health", s.handleHealth)
-	r.Get("/api/status", s.handleGetStatus)
-	r.Post("/api/start", s.handleStart)
-	r.Post("/api/stop", s.handleStop)
-	r.Get("/api/history", s.handleGetHistory)
-	r.Get("/api/history/export", s.handleExportHistory)
-	r.Get("/ws", s.hub.HandleWebSocket)
 
-	return r
+	r.Group(func(r chi.Router) {
+		r.Use(s.authenticate)
+
+		// Reading status, results and reports
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(auth.RoleViewer))
+			r.Get("/api/auth", s.handleGetAuth)
+			r.Get("/api/status", s.handleGetStatus)
+			r.Get("/api/version", s.handleGetVersion)
+			r.Get("/api/ports", s.handleGetPorts)
+			r.Get("/api/live", s.handleGetLive)
+			r.Get("/api/labels", s.handleGetLabels)
+			r.With(compress).Get("/api/history", s.handleGetHistory)
+			r.With(compress).Get("/api/history/export", s.handleExportHistory)
+			r.Get("/api/history/{id}", s.handleGetResult)
+			r.Get("/api/history/{id}/samples", s.handleGetSamples)
+			r.Get("/api/history/{id}/raw", s.handleGetRawOutput)
+			r.Get("/api/stats/accounting", s.handleGetAccounting)
+			r.Get("/api/stats/collisions", s.handleGetCollisions)
+			r.Get("/api/reports/pdf", s.handleGetReportPDF)
+			r.Get("/api/geo/results.geojson", s.handleGetResultsGeoJSON)
+			r.Get("/api/annotations", s.handleGetAnnotations)
+			r.Get("/api/desired-state", s.handleGetDesiredState)
+			r.Get("/api/desired-state/versions", s.handleListDesiredStates)
+			r.Get("/api/drift", s.handleGetDrift)
+			r.Get("/api/accounting/assignments", s.handleListAssignments)
+			r.Get("/api/capacities", s.handleListCapacities)
+			r.Get("/api/alerts", s.handleListAlertRules)
+			r.Get("/api/alerts/{id}", s.handleGetAlertRule)
+			r.Get("/api/profiles", s.handleListProfiles)
+			r.Get("/api/profiles/{name}", s.handleGetProfile)
+			r.Get("/api/queue", s.handleGetQueue)
+			r.Get("/api/latency/probes", s.handleListProbes)
+			r.Get("/api/latency/results", s.handleGetLatencyResults)
+			r.Get("/api/tools/mtu", s.handleGetMTUResults)
+			r.Get("/api/tools/mtu/export", s.handleExportMTU)
+			r.Get("/api/tools/traceroute", s.handleGetTraceroutes)
+			r.Get("/api/tools/traceroute/{id}", s.handleGetTraceroute)
+			r.Get("/api/grafana", s.handleGrafanaTest)
+			r.Post("/api/grafana/search", s.handleGrafanaSearch)
+			r.Post("/api/grafana/query", s.handleGrafanaQuery)
+			r.Post("/api/grafana/annotations", s.handleGrafanaAnnotations)
+			r.Get("/api/slo", s.handleListObjectives)
+			r.Get("/api/slo/{name}", s.handleGetObjective)
+			r.Get("/metrics", s.handleMetrics)
+			r.Get("/ws", s.hub.HandleWebSocket)
+			r.Get("/ws/sessions/{id}", s.handleSessionWebSocket)
+
+			if s.federation != nil {
+				r.Get("/api/federated/overview", s.handleFederatedOverview)
+				r.Get("/api/federated/history", s.handleFederatedHistory)
+			}
+			if s.community != nil {
+				r.Get("/api/community", s.handleGetCommunity)
+			}
+			if s.neighbors != nil {
+				r.Get("/api/neighbors", s.handleGetNeighbors)
+			}
+			if s.link != nil {
+				r.Get("/api/link", s.handleGetLink)
+			}
+		})
+
+		// Controlling the server and changing settings; the audit log, SMTP
+		// settings and config bundle reveal callers, credentials and webhooks,
+		// so they are here too
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(auth.RoleOperator))
+			r.Post("/api/start", s.handleStart)
+			r.Post("/api/validate", s.handleValidate)
+			r.Post("/api/stop", s.handleStop)
+			r.Post("/api/history/import", s.handleImportHistory)
+			r.Patch("/api/history/{id}", s.handleUpdateResult)
+			r.Get("/api/audit", s.handleGetAudit)
+			r.Get("/api/admin/config-bundle", s.handleExportConfigBundle)
+			r.Put("/api/admin/config-bundle", s.handleImportConfigBundle)
+			r.Get("/api/admin/stats", s.handleGetStatsCache)
+			r.Post("/api/admin/stats/rebuild", s.handleRebuildStats)
+			r.Post("/api/admin/reparse", s.handleReparse)
+			r.Put("/api/desired-state", s.handlePutDesiredState)
+			r.Post("/api/drift/reconcile", s.handleReconcile)
+			r.Post("/api/accounting/assignments", s.handleSaveAssignment)
+			r.Delete("/api/accounting/assignments/{id}", s.handleDeleteAssignment)
+			r.Post("/api/capacities", s.handleSaveCapacity)
+			r.Delete("/api/capacities/{id}", s.handleDeleteCapacity)
+			r.Post("/api/alerts", s.handleCreateAlertRule)
+			r.Put("/api/alerts/{id}", s.handleUpdateAlertRule)
+			r.Delete("/api/alerts/{id}", s.handleDeleteAlertRule)
+			r.Put("/api/profiles/{name}", s.handleSaveProfile)
+			r.Delete("/api/profiles/{name}", s.handleDeleteProfile)
+			r.Get("/api/notifications/email", s.handleGetEmailConfig)
+			r.Put("/api/notifications/email", s.handleUpdateEmailConfig)
+			r.Post("/api/notifications/email/test", s.handleTestEmail)
+			r.Post("/api/queue", s.handleEnqueue)
+			r.Delete("/api/queue/{id}", s.handleCancelJob)
+			r.Post("/api/latency/probes", s.handleStartProbe)
+			r.Delete("/api/latency/probes/{id}", s.handleStopProbe)
+			r.Post("/api/tools/mtu", s.handleDiscoverMTU)
+			r.Post("/api/tools/traceroute", s.handleStartTraceroute)
+
+			if s.backups != nil {
+				r.Get("/api/admin/backups", s.handleGetBackups)
+				r.Post("/api/admin/backups", s.handleCreateBackup)
+			}
+			if s.reports != nil {
+				r.Get("/api/reports/schedule", s.handleGetReportSchedule)
+				r.Post("/api/reports/schedule/send", s.handleSendReport)
+			}
+			if s.federation != nil {
+				r.Get("/api/peers", s.handleListPeers)
+				r.Put("/api/peers/{name}", s.handleSavePeer)
+				r.Delete("/api/peers/{name}", s.handleDeletePeer)
+			}
+		})
+	})
+
+}
+
+// healthResponse is the body of /health
+type healthResponse struct {
+	Status  string                `json:"status"`
+	Storage *models.StorageStatus `json:"storage,omitempty"`
 }
 
-// handleHealth returns a simple health check response.
+// handleHealth returns a simple health check response. While storage is
+// unavailable it reports degraded with 503, so container health checks
+// fail until the database opens.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: "ok"}
+	status := http.StatusOK
+	if s.storageStatus != nil {
+		st := s.storageStatus()
+		resp.Storage = &st
+		if st.State == models.StorageStateUnavailable {
+			resp.Status = "degraded"
+			status = http.StatusServiceUnavailable
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
 }
 
 // handleGetStatus returns the current server status.
 func (s *Server) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.statusPayload())
+}
+
+// handleGetVersion identifies the build and the iperf binary it runs, for
+// bug reports.
+func (s *Server) handleGetVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.VersionInfo{
+		BuildInfo: buildinfo.Get(),
+		Iperf:     s.manager.Binary(),
+	})
+}
+
+// statusPayload reports the server's status, configuration and health.
+func (s *Server) statusPayload() models.ServerStatusPayload {
 	status := s.manager.GetStatus()
 	config := s.manager.GetConfig()
 
 	listenAddr := ""
 	if status == models.ServerStatusRunning {
-		listenAddr = fmt.Sprintf("%s:%d", config.BindAddress, config.Port)
+		listenAddr = config.ListenAddr()
 	}
 
-	payload := models.ServerStatusPayload{
+	return models.ServerStatusPayload{
 		Status:     status,
 		Config:     &config,
 		ListenAddr: listenAddr,
+		Supervisor: s.manager.Supervisor(),
+		Process:    s.manager.Diagnostics(),
+		Tunnel:     s.tunnelStatus(),
+	}
+}
+
+// handleGetPorts returns whether each listener of the running server is
+// free or busy with a test.
+func (s *Server) handleGetPorts(w http.ResponseWriter, r *http.Request) {
+	ports := s.manager.Ports()
+	if ports == nil {
+		ports = []models.PortStatus{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(payload)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": s.manager.GetStatus(),
+		"ports":  ports,
+	})
 }
 
-// handleStart starts the iPerf server with the provided configuration.
+// handleGetLive returns the latest bandwidth update and the rolling average
+// throughput, for widgets that poll instead of using the WebSocket.
+func (s *Server) handleGetLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.manager.Live())
+}
+
+// handleStart starts the iPerf server with the provided configuration, or
+// with ?dryRun=true reports what starting would do. Starting a running
+// server with the config it runs returns its status; ?restart=true applies
+// a different one by restarting it.
 func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
 	var config models.ServerConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+	profileName := r.URL.Query().Get("profile")
+	if profileName != "" {
+		profile, err := s.storage.GetProfile(r.Context(), profileName)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.profile_not_found", i18n.Params{"name": profileName})
+			return
+		}
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.profile_get_failed", i18n.Params{"error": err})
+			return
+		}
+		config = profile.Config
+	} else if err := decodeJSON(w, r, &config); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		s.writeDryRun(w, r, s.manager.DryRun(config, false))
 		return
 	}
 
+	// Starting a running server with its own config changes nothing; a
+	// different config needs ?restart=true to cycle the processes
+	restarted := false
+	running := s.manager.GetConfig()
+	if s.manager.GetStatus() == models.ServerStatusRunning {
+		changes := startChanges(running, config)
+		switch {
+		case len(changes) == 0:
+			s.handleGetStatus(w, r)
+			return
+		case r.URL.Query().Get("restart") != "true":
+			s.writeRestartRequired(w, r, changes)
+			return
+		}
+		// An invalid config must not stop the running server
+		if errs := iperf.ValidateConfig(config); len(errs) > 0 {
+			s.writeCausedError(w, r, "error.start_failed", iperf.ConfigErrors(errs))
+			return
+		}
+		if err := s.manager.Stop(); err != nil && !errors.Is(err, iperf.ErrNotRunning) {
+			s.writeCausedError(w, r, "error.start_failed", err)
+			return
+		}
+		if !s.manager.WaitExited(restartWait) {
+			log.Printf("iperf did not exit within %s of a restart", restartWait)
+		}
+		restarted = true
+	}
+
+	// The latest config version is replaced while the server starts
+	previous, _ := s.storage.LatestConfigVersion(r.Context())
+
 	if err := s.manager.Start(config); err != nil {
-		http.Error(w, fmt.Sprintf("failed to start server: %v", err), http.StatusInternalServerError)
+		if restarted {
+			s.restoreRunning(w, r, running, err)
+			return
+		}
+		s.writeCausedError(w, r, "error.start_failed", err)
 		return
 	}
 
+	params := map[string]interface{}{"config": config}
+	if profileName != "" {
+		params["profile"] = profileName
+	}
+	if restarted {
+		params["restart"] = true
+	}
+	s.audit(r, models.AuditActionServerStart, params)
+	if previous != nil {
+		if changes := annotations.Diff(previous.Config, config); len(changes) > 0 {
+			s.audit(r, models.AuditActionConfigChange, map[string]interface{}{"changes": changes})
+		}
+	}
+
 	// Return current status
 	s.handleGetStatus(w, r)
 }
 
+// restoreRunning starts the server again with the config it ran before a
+// restart whose new config failed to start, reporting whether it is running
+// that config again or was left stopped.
+func (s *Server) restoreRunning(w http.ResponseWriter, r *http.Request, running models.ServerConfig, err error) {
+	if restoreErr := s.manager.Start(running); restoreErr != nil {
+		log.Printf("Failed to restore the previous config after a failed restart: %v", restoreErr)
+		s.audit(r, models.AuditActionServerStop, map[string]interface{}{"restart": true})
+		s.writeCausedError(w, r, "error.restart_stopped", err)
+		return
+	}
+	s.writeCausedError(w, r, "error.restart_restored", err)
+}
+
+// restartWait bounds how long a restart waits for the old iperf processes
+// to exit and free their ports.
+const restartWait = 10 * time.Second
+
+// startChanges returns the fields that differ between the running config
+// and the one a start asks for: those that affect measurements, plus the
+// session settings, which a restart is also needed to apply.
+func startChanges(running, requested models.ServerConfig) []models.ConfigChange {
+	changes := annotations.Diff(running, requested)
+	add := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, models.ConfigChange{Field: field, From: from, To: to})
+		}
+	}
+	add("oneOff", strconv.FormatBool(running.OneOff), strconv.FormatBool(requested.OneOff))
+	add("autoRearm", strconv.FormatBool(running.AutoRearm), strconv.FormatBool(requested.AutoRearm))
+	add("idleTimeout", strconv.Itoa(running.IdleTimeout), strconv.Itoa(requested.IdleTimeout))
+	add("outputMode", string(running.OutputMode), string(requested.OutputMode))
+	return changes
+}
+
+// writeRestartRequired rejects starting a running server with a different
+// config, listing each field that differs.
+func (s *Server) writeRestartRequired(w http.ResponseWriter, r *http.Request, changes []models.ConfigChange) {
+	lang := s.lang(r)
+	fields := make([]fieldError, len(changes))
+	for i, c := range changes {
+		params := i18n.Params{"field": c.Field, "from": c.From, "to": c.To}
+		fields[i] = fieldError{Field: c.Field, Code: "server.config_differs", Message: s.i18n.Translate(lang, "server.config_differs", params)}
+	}
+	reason := s.i18n.Translate(lang, "server.restart_required", nil)
+	s.writeErrorResponse(w, lang, http.StatusConflict, apiError{
+		Code:    "server.already_running",
+		Message: s.i18n.Translate(lang, "error.start_failed", i18n.Params{"error": reason}),
+		Fields:  fields,
+	})
+}
+
 // handleStop stops the iPerf server.
 func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
 	if err := s.manager.Stop(); err != nil {
-		http.Error(w, fmt.Sprintf("failed to stop server: %v", err), http.StatusInternalServerError)
+		s.writeCausedError(w, r, "error.stop_failed", err)
 		return
 	}
 
+	s.audit(r, models.AuditActionServerStop, nil)
+
 	// Return current status
 	s.handleGetStatus(w, r)
 }
@@ -129,7 +697,34 @@ func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
-	clientIP := r.URL.Query().Get("clientIp")
+	filter := storage.HistoryFilter{
+		ClientIP:       r.URL.Query().Get("clientIp"),
+		QualityFlag:    models.QualityFlag(r.URL.Query().Get("qualityFlag")),
+		ExcludeFlagged: r.URL.Query().Get("excludeFlagged") == "true",
+		Source:         models.JobSource(r.URL.Query().Get("source")),
+		CorrelationID:  r.URL.Query().Get("correlationId"),
+		Country:        r.URL.Query().Get("country"),
+		Tag:            strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag"))),
+		Local:          r.URL.Query().Get("local") == "true",
+	}
+	// This instance's results have no site, so its own name selects them
+	if site := r.URL.Query().Get("site"); site != "" && site == s.originName {
+		filter.Local = true
+	} else {
+		filter.Site = site
+	}
+	if filter.QualityFlag != "" && !filter.QualityFlag.Valid() {
+		s.writeError(w, r, http.StatusBadRequest, "error.invalid_quality_flag", i18n.Params{"value": filter.QualityFlag})
+		return
+	}
+	if v := r.URL.Query().Get("asn"); v != "" {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(v), "AS"), 10, 32)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "error.invalid_asn", i18n.Params{"value": v})
+			return
+		}
+		filter.ASN = uint(asn)
+	}
 
 	// Default and max limit
 	limit := 25
@@ -150,24 +745,20 @@ func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var results []models.TestResult
-	var err error
-
-	if clientIP != "" {
-		results, err = s.storage.GetTestResultsByClientIP(clientIP, limit, offset)
-	} else {
-		results, err = s.storage.GetTestResults(limit, offset)
-	}
-
+	// Count the results the filter matches, for pagination; a poll that
+	// finds them unchanged stops here
+	etag, total, err := s.resultsETag(r.Context(), r, filter)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get history: %v", err), http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, "error.count_failed", i18n.Params{"error": err})
+		return
+	}
+	if notModified(w, r, etag) {
 		return
 	}
 
-	// Get total count
-	total, err := s.storage.GetTotalCount()
+	results, err := s.storage.QueryTestResults(r.Context(), filter, limit, offset)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get total count: %v", err), http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
 		return
 	}
 
@@ -187,17 +778,111 @@ func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleExportHistory exports all test history in CSV or JSON format.
+// handleGetResult returns the details of a single test session, including
+// the client fingerprint.
+func (s *Server) handleGetResult(w http.ResponseWriter, r *http.Request) {
+	result, err := s.storage.GetTestResult(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.result_not_found", nil)
+			return
+		}
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// historyCSVColumns is the header of a CSV history export, which an import
+// reads back.
+var historyCSVColumns = []string{
+	"id", "timestamp", "client_ip", "client_port", "protocol",
+	"duration", "bytes_transferred", "avg_bandwidth", "max_bandwidth",
+	"min_bandwidth", "retransmits", "jitter", "packet_loss", "direction",
+	"status", "error_message", "requested_duration", "quality_flags",
+	"energy_joules", "joules_per_gb", "server_port", "source",
+	"correlation_id", "country", "asn", "isp", "tags", "note", "site",
+	"node", "host_cpu_total", "remote_cpu_total",
+	"sender_bytes", "sender_bandwidth", "sender_retransmits",
+	"receiver_bytes", "receiver_bandwidth", "receiver_retransmits",
+	"p50_bandwidth", "p95_bandwidth", "p99_bandwidth",
+	"retransmits_per_gb", "goodput_ratio", "jitter_ratio",
+	"link_capacity", "utilization", "precision",
+}
+
+// sideCells returns the CSV cells of one end of a test, empty if it was not
+// reported.
+func sideCells(side *models.SideStats, u exportUnits) []string {
+	if side == nil {
+		return []string{"", "", ""}
+	}
+	retransmits := ""
+	if side.Retransmits != nil {
+		retransmits = strconv.Itoa(*side.Retransmits)
+	}
+	return []string{strconv.FormatInt(side.Bytes, 10), u.bandwidth(side.Bandwidth), retransmits}
+}
+
+// percentileCells returns the CSV cells of a result's percentiles, empty
+// if it has none.
+func percentileCells(p *models.BandwidthPercentiles, u exportUnits) []string {
+	if p == nil {
+		return []string{"", "", ""}
+	}
+	return []string{u.bandwidth(p.P50), u.bandwidth(p.P95), u.bandwidth(p.P99)}
+}
+
+// derivedCells returns the CSV cells of a result's derived metrics, each
+// empty if it was not derived.
+func derivedCells(r *models.TestResult, u exportUnits) []string {
+	cells := make([]string, 0, 3)
+	for _, v := range []*float64{r.RetransmitsPerGB, r.GoodputRatio, r.JitterRatio} {
+		cell := ""
+		if v != nil {
+			cell = u.decimal(*v)
+		}
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// capacityCells returns the CSV cells of a result's link capacity and
+// utilization, empty if its client had no declared capacity.
+func capacityCells(r *models.TestResult, u exportUnits) []string {
+	if r.LinkCapacity == nil || r.Utilization == nil {
+		return []string{"", ""}
+	}
+	return []string{u.bandwidth(*r.LinkCapacity), u.decimal(*r.Utilization)}
+}
+
+// handleExportHistory exports all test history in CSV or JSON format, with
+// bandwidths in the ?units= and numbers to the ?precision= asked for.
 func (s *Server) handleExportHistory(w http.ResponseWriter, r *http.Request) {
 	format := r.URL.Query().Get("format")
 	if format == "" {
 		format = "csv"
 	}
+	units, err := parseExportUnits(r)
+	if err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	etag, _, err := s.resultsETag(r.Context(), r, storage.HistoryFilter{})
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
 
 	// Get all results (using a large limit)
-	results, err := s.storage.GetTestResults(10000, 0)
+	results, err := s.storage.GetTestResults(r.Context(), 10000, 0)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get history: %v", err), http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
 		return
 	}
 
@@ -209,7 +894,7 @@ func (s *Server) handleExportHistory(w http.ResponseWriter, r *http.Request) {
 	case "json":
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Disposition", "attachment; filename=iperf_history.json")
-		json.NewEncoder(w).Encode(results)
+		json.NewEncoder(w).Encode(units.jsonResults(results))
 
 	case "csv":
 		fallthrough
@@ -221,12 +906,7 @@ func (s *Server) handleExportHistory(w http.ResponseWriter, r *http.Request) {
 		defer writer.Flush()
 
 		// Write header row
-		header := []string{
-			"id", "timestamp", "client_ip", "client_port", "protocol",
-			"duration", "bytes_transferred", "avg_bandwidth", "max_bandwidth",
-			"min_bandwidth", "retransmits", "jitter", "packet_loss", "direction",
-		}
-		writer.Write(header)
+		writer.Write(units.columns())
 
 		// Write data rows
 		for _, r := range results {
@@ -237,12 +917,50 @@ func (s *Server) handleExportHistory(w http.ResponseWriter, r *http.Request) {
 
 			jitter := ""
 			if r.Jitter != nil {
-				jitter = fmt.Sprintf("%.6f", *r.Jitter)
+				jitter = units.decimal(*r.Jitter)
 			}
 
 			packetLoss := ""
 			if r.PacketLoss != nil {
-				packetLoss = fmt.Sprintf("%.6f", *r.PacketLoss)
+				packetLoss = units.decimal(*r.PacketLoss)
+			}
+
+			requestedDuration := ""
+			if r.RequestedDuration != nil {
+				requestedDuration = units.decimal(*r.RequestedDuration)
+			}
+
+			energyJoules := ""
+			if r.EnergyJoules != nil {
+				energyJoules = units.decimal(*r.EnergyJoules)
+			}
+
+			joulesPerGB := ""
+			if r.JoulesPerGB != nil {
+				joulesPerGB = units.decimal(*r.JoulesPerGB)
+			}
+
+			hostCPU := ""
+			if r.HostCPUTotal != nil {
+				hostCPU = units.decimal(*r.HostCPUTotal)
+			}
+
+			remoteCPU := ""
+			if r.RemoteCPUTotal != nil {
+				remoteCPU = units.decimal(*r.RemoteCPUTotal)
+			}
+
+			var country, asn, isp string
+			if r.Geo != nil {
+				country, isp = r.Geo.Country, r.Geo.ISP
+				if r.Geo.ASN != 0 {
+					asn = strconv.FormatUint(uint64(r.Geo.ASN), 10)
+				}
+			}
+
+			flags := make([]string, len(r.QualityFlags))
+			for i, f := range r.QualityFlags {
+				flags[i] = string(f)
 			}
 
 			row := []string{
@@ -251,16 +969,41 @@ func (s *Server) handleExportHistory(w http.ResponseWriter, r *http.Request) {
 				r.ClientIP,
 				strconv.Itoa(r.ClientPort),
 				string(r.Protocol),
-				fmt.Sprintf("%.6f", r.Duration),
+				units.decimal(r.Duration),
 				strconv.FormatInt(r.BytesTransferred, 10),
-				fmt.Sprintf("%.6f", r.AvgBandwidth),
-				fmt.Sprintf("%.6f", r.MaxBandwidth),
-				fmt.Sprintf("%.6f", r.MinBandwidth),
+				units.bandwidth(r.AvgBandwidth),
+				units.bandwidth(r.MaxBandwidth),
+				units.bandwidth(r.MinBandwidth),
 				retransmits,
 				jitter,
 				packetLoss,
 				r.Direction,
+				string(r.Status),
+				r.ErrorMessage,
+				requestedDuration,
+				strings.Join(flags, ";"),
+				energyJoules,
+				joulesPerGB,
+				strconv.Itoa(r.ServerPort),
+				string(r.Source),
+				r.CorrelationID,
+				country,
+				asn,
+				isp,
+				strings.Join(r.Tags, ";"),
+				r.Note,
+				r.Site,
+				r.Node,
+				hostCPU,
+				remoteCPU,
 			}
+			row = append(row, sideCells(r.Sender, units)...)
+			row = append(row, sideCells(r.Receiver, units)...)
+			row = append(row, percentileCells(r.Percentiles, units)...)
+			row = append(row, derivedCells(&r, units)...)
+			row = append(row, capacityCells(&r, units)...)
+			row = append(row, string(r.Precision))
+			row = append(row, units.textCells(&r)...)
 			writer.Write(row)
 		}
 	}
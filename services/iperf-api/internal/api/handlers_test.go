@@ -0,0 +1,4133 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/auth"
+	"github.com/Tom-Oram/fak/backend/internal/backup"
+	"github.com/Tom-Oram/fak/backend/internal/collisions"
+	"github.com/Tom-Oram/fak/backend/internal/community"
+	"github.com/Tom-Oram/fak/backend/internal/energy"
+	"github.com/Tom-Oram/fak/backend/internal/federation"
+	"github.com/Tom-Oram/fak/backend/internal/grafana"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/linkload"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/neighbor"
+	"github.com/Tom-Oram/fak/backend/internal/netprobe"
+	"github.com/Tom-Oram/fak/backend/internal/oui"
+	"github.com/Tom-Oram/fak/backend/internal/queue"
+	"github.com/Tom-Oram/fak/backend/internal/report"
+	"github.com/Tom-Oram/fak/backend/internal/slo"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/Tom-Oram/fak/backend/internal/testutil"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func newTestServer(t *testing.T, opts ...Option) (*Server, *storage.SQLiteStorage) {
+	t.Helper()
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	s := NewServer(store, opts...)
+	t.Cleanup(func() { s.Close() })
+	return s, store
+}
+
+func seedResults(t *testing.T, store storage.Store, results ...*models.TestResult) {
+	t.Helper()
+	for _, r := range results {
+		if r.Protocol == "" {
+			r.Protocol = models.ProtocolTCP
+		}
+		if r.Direction == "" {
+			r.Direction = "upload"
+		}
+		if err := store.SaveTestResult(context.Background(), r); err != nil {
+			t.Fatalf("SaveTestResult: %v", err)
+		}
+	}
+}
+
+// decodeError decodes an error response's envelope.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("error Content-Type = %q", ct)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+	return resp.Error
+}
+
+// subscribe registers a hub client and returns the channel it receives
+// broadcast messages on.
+func subscribe(s *Server) chan []byte {
+	c := s.hub.newClient(nil, "")
+	c.send = make(chan []byte, 16)
+	s.hub.register <- c
+	return c.send
+}
+
+// nextMessage returns the next broadcast of the given type.
+func nextMessage(t *testing.T, ch chan []byte, msgType models.WSMessageType) json.RawMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-ch:
+			var msg struct {
+				Type    models.WSMessageType `json:"type"`
+				Payload json.RawMessage      `json:"payload"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type == msgType {
+				return msg.Payload
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s message", msgType)
+		}
+	}
+}
+
+type historyResponse struct {
+	Results []models.TestResult `json:"results"`
+	Total   int                 `json:"total"`
+}
+
+func getHistory(t *testing.T, s *Server, query string) historyResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/history"+query, nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/history%s: status %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var resp historyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return resp
+}
+
+func TestHandleGetHistory_QualityFilters(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{ID: "clean", Timestamp: now, ClientIP: "10.0.0.1"},
+		&models.TestResult{ID: "flagged", Timestamp: now.Add(time.Second), ClientIP: "10.0.0.1",
+			QualityFlags: []models.QualityFlag{models.QualityFlagZeroBytes}},
+	)
+
+	resp := getHistory(t, s, "?qualityFlag=zero_bytes")
+	if len(resp.Results) != 1 || resp.Results[0].ID != "flagged" {
+		t.Errorf("qualityFlag filter returned %+v", resp.Results)
+	}
+
+	resp = getHistory(t, s, "?excludeFlagged=true")
+	if len(resp.Results) != 1 || resp.Results[0].ID != "clean" {
+		t.Errorf("excludeFlagged filter returned %+v", resp.Results)
+	}
+
+	// Only known flags are accepted
+	for _, flag := range []string{"%25", "zero_", "bogus"} {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history?qualityFlag="+flag, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("qualityFlag=%s: status = %d, want 400", flag, rec.Code)
+		}
+	}
+}
+
+func TestHandleGetHistory_FilteredTotal(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{ID: "a1", Timestamp: now, ClientIP: "10.0.0.1"},
+		&models.TestResult{ID: "a2", Timestamp: now.Add(time.Second), ClientIP: "10.0.0.1"},
+		&models.TestResult{ID: "a3", Timestamp: now.Add(2 * time.Second), ClientIP: "10.0.0.1"},
+		&models.TestResult{ID: "b", Timestamp: now.Add(3 * time.Second), ClientIP: "10.0.0.2"},
+	)
+
+	// The total counts the filtered results, not the page or every row
+	for query, want := range map[string]int{
+		"?limit=1":                    4,
+		"?clientIp=10.0.0.1&limit=2":  3,
+		"?clientIp=10.0.0.2":          1,
+		"?clientIp=10.0.0.9":          0,
+		"?clientIp=10.0.0.1&offset=2": 3,
+	} {
+		if resp := getHistory(t, s, query); resp.Total != want {
+			t.Errorf("%s: total = %d, want %d", query, resp.Total, want)
+		}
+	}
+}
+
+func TestHistoryETags(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Now()
+	seedResults(t, store, &models.TestResult{ID: "a", Timestamp: now, ClientIP: "10.0.0.1"})
+	routes := s.Routes()
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/api/history?clientIp=10.0.0.1", "/api/history/export?format=json"} {
+		first := get(path, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("%s: status %d, ETag %q", path, first.Code, etag)
+		}
+		if rec := get(path, etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: unchanged status %d, want 304", path, rec.Code)
+		}
+	}
+
+	// Other queries, new results and edits each change the tag
+	etag := get("/api/history", "").Header().Get("ETag")
+	if rec := get("/api/history?limit=1", etag); rec.Code != http.StatusOK {
+		t.Errorf("other query: status %d, want 200", rec.Code)
+	}
+	seedResults(t, store, &models.TestResult{ID: "b", Timestamp: now.Add(time.Second), ClientIP: "10.0.0.2"})
+	if rec := get("/api/history", etag); rec.Code != http.StatusOK {
+		t.Errorf("after a new result: status %d, want 200", rec.Code)
+	}
+	etag = get("/api/history", "").Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodPatch, "/api/history/a", strings.NewReader(`{"note":"checked"}`))
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := get("/api/history", etag); rec.Code != http.StatusOK {
+		t.Errorf("after an edit: status %d, want 200", rec.Code)
+	}
+
+	// Responses are gzipped for clients that accept it
+	req = httptest.NewRequest(http.MethodGet, "/api/history/export", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	rows, err := csv.NewReader(zr).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Errorf("export rows = %d, %v; want a header and 2 results", len(rows), err)
+	}
+}
+
+func TestHandleManagerEvent_FlagsAndStoresResult(t *testing.T) {
+	s, store := newTestServer(t)
+
+	result := &models.TestResult{
+		ClientIP:  "10.0.0.1",
+		Protocol:  models.ProtocolTCP,
+		Direction: "upload",
+		Duration:  10,
+		Status:    models.TestStatusCompleted,
+	}
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: result})
+
+	want := []models.QualityFlag{models.QualityFlagZeroBytes, models.QualityFlagZeroMinBandwidth}
+	if len(result.QualityFlags) != len(want) {
+		t.Fatalf("QualityFlags = %v, want %v", result.QualityFlags, want)
+	}
+
+	stored, err := store.GetTestResults(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || len(stored[0].QualityFlags) != 2 {
+		t.Errorf("stored results = %+v, want one result with two flags", stored)
+	}
+}
+
+func TestFederatedRoutes_DisabledByDefault(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/federated/history", nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 without federation", rec.Code)
+	}
+}
+
+func TestHandleFederatedHistory_IncludesLocalResults(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []models.TestResult{{ID: "remote", Timestamp: time.Now().Add(-time.Hour)}},
+			"total":   1,
+		})
+	}))
+	defer peer.Close()
+
+	client := federation.NewClient([]models.Peer{{Name: "branch", URL: peer.URL}}, time.Second)
+	s, store := newTestServer(t, WithFederation(client, "hq"))
+	seedResults(t, store, &models.TestResult{ID: "local", Timestamp: time.Now(), ClientIP: "10.0.0.1"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/federated/history", nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	var resp struct {
+		Results []federation.Result `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(resp.Results))
+	}
+	if resp.Results[0].ID != "local" || resp.Results[0].Origin != "hq" {
+		t.Errorf("results[0] = %s@%s, want local@hq", resp.Results[0].ID, resp.Results[0].Origin)
+	}
+	if resp.Results[1].ID != "remote" || resp.Results[1].Origin != "branch" {
+		t.Errorf("results[1] = %s@%s, want remote@branch", resp.Results[1].ID, resp.Results[1].Origin)
+	}
+}
+
+func TestGetResultsGeoJSON(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []models.TestResult{{ID: "remote", Timestamp: time.Now(), Status: models.TestStatusCompleted, AvgBandwidth: 50}},
+			"total":   1,
+		})
+	}))
+	defer peer.Close()
+
+	london, _ := models.ParseLocation("51.5072, -0.1276")
+	client := federation.NewClient([]models.Peer{
+		{Name: "branch", URL: peer.URL, Location: &models.Location{Latitude: 48.85, Longitude: 2.35}},
+		{Name: "down", URL: "http://127.0.0.1:1", Location: &models.Location{Latitude: 52.52, Longitude: 13.4}},
+		{Name: "unplaced", URL: peer.URL},
+	}, time.Second)
+	s, store := newTestServer(t, WithFederation(client, "hq"), WithSiteLocation(london))
+	seedResults(t, store, &models.TestResult{ID: "local", Timestamp: time.Now(), Status: models.TestStatusCompleted, AvgBandwidth: 10})
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/geo/results.geojson", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/geo+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var fc featureCollection
+	if err := json.NewDecoder(rec.Body).Decode(&fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 3 {
+		t.Fatalf("collection = %+v, want 3 located sites", fc)
+	}
+	hq, branch, down := fc.Features[0], fc.Features[1], fc.Features[2]
+	if hq.Properties.Site != "hq" || !hq.Properties.Local || hq.Geometry.Coordinates != [2]float64{-0.1276, 51.5072} ||
+		hq.Properties.Tests != 1 || *hq.Properties.AvgBandwidth != 10 {
+		t.Errorf("hq = %+v", hq)
+	}
+	if branch.Properties.Site != "branch" || !branch.Properties.Reachable || *branch.Properties.AvgBandwidth != 50 {
+		t.Errorf("branch = %+v", branch)
+	}
+	if down.Properties.Reachable || down.Properties.Error == "" || down.Properties.Tests != 0 {
+		t.Errorf("down = %+v", down)
+	}
+
+	// Without coordinates there is nothing to plot
+	s, _ = newTestServer(t)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/geo/results.geojson", nil))
+	if !strings.Contains(rec.Body.String(), `"features":[]`) {
+		t.Errorf("body = %s, want an empty collection", rec.Body.String())
+	}
+}
+
+func TestAccountingEndpoints(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{Timestamp: now.Add(-time.Hour), ClientIP: "10.1.0.1", BytesTransferred: 100},
+		&models.TestResult{Timestamp: now.Add(-2 * time.Hour), ClientIP: "10.1.0.2", BytesTransferred: 50},
+		&models.TestResult{Timestamp: now.Add(-time.Hour), ClientIP: "192.168.0.1", BytesTransferred: 7},
+		&models.TestResult{Timestamp: now.Add(-60 * 24 * time.Hour), ClientIP: "10.1.0.1", BytesTransferred: 1000},
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/accounting/assignments",
+		strings.NewReader(`{"match": "10.1.0.9/16", "costCenter": "lab"}`))
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST assignment: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var saved models.CostCenterAssignment
+	if err := json.NewDecoder(rec.Body).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Match != "10.1.0.0/16" {
+		t.Errorf("saved match = %q, want normalised 10.1.0.0/16", saved.Match)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/accounting/assignments",
+		strings.NewReader(`{"match": "lab-switch", "costCenter": "lab"}`))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid match: status %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/stats/accounting", nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	var resp struct {
+		Entries []models.AccountingEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []models.AccountingEntry{
+		{CostCenter: "lab", Tests: 2, BytesTransferred: 150, Clients: 2},
+		{CostCenter: "unassigned", Tests: 1, BytesTransferred: 7, Clients: 1},
+	}
+	if len(resp.Entries) != len(want) || resp.Entries[0] != want[0] || resp.Entries[1] != want[1] {
+		t.Errorf("entries = %+v, want %+v", resp.Entries, want)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/stats/accounting?format=csv", nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "cost_center,") || !strings.HasPrefix(lines[1], "lab,") {
+		t.Errorf("csv = %q", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/stats/accounting?from=2026-02-01&to=2026-01-01", nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("inverted period: status %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/accounting/assignments/"+strconv.FormatInt(saved.ID, 10), nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE assignment: status %d, want 204", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: status %d, want 404", rec.Code)
+	}
+}
+
+func TestAlertRules_FireOnSavedResult(t *testing.T) {
+	webhook := make(chan models.Alert, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a models.Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		webhook <- a
+	}))
+	defer hook.Close()
+
+	s, _ := newTestServer(t)
+	body := `{"name": "lab link", "clientIp": "10.0.0.1", "minAvgBandwidth": 500000000, "webhookUrl": "` + hook.URL + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/alerts: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var rule models.AlertRule
+	if err := json.NewDecoder(rec.Body).Decode(&rule); err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Enabled {
+		t.Error("new rule should default to enabled")
+	}
+
+	ch := subscribe(s)
+	result := &models.TestResult{
+		ClientIP:     "10.0.0.1",
+		Protocol:     models.ProtocolTCP,
+		Direction:    "upload",
+		Duration:     10,
+		AvgBandwidth: 420e6,
+		MinBandwidth: 400e6,
+		Status:       models.TestStatusCompleted,
+	}
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: result})
+
+	var alert models.Alert
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeAlert), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.RuleID != rule.ID || alert.ResultID != result.ID || len(alert.Violations) != 1 {
+		t.Errorf("alert = %+v", alert)
+	}
+
+	select {
+	case got := <-webhook:
+		if got.RuleID != rule.ID {
+			t.Errorf("webhook alert = %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/alerts/"+strconv.FormatInt(rule.ID, 10),
+		strings.NewReader(`{"name": "lab link", "minAvgBandwidth": 100000000, "enabled": false}`))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("PUT /api/alerts/{id}: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/alerts/"+strconv.FormatInt(rule.ID, 10), nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /api/alerts/{id}: status %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/alerts/"+strconv.FormatInt(rule.ID, 10), nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET deleted rule: status %d, want 404", rec.Code)
+	}
+}
+
+func TestCreateAlertRule_RejectsInvalidRule(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{"name": "no thresholds"}`))
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
+
+func TestEmailConfigEndpoints(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/notifications/email/test", nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("test email while disabled: status %d, want 400", rec.Code)
+	}
+
+	body := `{"host": "smtp.example.com", "username": "iperf", "password": "secret",
+		"from": "iperf@example.com", "to": ["noc@example.com"]}`
+	req = httptest.NewRequest(http.MethodPut, "/api/notifications/email", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT email config: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("response leaks password: %s", rec.Body.String())
+	}
+
+	// Omitting the password keeps the stored one
+	body = `{"host": "smtp.example.com", "port": 2525, "username": "iperf",
+		"from": "iperf@example.com", "to": ["noc@example.com"]}`
+	req = httptest.NewRequest(http.MethodPut, "/api/notifications/email", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	var resp struct {
+		Port        int  `json:"port"`
+		PasswordSet bool `json:"passwordSet"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Port != 2525 || !resp.PasswordSet {
+		t.Errorf("config = %+v, want port 2525 with password kept", resp)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/notifications/email",
+		strings.NewReader(`{"host": "smtp.example.com", "from": "iperf@example.com", "to": []}`))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("config without recipients: status %d, want 400", rec.Code)
+	}
+}
+
+func TestErrorsFollowAcceptLanguage(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{"name": "x"}`))
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != "alert.threshold_required" || got.Message != "Mindestens ein Schwellenwert ist erforderlich" {
+		t.Errorf("error = %+v", got)
+	}
+	if got := rec.Header().Get("Content-Language"); got != "de" {
+		t.Errorf("Content-Language = %q, want de", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/start", strings.NewReader(`{"port": 0}`))
+	req.Header.Set("Accept-Language", "de")
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if got := decodeError(t, rec); got.Message != "Server konnte nicht gestartet werden: port: muss zwischen 1 und 65535 liegen" {
+		t.Errorf("start error = %+v", got)
+	}
+}
+
+func TestHandleGetLabels(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/labels", nil)
+	req.Header.Set("Accept-Language", "de")
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	var resp struct {
+		Language string                       `json:"language"`
+		Labels   map[string]map[string]string `json:"labels"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Language != "de" || resp.Labels["testStatus"]["aborted"] != "Abgebrochen" {
+		t.Errorf("labels = %+v", resp)
+	}
+	for group, values := range resp.Labels {
+		for value, label := range values {
+			if strings.HasPrefix(label, "label.") {
+				t.Errorf("%s.%s has no translation", group, value)
+			}
+		}
+	}
+}
+
+func TestQueueEndpoints(t *testing.T) {
+	s, _ := newTestServer(t)
+	routes := s.Routes()
+
+	// An invalid config is rejected before anything is queued
+	req := httptest.NewRequest(http.MethodPost, "/api/queue", strings.NewReader(`{"source": "ci", "config": {"port": 0}}`))
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid enqueue: status %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/queue", strings.NewReader(`{"source": "cron"}`))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown source: status %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/queue/missing", nil)
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("cancel missing: status %d, want 404", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/queue", nil)
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	var snap struct {
+		Running    *models.QueueJob         `json:"running"`
+		Pending    []models.QueueJob        `json:"pending"`
+		Priorities map[models.JobSource]int `json:"priorities"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Running != nil || len(snap.Pending) != 0 {
+		t.Errorf("queue not empty: %+v", snap)
+	}
+	if snap.Priorities[models.JobSourceAdHoc] != 30 {
+		t.Errorf("priorities = %v", snap.Priorities)
+	}
+}
+
+func TestProcessManagerFake(t *testing.T) {
+	newManager, fake := testutil.Factory()
+	s, store := newTestServer(t, WithProcessManager(newManager))
+	routes := s.Routes()
+	ch := subscribe(s)
+
+	body := `{"port": 5201, "bindAddress": "127.0.0.1", "protocol": "tcp"}`
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("start: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var status models.ServerStatusPayload
+	json.NewDecoder(rec.Body).Decode(&status)
+	if status.Status != models.ServerStatusRunning || status.ListenAddr != "127.0.0.1:5201" {
+		t.Errorf("status = %+v", status)
+	}
+	if starts := fake().Starts(); len(starts) != 1 || starts[0].Port != 5201 {
+		t.Errorf("starts = %+v", starts)
+	}
+	nextMessage(t, ch, models.WSMessageTypeServerStatus)
+	if v, _ := store.LatestConfigVersion(context.Background()); v == nil || v.Config.Port != 5201 {
+		t.Errorf("config version = %+v", v)
+	}
+
+	// Results the fake reports are saved like real ones
+	fake().Emit(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "fake-1", Timestamp: time.Now(), ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP,
+		Duration: 10, BytesTransferred: 1250000000, AvgBandwidth: 1e9, Status: models.TestStatusCompleted,
+	}})
+	if got, err := store.GetTestResult(context.Background(), "fake-1"); err != nil || got == nil || got.ClientIP != "10.0.0.1" {
+		t.Errorf("saved result = %+v, %v", got, err)
+	}
+
+	// Starting again with the same config leaves the server alone
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || len(fake().Starts()) != 1 || fake().Stops() != 0 {
+		t.Errorf("same start: status %d, starts %d, stops %d", rec.Code, len(fake().Starts()), fake().Stops())
+	}
+
+	// A different config lists what differs unless a restart is asked for
+	changed := `{"port": 5202, "bindAddress": "127.0.0.1", "protocol": "tcp", "idleTimeout": 60}`
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start", strings.NewReader(changed)))
+	got := decodeError(t, rec)
+	if rec.Code != http.StatusConflict || got.Code != "server.already_running" || len(got.Fields) != 2 ||
+		got.Fields[0].Message != "port: running 5201, requested 5202" || got.Fields[1].Field != "idleTimeout" {
+		t.Errorf("changed start: status %d, error = %+v", rec.Code, got)
+	}
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start?restart=true", strings.NewReader(`{"port": 0}`)))
+	if rec.Code != http.StatusBadRequest || fake().Stops() != 0 {
+		t.Errorf("invalid restart: status %d, stops %d; the server must keep running", rec.Code, fake().Stops())
+	}
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start?restart=true", strings.NewReader(changed)))
+	if starts := fake().Starts(); rec.Code != http.StatusOK || fake().Stops() != 1 || len(starts) != 2 || starts[1].Port != 5202 {
+		t.Errorf("restart: status %d, stops %d, starts %+v", rec.Code, fake().Stops(), starts)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/stop", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stop: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if fake().Stops() != 2 || s.manager.GetStatus() != models.ServerStatusStopped {
+		t.Errorf("stops = %d, status = %s", fake().Stops(), s.manager.GetStatus())
+	}
+}
+
+func TestStart_RestartFailure(t *testing.T) {
+	newManager, fake := testutil.Factory()
+	s, _ := newTestServer(t, WithProcessManager(newManager))
+	routes := s.Routes()
+
+	body := `{"port": 5201, "bindAddress": "127.0.0.1", "protocol": "tcp"}`
+	changed := `{"port": 5202, "bindAddress": "127.0.0.1", "protocol": "tcp"}`
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("start: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	// A new config that fails to start puts the previous one back
+	fake().FailStarts(1, errors.New("address in use"))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start?restart=true", strings.NewReader(changed)))
+	got := decodeError(t, rec)
+	if rec.Code != http.StatusInternalServerError || got.Code != "error.restart_restored" ||
+		got.Message != "failed to restart server, it is running the previous config again: address in use" {
+		t.Errorf("restored restart: status %d, error = %+v", rec.Code, got)
+	}
+	if starts := fake().Starts(); s.manager.GetStatus() != models.ServerStatusRunning || len(starts) != 2 || starts[1].Port != 5201 {
+		t.Errorf("after restored restart: status %s, starts %+v", s.manager.GetStatus(), starts)
+	}
+
+	// When the previous config fails too the response says it is stopped
+	fake().FailStarts(2, errors.New("address in use"))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/start?restart=true", strings.NewReader(changed)))
+	got = decodeError(t, rec)
+	if rec.Code != http.StatusInternalServerError || got.Code != "error.restart_stopped" {
+		t.Errorf("stopped restart: status %d, error = %+v", rec.Code, got)
+	}
+	if s.manager.GetStatus() != models.ServerStatusStopped || fake().Stops() != 2 {
+		t.Errorf("after stopped restart: status %s, stops %d", s.manager.GetStatus(), fake().Stops())
+	}
+}
+
+func TestDryRunEndpoints(t *testing.T) {
+	s, store := newTestServer(t, WithManagerOptions(iperf.WithBinaryPath("sh")))
+	routes := s.Routes()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	body := fmt.Sprintf(`{"port": %d, "bindAddress": "127.0.0.1", "protocol": "tcp"}`, port)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/start?dryRun=true", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("dry run: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var report models.DryRunReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK || len(report.Commands) != 1 || report.Commands[0].Port != port {
+		t.Fatalf("report = %+v", report)
+	}
+	if argv := report.Commands[0].Argv; !strings.HasSuffix(argv[0], "/sh") || argv[1] != "-s" {
+		t.Errorf("argv = %v", argv)
+	}
+	if s.manager.GetStatus() != models.ServerStatusStopped {
+		t.Error("dry run started the server")
+	}
+	if v, _ := store.LatestConfigVersion(context.Background()); v != nil {
+		t.Errorf("dry run saved config version %+v", v)
+	}
+
+	// Failed checks are localized and reported with 422
+	req = httptest.NewRequest(http.MethodPost, "/api/start?dryRun=true", strings.NewReader(`{"port": 0}`))
+	req.Header.Set("Accept-Language", "de")
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid dry run: status %d, want 422", rec.Code)
+	}
+	report = models.DryRunReport{}
+	json.NewDecoder(rec.Body).Decode(&report)
+	if report.OK || len(report.Checks) == 0 || report.Checks[0].Error != "port: muss zwischen 1 und 65535 liegen" {
+		t.Errorf("report = %+v", report)
+	}
+
+	// Queued jobs are checked without being queued
+	req = httptest.NewRequest(http.MethodPost, "/api/queue?dryRun=true", strings.NewReader(`{"source": "cron"}`))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown source: status %d, want 400", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/queue?dryRun=true", strings.NewReader(`{"source": "ci", "config": `+body+`}`))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("queue dry run: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if snap := s.queue.Snapshot(); snap.Running != nil || len(snap.Pending) != 0 {
+		t.Errorf("dry run queued a job: %+v", snap)
+	}
+}
+
+func TestEnqueue_CorrelationID(t *testing.T) {
+	s, store := newTestServer(t, WithIDGenerator(func() string { return "fixed-id" }))
+	routes := s.Routes()
+	seedResults(t, store,
+		&models.TestResult{ID: "stored", Source: models.JobSourceCI, CorrelationID: "PROJ-12"},
+		&models.TestResult{ID: "other"},
+	)
+
+	// A correlation ID already on a stored result is rejected, dry run or not
+	for _, path := range []string{"/api/queue", "/api/queue?dryRun=true"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"source": "ci", "correlationId": "PROJ-12"}`))
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("%s: status %d, want 409", path, rec.Code)
+		}
+		if got := decodeError(t, rec); got.Code != "queue.correlation_in_use" || got.Message != `correlation ID "PROJ-12" is already used by source ci` {
+			t.Errorf("%s: error = %+v", path, got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/queue", strings.NewReader(`{"source": "adhoc", "correlationId": "PROJ-12"}`))
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("other source: status %d, want 202: %s", rec.Code, rec.Body)
+	}
+	var job models.QueueJob
+	json.NewDecoder(rec.Body).Decode(&job)
+	if job.ID != "fixed-id" || job.CorrelationID != "PROJ-12" {
+		t.Errorf("job = %+v, want the generated ID and correlation ID", job)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/history?source=ci&correlationId=PROJ-12", nil)
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	var history struct {
+		Results []models.TestResult `json:"results"`
+	}
+	json.NewDecoder(rec.Body).Decode(&history)
+	if len(history.Results) != 1 || history.Results[0].ID != "stored" {
+		t.Errorf("history = %+v, want only the correlated result", history.Results)
+	}
+}
+
+type fixedPower float64
+
+func (p fixedPower) Name() string { return "fixed" }
+
+func (p fixedPower) Power(context.Context) (float64, error) { return float64(p), nil }
+
+func TestHandleManagerEvent_RecordsEnergy(t *testing.T) {
+	meter := energy.NewMeter(fixedPower(50), 10*time.Millisecond)
+	s, store := newTestServer(t, WithEnergyMeter(meter))
+
+	s.handleManagerEvent(models.WSMessage{
+		Type:    models.WSMessageTypeClientConnected,
+		Payload: models.ConnectionEvent{ClientIP: "10.0.0.1", EventType: "connected"},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	result := &models.TestResult{
+		ClientIP:         "10.0.0.1",
+		Protocol:         models.ProtocolTCP,
+		Direction:        "upload",
+		Duration:         10,
+		BytesTransferred: 2e9,
+		Status:           models.TestStatusCompleted,
+	}
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: result})
+
+	stored, err := store.GetTestResults(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].EnergyJoules == nil || stored[0].JoulesPerGB == nil {
+		t.Fatalf("stored results = %+v, want energy recorded", stored)
+	}
+	if got, want := *stored[0].JoulesPerGB, *stored[0].EnergyJoules/2; got != want {
+		t.Errorf("JoulesPerGB = %v, want %v", got, want)
+	}
+	if *stored[0].EnergyJoules <= 0 {
+		t.Errorf("EnergyJoules = %v, want > 0", *stored[0].EnergyJoules)
+	}
+}
+
+func TestHandleManagerEvent_DiscardsOverlappingEnergy(t *testing.T) {
+	meter := energy.NewMeter(fixedPower(50), 10*time.Millisecond)
+	s, store := newTestServer(t, WithEnergyMeter(meter))
+
+	for i, port := range []int{5201, 5202} {
+		s.handleManagerEvent(models.WSMessage{
+			Type: models.WSMessageTypeClientConnected,
+			Payload: &models.ConnectionEvent{
+				SessionID:  "s" + strconv.Itoa(i),
+				ServerPort: port,
+				ClientIP:   "10.0.0.1",
+				EventType:  "connected",
+			},
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	for i, port := range []int{5201, 5202} {
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+			ID:               "s" + strconv.Itoa(i),
+			ServerPort:       port,
+			ClientIP:         "10.0.0.1",
+			Protocol:         models.ProtocolTCP,
+			Direction:        "upload",
+			BytesTransferred: 1e9,
+			Status:           models.TestStatusCompleted,
+		}})
+	}
+
+	stored, err := store.GetTestResults(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("stored %d results, want 2", len(stored))
+	}
+	for _, r := range stored {
+		if r.EnergyJoules != nil {
+			t.Errorf("result %s on port %d has energy %v from overlapping tests", r.ID, r.ServerPort, *r.EnergyJoules)
+		}
+	}
+}
+
+func TestSessionsPerPort(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	connect := func(session string, port int) {
+		s.handleManagerEvent(models.WSMessage{
+			Type:    models.WSMessageTypeClientConnected,
+			Payload: &models.ConnectionEvent{SessionID: session, ServerPort: port, ClientIP: "10.0.0.1"},
+		})
+	}
+	connect("a", 5201)
+	connect("b", 5202)
+	if !s.isLiveSession("a") || !s.isLiveSession("b") {
+		t.Fatal("sessions on different ports should both be live")
+	}
+
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "a", ServerPort: 5201, ClientIP: "10.0.0.1", Status: models.TestStatusCompleted,
+		Protocol: models.ProtocolTCP, Direction: "upload",
+	}})
+	if s.isLiveSession("a") || !s.isLiveSession("b") {
+		t.Error("completing a result should only end its own port's session")
+	}
+
+	// A new client on the same port replaces a session that never completed
+	connect("c", 5202)
+	if s.isLiveSession("b") || !s.isLiveSession("c") {
+		t.Error("session b should have been replaced by c")
+	}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ports", nil))
+	var ports struct {
+		Status models.ServerStatus `json:"status"`
+		Ports  []models.PortStatus `json:"ports"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&ports); err != nil {
+		t.Fatalf("decoding ports: %v", err)
+	}
+	if ports.Status != models.ServerStatusStopped || ports.Ports == nil || len(ports.Ports) != 0 {
+		t.Errorf("ports of a stopped server = %+v, want none", ports)
+	}
+}
+
+func TestGetLive(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/live", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var live map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&live); err != nil {
+		t.Fatalf("decoding live: %v", err)
+	}
+	// Before any test the latest update is null, not missing
+	if latest, ok := live["latest"]; !ok || latest != nil {
+		t.Errorf("latest = %v, want null", live["latest"])
+	}
+	if live["averageBitsPerSecond"] != 0.0 || live["windowSeconds"] != 10.0 {
+		t.Errorf("live = %v", live)
+	}
+}
+
+func TestConfigChangesAnnotateStats(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	start := func(cfg models.ServerConfig) {
+		s.handleManagerEvent(models.WSMessage{
+			Type:    models.WSMessageTypeServerStatus,
+			Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning, Config: &cfg},
+		})
+	}
+	cfg := models.DefaultServerConfig()
+	start(cfg)
+	// One-off mode does not affect results, so it is not a new version
+	cfg.OneOff = true
+	start(cfg)
+	cfg.Allowlist = []string{"10.0.0.0/8"}
+	start(cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/annotations", nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Annotations []models.Annotation `json:"annotations"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Annotations) != 1 {
+		t.Fatalf("annotations = %+v, want one", resp.Annotations)
+	}
+	if c := resp.Annotations[0].Changes; len(c) != 1 || c[0].Field != "allowlist" || c[0].To != "10.0.0.0/8" {
+		t.Errorf("changes = %+v, want allowlist change", c)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/stats/accounting", nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	resp.Annotations = nil
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Annotations) != 1 {
+		t.Errorf("accounting annotations = %+v, want one", resp.Annotations)
+	}
+}
+
+func TestHandleGetResult(t *testing.T) {
+	s, store := newTestServer(t)
+
+	r := &models.TestResult{
+		ClientIP: "10.0.0.1",
+		Client:   &models.ClientFingerprint{Version: "3.16", Streams: 8, BlockSize: 131072},
+	}
+	seedResults(t, store, r)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/history/"+r.ID, nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var got models.TestResult
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Client == nil || got.Client.Version != "3.16" || got.Client.Streams != 8 {
+		t.Errorf("Client = %+v", got.Client)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/history/missing", nil)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing result: status %d, want 404", rec.Code)
+	}
+}
+
+func TestAuditLog_RecordsPrincipal(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/alerts",
+		strings.NewReader(`{"name": "lab link", "minAvgBandwidth": 1000}`))
+	req.Header.Set("X-API-Key", "secret-key")
+	req.RemoteAddr = "192.0.2.10:40000"
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/alerts: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A rejected request is not an action and is not audited
+	req = httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid rule: status %d, want 400", rec.Code)
+	}
+
+	getAudit := func(query string) (entries []models.AuditEntry, total int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/audit"+query, nil)
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/audit%s: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Entries []models.AuditEntry `json:"entries"`
+			Total   int                 `json:"total"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Entries, resp.Total
+	}
+
+	entries, total := getAudit("")
+	if total != 1 || len(entries) != 1 {
+		t.Fatalf("entries = %+v, want 1", entries)
+	}
+	e := entries[0]
+	if e.Action != models.AuditActionAlertRuleCreate || e.RemoteIP != "192.0.2.10" {
+		t.Errorf("entry = %+v, want alert_rule.create from 192.0.2.10", e)
+	}
+	if !strings.HasPrefix(e.APIKey, "sha256:") || strings.Contains(e.APIKey, "secret") {
+		t.Errorf("apiKey = %q, want a hash of the key", e.APIKey)
+	}
+
+	if _, total := getAudit("?principal=" + e.APIKey); total != 1 {
+		t.Errorf("principal filter total = %d, want 1", total)
+	}
+	if _, total := getAudit("?action=server.start"); total != 0 {
+		t.Errorf("action filter total = %d, want 0", total)
+	}
+}
+
+func TestDesiredStateAndDrift(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/api/drift", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("drift before declaration: status %d, want 404", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/desired-state", `{"running": true, "config": {"port": 5201, "protocol": "tcp", "oneOff": true}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("one-off desired state: status %d, want 400", rec.Code)
+	}
+
+	rec := do(http.MethodPut, "/api/desired-state", `{"running": true}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("PUT /api/desired-state: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var declared models.DesiredState
+	if err := json.NewDecoder(rec.Body).Decode(&declared); err != nil {
+		t.Fatal(err)
+	}
+	if declared.Version == 0 || declared.Config.Port != 5201 || declared.Config.IdleTimeout != 0 {
+		t.Errorf("declared = %+v, want default config without idle timeout", declared)
+	}
+
+	rec = do(http.MethodGet, "/api/drift", "")
+	var report models.DriftReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	want := models.DriftField{Field: "running", Desired: "true", Actual: "false"}
+	if report.InSync || len(report.Drift) != 1 || report.Drift[0] != want || report.DesiredVersion != declared.Version {
+		t.Errorf("report = %+v, want running drift against version %d", report, declared.Version)
+	}
+
+	// Without auto-correct, reconciling only reports
+	rec = do(http.MethodPost, "/api/drift/reconcile", "")
+	report = models.DriftReport{}
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Action != "" || s.manager.GetStatus() != models.ServerStatusStopped {
+		t.Errorf("report = %+v, want no correction", report)
+	}
+
+	do(http.MethodPut, "/api/desired-state", `{"running": false}`)
+	rec = do(http.MethodGet, "/api/drift", "")
+	report = models.DriftReport{}
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.InSync {
+		t.Errorf("report = %+v, want in sync with a stopped server", report)
+	}
+
+	rec = do(http.MethodGet, "/api/desired-state/versions", "")
+	var versions []models.DesiredState
+	if err := json.NewDecoder(rec.Body).Decode(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Running {
+		t.Errorf("versions = %+v, want 2 newest first", versions)
+	}
+}
+
+func TestRoleBasedAccess(t *testing.T) {
+	keys, err := auth.ParseKeys("viewer:view-key,operator:op-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t, WithAPIKeys(keys))
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		want   int
+	}{
+		{"health is open", http.MethodGet, "/health", "", "", http.StatusOK},
+		{"no key", http.MethodGet, "/api/status", "", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodGet, "/api/status", "wrong", "", http.StatusUnauthorized},
+		{"viewer reads status", http.MethodGet, "/api/status", "view-key", "", http.StatusOK},
+		{"viewer reads history", http.MethodGet, "/api/history", "view-key", "", http.StatusOK},
+		{"viewer cannot stop", http.MethodPost, "/api/stop", "view-key", "", http.StatusForbidden},
+		{"viewer cannot start", http.MethodPost, "/api/start", "view-key", "{}", http.StatusForbidden},
+		{"viewer cannot read audit log", http.MethodGet, "/api/audit", "view-key", "", http.StatusForbidden},
+		{"operator creates alert", http.MethodPost, "/api/alerts", "op-key", `{"name": "n", "minAvgBandwidth": 1}`, http.StatusCreated},
+		{"operator reads audit log", http.MethodGet, "/api/audit", "op-key", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.path, tt.key, tt.body)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	if rec := do(http.MethodPost, "/api/stop", "view-key", ""); !strings.Contains(rec.Body.String(), "operator") {
+		t.Errorf("forbidden body = %q, want the required role", rec.Body.String())
+	}
+
+	rec := do(http.MethodGet, "/api/auth", "view-key", "")
+	var who struct {
+		Enabled bool      `json:"enabled"`
+		Role    auth.Role `json:"role"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&who); err != nil {
+		t.Fatal(err)
+	}
+	if !who.Enabled || who.Role != auth.RoleViewer {
+		t.Errorf("GET /api/auth = %+v, want enabled viewer", who)
+	}
+
+	// Browsers cannot set headers on WebSocket upgrades, so the key may be a query parameter
+	req := httptest.NewRequest(http.MethodGet, "/ws?api_key=view-key", nil)
+	req.Header.Set("Upgrade", "websocket")
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+		t.Errorf("WebSocket with query key: status %d, want authenticated", rec.Code)
+	}
+}
+
+func TestRoleBasedAccess_OpenWithoutKeys(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth", nil)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	var who struct {
+		Enabled bool      `json:"enabled"`
+		Role    auth.Role `json:"role"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&who); err != nil {
+		t.Fatal(err)
+	}
+	if who.Enabled || who.Role != auth.RoleOperator {
+		t.Errorf("GET /api/auth = %+v, want disabled with operator access", who)
+	}
+}
+
+// waitForClients blocks until the hub has n registered clients.
+func waitForClients(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.RLock()
+		count := len(h.clients)
+		h.mu.RUnlock()
+		if count == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d WebSocket clients", n)
+}
+
+// waitForTaken waits until the hub has queued sent messages and the clients'
+// goroutines have taken every one off their queues.
+func waitForTaken(t *testing.T, h *Hub, sent int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if stats := h.Stats(); stats.Sent >= sent && stats.MaxQueued == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d messages to be taken off the queues", sent)
+}
+
+func TestSessionWebSocket(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"/ws/sessions/unknown", nil); err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown session: err = %v, want 404", err)
+	}
+
+	s.handleManagerEvent(models.WSMessage{
+		Type:    models.WSMessageTypeClientConnected,
+		Payload: &models.ConnectionEvent{SessionID: "sess-1", ClientIP: "10.0.0.1", EventType: "connected"},
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws/sessions/sess-1", nil)
+	if err != nil {
+		t.Fatalf("dial session channel: %v", err)
+	}
+	defer conn.Close()
+	waitForClients(t, s.hub, 1)
+
+	s.handleManagerEvent(models.WSMessage{
+		Type:    models.WSMessageTypeBandwidthUpdate,
+		Payload: &models.BandwidthUpdate{SessionID: "other", BitsPerSecond: 1},
+	})
+	s.handleManagerEvent(models.WSMessage{
+		Type:    models.WSMessageTypeServerStatus,
+		Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning},
+	})
+	s.handleManagerEvent(models.WSMessage{
+		Type:    models.WSMessageTypeBandwidthUpdate,
+		Payload: &models.BandwidthUpdate{SessionID: "sess-1", BitsPerSecond: 2},
+	})
+	s.handleManagerEvent(models.WSMessage{
+		Type: models.WSMessageTypeTestComplete,
+		Payload: &models.TestResult{
+			ID: "sess-1", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP,
+			Direction: "upload", Status: models.TestStatusCompleted, Duration: 10,
+		},
+	})
+
+	// A hello, then only the session's own events arrive, then the channel
+	// closes
+	var got []models.WSMessageType
+	for {
+		var msg struct {
+			Type models.WSMessageType `json:"type"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("read: %v", err)
+			}
+			break
+		}
+		got = append(got, msg.Type)
+	}
+	want := []models.WSMessageType{models.WSMessageTypeHello, models.WSMessageTypeBandwidthUpdate, models.WSMessageTypeTestComplete}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("session messages = %v, want %v", got, want)
+	}
+
+	// An ended session replays its saved result
+	late, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws/sessions/sess-1", nil)
+	if err != nil {
+		t.Fatalf("dial ended session: %v", err)
+	}
+	defer late.Close()
+	var replay struct {
+		Type    models.WSMessageType `json:"type"`
+		Payload models.TestResult    `json:"payload"`
+	}
+	if err := late.ReadJSON(&replay); err != nil || replay.Type != models.WSMessageTypeHello {
+		t.Fatalf("read hello: %v, type %q", err, replay.Type)
+	}
+	if err := late.ReadJSON(&replay); err != nil {
+		t.Fatalf("read replay: %v", err)
+	}
+	if replay.Type != models.WSMessageTypeTestComplete || replay.Payload.ID != "sess-1" {
+		t.Errorf("replay = %+v, want the saved result", replay)
+	}
+}
+
+func TestWebSocketVersions(t *testing.T) {
+	for requested, want := range map[int]int{0: models.WSVersion2, 1: models.WSVersion1, 2: models.WSVersion2, 9: models.WSVersion2} {
+		if got := negotiateVersion(requested); got != want {
+			t.Errorf("negotiateVersion(%d) = %d, want %d", requested, got, want)
+		}
+	}
+
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+path, nil)
+		if err != nil {
+			t.Fatalf("dial %s: %v", path, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	type message struct {
+		Type    models.WSMessageType `json:"type"`
+		Version *int                 `json:"version"`
+		Payload json.RawMessage      `json:"payload"`
+	}
+	read := func(conn *websocket.Conn, typ models.WSMessageType) message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read %s: %v", typ, err)
+		}
+		if msg.Type != typ {
+			t.Fatalf("message type = %q, want %q", msg.Type, typ)
+		}
+		return msg
+	}
+	helloVersion := func(msg message) int {
+		t.Helper()
+		var hello models.WSHello
+		if err := json.Unmarshal(msg.Payload, &hello); err != nil {
+			t.Fatalf("decode hello: %v", err)
+		}
+		return hello.Version
+	}
+
+	current := dial("/ws")
+	old := dial("/ws?version=1")
+	waitForClients(t, s.hub, 2)
+
+	// Each is greeted with the version it gets; v1 messages have no version
+	if msg := read(current, models.WSMessageTypeHello); helloVersion(msg) != models.WSVersion2 || msg.Version == nil || *msg.Version != models.WSVersion2 {
+		t.Errorf("current hello = %+v, want version 2", msg)
+	}
+	if msg := read(old, models.WSMessageTypeHello); helloVersion(msg) != models.WSVersion1 || msg.Version != nil {
+		t.Errorf("v1 hello = %+v, want version 1 and no version field", msg)
+	}
+
+	status := models.WSMessage{
+		Type:    models.WSMessageTypeServerStatus,
+		Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning},
+	}
+	s.hub.Broadcast(status)
+	if msg := read(current, models.WSMessageTypeServerStatus); msg.Version == nil || *msg.Version != models.WSVersion2 {
+		t.Errorf("current status version = %v, want 2", msg.Version)
+	}
+	if msg := read(old, models.WSMessageTypeServerStatus); msg.Version != nil {
+		t.Errorf("v1 status version = %v, want none", *msg.Version)
+	}
+
+	// A hello renegotiates
+	if err := old.WriteJSON(map[string]any{"action": "hello", "version": 2}); err != nil {
+		t.Fatalf("send hello: %v", err)
+	}
+	if msg := read(old, models.WSMessageTypeHello); helloVersion(msg) != models.WSVersion2 {
+		t.Errorf("renegotiated hello = %+v, want version 2", msg)
+	}
+	s.hub.Broadcast(status)
+	if msg := read(old, models.WSMessageTypeServerStatus); msg.Version == nil || *msg.Version != models.WSVersion2 {
+		t.Errorf("renegotiated status version = %v, want 2", msg.Version)
+	}
+}
+
+func TestWebSocketEncodings(t *testing.T) {
+	s, _ := newTestServer(t, WithCompression(true))
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	packed, resp, err := dialer.Dial(wsURL+"/ws?encoding=msgpack", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer packed.Close()
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Errorf("extensions = %q, want permessage-deflate", ext)
+	}
+	plain, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer plain.Close()
+	waitForClients(t, s.hub, 2)
+
+	type message struct {
+		Type    models.WSMessageType `json:"type"`
+		Version int                  `json:"version"`
+		Payload struct {
+			SessionID     string  `json:"sessionId"`
+			BitsPerSecond float64 `json:"bitsPerSecond"`
+		} `json:"payload"`
+	}
+	read := func(conn *websocket.Conn, frame int) message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		got, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if got != frame {
+			t.Fatalf("frame type = %d, want %d", got, frame)
+		}
+		var msg message
+		if err := unmarshalCommand(frame, data, &msg); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return msg
+	}
+
+	if msg := read(packed, websocket.BinaryMessage); msg.Type != models.WSMessageTypeHello || msg.Version != models.WSVersionCurrent {
+		t.Errorf("hello = %+v", msg)
+	}
+	read(plain, websocket.TextMessage)
+
+	s.hub.Broadcast(models.WSMessage{
+		Type:    models.WSMessageTypeBandwidthUpdate,
+		Payload: &models.BandwidthUpdate{SessionID: "sess-1", BitsPerSecond: 1.5e9},
+	})
+	// Both encodings decode to the same message
+	want := read(plain, websocket.TextMessage)
+	if got := read(packed, websocket.BinaryMessage); got != want || got.Payload.BitsPerSecond != 1.5e9 {
+		t.Errorf("msgpack update = %+v, want %+v", got, want)
+	}
+
+	// Commands may be MessagePack too
+	var cmd bytes.Buffer
+	enc := msgpack.NewEncoder(&cmd)
+	if err := enc.Encode(map[string]any{"action": "hello", "version": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := packed.WriteMessage(websocket.BinaryMessage, cmd.Bytes()); err != nil {
+		t.Fatalf("send hello: %v", err)
+	}
+	if msg := read(packed, websocket.BinaryMessage); msg.Type != models.WSMessageTypeHello || msg.Version != 0 {
+		t.Errorf("renegotiated hello = %+v, want version 1", msg)
+	}
+}
+
+func TestWebSocketPause(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitForClients(t, s.hub, 1)
+
+	var msg struct {
+		Type    models.WSMessageType `json:"type"`
+		Payload json.RawMessage      `json:"payload"`
+	}
+	read := func(typ models.WSMessageType) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read %s: %v", typ, err)
+		}
+		if msg.Type != typ {
+			t.Fatalf("message type = %q, want %q", msg.Type, typ)
+		}
+	}
+	send := func(action string) {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]string{"action": action}); err != nil {
+			t.Fatalf("send %s: %v", action, err)
+		}
+	}
+	read(models.WSMessageTypeHello)
+
+	// The hello answer shows the pause before it has been handled
+	send("pause")
+	send("hello")
+	read(models.WSMessageTypeHello)
+
+	status := models.WSMessage{
+		Type:    models.WSMessageTypeServerStatus,
+		Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning},
+	}
+	s.hub.Broadcast(status)
+	s.hub.Broadcast(status)
+
+	send("resume")
+	read(models.WSMessageTypeSnapshot)
+	var snapshot models.WSSnapshot
+	if err := json.Unmarshal(msg.Payload, &snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if snapshot.Missed != 2 || snapshot.Status.Status != models.ServerStatusStopped || snapshot.Sessions == nil {
+		t.Errorf("snapshot = %+v, want 2 missed and the stopped server", snapshot)
+	}
+
+	s.hub.Broadcast(status)
+	read(models.WSMessageTypeServerStatus)
+}
+
+func TestObjectives(t *testing.T) {
+	minBW := 100.0
+	s, store := newTestServer(t, WithObjectives([]slo.Objective{
+		{Name: "branch-bw", Metric: slo.MetricAvgBandwidth, Min: &minBW, Target: 0.9, WindowDays: 7},
+	}))
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{ID: "good", Timestamp: now.Add(-time.Hour), AvgBandwidth: 150, Status: models.TestStatusCompleted},
+		&models.TestResult{ID: "slow", Timestamp: now.Add(-2 * time.Hour), AvgBandwidth: 50, Status: models.TestStatusCompleted},
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/slo/branch-bw")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/slo/branch-bw: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var report slo.Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if report.Total != 2 || report.Good != 1 || report.SLI == nil || *report.SLI != 0.5 {
+		t.Errorf("report total=%d good=%d sli=%v, want 2, 1, 0.5", report.Total, report.Good, report.SLI)
+	}
+	if len(report.Series) == 0 || report.Series[len(report.Series)-1].RollingSLI == nil {
+		t.Errorf("series = %+v, want a rolling SLI for today", report.Series)
+	}
+
+	rec = get("/api/slo/branch-bw?format=openslo")
+	var doc struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Spec       struct {
+			BudgetingMethod string `json:"budgetingMethod"`
+			Objectives      []struct {
+				Target float64 `json:"target"`
+			} `json:"objectives"`
+		} `json:"spec"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("decoding OpenSLO document: %v", err)
+	}
+	if doc.APIVersion != "openslo/v1" || doc.Kind != "SLO" || doc.Spec.BudgetingMethod != "Occurrences" ||
+		len(doc.Spec.Objectives) != 1 || doc.Spec.Objectives[0].Target != 0.9 {
+		t.Errorf("OpenSLO document = %+v", doc)
+	}
+
+	rec = get("/api/slo")
+	var list struct {
+		Objectives []slo.Report `json:"objectives"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding list: %v", err)
+	}
+	if len(list.Objectives) != 1 || list.Objectives[0].Series != nil {
+		t.Errorf("objectives = %+v, want one summary without a series", list.Objectives)
+	}
+
+	if rec := get("/api/slo/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown objective: status %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleGetStatus_Supervisor(t *testing.T) {
+	get := func(s *Server) models.ServerStatusPayload {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		var status models.ServerStatusPayload
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("decoding status: %v", err)
+		}
+		return status
+	}
+
+	plain, _ := newTestServer(t)
+	if status := get(plain); status.Supervisor != nil {
+		t.Errorf("supervisor = %+v without a restart policy", status.Supervisor)
+	}
+
+	supervised, _ := newTestServer(t, WithManagerOptions(iperf.WithRestartPolicy(iperf.RestartPolicy{MaxRetries: 3})))
+	status := get(supervised)
+	if status.Supervisor == nil || status.Supervisor.Circuit != models.CircuitClosed || status.Supervisor.MaxRetries != 3 {
+		t.Errorf("supervisor = %+v, want a closed circuit with 3 retries", status.Supervisor)
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{ID: "a", Timestamp: now.Add(-time.Hour), ServerPort: 5201, Status: models.TestStatusCompleted},
+		&models.TestResult{ID: "b", Timestamp: now.Add(-2 * time.Hour), ServerPort: 5201, Status: models.TestStatusCompleted},
+		&models.TestResult{ID: "c", Timestamp: now.Add(-3 * time.Hour), ServerPort: 5202, Status: models.TestStatusCompleted},
+	)
+
+	s.handleManagerEvent(models.WSMessage{
+		Type:    models.WSMessageTypeCollision,
+		Payload: &models.Collision{Timestamp: now.Add(-time.Hour), ServerPort: 5201, BusySessionID: "a", BusyClientIP: "10.0.0.1"},
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/stats/collisions?bucket=hour")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/stats/collisions: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var report collisions.Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if rec := get("/metrics"); !strings.Contains(rec.Body.String(), "\niperf_collisions_total 1\n") {
+		t.Errorf("metrics missing iperf_collisions_total 1:\n%s", rec.Body.String())
+	}
+	if report.Tests != 3 || report.Collisions != 1 || report.Rate == nil || *report.Rate != 0.25 {
+		t.Errorf("report tests=%d collisions=%d rate=%v, want 3, 1, 0.25", report.Tests, report.Collisions, report.Rate)
+	}
+	if report.Bucket != collisions.BucketHour || len(report.Series) < 30*24 {
+		t.Errorf("bucket %q with %d points, want hourly over 30 days", report.Bucket, len(report.Series))
+	}
+	if len(report.Ports) != 2 || report.Ports[0].Port != 5201 || report.Ports[0].Collisions != 1 {
+		t.Errorf("ports = %+v, want one collision on 5201", report.Ports)
+	}
+	if len(report.Recent) != 1 || report.Recent[0].BusyClientIP != "10.0.0.1" {
+		t.Errorf("recent = %+v, want the stored collision", report.Recent)
+	}
+
+	if rec := get("/api/stats/collisions?bucket=week"); rec.Code != http.StatusBadRequest {
+		t.Errorf("bucket=week: status %d, want 400", rec.Code)
+	}
+}
+
+func TestConfigBundle(t *testing.T) {
+	src, srcStore := newTestServer(t)
+	minBW := 100.0
+	if err := srcStore.CreateAlertRule(context.Background(), &models.AlertRule{Name: "slow", MinAvgBandwidth: &minBW, WebhookURL: "https://hooks.example.com/a", Enabled: true}); err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+	if err := srcStore.SaveCostCenterAssignment(context.Background(), &models.CostCenterAssignment{Match: "10.1.0.0/16", CostCenter: "branch"}); err != nil {
+		t.Fatalf("SaveCostCenterAssignment: %v", err)
+	}
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.Allowlist = []string{"10.1.0.0/16"}
+	if err := srcStore.SaveDesiredState(context.Background(), &models.DesiredState{Config: cfg}); err != nil {
+		t.Fatalf("SaveDesiredState: %v", err)
+	}
+	if err := srcStore.SaveProfile(context.Background(), &models.Profile{Name: "UDP lab", Config: models.DefaultServerConfig()}); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	if err := srcStore.SaveLinkCapacity(context.Background(), &models.LinkCapacity{Match: "10.1.0.5", Capacity: 1e9}); err != nil {
+		t.Fatalf("SaveLinkCapacity: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	src.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/config-bundle", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status %d: %s", rec.Code, rec.Body.String())
+	}
+	exported := rec.Body.String()
+
+	dst, dstStore := newTestServer(t)
+	if err := dstStore.CreateAlertRule(context.Background(), &models.AlertRule{Name: "replaced", Enabled: true}); err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		dst.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/admin/config-bundle", strings.NewReader(body)))
+		return rec
+	}
+
+	for name, body := range map[string]string{
+		"wrong version":   strings.Replace(exported, `"version":1`, `"version":2`, 1),
+		"invalid match":   strings.Replace(exported, `"10.1.0.0/16","costCenter"`, `"not-an-ip","costCenter"`, 1),
+		"invalid config":  strings.Replace(exported, `"port":5201`, `"port":70000`, 1),
+		"invalid profile": strings.Replace(exported, `"name":"UDP lab"`, `"name":""`, 1),
+		"bad capacity":    strings.Replace(exported, `"capacity":1000000000`, `"capacity":-1`, 1),
+	} {
+		if body == exported {
+			t.Fatalf("%s: replacement did not apply to %s", name, exported)
+		}
+		if rec := put(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+	if rules, _ := dstStore.ListAlertRules(context.Background()); len(rules) != 1 || rules[0].Name != "replaced" {
+		t.Fatalf("rules after rejected imports = %+v, want them unchanged", rules)
+	}
+
+	if rec := put(exported); rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rules, err := dstStore.ListAlertRules(context.Background())
+	if err != nil {
+		t.Fatalf("ListAlertRules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "slow" || rules[0].WebhookURL != "https://hooks.example.com/a" {
+		t.Errorf("rules = %+v, want the exported rule", rules)
+	}
+	assignments, err := dstStore.ListCostCenterAssignments(context.Background())
+	if err != nil {
+		t.Fatalf("ListCostCenterAssignments: %v", err)
+	}
+	if len(assignments) != 1 || assignments[0].CostCenter != "branch" {
+		t.Errorf("assignments = %+v, want the exported assignment", assignments)
+	}
+	desired, err := dstStore.LatestDesiredState(context.Background())
+	if err != nil {
+		t.Fatalf("LatestDesiredState: %v", err)
+	}
+	if len(desired.Config.Allowlist) != 1 || desired.Config.Allowlist[0] != "10.1.0.0/16" {
+		t.Errorf("desired state = %+v, want the exported allowlist", desired)
+	}
+	if _, err := dstStore.GetProfile(context.Background(), "UDP lab"); err != nil {
+		t.Errorf("GetProfile: %v, want the exported profile", err)
+	}
+	if capacities, err := dstStore.ListLinkCapacities(context.Background()); err != nil || len(capacities) != 1 || capacities[0].Match != "10.1.0.5" {
+		t.Errorf("capacities = %+v, %v, want the exported capacity", capacities, err)
+	}
+
+	entries, err := dstStore.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionConfigImport}, 10, 0)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("audit entries = %v, %v; want one import", entries, err)
+	}
+}
+
+func TestHandleGetStatus_Process(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	var status models.ServerStatusPayload
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	if status.Process == nil || status.Process.Processes == nil || len(status.Process.Processes) != 0 || status.Process.LastExit != nil {
+		t.Errorf("process = %+v, want diagnostics with no processes", status.Process)
+	}
+}
+
+func TestHandleGetVersion(t *testing.T) {
+	newManager, _ := testutil.Factory()
+	s, _ := newTestServer(t, WithProcessManager(newManager))
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	var version models.VersionInfo
+	if err := json.NewDecoder(rec.Body).Decode(&version); err != nil {
+		t.Fatalf("decoding version: %v", err)
+	}
+	if rec.Code != http.StatusOK || version.Version == "" || version.GoVersion == "" {
+		t.Errorf("version = %d %+v", rec.Code, version)
+	}
+	if version.Iperf.Path != "iperf3" || version.Iperf.Parser != models.IperfParserText || version.Iperf.OutputMode != models.OutputModeText {
+		t.Errorf("iperf = %+v", version.Iperf)
+	}
+}
+
+// staticTunnel reports a fixed tunnel state.
+type staticTunnel models.TunnelStatus
+
+func (t staticTunnel) Status() models.TunnelStatus { return models.TunnelStatus(t) }
+
+func TestHandleGetStatus_Tunnel(t *testing.T) {
+	getStatus := func(s *Server) models.ServerStatusPayload {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		var status models.ServerStatusPayload
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("decoding status: %v", err)
+		}
+		return status
+	}
+
+	s, _ := newTestServer(t)
+	if status := getStatus(s); status.Tunnel != nil {
+		t.Errorf("tunnel = %+v, want none without a tunnel", status.Tunnel)
+	}
+
+	s, _ = newTestServer(t, WithTunnel(staticTunnel{
+		Relay:      "wss://relay.example.com/probes",
+		ProbeID:    "branch-7",
+		State:      models.TunnelStateDisconnected,
+		LastError:  "connection refused",
+		Reconnects: 3,
+	}))
+	status := getStatus(s)
+	if status.Tunnel == nil || status.Tunnel.State != models.TunnelStateDisconnected || status.Tunnel.Reconnects != 3 {
+		t.Errorf("tunnel = %+v", status.Tunnel)
+	}
+}
+
+func TestHub_Subscribe(t *testing.T) {
+	s, _ := newTestServer(t)
+	events, unsubscribe := s.Subscribe()
+
+	s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeServerStatus})
+	select {
+	case data := <-events:
+		if !strings.Contains(string(data), `"server_status"`) {
+			t.Errorf("event = %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber received no event")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("channel still open after unsubscribe")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed after unsubscribe")
+	}
+}
+
+func TestHub_Stop(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	ch, unsubscribe := h.Subscribe()
+
+	h.Stop()
+	h.Stop()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("subscriber got a message instead of being disconnected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed after Stop")
+	}
+
+	// Nothing blocks once the hub has stopped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Broadcast(models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{Status: models.ServerStatusStopped}})
+		h.EndSession("s1")
+		unsubscribe()
+		late, _ := h.Subscribe()
+		if _, ok := <-late; ok {
+			t.Error("subscribing to a stopped hub returned an open channel")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("hub calls blocked after Stop")
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	s, store := newTestServer(t)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("reading hello: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("read after Close = %v, want a normal closure", err)
+	}
+	if _, err := store.GetTestResults(context.Background(), 1, 0); err == nil {
+		t.Error("storage still open after Close")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestHub_CoalescesBandwidthUpdates(t *testing.T) {
+	// A flush interval this long only fires via other messages
+	h := NewHub()
+	h.updateInterval = time.Hour
+	go h.Run()
+	c := h.newClient(nil, "")
+	c.send = make(chan []byte, 16)
+	h.register <- c
+
+	now := time.Now()
+	for _, u := range []*models.BandwidthUpdate{
+		{SessionID: "s1", ServerPort: 5201, Timestamp: now, IntervalStart: 0, IntervalEnd: 0.5, Bytes: 1000, BitsPerSecond: 16000},
+		{SessionID: "s1", ServerPort: 5201, Timestamp: now, IntervalStart: 0, IntervalEnd: 0.5, Bytes: 3000, BitsPerSecond: 48000},
+		{SessionID: "s2", ServerPort: 5202, Timestamp: now, IntervalStart: 0, IntervalEnd: 1, Bytes: 10, BitsPerSecond: 80},
+		{SessionID: "s1", ServerPort: 5201, Timestamp: now.Add(time.Second), IntervalStart: 0.5, IntervalEnd: 1, Bytes: 1000, BitsPerSecond: 16000},
+	} {
+		h.Broadcast(models.WSMessage{Type: models.WSMessageTypeBandwidthUpdate, Payload: u})
+	}
+	h.Broadcast(models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning}})
+
+	var first, second models.BandwidthUpdate
+	if err := json.Unmarshal(nextMessage(t, c.send, models.WSMessageTypeBandwidthUpdate), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(nextMessage(t, c.send, models.WSMessageTypeBandwidthUpdate), &second); err != nil {
+		t.Fatal(err)
+	}
+	// Streams of one interval add up; consecutive intervals widen it
+	if first.SessionID != "s1" || first.Bytes != 5000 || first.IntervalStart != 0 || first.IntervalEnd != 1 || first.BitsPerSecond != 40000 {
+		t.Errorf("s1 update = %+v, want 5000 bytes over 0-1s at 40000 bit/s", first)
+	}
+	if !first.Timestamp.Equal(now.Add(time.Second)) {
+		t.Errorf("s1 timestamp = %v, want the latest", first.Timestamp)
+	}
+	if second.SessionID != "s2" || second.Bytes != 10 || second.BitsPerSecond != 80 {
+		t.Errorf("s2 update = %+v, want it unchanged", second)
+	}
+	nextMessage(t, c.send, models.WSMessageTypeServerStatus)
+	select {
+	case data := <-c.send:
+		t.Errorf("unexpected extra message %s", data)
+	default:
+	}
+}
+
+func TestMergeUpdate_KeepsLatestSmoothed(t *testing.T) {
+	first, later := 100.0, 300.0
+	into := &models.BandwidthUpdate{IntervalStart: 0, IntervalEnd: 1, Bytes: 125, BitsPerSecond: 1000, SmoothedBitsPerSecond: &first}
+	mergeUpdate(into, &models.BandwidthUpdate{IntervalStart: 1, IntervalEnd: 2, Bytes: 375, BitsPerSecond: 3000, SmoothedBitsPerSecond: &later})
+	if into.BitsPerSecond != 2000 || into.SmoothedBitsPerSecond == nil || *into.SmoothedBitsPerSecond != later {
+		t.Errorf("merged = %+v, want 2000 bit/s smoothed to the latest %v", into, later)
+	}
+
+	// An update without a smoothed value, such as an omitted one, keeps it
+	mergeUpdate(into, &models.BandwidthUpdate{IntervalStart: 2, IntervalEnd: 3, Bytes: 0})
+	if into.SmoothedBitsPerSecond == nil || *into.SmoothedBitsPerSecond != later {
+		t.Errorf("smoothed = %v, want %v kept", into.SmoothedBitsPerSecond, later)
+	}
+}
+
+func TestWithUpdateRate_FlushesOnInterval(t *testing.T) {
+	s, _ := newTestServer(t, WithUpdateRate(20))
+	if s.hub.updateInterval != 50*time.Millisecond {
+		t.Fatalf("updateInterval = %v, want 50ms", s.hub.updateInterval)
+	}
+	ch := subscribe(s)
+
+	s.hub.Broadcast(models.WSMessage{
+		Type:    models.WSMessageTypeBandwidthUpdate,
+		Payload: &models.BandwidthUpdate{SessionID: "s1", IntervalStart: 0, IntervalEnd: 1, Bytes: 125, BitsPerSecond: 1000},
+	})
+	var u models.BandwidthUpdate
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeBandwidthUpdate), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Bytes != 125 || u.BitsPerSecond != 1000 {
+		t.Errorf("update = %+v", u)
+	}
+}
+
+func TestHandleGetCommunity(t *testing.T) {
+	shared := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]community.Aggregate{{Region: "eu", ISP: "net", Protocol: models.ProtocolTCP, Direction: "upload", Tests: 100, P50: 5e8}})
+	}))
+	defer shared.Close()
+
+	newServer := func(endpoint string) (*Server, *storage.SQLiteStorage) {
+		cfg := community.Config{Endpoint: endpoint, Region: "eu", ISP: "net", MinTests: 2}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		var s *Server
+		var store *storage.SQLiteStorage
+		s, store = newTestServer(t, WithCommunity(community.NewReporter(cfg, func(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+			return store.GetTestResultsBetween(context.Background(), from, to)
+		})))
+		return s, store
+	}
+
+	s, store := newServer(shared.URL)
+	earlier := time.Now().Add(-2 * time.Hour)
+	seedResults(t, store,
+		&models.TestResult{ID: "a", Timestamp: earlier, ClientIP: "10.0.0.1", Status: models.TestStatusCompleted, AvgBandwidth: 1e8},
+		&models.TestResult{ID: "b", Timestamp: earlier, ClientIP: "10.0.0.2", Status: models.TestStatusCompleted, AvgBandwidth: 3e8},
+	)
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/community", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Local     community.Submission  `json:"local"`
+		Community []community.Aggregate `json:"community"`
+		Error     string                `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Local.Aggregates) != 1 || body.Local.Aggregates[0].P90 != 3e8 {
+		t.Errorf("local = %+v", body.Local)
+	}
+	if len(body.Community) != 1 || body.Error != "" {
+		t.Errorf("community = %+v, error = %q", body.Community, body.Error)
+	}
+
+	// An unreachable endpoint still returns the local aggregates
+	shared.Close()
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/community", nil))
+	body.Error, body.Community = "", nil
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || body.Error == "" || body.Community != nil {
+		t.Errorf("unreachable: status %d, body %+v", rec.Code, body)
+	}
+
+	s, _ = newTestServer(t)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/community", nil))
+	if rec.Code == http.StatusOK {
+		t.Error("/api/community served without community sharing enabled")
+	}
+}
+
+func TestHub_WarnsThenDisconnectsSlowConsumer(t *testing.T) {
+	s, _ := newTestServer(t, WithClientBuffer(8))
+	events, _ := s.Subscribe()
+
+	// Nothing reads, so the subscriber's goroutine holds the first message
+	// and its queue fills behind it: a warning at 6 queued, then the tenth
+	// message overflows it. The warning goes out next, ahead of the queue.
+	status := models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning}}
+	s.hub.Broadcast(status)
+	waitForTaken(t, s.hub, 1)
+	for i := 0; i < 9; i++ {
+		s.hub.Broadcast(status)
+	}
+	waitForClients(t, s.hub, 0)
+
+	var got []models.WSMessageType
+	for data := range events {
+		var msg struct {
+			Type    models.WSMessageType       `json:"type"`
+			Payload models.SlowConsumerWarning `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.Type)
+		if msg.Type == models.WSMessageTypeSlowConsumer && (msg.Payload.Queued != 6 || msg.Payload.Capacity != 8) {
+			t.Errorf("warning = %+v, want 6 of 8 queued", msg.Payload)
+		}
+	}
+	if len(got) != 10 || got[1] != models.WSMessageTypeSlowConsumer {
+		t.Errorf("received %v, want the held message, a warning, then the 8 queued", got)
+	}
+
+	stats := s.hub.Stats()
+	want := HubStats{Capacity: 8, Sent: 9, Dropped: 1, SlowWarnings: 1, SlowDisconnects: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestHub_SlowClientHoldsUpNoOne(t *testing.T) {
+	s, _ := newTestServer(t)
+	stuck, unsubscribe := s.Subscribe()
+	events := subscribe(s)
+	waitForClients(t, s.hub, 2)
+
+	// One subscriber never reads; the other gets every message regardless
+	status := models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning}}
+	for i := 0; i < 20; i++ {
+		s.hub.Broadcast(status)
+		nextMessage(t, events, models.WSMessageTypeServerStatus)
+	}
+
+	// Unsubscribing ends the stuck goroutine without anything being read
+	unsubscribe()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-stuck:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("stuck subscriber's channel not closed after unsubscribe")
+		}
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	s, _ := newTestServer(t)
+	subscribe(s)
+	waitForClients(t, s.hub, 1)
+	s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeServerStatus})
+
+	waitForTaken(t, s.hub, 1)
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE iperf_ws_clients gauge\niperf_ws_clients 1\n",
+		"iperf_ws_queue_max 0\n",
+		fmt.Sprintf("iperf_ws_queue_capacity %d\n", DefaultClientBuffer),
+		"# TYPE iperf_ws_messages_sent_total counter\niperf_ws_messages_sent_total 1\n",
+		"iperf_ws_messages_dropped_total 0\n",
+		"iperf_ws_slow_consumer_disconnects_total 0\n",
+		"# TYPE iperf_collisions_total counter\niperf_collisions_total 0\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics missing %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestStatsRebuild(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{Timestamp: now.Add(-3 * time.Hour), ClientIP: "10.1.0.1", BytesTransferred: 100},
+		&models.TestResult{Timestamp: now.Add(-time.Hour), ClientIP: "10.1.0.2", BytesTransferred: 50},
+	)
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/stats", nil))
+	var status models.StatsCacheStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Ready {
+		t.Errorf("status = %+v, want not ready before a rebuild", status)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/admin/stats/rebuild", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rebuild: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Ready || status.Rows != 2 || status.LastRebuild == nil {
+		t.Errorf("status = %+v, want ready with 2 rows", status)
+	}
+	entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionStatsRebuild}, 10, 0)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("audit entries = %+v, %v, want one rebuild", entries, err)
+	}
+
+	// Reports read the rollup once it is ready
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/accounting", nil))
+	var resp struct {
+		Entries []models.AccountingEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := models.AccountingEntry{CostCenter: "unassigned", Tests: 2, BytesTransferred: 150, Clients: 2}
+	if len(resp.Entries) != 1 || resp.Entries[0] != want {
+		t.Errorf("entries = %+v, want %+v", resp.Entries, want)
+	}
+}
+
+func TestGetHistory_GeoFilters(t *testing.T) {
+	s, store := newTestServer(t)
+	seedResults(t, store,
+		&models.TestResult{ID: "gb", ClientIP: "81.2.69.1", Geo: &models.GeoInfo{Country: "GB", ASN: 64500, ISP: "Example Transit"}},
+		&models.TestResult{ID: "de", ClientIP: "2001:db8::1", Geo: &models.GeoInfo{Country: "DE", ASN: 64501}},
+	)
+
+	for query, want := range map[string]string{"country=de": "de", "asn=AS64500": "gb", "asn=64501": "de"} {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history?"+query, nil))
+		var history struct {
+			Results []models.TestResult `json:"results"`
+		}
+		json.NewDecoder(rec.Body).Decode(&history)
+		if len(history.Results) != 1 || history.Results[0].ID != want {
+			t.Errorf("%s: history = %+v, want %s", query, history.Results, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history?asn=transit", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid asn: status %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format=csv", nil))
+	if !strings.Contains(rec.Body.String(), ",GB,64500,Example Transit") {
+		t.Errorf("csv = %q, want the location columns", rec.Body.String())
+	}
+}
+
+func TestExportUnits(t *testing.T) {
+	s, store := newTestServer(t)
+	seedResults(t, store, &models.TestResult{
+		ID: "a", ClientIP: "10.0.0.1", Duration: 10.123456789,
+		AvgBandwidth: 941.2e6, MaxBandwidth: 1.25e9, MinBandwidth: 512,
+		Sender: &models.SideStats{Bytes: 1, Bandwidth: 2e6},
+	})
+	export := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?"+query, nil))
+		return rec
+	}
+	csvRow := func(query string) map[string]string {
+		t.Helper()
+		rec := export(query)
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil || len(rows) != 2 {
+			t.Fatalf("%s: %d rows, %v", query, len(rows), err)
+		}
+		row := make(map[string]string)
+		for i, column := range rows[0] {
+			row[column] = rows[1][i]
+		}
+		return row
+	}
+
+	// Without units the export is unchanged
+	row := csvRow("format=csv")
+	if row["avg_bandwidth"] != "941200000.000000" || row["duration"] != "10.123457" || row["avg_bandwidth_text"] != "" {
+		t.Errorf("default row = %v", row)
+	}
+
+	row = csvRow("format=csv&units=mbps&precision=2")
+	for column, want := range map[string]string{
+		"avg_bandwidth_mbps":    "941.20",
+		"max_bandwidth_mbps":    "1250.00",
+		"sender_bandwidth_mbps": "2.00",
+		"duration":              "10.12",
+		"avg_bandwidth_text":    "941.20 Mbps",
+		"min_bandwidth_text":    "0.00 Mbps",
+	} {
+		if row[column] != want {
+			t.Errorf("mbps %s = %q, want %q", column, row[column], want)
+		}
+	}
+
+	row = csvRow("format=csv&units=auto")
+	if row["avg_bandwidth"] != "941200000.000000" || row["avg_bandwidth_text"] != "941.2 Mbps" ||
+		row["max_bandwidth_text"] != "1.2 Gbps" || row["min_bandwidth_text"] != "512.0 bps" {
+		t.Errorf("auto row = %v", row)
+	}
+
+	var results []struct {
+		AvgBandwidth float64 `json:"avgBandwidth"`
+		Display      *struct {
+			AvgBandwidth string `json:"avgBandwidth"`
+		} `json:"display"`
+	}
+	json.NewDecoder(export("format=json&units=gbps&precision=3").Body).Decode(&results)
+	if len(results) != 1 || results[0].AvgBandwidth != 941.2e6 || results[0].Display == nil || results[0].Display.AvgBandwidth != "0.941 Gbps" {
+		t.Errorf("json = %+v, want full precision and the readable bandwidth", results)
+	}
+
+	for _, query := range []string{"units=furlongs", "precision=-1", "precision=13"} {
+		if rec := export(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestReportPDF(t *testing.T) {
+	s, store := newTestServer(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	seedResults(t, store,
+		&models.TestResult{ID: "a", Timestamp: day.Add(time.Hour), ClientIP: "10.0.0.1", Status: models.TestStatusCompleted, AvgBandwidth: 900e6},
+		&models.TestResult{ID: "b", Timestamp: day.Add(2 * time.Hour), ClientIP: "10.0.0.2", Status: models.TestStatusCompleted, AvgBandwidth: 400e6},
+	)
+	get := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/reports/pdf?"+query, nil))
+		return rec
+	}
+
+	for _, query := range []string{"from=2024-03-01&to=2024-03-02", "from=2024-03-01&to=2024-03-02&clientIp=10.0.0.1", "from=2024-01-01&to=2024-01-02"} {
+		rec := get(query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/pdf" {
+			t.Errorf("%s: Content-Type = %q", query, ct)
+		}
+		if !strings.HasPrefix(rec.Body.String(), "%PDF-") {
+			t.Errorf("%s: body is not a PDF", query)
+		}
+	}
+	if cd := get("from=2024-03-01&to=2024-03-02").Header().Get("Content-Disposition"); cd != "attachment; filename=iperf_report_20240301_20240302.pdf" {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	rec := get("from=2024-03-02&to=2024-03-01")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("reversed period: status = %d", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Code != "error.period_order" {
+		t.Errorf("reversed period: code = %q", e.Code)
+	}
+}
+
+func TestReportSchedule(t *testing.T) {
+	received := make(chan report.Digest, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d report.Digest
+		json.NewDecoder(r.Body).Decode(&d)
+		received <- d
+	}))
+	defer hook.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	var store storage.Store
+	results := func(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+		return store.GetTestResultsBetween(ctx, from, to)
+	}
+	cfg := report.ScheduleConfig{Period: report.Weekly}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	channel := func(url string) report.Channel {
+		c, err := report.NewWebhookChannel(url, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	s, sqlite := newTestServer(t, WithReportSchedule(report.NewScheduler(cfg, results, channel(hook.URL))))
+	store = sqlite
+	from, _ := report.Weekly.Last(time.Now())
+	seedResults(t, store,
+		&models.TestResult{ID: "a", Timestamp: from.Add(time.Hour), ClientIP: "10.0.0.1", Status: models.TestStatusCompleted, AvgBandwidth: 500e6},
+		&models.TestResult{ID: "b", Timestamp: from.Add(-time.Hour), ClientIP: "10.0.0.1", Status: models.TestStatusCompleted, AvgBandwidth: 900e6},
+	)
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/reports/schedule/send", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("send: status = %d: %s", rec.Code, rec.Body)
+	}
+	d := <-received
+	if d.Tests != 1 || len(d.Clients) != 1 || !d.Clients[0].Degraded {
+		t.Errorf("webhook received %+v", d)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/reports/schedule", nil))
+	var status report.ScheduleStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Reports != 1 || status.Period != report.Weekly || len(status.Channels) != 1 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// A failing channel is reported once the others have been sent to
+	s, _ = newTestServer(t, WithReportSchedule(report.NewScheduler(cfg, results, channel(broken.URL), channel(hook.URL))))
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/reports/schedule/send", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("broken send: status = %d", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Code != "report.send_failed" || !strings.Contains(e.Message, "503") {
+		t.Errorf("broken send: error = %+v", e)
+	}
+	<-received
+}
+
+func TestUpdateResult_TagsAndNote(t *testing.T) {
+	s, store := newTestServer(t)
+	seedResults(t, store,
+		&models.TestResult{ID: "before", ClientIP: "10.0.0.1"},
+		&models.TestResult{ID: "after", ClientIP: "10.0.0.1"},
+	)
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/api/history/"+id, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := patch("after", `{"tags": [" After-Firmware-Upgrade ", "lab_2", "lab_2", ""], "note": "fw 1.4.2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH: status %d: %s", rec.Code, rec.Body)
+	}
+	var got models.TestResult
+	json.NewDecoder(rec.Body).Decode(&got)
+	if strings.Join(got.Tags, ",") != "after-firmware-upgrade,lab_2" || got.Note != "fw 1.4.2" {
+		t.Errorf("result = %+v, want normalised tags and the note", got)
+	}
+
+	// A note alone keeps the tags
+	patch("after", `{"note": "fw 1.4.3"}`)
+	stored, _ := store.GetTestResult(context.Background(), "after")
+	if len(stored.Tags) != 2 || stored.Note != "fw 1.4.3" {
+		t.Errorf("stored = %+v, want tags kept", stored)
+	}
+	patch("before", `{"tags": ["lab2"]}`)
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history?tag=lab_2", nil))
+	var history struct {
+		Results []models.TestResult `json:"results"`
+	}
+	json.NewDecoder(rec.Body).Decode(&history)
+	if len(history.Results) != 1 || history.Results[0].ID != "after" {
+		t.Errorf("history = %+v, want only the result tagged lab_2", history.Results)
+	}
+
+	for body, want := range map[string]int{
+		`{"tags": ["has space"]}`:                                  http.StatusBadRequest,
+		`{"note": "` + strings.Repeat("x", MaxNoteLength+1) + `"}`: http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	} {
+		if rec := patch("after", body); rec.Code != want {
+			t.Errorf("PATCH %.30s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+	if rec := patch("missing", `{"note": "x"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("PATCH missing: status %d, want 404", rec.Code)
+	}
+
+	entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionResultAnnotate}, 10, 0)
+	if err != nil || len(entries) != 3 {
+		t.Errorf("audit entries = %d, %v, want 3", len(entries), err)
+	}
+}
+
+func TestNormalizeTags_Limit(t *testing.T) {
+	tags := make([]string, MaxResultTags+1)
+	for i := range tags {
+		tags[i] = strconv.Itoa(i)
+	}
+	if _, err := normalizeTags(tags); err == nil {
+		t.Errorf("normalizeTags accepted %d tags", len(tags))
+	}
+	if _, err := normalizeTags(tags[:MaxResultTags]); err != nil {
+		t.Errorf("normalizeTags(%d tags): %v", MaxResultTags, err)
+	}
+}
+
+func TestNeighborLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arp")
+	os.WriteFile(path, []byte(`IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.20     0x1         0x2         b8:27:eb:00:11:22     *        eth0
+`), 0o644)
+	s, store := newTestServer(t, WithNeighbors(neighbor.NewTable(path, oui.Builtin())))
+
+	event := &models.ConnectionEvent{ClientIP: "192.168.1.20", EventType: "connected"}
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeClientConnected, Payload: event})
+	if event.Neighbor == nil || event.Neighbor.Vendor != "Raspberry Pi Foundation" {
+		t.Errorf("event neighbor = %+v", event.Neighbor)
+	}
+
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "pi", ClientIP: "192.168.1.20", Protocol: models.ProtocolTCP, Direction: "upload", Status: models.TestStatusCompleted,
+	}})
+	stored, err := store.GetTestResult(context.Background(), "pi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Client == nil || stored.Client.MAC != "b8:27:eb:00:11:22" || stored.Client.Vendor != "Raspberry Pi Foundation" {
+		t.Errorf("stored client = %+v, want the MAC and vendor", stored.Client)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/neighbors", nil))
+	var neighbors []models.Neighbor
+	if err := json.NewDecoder(rec.Body).Decode(&neighbors); err != nil {
+		t.Fatal(err)
+	}
+	if len(neighbors) != 1 || neighbors[0].Device != "eth0" {
+		t.Errorf("neighbors = %+v", neighbors)
+	}
+
+	// The table is only served when lookup is enabled
+	s, _ = newTestServer(t)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/neighbors", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when disabled", rec.Code)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	s, store := newTestServer(t, WithManagerOptions(iperf.WithBinaryPath("sh")))
+	routes := s.Routes()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/api/profiles/UDP%20lab", `{"description": " lab bench ", "config": {"port": 5301, "protocol": "udp", "bindAddress": "127.0.0.1"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("save: status %d: %s", rec.Code, rec.Body)
+	}
+	var saved models.Profile
+	json.NewDecoder(rec.Body).Decode(&saved)
+	if saved.Name != "UDP lab" || saved.Description != "lab bench" || saved.Config.Port != 5301 || saved.CreatedAt.IsZero() {
+		t.Errorf("saved = %+v", saved)
+	}
+
+	for name, tc := range map[string]struct{ path, body string }{
+		"no config":      {"/api/profiles/a", `{}`},
+		"invalid config": {"/api/profiles/a", `{"config": {"port": 70000}}`},
+		"padded name":    {"/api/profiles/%20a", `{"config": {"port": 5201}}`},
+		"long name":      {"/api/profiles/" + strings.Repeat("a", MaxProfileNameLength+1), `{"config": {"port": 5201}}`},
+	} {
+		if rec := do(http.MethodPut, tc.path, tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+
+	rec = do(http.MethodGet, "/api/profiles", "")
+	var list struct {
+		Profiles []models.Profile `json:"profiles"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Profiles) != 1 || list.Profiles[0].Config.Protocol != models.ProtocolUDP {
+		t.Errorf("profiles = %+v, want the UDP lab profile", list.Profiles)
+	}
+
+	// Starting from a profile ignores the body
+	rec = do(http.MethodPost, "/api/start?dryRun=true&profile=UDP%20lab", "")
+	var report models.DryRunReport
+	json.NewDecoder(rec.Body).Decode(&report)
+	if len(report.Commands) != 1 || report.Commands[0].Port != 5301 {
+		t.Errorf("dry run from profile = %+v, want port 5301", report)
+	}
+	if rec := do(http.MethodPost, "/api/start?profile=missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("missing profile: status %d, want 404", rec.Code)
+	}
+
+	if rec := do(http.MethodDelete, "/api/profiles/UDP%20lab", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/profiles/UDP%20lab", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status %d, want 404", rec.Code)
+	}
+
+	for _, action := range []models.AuditAction{models.AuditActionProfileSave, models.AuditActionProfileDelete} {
+		if entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: action}, 10, 0); err != nil || len(entries) != 1 {
+			t.Errorf("%s audit entries = %v, %v; want one", action, entries, err)
+		}
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	s, _ := newTestServer(t, WithWebUI(fstest.MapFS{
+		"index.html":         {Data: []byte("<html>dashboard</html>")},
+		"assets/index-1a.js": {Data: []byte("console.log(1)")},
+	}))
+	routes := s.Routes()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	for _, path := range []string{"/", "/iperf-server", "/tools/path-tracer/run"} {
+		if rec := get(path); rec.Code != http.StatusOK || rec.Body.String() != "<html>dashboard</html>" {
+			t.Errorf("%s: status %d body %q, want the dashboard", path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := get("/assets/index-1a.js"); rec.Body.String() != "console.log(1)" {
+		t.Errorf("asset body = %q", rec.Body.String())
+	}
+
+	// The API answers at the root and under /iperf, where the dashboard looks
+	for _, path := range []string{"/api/status", "/iperf/api/status", "/iperf/health"} {
+		rec := get(path)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Type"), "json") {
+			t.Errorf("%s: status %d, Content-Type %q, want the API", path, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+	for _, path := range []string{"/api/nope", "/iperf/api/nope"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, rec.Code)
+		}
+	}
+
+	// Without a bundle only the API is served
+	s, _ = newTestServer(t)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404 without a web UI", rec.Code)
+	}
+}
+
+// busyLink reports 1 MB more in each direction on every read.
+type busyLink struct {
+	mu    sync.Mutex
+	bytes uint64
+}
+
+func (b *busyLink) Name() string { return "eth0" }
+
+func (b *busyLink) Counters(context.Context) (linkload.Counters, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes += 1_000_000
+	return linkload.Counters{RxBytes: b.bytes, TxBytes: b.bytes, SpeedBps: 1e9}, nil
+}
+
+func TestLinkDefersScheduledJobs(t *testing.T) {
+	opts := queue.DefaultOptions()
+	opts.DeferInterval = time.Hour
+	monitor := linkload.NewMonitor(&busyLink{}, 0, 10*time.Millisecond, 50)
+	s, store := newTestServer(t, WithQueueOptions(opts), WithLinkMonitor(monitor))
+	routes := s.Routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link", nil)
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	var u models.LinkUtilization
+	if err := json.NewDecoder(rec.Body).Decode(&u); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/link: status %d, %v", rec.Code, err)
+	}
+	if !u.Busy || u.Source != "eth0" || u.Threshold != 50 {
+		t.Errorf("utilization = %+v, want a busy eth0", u)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/queue", strings.NewReader(`{"source": "scheduled"}`))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	var job models.QueueJob
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatalf("enqueue: status %d, %v", rec.Code, err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		snap := s.queue.Snapshot()
+		if len(snap.Pending) == 1 && snap.Pending[0].State == models.JobStateDeferred {
+			if !strings.HasPrefix(snap.Pending[0].DeferReason, "link ") {
+				t.Errorf("DeferReason = %q", snap.Pending[0].DeferReason)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job not deferred: %+v", snap)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionQueueDefer}, 10, 0)
+	if err != nil || len(entries) != 1 || entries[0].Parameters["jobId"] != job.ID {
+		t.Errorf("audit entries = %+v, %v; want one deferral of %s", entries, err, job.ID)
+	}
+
+	// Without a monitor the endpoint is not served
+	s, _ = newTestServer(t)
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/link", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/link without a monitor: status %d, want 404", rec.Code)
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	s, store := newTestServer(t)
+	routes := s.Routes()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// Every error is reported by field, alongside the busy port
+	body := fmt.Sprintf(`{"port": %d, "bindAddress": "127.0.0.1", "idleTimeout": -1, "allowlist": ["nope", "127.0.0.1", "192.0.2.1"]}`, port)
+	req := httptest.NewRequest(http.MethodPost, "/api/validate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var report models.DryRunReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]bool{}
+	warned := map[string]bool{}
+	for _, c := range report.Checks {
+		if !c.OK {
+			failed[c.Field] = true
+			if c.Name == "port" && c.Port != port {
+				t.Errorf("failed port check = %+v", c)
+			}
+		}
+		if c.Warning != "" {
+			warned[c.Field] = true
+		}
+	}
+	if !failed["idleTimeout"] || !failed["allowlist[0]"] || !failed[""] {
+		t.Errorf("failed = %v, want idleTimeout, allowlist[0] and the port", failed)
+	}
+	if !warned["allowlist[2]"] || len(warned) != 1 {
+		t.Errorf("warned = %v, want the unreachable allowlist[2]", warned)
+	}
+	if s.manager.GetStatus() != models.ServerStatusStopped {
+		t.Error("validation started the server")
+	}
+	if entries, _ := store.QueryAuditLog(context.Background(), storage.AuditFilter{}, 10, 0); len(entries) != 0 {
+		t.Errorf("validation recorded audit entries %+v", entries)
+	}
+
+	// Warnings alone do not fail validation
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free.Close()
+	body = fmt.Sprintf(`{"port": %d, "bindAddress": "127.0.0.1", "allowlist": ["192.0.2.1"]}`, free.Addr().(*net.TCPAddr).Port)
+	req = httptest.NewRequest(http.MethodPost, "/api/validate", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("warnings only: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/validate", strings.NewReader("{"))
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad body: status %d, want 400", rec.Code)
+	}
+}
+
+func TestRequestBodies(t *testing.T) {
+	s, _ := newTestServer(t)
+	routes := s.Routes()
+	do := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	// A misspelt field is rejected, not ignored
+	rec := do("/api/start", `{"port": 5201, "protcol": "udp"}`)
+	if got := decodeError(t, rec); rec.Code != http.StatusBadRequest || got.Code != "error.invalid_body" || !strings.Contains(got.Message, `"protcol"`) {
+		t.Errorf("unknown field: status %d, error = %+v", rec.Code, got)
+	}
+	if rec := do("/api/start", `{"port": 5201} {"port": 5202}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("two values: status %d, want 400", rec.Code)
+	}
+
+	// A body over the limit is refused before it is decoded
+	huge := `{"bindAddress": "` + strings.Repeat("x", MaxBodySize) + `"}`
+	rec = do("/api/start", huge)
+	if got := decodeError(t, rec); rec.Code != http.StatusRequestEntityTooLarge || got.Code != "error.body_too_large" ||
+		got.Message != "the request body is larger than 1024 KiB" {
+		t.Errorf("huge body: status %d, error = %+v", rec.Code, got)
+	}
+	if rec := do("/api/alerts", huge); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("huge alert: status %d, want 413", rec.Code)
+	}
+	// so is one whose first value fits under it
+	if rec := do("/api/start", `{"port": 5201}`+strings.Repeat(" ", MaxBodySize)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("huge padding: status %d, want 413", rec.Code)
+	}
+
+	// An allowlist over the cap is one invalid field
+	entries := make([]string, iperf.MaxAllowlistEntries+1)
+	for i := range entries {
+		entries[i] = `"10.0.0.1"`
+	}
+	rec = do("/api/start", `{"port": 5201, "allowlist": [`+strings.Join(entries, ",")+`]}`)
+	if got := decodeError(t, rec); rec.Code != http.StatusBadRequest || len(got.Fields) != 1 || got.Fields[0].Code != "validation.allowlist_length" {
+		t.Errorf("long allowlist: status %d, error = %+v", rec.Code, got)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	s, _ := newTestServer(t)
+	routes := s.Routes()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Every invalid field is listed, and the request is rejected as invalid
+	rec := do(http.MethodPost, "/api/start", `{"port": 0, "portCount": 1, "idleTimeout": -1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid start: status %d, want 400", rec.Code)
+	}
+	got := decodeError(t, rec)
+	if got.Code != codeInvalidConfig || !strings.HasPrefix(got.Message, "failed to start server: port: ") {
+		t.Errorf("invalid start: error = %+v", got)
+	}
+	if len(got.Fields) != 2 || got.Fields[0].Field != "port" || got.Fields[0].Code != "validation.port_range" ||
+		got.Fields[1].Field != "idleTimeout" || got.Fields[1].Message != "idleTimeout: must be non-negative" {
+		t.Errorf("invalid start: fields = %+v", got.Fields)
+	}
+
+	rec = do(http.MethodPut, "/api/profiles/lab", `{"config": {"port": 70000}}`)
+	if got := decodeError(t, rec); rec.Code != http.StatusBadRequest || len(got.Fields) != 1 || got.Fields[0].Field != "port" {
+		t.Errorf("invalid profile: status %d, error = %+v", rec.Code, got)
+	}
+
+	// Stopping a stopped server conflicts with its state
+	rec = do(http.MethodPost, "/api/stop", "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("stop: status %d, want 409", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != "server.not_running" || got.Message != "failed to stop server: server is not running" || got.Fields != nil {
+		t.Errorf("stop: error = %+v", got)
+	}
+
+	rec = do(http.MethodGet, "/api/nothing", "")
+	if got := decodeError(t, rec); rec.Code != http.StatusNotFound || got.Code != "error.not_found" {
+		t.Errorf("unknown path: status %d, error = %+v", rec.Code, got)
+	}
+	rec = do(http.MethodDelete, "/api/status", "")
+	if got := decodeError(t, rec); rec.Code != http.StatusMethodNotAllowed || got.Message != "DELETE is not supported for /api/status" {
+		t.Errorf("wrong method: status %d, error = %+v", rec.Code, got)
+	}
+
+	rec = do(http.MethodPost, "/api/alerts", `{`)
+	if got := decodeError(t, rec); rec.Code != http.StatusBadRequest || got.Code != "error.invalid_body" {
+		t.Errorf("bad body: status %d, error = %+v", rec.Code, got)
+	}
+}
+
+func TestStorageFollowsRequestContext(t *testing.T) {
+	s, store := newTestServer(t)
+	seedResults(t, store, &models.TestResult{ID: "r1"})
+
+	// A client that has gone away cancels the queries made for it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/history", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("cancelled request: status %d, want 500", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200", rec.Code)
+	}
+}
+
+func TestHandleManagerEvent_StoresIntervalSamples(t *testing.T) {
+	s, _ := newTestServer(t)
+	routes := s.Routes()
+
+	connect := func(session string) {
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeClientConnected, Payload: &models.ConnectionEvent{
+			SessionID: session, ServerPort: 5201, ClientIP: "10.0.0.1", EventType: "connected",
+		}})
+	}
+	update := func(session string, i int) {
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeBandwidthUpdate, Payload: &models.BandwidthUpdate{
+			SessionID: session, ServerPort: 5201, IntervalStart: float64(i), IntervalEnd: float64(i + 1),
+			Bytes: int64(1000 * (i + 1)), BitsPerSecond: float64(8000 * (i + 1)),
+		}})
+	}
+
+	// A session that never completes leaves nothing behind when the next
+	// one starts on its port
+	connect("abandoned")
+	update("abandoned", 0)
+	connect("s1")
+	// iperf3's omit period is not part of the result
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeBandwidthUpdate, Payload: &models.BandwidthUpdate{
+		SessionID: "s1", ServerPort: 5201, IntervalEnd: 1, Bytes: 1, Omitted: true,
+	}})
+	for i := 0; i < 3; i++ {
+		update("s1", i)
+	}
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "s1", ServerPort: 5201, ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload",
+		Status: models.TestStatusCompleted,
+	}})
+	if len(s.sessionSamples) != 0 {
+		t.Errorf("samples kept after the result was saved: %v", s.sessionSamples)
+	}
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/s1/samples", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Samples []models.IntervalSample `json:"samples"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Samples) != 3 || resp.Samples[2].IntervalStart != 2 || resp.Samples[2].Bytes != 3000 {
+		t.Errorf("samples = %+v, want the session's 3 intervals", resp.Samples)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/abandoned/samples", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown result: status %d, want 404", rec.Code)
+	}
+}
+
+func TestIntervalPercentiles(t *testing.T) {
+	s, store := newTestServer(t)
+	ch := subscribe(s)
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeClientConnected, Payload: &models.ConnectionEvent{
+		SessionID: "s1", ServerPort: 5201, ClientIP: "10.0.0.1", EventType: "connected",
+	}})
+	for _, bps := range []float64{24000, 8000, 16000} {
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeBandwidthUpdate, Payload: &models.BandwidthUpdate{
+			SessionID: "s1", ServerPort: 5201, BitsPerSecond: bps,
+		}})
+	}
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "s1", ServerPort: 5201, ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload",
+		Status: models.TestStatusCompleted,
+	}})
+
+	want := models.BandwidthPercentiles{P50: 16000, P95: 24000, P99: 24000}
+	var complete models.TestResult
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeTestComplete), &complete); err != nil {
+		t.Fatal(err)
+	}
+	if complete.Percentiles == nil || *complete.Percentiles != want {
+		t.Errorf("test_complete percentiles = %+v, want %+v", complete.Percentiles, want)
+	}
+	stored, err := store.GetTestResult(context.Background(), "s1")
+	if err != nil || stored.Percentiles == nil || *stored.Percentiles != want {
+		t.Fatalf("stored percentiles = %+v, %v", stored, err)
+	}
+
+	// The CSV export has them, in the export's units, and import reads them
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format=csv&units=kbps&precision=0", nil))
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("export: %d rows, %v", len(rows), err)
+	}
+	row := make(map[string]string)
+	for i, column := range rows[0] {
+		row[column] = rows[1][i]
+	}
+	if row["p50_bandwidth_kbps"] != "16" || row["p99_bandwidth_kbps"] != "24" {
+		t.Errorf("export row = %v", row)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format=csv", nil))
+	other, otherStore := newTestServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/history/import?format=csv", rec.Body)
+	rec = httptest.NewRecorder()
+	other.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body)
+	}
+	imported, err := otherStore.GetTestResult(context.Background(), "s1")
+	if err != nil || imported.Percentiles == nil || *imported.Percentiles != want {
+		t.Errorf("imported percentiles = %+v, %v", imported, err)
+	}
+}
+
+func TestDerivedMetrics(t *testing.T) {
+	s, store := newTestServer(t, WithLinkSpeed(1e9))
+	ch := subscribe(s)
+	retransmits := 50
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "s1", ServerPort: 5201, ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload",
+		Status: models.TestStatusCompleted, Duration: 10, BytesTransferred: 1e9, AvgBandwidth: 800e6,
+		Retransmits: &retransmits,
+	}})
+
+	var complete models.TestResult
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeTestComplete), &complete); err != nil {
+		t.Fatal(err)
+	}
+	if complete.GoodputRatio == nil || *complete.GoodputRatio != 0.8 {
+		t.Errorf("test_complete goodputRatio = %v, want 0.8", complete.GoodputRatio)
+	}
+	stored, err := store.GetTestResult(context.Background(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RetransmitsPerGB == nil || *stored.RetransmitsPerGB != 50 || stored.GoodputRatio == nil || stored.JitterRatio != nil {
+		t.Errorf("stored derived = %v, %v, %v", stored.RetransmitsPerGB, stored.GoodputRatio, stored.JitterRatio)
+	}
+
+	// The CSV export has them and import keeps them
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format=csv", nil))
+	other, otherStore := newTestServer(t)
+	rec2 := httptest.NewRecorder()
+	other.Routes().ServeHTTP(rec2, httptest.NewRequest(http.MethodPost, "/api/history/import?format=csv", rec.Body))
+	if rec2.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rec2.Code, rec2.Body)
+	}
+	imported, err := otherStore.GetTestResult(context.Background(), "s1")
+	if err != nil || imported.GoodputRatio == nil || *imported.GoodputRatio != 0.8 || imported.RetransmitsPerGB == nil {
+		t.Errorf("imported = %+v, %v", imported, err)
+	}
+
+	// Alert rules can use them
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{"name": "x", "minGoodputRatio": 1.5}`)))
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "alert.goodput_ratio_range" {
+		t.Errorf("out-of-range goodput rule: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{"name": "x", "minGoodputRatio": 0.9}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create rule: status %d: %s", rec.Code, rec.Body)
+	}
+	rules, err := store.ListAlertRules(context.Background())
+	if err != nil || len(rules) != 1 || rules[0].MinGoodputRatio == nil || *rules[0].MinGoodputRatio != 0.9 {
+		t.Errorf("stored rules = %+v, %v", rules, err)
+	}
+}
+
+func TestLinkCapacities(t *testing.T) {
+	s, store := newTestServer(t)
+	save := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/capacities", strings.NewReader(body)))
+		return rec
+	}
+
+	for body, code := range map[string]string{
+		`{"match": "lab", "capacity": 1000000000}`: "accounting.invalid_match",
+		`{"match": "10.0.0.5", "capacity": 0}`:     "capacity.capacity_positive",
+	} {
+		if rec := save(body); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != code {
+			t.Errorf("POST %s: status %d: %s, want %s", body, rec.Code, rec.Body, code)
+		}
+	}
+	if rec := save(`{"match": "10.0.0.0/24", "capacity": 100000000}`); rec.Code != http.StatusOK {
+		t.Fatalf("POST subnet: status %d: %s", rec.Code, rec.Body)
+	}
+	rec := save(`{"match": "10.0.0.5", "capacity": 1000000000}`)
+	var host models.LinkCapacity
+	if err := json.NewDecoder(rec.Body).Decode(&host); err != nil || host.ID == 0 {
+		t.Fatalf("POST host: %v, %+v", err, host)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{"name": "underused", "minUtilization": 80}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create rule: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Results are measured against the most specific declaration, and the
+	// rule fires on the host that reached less than 80% of its link
+	ch := subscribe(s)
+	for id, ip := range map[string]string{"r1": "10.0.0.5", "r2": "10.0.0.9", "r3": "192.0.2.1"} {
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+			ID: id, ClientIP: ip, Protocol: models.ProtocolTCP, Direction: "upload", Duration: 10,
+			AvgBandwidth: 420e6, Status: models.TestStatusCompleted,
+		}})
+	}
+	var alert models.Alert
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeAlert), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.ClientIP != "10.0.0.5" || len(alert.Violations) != 1 || alert.Violations[0] != "utilization 42.0% of the 1000.00 Mbps link is below 80.0%" {
+		t.Errorf("alert = %+v", alert)
+	}
+	for id, want := range map[string]float64{"r1": 42, "r2": 420} {
+		r, err := store.GetTestResult(context.Background(), id)
+		if err != nil || r.Utilization == nil || *r.Utilization != want {
+			t.Errorf("%s utilization = %+v, %v, want %v", id, r, err, want)
+		}
+	}
+	if r, err := store.GetTestResult(context.Background(), "r3"); err != nil || r.Utilization != nil || r.LinkCapacity != nil {
+		t.Errorf("undeclared client = %+v, %v, want no utilization", r, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/capacities", nil))
+	var list struct {
+		Capacities []models.LinkCapacity `json:"capacities"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Capacities) != 2 {
+		t.Errorf("GET /api/capacities = %+v, %v", list, err)
+	}
+
+	path := "/api/capacities/" + strconv.FormatInt(host.ID, 10)
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rec = httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != want {
+			t.Errorf("DELETE %s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestTestSummaries(t *testing.T) {
+	complete := func(s *Server) {
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeClientConnected, Payload: &models.ConnectionEvent{
+			SessionID: "s1", ServerPort: 5201, ClientIP: "10.0.0.1", EventType: "connected",
+		}})
+		for i := 0; i < 2; i++ {
+			s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeBandwidthUpdate, Payload: &models.BandwidthUpdate{
+				SessionID: "s1", ServerPort: 5201, IntervalStart: float64(i), IntervalEnd: float64(i + 1), Bytes: 1000,
+			}})
+		}
+		s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+			ID: "s1", ServerPort: 5201, ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload",
+			Status: models.TestStatusCompleted,
+		}})
+		s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{}})
+	}
+
+	s, _ := newTestServer(t, WithTestSummaries(true))
+	ch := subscribe(s)
+	complete(s)
+	var summary models.TestSummary
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeTestSummary), &summary); err != nil {
+		t.Fatalf("decode summary: %v", err)
+	}
+	if summary.Result == nil || summary.Result.ID != "s1" || len(summary.Samples) != 2 || summary.Samples[1].IntervalStart != 1 {
+		t.Errorf("summary = %+v, want s1 with its 2 intervals", summary)
+	}
+	if sessionOf(models.WSMessage{Payload: &summary}) != "s1" {
+		t.Error("summary not part of its session")
+	}
+
+	// Off by default
+	s, _ = newTestServer(t)
+	ch = subscribe(s)
+	complete(s)
+	for {
+		var msg models.WSMessage
+		json.Unmarshal(<-ch, &msg)
+		if msg.Type == models.WSMessageTypeTestSummary {
+			t.Fatal("test_summary sent when not enabled")
+		}
+		if msg.Type == models.WSMessageTypeServerStatus {
+			break
+		}
+	}
+}
+
+func TestRawOutputArchive(t *testing.T) {
+	s, store := newTestServer(t, WithRawOutputArchive(true))
+	if len(s.managerOpts) != 1 {
+		t.Fatalf("manager options = %d, want the raw output handler", len(s.managerOpts))
+	}
+	seedResults(t, store, &models.TestResult{ID: "r1"}, &models.TestResult{ID: "r2"})
+
+	output := "Server listening on 5201\nAccepted connection from 10.0.0.1, port 50000\n"
+	s.archiveRawOutput("r1", []byte(output))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	rec := get("/api/history/r1/raw")
+	if rec.Code != http.StatusOK || rec.Body.String() != output {
+		t.Fatalf("status %d, body %q, want the archived output", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+
+	for _, id := range []string{"r2", "missing"} {
+		rec := get("/api/history/" + id + "/raw")
+		if rec.Code != http.StatusNotFound || decodeError(t, rec).Code != "error.raw_output_not_found" {
+			t.Errorf("%s: status %d: %s, want 404", id, rec.Code, rec.Body)
+		}
+	}
+
+	if s, _ := newTestServer(t); len(s.managerOpts) != 0 {
+		t.Errorf("raw output captured without the archive enabled")
+	}
+}
+
+func TestReparse(t *testing.T) {
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	output := strings.Join([]string{
+		"Server listening on 5201",
+		"Time: Fri, 30 Jan 2026 12:00:00 GMT",
+		"Accepted connection from 10.0.0.1, port 50000",
+		"[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 50001",
+		"Starting Test: protocol: TCP, 1 streams, 131072 byte blocks, omitting 0 seconds, 2 second test, tos 0",
+		"[  5]   0.00-1.00   sec   100 MBytes   839 Mbits/sec",
+		"[  5]   1.00-2.00   sec   120 MBytes  1007 Mbits/sec",
+		"- - - - - - - - - - - - - - - - - - - - - - - - -",
+		"[  5]   0.00-2.00   sec   220 MBytes   923 Mbits/sec                  receiver",
+		"",
+	}, "\n")
+
+	// Saved by an older parser that missed the minimum and the streams, and
+	// flagged from its own numbers
+	seedResults(t, store,
+		&models.TestResult{ID: "old", Timestamp: time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC), ClientIP: "10.0.0.1",
+			ClientPort: 50001, Duration: 2, BytesTransferred: 220 * 1024 * 1024, AvgBandwidth: 923e6, MaxBandwidth: 1007e6,
+			QualityFlags: []models.QualityFlag{models.QualityFlagZeroMinBandwidth, models.QualityFlagClockSkew},
+			Client:       &models.ClientFingerprint{Vendor: "Acme"}, Tags: []string{"lab"}},
+		&models.TestResult{ID: "empty"},
+		&models.TestResult{ID: "unarchived", MinBandwidth: 1},
+	)
+	s.archiveRawOutput("old", []byte(output))
+	s.archiveRawOutput("empty", []byte("Server listening on 5201\n"))
+
+	reparse := func() reparseReport {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/admin/reparse", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var report reparseReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := reparse()
+	if report.Archived != 2 || report.Changed != 1 || len(report.ChangedIDs) != 1 || report.ChangedIDs[0] != "old" || report.Unparsed != 1 {
+		t.Errorf("report = %+v, want old changed and empty unparsed", report)
+	}
+	r, err := store.GetTestResult(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.MinBandwidth != 839e6 || r.Precision != models.PrecisionRounded || r.Client == nil || r.Client.Streams != 1 || r.Client.Vendor != "Acme" {
+		t.Errorf("reparsed result = %+v client %+v, want min 839e6 and the stream count, keeping the vendor", r, r.Client)
+	}
+	if !slices.Equal(r.QualityFlags, []models.QualityFlag{models.QualityFlagClockSkew}) {
+		t.Errorf("flags = %v, want only the clock skew judged on arrival", r.QualityFlags)
+	}
+	if r.ClientIP != "10.0.0.1" || !slices.Equal(r.Tags, []string{"lab"}) {
+		t.Errorf("fields the parser does not derive changed: %+v", r)
+	}
+
+	if again := reparse(); again.Changed != 0 {
+		t.Errorf("second run changed %v, want nothing", again.ChangedIDs)
+	}
+	entries, _ := store.QueryAuditLog(ctx, storage.AuditFilter{Action: models.AuditActionResultsReparse}, 10, 0)
+	if len(entries) != 2 {
+		t.Errorf("audit entries = %d, want one per run", len(entries))
+	}
+}
+
+func TestServer_InMemoryStorage(t *testing.T) {
+	store := storage.NewMemory()
+	s := NewServer(store)
+	now := time.Now()
+	seedResults(t, store,
+		&models.TestResult{ID: "older", Timestamp: now.Add(-time.Minute), ClientIP: "10.0.0.1"},
+		&models.TestResult{ID: "newer", Timestamp: now, ClientIP: "10.0.0.2"},
+	)
+
+	resp := getHistory(t, s, "?limit=1")
+	if resp.Total != 2 || len(resp.Results) != 1 || resp.Results[0].ID != "newer" {
+		t.Errorf("history = %+v, want the newer of 2 results", resp)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown result: status %d, want 404", rec.Code)
+	}
+}
+
+func TestBackupsEndpoints(t *testing.T) {
+	target, err := backup.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := backup.Config{Keep: 1}
+	cfg.Validate()
+	var store *storage.SQLiteStorage
+	scheduler := backup.NewScheduler(cfg, func(ctx context.Context, path string) error {
+		return store.Backup(ctx, path)
+	}, target)
+	s, store := newTestServer(t, WithBackups(scheduler))
+	seedResults(t, store, &models.TestResult{ID: "r1", ClientIP: "10.0.0.1"})
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/admin/backups", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("backup: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var created backup.Object
+	json.NewDecoder(rec.Body).Decode(&created)
+	if created.Name == "" || created.Size == 0 {
+		t.Errorf("created = %+v", created)
+	}
+	entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionBackupCreate}, 10, 0)
+	if err != nil || len(entries) != 1 || entries[0].Parameters["name"] != created.Name {
+		t.Errorf("audit entries = %+v, %v, want the backup", entries, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/backups", nil))
+	var resp struct {
+		Status  backup.Status   `json:"status"`
+		Backups []backup.Object `json:"backups"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Backups) != 1 || resp.Backups[0].Name != created.Name || resp.Status.Keep != 1 ||
+		resp.Status.LastBackup == nil || resp.Status.Backups != 1 {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// Without a scheduler there is no endpoint
+	plain, _ := newTestServer(t)
+	rec = httptest.NewRecorder()
+	plain.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/backups", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without backups: status %d, want 404", rec.Code)
+	}
+}
+
+func TestImportHistory_RoundTrip(t *testing.T) {
+	retransmits, jitter, loss, hostCPU, remoteCPU := 3, 0.125, 1.5, 97.5, 12.25
+	source, store := newTestServer(t)
+	seedResults(t, store,
+		&models.TestResult{
+			ID: "r1", Timestamp: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), ClientIP: "10.0.0.1", ClientPort: 50000,
+			ServerPort: 5201, Protocol: models.ProtocolTCP, Duration: 10, BytesTransferred: 1 << 30,
+			AvgBandwidth: 858993459.2, MaxBandwidth: 9e8, MinBandwidth: 8e8, Retransmits: &retransmits,
+			Direction: "upload", Status: models.TestStatusCompleted, Source: models.JobSourceCI, CorrelationID: "build-7",
+			Geo: &models.GeoInfo{Country: "DE", ASN: 3320, ISP: "Telekom"}, Tags: []string{"lab", "wan"}, Note: "after the upgrade",
+			Node: "edge-1", HostCPUTotal: &hostCPU, RemoteCPUTotal: &remoteCPU, Precision: models.PrecisionCorrected,
+			Sender:   &models.SideStats{Bytes: 1<<30 + 4096, Bandwidth: 859e6, Retransmits: &retransmits},
+			Receiver: &models.SideStats{Bytes: 1 << 30, Bandwidth: 858993459.2},
+		},
+		&models.TestResult{
+			ID: "r2", Timestamp: time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), ClientIP: "2001:db8::1", Protocol: models.ProtocolUDP,
+			Duration: 2, Jitter: &jitter, PacketLoss: &loss, Direction: "upload", Status: models.TestStatusAborted,
+			ErrorMessage: "client went away, early", QualityFlags: []models.QualityFlag{models.QualityFlagShortDuration},
+		},
+	)
+	want, err := store.GetTestResults(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"csv", "json"} {
+		rec := httptest.NewRecorder()
+		source.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format="+format, nil))
+		exported := rec.Body.String()
+
+		s, dest := newTestServer(t)
+		for attempt, wantImported := range []int{2, 0} {
+			req := httptest.NewRequest(http.MethodPost, "/api/history/import", strings.NewReader(exported))
+			req.Header.Set("Content-Type", rec.Header().Get("Content-Type"))
+			rec := httptest.NewRecorder()
+			s.Routes().ServeHTTP(rec, req)
+			var resp importResponse
+			json.NewDecoder(rec.Body).Decode(&resp)
+			// Importing the same file again finds every result already stored
+			if rec.Code != http.StatusOK || resp.Imported != wantImported || resp.Duplicates != 2-wantImported || len(resp.Errors) != 0 {
+				t.Fatalf("%s import %d: status %d, %+v", format, attempt+1, rec.Code, resp)
+			}
+		}
+
+		got, err := dest.GetTestResults(context.Background(), 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s round trip:\n got %+v\nwant %+v", format, got, want)
+		}
+		entries, err := dest.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionHistoryImport}, 10, 0)
+		if err != nil || len(entries) != 2 || entries[1].Parameters["format"] != format {
+			t.Errorf("%s audit entries = %+v, %v", format, entries, err)
+		}
+	}
+}
+
+func TestImportHistory_ReportsRowErrors(t *testing.T) {
+	s, store := newTestServer(t)
+	seedResults(t, store, &models.TestResult{ID: "stored", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP})
+
+	body := "status,protocol,client_ip,timestamp,id,tags\n" +
+		"completed,tcp,10.0.0.2,2024-03-01T10:00:00Z,new,Lab\n" +
+		"completed,tcp,10.0.0.3,2024-03-01T10:00:00Z,new,\n" +
+		"completed,tcp,10.0.0.4,2024-03-01T10:00:00Z,stored,\n" +
+		"completed,sctp,10.0.0.5,2024-03-01T10:00:00Z,bad-protocol,\n" +
+		"completed,tcp,10.0.0.6,yesterday,bad-time,\n" +
+		"completed,tcp,,2024-03-01T10:00:00Z,no-ip,\n" +
+		"completed,tcp\n"
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/history/import?format=csv", strings.NewReader(body)))
+	var resp importResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Imported != 1 || resp.Duplicates != 2 {
+		t.Fatalf("status %d, %+v", rec.Code, resp)
+	}
+	want := []importRowError{
+		{Row: 4, ID: "bad-protocol", Field: "protocol", Code: "import.invalid_value"},
+		{Row: 5, ID: "bad-time", Field: "timestamp", Code: "import.invalid_value"},
+		{Row: 6, ID: "no-ip", Field: "client_ip", Code: "import.missing_value"},
+		{Row: 7, Code: "import.malformed_row"},
+	}
+	for i := range resp.Errors {
+		resp.Errors[i].Message = ""
+	}
+	if !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", resp.Errors, want)
+	}
+
+	imported, err := store.GetTestResult(context.Background(), "new")
+	if err != nil || imported.ClientIP != "10.0.0.2" || !reflect.DeepEqual(imported.Tags, []string{"lab"}) {
+		t.Errorf("imported = %+v, %v", imported, err)
+	}
+
+	// JSON rows are reported by their JSON field names
+	req := httptest.NewRequest(http.MethodPost, "/api/history/import",
+		strings.NewReader(`[{"id":"j1","timestamp":"2024-03-01T10:00:00Z","clientIp":"10.0.0.7","protocol":"tcp","status":"completed","duration":-1}]`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+	resp = importResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "duration" || resp.Imported != 0 {
+		t.Errorf("json response = %+v", resp)
+	}
+
+	// and CSV rows by their columns, including those of each end of a test
+	body = "id,timestamp,client_ip,protocol,status,sender_bytes,sender_bandwidth\n" +
+		"c1,2024-03-01T10:00:00Z,10.0.0.8,tcp,completed,-5,1e6\n"
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/history/import?format=csv", strings.NewReader(body)))
+	resp = importResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "sender_bytes" || resp.Imported != 0 {
+		t.Errorf("csv response = %+v", resp)
+	}
+}
+
+func TestImportHistory_RejectsFile(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, tc := range []struct {
+		query, body string
+		code        string
+	}{
+		{"?format=csv", "id,timestamp,client_ip,status\n", "error.import_missing_column"},
+		{"?format=json", `{"id":"r1"}`, "error.invalid_body"},
+		{"?format=xml", "<results/>", "error.import_format"},
+	} {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/history/import"+tc.query, strings.NewReader(tc.body)))
+		if e := decodeError(t, rec); rec.Code != http.StatusBadRequest || e.Code != tc.code {
+			t.Errorf("%s: status %d, code %s, want 400 %s", tc.query, rec.Code, e.Code, tc.code)
+		}
+	}
+}
+
+func TestPeers_RegisterAndPull(t *testing.T) {
+	branch, branchStore := newTestServer(t)
+	seedResults(t, branchStore,
+		&models.TestResult{ID: "branch-1", Timestamp: time.Now().Add(-time.Hour), ClientIP: "10.1.0.1"},
+		&models.TestResult{ID: "branch-2", Timestamp: time.Now().Add(-time.Minute), ClientIP: "10.1.0.2"},
+	)
+	branchURL := httptest.NewServer(branch.Routes())
+	defer branchURL.Close()
+
+	client := federation.NewClient([]models.Peer{{Name: "filed", URL: "http://127.0.0.1:1"}}, time.Second)
+	store := storage.NewMemory()
+	puller := federation.NewPuller(client, store, time.Hour)
+	s := NewServer(store, WithFederation(client, "hq"), WithPeerPulling(puller))
+	seedResults(t, store, &models.TestResult{ID: "hq-1", Timestamp: time.Now(), ClientIP: "10.0.0.1"})
+
+	put := func(name, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/peers/"+name, strings.NewReader(body)))
+		return rec
+	}
+	if rec := put("branch", `{"url":"`+branchURL.URL+`","apiKey":"viewer-key"}`); rec.Code != http.StatusOK {
+		t.Fatalf("register: status %d: %s", rec.Code, rec.Body.String())
+	}
+	// Replacing the peer without a key keeps the one it has
+	if rec := put("branch", `{"url":"`+branchURL.URL+`/","location":{"latitude":53.48,"longitude":-2.24}}`); rec.Code != http.StatusOK {
+		t.Fatalf("replace: status %d: %s", rec.Code, rec.Body.String())
+	}
+	for name, tc := range map[string]struct {
+		peer, body string
+		status     int
+		code       string
+	}{
+		"local name": {"hq", `{"url":"http://hq"}`, http.StatusBadRequest, "peer.invalid_name"},
+		"bad url":    {"x", `{"url":"ftp://x"}`, http.StatusBadRequest, "peer.invalid_url"},
+		"from file":  {"filed", `{"url":"http://x"}`, http.StatusConflict, "error.peer_configured"},
+	} {
+		rec := put(tc.peer, tc.body)
+		if e := decodeError(t, rec); rec.Code != tc.status || e.Code != tc.code {
+			t.Errorf("%s: status %d, code %s, want %d %s", name, rec.Code, e.Code, tc.status, tc.code)
+		}
+	}
+
+	if n, err := puller.Pull(context.Background(), client.Peers()[1]); err != nil || n != 2 {
+		t.Fatalf("Pull = %d, %v, want both branch results", n, err)
+	}
+
+	for query, want := range map[string][]string{
+		"":             {"hq-1", "branch-2", "branch-1"},
+		"?site=branch": {"branch-2", "branch-1"},
+		"?site=hq":     {"hq-1"},
+		"?local=true":  {"hq-1"},
+	} {
+		resp := getHistory(t, s, query)
+		var ids []string
+		for _, r := range resp.Results {
+			ids = append(ids, r.ID)
+			if (r.Site == "branch") != strings.HasPrefix(r.ID, "branch") {
+				t.Errorf("%s: %s has site %q", query, r.ID, r.Site)
+			}
+		}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("history%s = %v, want %v", query, ids, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/peers", nil))
+	var listed struct {
+		Peers   []peerView `json:"peers"`
+		Pulling bool       `json:"pulling"`
+	}
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if len(listed.Peers) != 2 || !listed.Pulling || listed.Peers[0].Registered || !listed.Peers[1].Registered {
+		t.Fatalf("peers = %+v", listed)
+	}
+	if got := listed.Peers[1]; got.Name != "branch" || !got.APIKeySet || got.Location == nil || got.Pull == nil || got.Pull.Pulled != 2 {
+		t.Errorf("branch = %+v", got)
+	}
+	if strings.Contains(rec.Body.String(), "viewer-key") {
+		t.Error("peer list reveals the API key")
+	}
+	entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionPeerSave}, 10, 0)
+	if err != nil || len(entries) != 2 || entries[0].Parameters["apiKeySet"] != true {
+		t.Errorf("audit entries = %+v, %v", entries, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/peers/branch", nil))
+	if rec.Code != http.StatusNoContent || len(client.Peers()) != 1 {
+		t.Errorf("delete: status %d, peers %+v", rec.Code, client.Peers())
+	}
+	// What was pulled stays
+	if resp := getHistory(t, s, "?site=branch"); len(resp.Results) != 2 {
+		t.Errorf("after delete, branch results = %+v", resp.Results)
+	}
+	rec = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/peers/branch", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d, want 404", rec.Code)
+	}
+}
+
+func TestNodeName_LabelsResultsAndMessages(t *testing.T) {
+	s, store := newTestServer(t, WithNodeName("edge-1"))
+	ch := subscribe(s)
+
+	s.handleManagerEvent(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "r1", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Status: models.TestStatusCompleted,
+	}})
+
+	var msg models.WSMessage
+	for msg.Type != models.WSMessageTypeTestComplete {
+		select {
+		case data := <-ch:
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no test_complete message")
+		}
+	}
+	if msg.Node != "edge-1" {
+		t.Errorf("message node = %q, want edge-1", msg.Node)
+	}
+
+	stored, err := store.GetTestResult(context.Background(), "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Node != "edge-1" {
+		t.Errorf("stored node = %q, want edge-1", stored.Node)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format=csv", nil))
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("export rows = %v, %v", rows, err)
+	}
+	if col := slices.Index(rows[0], "node"); col < 0 || rows[1][col] != "edge-1" {
+		t.Errorf("export header %v, row %v", rows[0], rows[1])
+	}
+}
+
+func TestLatencyProbes(t *testing.T) {
+	s, store := newTestServer(t)
+	defer s.latency.Close()
+	ch := subscribe(s)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/latency/probes", `{"target": "`+ln.Addr().String()+`", "method": "tcp", "count": 2, "interval": 0.1}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("start status = %d: %s", rec.Code, rec.Body)
+	}
+	var probe models.LatencyProbe
+	if err := json.NewDecoder(rec.Body).Decode(&probe); err != nil {
+		t.Fatal(err)
+	}
+	if probe.ID == "" || probe.Count != 2 || probe.Timeout != netprobe.DefaultTimeout {
+		t.Errorf("probe = %+v", probe)
+	}
+
+	var ping models.LatencyPing
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeLatencyPing), &ping); err != nil {
+		t.Fatal(err)
+	}
+	if ping.ProbeID != probe.ID || ping.RTT == nil {
+		t.Errorf("ping = %+v", ping)
+	}
+	// The result is sent once saved, with its ID
+	var result models.LatencyResult
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeLatencyResult), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID == 0 || result.Sent != 2 || result.Received != 2 || result.RTTAvg == nil {
+		t.Errorf("result = %+v", result)
+	}
+
+	rec = do(http.MethodGet, "/api/latency/results?target="+url.QueryEscape(ln.Addr().String()), "")
+	var stored []models.LatencyResult
+	if err := json.NewDecoder(rec.Body).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].ID != result.ID {
+		t.Errorf("stored results = %+v", stored)
+	}
+	if rec := do(http.MethodGet, "/api/latency/results?target=192.0.2.1", ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("results of an unprobed target = %s, want []", rec.Body)
+	}
+
+	// A continuous probe runs until stopped
+	rec = do(http.MethodPost, "/api/latency/probes", `{"target": "`+ln.Addr().String()+`", "method": "tcp", "count": 1, "interval": 0.1, "continuous": true}`)
+	if err := json.NewDecoder(rec.Body).Decode(&probe); err != nil {
+		t.Fatal(err)
+	}
+	var running []models.LatencyProbe
+	if err := json.NewDecoder(do(http.MethodGet, "/api/latency/probes", "").Body).Decode(&running); err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].ID != probe.ID || !running[0].Continuous {
+		t.Errorf("running probes = %+v", running)
+	}
+	if rec := do(http.MethodDelete, "/api/latency/probes/"+probe.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("stop status = %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/latency/probes/"+probe.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second stop status = %d, want 404", rec.Code)
+	}
+
+	rec = do(http.MethodPost, "/api/latency/probes", `{"target": "example.com", "method": "udp"}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "latency.invalid_method" {
+		t.Errorf("invalid method: status %d", rec.Code)
+	}
+
+	entries, err := store.QueryAuditLog(context.Background(), storage.AuditFilter{Action: models.AuditActionLatencyStart}, 10, 0)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("latency.start audit entries = %d, %v; want 2", len(entries), err)
+	}
+}
+
+func TestDiscoverMTU(t *testing.T) {
+	s, _ := newTestServer(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/tools/mtu", `{"target": "127.0.0.1", "method": "tcp"}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "mtu.invalid_method" {
+		t.Errorf("invalid method: status %d", rec.Code)
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skip("path MTU discovery needs Linux")
+	}
+	rec = do(http.MethodPost, "/api/tools/mtu", `{"target": "127.0.0.1", "method": "udp", "max": 9000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var result models.MTUResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID == 0 || result.PathMTU != 9000 || result.Method != models.ProbeMethodUDP {
+		t.Errorf("result = %+v", result)
+	}
+
+	var stored []models.MTUResult
+	if err := json.NewDecoder(do(http.MethodGet, "/api/tools/mtu?target=127.0.0.1", "").Body).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].ID != result.ID {
+		t.Errorf("stored results = %+v", stored)
+	}
+
+	rows, err := csv.NewReader(do(http.MethodGet, "/api/tools/mtu/export", "").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || !slices.Equal(rows[0], mtuCSVColumns) || rows[1][2] != "127.0.0.1" || rows[1][5] != "9000" {
+		t.Errorf("export = %v", rows)
+	}
+}
+
+func TestTraceroute(t *testing.T) {
+	s, store := newTestServer(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/tools/traceroute", `{"target": "127.0.0.1", "maxHops": 100}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "traceroute.invalid_max_hops" {
+		t.Errorf("invalid max hops: status %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/tools/traceroute", `{"resultId": "missing"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("missing result: status %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/tools/traceroute/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("missing trace: status %d", rec.Code)
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skip("traceroute needs Linux")
+	}
+	result := &models.TestResult{ID: "result-1", Timestamp: time.Now(), ClientIP: "127.0.0.1"}
+	if err := store.SaveTestResult(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	ch := subscribe(s)
+
+	// The target defaults to the result's client
+	rec = do(http.MethodPost, "/api/tools/traceroute", `{"resultId": "result-1", "queries": 1}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("start status = %d: %s", rec.Code, rec.Body)
+	}
+	var started models.Traceroute
+	if err := json.NewDecoder(rec.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	if started.ID == "" || started.Target != "127.0.0.1" || started.MaxHops != netprobe.DefaultMaxHops || started.Done {
+		t.Errorf("started = %+v", started)
+	}
+
+	var hop models.TracerouteHop
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeTracerouteHop), &hop); err != nil {
+		t.Fatal(err)
+	}
+	if hop.TraceID != started.ID || hop.TTL != 1 || hop.Address != "127.0.0.1" || len(hop.RTTs) != 1 {
+		t.Errorf("hop = %+v", hop)
+	}
+	var done models.Traceroute
+	if err := json.Unmarshal(nextMessage(t, ch, models.WSMessageTypeTracerouteDone), &done); err != nil {
+		t.Fatal(err)
+	}
+	if !done.Done || !done.Reached || len(done.Hops) != 1 || done.Error != "" {
+		t.Errorf("done = %+v", done)
+	}
+
+	var stored []models.Traceroute
+	if err := json.NewDecoder(do(http.MethodGet, "/api/tools/traceroute?resultId=result-1", "").Body).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].ID != started.ID || stored[0].Address != "127.0.0.1" {
+		t.Errorf("stored = %+v", stored)
+	}
+	if rec := do(http.MethodGet, "/api/tools/traceroute/"+started.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("get trace: status %d", rec.Code)
+	}
+}
+
+func TestGrafanaDatasource(t *testing.T) {
+	s, store := newTestServer(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, ip := range []string{"10.0.0.5", "10.0.0.6"} {
+		result := &models.TestResult{ID: fmt.Sprintf("r%d", i), Timestamp: now.Add(time.Duration(i-2) * time.Hour),
+			ClientIP: ip, Status: models.TestStatusCompleted, AvgBandwidth: float64(100 * (i + 1))}
+		if err := store.SaveTestResult(context.Background(), result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rec := do(http.MethodGet, "/api/grafana", ""); rec.Code != http.StatusOK {
+		t.Errorf("connection test: status %d", rec.Code)
+	}
+
+	var targets []string
+	if err := json.NewDecoder(do(http.MethodPost, "/api/grafana/search", `{"target": "bandwidth:10.0.0."}`).Body).Decode(&targets); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(targets, []string{"bandwidth:10.0.0.5", "bandwidth:10.0.0.6"}) {
+		t.Errorf("search = %v", targets)
+	}
+	if rec := do(http.MethodPost, "/api/grafana/search", ""); rec.Code != http.StatusOK {
+		t.Errorf("search without a body: status %d", rec.Code)
+	}
+
+	from, to := now.Add(-3*time.Hour).Format(time.RFC3339), now.Format(time.RFC3339)
+	rec := do(http.MethodPost, "/api/grafana/query", `{"range": {"from": "`+from+`", "to": "`+to+`"},
+		"targets": [{"target": "bandwidth", "refId": "A"}, {"target": "bandwidth:10.0.0.6", "refId": "B"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("query status = %d: %s", rec.Code, rec.Body)
+	}
+	var series []grafana.TimeSeries
+	if err := json.NewDecoder(rec.Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 2 || len(series[0].Datapoints) != 2 || series[0].Datapoints[0][0] != 100 ||
+		len(series[1].Datapoints) != 1 || series[1].Datapoints[0][0] != 200 {
+		t.Errorf("series = %+v", series)
+	}
+
+	rec = do(http.MethodPost, "/api/grafana/query", `{"targets": [{"target": "speed"}]}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "grafana.unknown_metric" {
+		t.Errorf("unknown metric: status %d", rec.Code)
+	}
+	rec = do(http.MethodPost, "/api/grafana/query", `{"range": {"from": "`+to+`", "to": "`+from+`"}, "targets": []}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("reversed range: status %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/grafana/annotations", `{"annotation": {"name": "config"}}`); rec.Code != http.StatusOK {
+		t.Errorf("annotations: status %d", rec.Code)
+	}
+}
+
+func TestHealth_ReportsStorage(t *testing.T) {
+	store := storage.NewReconnecting(func() (storage.Store, bool, error) {
+		return nil, false, os.ErrPermission
+	}, time.Hour, time.Hour)
+	s := NewServer(store, WithStorageStatus(store.Status))
+	t.Cleanup(func() { s.Close() })
+
+	do := func(s *Server, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// The API is up, but health fails until storage opens
+	rec := do(s, "/health")
+	var health healthResponse
+	json.NewDecoder(rec.Body).Decode(&health)
+	if rec.Code != http.StatusServiceUnavailable || health.Status != "degraded" || health.Storage == nil ||
+		health.Storage.State != models.StorageStateUnavailable || health.Storage.Error != os.ErrPermission.Error() {
+		t.Errorf("health = %d %+v", rec.Code, health)
+	}
+	if rec := do(s, "/api/status"); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 without storage", rec.Code)
+	}
+	if rec := do(s, "/api/history"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("history = %d, want 503 without storage", rec.Code)
+	}
+
+	// Read-only storage is healthy
+	ro := storage.NewReconnecting(func() (storage.Store, bool, error) { return storage.NewMemory(), true, nil }, 0, 0)
+	readOnly, _ := newTestServer(t, WithStorageStatus(ro.Status))
+	rec = do(readOnly, "/health")
+	health = healthResponse{}
+	json.NewDecoder(rec.Body).Decode(&health)
+	if rec.Code != http.StatusOK || health.Status != "ok" || health.Storage.State != models.StorageStateReadOnly {
+		t.Errorf("read-only health = %d %+v", rec.Code, health)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/accounting"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// WithTranslations replaces the message catalogs used for API responses.
+func WithTranslations(b *i18n.Bundle) Option {
+	return func(s *Server) {
+		s.i18n = b
+	}
+}
+
+// lang returns the response language negotiated from Accept-Language.
+func (s *Server) lang(r *http.Request) string {
+	return s.i18n.Match(r.Header.Get("Accept-Language"))
+}
+
+// errorResponse is the body of every API error.
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiError describes a failed request. Code is a stable identifier for
+// clients to match on, usually the message's catalog key; Message is in
+// the request's language.
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+// fieldError is a problem with one field of a submitted configuration.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// codeInvalidConfig identifies a configuration rejected for one or more
+// invalid fields.
+const codeInvalidConfig = "validation.invalid_config"
+
+// writeError sends the catalog message for key as an error in the request's
+// language, with key as its code. An internal error caused by storage that
+// is unavailable or read-only is sent as 503.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, key string, params i18n.Params) {
+	if cause, ok := params["error"].(error); ok && status == http.StatusInternalServerError &&
+		(errors.Is(cause, storage.ErrUnavailable) || errors.Is(cause, storage.ErrReadOnly)) {
+		status = http.StatusServiceUnavailable
+	}
+	lang := s.lang(r)
+	s.writeErrorResponse(w, lang, status, apiError{Code: key, Message: s.i18n.Translate(lang, key, params)})
+}
+
+// writeLocalizedError sends err as an error, translated and coded by its
+// message key when it carries one, and listing the invalid fields of a
+// rejected configuration.
+func (s *Server) writeLocalizedError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	lang := s.lang(r)
+	s.writeErrorResponse(w, lang, status, apiError{
+		Code:    errorCode(err, statusCode(status)),
+		Message: s.i18n.Localize(lang, err),
+		Fields:  s.fieldErrors(lang, err),
+	})
+}
+
+// writeCausedError reports a failed operation: the catalog message for key
+// with the cause filled in. The status and code come from the cause, so
+// clients can tell an invalid request (400) or a conflict with the
+// server's state (409) from an internal failure (500).
+func (s *Server) writeCausedError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	status := http.StatusInternalServerError
+	var invalid iperf.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, iperf.ErrAlreadyRunning), errors.Is(err, iperf.ErrNotRunning):
+		status = http.StatusConflict
+	}
+	lang := s.lang(r)
+	s.writeErrorResponse(w, lang, status, apiError{
+		Code:    errorCode(err, key),
+		Message: s.i18n.Translate(lang, key, i18n.Params{"error": s.i18n.Localize(lang, err)}),
+		Fields:  s.fieldErrors(lang, err),
+	})
+}
+
+func (s *Server) writeErrorResponse(w http.ResponseWriter, lang string, status int, e apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: e})
+}
+
+// errorCode returns err's catalog key, or fallback for errors without one.
+func errorCode(err error, fallback string) string {
+	var config iperf.ConfigErrors
+	if errors.As(err, &config) {
+		return codeInvalidConfig
+	}
+	var l i18n.Localizable
+	if errors.As(err, &l) {
+		key, _ := l.MessageKey()
+		return key
+	}
+	return fallback
+}
+
+// statusCode derives a code from an HTTP status, such as "error.bad_request".
+func statusCode(status int) string {
+	return "error." + strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// fieldErrors lists the invalid configuration fields behind err.
+func (s *Server) fieldErrors(lang string, err error) []fieldError {
+	var errs []iperf.ValidationError
+	var config iperf.ConfigErrors
+	var single iperf.ValidationError
+	switch {
+	case errors.As(err, &config):
+		errs = config
+	case errors.As(err, &single):
+		errs = []iperf.ValidationError{single}
+	}
+	fields := make([]fieldError, len(errs))
+	for i, e := range errs {
+		fields[i] = fieldError{Field: e.Field, Code: e.Key, Message: s.i18n.Localize(lang, e)}
+	}
+	return fields
+}
+
+// handleNotFound reports an unknown API path.
+func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, http.StatusNotFound, "error.not_found", i18n.Params{"path": r.URL.Path})
+}
+
+// handleMethodNotAllowed reports a method the API path does not support.
+func (s *Server) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed",
+		i18n.Params{"method": r.Method, "path": r.URL.Path})
+}
+
+// localize returns err's message in the request's language.
+func (s *Server) localize(r *http.Request, err error) string {
+	return s.i18n.Localize(s.lang(r), err)
+}
+
+// labelEnums lists the enum values with display labels, by label group.
+var labelEnums = map[string][]string{
+	"serverStatus": {
+		string(models.ServerStatusStopped),
+		string(models.ServerStatusRunning),
+		string(models.ServerStatusError),
+	},
+	"protocol": {
+		string(models.ProtocolTCP),
+		string(models.ProtocolUDP),
+	},
+	"testStatus": {
+		string(models.TestStatusCompleted),
+		string(models.TestStatusAborted),
+		string(models.TestStatusFailed),
+	},
+	"qualityFlag": {
+		string(models.QualityFlagShortDuration),
+		string(models.QualityFlagZeroBytes),
+		string(models.QualityFlagZeroMinBandwidth),
+		string(models.QualityFlagClockSkew),
+	},
+	"costCenter": {
+		accounting.Unassigned,
+	},
+	"jobSource": {
+		string(models.JobSourceAdHoc),
+		string(models.JobSourceCI),
+		string(models.JobSourceScheduled),
+	},
+	"jobState": {
+		string(models.JobStateQueued),
+		string(models.JobStateRunning),
+		string(models.JobStateCompleted),
+		string(models.JobStateFailed),
+		string(models.JobStateCancelled),
+	},
+}
+
+// handleGetLabels returns display labels for enum values in the request's
+// language, along with the languages available.
+func (s *Server) handleGetLabels(w http.ResponseWriter, r *http.Request) {
+	lang := s.lang(r)
+
+	labels := make(map[string]map[string]string, len(labelEnums))
+	for group, values := range labelEnums {
+		labels[group] = make(map[string]string, len(values))
+		for _, v := range values {
+			labels[group][v] = s.i18n.Translate(lang, "label."+group+"."+v, nil)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"language":  lang,
+		"languages": s.i18n.Languages(),
+		"labels":    labels,
+	})
+}
@@ -0,0 +1,525 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// MaxImportSize bounds the body of a history import, in bytes.
+const MaxImportSize = 64 << 20
+
+// requiredImportColumns must be in the header of an imported CSV.
+var requiredImportColumns = []string{"id", "timestamp", "client_ip", "protocol", "status"}
+
+// importRowError is a row that was not imported. Rows are counted from 1,
+// leaving out a CSV header; Field is the column or JSON field at fault.
+type importRowError struct {
+	Row     int    `json:"row"`
+	ID      string `json:"id,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// importResponse reports what an import did.
+type importResponse struct {
+	Imported int `json:"imported"`
+	// Duplicates were already stored, or repeated earlier in the file
+	Duplicates int              `json:"duplicates"`
+	Errors     []importRowError `json:"errors"`
+}
+
+// importRow is a result read from an import, or why it could not be read.
+type importRow struct {
+	result models.TestResult
+	field  string
+	err    error
+}
+
+// handleImportHistory stores results exported by this or another instance.
+// The format is ?format=csv or json, or taken from the Content-Type. Rows
+// are imported one by one: invalid rows are reported and skipped, and rows
+// whose ID is already stored are counted as duplicates.
+func (s *Server) handleImportHistory(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			format = "json"
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, MaxImportSize)
+	var rows []importRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = readCSVImport(body)
+	case "json":
+		rows, err = readJSONImport(body)
+	default:
+		s.writeError(w, r, http.StatusBadRequest, "error.import_format", i18n.Params{"format": format})
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	var localizable i18n.Localizable
+	switch {
+	case errors.As(err, &tooLarge):
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, "error.import_too_large", i18n.Params{"max": MaxImportSize >> 20})
+		return
+	case errors.As(err, &localizable):
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		s.writeError(w, r, http.StatusBadRequest, "error.invalid_body", i18n.Params{"error": err})
+		return
+	}
+
+	resp := importResponse{Errors: []importRowError{}}
+	seen := make(map[string]bool)
+	for i, row := range rows {
+		if row.err == nil {
+			row.field, row.err = checkImported(&row.result)
+			if format == "csv" {
+				row.field = columnName(row.field)
+			}
+		}
+		if row.err == nil && seen[row.result.ID] {
+			resp.Duplicates++
+			continue
+		}
+		if row.err == nil {
+			seen[row.result.ID] = true
+			var duplicate bool
+			duplicate, row.err = s.importResult(r.Context(), &row.result)
+			if duplicate {
+				resp.Duplicates++
+				continue
+			}
+		}
+		if row.err != nil {
+			resp.Errors = append(resp.Errors, importRowError{
+				Row:     i + 1,
+				ID:      row.result.ID,
+				Field:   row.field,
+				Code:    errorCode(row.err, "error.invalid_body"),
+				Message: s.localize(r, row.err),
+			})
+			continue
+		}
+		resp.Imported++
+	}
+	s.audit(r, models.AuditActionHistoryImport, map[string]interface{}{
+		"format":     format,
+		"imported":   resp.Imported,
+		"duplicates": resp.Duplicates,
+		"errors":     len(resp.Errors),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// importResult stores result unless a result with its ID already is.
+func (s *Server) importResult(ctx context.Context, result *models.TestResult) (duplicate bool, err error) {
+	_, err = s.storage.GetTestResult(ctx, result.ID)
+	switch {
+	case err == nil:
+		return true, nil
+	case !errors.Is(err, storage.ErrNotFound):
+		return false, i18n.NewError("import.save_failed", i18n.Params{"error": err})
+	}
+	if err := s.storage.SaveTestResult(ctx, result); err != nil {
+		return false, i18n.NewError("import.save_failed", i18n.Params{"error": err})
+	}
+	return false, nil
+}
+
+// readJSONImport reads an array of results as the JSON export writes them.
+func readJSONImport(body io.Reader) ([]importRow, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	rows := make([]importRow, len(raw))
+	for i, msg := range raw {
+		if err := json.Unmarshal(msg, &rows[i].result); err != nil {
+			rows[i].err = i18n.NewError("import.malformed_row", i18n.Params{"error": err})
+		}
+	}
+	return rows, nil
+}
+
+// readCSVImport reads results as the CSV export writes them. Columns are
+// matched by name, so they may be in any order and optional ones may be
+// left out.
+func readCSVImport(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(header))
+	for i, col := range header {
+		// Spreadsheets may save a byte order mark before the first column
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff")))] = i
+	}
+	for _, col := range requiredImportColumns {
+		if _, ok := index[col]; !ok {
+			return nil, i18n.NewError("error.import_missing_column", i18n.Params{"column": col})
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			rows = append(rows, importRow{err: i18n.NewError("import.malformed_row", i18n.Params{"error": err})})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, parseCSVRow(index, record))
+	}
+}
+
+// csvRecord reads typed values from a CSV row, keeping the first value that
+// does not parse.
+type csvRecord struct {
+	index  map[string]int
+	values []string
+	field  string
+	err    error
+}
+
+func (c *csvRecord) get(col string) string {
+	if i, ok := c.index[col]; ok {
+		return c.values[i]
+	}
+	return ""
+}
+
+func (c *csvRecord) fail(col, value string) {
+	if c.err == nil {
+		c.field = col
+		c.err = i18n.NewError("import.invalid_value", i18n.Params{"field": col, "value": value})
+	}
+}
+
+func (c *csvRecord) int(col string) int {
+	v := strings.TrimSpace(c.get(col))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		c.fail(col, v)
+	}
+	return n
+}
+
+func (c *csvRecord) int64(col string) int64 {
+	v := strings.TrimSpace(c.get(col))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		c.fail(col, v)
+	}
+	return n
+}
+
+func (c *csvRecord) float(col string) float64 {
+	if f := c.optFloat(col); f != nil {
+		return *f
+	}
+	return 0
+}
+
+// optInt and optFloat return nil for an empty value, as the export writes
+// for a measurement that was not taken.
+func (c *csvRecord) optInt(col string) *int {
+	if strings.TrimSpace(c.get(col)) == "" {
+		return nil
+	}
+	n := c.int(col)
+	return &n
+}
+
+func (c *csvRecord) optFloat(col string) *float64 {
+	v := strings.TrimSpace(c.get(col))
+	if v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		c.fail(col, v)
+	}
+	return &f
+}
+
+// percentiles reads a result's percentiles, nil if p50 is empty.
+func (c *csvRecord) percentiles() *models.BandwidthPercentiles {
+	if strings.TrimSpace(c.get("p50_bandwidth")) == "" {
+		return nil
+	}
+	return &models.BandwidthPercentiles{
+		P50: c.float("p50_bandwidth"),
+		P95: c.float("p95_bandwidth"),
+		P99: c.float("p99_bandwidth"),
+	}
+}
+
+// side reads one end of a test from the columns with prefix, nil if its
+// bytes are empty.
+func (c *csvRecord) side(prefix string) *models.SideStats {
+	if strings.TrimSpace(c.get(prefix+"_bytes")) == "" {
+		return nil
+	}
+	return &models.SideStats{
+		Bytes:       c.int64(prefix + "_bytes"),
+		Bandwidth:   c.float(prefix + "_bandwidth"),
+		Retransmits: c.optInt(prefix + "_retransmits"),
+	}
+}
+
+// list splits a ";"-separated value.
+func (c *csvRecord) list(col string) []string {
+	var items []string
+	for _, item := range strings.Split(c.get(col), ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseCSVRow reads a result from a row of the CSV export.
+func parseCSVRow(index map[string]int, values []string) importRow {
+	c := &csvRecord{index: index, values: values}
+	result := models.TestResult{
+		ID:                strings.TrimSpace(c.get("id")),
+		ClientIP:          strings.TrimSpace(c.get("client_ip")),
+		ClientPort:        c.int("client_port"),
+		ServerPort:        c.int("server_port"),
+		Protocol:          models.Protocol(strings.TrimSpace(c.get("protocol"))),
+		Duration:          c.float("duration"),
+		BytesTransferred:  c.int64("bytes_transferred"),
+		AvgBandwidth:      c.float("avg_bandwidth"),
+		MaxBandwidth:      c.float("max_bandwidth"),
+		MinBandwidth:      c.float("min_bandwidth"),
+		Retransmits:       c.optInt("retransmits"),
+		Jitter:            c.optFloat("jitter"),
+		PacketLoss:        c.optFloat("packet_loss"),
+		Direction:         strings.TrimSpace(c.get("direction")),
+		Status:            models.TestStatus(strings.TrimSpace(c.get("status"))),
+		ErrorMessage:      c.get("error_message"),
+		RequestedDuration: c.optFloat("requested_duration"),
+		EnergyJoules:      c.optFloat("energy_joules"),
+		JoulesPerGB:       c.optFloat("joules_per_gb"),
+		HostCPUTotal:      c.optFloat("host_cpu_total"),
+		RemoteCPUTotal:    c.optFloat("remote_cpu_total"),
+		Sender:            c.side("sender"),
+		Receiver:          c.side("receiver"),
+		Percentiles:       c.percentiles(),
+		RetransmitsPerGB:  c.optFloat("retransmits_per_gb"),
+		GoodputRatio:      c.optFloat("goodput_ratio"),
+		JitterRatio:       c.optFloat("jitter_ratio"),
+		LinkCapacity:      c.optFloat("link_capacity"),
+		Utilization:       c.optFloat("utilization"),
+		Precision:         models.Precision(strings.TrimSpace(c.get("precision"))),
+		Source:            models.JobSource(strings.TrimSpace(c.get("source"))),
+		CorrelationID:     c.get("correlation_id"),
+		Tags:              c.list("tags"),
+		Note:              c.get("note"),
+		Site:              strings.TrimSpace(c.get("site")),
+		Node:              strings.TrimSpace(c.get("node")),
+	}
+	if v := strings.TrimSpace(c.get("timestamp")); v != "" {
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.fail("timestamp", v)
+		}
+		result.Timestamp = ts
+	}
+	for _, flag := range c.list("quality_flags") {
+		result.QualityFlags = append(result.QualityFlags, models.QualityFlag(flag))
+	}
+
+	geo := models.GeoInfo{Country: strings.TrimSpace(c.get("country")), ISP: c.get("isp")}
+	if v := strings.TrimSpace(c.get("asn")); v != "" {
+		asn, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.fail("asn", v)
+		}
+		geo.ASN = uint(asn)
+	}
+	if geo != (models.GeoInfo{}) {
+		result.Geo = &geo
+	}
+	return importRow{result: result, field: c.field, err: c.err}
+}
+
+// Values an imported result may take.
+var (
+	importProtocols    = []models.Protocol{models.ProtocolTCP, models.ProtocolUDP}
+	importStatuses     = []models.TestStatus{models.TestStatusCompleted, models.TestStatusAborted, models.TestStatusFailed}
+	importSources      = []models.JobSource{"", models.JobSourceAdHoc, models.JobSourceCI, models.JobSourceScheduled}
+	importPrecisions   = []models.Precision{"", models.PrecisionExact, models.PrecisionCorrected, models.PrecisionRounded}
+	importQualityFlags = []models.QualityFlag{
+		models.QualityFlagShortDuration, models.QualityFlagZeroBytes,
+		models.QualityFlagZeroMinBandwidth, models.QualityFlagClockSkew,
+	}
+)
+
+// checkImported validates a result read from an import, returning the JSON
+// name of the first invalid field. Tags are normalized as when a user adds
+// them.
+func checkImported(result *models.TestResult) (string, error) {
+	missing := func(field string) (string, error) {
+		return field, i18n.NewError("import.missing_value", i18n.Params{"field": field})
+	}
+	invalid := func(field string, value interface{}) (string, error) {
+		return field, i18n.NewError("import.invalid_value", i18n.Params{"field": field, "value": value})
+	}
+
+	switch {
+	case result.ID == "":
+		return missing("id")
+	case result.Timestamp.IsZero():
+		return missing("timestamp")
+	case result.ClientIP == "":
+		return missing("clientIp")
+	case net.ParseIP(result.ClientIP) == nil:
+		return invalid("clientIp", result.ClientIP)
+	case result.ClientPort < 0 || result.ClientPort > 65535:
+		return invalid("clientPort", result.ClientPort)
+	case result.ServerPort < 0 || result.ServerPort > 65535:
+		return invalid("serverPort", result.ServerPort)
+	case !slices.Contains(importProtocols, result.Protocol):
+		return invalid("protocol", result.Protocol)
+	case !slices.Contains(importStatuses, result.Status):
+		return invalid("status", result.Status)
+	case !slices.Contains(importSources, result.Source):
+		return invalid("source", result.Source)
+	case !slices.Contains(importPrecisions, result.Precision):
+		return invalid("precision", result.Precision)
+	case result.BytesTransferred < 0:
+		return invalid("bytesTransferred", result.BytesTransferred)
+	case result.Retransmits != nil && *result.Retransmits < 0:
+		return invalid("retransmits", *result.Retransmits)
+	}
+	sides := []struct {
+		name string
+		side *models.SideStats
+	}{
+		{"sender", result.Sender},
+		{"receiver", result.Receiver},
+	}
+	for _, s := range sides {
+		name, side := s.name, s.side
+		switch {
+		case side == nil:
+		case side.Bytes < 0:
+			return invalid(name+".bytes", side.Bytes)
+		case side.Bandwidth < 0 || math.IsNaN(side.Bandwidth) || math.IsInf(side.Bandwidth, 0):
+			return invalid(name+".bandwidth", side.Bandwidth)
+		case side.Retransmits != nil && *side.Retransmits < 0:
+			return invalid(name+".retransmits", *side.Retransmits)
+		}
+	}
+
+	measurements := []struct {
+		field string
+		value *float64
+	}{
+		{"duration", &result.Duration},
+		{"avgBandwidth", &result.AvgBandwidth},
+		{"maxBandwidth", &result.MaxBandwidth},
+		{"minBandwidth", &result.MinBandwidth},
+		{"jitter", result.Jitter},
+		{"packetLoss", result.PacketLoss},
+		{"requestedDuration", result.RequestedDuration},
+		{"energyJoules", result.EnergyJoules},
+		{"joulesPerGb", result.JoulesPerGB},
+		{"hostCpuTotal", result.HostCPUTotal},
+		{"remoteCpuTotal", result.RemoteCPUTotal},
+		{"retransmitsPerGb", result.RetransmitsPerGB},
+		{"goodputRatio", result.GoodputRatio},
+		{"jitterRatio", result.JitterRatio},
+		{"linkCapacity", result.LinkCapacity},
+		{"utilization", result.Utilization},
+	}
+	if p := result.Percentiles; p != nil {
+		measurements = append(measurements, []struct {
+			field string
+			value *float64
+		}{{"percentiles.p50", &p.P50}, {"percentiles.p95", &p.P95}, {"percentiles.p99", &p.P99}}...)
+	}
+	for _, m := range measurements {
+		if m.value != nil && (*m.value < 0 || math.IsNaN(*m.value) || math.IsInf(*m.value, 0)) {
+			return invalid(m.field, *m.value)
+		}
+	}
+	for _, flag := range result.QualityFlags {
+		if !slices.Contains(importQualityFlags, flag) {
+			return invalid("qualityFlags", flag)
+		}
+	}
+
+	tags, err := normalizeTags(result.Tags)
+	if err != nil {
+		return "tags", err
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	result.Tags = tags
+	result.Note = strings.TrimSpace(result.Note)
+	if utf8.RuneCountInString(result.Note) > MaxNoteLength {
+		return "note", i18n.NewError("result.note_too_long", i18n.Params{"max": MaxNoteLength})
+	}
+	return "", nil
+}
+
+// columnName returns the CSV column of a JSON field, such as client_ip for
+// clientIp or sender_bytes for sender.bytes.
+func columnName(field string) string {
+	var b strings.Builder
+	for _, r := range field {
+		switch {
+		case r == '.':
+			r = '_'
+		case unicode.IsUpper(r):
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
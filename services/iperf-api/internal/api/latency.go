@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/netprobe"
+	"github.com/go-chi/chi/v5"
+)
+
+// handleLatencyEvent saves each round of a latency probe, so the result
+// sent to WebSocket clients carries its ID, and forwards pings as they
+// complete.
+func (s *Server) handleLatencyEvent(msg models.WSMessage) {
+	if result, ok := msg.Payload.(*models.LatencyResult); ok {
+		if err := s.storage.SaveLatencyResult(context.Background(), result); err != nil {
+			log.Printf("Failed to save latency result for %s: %v", result.Target, err)
+		}
+	}
+	s.hub.Broadcast(msg)
+}
+
+// handleListProbes returns the running latency probes.
+func (s *Server) handleListProbes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.latency.List())
+}
+
+// handleStartProbe starts a one-off or continuous latency probe. Its pings
+// and results are streamed over the WebSocket.
+func (s *Server) handleStartProbe(w http.ResponseWriter, r *http.Request) {
+	var spec models.ProbeSpec
+	if err := decodeJSON(w, r, &spec); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	probe, err := s.latency.Start(spec)
+	if errors.Is(err, netprobe.ErrTooManyProbes) {
+		s.writeError(w, r, http.StatusConflict, "latency.too_many_probes", i18n.Params{"max": netprobe.MaxProbes})
+		return
+	}
+	if err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.audit(r, models.AuditActionLatencyStart, map[string]interface{}{
+		"probeId": probe.ID,
+		"spec":    probe.ProbeSpec,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(probe)
+}
+
+// handleStopProbe stops a running latency probe.
+func (s *Server) handleStopProbe(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := s.latency.Stop(id); err != nil {
+		s.writeError(w, r, http.StatusNotFound, "error.latency_probe_not_found", nil)
+		return
+	}
+
+	s.audit(r, models.AuditActionLatencyStop, map[string]interface{}{"probeId": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetLatencyResults returns stored latency results, newest first,
+// optionally for one target.
+func (s *Server) handleGetLatencyResults(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	// Default and max limit
+	limit := 50
+	if parsed, err := strconv.Atoi(q.Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset := 0
+	if parsed, err := strconv.Atoi(q.Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+
+	results, err := s.storage.GetLatencyResults(r.Context(), q.Get("target"), limit, offset)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.latency_results_failed", i18n.Params{"error": err})
+		return
+	}
+	if results == nil {
+		results = []models.LatencyResult{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/linkload"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// linkCheckTimeout bounds one link utilisation measurement.
+const linkCheckTimeout = 30 * time.Second
+
+// WithLinkMonitor measures the uplink before each scheduled job starts and
+// defers the job while the link is above the monitor's threshold.
+func WithLinkMonitor(m *linkload.Monitor) Option {
+	return func(s *Server) {
+		s.link = m
+	}
+}
+
+// linkBusy is the queue's Defer hook. It returns why a scheduled job should
+// wait, recording the deferral, or "" to start it. A failed measurement
+// does not hold jobs back.
+func (s *Server) linkBusy(job models.QueueJob) string {
+	ctx, cancel := context.WithTimeout(context.Background(), linkCheckTimeout)
+	defer cancel()
+	u, err := s.link.Measure(ctx)
+	if err != nil {
+		log.Printf("Link utilization check for job %s failed, starting it anyway: %v", job.ID, err)
+		return ""
+	}
+	if !u.Busy {
+		return ""
+	}
+
+	reason := fmt.Sprintf("link %.1f%% utilized, above %.1f%%", u.Percent, u.Threshold)
+	log.Printf("Deferring scheduled job %s: %s", job.ID, reason)
+	s.saveAudit(&models.AuditEntry{
+		Action: models.AuditActionQueueDefer,
+		Parameters: map[string]interface{}{
+			"jobId":           job.ID,
+			"attempt":         job.Deferrals + 1,
+			"percent":         u.Percent,
+			"threshold":       u.Threshold,
+			"rxBitsPerSecond": u.RxBitsPerSecond,
+			"txBitsPerSecond": u.TxBitsPerSecond,
+		},
+	})
+	return reason
+}
+
+// handleGetLink measures the uplink's current utilization.
+func (s *Server) handleGetLink(w http.ResponseWriter, r *http.Request) {
+	u, err := s.link.Measure(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusBadGateway, "error.link_failed", i18n.Params{"error": err})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
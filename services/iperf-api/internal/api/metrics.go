@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// hubMetrics counts WebSocket deliveries for /metrics.
+type hubMetrics struct {
+	sent            atomic.Int64
+	dropped         atomic.Int64
+	slowWarnings    atomic.Int64
+	slowDisconnects atomic.Int64
+}
+
+// HubStats is a snapshot of the hub's clients and delivery counters.
+type HubStats struct {
+	Clients int
+	// MaxQueued is the longest queue of any client right now
+	MaxQueued int
+	// Capacity is the length of each client's queue
+	Capacity        int
+	Sent            int64
+	Dropped         int64
+	SlowWarnings    int64
+	SlowDisconnects int64
+}
+
+// Stats returns the hub's current clients and delivery counters.
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		Capacity:        h.bufferSize,
+		Sent:            h.metrics.sent.Load(),
+		Dropped:         h.metrics.dropped.Load(),
+		SlowWarnings:    h.metrics.slowWarnings.Load(),
+		SlowDisconnects: h.metrics.slowDisconnects.Load(),
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats.Clients = len(h.clients)
+	for client := range h.clients {
+		if n := len(client.queue); n > stats.MaxQueued {
+			stats.MaxQueued = n
+		}
+	}
+	return stats
+}
+
+// WithClientBuffer sets how many messages are queued for each WebSocket
+// client before it is disconnected as too slow.
+func WithClientBuffer(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.hub.bufferSize = n
+		}
+	}
+}
+
+// handleMetrics serves the hub's delivery metrics and the count of
+// connections turned away while busy in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := s.hub.Stats()
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"iperf_ws_clients", "gauge", "Connected WebSocket clients.", int64(stats.Clients)},
+		{"iperf_ws_queue_max", "gauge", "Longest message queue of any WebSocket client.", int64(stats.MaxQueued)},
+		{"iperf_ws_queue_capacity", "gauge", "Messages queued for a WebSocket client before it is disconnected.", int64(stats.Capacity)},
+		{"iperf_ws_messages_sent_total", "counter", "Messages queued for WebSocket clients.", stats.Sent},
+		{"iperf_ws_messages_dropped_total", "counter", "Messages dropped because a WebSocket client's queue was full.", stats.Dropped},
+		{"iperf_ws_slow_consumer_warnings_total", "counter", "Warnings sent to WebSocket clients falling behind.", stats.SlowWarnings},
+		{"iperf_ws_slow_consumer_disconnects_total", "counter", "WebSocket clients disconnected for falling behind.", stats.SlowDisconnects},
+		{"iperf_collisions_total", "counter", "Connections turned away because a test was running.", s.collisions.Load()},
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/netprobe"
+)
+
+// mtuCSVColumns is the header of the path MTU export.
+var mtuCSVColumns = []string{
+	"id", "timestamp", "target", "address", "method", "path_mtu",
+	"min", "max", "probes", "error",
+}
+
+// handleDiscoverMTU runs a path MTU discovery to a target, stores the result
+// and returns it. The request waits for the search to finish.
+func (s *Server) handleDiscoverMTU(w http.ResponseWriter, r *http.Request) {
+	var req models.MTURequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	result, err := netprobe.DiscoverMTU(r.Context(), req)
+	var invalid *i18n.Error
+	if errors.As(err, &invalid) {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.mtu_failed", i18n.Params{"error": err})
+		return
+	}
+	if err := s.storage.SaveMTUResult(r.Context(), result); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.mtu_save_failed", i18n.Params{"error": err})
+		return
+	}
+
+	s.audit(r, models.AuditActionMTUDiscover, map[string]interface{}{
+		"target":  result.Target,
+		"method":  result.Method,
+		"pathMtu": result.PathMTU,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleGetMTUResults returns stored path MTU discoveries, newest first,
+// optionally for one target.
+func (s *Server) handleGetMTUResults(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	// Default and max limit
+	limit := 50
+	if parsed, err := strconv.Atoi(q.Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset := 0
+	if parsed, err := strconv.Atoi(q.Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+
+	results, err := s.storage.GetMTUResults(r.Context(), q.Get("target"), limit, offset)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.mtu_results_failed", i18n.Params{"error": err})
+		return
+	}
+	if results == nil {
+		results = []models.MTUResult{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleExportMTU exports all stored path MTU discoveries as CSV (the
+// default) or JSON.
+func (s *Server) handleExportMTU(w http.ResponseWriter, r *http.Request) {
+	results, err := s.storage.GetMTUResults(r.Context(), "", -1, 0)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.mtu_results_failed", i18n.Params{"error": err})
+		return
+	}
+	if results == nil {
+		results = []models.MTUResult{}
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=mtu_results.json")
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=mtu_results.csv")
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+	writer.Write(mtuCSVColumns)
+	for _, m := range results {
+		writer.Write([]string{
+			strconv.FormatInt(m.ID, 10),
+			m.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+			m.Target,
+			m.Address,
+			string(m.Method),
+			strconv.Itoa(m.PathMTU),
+			strconv.Itoa(m.Min),
+			strconv.Itoa(m.Max),
+			strconv.Itoa(m.Probes),
+			m.Error,
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/neighbor"
+)
+
+// WithNeighbors attaches the MAC address and vendor of directly attached
+// clients to connection events and test results, and serves the neighbor
+// table.
+func WithNeighbors(table *neighbor.Table) Option {
+	return func(s *Server) {
+		s.neighbors = table
+	}
+}
+
+// identify fills in the neighbor table entry of the client of a connection
+// event or test result.
+func (s *Server) identify(msg models.WSMessage) {
+	if s.neighbors == nil {
+		return
+	}
+	switch p := msg.Payload.(type) {
+	case *models.ConnectionEvent:
+		p.Neighbor = s.neighbors.Lookup(p.ClientIP)
+	case *models.TestResult:
+		n := s.neighbors.Lookup(p.ClientIP)
+		if n == nil {
+			return
+		}
+		if p.Client == nil {
+			p.Client = &models.ClientFingerprint{}
+		}
+		p.Client.MAC = n.MAC
+		p.Client.Vendor = n.Vendor
+	}
+}
+
+// handleGetNeighbors lists the host's neighbor table with vendors.
+func (s *Server) handleGetNeighbors(w http.ResponseWriter, r *http.Request) {
+	neighbors, err := s.neighbors.List()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.neighbors_failed", i18n.Params{"error": err})
+		return
+	}
+	if neighbors == nil {
+		neighbors = []models.Neighbor{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(neighbors)
+}
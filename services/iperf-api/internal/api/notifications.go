@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/alerts"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// emailTimeout bounds each SMTP delivery.
+const emailTimeout = 30 * time.Second
+
+// WithEmailNotifier enables email notifications for alerts and server errors.
+func WithEmailNotifier(n *alerts.EmailNotifier) Option {
+	return func(s *Server) {
+		s.email = n
+	}
+}
+
+// emailConfigResponse is the email configuration as returned by the API,
+// with the password replaced by whether one is set.
+type emailConfigResponse struct {
+	alerts.EmailConfig
+	Password    string `json:"password,omitempty"`
+	PasswordSet bool   `json:"passwordSet"`
+}
+
+// notifyEmail sends an email for ev in the background, logging failures.
+func (s *Server) notifyEmail(ev alerts.EmailEvent) {
+	if !s.email.Enabled() {
+		return
+	}
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailTimeout)
+		defer cancel()
+		if err := s.email.Send(ctx, ev); err != nil {
+			log.Printf("Email notification failed: %v", err)
+		}
+	}()
+}
+
+// notifyServerError emails when a status update reports the error state.
+func (s *Server) notifyServerError(msg models.WSMessage) {
+	status, ok := msg.Payload.(models.ServerStatusPayload)
+	if !ok || status.Status != models.ServerStatusError {
+		return
+	}
+	s.notifyEmail(alerts.EmailEvent{Status: &status})
+}
+
+// handleGetEmailConfig returns the email notification settings.
+func (s *Server) handleGetEmailConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := s.email.Config()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(emailConfigResponse{
+		EmailConfig: cfg,
+		PasswordSet: cfg.Password != "",
+	})
+}
+
+// handleUpdateEmailConfig replaces the email notification settings. An
+// omitted password keeps the current one. Changes last until restart.
+func (s *Server) handleUpdateEmailConfig(w http.ResponseWriter, r *http.Request) {
+	var cfg alerts.EmailConfig
+	if err := decodeJSON(w, r, &cfg); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	if cfg.Password == "" && cfg.Username != "" {
+		cfg.Password = s.email.Config().Password
+	}
+
+	if err := s.email.SetConfig(cfg); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// The password is never written to the audit log
+	redacted := cfg
+	redacted.Password = ""
+	s.audit(r, models.AuditActionEmailConfigUpdate, map[string]interface{}{
+		"config":      redacted,
+		"passwordSet": cfg.Password != "",
+	})
+
+	s.handleGetEmailConfig(w, r)
+}
+
+// handleTestEmail sends a sample alert email synchronously so configuration
+// errors are reported to the caller.
+func (s *Server) handleTestEmail(w http.ResponseWriter, r *http.Request) {
+	if !s.email.Enabled() {
+		s.writeError(w, r, http.StatusBadRequest, "error.email_not_configured", nil)
+		return
+	}
+
+	ev := alerts.EmailEvent{
+		Timestamp: time.Now(),
+		Alert: &models.Alert{
+			Timestamp:  time.Now(),
+			RuleName:   "Test notification",
+			ResultID:   "test",
+			ClientIP:   "0.0.0.0",
+			Violations: []string{"this is a test email from the iPerf server"},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), emailTimeout)
+	defer cancel()
+	if err := s.email.Send(ctx, ev); err != nil {
+		s.writeError(w, r, http.StatusBadGateway, "error.email_test_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "sent"})
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Tom-Oram/fak/backend/internal/federation"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// MaxPeerNameLength bounds the names of registered peers.
+const MaxPeerNameLength = 64
+
+// WithPeerPulling copies the history of every federation peer into this
+// instance's storage. It needs WithFederation.
+func WithPeerPulling(p *federation.Puller) Option {
+	return func(s *Server) {
+		s.puller = p
+	}
+}
+
+// peerView is a peer as the API shows it, with the API key replaced by
+// whether one is set.
+type peerView struct {
+	Name      string           `json:"name"`
+	URL       string           `json:"url"`
+	APIKeySet bool             `json:"apiKeySet"`
+	Location  *models.Location `json:"location,omitempty"`
+	// Registered peers were added through the API; the others are from
+	// the peers file and cannot be changed here
+	Registered bool                   `json:"registered"`
+	Pull       *federation.PullStatus `json:"pull,omitempty"`
+}
+
+// peerRequest is the body of PUT /api/peers/{name}.
+type peerRequest struct {
+	URL      string           `json:"url"`
+	APIKey   string           `json:"apiKey"`
+	Location *models.Location `json:"location"`
+}
+
+// validatePeer checks a peer's name, URL and location. Names are used in
+// URLs and as the site of pulled results, so they cannot contain "/" or
+// "%", and this instance's name is taken.
+func (s *Server) validatePeer(p *models.Peer) error {
+	if p.Name == "" {
+		return i18n.NewError("peer.name_required", nil)
+	}
+	if utf8.RuneCountInString(p.Name) > MaxPeerNameLength || p.Name != strings.TrimSpace(p.Name) ||
+		strings.ContainsAny(p.Name, "/%") || strings.IndexFunc(p.Name, unicode.IsControl) >= 0 || p.Name == s.originName {
+		return i18n.NewError("peer.invalid_name", i18n.Params{"name": p.Name, "max": MaxPeerNameLength})
+	}
+	if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return i18n.NewError("peer.invalid_url", i18n.Params{"url": p.URL})
+	}
+	if p.Location != nil && !p.Location.Valid() {
+		return i18n.NewError("peer.invalid_location", nil)
+	}
+	return nil
+}
+
+// reloadPeers hands the registered peers to the federation client, and has
+// the puller pull from any new one.
+func (s *Server) reloadPeers(r *http.Request) error {
+	peers, err := s.storage.ListPeers(r.Context())
+	if err != nil {
+		return err
+	}
+	s.federation.SetRegistered(peers)
+	if s.puller != nil {
+		s.puller.Wake()
+	}
+	return nil
+}
+
+// handleListPeers returns the peers from the peers file and those
+// registered, with how pulling from each last went.
+func (s *Server) handleListPeers(w http.ResponseWriter, r *http.Request) {
+	var status map[string]federation.PullStatus
+	if s.puller != nil {
+		status = s.puller.Status()
+	}
+
+	peers := []peerView{}
+	for _, p := range s.federation.Peers() {
+		view := peerView{
+			Name:       p.Name,
+			URL:        p.URL,
+			APIKeySet:  p.APIKey != "",
+			Location:   p.Location,
+			Registered: !s.federation.Configured(p.Name),
+		}
+		if pull, ok := status[p.Name]; ok {
+			view.Pull = &pull
+		}
+		peers = append(peers, view)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peers":   peers,
+		"pulling": s.puller != nil,
+	})
+}
+
+// handleSavePeer registers a peer or replaces the registered one with the
+// same name. An omitted API key keeps the current one.
+func (s *Server) handleSavePeer(w http.ResponseWriter, r *http.Request) {
+	var req peerRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	peer := models.Peer{
+		Name:     chi.URLParam(r, "name"),
+		URL:      strings.TrimSpace(req.URL),
+		APIKey:   req.APIKey,
+		Location: req.Location,
+	}
+	if err := s.validatePeer(&peer); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if s.federation.Configured(peer.Name) {
+		s.writeError(w, r, http.StatusConflict, "error.peer_configured", i18n.Params{"name": peer.Name})
+		return
+	}
+	if peer.APIKey == "" {
+		for _, p := range s.federation.Peers() {
+			if p.Name == peer.Name {
+				peer.APIKey = p.APIKey
+			}
+		}
+	}
+
+	if err := s.storage.SavePeer(r.Context(), &peer); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.peer_save_failed", i18n.Params{"error": err})
+		return
+	}
+	if err := s.reloadPeers(r); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.peer_save_failed", i18n.Params{"error": err})
+		return
+	}
+	// The API key is never written to the audit log
+	s.audit(r, models.AuditActionPeerSave, map[string]interface{}{
+		"name":      peer.Name,
+		"url":       peer.URL,
+		"apiKeySet": peer.APIKey != "",
+		"location":  peer.Location,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peerView{
+		Name:       peer.Name,
+		URL:        peer.URL,
+		APIKeySet:  peer.APIKey != "",
+		Location:   peer.Location,
+		Registered: true,
+	})
+}
+
+// handleDeletePeer unregisters a peer. Results already pulled from it are
+// kept.
+func (s *Server) handleDeletePeer(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if s.federation.Configured(name) {
+		s.writeError(w, r, http.StatusConflict, "error.peer_configured", i18n.Params{"name": name})
+		return
+	}
+	if err := s.storage.DeletePeer(r.Context(), name); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.peer_not_found", i18n.Params{"name": name})
+			return
+		}
+		s.writeError(w, r, http.StatusInternalServerError, "error.peer_delete_failed", i18n.Params{"error": err})
+		return
+	}
+	if err := s.reloadPeers(r); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.peer_delete_failed", i18n.Params{"error": err})
+		return
+	}
+	s.audit(r, models.AuditActionPeerDelete, map[string]interface{}{"name": name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// MaxProfileNameLength and MaxProfileDescriptionLength bound profile fields.
+const (
+	MaxProfileNameLength        = 64
+	MaxProfileDescriptionLength = 1024
+)
+
+// profileRequest is the body of PUT /api/profiles/{name}.
+type profileRequest struct {
+	Description string               `json:"description"`
+	Config      *models.ServerConfig `json:"config"`
+}
+
+// validateProfile checks a profile's name, description and configuration.
+// Names are used in URLs, so they cannot contain "/" or "%".
+func validateProfile(p *models.Profile) error {
+	if p.Name == "" {
+		return i18n.NewError("profile.name_required", nil)
+	}
+	if utf8.RuneCountInString(p.Name) > MaxProfileNameLength || p.Name != strings.TrimSpace(p.Name) ||
+		strings.ContainsAny(p.Name, "/%") || strings.IndexFunc(p.Name, unicode.IsControl) >= 0 {
+		return i18n.NewError("profile.invalid_name", i18n.Params{"name": p.Name, "max": MaxProfileNameLength})
+	}
+	if utf8.RuneCountInString(p.Description) > MaxProfileDescriptionLength {
+		return i18n.NewError("profile.description_too_long", i18n.Params{"max": MaxProfileDescriptionLength})
+	}
+	if errs := iperf.ValidateConfig(p.Config); len(errs) > 0 {
+		return iperf.ConfigErrors(errs)
+	}
+	return nil
+}
+
+// writeProfileError maps storage errors to HTTP responses; key names the
+// message for unexpected failures.
+func (s *Server) writeProfileError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusNotFound, "error.profile_not_found", i18n.Params{"name": chi.URLParam(r, "name")})
+		return
+	}
+	s.writeError(w, r, http.StatusInternalServerError, key, i18n.Params{"error": err})
+}
+
+// handleListProfiles returns all profiles.
+func (s *Server) handleListProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.storage.ListProfiles(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.profile_list_failed", i18n.Params{"error": err})
+		return
+	}
+
+	if profiles == nil {
+		profiles = []models.Profile{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"profiles": profiles,
+	})
+}
+
+// handleGetProfile returns a single profile.
+func (s *Server) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	profile, err := s.storage.GetProfile(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		s.writeProfileError(w, r, "error.profile_get_failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// handleSaveProfile creates a profile or replaces the one with the same name.
+func (s *Server) handleSaveProfile(w http.ResponseWriter, r *http.Request) {
+	var req profileRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if req.Config == nil {
+		s.writeError(w, r, http.StatusBadRequest, "profile.config_required", nil)
+		return
+	}
+
+	profile := models.Profile{
+		Name:        chi.URLParam(r, "name"),
+		Description: strings.TrimSpace(req.Description),
+		Config:      *req.Config,
+	}
+	if err := validateProfile(&profile); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.storage.SaveProfile(r.Context(), &profile); err != nil {
+		s.writeProfileError(w, r, "error.profile_save_failed", err)
+		return
+	}
+	s.audit(r, models.AuditActionProfileSave, map[string]interface{}{"profile": profile})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// handleDeleteProfile removes a profile.
+func (s *Server) handleDeleteProfile(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := s.storage.DeleteProfile(r.Context(), name); err != nil {
+		s.writeProfileError(w, r, "error.profile_delete_failed", err)
+		return
+	}
+	s.audit(r, models.AuditActionProfileDelete, map[string]interface{}{"name": name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
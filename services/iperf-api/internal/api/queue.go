@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/queue"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithQueueOptions overrides the execution queue priorities and timeouts.
+func WithQueueOptions(opts queue.Options) Option {
+	return func(s *Server) {
+		s.queueOpts = opts
+	}
+}
+
+// WithIDGenerator sets how test session, result and queued job IDs are
+// generated.
+func WithIDGenerator(gen ids.Generator) Option {
+	return func(s *Server) {
+		s.newID = gen
+	}
+}
+
+// correlationRecorded reports whether a result of this instance already
+// carries a source's correlation ID.
+func (s *Server) correlationRecorded(source models.JobSource, correlationID string) (bool, error) {
+	results, err := s.storage.QueryTestResults(context.Background(),
+		storage.HistoryFilter{Source: source, CorrelationID: correlationID, Local: true}, 1, 0)
+	return len(results) > 0, err
+}
+
+// enqueueRequest is the body of POST /api/queue.
+type enqueueRequest struct {
+	Source models.JobSource `json:"source"`
+	// Config defaults to the standard server configuration when omitted
+	Config  *models.ServerConfig `json:"config"`
+	Timeout int                  `json:"timeout"`
+	// CorrelationID is an optional external reference, such as a ticket
+	// number or CI run ID, copied to the job's result
+	CorrelationID string `json:"correlationId"`
+}
+
+// handleGetQueue returns the running job, queued jobs in order and recently
+// finished jobs.
+func (s *Server) handleGetQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.queue.Snapshot())
+}
+
+// handleEnqueue adds a test run to the execution queue, or with
+// ?dryRun=true reports what running it would do.
+func (s *Server) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req enqueueRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+	if req.Source == "" {
+		req.Source = models.JobSourceAdHoc
+	}
+	cfg := models.DefaultServerConfig()
+	if req.Config != nil {
+		cfg = *req.Config
+	}
+
+	if isDryRun(r) {
+		if err := s.queue.ValidateJob(req.Source, req.Timeout, req.CorrelationID); err != nil {
+			s.writeEnqueueError(w, r, req, err)
+			return
+		}
+		s.writeDryRun(w, r, s.manager.DryRun(cfg, true))
+		return
+	}
+
+	job, err := s.queue.Enqueue(req.Source, cfg, req.Timeout, req.CorrelationID)
+	if err != nil {
+		s.writeEnqueueError(w, r, req, err)
+		return
+	}
+
+	s.audit(r, models.AuditActionQueueEnqueue, map[string]interface{}{
+		"jobId":         job.ID,
+		"source":        job.Source,
+		"correlationId": job.CorrelationID,
+		"config":        job.Config,
+		"timeout":       job.Timeout,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// writeEnqueueError reports a rejected job: 409 for a correlation ID already
+// in use, otherwise 400.
+func (s *Server) writeEnqueueError(w http.ResponseWriter, r *http.Request, req enqueueRequest, err error) {
+	if errors.Is(err, queue.ErrCorrelationInUse) {
+		s.writeError(w, r, http.StatusConflict, "queue.correlation_in_use",
+			i18n.Params{"source": req.Source, "correlationId": req.CorrelationID})
+		return
+	}
+	s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+}
+
+// handleCancelJob removes a queued job or stops the running one.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := s.queue.Cancel(id); err != nil {
+		if errors.Is(err, queue.ErrJobNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.queue_job_not_found", nil)
+			return
+		}
+		s.writeLocalizedError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.audit(r, models.AuditActionQueueCancel, map[string]interface{}{"jobId": id})
+	w.WriteHeader(http.StatusNoContent)
+}
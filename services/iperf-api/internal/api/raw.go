@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/Tom-Oram/fak/backend/internal/capacity"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/quality"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithRawOutputArchive stores the iperf output each result was parsed from,
+// served by GET /api/history/{id}/raw, so parsing problems can be debugged
+// and results parsed again after a fix.
+func WithRawOutputArchive(enabled bool) Option {
+	return func(s *Server) {
+		if enabled {
+			s.managerOpts = append(s.managerOpts, iperf.WithRawOutput(s.archiveRawOutput))
+		}
+	}
+}
+
+// archiveRawOutput stores a result's raw output. It arrives after the
+// result has been saved; failures are logged.
+func (s *Server) archiveRawOutput(resultID string, output []byte) {
+	if err := s.storage.SaveRawOutput(context.Background(), resultID, output); err != nil {
+		log.Printf("Failed to archive raw output of result %s: %v", resultID, err)
+	}
+}
+
+// handleGetRawOutput returns the archived iperf output of a stored result as
+// plain text.
+func (s *Server) handleGetRawOutput(w http.ResponseWriter, r *http.Request) {
+	output, err := s.storage.GetRawOutput(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusNotFound, "error.raw_output_not_found", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.raw_output_failed", i18n.Params{"error": err})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(output)
+}
+
+// reparseReport is the outcome of parsing archived output again.
+type reparseReport struct {
+	// Archived counts the results with archived output
+	Archived int `json:"archived"`
+	// Changed counts the results whose fields changed, listed in ChangedIDs
+	Changed    int      `json:"changed"`
+	ChangedIDs []string `json:"changedIds"`
+	// Unparsed counts archived output the parser found no test in
+	Unparsed int `json:"unparsed"`
+}
+
+// handleReparse runs the current parser over every result's archived output
+// and updates the fields it derives, for results saved before a parser fix.
+// It responds once every result has been checked.
+func (s *Server) handleReparse(w http.ResponseWriter, r *http.Request) {
+	ids, err := s.storage.ListRawOutputIDs(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.reparse_failed", i18n.Params{"error": err})
+		return
+	}
+
+	report := reparseReport{Archived: len(ids), ChangedIDs: []string{}}
+	for _, id := range ids {
+		changed, err := s.reparse(r.Context(), id)
+		if errors.Is(err, errUnparsed) {
+			report.Unparsed++
+			continue
+		}
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.reparse_failed", i18n.Params{"error": err})
+			return
+		}
+		if changed {
+			report.Changed++
+			report.ChangedIDs = append(report.ChangedIDs, id)
+		}
+	}
+	s.audit(r, models.AuditActionResultsReparse, map[string]interface{}{"archived": report.Archived, "changed": report.Changed})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// errUnparsed is archived output without a test in it.
+var errUnparsed = errors.New("no test in archived output")
+
+// reparse parses one result's archived output again and stores the result
+// if any field changed, reporting whether one did.
+func (s *Server) reparse(ctx context.Context, id string) (bool, error) {
+	output, err := s.storage.GetRawOutput(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	stored, err := s.storage.GetTestResult(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	parsed := s.manager.Reparse(output)
+	if parsed == nil {
+		return false, errUnparsed
+	}
+
+	// Compared as JSON, where a field stored empty and one parsed as nil are
+	// the same
+	before, _ := json.Marshal(stored)
+	updated := *stored
+	s.applyReparsed(&updated, parsed)
+	if after, _ := json.Marshal(&updated); bytes.Equal(before, after) {
+		return false, nil
+	}
+	if err := s.storage.UpdateTestResult(ctx, &updated); err != nil {
+		return true, err
+	}
+	s.resultsRevision.Add(1)
+	return true, nil
+}
+
+// applyReparsed copies the fields the parser derives from a test's output
+// onto its stored result and flags its quality again. The client's MAC and
+// vendor come from the neighbor table, and clock skew was judged when the
+// result arrived, so both are kept.
+func (s *Server) applyReparsed(stored, parsed *models.TestResult) {
+	stored.Protocol = parsed.Protocol
+	stored.Direction = parsed.Direction
+	stored.Duration = parsed.Duration
+	stored.BytesTransferred = parsed.BytesTransferred
+	stored.Precision = parsed.Precision
+	stored.AvgBandwidth = parsed.AvgBandwidth
+	stored.MinBandwidth = parsed.MinBandwidth
+	stored.MaxBandwidth = parsed.MaxBandwidth
+	stored.Retransmits = parsed.Retransmits
+	stored.Jitter = parsed.Jitter
+	stored.PacketLoss = parsed.PacketLoss
+	stored.RequestedDuration = parsed.RequestedDuration
+	stored.HostCPUTotal = parsed.HostCPUTotal
+	stored.RemoteCPUTotal = parsed.RemoteCPUTotal
+	stored.Sender = parsed.Sender
+	stored.Receiver = parsed.Receiver
+
+	if prev := stored.Client; prev != nil && (prev.MAC != "" || prev.Vendor != "") {
+		client := models.ClientFingerprint{}
+		if parsed.Client != nil {
+			client = *parsed.Client
+		}
+		client.MAC, client.Vendor = prev.MAC, prev.Vendor
+		parsed.Client = &client
+	}
+	stored.Client = parsed.Client
+
+	skewed := slices.Contains(stored.QualityFlags, models.QualityFlagClockSkew)
+	stored.QualityFlags = quality.Assess(stored, s.qualityOpts, stored.Timestamp)
+	if skewed {
+		stored.QualityFlags = append(stored.QualityFlags, models.QualityFlagClockSkew)
+	}
+	quality.Derive(stored, s.linkSpeed)
+	// Utilization stays measured against the capacity declared when the
+	// result was saved
+	if stored.LinkCapacity != nil {
+		capacity.Measure(stored, *stored.LinkCapacity)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/report"
+)
+
+// WithReportSchedule enables /api/reports/schedule. The scheduler sends
+// reports on its own.
+func WithReportSchedule(sched *report.Scheduler) Option {
+	return func(s *Server) {
+		s.reports = sched
+	}
+}
+
+// handleGetReportPDF renders the tests of a period, optionally of one
+// ?clientIp=, as a PDF report. The period is read as for accounting.
+func (s *Server) handleGetReportPDF(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := s.parsePeriod(w, r)
+	if !ok {
+		return
+	}
+	clientIP := r.URL.Query().Get("clientIp")
+
+	results, err := s.storage.GetTestResultsBetween(r.Context(), from, to)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+	if clientIP != "" {
+		var matched []models.TestResult
+		for _, result := range results {
+			if result.ClientIP == clientIP {
+				matched = append(matched, result)
+			}
+		}
+		results = matched
+	}
+
+	// Rendered to a buffer first, so a failure can still be reported
+	var buf bytes.Buffer
+	rep := report.Report{
+		From:      from,
+		To:        to,
+		ClientIP:  clientIP,
+		Node:      s.nodeName,
+		Generated: time.Now(),
+		Results:   results,
+	}
+	if err := report.WritePDF(&buf, rep); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "report.failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=iperf_report_"+from.UTC().Format("20060102")+"_"+to.UTC().Format("20060102")+".pdf")
+	w.Write(buf.Bytes())
+}
+
+// handleGetReportSchedule returns the report scheduler's status.
+func (s *Server) handleGetReportSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.reports.Status())
+}
+
+// handleSendReport sends the report of the last period now and returns it.
+// It fails with 502 if any channel did, naming each.
+func (s *Server) handleSendReport(w http.ResponseWriter, r *http.Request) {
+	digest, err := s.reports.Build(r.Context())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+	failures := s.reports.Deliver(r.Context(), digest)
+	s.audit(r, models.AuditActionReportSend, map[string]interface{}{
+		"period": digest.Period, "from": digest.From, "to": digest.To, "failures": len(failures),
+	})
+
+	if len(failures) > 0 {
+		var errs []string
+		for name, failure := range failures {
+			errs = append(errs, name+": "+failure)
+		}
+		slices.Sort(errs)
+		s.writeError(w, r, http.StatusBadGateway, "report.send_failed", i18n.Params{"error": strings.Join(errs, "; ")})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(digest)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// Limits on what users can attach to a result.
+const (
+	MaxResultTags  = 20
+	MaxTagLength   = 64
+	MaxNoteLength  = 4096
+	tagPunctuation = "-_.:/"
+)
+
+// normalizeTags lowercases and trims tags and drops empty and repeated ones.
+// Tags are letters, digits and -_.:/ so they can be used in query strings.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > MaxTagLength || strings.IndexFunc(tag, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune(tagPunctuation, r))
+		}) >= 0 {
+			return nil, i18n.NewError("result.invalid_tag", i18n.Params{"tag": tag, "max": MaxTagLength})
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > MaxResultTags {
+		return nil, i18n.NewError("result.too_many_tags", i18n.Params{"max": MaxResultTags})
+	}
+	return normalized, nil
+}
+
+// updateResultRequest changes the tags and note of a result. Fields left out
+// are kept.
+type updateResultRequest struct {
+	Tags *[]string `json:"tags"`
+	Note *string   `json:"note"`
+}
+
+// handleUpdateResult tags and annotates a stored result.
+func (s *Server) handleUpdateResult(w http.ResponseWriter, r *http.Request) {
+	var req updateResultRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	result, err := s.storage.GetTestResult(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.result_not_found", nil)
+			return
+		}
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		result.Tags = tags
+	}
+	if req.Note != nil {
+		note := strings.TrimSpace(*req.Note)
+		if utf8.RuneCountInString(note) > MaxNoteLength {
+			s.writeError(w, r, http.StatusBadRequest, "result.note_too_long", i18n.Params{"max": MaxNoteLength})
+			return
+		}
+		result.Note = note
+	}
+
+	if err := s.storage.UpdateTestResultNotes(r.Context(), result.ID, result.Tags, result.Note); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.result_update_failed", i18n.Params{"error": err})
+		return
+	}
+	s.resultsRevision.Add(1)
+	s.audit(r, models.AuditActionResultAnnotate, map[string]interface{}{
+		"id":   result.ID,
+		"tags": result.Tags,
+		"note": result.Note,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
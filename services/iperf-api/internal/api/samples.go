@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxSessionSamples caps the samples kept for one test: an hour at iperf3's
+// shortest 100 ms interval. Later intervals are not stored.
+const maxSessionSamples = 36000
+
+// collectSample keeps a bandwidth update of a test session in progress, to
+// be saved with the session's result. Intervals iperf3 omitted are not part
+// of the result, so they are not kept.
+func (s *Server) collectSample(msg models.WSMessage) {
+	if msg.Type != models.WSMessageTypeBandwidthUpdate {
+		return
+	}
+	u, ok := msg.Payload.(*models.BandwidthUpdate)
+	if !ok || u.SessionID == "" || u.Omitted {
+		return
+	}
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if s.liveSessions[u.ServerPort] != u.SessionID || len(s.sessionSamples[u.SessionID]) >= maxSessionSamples {
+		return
+	}
+	s.sessionSamples[u.SessionID] = append(s.sessionSamples[u.SessionID], models.IntervalSample{
+		Timestamp:     u.Timestamp,
+		IntervalStart: u.IntervalStart,
+		IntervalEnd:   u.IntervalEnd,
+		Bytes:         u.Bytes,
+		BitsPerSecond: u.BitsPerSecond,
+	})
+}
+
+// takeSamples returns and forgets the samples collected for a session.
+func (s *Server) takeSamples(session string) []models.IntervalSample {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	samples := s.sessionSamples[session]
+	delete(s.sessionSamples, session)
+	return samples
+}
+
+// samplePercentiles returns the percentiles of the samples collected for a
+// session so far, or nil if there are none.
+func (s *Server) samplePercentiles(session string) *models.BandwidthPercentiles {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	return models.SamplePercentiles(s.sessionSamples[session])
+}
+
+// WithTestSummaries sends WebSocket clients a test_summary message after
+// each test_complete, with the result and its interval series together.
+func WithTestSummaries(enabled bool) Option {
+	return func(s *Server) {
+		s.testSummaries = enabled
+	}
+}
+
+// broadcastSummary sends a completed test's result and samples, if test
+// summaries are enabled.
+func (s *Server) broadcastSummary(result *models.TestResult, samples []models.IntervalSample) {
+	if !s.testSummaries {
+		return
+	}
+	if samples == nil {
+		samples = []models.IntervalSample{}
+	}
+	s.hub.Broadcast(models.WSMessage{
+		Type:    models.WSMessageTypeTestSummary,
+		Payload: &models.TestSummary{Result: result, Samples: samples},
+	})
+}
+
+// handleGetSamples returns the interval samples of a stored result.
+func (s *Server) handleGetSamples(w http.ResponseWriter, r *http.Request) {
+	samples, err := s.storage.GetTestSamples(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusNotFound, "error.result_not_found", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.samples_failed", i18n.Params{"error": err})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"samples": samples})
+}
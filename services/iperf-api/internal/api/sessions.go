@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// sessionOf returns the test session a message belongs to, or "" for
+// server-wide messages.
+func sessionOf(msg models.WSMessage) string {
+	switch p := msg.Payload.(type) {
+	case *models.ConnectionEvent:
+		return p.SessionID
+	case *models.BandwidthUpdate:
+		return p.SessionID
+	case *models.WatchdogWarning:
+		return p.SessionID
+	case *models.TestResult:
+		return p.ID
+	case *models.TestSummary:
+		return p.Result.ID
+	case models.Alert:
+		return p.ResultID
+	}
+	return ""
+}
+
+// trackSession records the test session in progress on each listener port,
+// ending a previous one on the same port that never completed.
+func (s *Server) trackSession(msg models.WSMessage) {
+	if msg.Type != models.WSMessageTypeClientConnected {
+		return
+	}
+	ev, ok := msg.Payload.(*models.ConnectionEvent)
+	if !ok || ev.SessionID == "" {
+		return
+	}
+
+	s.sessionMu.Lock()
+	previous := s.liveSessions[ev.ServerPort]
+	s.liveSessions[ev.ServerPort] = ev.SessionID
+	delete(s.sessionSamples, previous)
+	s.sessionMu.Unlock()
+
+	if previous != "" {
+		s.hub.EndSession(previous)
+	}
+}
+
+// endSession closes the channels of the session in progress on the result's
+// port, once the result has been saved and checked against alert rules.
+func (s *Server) endSession(result *models.TestResult) {
+	s.sessionMu.Lock()
+	session := s.liveSessions[result.ServerPort]
+	delete(s.liveSessions, result.ServerPort)
+	s.sessionMu.Unlock()
+
+	if session != "" {
+		s.hub.EndSession(session)
+	}
+}
+
+// isLiveSession reports whether id is a test session in progress.
+func (s *Server) isLiveSession(id string) bool {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	for _, session := range s.liveSessions {
+		if id != "" && id == session {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot reports the state a WebSocket client resuming its stream is sent.
+func (s *Server) snapshot() models.WSSnapshot {
+	s.sessionMu.Lock()
+	sessions := make([]string, 0, len(s.liveSessions))
+	for _, session := range s.liveSessions {
+		sessions = append(sessions, session)
+	}
+	s.sessionMu.Unlock()
+	slices.Sort(sessions)
+
+	return models.WSSnapshot{
+		Status:   s.statusPayload(),
+		Live:     s.manager.Live(),
+		Sessions: sessions,
+	}
+}
+
+// handleSessionWebSocket streams the events of one test session: the client
+// connection, bandwidth updates, watchdog warnings, the result and any
+// alerts it raises. The channel closes when the session ends. For a session
+// that has already ended, the saved result is sent before closing. Either
+// way the first message is a hello, as on /ws.
+func (s *Server) handleSessionWebSocket(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if s.isLiveSession(id) {
+		s.hub.serve(w, r, id)
+		return
+	}
+
+	result, err := s.storage.GetTestResult(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusNotFound, "error.session_not_found", i18n.Params{"id": id})
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	conn, err := s.hub.upgrader().Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	format := requestedFormat(r)
+	conn.WriteMessage(format.messageType(), s.hub.encode(helloMessage(format.version), format))
+	conn.WriteMessage(format.messageType(), s.hub.encode(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: result}, format))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
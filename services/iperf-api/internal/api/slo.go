@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/slo"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithObjectives configures the service level objectives served under
+// /api/slo.
+func WithObjectives(objectives []slo.Objective) Option {
+	return func(s *Server) {
+		s.objectives = objectives
+	}
+}
+
+// objective returns the configured objective with the given name.
+func (s *Server) objective(name string) (slo.Objective, bool) {
+	for _, o := range s.objectives {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return slo.Objective{}, false
+}
+
+// evaluateObjective computes an objective's report over [from, to), loading
+// the results its first rolling window needs as well.
+func (s *Server) evaluateObjective(ctx context.Context, o slo.Objective, from, to time.Time) (slo.Report, error) {
+	results, err := s.storage.GetTestResultsBetween(ctx, from.Add(-o.Window()), to)
+	if err != nil {
+		return slo.Report{}, err
+	}
+	return slo.Evaluate(o, results, from, to), nil
+}
+
+// handleListObjectives returns every objective with its SLI over the period,
+// without the daily series.
+func (s *Server) handleListObjectives(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := s.parsePeriod(w, r)
+	if !ok {
+		return
+	}
+
+	reports := make([]slo.Report, 0, len(s.objectives))
+	for _, o := range s.objectives {
+		report, err := s.evaluateObjective(r.Context(), o, from, to)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+			return
+		}
+		report.Series = nil
+		reports = append(reports, report)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"objectives": reports,
+	})
+}
+
+// handleGetObjective returns an objective's SLI with its daily and rolling
+// series, or with format=openslo its OpenSLO definition.
+func (s *Server) handleGetObjective(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	o, found := s.objective(name)
+	if !found {
+		s.writeError(w, r, http.StatusNotFound, "error.slo_not_found", i18n.Params{"name": name})
+		return
+	}
+
+	if r.URL.Query().Get("format") == "openslo" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openSLO(o))
+		return
+	}
+
+	from, to, ok := s.parsePeriod(w, r)
+	if !ok {
+		return
+	}
+	report, err := s.evaluateObjective(r.Context(), o, from, to)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// openSLO describes an objective as an OpenSLO v1 SLO. The ratio's good and
+// total queries name this API's own counts, for importers that read them
+// from GET /api/slo/{name}. JSON is valid YAML, so the document can be fed
+// to OpenSLO tooling as is.
+func openSLO(o slo.Objective) map[string]interface{} {
+	threshold := "max"
+	bound := o.Max
+	if o.Min != nil {
+		threshold, bound = "min", o.Min
+	}
+	query := func(field string) map[string]interface{} {
+		return map[string]interface{}{
+			"metricSource": map[string]interface{}{
+				"type": "iperf-api",
+				"spec": map[string]interface{}{
+					"objective": o.Name,
+					"metric":    o.Metric,
+					threshold:   *bound,
+					"clientIp":  o.ClientIP,
+					"field":     field,
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "openslo/v1",
+		"kind":       "SLO",
+		"metadata": map[string]interface{}{
+			"name": o.Name,
+		},
+		"spec": map[string]interface{}{
+			"description": o.Description,
+			"service":     "iperf-server",
+			"indicator": map[string]interface{}{
+				"metadata": map[string]interface{}{"name": o.Name + "-sli"},
+				"spec": map[string]interface{}{
+					"ratioMetric": map[string]interface{}{
+						"counter": true,
+						"good":    query("good"),
+						"total":   query("total"),
+					},
+				},
+			},
+			"timeWindow": []map[string]interface{}{{
+				"duration":  fmt.Sprintf("%dd", o.WindowDays),
+				"isRolling": true,
+			}},
+			"budgetingMethod": "Occurrences",
+			"objectives": []map[string]interface{}{{
+				"displayName": o.Name,
+				"target":      o.Target,
+			}},
+		},
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// handleGetStatsCache reports the state of the precomputed statistics.
+func (s *Server) handleGetStatsCache(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.storage.ResultStatsStatus())
+}
+
+// handleRebuildStats recomputes the precomputed statistics from every stored
+// result, for when results were written without going through the server.
+// It responds once the rebuild is done.
+func (s *Server) handleRebuildStats(w http.ResponseWriter, r *http.Request) {
+	if err := s.storage.RebuildResultStats(r.Context()); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.stats_rebuild_failed", i18n.Params{"error": err})
+		return
+	}
+	status := s.storage.ResultStatsStatus()
+	s.audit(r, models.AuditActionStatsRebuild, map[string]interface{}{"rows": status.Rows})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
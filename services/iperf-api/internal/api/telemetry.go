@@ -0,0 +1,22 @@
+package api
+
+import (
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/telemetry"
+)
+
+// WithTelemetry records the iperf server's lifecycle and tests as
+// OpenTelemetry spans and metrics.
+func WithTelemetry(observer *telemetry.Observer) Option {
+	return func(s *Server) {
+		s.telemetry = observer
+	}
+}
+
+// observe passes a manager event to the telemetry observer, if any.
+func (s *Server) observe(msg models.WSMessage) {
+	if s.telemetry == nil {
+		return
+	}
+	s.telemetry.Observe(msg)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/netprobe"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// handleStartTraceroute starts a traceroute to a host or to the client of a
+// test result, whose path is then stored with that result. Hops are
+// streamed over the WebSocket as they resolve, followed by the finished
+// trace once it is stored.
+func (s *Server) handleStartTraceroute(w http.ResponseWriter, r *http.Request) {
+	var req models.TracerouteRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeBodyError(w, r, err)
+		return
+	}
+
+	if req.ResultID != "" {
+		result, err := s.storage.GetTestResult(r.Context(), req.ResultID)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.writeError(w, r, http.StatusNotFound, "error.result_not_found", nil)
+			return
+		}
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "error.history_failed", i18n.Params{"error": err})
+			return
+		}
+		if req.Target == "" {
+			req.Target = result.ClientIP
+		}
+	}
+	if err := netprobe.ValidateTraceroute(&req); err != nil {
+		s.writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if s.traceroutes.Add(1) > netprobe.MaxTraceroutes {
+		s.traceroutes.Add(-1)
+		s.writeError(w, r, http.StatusConflict, "traceroute.too_many", i18n.Params{"max": netprobe.MaxTraceroutes})
+		return
+	}
+
+	newID := s.newID
+	if newID == nil {
+		newID = ids.UUID
+	}
+	trace := &models.Traceroute{
+		ID:                newID(),
+		TracerouteRequest: req,
+		Timestamp:         time.Now().UTC(),
+		Hops:              []models.TracerouteHop{},
+	}
+
+	s.audit(r, models.AuditActionTracerouteStart, map[string]interface{}{
+		"traceId":  trace.ID,
+		"target":   req.Target,
+		"resultId": req.ResultID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(trace)
+
+	go s.runTraceroute(*trace)
+}
+
+// runTraceroute runs a trace, streaming its hops, then stores it and sends
+// it to WebSocket clients.
+func (s *Server) runTraceroute(trace models.Traceroute) {
+	defer s.traceroutes.Add(-1)
+
+	err := netprobe.Traceroute(context.Background(), &trace, func(hop models.TracerouteHop) {
+		s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeTracerouteHop, Payload: hop})
+	})
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	trace.Done = true
+	if err := s.storage.SaveTraceroute(context.Background(), &trace); err != nil {
+		log.Printf("Failed to save traceroute to %s: %v", trace.Target, err)
+	}
+	s.hub.Broadcast(models.WSMessage{Type: models.WSMessageTypeTracerouteDone, Payload: trace})
+}
+
+// handleGetTraceroutes returns stored traceroutes, newest first, optionally
+// for one test result.
+func (s *Server) handleGetTraceroutes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	// Default and max limit
+	limit := 50
+	if parsed, err := strconv.Atoi(q.Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset := 0
+	if parsed, err := strconv.Atoi(q.Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+
+	traces, err := s.storage.GetTraceroutes(r.Context(), q.Get("resultId"), limit, offset)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.traceroute_results_failed", i18n.Params{"error": err})
+		return
+	}
+	if traces == nil {
+		traces = []models.Traceroute{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(traces)
+}
+
+// handleGetTraceroute returns one stored traceroute.
+func (s *Server) handleGetTraceroute(w http.ResponseWriter, r *http.Request) {
+	trace, err := s.storage.GetTraceroute(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		s.writeError(w, r, http.StatusNotFound, "error.traceroute_not_found", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "error.traceroute_results_failed", i18n.Params{"error": err})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trace)
+}
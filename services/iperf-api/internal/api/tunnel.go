@@ -0,0 +1,30 @@
+package api
+
+import "github.com/Tom-Oram/fak/backend/internal/models"
+
+// TunnelStatusProvider reports the state of the management tunnel.
+type TunnelStatusProvider interface {
+	Status() models.TunnelStatus
+}
+
+// WithTunnel reports the management tunnel's state in /api/status.
+func WithTunnel(t TunnelStatusProvider) Option {
+	return func(s *Server) {
+		s.tunnel = t
+	}
+}
+
+// Subscribe returns a channel receiving every message broadcast to
+// WebSocket clients, for forwarding over the management tunnel.
+func (s *Server) Subscribe() (<-chan []byte, func()) {
+	return s.hub.Subscribe()
+}
+
+// tunnelStatus returns the tunnel state, or nil when no tunnel is set up.
+func (s *Server) tunnelStatus() *models.TunnelStatus {
+	if s.tunnel == nil {
+		return nil
+	}
+	status := s.tunnel.Status()
+	return &status
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// MaxExportPrecision is the most decimal places an export can ask for.
+const MaxExportPrecision = 12
+
+// bandwidthUnit is a unit bandwidths can be exported in.
+type bandwidthUnit struct {
+	label string
+	scale float64
+}
+
+// bandwidthUnits are the units ?units= takes besides auto, smallest first.
+var bandwidthUnits = map[string]bandwidthUnit{
+	"bps":  {"bps", 1},
+	"kbps": {"Kbps", 1e3},
+	"mbps": {"Mbps", 1e6},
+	"gbps": {"Gbps", 1e9},
+}
+
+// autoUnits are tried largest first by units=auto.
+var autoUnits = []string{"gbps", "mbps", "kbps", "bps"}
+
+// bandwidthColumns are the CSV export's bandwidth columns.
+var bandwidthColumns = map[string]bool{
+	"avg_bandwidth": true, "max_bandwidth": true, "min_bandwidth": true,
+	"sender_bandwidth": true, "receiver_bandwidth": true,
+	"p50_bandwidth": true, "p95_bandwidth": true, "p99_bandwidth": true,
+	"link_capacity": true,
+}
+
+// exportUnits is how an export writes numbers. unit is empty unless ?units=
+// was given, in which case readable bandwidths are added.
+type exportUnits struct {
+	unit string
+	// precision is the decimal places of numbers, and textPrecision of
+	// readable bandwidths
+	precision     int
+	textPrecision int
+}
+
+// parseExportUnits reads ?units= and ?precision=. Without them an export
+// is written as before: bandwidths in bits per second to six places.
+func parseExportUnits(r *http.Request) (exportUnits, error) {
+	u := exportUnits{precision: 6, textPrecision: 1}
+	if v := strings.ToLower(r.URL.Query().Get("units")); v != "" {
+		if _, ok := bandwidthUnits[v]; !ok && v != "auto" {
+			return u, i18n.NewError("export.invalid_units", i18n.Params{"units": v})
+		}
+		u.unit = v
+	}
+	if v := r.URL.Query().Get("precision"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 0 || p > MaxExportPrecision {
+			return u, i18n.NewError("export.invalid_precision", i18n.Params{"max": MaxExportPrecision})
+		}
+		u.precision, u.textPrecision = p, p
+	}
+	return u, nil
+}
+
+// scaled reports whether bandwidth columns are converted from bits per
+// second. With units=auto each value's unit differs, so they are not.
+func (u exportUnits) scaled() bool {
+	return u.unit != "" && u.unit != "auto" && u.unit != "bps"
+}
+
+// columns returns the CSV header: bandwidth columns named after their unit
+// when converted, and readable bandwidths last when units are set.
+func (u exportUnits) columns() []string {
+	columns := append([]string{}, historyCSVColumns...)
+	if u.scaled() {
+		for i, c := range columns {
+			if bandwidthColumns[c] {
+				columns[i] = c + "_" + u.unit
+			}
+		}
+	}
+	if u.unit != "" {
+		columns = append(columns, "avg_bandwidth_text", "max_bandwidth_text", "min_bandwidth_text")
+	}
+	return columns
+}
+
+// decimal formats a number to the export's precision.
+func (u exportUnits) decimal(v float64) string {
+	return strconv.FormatFloat(v, 'f', u.precision, 64)
+}
+
+// bandwidth formats a bandwidth cell, in the export's unit if converted.
+func (u exportUnits) bandwidth(bps float64) string {
+	if u.scaled() {
+		return u.decimal(bps / bandwidthUnits[u.unit].scale)
+	}
+	return u.decimal(bps)
+}
+
+// text formats a bandwidth for people, such as "941.2 Mbps". units=auto
+// picks the largest unit the value reaches.
+func (u exportUnits) text(bps float64) string {
+	unit := bandwidthUnits["bps"]
+	if u.unit == "auto" {
+		for _, name := range autoUnits {
+			if math.Abs(bps) >= bandwidthUnits[name].scale {
+				unit = bandwidthUnits[name]
+				break
+			}
+		}
+	} else if known, ok := bandwidthUnits[u.unit]; ok {
+		unit = known
+	}
+	return strconv.FormatFloat(bps/unit.scale, 'f', u.textPrecision, 64) + " " + unit.label
+}
+
+// textCells returns a result's readable bandwidths, if units are set.
+func (u exportUnits) textCells(r *models.TestResult) []string {
+	if u.unit == "" {
+		return nil
+	}
+	return []string{u.text(r.AvgBandwidth), u.text(r.MaxBandwidth), u.text(r.MinBandwidth)}
+}
+
+// exportedResult is a result in a JSON export. The measurements keep full
+// precision so the export can be imported; Display holds them as text.
+type exportedResult struct {
+	models.TestResult
+	Display *bandwidthText `json:"display,omitempty"`
+}
+
+// bandwidthText is a result's bandwidths formatted for people.
+type bandwidthText struct {
+	AvgBandwidth string `json:"avgBandwidth"`
+	MaxBandwidth string `json:"maxBandwidth"`
+	MinBandwidth string `json:"minBandwidth"`
+}
+
+// jsonResults returns results as a JSON export writes them.
+func (u exportUnits) jsonResults(results []models.TestResult) []exportedResult {
+	out := make([]exportedResult, len(results))
+	for i := range results {
+		out[i].TestResult = results[i]
+		if u.unit != "" {
+			out[i].Display = &bandwidthText{
+				AvgBandwidth: u.text(results[i].AvgBandwidth),
+				MaxBandwidth: u.text(results[i].MaxBandwidth),
+				MinBandwidth: u.text(results[i].MinBandwidth),
+			}
+		}
+	}
+	return out
+}
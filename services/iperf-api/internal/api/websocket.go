@@ -1,10 +1,12 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Tom-Oram/fak/backend/internal/models"
 	"github.com/gorilla/websocket"
@@ -17,51 +19,185 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-// Client represents a WebSocket client connection.
+// Client represents a WebSocket client connection, or a subscriber without
+// one. Each client has its own goroutine, started when it is registered,
+// that takes messages off its queue and writes them, so a slow client holds
+// up no one else.
 type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
+	// queue holds messages for the client until its goroutine writes them.
+	// Only Hub.Run sends on it, and closes it to disconnect the client.
+	queue chan outbound
+	// urgent carries a slow consumer warning, which the client's goroutine
+	// writes ahead of the messages queued before it
+	urgent chan outbound
+	// send receives the messages of a client without a connection, and is
+	// closed once it is disconnected; stop, closed by the subscriber, ends
+	// the goroutine without waiting for them to be read
 	send chan []byte
+	stop chan struct{}
+	// session limits the client to one test session's events; empty receives all
+	session string
+	// pending holds bandwidth updates held back by coalescing, owned by the
+	// client's goroutine
+	pending coalescer
+	// warned is set once the client has been told it is falling behind, and
+	// cleared when its queue drains below half; owned by Hub.Run
+	warned bool
+	// paused stops messages reaching the client until it resumes, counting
+	// them in missed; owned by the client's goroutine
+	paused bool
+	missed int
+	// version is the message schema version negotiated; zero means current
+	version atomic.Int32
+	// encoding is how messages are encoded, fixed when the client connects;
+	// empty means JSON
+	encoding string
 }
 
-// Hub maintains the set of active clients and broadcasts messages to them.
+// Version returns the message schema version the client gets.
+func (c *Client) Version() int {
+	if v := c.version.Load(); v != 0 {
+		return int(v)
+	}
+	return models.WSVersionCurrent
+}
+
+// format returns how the client is sent messages.
+func (c *Client) format() wsFormat {
+	f := wsFormat{version: c.Version(), encoding: c.encoding}
+	if f.encoding == "" {
+		f.encoding = wsEncodingJSON
+	}
+	return f
+}
+
+// outbound is a message for clients and the test session it belongs to, if
+// any. update is set for bandwidth updates, which may be coalesced. control
+// is set instead for a command the client's goroutine acts on.
+type outbound struct {
+	msg     models.WSMessage
+	encoded *encodings
+	session string
+	update  *models.BandwidthUpdate
+	control *control
+}
+
+// encodings holds a broadcast message encoded once per client format, so
+// clients sharing a format share the work. nil means those clients are not
+// sent it.
+type encodings struct {
+	mu   sync.Mutex
+	data map[wsFormat][]byte
+}
+
+// get returns msg encoded for clients of format, encoding it on first use.
+func (e *encodings) get(h *Hub, msg models.WSMessage, format wsFormat) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data, ok := e.data[format]
+	if !ok {
+		data = h.encode(msg, format)
+		e.data[format] = data
+	}
+	return data
+}
+
+// control is a command for a client's goroutine. snapshot is set for
+// resume, warning for a slow consumer warning.
+type control struct {
+	client   *Client
+	action   string
+	snapshot *models.WSSnapshot
+	warning  *models.SlowConsumerWarning
+}
+
+// Hub maintains the set of active clients and hands broadcast messages to
+// their queues.
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan outbound
 	register   chan *Client
 	unregister chan *Client
+	end        chan string
+	control    chan control
 	mu         sync.RWMutex
+
+	// done is closed by Stop; Run then disconnects every client and returns
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// updateInterval, when set, coalesces bandwidth updates so each client
+	// gets at most one per test session per interval. Set before Run.
+	updateInterval time.Duration
+	// bufferSize is the number of messages queued for each client before
+	// it is disconnected as too slow
+	bufferSize int
+	// node is the deployment's name, sent with every message. Set before
+	// Run.
+	node string
+	// compress offers permessage-deflate to clients
+	compress bool
+	// snapshot reports the state a resuming client is sent. Set before
+	// Run.
+	snapshot func() models.WSSnapshot
+
+	metrics hubMetrics
 }
 
+// DefaultClientBuffer is the per-client queue length used when none is set.
+const DefaultClientBuffer = 1024
+
 // NewHub creates and returns a new Hub instance.
 func NewHub() *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan outbound),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		end:        make(chan string),
+		control:    make(chan control),
+		done:       make(chan struct{}),
+		bufferSize: DefaultClientBuffer,
+	}
+}
+
+// newClient returns a client with an empty queue, for a connection or, when
+// conn is nil, a subscriber.
+func (h *Hub) newClient(conn *websocket.Conn, session string) *Client {
+	return &Client{
+		hub:     h,
+		conn:    conn,
+		session: session,
+		queue:   make(chan outbound, h.bufferSize),
+		urgent:  make(chan outbound, 1),
 	}
 }
 
-// Run starts the hub's main event loop. It should be run in a goroutine.
+// Run starts the hub's main event loop until Stop is called. It should be
+// run in a goroutine. It only queues messages for clients, which never
+// blocks: a client whose queue is full is disconnected instead.
 func (h *Hub) Run() {
 	for {
 		select {
+		case <-h.done:
+			h.disconnectAll()
+			return
+
 		case client := <-h.register:
 			h.mu.Lock()
 			h.clients[client] = true
 			count := len(h.clients)
 			h.mu.Unlock()
+			go client.writeLoop()
 			log.Printf("WebSocket client connected, total clients: %d", count)
 
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
+			h.disconnect(client)
+			h.mu.RLock()
 			count := len(h.clients)
-			h.mu.Unlock()
+			h.mu.RUnlock()
 			log.Printf("WebSocket client disconnected, total clients: %d", count)
 
 		case message := <-h.broadcast:
@@ -73,58 +209,418 @@ func (h *Hub) Run() {
 			h.mu.RUnlock()
 
 			for _, client := range clients {
-				select {
-				case client.send <- message:
-				default:
-					h.mu.Lock()
-					delete(h.clients, client)
-					close(client.send)
-					h.mu.Unlock()
+				if client.session != "" && client.session != message.session {
+					continue
+				}
+				h.deliver(client, message)
+			}
+
+		case c := <-h.control:
+			h.mu.RLock()
+			_, connected := h.clients[c.client]
+			h.mu.RUnlock()
+			if connected {
+				h.deliver(c.client, outbound{control: &c})
+			}
+
+		case session := <-h.end:
+			// Session channels close once their session is over; their
+			// goroutines write what is queued first
+			h.mu.RLock()
+			var ended []*Client
+			for client := range h.clients {
+				if client.session == session {
+					ended = append(ended, client)
 				}
 			}
+			h.mu.RUnlock()
+
+			for _, client := range ended {
+				h.disconnect(client)
+			}
 		}
 	}
 }
 
+// Stop ends Run, which closes every client's queue so connections are
+// sent a close frame and closed. Messages sent to a stopped hub are
+// dropped. It is safe to call more than once.
+func (h *Hub) Stop() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// disconnect removes a client and closes its queue, unless it has been
+// removed already.
+func (h *Hub) disconnect(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.queue)
+	}
+}
+
+// disconnectAll closes the queues of every client.
+func (h *Hub) disconnectAll() {
+	h.mu.Lock()
+	count := len(h.clients)
+	for client := range h.clients {
+		delete(h.clients, client)
+		close(client.queue)
+	}
+	h.mu.Unlock()
+	if count > 0 {
+		log.Printf("WebSocket hub stopped, disconnected %d clients", count)
+	}
+}
+
+// deliver queues a message for a client. A client whose queue is three
+// quarters full is warned first; one whose queue is full is disconnected.
+func (h *Hub) deliver(client *Client, out outbound) {
+	queued, capacity := len(client.queue), cap(client.queue)
+	if client.warned && queued < capacity/2 {
+		client.warned = false
+	}
+	if !client.warned && capacity > 1 && queued >= capacity*3/4 {
+		client.warned = true
+		h.metrics.slowWarnings.Add(1)
+		h.warnSlow(client, queued, capacity)
+	}
+
+	select {
+	case client.queue <- out:
+		h.metrics.sent.Add(1)
+	default:
+		h.metrics.dropped.Add(1)
+		h.metrics.slowDisconnects.Add(1)
+		log.Printf("Disconnecting slow WebSocket client with %d messages queued", capacity)
+		h.drop(client)
+	}
+}
+
+// drop disconnects a client that has fallen behind. Its connection is
+// closed too, since its goroutine may be stuck writing to it and never
+// take the close of its queue.
+func (h *Hub) drop(client *Client) {
+	h.disconnect(client)
+	if client.conn != nil {
+		client.conn.Close()
+	}
+}
+
+// warnSlow sends the client a slow_consumer warning ahead of its queue, so
+// it is not stuck behind the backlog it warns of.
+func (h *Hub) warnSlow(client *Client, queued, capacity int) {
+	warning := &models.SlowConsumerWarning{
+		Timestamp: time.Now(),
+		Queued:    queued,
+		Capacity:  capacity,
+	}
+	select {
+	case client.urgent <- outbound{control: &control{client: client, action: "warn", warning: warning}}:
+	default:
+	}
+}
+
+// writeLoop is the client's goroutine. It writes the messages queued for
+// the client, holding back bandwidth updates to coalesce them and anything
+// while paused, until the queue is closed and drained. A warning is written
+// as soon as the message being written is done.
+func (c *Client) writeLoop() {
+	defer c.close()
+
+	var flush <-chan time.Time
+	if c.hub.updateInterval > 0 {
+		ticker := time.NewTicker(c.hub.updateInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
+	for {
+		var out outbound
+		select {
+		case out = <-c.urgent:
+		default:
+			select {
+			case out = <-c.urgent:
+			case queued, ok := <-c.queue:
+				if !ok {
+					// Held back updates still go out to a disconnected client
+					if !c.paused {
+						c.flush()
+					}
+					c.closeConn()
+					return
+				}
+				out = queued
+
+			case <-flush:
+				if c.paused {
+					continue
+				}
+				if err := c.flush(); err != nil {
+					return
+				}
+				continue
+
+			case <-c.stop:
+				return
+			}
+		}
+		if err := c.handle(out); err != nil {
+			if err != errUnsubscribed {
+				log.Printf("WebSocket write error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// handle writes a queued message or acts on a command.
+func (c *Client) handle(out outbound) error {
+	if out.control != nil {
+		return c.handleControl(*out.control)
+	}
+	if c.paused {
+		c.missed++
+		return nil
+	}
+	format := c.format()
+	var data []byte
+	if out.encoded != nil {
+		data = out.encoded.get(c.hub, out.msg, format)
+	} else {
+		data = c.hub.encode(out.msg, format)
+	}
+	if data == nil {
+		return nil
+	}
+	if out.update != nil && c.hub.updateInterval > 0 {
+		c.pending.add(out.update)
+		return nil
+	}
+	// Held back updates go first so clients see events in order
+	if err := c.flush(); err != nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// handleControl acts on a command. A hello is answered with the version
+// chosen. A paused client is sent nothing, its held back updates included,
+// until it resumes; it is then sent the snapshot and how many messages it
+// missed. A slow consumer warning is sent even while paused.
+func (c *Client) handleControl(cmd control) error {
+	format := c.format()
+	switch cmd.action {
+	case "hello":
+		return c.writeMessage(helloMessage(format.version))
+	case "warn":
+		return c.writeMessage(models.WSMessage{Type: models.WSMessageTypeSlowConsumer, Payload: *cmd.warning})
+	case "pause":
+		c.paused = true
+		c.pending.reset()
+	case "resume":
+		if !c.paused {
+			return nil
+		}
+		cmd.snapshot.Missed = c.missed
+		c.paused, c.missed = false, 0
+		return c.writeMessage(models.WSMessage{Type: models.WSMessageTypeSnapshot, Payload: cmd.snapshot})
+	}
+	return nil
+}
+
+// flush writes the client's coalesced bandwidth updates.
+func (c *Client) flush() error {
+	for _, update := range c.pending.take() {
+		if err := c.writeMessage(models.WSMessage{Type: models.WSMessageTypeBandwidthUpdate, Payload: update}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMessage encodes msg for the client and writes it, unless the client
+// is not sent it.
+func (c *Client) writeMessage(msg models.WSMessage) error {
+	if data := c.hub.encode(msg, c.format()); data != nil {
+		return c.write(data)
+	}
+	return nil
+}
+
+// errUnsubscribed ends the goroutine of a subscriber that has gone.
+var errUnsubscribed = errors.New("unsubscribed")
+
+// wsWriteTimeout bounds each write to a client, so a peer that stops
+// reading fails the write instead of holding its goroutine forever.
+const wsWriteTimeout = 10 * time.Second
+
+// write sends data over the client's connection, or to its channel.
+func (c *Client) write(data []byte) error {
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return c.conn.WriteMessage(c.format().messageType(), data)
+	}
+	select {
+	case c.send <- data:
+		return nil
+	case <-c.stop:
+		return errUnsubscribed
+	}
+}
+
+// closeConn tells a connected peer the hub is done with it.
+func (c *Client) closeConn() {
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+}
+
+// close closes the client's connection, or its channel.
+func (c *Client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		return
+	}
+	close(c.send)
+}
+
 // Broadcast sends a WebSocket message to all connected clients.
 func (h *Hub) Broadcast(msg models.WSMessage) {
-	data, err := json.Marshal(msg)
+	data := h.encode(msg, currentFormat)
+	if data == nil {
+		return
+	}
+	out := outbound{
+		msg:     msg,
+		encoded: &encodings{data: map[wsFormat][]byte{currentFormat: data}},
+		session: sessionOf(msg),
+	}
+	if update, ok := msg.Payload.(*models.BandwidthUpdate); ok && msg.Type == models.WSMessageTypeBandwidthUpdate {
+		out.update = update
+	}
+	select {
+	case h.broadcast <- out:
+	case <-h.done:
+	}
+}
+
+// encode marshals msg as sent by this deployment to clients of format. It
+// returns nil if those clients are not sent msg, or it cannot be marshaled.
+func (h *Hub) encode(msg models.WSMessage, format wsFormat) []byte {
+	msg, ok := downgrade(msg, format.version)
+	if !ok {
+		return nil
+	}
+	msg.Node = h.node
+	data, err := format.marshal(msg)
 	if err != nil {
 		log.Printf("Error marshaling WebSocket message: %v", err)
-		return
+		return nil
+	}
+	return data
+}
+
+// EndSession disconnects clients following a test session once they have
+// been sent what is queued for them.
+func (h *Hub) EndSession(session string) {
+	select {
+	case h.end <- session:
+	case <-h.done:
+	}
+}
+
+// Subscribe registers a client without a connection that receives every
+// message on the returned channel. The channel is closed by unsubscribe, by
+// Stop, or early if the subscriber falls behind like any other slow client.
+func (h *Hub) Subscribe() (<-chan []byte, func()) {
+	client := h.newClient(nil, "")
+	client.send, client.stop = make(chan []byte), make(chan struct{})
+	var once sync.Once
+	if !h.add(client) {
+		close(client.send)
+		return client.send, func() {}
+	}
+	return client.send, func() {
+		once.Do(func() {
+			close(client.stop)
+			h.remove(client)
+		})
+	}
+}
+
+// add registers a client, reporting false if the hub has stopped.
+func (h *Hub) add(client *Client) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// remove unregisters a client, unless the hub has stopped and disconnected
+// it already.
+func (h *Hub) remove(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+// command passes a client's command to Run, unless the hub has stopped.
+func (h *Hub) command(c control) {
+	select {
+	case h.control <- c:
+	case <-h.done:
 	}
-	h.broadcast <- data
 }
 
 // HandleWebSocket handles WebSocket upgrade requests and manages the connection.
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	h.serve(w, r, "")
+}
+
+// serve upgrades the connection and registers a client for all events, or
+// for one test session's events when session is set. The client gets the
+// message schema version it asks for with ?version=, the current one by
+// default, and is greeted with a hello saying which. ?encoding=msgpack
+// switches it to MessagePack in binary frames.
+func (h *Hub) serve(w http.ResponseWriter, r *http.Request, session string) {
+	conn, err := h.upgrader().Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	client := &Client{
-		hub:  h,
-		conn: conn,
-		send: make(chan []byte, 256),
-	}
+	client := h.newClient(conn, session)
+	format := requestedFormat(r)
+	client.version.Store(int32(format.version))
+	client.encoding = format.encoding
+	// Nothing else can be queued before the client is registered
+	client.queue <- outbound{control: &control{client: client, action: "hello"}}
 
-	h.register <- client
+	if !h.add(client) {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		conn.Close()
+		return
+	}
 
-	go client.writePump()
 	go client.readPump()
 }
 
 // readPump reads messages from the WebSocket connection.
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.remove(c)
 		c.conn.Close()
 	}()
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket read error: %v", err)
@@ -134,29 +630,37 @@ func (c *Client) readPump() {
 
 		// Parse incoming commands
 		var cmd struct {
-			Action string              `json:"action"`
-			Config *models.ServerConfig `json:"config,omitempty"`
+			Action  string               `json:"action"`
+			Config  *models.ServerConfig `json:"config,omitempty"`
+			Version int                  `json:"version,omitempty"`
 		}
-		if err := json.Unmarshal(message, &cmd); err != nil {
+		if err := unmarshalCommand(messageType, message, &cmd); err != nil {
 			log.Printf("Error parsing WebSocket command: %v", err)
 			continue
 		}
 
+		// A hello renegotiates the schema version; the hub answers with
+		// the version chosen. Pause and resume stop and restart the stream,
+		// so a hidden dashboard need not disconnect.
+		switch cmd.Action {
+		case "hello":
+			c.version.Store(int32(negotiateVersion(cmd.Version)))
+			c.hub.command(control{client: c, action: cmd.Action})
+			continue
+		case "pause":
+			c.hub.command(control{client: c, action: cmd.Action})
+			continue
+		case "resume":
+			// Taken here, so the hub never waits on the server
+			snapshot := models.WSSnapshot{Sessions: []string{}}
+			if c.hub.snapshot != nil {
+				snapshot = c.hub.snapshot()
+			}
+			c.hub.command(control{client: c, action: cmd.Action, snapshot: &snapshot})
+			continue
+		}
+
 		log.Printf("Received WebSocket command: action=%s", cmd.Action)
 		// Commands are logged but not processed here - actual handling would be done by the server manager
 	}
 }
-
-// writePump writes messages from the send channel to the WebSocket connection.
-func (c *Client) writePump() {
-	defer func() {
-		c.conn.Close()
-	}()
-
-	for message := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Printf("WebSocket write error: %v", err)
-			return
-		}
-	}
-}
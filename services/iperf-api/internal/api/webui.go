@@ -0,0 +1,43 @@
+package api
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/webui"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithWebUI serves the dashboard bundle in fsys at / alongside the API.
+func WithWebUI(fsys fs.FS) Option {
+	return func(s *Server) {
+		s.webUI = fsys
+	}
+}
+
+// webUIRoutes serves the dashboard for every path the API does not handle.
+// The dashboard reaches the API under /iperf, as it does through the nginx
+// frontend, so the API is mounted there too.
+func (s *Server) webUIRoutes(r chi.Router) {
+	r.Route("/iperf", s.apiRoutes)
+
+	ui := webui.Handler(s.webUI)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		// Unknown API paths are errors, not pages of the dashboard
+		if isAPIPath(r.URL.Path) {
+			s.handleNotFound(w, r)
+			return
+		}
+		ui.ServeHTTP(w, r)
+	})
+}
+
+func isAPIPath(path string) bool {
+	for _, prefix := range []string{"/api/", "/iperf/", "/ws/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
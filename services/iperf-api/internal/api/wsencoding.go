@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encodings WebSocket clients can ask for with ?encoding=.
+const (
+	wsEncodingJSON    = "json"
+	wsEncodingMsgpack = "msgpack"
+)
+
+// WithCompression offers permessage-deflate to WebSocket clients. Browsers
+// accept it, trading server CPU for bandwidth.
+func WithCompression(enabled bool) Option {
+	return func(s *Server) {
+		s.hub.compress = enabled
+	}
+}
+
+// wsFormat is how messages are sent to a client: the schema version and
+// the encoding.
+type wsFormat struct {
+	version  int
+	encoding string
+}
+
+// currentFormat is the format of clients that ask for nothing.
+var currentFormat = wsFormat{version: models.WSVersionCurrent, encoding: wsEncodingJSON}
+
+// requestedFormat negotiates the format a connection asks for with
+// ?version= and ?encoding=. Unknown encodings get JSON.
+func requestedFormat(r *http.Request) wsFormat {
+	f := wsFormat{version: requestedVersion(r), encoding: wsEncodingJSON}
+	if r.URL.Query().Get("encoding") == wsEncodingMsgpack {
+		f.encoding = wsEncodingMsgpack
+	}
+	return f
+}
+
+// messageType is the WebSocket frame type messages in the format use.
+func (f wsFormat) messageType() int {
+	if f.encoding == wsEncodingMsgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// marshal encodes msg. MessagePack uses the JSON field names, so both
+// encodings decode to the same objects.
+func (f wsFormat) marshal(msg models.WSMessage) ([]byte, error) {
+	if f.encoding != wsEncodingMsgpack {
+		return json.Marshal(msg)
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalCommand decodes a command a client sent in a frame of messageType.
+func unmarshalCommand(messageType int, data []byte, v any) error {
+	if messageType != websocket.BinaryMessage {
+		return json.Unmarshal(data, v)
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// upgrader returns the upgrader for the hub's connections.
+func (h *Hub) upgrader() *websocket.Upgrader {
+	u := upgrader
+	u.EnableCompression = h.compress
+	return &u
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// wsVersions are the message schema versions clients can ask for, oldest
+// first.
+var wsVersions = []int{models.WSVersion1, models.WSVersion2}
+
+// wsDowngrades turn a message of each version into the one before it, or
+// report that clients of the older version do not get it at all, such as a
+// message type the older version lacks. Each schema change adds a step
+// here, so old dashboards keep getting what they understand.
+var wsDowngrades = map[int]func(models.WSMessage) (models.WSMessage, bool){
+	// Version 1 only lacks the version field, which encode sets last
+	models.WSVersion2: func(msg models.WSMessage) (models.WSMessage, bool) { return msg, true },
+}
+
+// negotiateVersion returns the version to use for a client asking for
+// requested: the newest supported version not above it, or the current
+// version if it asked for none.
+func negotiateVersion(requested int) int {
+	if requested <= 0 {
+		return models.WSVersionCurrent
+	}
+	chosen := wsVersions[0]
+	for _, v := range wsVersions {
+		if v <= requested {
+			chosen = v
+		}
+	}
+	return chosen
+}
+
+// requestedVersion negotiates the version a connection asks for with
+// ?version=.
+func requestedVersion(r *http.Request) int {
+	requested, _ := strconv.Atoi(r.URL.Query().Get("version"))
+	return negotiateVersion(requested)
+}
+
+// downgrade returns msg, in the current version's schema, as clients of
+// version expect it, or false if they are not sent it. The hello exchange
+// is understood by every version.
+func downgrade(msg models.WSMessage, version int) (models.WSMessage, bool) {
+	if msg.Type != models.WSMessageTypeHello {
+		for v := models.WSVersionCurrent; v > version; v-- {
+			var ok bool
+			if msg, ok = wsDowngrades[v](msg); !ok {
+				return msg, false
+			}
+		}
+	}
+	msg.Version = 0
+	if version > models.WSVersion1 {
+		msg.Version = version
+	}
+	return msg, true
+}
+
+// helloMessage tells a client the version its messages use.
+func helloMessage(version int) models.WSMessage {
+	return models.WSMessage{
+		Type:    models.WSMessageTypeHello,
+		Payload: models.WSHello{Version: version, Versions: wsVersions},
+	}
+}
@@ -0,0 +1,107 @@
+// Package auth maps API keys to roles. Viewers may read; operators may also
+// control the server and change its settings.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role is the level of access granted to an API key.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+)
+
+// rank orders roles so that a higher role includes the lower ones.
+var rank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+}
+
+// Allows reports whether r grants the access required.
+func (r Role) Allows(required Role) bool {
+	return rank[r] >= rank[required]
+}
+
+// Keys maps API keys to roles. Keys are held as SHA-256 hashes so they are
+// not compared byte by byte.
+type Keys struct {
+	roles map[[sha256.Size]byte]Role
+}
+
+// ParseKeys parses a comma-separated list of role:key entries, e.g.
+// "viewer:abc123,operator:def456".
+func ParseKeys(spec string) (*Keys, error) {
+	k := &Keys{roles: make(map[[sha256.Size]byte]Role)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		role := Role(strings.TrimSpace(name))
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: want role:key", name)
+		}
+		if _, known := rank[role]; !known {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+		sum := sha256.Sum256([]byte(key))
+		if _, dup := k.roles[sum]; dup {
+			return nil, fmt.Errorf("duplicate API key for role %q", role)
+		}
+		k.roles[sum] = role
+	}
+	return k, nil
+}
+
+// Enabled reports whether any keys are configured. Without keys the API is
+// open and every request acts as an operator.
+func (k *Keys) Enabled() bool {
+	return k != nil && len(k.roles) > 0
+}
+
+// Lookup returns the role granted to key.
+func (k *Keys) Lookup(key string) (Role, bool) {
+	if !k.Enabled() || key == "" {
+		return "", false
+	}
+	role, ok := k.roles[sha256.Sum256([]byte(key))]
+	return role, ok
+}
+
+// KeyFromRequest returns the API key presented with a request: the
+// X-API-Key header, a Bearer token, or for WebSocket upgrades, which
+// browsers cannot add headers to, the api_key query parameter.
+func KeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("api_key")
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+// WithRole returns a context carrying the authenticated role.
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, contextKey{}, role)
+}
+
+// RoleFrom returns the role stored by WithRole.
+func RoleFrom(ctx context.Context) (Role, bool) {
+	role, ok := ctx.Value(contextKey{}).(Role)
+	return role, ok
+}
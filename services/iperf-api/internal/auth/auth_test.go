@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(" viewer:read-key , operator:op-key,")
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	if !keys.Enabled() {
+		t.Fatal("Enabled() = false, want true")
+	}
+
+	tests := []struct {
+		key  string
+		role Role
+		ok   bool
+	}{
+		{"read-key", RoleViewer, true},
+		{"op-key", RoleOperator, true},
+		{"other", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		role, ok := keys.Lookup(tt.key)
+		if role != tt.role || ok != tt.ok {
+			t.Errorf("Lookup(%q) = %q, %v; want %q, %v", tt.key, role, ok, tt.role, tt.ok)
+		}
+	}
+
+	for _, spec := range []string{"admin:k", "viewer", "viewer:", "viewer:k,operator:k"} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("ParseKeys(%q) = nil error, want error", spec)
+		}
+	}
+
+	empty, err := ParseKeys("")
+	if err != nil || empty.Enabled() {
+		t.Errorf("ParseKeys(\"\") = %v, %v; want disabled keys", empty, err)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	if !RoleOperator.Allows(RoleViewer) || !RoleOperator.Allows(RoleOperator) || !RoleViewer.Allows(RoleViewer) {
+		t.Error("role should allow itself and lower roles")
+	}
+	if RoleViewer.Allows(RoleOperator) || Role("").Allows(RoleViewer) {
+		t.Error("role should not allow higher roles")
+	}
+}
+
+func TestKeyFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/status?api_key=query", nil)
+	if key := KeyFromRequest(req); key != "" {
+		t.Errorf("query key on plain request = %q, want ignored", key)
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	if key := KeyFromRequest(req); key != "query" {
+		t.Errorf("WebSocket key = %q, want query", key)
+	}
+
+	req.Header.Set("Authorization", "Bearer bearer")
+	if key := KeyFromRequest(req); key != "bearer" {
+		t.Errorf("Bearer key = %q, want bearer", key)
+	}
+
+	req.Header.Set("X-API-Key", "header")
+	if key := KeyFromRequest(req); key != "header" {
+		t.Errorf("header key = %q, want header", key)
+	}
+}
+
+func TestRoleContext(t *testing.T) {
+	if _, ok := RoleFrom(context.Background()); ok {
+		t.Error("RoleFrom on empty context should report no role")
+	}
+	if role, ok := RoleFrom(WithRole(context.Background(), RoleViewer)); !ok || role != RoleViewer {
+		t.Errorf("RoleFrom = %q, %v; want viewer", role, ok)
+	}
+}
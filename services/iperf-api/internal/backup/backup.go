@@ -0,0 +1,275 @@
+// Package backup takes periodic snapshots of the database and keeps the
+// most recent ones in a local directory or an S3-compatible bucket.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backups are named for the time they were taken, so their names sort in
+// the order they were taken.
+const (
+	namePrefix = "iperf-"
+	nameSuffix = ".db"
+	nameTime   = "20060102T150405Z"
+)
+
+// Object is a stored backup.
+type Object struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Target stores backups.
+type Target interface {
+	// Name says where backups go, for logs and status
+	Name() string
+	// Put stores size bytes from body as name, replacing any object with
+	// that name
+	Put(ctx context.Context, name string, body io.ReadSeeker, size int64) error
+	// List returns every object at the target, in any order
+	List(ctx context.Context) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Snapshot writes a consistent copy of the database to path.
+type Snapshot func(ctx context.Context, path string) error
+
+// Config configures scheduled backups.
+type Config struct {
+	// Interval is how often a backup is taken
+	Interval time.Duration
+	// Keep is how many backups are kept; older ones are deleted after each
+	// new backup
+	Keep int
+	// Timeout bounds each scheduled backup, including the upload
+	Timeout time.Duration
+}
+
+// Validate checks the retention and applies defaults.
+func (c *Config) Validate() error {
+	if c.Keep < 0 {
+		return errors.New("the number of backups to keep must not be negative")
+	}
+	if c.Interval <= 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.Keep == 0 {
+		c.Keep = 7
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Minute
+	}
+	return nil
+}
+
+// Status reports the scheduler's settings and how its last backup went.
+type Status struct {
+	Target          string     `json:"target"`
+	IntervalSeconds int        `json:"intervalSeconds"`
+	Keep            int        `json:"keep"`
+	Running         bool       `json:"running"`
+	NextBackup      *time.Time `json:"nextBackup,omitempty"`
+	LastBackup      *Object    `json:"lastBackup,omitempty"`
+	// LastDurationMs is how long the last successful backup took
+	LastDurationMs int64  `json:"lastDurationMs"`
+	LastError      string `json:"lastError,omitempty"`
+	// Backups counts the backups taken since startup
+	Backups int `json:"backups"`
+}
+
+// Scheduler takes a backup every interval and prunes old ones.
+type Scheduler struct {
+	cfg      Config
+	snapshot Snapshot
+	target   Target
+	now      func() time.Time
+
+	// runMu lets one backup run at a time
+	runMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewScheduler creates a Scheduler for a validated config.
+func NewScheduler(cfg Config, snapshot Snapshot, target Target) *Scheduler {
+	return &Scheduler{
+		cfg:      cfg,
+		snapshot: snapshot,
+		target:   target,
+		now:      time.Now,
+		done:     make(chan struct{}),
+		status: Status{
+			Target:          target.Name(),
+			IntervalSeconds: int(cfg.Interval / time.Second),
+			Keep:            cfg.Keep,
+		},
+	}
+}
+
+// Run takes a backup every interval until Close is called.
+func (s *Scheduler) Run() {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		s.setNext(s.now().Add(s.cfg.Interval))
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		if obj, err := s.Backup(ctx); err != nil {
+			log.Printf("Backup failed: %v", err)
+		} else {
+			log.Printf("Backed up %d bytes to %s as %s", obj.Size, s.target.Name(), obj.Name)
+		}
+		cancel()
+	}
+}
+
+func (s *Scheduler) setNext(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.NextBackup = &at
+}
+
+// Close stops scheduled backups.
+func (s *Scheduler) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Status returns the settings and the outcome of the last backup.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	if status.NextBackup != nil {
+		at := *status.NextBackup
+		status.NextBackup = &at
+	}
+	if status.LastBackup != nil {
+		obj := *status.LastBackup
+		status.LastBackup = &obj
+	}
+	return status
+}
+
+// Backup takes a backup now, stores it at the target and deletes all but
+// the newest Keep backups. A backup that was stored but could not prune
+// older ones is returned along with the error.
+func (s *Scheduler) Backup(ctx context.Context) (Object, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	s.mu.Lock()
+	s.status.Running = true
+	s.mu.Unlock()
+
+	started := s.now()
+	obj, err := s.backup(ctx, started)
+	if err == nil {
+		if err = s.prune(ctx); err != nil {
+			err = fmt.Errorf("deleting old backups: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	if obj.Name != "" {
+		s.status.LastBackup = &obj
+		s.status.LastDurationMs = s.now().Sub(started).Milliseconds()
+		s.status.Backups++
+	}
+	if err != nil {
+		s.status.LastError = err.Error()
+		return obj, err
+	}
+	s.status.LastError = ""
+	return obj, nil
+}
+
+// backup snapshots the database to a scratch directory and uploads it.
+func (s *Scheduler) backup(ctx context.Context, at time.Time) (Object, error) {
+	dir, err := os.MkdirTemp("", "iperf-backup-")
+	if err != nil {
+		return Object{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	name := namePrefix + at.UTC().Format(nameTime) + nameSuffix
+	path := filepath.Join(dir, name)
+	if err := s.snapshot(ctx, path); err != nil {
+		return Object{}, fmt.Errorf("snapshot: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Object{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Object{}, err
+	}
+	if err := s.target.Put(ctx, name, f, info.Size()); err != nil {
+		return Object{}, fmt.Errorf("storing %s: %w", name, err)
+	}
+	return Object{Name: name, Size: info.Size(), Modified: at.UTC()}, nil
+}
+
+// prune deletes backups beyond the newest Keep.
+func (s *Scheduler) prune(ctx context.Context) error {
+	backups, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for i := s.cfg.Keep; i < len(backups); i++ {
+		if err := s.target.Delete(ctx, backups[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the backups at the target, newest first. Other objects at
+// the target are left out.
+func (s *Scheduler) List(ctx context.Context) ([]Object, error) {
+	objects, err := s.target.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backups := []Object{}
+	for _, obj := range objects {
+		if isBackup(obj.Name) {
+			backups = append(backups, obj)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// isBackup reports whether name is one the scheduler gave a backup.
+func isBackup(name string) bool {
+	if !strings.HasPrefix(name, namePrefix) || !strings.HasSuffix(name, nameSuffix) {
+		return false
+	}
+	_, err := time.Parse(nameTime, strings.TrimSuffix(strings.TrimPrefix(name, namePrefix), nameSuffix))
+	return err == nil
+}
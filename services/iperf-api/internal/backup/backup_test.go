@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testScheduler backs up to a directory, one minute apart, with a snapshot
+// holding the time it was taken.
+func testScheduler(t *testing.T, keep int) (*Scheduler, string) {
+	t.Helper()
+	dir := t.TempDir()
+	target, err := NewDir(dir)
+	if err != nil {
+		t.Fatalf("NewDir: %v", err)
+	}
+	cfg := Config{Keep: keep}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	clock := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := NewScheduler(cfg, func(ctx context.Context, path string) error {
+		return os.WriteFile(path, []byte(clock.String()), 0o644)
+	}, target)
+	s.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	return s, dir
+}
+
+func TestScheduler_KeepsNewest(t *testing.T) {
+	s, dir := testScheduler(t, 2)
+	ctx := context.Background()
+	// Files that are not backups are never pruned
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0o644)
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		obj, err := s.Backup(ctx)
+		if err != nil {
+			t.Fatalf("Backup: %v", err)
+		}
+		names = append(names, obj.Name)
+	}
+	if names[0] != "iperf-20240301T100100Z.db" {
+		t.Errorf("first backup named %s", names[0])
+	}
+
+	backups, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(backups) != 2 || backups[0].Name != names[2] || backups[1].Name != names[1] {
+		t.Errorf("List = %+v, want the newest two of %v", backups, names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+
+	status := s.Status()
+	if status.Backups != 3 || status.LastBackup == nil || status.LastBackup.Name != names[2] ||
+		status.LastBackup.Size == 0 || status.LastError != "" || status.Running {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestScheduler_ReportsFailure(t *testing.T) {
+	s, dir := testScheduler(t, 2)
+	ctx := context.Background()
+	if _, err := s.Backup(ctx); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	s.snapshot = func(ctx context.Context, path string) error { return errors.New("disk full") }
+	if _, err := s.Backup(ctx); err == nil {
+		t.Fatal("Backup succeeded with a failing snapshot")
+	}
+	status := s.Status()
+	if status.LastError != "snapshot: disk full" || status.Backups != 1 || status.LastBackup == nil {
+		t.Errorf("status = %+v, want the error alongside the earlier backup", status)
+	}
+
+	// Nothing half-written is left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want the one backup", len(entries))
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := Config{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if cfg.Interval != 24*time.Hour || cfg.Keep != 7 {
+		t.Errorf("defaults = %+v", cfg)
+	}
+	if err := (&Config{Keep: -1}).Validate(); err == nil {
+		t.Error("negative Keep accepted")
+	}
+}
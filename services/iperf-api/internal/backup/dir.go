@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir keeps backups in a local directory, which may be a mounted volume.
+type Dir struct {
+	path string
+}
+
+// NewDir creates the directory if needed and returns a target for it.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+// Name implements Target.
+func (d *Dir) Name() string {
+	return d.path
+}
+
+// Put implements Target. The backup is written under a temporary name and
+// renamed, so a listing never shows a partial file.
+func (d *Dir) Put(ctx context.Context, name string, body io.ReadSeeker, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.path, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.path, filepath.Base(name)))
+}
+
+// List implements Target. Hidden files, such as backups being written, are
+// left out.
+func (d *Dir) List(ctx context.Context) ([]Object, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// Deleted since the directory was read
+			continue
+		}
+		objects = append(objects, Object{Name: e.Name(), Size: info.Size(), Modified: info.ModTime().UTC()})
+	}
+	return objects, nil
+}
+
+// Delete implements Target.
+func (d *Dir) Delete(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(d.path, filepath.Base(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+// Package buildinfo identifies the running build, from values set when it
+// was linked or, failing those, what the Go toolchain recorded.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Set when linking, for builds made outside a git checkout:
+//
+//	go build -ldflags "-X github.com/Tom-Oram/fak/backend/internal/buildinfo.Version=v1.4.0 \
+//	    -X github.com/Tom-Oram/fak/backend/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/Tom-Oram/fak/backend/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// devVersion is reported by builds without a version
+const devVersion = "dev"
+
+// Get returns the running build's information.
+func Get() models.BuildInfo {
+	bi, _ := debug.ReadBuildInfo()
+	return resolve(bi)
+}
+
+// resolve fills in what was not set when linking from bi, which may be nil.
+func resolve(bi *debug.BuildInfo) models.BuildInfo {
+	info := models.BuildInfo{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version()}
+	if bi != nil {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		// The toolchain's VCS settings only describe the linked commit
+		if Commit == "" {
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					info.Commit = s.Value
+				case "vcs.time":
+					if info.BuildDate == "" {
+						info.BuildDate = s.Value
+					}
+				case "vcs.modified":
+					info.Modified = s.Value == "true"
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = devVersion
+	}
+	return info
+}
@@ -0,0 +1,38 @@
+package buildinfo
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	vcs := &debug.BuildInfo{
+		Main: debug.Module{Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2026-10-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	// Without link-time values the toolchain's are used
+	got := resolve(vcs)
+	if got.Version != "dev" || got.Commit != "abc123" || got.BuildDate != "2026-10-01T12:00:00Z" || !got.Modified || got.GoVersion == "" {
+		t.Errorf("from toolchain = %+v", got)
+	}
+	if got := resolve(nil); got.Version != "dev" || got.Commit != "" {
+		t.Errorf("without build info = %+v", got)
+	}
+	if got := resolve(&debug.BuildInfo{Main: debug.Module{Version: "v1.2.0"}}); got.Version != "v1.2.0" {
+		t.Errorf("module version = %q", got.Version)
+	}
+
+	// Link-time values win, and the toolchain's VCS settings are left out
+	// since they may describe another commit
+	Version, Commit, Date = "v1.4.0", "def456", "2026-10-02T08:00:00Z"
+	t.Cleanup(func() { Version, Commit, Date = "", "", "" })
+	got = resolve(vcs)
+	if got.Version != "v1.4.0" || got.Commit != "def456" || got.BuildDate != "2026-10-02T08:00:00Z" || got.Modified {
+		t.Errorf("from ldflags = %+v", got)
+	}
+}
@@ -0,0 +1,88 @@
+// Package capacity resolves the declared link speed of clients and measures
+// their results' utilization against it.
+package capacity
+
+import (
+	"math"
+	"net"
+	"sort"
+
+	"github.com/Tom-Oram/fak/backend/internal/accounting"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Validate checks a declared capacity and normalises its match, which is an
+// IP address or CIDR range as for cost center assignments.
+func Validate(c *models.LinkCapacity) error {
+	match, err := accounting.CanonicalMatch(c.Match)
+	if err != nil {
+		return err
+	}
+	if c.Capacity <= 0 || math.IsInf(c.Capacity, 0) || math.IsNaN(c.Capacity) {
+		return i18n.NewError("capacity.capacity_positive", nil)
+	}
+	c.Match = match
+	return nil
+}
+
+type rule struct {
+	network   *net.IPNet
+	prefixLen int
+	capacity  float64
+}
+
+// Resolver maps client IPs to their declared capacity. When several
+// declarations match, the most specific one wins.
+type Resolver struct {
+	rules []rule
+}
+
+// NewResolver builds a Resolver from stored capacities. Capacities whose
+// match does not parse are ignored.
+func NewResolver(capacities []models.LinkCapacity) *Resolver {
+	r := &Resolver{}
+	for _, c := range capacities {
+		network, err := accounting.ParseMatch(c.Match)
+		if err != nil {
+			continue
+		}
+		ones, _ := network.Mask.Size()
+		r.rules = append(r.rules, rule{network: network, prefixLen: ones, capacity: c.Capacity})
+	}
+	sort.SliceStable(r.rules, func(i, j int) bool {
+		return r.rules[i].prefixLen > r.rules[j].prefixLen
+	})
+	return r
+}
+
+// Capacity returns the declared capacity of clientIP in bits per second,
+// or false if none matches.
+func (r *Resolver) Capacity(clientIP string) (float64, bool) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return 0, false
+	}
+	for _, rl := range r.rules {
+		if rl.network.Contains(ip) {
+			return rl.capacity, true
+		}
+	}
+	return 0, false
+}
+
+// Apply sets the result's link capacity and utilization, clearing them if
+// its client has no declared capacity.
+func (r *Resolver) Apply(result *models.TestResult) {
+	result.LinkCapacity, result.Utilization = nil, nil
+	if capacity, ok := r.Capacity(result.ClientIP); ok {
+		Measure(result, capacity)
+	}
+}
+
+// Measure sets the result's link capacity to bps and its utilization to its
+// average bandwidth as a percentage of it.
+func Measure(result *models.TestResult, bps float64) {
+	utilization := result.AvgBandwidth / bps * 100
+	result.LinkCapacity, result.Utilization = &bps, &utilization
+}
@@ -0,0 +1,49 @@
+package capacity
+
+import (
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestValidate(t *testing.T) {
+	c := models.LinkCapacity{Match: " 10.0.0.0/8 ", Capacity: 1e9}
+	if err := Validate(&c); err != nil || c.Match != "10.0.0.0/8" {
+		t.Errorf("Validate = %v, match %q", err, c.Match)
+	}
+	for _, bad := range []models.LinkCapacity{
+		{Match: "lab", Capacity: 1e9},
+		{Match: "10.0.0.5", Capacity: 0},
+		{Match: "10.0.0.5", Capacity: -1},
+	} {
+		if err := Validate(&bad); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", bad)
+		}
+	}
+}
+
+func TestResolverApply(t *testing.T) {
+	r := NewResolver([]models.LinkCapacity{
+		{Match: "10.0.0.0/8", Capacity: 100e6},
+		{Match: "10.0.0.5", Capacity: 1e9},
+	})
+
+	// The most specific declaration wins
+	result := &models.TestResult{ClientIP: "10.0.0.5", AvgBandwidth: 800e6}
+	r.Apply(result)
+	if result.LinkCapacity == nil || *result.LinkCapacity != 1e9 || result.Utilization == nil || *result.Utilization != 80 {
+		t.Errorf("10.0.0.5: capacity %v, utilization %v", result.LinkCapacity, result.Utilization)
+	}
+
+	result = &models.TestResult{ClientIP: "10.1.2.3", AvgBandwidth: 50e6}
+	r.Apply(result)
+	if result.Utilization == nil || *result.Utilization != 50 {
+		t.Errorf("10.1.2.3: utilization %v, want 50", result.Utilization)
+	}
+
+	result.ClientIP = "192.0.2.1"
+	r.Apply(result)
+	if result.LinkCapacity != nil || result.Utilization != nil {
+		t.Errorf("undeclared client: capacity %v, utilization %v, want nil", result.LinkCapacity, result.Utilization)
+	}
+}
@@ -0,0 +1,166 @@
+// Package collisions reports how often clients were turned away because a
+// test was already running, to show when more instances or ports are needed.
+package collisions
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Bucket is the granularity of a report's series.
+type Bucket string
+
+const (
+	BucketHour Bucket = "hour"
+	BucketDay  Bucket = "day"
+)
+
+// ParseBucket returns the named bucket; empty selects BucketDay.
+func ParseBucket(name string) (Bucket, error) {
+	switch Bucket(name) {
+	case "", BucketDay:
+		return BucketDay, nil
+	case BucketHour:
+		return BucketHour, nil
+	}
+	return "", fmt.Errorf("unknown bucket %q", name)
+}
+
+// duration returns the length of one bucket.
+func (b Bucket) duration() time.Duration {
+	if b == BucketHour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Count is the number of tests run and connections turned away. Rate is the
+// share of connection attempts that collided, nil when there were none.
+type Count struct {
+	Tests      int      `json:"tests"`
+	Collisions int      `json:"collisions"`
+	Rate       *float64 `json:"rate"`
+}
+
+func (c *Count) setRate() {
+	attempts := c.Tests + c.Collisions
+	if attempts == 0 {
+		c.Rate = nil
+		return
+	}
+	v := float64(c.Collisions) / float64(attempts)
+	c.Rate = &v
+}
+
+// Point is the count for one bucket of the period, starting at Start (UTC).
+type Point struct {
+	Start time.Time `json:"start"`
+	Count
+}
+
+// PortCount is the count for one listener of a port pool.
+type PortCount struct {
+	Port int `json:"port"`
+	Count
+}
+
+// Report summarises collisions over a period.
+type Report struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Bucket Bucket    `json:"bucket"`
+	Count
+	Series []Point            `json:"series"`
+	Ports  []PortCount        `json:"ports"`
+	Recent []models.Collision `json:"recent"`
+}
+
+// recentLimit is the number of latest collisions listed in a report.
+const recentLimit = 20
+
+// Summarise counts tests and collisions in [from, to), overall, per bucket
+// and per port. Every bucket of the period is reported, including empty
+// ones; results recorded before port pools have no port and are only
+// counted overall.
+func Summarise(collisions []models.Collision, results []models.TestResult, from, to time.Time, bucket Bucket) Report {
+	var inPeriod []models.TestResult
+	for _, r := range results {
+		if ts := r.Timestamp.UTC(); !ts.Before(from.UTC()) && ts.Before(to.UTC()) {
+			inPeriod = append(inPeriod, r)
+		}
+	}
+	return SummariseStats(collisions, models.HourlyStats(inPeriod), from, to, bucket)
+}
+
+// SummariseStats is Summarise for results already rolled up by hour. The
+// statistics must cover only [from, to); an hour that starts before from is
+// counted in the first bucket.
+func SummariseStats(collisions []models.Collision, stats []models.ResultStat, from, to time.Time, bucket Bucket) Report {
+	from, to = from.UTC(), to.UTC()
+	step := bucket.duration()
+	first := from.Truncate(step)
+
+	report := Report{From: from, To: to, Bucket: bucket, Series: []Point{}, Ports: []PortCount{}, Recent: []models.Collision{}}
+	for start := first; start.Before(to); start = start.Add(step) {
+		report.Series = append(report.Series, Point{Start: start})
+	}
+	ports := make(map[int]*Count)
+	port := func(p int) *Count {
+		if ports[p] == nil {
+			ports[p] = &Count{}
+		}
+		return ports[p]
+	}
+	inPeriod := func(ts time.Time) (int, bool) {
+		ts = ts.UTC()
+		if ts.Before(from) || !ts.Before(to) {
+			return 0, false
+		}
+		return int(ts.Sub(first) / step), true
+	}
+
+	for _, st := range stats {
+		hour := st.Hour.UTC()
+		if hour.Before(first) || !hour.Before(to) {
+			continue
+		}
+		idx := int(hour.Sub(first) / step)
+		report.Tests += st.Tests
+		report.Series[idx].Tests += st.Tests
+		if st.ServerPort != 0 {
+			port(st.ServerPort).Tests += st.Tests
+		}
+	}
+	for _, c := range collisions {
+		idx, ok := inPeriod(c.Timestamp)
+		if !ok {
+			continue
+		}
+		report.Collisions++
+		report.Series[idx].Collisions++
+		if c.ServerPort != 0 {
+			port(c.ServerPort).Collisions++
+		}
+		report.Recent = append(report.Recent, c)
+	}
+
+	report.setRate()
+	for i := range report.Series {
+		report.Series[i].setRate()
+	}
+	for p, c := range ports {
+		c.setRate()
+		report.Ports = append(report.Ports, PortCount{Port: p, Count: *c})
+	}
+	sort.Slice(report.Ports, func(i, j int) bool { return report.Ports[i].Port < report.Ports[j].Port })
+
+	// Newest first
+	sort.SliceStable(report.Recent, func(i, j int) bool { return report.Recent[i].Timestamp.After(report.Recent[j].Timestamp) })
+	if len(report.Recent) > recentLimit {
+		report.Recent = report.Recent[:recentLimit]
+	}
+	return report
+}
@@ -0,0 +1,102 @@
+package collisions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestParseBucket(t *testing.T) {
+	for name, want := range map[string]Bucket{"": BucketDay, "day": BucketDay, "hour": BucketHour} {
+		got, err := ParseBucket(name)
+		if err != nil || got != want {
+			t.Errorf("ParseBucket(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseBucket("week"); err == nil {
+		t.Error("ParseBucket(week) succeeded, want error")
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 3)
+	at := func(day, hour int) time.Time { return from.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour) }
+
+	results := []models.TestResult{
+		{Timestamp: at(0, 1), ServerPort: 5201},
+		{Timestamp: at(0, 2), ServerPort: 5202},
+		{Timestamp: at(0, 3), ServerPort: 5201},
+		{Timestamp: at(2, 1)},
+		{Timestamp: at(-1, 1), ServerPort: 5201}, // before the period
+	}
+	collisions := []models.Collision{
+		{Timestamp: at(0, 1), ServerPort: 5201},
+		{Timestamp: at(2, 5), ServerPort: 5201},
+		{Timestamp: at(3, 0), ServerPort: 5201}, // at the end of the period
+	}
+
+	r := Summarise(collisions, results, from, to, BucketDay)
+
+	if r.Tests != 4 || r.Collisions != 2 {
+		t.Errorf("totals = %d tests, %d collisions; want 4, 2", r.Tests, r.Collisions)
+	}
+	if r.Rate == nil || *r.Rate != 2.0/6 {
+		t.Errorf("rate = %v, want 1/3", r.Rate)
+	}
+
+	if len(r.Series) != 3 {
+		t.Fatalf("series has %d points, want 3", len(r.Series))
+	}
+	if p := r.Series[0]; !p.Start.Equal(from) || p.Tests != 3 || p.Collisions != 1 || *p.Rate != 0.25 {
+		t.Errorf("day 0 = %+v, want 3 tests and 1 collision", p)
+	}
+	if p := r.Series[1]; p.Tests != 0 || p.Collisions != 0 || p.Rate != nil {
+		t.Errorf("day 1 = %+v, want empty with no rate", p)
+	}
+
+	if len(r.Ports) != 2 || r.Ports[0].Port != 5201 || r.Ports[0].Tests != 2 || r.Ports[0].Collisions != 2 || r.Ports[1].Port != 5202 {
+		t.Errorf("ports = %+v, want 5201 with 2 tests and 2 collisions, then 5202", r.Ports)
+	}
+
+	if len(r.Recent) != 2 || !r.Recent[0].Timestamp.Equal(at(2, 5)) {
+		t.Errorf("recent = %+v, want the two collisions newest first", r.Recent)
+	}
+}
+
+func TestSummarise_Hourly(t *testing.T) {
+	from := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	collisions := []models.Collision{{Timestamp: from.Add(40 * time.Minute)}}
+
+	r := Summarise(collisions, nil, from, to, BucketHour)
+
+	if len(r.Series) != 3 {
+		t.Fatalf("series has %d points, want 3", len(r.Series))
+	}
+	if !r.Series[0].Start.Equal(from.Truncate(time.Hour)) || r.Series[1].Collisions != 1 {
+		t.Errorf("series = %+v, want the collision in the 11:00 bucket", r.Series)
+	}
+	if r.Rate == nil || *r.Rate != 1 {
+		t.Errorf("rate = %v, want 1", r.Rate)
+	}
+}
+
+func TestSummariseStats_EdgeHour(t *testing.T) {
+	from := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	// Storage counts the partial hour at from under 12:00
+	stats := []models.ResultStat{
+		{Hour: from.Truncate(time.Hour), ServerPort: 5201, Tests: 2},
+		{Hour: from.Add(90 * time.Minute), ServerPort: 5202, Tests: 1},
+	}
+
+	report := SummariseStats(nil, stats, from, to, BucketHour)
+	if report.Tests != 3 || len(report.Series) != 3 || report.Series[0].Tests != 2 || report.Series[2].Tests != 1 {
+		t.Errorf("report = %+v, want 2 tests in the first bucket and 1 in the last", report)
+	}
+	if len(report.Ports) != 2 || report.Ports[0].Tests != 2 || report.Ports[1].Tests != 1 {
+		t.Errorf("ports = %+v", report.Ports)
+	}
+}
@@ -0,0 +1,354 @@
+// Package community shares coarse, anonymised bandwidth aggregates with a
+// community endpoint and fetches the community's aggregates for comparison.
+// Raw results never leave the instance: submissions carry only a test count
+// and bandwidth percentiles per region, ISP, protocol and direction.
+package community
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Config configures community sharing.
+type Config struct {
+	// Endpoint is the community service's base URL
+	Endpoint string
+	// Token, if set, is sent as a bearer token
+	Token string
+	// Region and ISP label this instance's aggregates, e.g. "eu-west" and
+	// "ExampleNet"; they are configured, never derived from client addresses
+	Region string
+	ISP    string
+	// Interval is both how often aggregates are submitted and the period
+	// each submission covers
+	Interval time.Duration
+	// MinTests withholds groups with fewer tests, so a single client's
+	// results cannot be singled out
+	MinTests int
+	// Epsilon, when positive, adds Laplace noise with scale 1/Epsilon to
+	// each test count; smaller values add more noise
+	Epsilon float64
+	// Timeout bounds each request to the endpoint
+	Timeout time.Duration
+}
+
+// Validate checks the endpoint and labels and applies defaults.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid community endpoint %q", c.Endpoint)
+	}
+	if c.Region == "" || c.ISP == "" {
+		return errors.New("region and ISP are required")
+	}
+	if c.Epsilon < 0 {
+		return errors.New("epsilon must not be negative")
+	}
+	if c.Interval <= 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.MinTests <= 0 {
+		c.MinTests = 10
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return nil
+}
+
+// Aggregate summarises the completed tests of one group. Bandwidths are in
+// bits per second, rounded to two significant figures.
+type Aggregate struct {
+	Region    string          `json:"region"`
+	ISP       string          `json:"isp"`
+	Protocol  models.Protocol `json:"protocol"`
+	Direction string          `json:"direction"`
+	// Tests is rounded to a multiple of 5, after noise when enabled
+	Tests int     `json:"tests"`
+	P10   float64 `json:"p10"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+}
+
+// Submission is the document sent to the community endpoint.
+type Submission struct {
+	// From and To bound the period on whole hours
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+	Aggregates []Aggregate `json:"aggregates"`
+}
+
+// Status reports the outcome of the last submission.
+type Status struct {
+	Endpoint       string     `json:"endpoint"`
+	Region         string     `json:"region"`
+	ISP            string     `json:"isp"`
+	LastSubmission *time.Time `json:"lastSubmission,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	Submissions    int        `json:"submissions"`
+}
+
+// Summarise groups completed, unflagged results by protocol and direction.
+// Groups with fewer than minTests results are left out. noise returns the
+// value added to each count; nil adds none.
+func Summarise(results []models.TestResult, region, isp string, minTests int, noise func() float64) []Aggregate {
+	type key struct {
+		protocol  models.Protocol
+		direction string
+	}
+	groups := make(map[key][]float64)
+	for _, r := range results {
+		if r.Status != models.TestStatusCompleted || len(r.QualityFlags) > 0 || r.AvgBandwidth <= 0 {
+			continue
+		}
+		k := key{r.Protocol, r.Direction}
+		groups[k] = append(groups[k], r.AvgBandwidth)
+	}
+
+	aggregates := []Aggregate{}
+	for k, bw := range groups {
+		if len(bw) < minTests {
+			continue
+		}
+		sort.Float64s(bw)
+		count := float64(len(bw))
+		if noise != nil {
+			count += noise()
+		}
+		aggregates = append(aggregates, Aggregate{
+			Region:    region,
+			ISP:       isp,
+			Protocol:  k.protocol,
+			Direction: k.direction,
+			Tests:     roundTo(count, 5),
+			P10:       coarse(percentile(bw, 10)),
+			P50:       coarse(percentile(bw, 50)),
+			P90:       coarse(percentile(bw, 90)),
+		})
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		if aggregates[i].Protocol != aggregates[j].Protocol {
+			return aggregates[i].Protocol < aggregates[j].Protocol
+		}
+		return aggregates[i].Direction < aggregates[j].Direction
+	})
+	return aggregates
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// coarse rounds v to two significant figures.
+func coarse(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	scale := math.Pow(10, math.Floor(math.Log10(v))-1)
+	return math.Round(v/scale) * scale
+}
+
+// roundTo rounds v to the nearest multiple of step, never below zero.
+func roundTo(v float64, step int) int {
+	n := int(math.Round(v/float64(step))) * step
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// laplace returns a sampler of Laplace noise with the given scale.
+func laplace(scale float64) func() float64 {
+	return func() float64 {
+		u := rand.Float64() - 0.5
+		return -scale * math.Copysign(1, u) * math.Log(1-2*math.Abs(u))
+	}
+}
+
+// Reporter periodically submits this instance's aggregates.
+type Reporter struct {
+	cfg     Config
+	http    *http.Client
+	results func(ctx context.Context, from, to time.Time) ([]models.TestResult, error)
+	noise   func() float64
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewReporter creates a Reporter for a validated config. results loads the
+// results of a period.
+func NewReporter(cfg Config, results func(ctx context.Context, from, to time.Time) ([]models.TestResult, error)) *Reporter {
+	r := &Reporter{
+		cfg:     cfg,
+		http:    &http.Client{Timeout: cfg.Timeout},
+		results: results,
+		done:    make(chan struct{}),
+		status: Status{
+			Endpoint: cfg.Endpoint,
+			Region:   cfg.Region,
+			ISP:      cfg.ISP,
+		},
+	}
+	if cfg.Epsilon > 0 {
+		r.noise = laplace(1 / cfg.Epsilon)
+	}
+	return r
+}
+
+// Run submits aggregates every interval until Close is called.
+func (r *Reporter) Run() {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.done:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+		if err := r.Submit(ctx, time.Now()); err != nil {
+			log.Printf("Community submission failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Close stops periodic submissions.
+func (r *Reporter) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// Status returns the outcome of the last submission.
+func (r *Reporter) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status
+	if status.LastSubmission != nil {
+		at := *status.LastSubmission
+		status.LastSubmission = &at
+	}
+	return status
+}
+
+// Preview builds the submission for the interval ending at now as Submit
+// would send it, but without noise: noisy counts that could be requested
+// repeatedly would average out.
+func (r *Reporter) Preview(ctx context.Context, now time.Time) (Submission, error) {
+	return r.build(ctx, now, nil)
+}
+
+func (r *Reporter) build(ctx context.Context, now time.Time, noise func() float64) (Submission, error) {
+	to := now.UTC().Truncate(time.Hour)
+	from := to.Add(-r.cfg.Interval)
+	results, err := r.results(ctx, from, to)
+	if err != nil {
+		return Submission{}, err
+	}
+	return Submission{
+		From:       from,
+		To:         to,
+		Aggregates: Summarise(results, r.cfg.Region, r.cfg.ISP, r.cfg.MinTests, noise),
+	}, nil
+}
+
+// Submit sends the aggregates for the interval ending at now. A period with
+// no group large enough is not sent.
+func (r *Reporter) Submit(ctx context.Context, now time.Time) error {
+	sub, err := r.build(ctx, now, r.noise)
+	if err == nil && len(sub.Aggregates) > 0 {
+		err = r.do(ctx, http.MethodPost, "/submissions", nil, sub, nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.status.LastError = err.Error()
+		return err
+	}
+	r.status.LastError = ""
+	if len(sub.Aggregates) > 0 {
+		at := time.Now()
+		r.status.LastSubmission = &at
+		r.status.Submissions++
+	}
+	return nil
+}
+
+// Fetch returns the community's aggregates for this instance's region and
+// ISP.
+func (r *Reporter) Fetch(ctx context.Context) ([]Aggregate, error) {
+	query := url.Values{"region": {r.cfg.Region}, "isp": {r.cfg.ISP}}
+	var aggregates []Aggregate
+	if err := r.do(ctx, http.MethodGet, "/aggregates", query, nil, &aggregates); err != nil {
+		return nil, err
+	}
+	if aggregates == nil {
+		aggregates = []Aggregate{}
+	}
+	return aggregates, nil
+}
+
+// do sends a request to the endpoint, encoding in and decoding out as JSON
+// when they are set.
+func (r *Reporter) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := strings.TrimRight(r.cfg.Endpoint, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
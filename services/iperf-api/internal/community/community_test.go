@@ -0,0 +1,206 @@
+package community
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// results returns n completed TCP upload results at 1..n Mbps.
+func results(n int) []models.TestResult {
+	var out []models.TestResult
+	for i := 1; i <= n; i++ {
+		out = append(out, models.TestResult{
+			ID:           "r",
+			ClientIP:     "203.0.113.7",
+			Protocol:     models.ProtocolTCP,
+			Direction:    "upload",
+			Status:       models.TestStatusCompleted,
+			AvgBandwidth: float64(i) * 1e6,
+		})
+	}
+	return out
+}
+
+func TestSummarise(t *testing.T) {
+	rs := results(20)
+	// Excluded: failed, flagged, and a group below the minimum
+	rs = append(rs,
+		models.TestResult{Protocol: models.ProtocolTCP, Direction: "upload", Status: models.TestStatusFailed, AvgBandwidth: 1e9},
+		models.TestResult{Protocol: models.ProtocolTCP, Direction: "upload", Status: models.TestStatusCompleted, AvgBandwidth: 1e9,
+			QualityFlags: []models.QualityFlag{models.QualityFlagZeroBytes}},
+		models.TestResult{Protocol: models.ProtocolUDP, Direction: "upload", Status: models.TestStatusCompleted, AvgBandwidth: 5e6},
+	)
+
+	got := Summarise(rs, "eu-west", "ExampleNet", 10, nil)
+	if len(got) != 1 {
+		t.Fatalf("aggregates = %+v, want only the TCP upload group", got)
+	}
+	want := Aggregate{
+		Region: "eu-west", ISP: "ExampleNet", Protocol: models.ProtocolTCP, Direction: "upload",
+		Tests: 20, P10: 2e6, P50: 10e6, P90: 18e6,
+	}
+	if got[0] != want {
+		t.Errorf("aggregate = %+v, want %+v", got[0], want)
+	}
+
+	if got := Summarise(rs, "eu-west", "ExampleNet", 21, nil); len(got) != 0 {
+		t.Errorf("aggregates = %+v, want none below the minimum", got)
+	}
+
+	noisy := Summarise(results(20), "r", "i", 10, func() float64 { return 2.6 })
+	if noisy[0].Tests != 25 {
+		t.Errorf("noisy tests = %d, want 22.6 rounded to 25", noisy[0].Tests)
+	}
+}
+
+func TestCoarse(t *testing.T) {
+	tests := []struct{ in, want float64 }{
+		{943_200_000, 940_000_000},
+		{1_234, 1_200},
+		{99.6, 100},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := coarse(tt.in); got != tt.want {
+			t.Errorf("coarse(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	if got := roundTo(-3, 5); got != 0 {
+		t.Errorf("roundTo(-3, 5) = %d, want 0", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := Config{Endpoint: "https://community.example.com", Region: "eu", ISP: "net"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if cfg.Interval != 24*time.Hour || cfg.MinTests != 10 {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+
+	for _, bad := range []Config{
+		{Endpoint: "ftp://x", Region: "eu", ISP: "net"},
+		{Endpoint: "https://x", ISP: "net"},
+		{Endpoint: "https://x", Region: "eu", ISP: "net", Epsilon: -1},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", bad)
+		}
+	}
+}
+
+// fakeCommunity records submissions and serves canned aggregates.
+func fakeCommunity(t *testing.T, submitted *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/submissions":
+			body, _ := io.ReadAll(r.Body)
+			*submitted = append(*submitted, string(body))
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodGet && r.URL.Path == "/aggregates":
+			json.NewEncoder(w).Encode([]Aggregate{{
+				Region: r.URL.Query().Get("region"), ISP: r.URL.Query().Get("isp"),
+				Protocol: models.ProtocolTCP, Direction: "upload", Tests: 500, P50: 9e6,
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReporter_SubmitAndFetch(t *testing.T) {
+	var submitted []string
+	srv := fakeCommunity(t, &submitted)
+
+	now := time.Date(2026, 3, 2, 10, 45, 0, 0, time.UTC)
+	var gotFrom, gotTo time.Time
+	cfg := Config{Endpoint: srv.URL + "/", Token: "tok", Region: "eu-west", ISP: "ExampleNet", Interval: 24 * time.Hour}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReporter(cfg, func(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+		gotFrom, gotTo = from, to
+		return results(12), nil
+	})
+
+	if err := r.Submit(context.Background(), now); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if !gotTo.Equal(time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)) || !gotFrom.Equal(gotTo.Add(-24*time.Hour)) {
+		t.Errorf("period = %v - %v, want the day up to the hour", gotFrom, gotTo)
+	}
+	if len(submitted) != 1 {
+		t.Fatalf("submissions = %d, want 1", len(submitted))
+	}
+	if strings.Contains(submitted[0], "203.0.113.7") || strings.Contains(submitted[0], "clientIp") {
+		t.Errorf("submission leaks client data: %s", submitted[0])
+	}
+	var sub Submission
+	if err := json.Unmarshal([]byte(submitted[0]), &sub); err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.Aggregates) != 1 || sub.Aggregates[0].Tests != 10 {
+		t.Errorf("submission = %+v, want one group of 12 tests rounded to 10", sub)
+	}
+	if st := r.Status(); st.Submissions != 1 || st.LastSubmission == nil || st.LastError != "" {
+		t.Errorf("status = %+v", st)
+	}
+
+	shared, err := r.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(shared) != 1 || shared[0].Region != "eu-west" || shared[0].ISP != "ExampleNet" {
+		t.Errorf("community = %+v", shared)
+	}
+}
+
+func TestReporter_NothingToShare(t *testing.T) {
+	var submitted []string
+	srv := fakeCommunity(t, &submitted)
+	cfg := Config{Endpoint: srv.URL, Token: "tok", Region: "eu", ISP: "net"}
+	cfg.Validate()
+	r := NewReporter(cfg, func(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+		return results(3), nil
+	})
+
+	if err := r.Submit(context.Background(), time.Now()); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if len(submitted) != 0 || r.Status().Submissions != 0 {
+		t.Errorf("sent %d submissions with no group above the minimum", len(submitted))
+	}
+}
+
+func TestReporter_SubmitError(t *testing.T) {
+	var submitted []string
+	srv := fakeCommunity(t, &submitted)
+	cfg := Config{Endpoint: srv.URL, Token: "wrong", Region: "eu", ISP: "net"}
+	cfg.Validate()
+	r := NewReporter(cfg, func(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+		return results(10), nil
+	})
+
+	if err := r.Submit(context.Background(), time.Now()); err == nil {
+		t.Fatal("Submit succeeded with a rejected token")
+	}
+	if st := r.Status(); st.LastError == "" || st.LastSubmission != nil {
+		t.Errorf("status = %+v, want the error", st)
+	}
+}
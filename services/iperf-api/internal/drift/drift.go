@@ -0,0 +1,233 @@
+// Package drift compares the iPerf server with a declared desired state and,
+// when the declaration allows it, restores that state.
+package drift
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/annotations"
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// DefaultInterval is how often the desired state is checked when no
+// interval is given.
+const DefaultInterval = time.Minute
+
+// exitWait bounds how long a restart waits for the old iperf process to
+// release the port.
+const exitWait = 10 * time.Second
+
+// Target is the server being reconciled. *iperf.Manager satisfies it.
+type Target interface {
+	Start(cfg models.ServerConfig) error
+	Stop() error
+	GetStatus() models.ServerStatus
+	GetConfig() models.ServerConfig
+	WaitExited(timeout time.Duration) bool
+}
+
+// Validate checks that a desired state can be held. A running server must
+// not stop by itself, so idle timeouts and one-off mode without auto-rearm
+// are rejected.
+func Validate(d models.DesiredState) error {
+	if errs := iperf.ValidateConfig(d.Config); len(errs) > 0 {
+		return iperf.ConfigErrors(errs)
+	}
+	ephemeral := d.Config.OneOff && !d.Config.AutoRearm
+	if d.Running && (ephemeral || d.Config.IdleTimeout > 0) {
+		return i18n.NewError("drift.ephemeral_config", nil)
+	}
+	return nil
+}
+
+// Compare returns how the actual server differs from the desired state. The
+// configuration is only compared while both are running, using the fields
+// that affect results.
+func Compare(desired models.DesiredState, status models.ServerStatus, actual models.ServerConfig) []models.DriftField {
+	running := status == models.ServerStatusRunning
+	if running != desired.Running {
+		return []models.DriftField{{
+			Field:   "running",
+			Desired: strconv.FormatBool(desired.Running),
+			Actual:  strconv.FormatBool(running),
+		}}
+	}
+	if !running {
+		return nil
+	}
+
+	var fields []models.DriftField
+	for _, c := range annotations.Diff(actual, desired.Config) {
+		fields = append(fields, models.DriftField{Field: c.Field, Desired: c.To, Actual: c.From})
+	}
+	return fields
+}
+
+// Options configures a Reconciler.
+type Options struct {
+	// Interval between periodic checks
+	Interval time.Duration
+	// Busy reports whether a test or queued job is using the server;
+	// correction is deferred until it returns false
+	Busy func() bool
+}
+
+// Reconciler periodically compares the server with the latest desired state.
+type Reconciler struct {
+	target  Target
+	desired func() (*models.DesiredState, error)
+	notify  func(models.DriftReport)
+	opts    Options
+
+	// mu serialises checks so corrections never overlap
+	mu   sync.Mutex
+	last *models.DriftReport
+
+	kick      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReconciler creates a Reconciler. desired returns the latest desired
+// state, or nil when none is declared. notify receives each report that
+// differs from the previous one. Call Run to start periodic checks.
+func NewReconciler(target Target, desired func() (*models.DesiredState, error), notify func(models.DriftReport), opts Options) *Reconciler {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Busy == nil {
+		opts.Busy = func() bool { return false }
+	}
+	return &Reconciler{
+		target:  target,
+		desired: desired,
+		notify:  notify,
+		opts:    opts,
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run checks the desired state every interval, and whenever Trigger is
+// called, until Close. It should be run in a goroutine.
+func (r *Reconciler) Run() {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.kick:
+		case <-r.done:
+			return
+		}
+		r.Reconcile()
+	}
+}
+
+// Close stops periodic checks.
+func (r *Reconciler) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// Trigger asks Run to check now, e.g. after a new declaration.
+func (r *Reconciler) Trigger() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Inspect compares the server with the desired state without correcting
+// it. It returns nil when no desired state is declared.
+func (r *Reconciler) Inspect() (*models.DriftReport, error) {
+	desired, err := r.desired()
+	if err != nil || desired == nil {
+		return nil, err
+	}
+	return r.compare(desired), nil
+}
+
+// Reconcile compares the server with the desired state and corrects drift
+// if the declaration allows it. It returns nil when no desired state is
+// declared.
+func (r *Reconciler) Reconcile() (*models.DriftReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	desired, err := r.desired()
+	if err != nil || desired == nil {
+		return nil, err
+	}
+
+	report := r.compare(desired)
+	if !report.InSync && desired.AutoCorrect {
+		if r.opts.Busy() {
+			report.Deferred = true
+		} else {
+			r.correct(desired, report)
+		}
+	}
+
+	if r.last == nil || !sameOutcome(*r.last, *report) {
+		r.notify(*report)
+	}
+	r.last = report
+	return report, nil
+}
+
+// Last returns the report of the most recent Reconcile, or nil.
+func (r *Reconciler) Last() *models.DriftReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last == nil {
+		return nil
+	}
+	report := *r.last
+	return &report
+}
+
+func (r *Reconciler) compare(desired *models.DesiredState) *models.DriftReport {
+	drift := Compare(*desired, r.target.GetStatus(), r.target.GetConfig())
+	return &models.DriftReport{
+		CheckedAt:      time.Now(),
+		DesiredVersion: desired.Version,
+		InSync:         len(drift) == 0,
+		Drift:          drift,
+	}
+}
+
+// correct starts, stops or restarts the server to match the desired state.
+func (r *Reconciler) correct(desired *models.DesiredState, report *models.DriftReport) {
+	running := r.target.GetStatus() == models.ServerStatusRunning
+
+	var err error
+	switch {
+	case !desired.Running:
+		report.Action = models.DriftActionStop
+		err = r.target.Stop()
+	case !running:
+		report.Action = models.DriftActionStart
+		r.target.WaitExited(exitWait)
+		err = r.target.Start(desired.Config)
+	default:
+		report.Action = models.DriftActionRestart
+		if err = r.target.Stop(); err == nil {
+			r.target.WaitExited(exitWait)
+			err = r.target.Start(desired.Config)
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+// sameOutcome reports whether two reports differ only in when they ran.
+func sameOutcome(a, b models.DriftReport) bool {
+	a.CheckedAt, b.CheckedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,190 @@
+package drift
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// fakeTarget records the starts and stops the reconciler makes.
+type fakeTarget struct {
+	status models.ServerStatus
+	config models.ServerConfig
+	starts []models.ServerConfig
+	stops  int
+}
+
+func (f *fakeTarget) Start(cfg models.ServerConfig) error {
+	if f.status == models.ServerStatusRunning {
+		return errors.New("server is already running")
+	}
+	f.status = models.ServerStatusRunning
+	f.config = cfg
+	f.starts = append(f.starts, cfg)
+	return nil
+}
+
+func (f *fakeTarget) Stop() error {
+	if f.status != models.ServerStatusRunning {
+		return errors.New("server is not running")
+	}
+	f.status = models.ServerStatusStopped
+	f.stops++
+	return nil
+}
+
+func (f *fakeTarget) GetStatus() models.ServerStatus        { return f.status }
+func (f *fakeTarget) GetConfig() models.ServerConfig        { return f.config }
+func (f *fakeTarget) WaitExited(timeout time.Duration) bool { return true }
+
+func desiredConfig() models.ServerConfig {
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.Port = 5301
+	return cfg
+}
+
+func TestCompare(t *testing.T) {
+	desired := models.DesiredState{Running: true, Config: desiredConfig()}
+
+	if got := Compare(desired, models.ServerStatusRunning, desired.Config); len(got) != 0 {
+		t.Errorf("matching server drift = %+v, want none", got)
+	}
+
+	got := Compare(desired, models.ServerStatusError, models.DefaultServerConfig())
+	want := models.DriftField{Field: "running", Desired: "true", Actual: "false"}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("stopped server drift = %+v, want %+v", got, want)
+	}
+
+	actual := desired.Config
+	actual.Port = 5201
+	actual.Allowlist = []string{"10.0.0.0/8"}
+	actual.IdleTimeout = 60
+	got = Compare(desired, models.ServerStatusRunning, actual)
+	if len(got) != 2 || got[0] != (models.DriftField{Field: "port", Desired: "5301", Actual: "5201"}) || got[1].Field != "allowlist" {
+		t.Errorf("config drift = %+v, want port and allowlist", got)
+	}
+
+	stopped := models.DesiredState{Running: false, Config: desiredConfig()}
+	if got := Compare(stopped, models.ServerStatusStopped, actual); len(got) != 0 {
+		t.Errorf("stopped as desired drift = %+v, want none", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ok := models.DesiredState{Running: true, Config: desiredConfig()}
+	if err := Validate(ok); err != nil {
+		t.Errorf("Validate(%+v) = %v", ok, err)
+	}
+
+	oneOff := ok
+	oneOff.Config.OneOff = true
+	idle := ok
+	idle.Config.IdleTimeout = 60
+	badPort := ok
+	badPort.Config.Port = 0
+	for _, d := range []models.DesiredState{oneOff, idle, badPort} {
+		if err := Validate(d); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", d.Config)
+		}
+	}
+
+	// An auto-rearming one-off server keeps running
+	rearm := oneOff
+	rearm.Config.AutoRearm = true
+	if err := Validate(rearm); err != nil {
+		t.Errorf("Validate(one-off with auto-rearm) = %v", err)
+	}
+
+	// A stopped server may declare any valid config
+	idle.Running = false
+	if err := Validate(idle); err != nil {
+		t.Errorf("Validate(stopped with idle timeout) = %v", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	var desired *models.DesiredState
+	var reports []models.DriftReport
+	busy := false
+	target := &fakeTarget{status: models.ServerStatusStopped, config: models.DefaultServerConfig()}
+	r := NewReconciler(target,
+		func() (*models.DesiredState, error) { return desired, nil },
+		func(report models.DriftReport) { reports = append(reports, report) },
+		Options{Busy: func() bool { return busy }},
+	)
+
+	if report, err := r.Reconcile(); report != nil || err != nil {
+		t.Fatalf("Reconcile with nothing declared = %+v, %v; want nil", report, err)
+	}
+
+	// Drift is only reported without auto-correct
+	desired = &models.DesiredState{Version: 1, Running: true, Config: desiredConfig()}
+	report, _ := r.Reconcile()
+	if report.InSync || report.Action != "" || len(target.starts) != 0 {
+		t.Errorf("report = %+v, starts = %d; want drift without action", report, len(target.starts))
+	}
+	r.Reconcile()
+	if len(reports) != 1 {
+		t.Errorf("notified %d times, want unchanged reports notified once", len(reports))
+	}
+
+	// Correction waits while the server is busy
+	desired = &models.DesiredState{Version: 2, Running: true, Config: desiredConfig(), AutoCorrect: true}
+	busy = true
+	if report, _ := r.Reconcile(); !report.Deferred || len(target.starts) != 0 {
+		t.Errorf("busy report = %+v, want deferred", report)
+	}
+	busy = false
+
+	report, _ = r.Reconcile()
+	if report.Action != models.DriftActionStart || len(target.starts) != 1 || target.config.Port != 5301 {
+		t.Errorf("report = %+v, config = %+v; want started on port 5301", report, target.config)
+	}
+	if report, _ := r.Reconcile(); !report.InSync {
+		t.Errorf("after start report = %+v, want in sync", report)
+	}
+
+	// A running server with a different config is restarted
+	target.config.Port = 5201
+	report, _ = r.Reconcile()
+	if report.Action != models.DriftActionRestart || target.stops != 1 || target.config.Port != 5301 {
+		t.Errorf("report = %+v, stops = %d; want restart", report, target.stops)
+	}
+
+	desired = &models.DesiredState{Version: 3, Running: false, Config: desiredConfig(), AutoCorrect: true}
+	report, _ = r.Reconcile()
+	if report.Action != models.DriftActionStop || target.status != models.ServerStatusStopped {
+		t.Errorf("report = %+v, status = %s; want stopped", report, target.status)
+	}
+
+	if last := r.Last(); last == nil || last.DesiredVersion != 3 {
+		t.Errorf("Last() = %+v, want version 3", last)
+	}
+}
+
+func TestReconcile_ReportsFailedCorrection(t *testing.T) {
+	desired := &models.DesiredState{Version: 1, Running: true, Config: desiredConfig(), AutoCorrect: true}
+	target := &failingTarget{fakeTarget{status: models.ServerStatusStopped}}
+	r := NewReconciler(target,
+		func() (*models.DesiredState, error) { return desired, nil },
+		func(models.DriftReport) {},
+		Options{},
+	)
+
+	report, _ := r.Reconcile()
+	if report.Action != models.DriftActionStart || report.Error == "" {
+		t.Errorf("report = %+v, want failed start", report)
+	}
+}
+
+type failingTarget struct {
+	fakeTarget
+}
+
+func (f *failingTarget) Start(models.ServerConfig) error {
+	return errors.New("address already in use")
+}
@@ -0,0 +1,198 @@
+// Package energy samples host power draw while tests run, so results can
+// record the energy spent per gigabyte transferred.
+package energy
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Source reads the host's power draw in watts. Sources backed by an energy
+// counter report the average draw since the previous call; the first call
+// only primes the counter.
+type Source interface {
+	Name() string
+	Power(ctx context.Context) (float64, error)
+}
+
+// DefaultInterval is the sampling interval used when none is given.
+const DefaultInterval = time.Second
+
+// Measurement is the energy used over one session.
+type Measurement struct {
+	Joules   float64
+	Duration time.Duration
+	Samples  int
+}
+
+// JoulesPerGB returns the energy per 10^9 bytes transferred, or nil when no
+// bytes were transferred.
+func (m Measurement) JoulesPerGB(bytes int64) *float64 {
+	if bytes <= 0 {
+		return nil
+	}
+	v := m.Joules / (float64(bytes) / 1e9)
+	return &v
+}
+
+// Meter samples a Source at a fixed interval for one session at a time.
+type Meter struct {
+	source   Source
+	interval time.Duration
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	session *session
+}
+
+// session integrates power samples between Begin and End.
+type session struct {
+	stop    chan struct{}
+	started time.Time
+
+	mu        sync.Mutex
+	joules    float64
+	lastPower float64
+	lastAt    time.Time
+	samples   int
+}
+
+// NewMeter creates a Meter that samples source every interval. Each reading
+// times out after one interval.
+func NewMeter(source Source, interval time.Duration) *Meter {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Meter{
+		source:   source,
+		interval: interval,
+		timeout:  interval,
+	}
+}
+
+// Source returns the meter's power source.
+func (m *Meter) Source() Source {
+	return m.source
+}
+
+// Active reports whether a session is in progress.
+func (m *Meter) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.session != nil
+}
+
+// Begin starts a session and reports whether it did; it does nothing if one
+// is already running.
+func (m *Meter) Begin() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.session != nil {
+		return false
+	}
+
+	now := time.Now()
+	sess := &session{
+		stop:    make(chan struct{}),
+		started: now,
+		lastAt:  now,
+	}
+	m.session = sess
+	go m.sample(sess)
+	return true
+}
+
+// End finishes the session and returns its measurement. It does no I/O: the
+// last sampled power is extended up to now. ok is false when no session was
+// running or no sample was taken.
+func (m *Meter) End() (Measurement, bool) {
+	m.mu.Lock()
+	sess := m.session
+	m.session = nil
+	m.mu.Unlock()
+	if sess == nil {
+		return Measurement{}, false
+	}
+	close(sess.stop)
+
+	now := time.Now()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.samples == 0 {
+		return Measurement{}, false
+	}
+	joules := sess.joules + sess.lastPower*now.Sub(sess.lastAt).Seconds()
+	return Measurement{
+		Joules:   joules,
+		Duration: now.Sub(sess.started),
+		Samples:  sess.samples,
+	}, true
+}
+
+// Abort discards the running session, if any.
+func (m *Meter) Abort() {
+	m.mu.Lock()
+	sess := m.session
+	m.session = nil
+	m.mu.Unlock()
+	if sess != nil {
+		close(sess.stop)
+	}
+}
+
+// sample polls the source until the session stops. Each reading covers the
+// time since the previous one.
+func (m *Meter) sample(sess *session) {
+	// Prime counter-based sources so the first reading covers one interval
+	m.read(sess.stop)
+	sess.mu.Lock()
+	sess.lastAt = time.Now()
+	sess.mu.Unlock()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	logged := false
+	for {
+		select {
+		case <-sess.stop:
+			return
+		case <-ticker.C:
+		}
+
+		watts, err := m.read(sess.stop)
+		if err != nil {
+			// Log once per session; a missing meter would otherwise log every tick
+			if !logged {
+				log.Printf("Energy sample from %s failed: %v", m.source.Name(), err)
+				logged = true
+			}
+			continue
+		}
+		now := time.Now()
+
+		sess.mu.Lock()
+		sess.joules += watts * now.Sub(sess.lastAt).Seconds()
+		sess.lastPower = watts
+		sess.lastAt = now
+		sess.samples++
+		sess.mu.Unlock()
+	}
+}
+
+// read takes one reading, abandoning it if the session stops first.
+func (m *Meter) read(stop <-chan struct{}) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return m.source.Power(ctx)
+}
@@ -0,0 +1,179 @@
+package energy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type constantSource struct {
+	watts float64
+	err   error
+}
+
+func (c constantSource) Name() string { return "constant" }
+
+func (c constantSource) Power(context.Context) (float64, error) {
+	return c.watts, c.err
+}
+
+func TestMeter_IntegratesPower(t *testing.T) {
+	m := NewMeter(constantSource{watts: 100}, 20*time.Millisecond)
+
+	if !m.Begin() || !m.Active() {
+		t.Fatal("session not active after Begin")
+	}
+	if m.Begin() {
+		t.Error("Begin started a second session")
+	}
+	time.Sleep(200 * time.Millisecond)
+	got, ok := m.End()
+	if !ok {
+		t.Fatal("End reported no measurement")
+	}
+
+	// 100 W over the session duration, give or take the priming read
+	want := 100 * got.Duration.Seconds()
+	if math.Abs(got.Joules-want) > 3 {
+		t.Errorf("Joules = %.2f, want about %.2f", got.Joules, want)
+	}
+	if got.Samples == 0 {
+		t.Error("no samples recorded")
+	}
+	if m.Active() {
+		t.Error("session still active after End")
+	}
+}
+
+func TestMeter_NoSamples(t *testing.T) {
+	m := NewMeter(constantSource{err: errors.New("unreachable")}, 10*time.Millisecond)
+
+	if _, ok := m.End(); ok {
+		t.Error("End without Begin reported a measurement")
+	}
+
+	m.Begin()
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := m.End(); ok {
+		t.Error("End with only failed samples reported a measurement")
+	}
+}
+
+func TestMeasurement_JoulesPerGB(t *testing.T) {
+	m := Measurement{Joules: 50}
+	if got := m.JoulesPerGB(2e9); got == nil || *got != 25 {
+		t.Errorf("JoulesPerGB(2e9) = %v, want 25", got)
+	}
+	if got := m.JoulesPerGB(0); got != nil {
+		t.Errorf("JoulesPerGB(0) = %v, want nil", *got)
+	}
+}
+
+func TestRAPL_PowerFromCounter(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, value string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("energy_uj", "1000000")
+	write("max_energy_range_uj", "2000000")
+
+	r, err := NewRAPL(dir)
+	if err != nil {
+		t.Fatalf("NewRAPL: %v", err)
+	}
+	if w, err := r.Power(context.Background()); err != nil || w != 0 {
+		t.Fatalf("priming Power = %v, %v; want 0, nil", w, err)
+	}
+
+	// Rewind the baseline so the reading covers a known interval
+	r.lastAt = time.Now().Add(-time.Second)
+	write("energy_uj", "1500000")
+	w, err := r.Power(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(w-0.5) > 0.05 {
+		t.Errorf("Power = %.3f W, want about 0.5", w)
+	}
+
+	// Counter wraps past max_energy_range_uj
+	r.lastAt = time.Now().Add(-time.Second)
+	write("energy_uj", "100000")
+	w, err = r.Power(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(w-0.6) > 0.05 {
+		t.Errorf("Power after wrap = %.3f W, want about 0.6", w)
+	}
+}
+
+func TestNewRAPL_Unreadable(t *testing.T) {
+	if _, err := NewRAPL(t.TempDir()); err == nil {
+		t.Error("NewRAPL succeeded without an energy counter")
+	}
+}
+
+func TestShelly_Gen2AndGen1(t *testing.T) {
+	gen2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rpc/Switch.GetStatus" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"id": 0, "output": true, "apower": 42.5}`))
+	}))
+	defer gen2.Close()
+
+	w, err := NewShelly(gen2.URL, gen2.Client()).Power(context.Background())
+	if err != nil || w != 42.5 {
+		t.Errorf("gen2 Power = %v, %v; want 42.5", w, err)
+	}
+
+	gen1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"meters": [{"power": 17.25, "is_valid": true}]}`))
+	}))
+	defer gen1.Close()
+
+	w, err = NewShelly(gen1.URL+"/", gen1.Client()).Power(context.Background())
+	if err != nil || w != 17.25 {
+		t.Errorf("gen1 Power = %v, %v; want 17.25", w, err)
+	}
+}
+
+func TestTasmota_Power(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cmnd") != "Status 10" {
+			http.Error(w, "bad command", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"StatusSNS": {"Time": "2024-01-01T00:00:00", "ENERGY": {"Power": 63, "Voltage": 230}}}`))
+	}))
+	defer srv.Close()
+
+	w, err := NewTasmota(srv.URL, srv.Client()).Power(context.Background())
+	if err != nil || w != 63 {
+		t.Errorf("Power = %v, %v; want 63", w, err)
+	}
+}
+
+func TestNewSource_Validation(t *testing.T) {
+	if _, err := NewSource("shelly", "", time.Second); err == nil {
+		t.Error("shelly without a URL accepted")
+	}
+	if _, err := NewSource("ipmi", "", time.Second); err == nil {
+		t.Error("unknown source accepted")
+	}
+}
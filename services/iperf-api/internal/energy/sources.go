@@ -0,0 +1,213 @@
+package energy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRAPLPath is the package-level Intel RAPL powercap zone.
+const DefaultRAPLPath = "/sys/class/powercap/intel-rapl:0"
+
+// RAPL reads the cumulative energy counter of a Linux powercap zone.
+type RAPL struct {
+	path string
+
+	mu       sync.Mutex
+	lastUJ   uint64
+	lastAt   time.Time
+	maxUJ    uint64
+	hasFirst bool
+}
+
+// NewRAPL creates a RAPL source for the powercap zone at path, checking that
+// its energy counter is readable (it is usually root-only).
+func NewRAPL(path string) (*RAPL, error) {
+	if path == "" {
+		path = DefaultRAPLPath
+	}
+	r := &RAPL{path: path}
+	if _, err := r.readUJ("energy_uj"); err != nil {
+		return nil, err
+	}
+	// The counter wraps at max_energy_range_uj; without it a wrap is dropped
+	if maxUJ, err := r.readUJ("max_energy_range_uj"); err == nil {
+		r.maxUJ = maxUJ
+	}
+	return r, nil
+}
+
+// Name implements Source.
+func (r *RAPL) Name() string {
+	return "rapl"
+}
+
+// Power implements Source, returning the average draw since the last call.
+func (r *RAPL) Power(ctx context.Context) (float64, error) {
+	uj, err := r.readUJ("energy_uj")
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prevUJ, prevAt, hadFirst := r.lastUJ, r.lastAt, r.hasFirst
+	r.lastUJ, r.lastAt, r.hasFirst = uj, now, true
+	if !hadFirst || !now.After(prevAt) {
+		return 0, nil
+	}
+
+	var delta uint64
+	switch {
+	case uj >= prevUJ:
+		delta = uj - prevUJ
+	case r.maxUJ > 0:
+		delta = r.maxUJ - prevUJ + uj
+	default:
+		return 0, errors.New("rapl: energy counter wrapped")
+	}
+	return float64(delta) / 1e6 / now.Sub(prevAt).Seconds(), nil
+}
+
+func (r *RAPL) readUJ(name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(r.path, name))
+	if err != nil {
+		return 0, fmt.Errorf("rapl: %w", err)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("rapl: parsing %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// Shelly reads the power reported by a Shelly smart plug. Gen2 and later
+// devices are queried over RPC; Gen1 devices through /status.
+type Shelly struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewShelly creates a Shelly source for the device at baseURL.
+func NewShelly(baseURL string, client *http.Client) *Shelly {
+	return &Shelly{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// Name implements Source.
+func (s *Shelly) Name() string {
+	return "shelly"
+}
+
+// Power implements Source.
+func (s *Shelly) Power(ctx context.Context) (float64, error) {
+	var gen2 struct {
+		APower *float64 `json:"apower"`
+	}
+	err := getJSON(ctx, s.client, s.baseURL+"/rpc/Switch.GetStatus?id=0", &gen2)
+	if err == nil && gen2.APower != nil {
+		return *gen2.APower, nil
+	}
+	if err != nil && !errors.Is(err, errNotFound) {
+		return 0, fmt.Errorf("shelly: %w", err)
+	}
+
+	var gen1 struct {
+		Meters []struct {
+			Power float64 `json:"power"`
+		} `json:"meters"`
+	}
+	if err := getJSON(ctx, s.client, s.baseURL+"/status", &gen1); err != nil {
+		return 0, fmt.Errorf("shelly: %w", err)
+	}
+	if len(gen1.Meters) == 0 {
+		return 0, errors.New("shelly: device reports no power meter")
+	}
+	return gen1.Meters[0].Power, nil
+}
+
+// Tasmota reads the power reported by a Tasmota smart plug's energy sensor.
+type Tasmota struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewTasmota creates a Tasmota source for the device at baseURL.
+func NewTasmota(baseURL string, client *http.Client) *Tasmota {
+	return &Tasmota{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// Name implements Source.
+func (t *Tasmota) Name() string {
+	return "tasmota"
+}
+
+// Power implements Source.
+func (t *Tasmota) Power(ctx context.Context) (float64, error) {
+	var status struct {
+		StatusSNS struct {
+			Energy *struct {
+				Power float64 `json:"Power"`
+			} `json:"ENERGY"`
+		} `json:"StatusSNS"`
+	}
+	if err := getJSON(ctx, t.client, t.baseURL+"/cm?cmnd=Status%2010", &status); err != nil {
+		return 0, fmt.Errorf("tasmota: %w", err)
+	}
+	if status.StatusSNS.Energy == nil {
+		return 0, errors.New("tasmota: device reports no energy sensor")
+	}
+	return status.StatusSNS.Energy.Power, nil
+}
+
+// errNotFound is returned by getJSON for a 404 response.
+var errNotFound = errors.New("not found")
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// NewSource creates the Source named kind: "rapl", "shelly" or "tasmota".
+// target is the powercap zone path for RAPL and the device URL otherwise.
+func NewSource(kind, target string, timeout time.Duration) (Source, error) {
+	switch kind {
+	case "rapl":
+		return NewRAPL(target)
+	case "shelly", "tasmota":
+		if target == "" {
+			return nil, fmt.Errorf("%s: device URL is required", kind)
+		}
+		client := &http.Client{Timeout: timeout}
+		if kind == "shelly" {
+			return NewShelly(target, client), nil
+		}
+		return NewTasmota(target, client), nil
+	default:
+		return nil, fmt.Errorf("unknown energy source %q", kind)
+	}
+}
@@ -0,0 +1,245 @@
+// Package federation queries peer iperf-api deployments so one instance can
+// present a combined view of several independent sites.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// PeerOverview summarises one deployment's current state.
+type PeerOverview struct {
+	Origin       string                      `json:"origin"`
+	Reachable    bool                        `json:"reachable"`
+	Error        string                      `json:"error,omitempty"`
+	Status       *models.ServerStatusPayload `json:"status,omitempty"`
+	TotalTests   int                         `json:"totalTests"`
+	LatestResult *models.TestResult          `json:"latestResult,omitempty"`
+}
+
+// Result is a test result tagged with the deployment it came from.
+type Result struct {
+	models.TestResult
+	Origin string `json:"origin"`
+}
+
+// PeerError records a peer that could not be queried.
+type PeerError struct {
+	Origin string `json:"origin"`
+	Error  string `json:"error"`
+}
+
+// historyPage mirrors the /api/history response body.
+type historyPage struct {
+	Results []models.TestResult `json:"results"`
+	Total   int                 `json:"total"`
+}
+
+// Client fans requests out to the peers from the peers file and those
+// registered through the API.
+type Client struct {
+	mu         sync.RWMutex
+	configured []models.Peer
+	registered []models.Peer
+	http       *http.Client
+}
+
+// NewClient creates a Client for the given peers with a per-request timeout.
+func NewClient(peers []models.Peer, timeout time.Duration) *Client {
+	return &Client{
+		configured: peers,
+		http:       &http.Client{Timeout: timeout},
+	}
+}
+
+// Peers returns the configured peers followed by the registered ones.
+func (c *Client) Peers() []models.Peer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append(append([]models.Peer(nil), c.configured...), c.registered...)
+}
+
+// Configured reports whether name is a peer from the peers file, which
+// cannot be changed through the API.
+func (c *Client) Configured(name string) bool {
+	for _, p := range c.configured {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRegistered replaces the peers registered through the API.
+func (c *Client) SetRegistered(peers []models.Peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registered = peers
+}
+
+// LoadPeers reads a JSON array of peers from path.
+func LoadPeers(path string) ([]models.Peer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []models.Peer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("invalid peers file %s: %w", path, err)
+	}
+
+	for i, p := range peers {
+		if p.Name == "" {
+			return nil, fmt.Errorf("peer %d: name is required", i)
+		}
+		if u, err := url.Parse(p.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("peer %q: invalid url %q", p.Name, p.URL)
+		}
+		if p.Location != nil && !p.Location.Valid() {
+			return nil, fmt.Errorf("peer %q: location out of range", p.Name)
+		}
+	}
+
+	return peers, nil
+}
+
+// Overview queries every peer's status and latest result concurrently.
+// Unreachable peers are reported with Reachable=false rather than failing.
+func (c *Client) Overview(ctx context.Context) []PeerOverview {
+	peers := c.Peers()
+	overviews := make([]PeerOverview, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer models.Peer) {
+			defer wg.Done()
+			overviews[i] = c.peerOverview(ctx, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	return overviews
+}
+
+// peerOverview builds the overview for one peer.
+func (c *Client) peerOverview(ctx context.Context, peer models.Peer) PeerOverview {
+	ov := PeerOverview{Origin: peer.Name}
+
+	var status models.ServerStatusPayload
+	if err := c.get(ctx, peer, "/api/status", nil, &status); err != nil {
+		ov.Error = err.Error()
+		return ov
+	}
+
+	var page historyPage
+	if err := c.get(ctx, peer, "/api/history", url.Values{"limit": {"1"}, "local": {"true"}}, &page); err != nil {
+		ov.Error = err.Error()
+		return ov
+	}
+
+	ov.Reachable = true
+	ov.Status = &status
+	ov.TotalTests = page.Total
+	if len(page.Results) > 0 {
+		ov.LatestResult = &page.Results[0]
+	}
+	return ov
+}
+
+// History fetches up to limit recent results of every peer's own tests,
+// optionally filtered by client IP, and returns them tagged by origin. Peers
+// that fail are listed in the returned errors.
+func (c *Client) History(ctx context.Context, limit int, clientIP string) ([]Result, []PeerError) {
+	type peerResults struct {
+		results []Result
+		err     error
+	}
+	peers := c.Peers()
+	collected := make([]peerResults, len(peers))
+
+	query := url.Values{"limit": {strconv.Itoa(limit)}, "local": {"true"}}
+	if clientIP != "" {
+		query.Set("clientIp", clientIP)
+	}
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer models.Peer) {
+			defer wg.Done()
+			var page historyPage
+			if err := c.get(ctx, peer, "/api/history", query, &page); err != nil {
+				collected[i].err = err
+				return
+			}
+			for _, r := range page.Results {
+				collected[i].results = append(collected[i].results, Result{TestResult: r, Origin: peer.Name})
+			}
+		}(i, peer)
+	}
+	wg.Wait()
+
+	var results []Result
+	var errs []PeerError
+	for i, pr := range collected {
+		if pr.err != nil {
+			errs = append(errs, PeerError{Origin: peers[i].Name, Error: pr.err.Error()})
+			continue
+		}
+		results = append(results, pr.results...)
+	}
+
+	return results, errs
+}
+
+// MergeResults sorts results newest first and truncates to limit.
+func MergeResults(results []Result, limit int) []Result {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Timestamp.After(results[j].Timestamp)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// get performs an authenticated GET against a peer and decodes the JSON body.
+func (c *Client) get(ctx context.Context, peer models.Peer, path string, query url.Values, out interface{}) error {
+	u := strings.TrimRight(peer.URL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if peer.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.APIKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,183 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// fakePeer serves /api/status and /api/history with canned data and records
+// the Authorization header it received.
+func fakePeer(t *testing.T, results []models.TestResult, gotAuth *string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		if gotAuth != nil {
+			*gotAuth = r.Header.Get("Authorization")
+		}
+		json.NewEncoder(w).Encode(models.ServerStatusPayload{Status: models.ServerStatusRunning})
+	})
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		filtered := results
+		if ip := r.URL.Query().Get("clientIp"); ip != "" {
+			filtered = nil
+			for _, res := range results {
+				if res.ClientIP == ip {
+					filtered = append(filtered, res)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": filtered, "total": len(results)})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOverview_ReportsReachableAndUnreachablePeers(t *testing.T) {
+	now := time.Now()
+	var auth string
+	up := fakePeer(t, []models.TestResult{{ID: "a1", Timestamp: now}}, &auth)
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	c := NewClient([]models.Peer{
+		{Name: "site-a", URL: up.URL + "/", APIKey: "secret"},
+		{Name: "site-b", URL: down.URL},
+	}, time.Second)
+
+	overviews := c.Overview(context.Background())
+	if len(overviews) != 2 {
+		t.Fatalf("got %d overviews, want 2", len(overviews))
+	}
+
+	a := overviews[0]
+	if !a.Reachable || a.Origin != "site-a" || a.TotalTests != 1 {
+		t.Errorf("site-a overview = %+v", a)
+	}
+	if a.Status == nil || a.Status.Status != models.ServerStatusRunning {
+		t.Errorf("site-a status = %+v, want running", a.Status)
+	}
+	if a.LatestResult == nil || a.LatestResult.ID != "a1" {
+		t.Errorf("site-a latest = %+v, want a1", a.LatestResult)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+	}
+
+	b := overviews[1]
+	if b.Reachable || b.Error == "" {
+		t.Errorf("site-b overview = %+v, want unreachable with error", b)
+	}
+}
+
+func TestHistory_TagsAndMergesResults(t *testing.T) {
+	now := time.Now()
+	a := fakePeer(t, []models.TestResult{
+		{ID: "a-new", Timestamp: now, ClientIP: "10.0.0.1"},
+		{ID: "a-old", Timestamp: now.Add(-2 * time.Hour), ClientIP: "10.0.0.2"},
+	}, nil)
+	b := fakePeer(t, []models.TestResult{
+		{ID: "b-mid", Timestamp: now.Add(-time.Hour), ClientIP: "10.0.0.1"},
+	}, nil)
+
+	c := NewClient([]models.Peer{
+		{Name: "site-a", URL: a.URL},
+		{Name: "site-b", URL: b.URL},
+		{Name: "site-c", URL: "http://127.0.0.1:1"},
+	}, time.Second)
+
+	results, errs := c.History(context.Background(), 10, "")
+	merged := MergeResults(results, 2)
+
+	if len(merged) != 2 {
+		t.Fatalf("got %d merged results, want 2", len(merged))
+	}
+	if merged[0].ID != "a-new" || merged[0].Origin != "site-a" {
+		t.Errorf("merged[0] = %s@%s, want a-new@site-a", merged[0].ID, merged[0].Origin)
+	}
+	if merged[1].ID != "b-mid" || merged[1].Origin != "site-b" {
+		t.Errorf("merged[1] = %s@%s, want b-mid@site-b", merged[1].ID, merged[1].Origin)
+	}
+
+	if len(errs) != 1 || errs[0].Origin != "site-c" {
+		t.Errorf("errors = %+v, want one for site-c", errs)
+	}
+
+	filtered, _ := c.History(context.Background(), 10, "10.0.0.2")
+	if len(filtered) != 1 || filtered[0].ID != "a-old" {
+		t.Errorf("clientIp filter returned %+v", filtered)
+	}
+}
+
+func TestResult_JSONIncludesOrigin(t *testing.T) {
+	data, err := json.Marshal(Result{TestResult: models.TestResult{ID: "x"}, Origin: "site-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if decoded["id"] != "x" || decoded["origin"] != "site-a" {
+		t.Errorf("JSON = %s, want flattened result with origin", data)
+	}
+}
+
+func TestLoadPeers(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "peers.json")
+	os.WriteFile(valid, []byte(`[{"name":"hq","url":"https://hq.example.com:8082","apiKey":"k"}]`), 0644)
+	peers, err := LoadPeers(valid)
+	if err != nil {
+		t.Fatalf("LoadPeers: %v", err)
+	}
+	if len(peers) != 1 || peers[0].Name != "hq" || peers[0].APIKey != "k" {
+		t.Errorf("peers = %+v", peers)
+	}
+
+	invalid := map[string]string{
+		"missing name": `[{"url":"http://a"}]`,
+		"bad url":      `[{"name":"a","url":"not a url"}]`,
+		"bad location": `[{"name":"a","url":"http://a","location":{"latitude":91,"longitude":0}}]`,
+		"not json":     `{`,
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, "bad.json")
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadPeers(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	now := time.Now()
+	summary := Summarise([]models.TestResult{
+		{Timestamp: now.Add(-time.Hour), Status: models.TestStatusCompleted, Direction: "upload", AvgBandwidth: 100},
+		{Timestamp: now, Status: models.TestStatusCompleted, Direction: "download", AvgBandwidth: 300},
+		{Timestamp: now.Add(-2 * time.Hour), Status: models.TestStatusCompleted, Direction: "upload", AvgBandwidth: 5,
+			QualityFlags: []models.QualityFlag{models.QualityFlagShortDuration}},
+		{Timestamp: now.Add(-3 * time.Hour), Status: models.TestStatusFailed},
+	})
+	if summary.Tests != 4 || summary.Completed != 3 || !summary.LatestTest.Equal(now) {
+		t.Errorf("summary = %+v", summary)
+	}
+	if summary.AvgBandwidth == nil || *summary.AvgBandwidth != 200 ||
+		*summary.UploadBandwidth != 100 || *summary.DownloadBandwidth != 300 {
+		t.Errorf("bandwidths = %v %v %v, want 200 100 300", summary.AvgBandwidth, summary.UploadBandwidth, summary.DownloadBandwidth)
+	}
+
+	if empty := Summarise(nil); empty.AvgBandwidth != nil || empty.LatestTest != nil {
+		t.Errorf("empty summary = %+v", empty)
+	}
+}
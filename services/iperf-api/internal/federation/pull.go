@@ -0,0 +1,190 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+const (
+	// pullPageSize is the most results a peer returns per history request
+	pullPageSize = 100
+	// pullOverlap is how far before the newest result already pulled a pull
+	// looks, since results are not always saved in timestamp order
+	pullOverlap = time.Hour
+)
+
+// Store keeps pulled results; storage.Store satisfies it.
+type Store interface {
+	GetTestResult(ctx context.Context, id string) (*models.TestResult, error)
+	SaveTestResult(ctx context.Context, result *models.TestResult) error
+	QueryTestResults(ctx context.Context, filter storage.HistoryFilter, limit, offset int) ([]models.TestResult, error)
+}
+
+// PullStatus is the outcome of pulling one peer's history.
+type PullStatus struct {
+	LastPull  *time.Time `json:"lastPull,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+	// Pulled counts the results stored since startup
+	Pulled int `json:"pulled"`
+}
+
+// Puller copies the history of every peer into the store, with the peer's
+// name as each result's site, so the results can be queried with this
+// instance's own.
+type Puller struct {
+	client   *Client
+	store    Store
+	interval time.Duration
+
+	mu     sync.Mutex
+	status map[string]PullStatus
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	now    func() time.Time
+}
+
+// NewPuller returns a Puller that pulls every interval once Run is called.
+func NewPuller(client *Client, store Store, interval time.Duration) *Puller {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Puller{
+		client:   client,
+		store:    store,
+		interval: interval,
+		status:   make(map[string]PullStatus),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		now:      time.Now,
+	}
+}
+
+// Run pulls from every peer now and then every interval until Close is
+// called.
+func (p *Puller) Run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.PullAll(p.ctx)
+		select {
+		case <-ticker.C:
+		case <-p.wake:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Wake makes Run pull again without waiting for the interval, such as after
+// a peer is registered.
+func (p *Puller) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops Run, abandoning a pull in progress.
+func (p *Puller) Close() {
+	p.cancel()
+}
+
+// Status returns the outcome of the last pull from each peer, by name.
+func (p *Puller) Status() map[string]PullStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make(map[string]PullStatus, len(p.status))
+	for name, s := range p.status {
+		status[name] = s
+	}
+	return status
+}
+
+// PullAll pulls from each peer in turn.
+func (p *Puller) PullAll(ctx context.Context) {
+	for _, peer := range p.client.Peers() {
+		if ctx.Err() != nil {
+			return
+		}
+		if n, err := p.Pull(ctx, peer); err != nil {
+			log.Printf("Pulling history from %s failed after %d results: %v", peer.Name, n, err)
+		} else if n > 0 {
+			log.Printf("Pulled %d results from %s", n, peer.Name)
+		}
+	}
+}
+
+// Pull stores the results of peer's own tests that are not stored yet and
+// returns how many it stored. The first pull copies the peer's whole
+// history; later ones stop at the results pulled before.
+func (p *Puller) Pull(ctx context.Context, peer models.Peer) (int, error) {
+	pulled, err := p.pull(ctx, peer)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	status := p.status[peer.Name]
+	status.LastPull = &now
+	status.Pulled += pulled
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	p.status[peer.Name] = status
+	return pulled, err
+}
+
+func (p *Puller) pull(ctx context.Context, peer models.Peer) (int, error) {
+	var stopBefore time.Time
+	latest, err := p.store.QueryTestResults(ctx, storage.HistoryFilter{Site: peer.Name}, 1, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(latest) > 0 {
+		stopBefore = latest[0].Timestamp.Add(-pullOverlap)
+	}
+
+	pulled := 0
+	for offset := 0; ; offset += pullPageSize {
+		var page historyPage
+		query := url.Values{"limit": {strconv.Itoa(pullPageSize)}, "offset": {strconv.Itoa(offset)}, "local": {"true"}}
+		if err := p.client.get(ctx, peer, "/api/history", query, &page); err != nil {
+			return pulled, err
+		}
+
+		for _, r := range page.Results {
+			if r.Timestamp.Before(stopBefore) {
+				return pulled, nil
+			}
+			// What the peer pulled itself is left to its own peers, should
+			// it ignore the local filter
+			if r.Site != "" || r.ID == "" {
+				continue
+			}
+			_, err := p.store.GetTestResult(ctx, r.ID)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, storage.ErrNotFound) {
+				return pulled, err
+			}
+			r.Site = peer.Name
+			if err := p.store.SaveTestResult(ctx, &r); err != nil {
+				return pulled, err
+			}
+			pulled++
+		}
+		if len(page.Results) < pullPageSize {
+			return pulled, nil
+		}
+	}
+}
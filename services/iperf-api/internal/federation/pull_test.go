@@ -0,0 +1,86 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+)
+
+// pagingPeer serves /api/history newest first, paging by limit and offset
+// as the API does, and counts the pages served.
+type pagingPeer struct {
+	results []models.TestResult
+	pages   int
+}
+
+func (p *pagingPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	page := []models.TestResult{}
+	for i := offset; i < len(p.results) && i < offset+limit; i++ {
+		page = append(page, p.results[i])
+	}
+	p.pages++
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": page, "total": len(p.results)})
+}
+
+// history returns n results a minute apart, newest first.
+func history(prefix string, newest time.Time, n int) []models.TestResult {
+	results := make([]models.TestResult, n)
+	for i := range results {
+		results[i] = models.TestResult{
+			ID: prefix + strconv.Itoa(n-i), Timestamp: newest.Add(-time.Duration(i) * time.Minute),
+			ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Status: models.TestStatusCompleted,
+		}
+	}
+	return results
+}
+
+func TestPuller_Pull(t *testing.T) {
+	newest := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	peer := &pagingPeer{results: history("b", newest, 250)}
+	// A result the peer pulled from elsewhere is not pulled again
+	peer.results[3].Site = "elsewhere"
+	srv := httptest.NewServer(peer)
+	defer srv.Close()
+
+	store := storage.NewMemory()
+	branch := models.Peer{Name: "branch", URL: srv.URL}
+	p := NewPuller(NewClient([]models.Peer{branch}, time.Second), store, time.Hour)
+	ctx := context.Background()
+
+	n, err := p.Pull(ctx, branch)
+	if err != nil || n != 249 || peer.pages != 3 {
+		t.Fatalf("first Pull = %d, %v after %d pages, want 249 in 3", n, err, peer.pages)
+	}
+	got, err := store.QueryTestResults(ctx, storage.HistoryFilter{Site: "branch"}, -1, 0)
+	if err != nil || len(got) != 249 || got[0].ID != "b250" || got[0].Site != "branch" {
+		t.Fatalf("stored %d results, first %+v, %v", len(got), got[0], err)
+	}
+
+	// Later pulls read back only to an hour before the newest result pulled
+	peer.results = append(history("n", newest.Add(10*time.Minute), 5), peer.results...)
+	peer.pages = 0
+	if n, err := p.Pull(ctx, branch); err != nil || n != 5 || peer.pages != 1 {
+		t.Errorf("second Pull = %d, %v after %d pages, want 5 in 1", n, err, peer.pages)
+	}
+	status := p.Status()["branch"]
+	if status.Pulled != 254 || status.LastPull == nil || status.LastError != "" {
+		t.Errorf("status = %+v", status)
+	}
+
+	srv.Close()
+	if _, err := p.Pull(ctx, branch); err == nil {
+		t.Error("Pull from a stopped peer succeeded")
+	}
+	if status := p.Status()["branch"]; status.LastError == "" || status.Pulled != 254 {
+		t.Errorf("status after failure = %+v", status)
+	}
+}
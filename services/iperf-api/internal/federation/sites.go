@@ -0,0 +1,68 @@
+package federation
+
+import (
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SiteSummary is the recent performance of one site. Bandwidths are means in
+// bits per second over completed, unflagged results; they are nil when there
+// are none.
+type SiteSummary struct {
+	Tests             int        `json:"tests"`
+	Completed         int        `json:"completed"`
+	AvgBandwidth      *float64   `json:"avgBandwidth,omitempty"`
+	UploadBandwidth   *float64   `json:"uploadBandwidth,omitempty"`
+	DownloadBandwidth *float64   `json:"downloadBandwidth,omitempty"`
+	LatestTest        *time.Time `json:"latestTest,omitempty"`
+}
+
+// Summarise summarises a site's results.
+func Summarise(results []models.TestResult) SiteSummary {
+	var summary SiteSummary
+	var all, upload, download mean
+	for _, r := range results {
+		summary.Tests++
+		if summary.LatestTest == nil || r.Timestamp.After(*summary.LatestTest) {
+			ts := r.Timestamp
+			summary.LatestTest = &ts
+		}
+		if r.Status != models.TestStatusCompleted {
+			continue
+		}
+		summary.Completed++
+		if len(r.QualityFlags) > 0 {
+			continue
+		}
+		all.add(r.AvgBandwidth)
+		switch r.Direction {
+		case "upload":
+			upload.add(r.AvgBandwidth)
+		case "download":
+			download.add(r.AvgBandwidth)
+		}
+	}
+	summary.AvgBandwidth = all.value()
+	summary.UploadBandwidth = upload.value()
+	summary.DownloadBandwidth = download.value()
+	return summary
+}
+
+type mean struct {
+	sum float64
+	n   int
+}
+
+func (m *mean) add(v float64) {
+	m.sum += v
+	m.n++
+}
+
+func (m mean) value() *float64 {
+	if m.n == 0 {
+		return nil
+	}
+	v := m.sum / float64(m.n)
+	return &v
+}
@@ -0,0 +1,94 @@
+// Package geoip resolves client addresses to a country, autonomous system and
+// ISP using MaxMind databases such as GeoLite2-Country, GeoLite2-ASN or
+// GeoIP2-ISP.
+package geoip
+
+import (
+	"log"
+	"net/netip"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Resolver looks addresses up in one or more MaxMind databases. Each field
+// is taken from the first database that has it, so country and ASN
+// databases can be combined.
+type Resolver struct {
+	dbs []*database
+}
+
+// Open loads the MaxMind DB files at paths.
+func Open(paths ...string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, path := range paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		r.dbs = append(r.dbs, db)
+	}
+	return r, nil
+}
+
+// Types returns the database type of each loaded file, e.g.
+// "GeoLite2-Country".
+func (r *Resolver) Types() []string {
+	types := make([]string, 0, len(r.dbs))
+	for _, db := range r.dbs {
+		types = append(types, db.dbType)
+	}
+	return types
+}
+
+// Lookup returns what the databases know about ip, or nil if it is not a
+// valid address or no database has a record for it, as for private ranges.
+func (r *Resolver) Lookup(ip string) *models.GeoInfo {
+	if r == nil {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.WithZone("")
+
+	var geo models.GeoInfo
+	for _, db := range r.dbs {
+		record, err := db.lookup(addr)
+		if err != nil {
+			log.Printf("GeoIP lookup of %s in %s failed: %v", ip, db.dbType, err)
+			continue
+		}
+		merge(&geo, record)
+	}
+	if geo == (models.GeoInfo{}) {
+		return nil
+	}
+	return &geo
+}
+
+// merge fills the empty fields of geo from a database record.
+func merge(geo *models.GeoInfo, record map[string]interface{}) {
+	if geo.Country == "" {
+		geo.Country = isoCode(record, "country")
+	}
+	if geo.Country == "" {
+		geo.Country = isoCode(record, "registered_country")
+	}
+	if geo.ASN == 0 {
+		geo.ASN = uintField(record, "autonomous_system_number")
+	}
+	if geo.ISP == "" {
+		geo.ISP, _ = record["isp"].(string)
+	}
+	if geo.ISP == "" {
+		geo.ISP, _ = record["autonomous_system_organization"].(string)
+	}
+}
+
+// isoCode returns the iso_code of a country record such as "country".
+func isoCode(record map[string]interface{}, name string) string {
+	country, _ := record[name].(map[string]interface{})
+	code, _ := country["iso_code"].(string)
+	return code
+}
@@ -0,0 +1,236 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// The helpers below write just enough of the MaxMind DB format to build
+// small test databases.
+
+func ctrl(typ, size int) []byte {
+	var b []byte
+	if typ > 7 {
+		b = []byte{0, byte(typ - 7)}
+	} else {
+		b = []byte{byte(typ << 5)}
+	}
+	if size < 29 {
+		b[0] |= byte(size)
+		return b
+	}
+	b[0] |= 29
+	return append(b, byte(size-29))
+}
+
+func str(s string) []byte { return append(ctrl(typeString, len(s)), s...) }
+
+func u16(v uint16) []byte { return append(ctrl(typeUint16, 2), byte(v>>8), byte(v)) }
+
+func u32(v uint32) []byte {
+	return append(ctrl(typeUint32, 4), binary.BigEndian.AppendUint32(nil, v)...)
+}
+
+func u64(v uint64) []byte {
+	return append(ctrl(typeUint64, 8), binary.BigEndian.AppendUint64(nil, v)...)
+}
+
+// ptr points to an offset below 2048.
+func ptr(off int) []byte { return []byte{byte(typePointer<<5 | (off>>8)&7), byte(off)} }
+
+func kv(pairs ...interface{}) []byte {
+	b := ctrl(typeMap, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		b = append(b, str(pairs[i].(string))...)
+		switch v := pairs[i+1].(type) {
+		case string:
+			b = append(b, str(v)...)
+		case []byte:
+			b = append(b, v...)
+		default:
+			panic(fmt.Sprintf("unsupported value %T", v))
+		}
+	}
+	return b
+}
+
+// entry maps a network to encoded data in the data section.
+type entry struct {
+	prefix string
+	data   []byte
+}
+
+// writeDB builds a database from entries and returns its path. Later data
+// may point into earlier data, since entries are laid out in order.
+func writeDB(t *testing.T, dbType string, ipVersion, recordSize int, entries []entry) string {
+	t.Helper()
+
+	type record struct{ node, data int }
+	const none = -1
+	nodes := [][2]record{{{none, none}, {none, none}}}
+	var section []byte
+	for _, e := range entries {
+		p := netip.MustParsePrefix(e.prefix)
+		addr := p.Addr().AsSlice()
+		bits := p.Bits()
+		if ipVersion == 6 && p.Addr().Is4() {
+			addr = append(make([]byte, 12), addr...)
+			bits += 96
+		}
+		offset := len(section)
+		section = append(section, e.data...)
+
+		n := 0
+		for i := 0; i < bits; i++ {
+			bit := (addr[i/8] >> (7 - i%8)) & 1
+			if i == bits-1 {
+				nodes[n][bit] = record{node: none, data: offset}
+				break
+			}
+			if nodes[n][bit].node == none {
+				nodes = append(nodes, [2]record{{none, none}, {none, none}})
+				nodes[n][bit] = record{node: len(nodes) - 1, data: none}
+			}
+			n = nodes[n][bit].node
+		}
+	}
+
+	count := len(nodes)
+	value := func(r record) uint32 {
+		switch {
+		case r.node != none:
+			return uint32(r.node)
+		case r.data != none:
+			return uint32(count + dataSectionSeparator + r.data)
+		}
+		return uint32(count)
+	}
+	var tree []byte
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>24)<<4|byte(right>>24)&0x0f, byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, left)
+			tree = binary.BigEndian.AppendUint32(tree, right)
+		}
+	}
+
+	buf := append(tree, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, section...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, kv(
+		"node_count", u32(uint32(count)),
+		"record_size", u16(uint16(recordSize)),
+		"ip_version", u16(uint16(ipVersion)),
+		"database_type", dbType,
+		"build_epoch", u64(1700000000),
+	)...)
+
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func countryDB(t *testing.T, recordSize int) string {
+	gb := kv("country", kv("iso_code", "GB"))
+	return writeDB(t, "GeoLite2-Country", 6, recordSize, []entry{
+		{"81.2.69.0/24", gb},
+		{"2001:db8::/32", kv("country", kv("iso_code", "DE"))},
+		// Only the registered country is known; it points at GB's country map,
+		// which follows the outer map's header and key
+		{"2001:db9::/32", kv("registered_country", ptr(1+len(str("country"))))},
+	})
+}
+
+func asnDB(t *testing.T) string {
+	return writeDB(t, "GeoLite2-ASN", 4, 24, []entry{
+		{"81.2.69.0/24", kv(
+			"autonomous_system_number", u32(64500),
+			"autonomous_system_organization", "Example Transit Networks Limited Company",
+		)},
+	})
+}
+
+func TestLookup_RecordSizes(t *testing.T) {
+	for _, size := range []int{24, 28, 32} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			r, err := Open(countryDB(t, size))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			tests := []struct {
+				ip   string
+				want string
+			}{
+				{"81.2.69.160", "GB"},
+				{"::ffff:81.2.69.160", "GB"},
+				{"2001:db8::1", "DE"},
+				{"fe80::1%eth0", ""},
+				{"2001:db9:1::5", "GB"},
+				{"10.0.0.1", ""},
+				{"not an ip", ""},
+			}
+			for _, tt := range tests {
+				geo := r.Lookup(tt.ip)
+				got := ""
+				if geo != nil {
+					got = geo.Country
+				}
+				if got != tt.want {
+					t.Errorf("Lookup(%q) country = %q, want %q", tt.ip, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLookup_CombinesDatabases(t *testing.T) {
+	r, err := Open(countryDB(t, 28), asnDB(t))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got := r.Types(); len(got) != 2 || got[0] != "GeoLite2-Country" || got[1] != "GeoLite2-ASN" {
+		t.Errorf("Types() = %v", got)
+	}
+
+	want := models.GeoInfo{Country: "GB", ASN: 64500, ISP: "Example Transit Networks Limited Company"}
+	if got := r.Lookup("81.2.69.1"); got == nil || *got != want {
+		t.Errorf("Lookup = %+v, want %+v", got, want)
+	}
+	// The IPv4-only ASN database has nothing for IPv6 clients
+	if got := r.Lookup("2001:db8::1"); got == nil || *got != (models.GeoInfo{Country: "DE"}) {
+		t.Errorf("Lookup = %+v, want only the country", got)
+	}
+	if got := r.Lookup("192.0.2.1"); got != nil {
+		t.Errorf("Lookup = %+v, want nil", got)
+	}
+
+	var unset *Resolver
+	if got := unset.Lookup("81.2.69.1"); got != nil {
+		t.Errorf("nil resolver Lookup = %+v", got)
+	}
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.mmdb")
+	os.WriteFile(path, []byte("not a database"), 0o644)
+	if _, err := Open(path); err == nil {
+		t.Error("Open succeeded on a file without metadata")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("Open succeeded on a missing file")
+	}
+}
@@ -0,0 +1,360 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparator = 16
+
+// database is a MaxMind DB (.mmdb) file read into memory. It implements the
+// parts of the format needed to look up one address: the binary search tree
+// and the data section's types.
+type database struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dbType     string
+	// data is the data section, which pointers are relative to
+	data []byte
+	// ipv4Start is the node reached by the 96 zero bits that prefix IPv4
+	// addresses in an IPv6 tree
+	ipv4Start uint
+}
+
+// openDatabase reads and checks a MaxMind DB file.
+func openDatabase(path string) (*database, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseDatabase(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func parseDatabase(buf []byte) (*database, error) {
+	at := bytes.LastIndex(buf, metadataMarker)
+	if at < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	meta := &decoder{buf: buf[at+len(metadataMarker):]}
+	value, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	db := &database{buf: buf}
+	db.nodeCount = uintField(fields, "node_count")
+	db.recordSize = uintField(fields, "record_size")
+	db.ipVersion = uintField(fields, "ip_version")
+	db.dbType, _ = fields["database_type"].(string)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(at) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	db.data = buf[treeSize+dataSectionSeparator : at]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func uintField(fields map[string]interface{}, name string) uint {
+	switch v := fields[name].(type) {
+	case uint64:
+		return uint(v)
+	case uint32:
+		return uint(v)
+	case uint16:
+		return uint(v)
+	}
+	return 0
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (db *database) record(node uint, bit byte) uint {
+	switch db.recordSize {
+	case 24:
+		off := node*6 + uint(bit)*3
+		b := db.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buf[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + uint(bit)*4
+		return uint(binary.BigEndian.Uint32(db.buf[off : off+4]))
+	}
+}
+
+// lookup returns the record stored for addr, or nil if there is none.
+func (db *database) lookup(addr netip.Addr) (map[string]interface{}, error) {
+	addr = addr.Unmap()
+	var bits []byte
+	node := uint(0)
+	switch {
+	case addr.Is4() && db.ipVersion == 6:
+		a := addr.As4()
+		bits, node = a[:], db.ipv4Start
+	case addr.Is4():
+		a := addr.As4()
+		bits = a[:]
+	case db.ipVersion == 4:
+		return nil, nil
+	default:
+		a := addr.As16()
+		bits = a[:]
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		// Equal to the node count means no data; smaller means the tree
+		// ran out of address bits, which a valid file never does
+		return nil, nil
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	d := &decoder{buf: db.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := value.(map[string]interface{})
+	return fields, nil
+}
+
+// Data section types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds nesting so a corrupt file cannot exhaust the stack.
+const maxDepth = 64
+
+// decoder reads values from a data section, or from the metadata, which uses
+// the same encoding.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+var errTruncated = errors.New("unexpected end of data")
+
+// decode reads the value at offset and returns it with the offset after it.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errTruncated
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+	b := d.buf[offset:end]
+	switch typ {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return append([]byte(nil), b...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), end, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("integer of size %d", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		switch typ {
+		case typeUint16:
+			return uint16(v), end, nil
+		case typeUint32:
+			return uint32(v), end, nil
+		case typeInt32:
+			return int32(uint32(v)), end, nil
+		}
+		return v, end, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), end, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// size reads the payload size encoded in a control byte and the bytes after
+// it.
+func (d *decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	extra := size - 28
+	if offset+extra > uint(len(d.buf)) {
+		return 0, 0, errTruncated
+	}
+	var v uint
+	for _, c := range d.buf[offset : offset+extra] {
+		v = v<<8 | uint(c)
+	}
+	switch size {
+	case 29:
+		v += 29
+	case 30:
+		v += 285
+	default:
+		v += 65821
+	}
+	return v, offset + extra, nil
+}
+
+// pointer reads a pointer's target and returns it with the offset after the
+// pointer.
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errTruncated
+	}
+	b := d.buf[offset : offset+n]
+	var v uint
+	if n < 4 {
+		v = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		v = v<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
@@ -0,0 +1,265 @@
+// Package grafana answers the simple JSON datasource protocol, which
+// Grafana's JSON and Infinity datasource plugins speak, over stored test
+// results, so bandwidth history can be charted without an exporter.
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// metric is a value charted from each completed result.
+type metric struct {
+	name string
+	// value returns the result's value, or false if it has none
+	value func(r models.TestResult) (float64, bool)
+}
+
+func optional(v *float64) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
+
+// percentile returns one of the result's interval bandwidth percentiles,
+// which results saved without samples do not have.
+func percentile(r models.TestResult, p int) (float64, bool) {
+	if r.Percentiles == nil {
+		return 0, false
+	}
+	switch p {
+	case 50:
+		return r.Percentiles.P50, true
+	case 95:
+		return r.Percentiles.P95, true
+	}
+	return r.Percentiles.P99, true
+}
+
+// metrics are the names search offers, in the order it lists them.
+var metrics = []metric{
+	{"bandwidth", func(r models.TestResult) (float64, bool) { return r.AvgBandwidth, true }},
+	{"max_bandwidth", func(r models.TestResult) (float64, bool) { return r.MaxBandwidth, true }},
+	{"min_bandwidth", func(r models.TestResult) (float64, bool) { return r.MinBandwidth, true }},
+	{"p50_bandwidth", func(r models.TestResult) (float64, bool) { return percentile(r, 50) }},
+	{"p95_bandwidth", func(r models.TestResult) (float64, bool) { return percentile(r, 95) }},
+	{"p99_bandwidth", func(r models.TestResult) (float64, bool) { return percentile(r, 99) }},
+	{"bytes", func(r models.TestResult) (float64, bool) { return float64(r.BytesTransferred), true }},
+	{"duration", func(r models.TestResult) (float64, bool) { return r.Duration, true }},
+	{"retransmits", func(r models.TestResult) (float64, bool) {
+		if r.Retransmits == nil {
+			return 0, false
+		}
+		return float64(*r.Retransmits), true
+	}},
+	{"jitter", func(r models.TestResult) (float64, bool) { return optional(r.Jitter) }},
+	{"packet_loss", func(r models.TestResult) (float64, bool) { return optional(r.PacketLoss) }},
+	{"retransmits_per_gb", func(r models.TestResult) (float64, bool) { return optional(r.RetransmitsPerGB) }},
+	{"goodput_ratio", func(r models.TestResult) (float64, bool) { return optional(r.GoodputRatio) }},
+	{"jitter_ratio", func(r models.TestResult) (float64, bool) { return optional(r.JitterRatio) }},
+	{"utilization", func(r models.TestResult) (float64, bool) { return optional(r.Utilization) }},
+}
+
+func lookup(name string) (metric, bool) {
+	for _, m := range metrics {
+		if m.name == name {
+			return m, true
+		}
+	}
+	return metric{}, false
+}
+
+// Search returns the targets matching a search query: the metric names
+// containing it or, for a query of the form "metric:" followed by part of
+// an address, that metric for each of clients starting with it. A target
+// "bandwidth:10.0.0.5" charts only that client's results.
+func Search(query string, clients []string) []string {
+	targets := []string{}
+	if name, prefix, ok := strings.Cut(query, ":"); ok {
+		if _, known := lookup(name); known {
+			for _, c := range clients {
+				if strings.HasPrefix(c, prefix) {
+					targets = append(targets, name+":"+c)
+				}
+			}
+		}
+		return targets
+	}
+	for _, m := range metrics {
+		if strings.Contains(m.name, query) {
+			targets = append(targets, m.name)
+		}
+	}
+	return targets
+}
+
+// Range is the period of a query, as Grafana sends it.
+type Range struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Target is one series or table a panel asks for. Type is "timeserie",
+// the default, or "table".
+type Target struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Type   string `json:"type"`
+}
+
+// QueryRequest is the body of a query.
+type QueryRequest struct {
+	Range         Range    `json:"range"`
+	Targets       []Target `json:"targets"`
+	MaxDataPoints int      `json:"maxDataPoints"`
+}
+
+// TimeSeries is a series of [value, Unix milliseconds] points.
+type TimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// Column heads a table column; Type is "time", "string" or "number".
+type Column struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// Table is the response to a table target: one row per result.
+type Table struct {
+	Type    string          `json:"type"`
+	Columns []Column        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// tableColumns head the table of results.
+var tableColumns = []Column{
+	{"Time", "time"}, {"Client", "string"}, {"Protocol", "string"}, {"Direction", "string"},
+	{"Bandwidth", "number"}, {"Jitter", "number"}, {"Packet loss", "number"}, {"Retransmits", "number"},
+}
+
+// Query answers each target from results, which must cover the request's
+// range. Only completed results are charted. A series longer than
+// MaxDataPoints is averaged down to at most that many points. An unknown
+// metric is an error.
+func Query(req QueryRequest, results []models.TestResult) ([]interface{}, error) {
+	completed := make([]models.TestResult, 0, len(results))
+	for _, r := range results {
+		if r.Status == models.TestStatusCompleted {
+			completed = append(completed, r)
+		}
+	}
+	sort.SliceStable(completed, func(i, j int) bool {
+		return completed[i].Timestamp.Before(completed[j].Timestamp)
+	})
+
+	answers := make([]interface{}, 0, len(req.Targets))
+	for _, t := range req.Targets {
+		name, client, _ := strings.Cut(t.Target, ":")
+		m, ok := lookup(name)
+		if !ok {
+			return nil, i18n.NewError("grafana.unknown_metric", i18n.Params{"metric": t.Target})
+		}
+		var matched []models.TestResult
+		for _, r := range completed {
+			if client == "" || r.ClientIP == client {
+				matched = append(matched, r)
+			}
+		}
+
+		if t.Type == "table" {
+			answers = append(answers, table(matched))
+			continue
+		}
+		series := TimeSeries{Target: t.Target, Datapoints: [][2]float64{}}
+		for _, r := range matched {
+			if v, ok := m.value(r); ok {
+				series.Datapoints = append(series.Datapoints, [2]float64{v, float64(r.Timestamp.UnixMilli())})
+			}
+		}
+		series.Datapoints = downsample(series.Datapoints, req.MaxDataPoints)
+		answers = append(answers, series)
+	}
+	return answers, nil
+}
+
+func table(results []models.TestResult) Table {
+	t := Table{Type: "table", Columns: tableColumns, Rows: [][]interface{}{}}
+	for _, r := range results {
+		var retransmits interface{}
+		if r.Retransmits != nil {
+			retransmits = *r.Retransmits
+		}
+		t.Rows = append(t.Rows, []interface{}{
+			r.Timestamp.UnixMilli(), r.ClientIP, string(r.Protocol), r.Direction,
+			r.AvgBandwidth, r.Jitter, r.PacketLoss, retransmits,
+		})
+	}
+	return t
+}
+
+// downsample averages runs of consecutive points so at most max remain.
+func downsample(points [][2]float64, max int) [][2]float64 {
+	if max <= 0 || len(points) <= max {
+		return points
+	}
+	size := (len(points) + max - 1) / max
+	out := make([][2]float64, 0, max)
+	for start := 0; start < len(points); start += size {
+		end := start + size
+		if end > len(points) {
+			end = len(points)
+		}
+		var value, ts float64
+		for _, p := range points[start:end] {
+			value += p[0]
+			ts += p[1]
+		}
+		n := float64(end - start)
+		out = append(out, [2]float64{value / n, ts / n})
+	}
+	return out
+}
+
+// AnnotationRequest is the body of an annotation query. Annotation is the
+// panel's annotation definition, which is echoed back.
+type AnnotationRequest struct {
+	Range      Range           `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+// Annotation is an event Grafana marks on the time axis.
+type Annotation struct {
+	Annotation json.RawMessage `json:"annotation,omitempty"`
+	Time       int64           `json:"time"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// Annotations turns config change annotations into Grafana's form.
+func Annotations(req AnnotationRequest, list []models.Annotation) []Annotation {
+	out := make([]Annotation, 0, len(list))
+	for _, a := range list {
+		changes := make([]string, 0, len(a.Changes))
+		for _, c := range a.Changes {
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", c.Field, c.From, c.To))
+		}
+		out = append(out, Annotation{
+			Annotation: req.Annotation,
+			Time:       a.Timestamp.UnixMilli(),
+			Title:      fmt.Sprintf("Config version %d", a.Version),
+			Text:       strings.Join(changes, "\n"),
+			Tags:       []string{string(a.Kind)},
+		})
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package grafana
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestSearch(t *testing.T) {
+	clients := []string{"10.0.0.5", "10.0.1.9", "192.0.2.1"}
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"bandwidth", []string{"bandwidth", "max_bandwidth", "min_bandwidth", "p50_bandwidth", "p95_bandwidth", "p99_bandwidth"}},
+		{"jit", []string{"jitter", "jitter_ratio"}},
+		{"bandwidth:10.0.", []string{"bandwidth:10.0.0.5", "bandwidth:10.0.1.9"}},
+		{"nope:10.0.", []string{}},
+	} {
+		if got := Search(tc.query, clients); !slices.Equal(got, tc.want) {
+			t.Errorf("Search(%q) = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+	if got := Search("", nil); len(got) != len(metrics) {
+		t.Errorf("Search(\"\") = %v, want every metric", got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	jitter := 0.5
+	results := []models.TestResult{
+		{Timestamp: base.Add(time.Minute), ClientIP: "10.0.0.5", Status: models.TestStatusCompleted, AvgBandwidth: 200, Jitter: &jitter},
+		{Timestamp: base, ClientIP: "10.0.0.6", Status: models.TestStatusCompleted, AvgBandwidth: 100},
+		{Timestamp: base.Add(2 * time.Minute), ClientIP: "10.0.0.5", Status: models.TestStatusFailed},
+	}
+
+	answers, err := Query(QueryRequest{Targets: []Target{
+		{Target: "bandwidth"},
+		{Target: "bandwidth:10.0.0.5"},
+		{Target: "jitter"},
+		{Target: "bandwidth", Type: "table"},
+	}}, results)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	ms := float64(base.UnixMilli())
+	all := answers[0].(TimeSeries)
+	if want := [][2]float64{{100, ms}, {200, ms + 60000}}; !slices.Equal(all.Datapoints, want) {
+		t.Errorf("bandwidth = %v, want %v, oldest first without the failed test", all.Datapoints, want)
+	}
+	if one := answers[1].(TimeSeries); len(one.Datapoints) != 1 || one.Datapoints[0][0] != 200 || one.Target != "bandwidth:10.0.0.5" {
+		t.Errorf("bandwidth for one client = %+v", one)
+	}
+	if j := answers[2].(TimeSeries); len(j.Datapoints) != 1 || j.Datapoints[0][0] != 0.5 {
+		t.Errorf("jitter = %+v, want only the result that has it", j)
+	}
+	tbl := answers[3].(Table)
+	if tbl.Type != "table" || len(tbl.Columns) != len(tbl.Rows[0]) || len(tbl.Rows) != 2 || tbl.Rows[0][1] != "10.0.0.6" {
+		t.Errorf("table = %+v", tbl)
+	}
+
+	_, err = Query(QueryRequest{Targets: []Target{{Target: "speed"}}}, results)
+	var unknown *i18n.Error
+	if !errors.As(err, &unknown) {
+		t.Errorf("unknown metric err = %v", err)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	points := [][2]float64{{1, 0}, {3, 10}, {5, 20}, {7, 30}, {9, 40}}
+	got := downsample(points, 2)
+	if want := [][2]float64{{3, 10}, {8, 35}}; !slices.Equal(got, want) {
+		t.Errorf("downsample = %v, want %v", got, want)
+	}
+	if got := downsample(points, 0); len(got) != 5 {
+		t.Errorf("downsample without a limit = %v", got)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	req := AnnotationRequest{Annotation: json.RawMessage(`{"name":"config"}`)}
+	got := Annotations(req, []models.Annotation{{
+		Timestamp: at, Kind: models.AnnotationKindConfigChange, Version: 3,
+		Changes: []models.ConfigChange{{Field: "port", From: "5201", To: "5202"}},
+	}})
+	if len(got) != 1 || got[0].Time != at.UnixMilli() || got[0].Title != "Config version 3" ||
+		got[0].Text != "port: 5201 → 5202" || string(got[0].Annotation) != `{"name":"config"}` {
+		t.Errorf("Annotations = %+v", got)
+	}
+}
@@ -0,0 +1,120 @@
+// Package httpserver serves the API over plain HTTP or TLS, with optional
+// HTTP to HTTPS redirects and Let's Encrypt certificates.
+package httpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config selects how the API is served. TLS is enabled by a certificate and
+// key pair or by autocert hosts, not both.
+type Config struct {
+	// Addr is the listen address, e.g. ":8080"
+	Addr string
+
+	CertFile string
+	KeyFile  string
+
+	// AutocertHosts are the hostnames to obtain Let's Encrypt certificates for
+	AutocertHosts []string
+	// AutocertCacheDir stores issued certificates across restarts
+	AutocertCacheDir string
+	AutocertEmail    string
+
+	// RedirectAddr, when set with TLS enabled, serves redirects to HTTPS and
+	// answers ACME HTTP-01 challenges
+	RedirectAddr string
+}
+
+// TLSEnabled reports whether the config serves HTTPS.
+func (c Config) TLSEnabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || len(c.AutocertHosts) > 0
+}
+
+// Validate checks that the TLS settings are complete and consistent.
+func (c Config) Validate() error {
+	manual := c.CertFile != "" || c.KeyFile != ""
+	if manual && (c.CertFile == "" || c.KeyFile == "") {
+		return errors.New("both a certificate and a key file are required")
+	}
+	if manual && len(c.AutocertHosts) > 0 {
+		return errors.New("use either a certificate and key or autocert hosts, not both")
+	}
+	if len(c.AutocertHosts) > 0 && c.AutocertCacheDir == "" {
+		return errors.New("autocert requires a cache directory")
+	}
+	if c.RedirectAddr != "" && !c.TLSEnabled() {
+		return errors.New("HTTPS redirect requires TLS to be enabled")
+	}
+	return nil
+}
+
+// RedirectHandler redirects every request to the same host and path over
+// HTTPS on httpsPort.
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// ListenAndServe serves handler according to cfg until the main listener
+// fails. The redirect listener, if any, runs alongside it.
+func ListenAndServe(cfg Config, handler http.Handler) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: cfg.Addr, Handler: handler}
+	if !cfg.TLSEnabled() {
+		return srv.ListenAndServe()
+	}
+
+	_, httpsPort, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return err
+	}
+	redirect := RedirectHandler(httpsPort)
+
+	if len(cfg.AutocertHosts) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertHosts...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+	} else {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if cfg.RedirectAddr != "" {
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", cfg.RedirectAddr)
+			if err := http.ListenAndServe(cfg.RedirectAddr, redirect); err != nil {
+				log.Printf("HTTP redirect listener failed: %v", err)
+			}
+		}()
+	}
+
+	// Certificates come from TLSConfig when autocert is used
+	return srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
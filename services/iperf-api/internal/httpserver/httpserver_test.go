@@ -0,0 +1,53 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"plain HTTP", Config{Addr: ":8080"}, false},
+		{"cert pair", Config{CertFile: "c.pem", KeyFile: "k.pem", RedirectAddr: ":80"}, false},
+		{"autocert", Config{AutocertHosts: []string{"iperf.example.com"}, AutocertCacheDir: "/data/autocert"}, false},
+		{"cert without key", Config{CertFile: "c.pem"}, true},
+		{"cert and autocert", Config{CertFile: "c.pem", KeyFile: "k.pem", AutocertHosts: []string{"a"}, AutocertCacheDir: "d"}, true},
+		{"autocert without cache", Config{AutocertHosts: []string{"a"}}, true},
+		{"redirect without TLS", Config{RedirectAddr: ":80"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port, host, target, want string
+	}{
+		{"8443", "iperf.example.com:8080", "/api/status?x=1", "https://iperf.example.com:8443/api/status?x=1"},
+		{"443", "iperf.example.com", "/ws", "https://iperf.example.com/ws"},
+		{"443", "[::1]:80", "/", "https://[::1]/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		RedirectHandler(tt.port).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMovedPermanently {
+			t.Errorf("%s%s: status %d, want 301", tt.host, tt.target, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s%s: Location = %q, want %q", tt.host, tt.target, got, tt.want)
+		}
+	}
+}
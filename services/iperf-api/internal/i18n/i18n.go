@@ -0,0 +1,241 @@
+// Package i18n translates user-facing API strings. Catalogs are flat JSON
+// maps from message key to text, with {name} placeholders filled from Params.
+// English and German are embedded; more languages can be loaded from a
+// directory of <lang>.json files.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLanguage is used when no requested language has a catalog, and for
+// keys missing from the selected catalog.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+// Params are the values substituted into {name} placeholders.
+type Params map[string]interface{}
+
+// Localizable is implemented by errors that carry a catalog message key.
+type Localizable interface {
+	error
+	MessageKey() (string, Params)
+}
+
+// Error is an error with a catalog key. Its Error text is the English message.
+type Error struct {
+	Key    string
+	Params Params
+}
+
+// NewError returns an Error for key with the given placeholder values.
+func NewError(key string, params Params) *Error {
+	return &Error{Key: key, Params: params}
+}
+
+// Error returns the English message.
+func (e *Error) Error() string {
+	return english.Translate(DefaultLanguage, e.Key, e.Params)
+}
+
+// MessageKey returns the catalog key and placeholder values.
+func (e *Error) MessageKey() (string, Params) {
+	return e.Key, e.Params
+}
+
+// english backs Error.Error so messages read the same everywhere.
+var english = MustNew()
+
+// Bundle holds the loaded catalogs.
+type Bundle struct {
+	mu       sync.RWMutex
+	catalogs map[string]map[string]string
+}
+
+// New returns a Bundle with the embedded catalogs loaded.
+func New() (*Bundle, error) {
+	b := &Bundle{catalogs: make(map[string]map[string]string)}
+
+	entries, err := embedded.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		data, err := embedded.ReadFile("locales/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := b.add(strings.TrimSuffix(e.Name(), ".json"), data); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// MustNew is like New but panics if the embedded catalogs are invalid.
+func MustNew() *Bundle {
+	b, err := New()
+	if err != nil {
+		panic(fmt.Sprintf("i18n: loading embedded catalogs: %v", err))
+	}
+	return b
+}
+
+// LoadDir adds every <lang>.json catalog in dir. Keys in a catalog for an
+// already loaded language override the existing messages.
+func (b *Bundle) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		lang := strings.TrimSuffix(filepath.Base(f), ".json")
+		if err := b.add(lang, data); err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	return nil
+}
+
+// add merges a JSON catalog into the bundle.
+func (b *Bundle) add(lang string, data []byte) error {
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+
+	lang = strings.ToLower(lang)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	catalog, ok := b.catalogs[lang]
+	if !ok {
+		catalog = make(map[string]string, len(messages))
+		b.catalogs[lang] = catalog
+	}
+	for k, v := range messages {
+		catalog[k] = v
+	}
+	return nil
+}
+
+// Languages returns the loaded language tags, sorted.
+func (b *Bundle) Languages() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	langs := make([]string, 0, len(b.catalogs))
+	for lang := range b.catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Match picks the best loaded language for an Accept-Language header,
+// honouring q-values and falling back from regional tags (de-AT) to their
+// base language (de). It returns DefaultLanguage when nothing matches.
+func (b *Bundle) Match(acceptLanguage string) string {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{tag, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, c := range candidates {
+		if _, ok := b.catalogs[c.tag]; ok {
+			return c.tag
+		}
+		if base, _, found := strings.Cut(c.tag, "-"); found {
+			if _, ok := b.catalogs[base]; ok {
+				return base
+			}
+		}
+	}
+	return DefaultLanguage
+}
+
+// Translate renders key in lang, falling back to DefaultLanguage and then to
+// the key itself.
+func (b *Bundle) Translate(lang, key string, params Params) string {
+	b.mu.RLock()
+	msg, ok := b.catalogs[lang][key]
+	if !ok {
+		msg, ok = b.catalogs[DefaultLanguage][key]
+	}
+	b.mu.RUnlock()
+
+	if !ok {
+		msg = key
+	}
+	return interpolate(msg, params)
+}
+
+// Localize returns err's message in lang. Errors without a message key fall
+// back to err.Error(); wrapped errors are not unwrapped, so context added by
+// the wrapper is kept. Joined errors are localized one by one.
+func (b *Bundle) Localize(lang string, err error) string {
+	if l, ok := err.(Localizable); ok {
+		key, params := l.MessageKey()
+		return b.Translate(lang, key, params)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = b.Localize(lang, e)
+		}
+		return strings.Join(msgs, "; ")
+	}
+	return err.Error()
+}
+
+// interpolate replaces {name} placeholders with their values.
+func interpolate(msg string, params Params) string {
+	if len(params) == 0 {
+		return msg
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(msg)
+}
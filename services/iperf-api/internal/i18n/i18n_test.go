@@ -0,0 +1,103 @@
+package i18n
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	b := MustNew()
+
+	tests := map[string]string{
+		"":                          "en",
+		"de":                        "de",
+		"de-AT,de;q=0.9":            "de",
+		"fr-CH, fr;q=0.9, de;q=0.8": "de",
+		"en;q=0.5, de;q=0.9":        "de",
+		"de;q=0, en":                "en",
+		"ja":                        "en",
+		"*":                         "en",
+	}
+	for header, want := range tests {
+		if got := b.Match(header); got != want {
+			t.Errorf("Match(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	b := MustNew()
+
+	if got := b.Translate("de", "error.invalid_body", Params{"error": "EOF"}); got != "Ungültiger Anfrageinhalt: EOF" {
+		t.Errorf("German translation = %q", got)
+	}
+	if got := b.Translate("xx", "error.period_order", nil); got != "from must be before to" {
+		t.Errorf("unknown language should fall back to English, got %q", got)
+	}
+	if got := b.Translate("en", "no.such.key", nil); got != "no.such.key" {
+		t.Errorf("missing key should return the key, got %q", got)
+	}
+}
+
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	b := MustNew()
+	en, de := b.catalogs["en"], b.catalogs["de"]
+
+	for key := range en {
+		if _, ok := de[key]; !ok {
+			t.Errorf("de catalog missing %q", key)
+		}
+	}
+	for key := range de {
+		if _, ok := en[key]; !ok {
+			t.Errorf("en catalog missing %q", key)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"error.period_order": "from doit précéder to"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"error.period_order": "Beginn vor Ende"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := MustNew()
+	if err := b.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+
+	if b.Match("fr-FR") != "fr" {
+		t.Errorf("fr catalog not selectable, languages = %v", b.Languages())
+	}
+	if got := b.Translate("fr", "error.period_order", nil); got != "from doit précéder to" {
+		t.Errorf("fr translation = %q", got)
+	}
+	if got := b.Translate("fr", "error.cost_center_required", nil); got != "costCenter is required" {
+		t.Errorf("fr missing key should fall back to English, got %q", got)
+	}
+	if got := b.Translate("de", "error.period_order", nil); got != "Beginn vor Ende" {
+		t.Errorf("de override = %q", got)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	b := MustNew()
+	err := NewError("accounting.invalid_match", Params{"match": "lab"})
+
+	if got := err.Error(); got != `invalid match "lab": must be an IP address or CIDR range` {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := b.Localize("de", err); got != `Ungültiger Wert "lab": muss eine IP-Adresse oder ein CIDR-Bereich sein` {
+		t.Errorf("Localize(de) = %q", got)
+	}
+
+	joined := errors.Join(err, NewError("server.not_running", nil), errors.New("plain"))
+	if got := b.Localize("de", joined); got != `Ungültiger Wert "lab": muss eine IP-Adresse oder ein CIDR-Bereich sein; Server läuft nicht; plain` {
+		t.Errorf("Localize(de) of joined errors = %q", got)
+	}
+}
@@ -0,0 +1,48 @@
+// Package ids generates the IDs given to test sessions, results and queued
+// jobs. The generator is chosen at startup so deployments that index IDs
+// can use time-ordered ones.
+package ids
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Generator returns a new unique ID on each call.
+type Generator func() string
+
+// Formats accepted by Parse
+const (
+	// FormatUUID is a random (version 4) UUID, the default
+	FormatUUID = "uuid"
+	// FormatUUIDv7 is a version 7 UUID, which sorts by creation time
+	FormatUUIDv7 = "uuidv7"
+)
+
+// UUID returns a random (version 4) UUID.
+func UUID() string {
+	return uuid.New().String()
+}
+
+// UUIDv7 returns a time-ordered (version 7) UUID, falling back to a random
+// one if the clock sequence cannot be read.
+func UUIDv7() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return UUID()
+	}
+	return id.String()
+}
+
+// Parse returns the generator for a format name; empty selects FormatUUID.
+func Parse(format string) (Generator, error) {
+	switch format {
+	case "", FormatUUID:
+		return UUID, nil
+	case FormatUUIDv7:
+		return UUIDv7, nil
+	default:
+		return nil, fmt.Errorf("unknown ID format %q: want %s or %s", format, FormatUUID, FormatUUIDv7)
+	}
+}
@@ -0,0 +1,38 @@
+package ids
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParse(t *testing.T) {
+	for format, version := range map[string]uuid.Version{"": 4, FormatUUID: 4, FormatUUIDv7: 7} {
+		gen, err := Parse(format)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", format, err)
+		}
+		id, err := uuid.Parse(gen())
+		if err != nil {
+			t.Fatalf("Parse(%q) generated an invalid UUID: %v", format, err)
+		}
+		if id.Version() != version {
+			t.Errorf("Parse(%q) generated version %d, want %d", format, id.Version(), version)
+		}
+	}
+
+	if _, err := Parse("ulid"); err == nil {
+		t.Error("Parse accepted an unknown format")
+	}
+}
+
+func TestUUIDv7_Ordered(t *testing.T) {
+	generated := make([]string, 100)
+	for i := range generated {
+		generated[i] = UUIDv7()
+	}
+	if !sort.StringsAreSorted(generated) {
+		t.Error("UUIDv7 IDs do not sort in creation order")
+	}
+}
@@ -3,8 +3,11 @@ package iperf
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
+	"strings"
 
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
 	"github.com/Tom-Oram/fak/backend/internal/models"
 )
 
@@ -12,6 +15,9 @@ import (
 type ValidationError struct {
 	Field   string
 	Message string
+	// Key and Params identify the message in the i18n catalogs
+	Key    string
+	Params i18n.Params
 }
 
 // Error returns the string representation of the validation error
@@ -19,6 +25,51 @@ func (e ValidationError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Field, e.Message)
 }
 
+// MessageKey returns the catalog key and placeholder values, including the field
+func (e ValidationError) MessageKey() (string, i18n.Params) {
+	params := i18n.Params{"field": e.Field}
+	for k, v := range e.Params {
+		params[k] = v
+	}
+	return e.Key, params
+}
+
+// ConfigErrors is every validation error of a configuration, reported
+// together.
+type ConfigErrors []ValidationError
+
+// Error joins the errors' messages
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual errors, so each can be matched and localized
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// MaxPortCount is the largest port pool a server may run
+const MaxPortCount = 64
+
+// MaxAllowlistEntries is the longest allowlist a server may have
+const MaxAllowlistEntries = 256
+
+// Report intervals a server may use, in seconds. iperf3 accepts 0.1 to 60;
+// iperf2 raises intervals under half a second to 0.5.
+const (
+	MinInterval       = 0.1
+	MinIperf2Interval = 0.5
+	MaxInterval       = 60
+)
+
 // ValidateConfig validates the server configuration and returns any validation errors
 func ValidateConfig(cfg models.ServerConfig) []ValidationError {
 	var errors []ValidationError
@@ -28,15 +79,72 @@ func ValidateConfig(cfg models.ServerConfig) []ValidationError {
 		errors = append(errors, ValidationError{
 			Field:   "port",
 			Message: "must be between 1 and 65535",
+			Key:     "validation.port_range",
 		})
 	}
 
-	// BindAddress must be valid IP if not empty or "0.0.0.0"
+	// A port pool must fit below 65536; one-off mode would end each listener
+	// after its first test
+	if cfg.PortCount < 0 || cfg.PortCount > MaxPortCount {
+		errors = append(errors, ValidationError{
+			Field:   "portCount",
+			Message: fmt.Sprintf("must be between 0 and %d", MaxPortCount),
+			Key:     "validation.port_count",
+			Params:  i18n.Params{"max": MaxPortCount},
+		})
+	} else if cfg.PortCount > 1 {
+		if cfg.Port+cfg.PortCount-1 > 65535 {
+			errors = append(errors, ValidationError{
+				Field:   "portCount",
+				Message: "pool must end at or below port 65535",
+				Key:     "validation.port_pool_range",
+			})
+		}
+		if cfg.OneOff {
+			errors = append(errors, ValidationError{
+				Field:   "oneOff",
+				Message: "not supported with a port pool",
+				Key:     "validation.oneoff_pool",
+			})
+		}
+	}
+
+	if cfg.AutoRearm && !cfg.OneOff {
+		errors = append(errors, ValidationError{
+			Field:   "autoRearm",
+			Message: "requires one-off mode",
+			Key:     "validation.auto_rearm",
+		})
+	}
+
+	// AddressFamily must be empty, ipv4 or ipv6
+	switch cfg.AddressFamily {
+	case models.AddressFamilyAny, models.AddressFamilyIPv4, models.AddressFamilyIPv6:
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "addressFamily",
+			Message: fmt.Sprintf("must be %q or %q", models.AddressFamilyIPv4, models.AddressFamilyIPv6),
+			Key:     "validation.address_family",
+			Params:  i18n.Params{"ipv4": models.AddressFamilyIPv4, "ipv6": models.AddressFamilyIPv6},
+		})
+	}
+
+	// BindAddress must be valid IP if not empty or "0.0.0.0", and of the
+	// address family when one is set
 	if cfg.BindAddress != "" && cfg.BindAddress != "0.0.0.0" {
-		if net.ParseIP(cfg.BindAddress) == nil {
+		ip := net.ParseIP(cfg.BindAddress)
+		if ip == nil {
 			errors = append(errors, ValidationError{
 				Field:   "bindAddress",
 				Message: "must be a valid IP address",
+				Key:     "validation.bind_address",
+			})
+		} else if family := addressFamily(ip); cfg.AddressFamily != models.AddressFamilyAny && family != cfg.AddressFamily {
+			errors = append(errors, ValidationError{
+				Field:   "bindAddress",
+				Message: fmt.Sprintf("must be an %s address", cfg.AddressFamily),
+				Key:     "validation.bind_address_family",
+				Params:  i18n.Params{"family": cfg.AddressFamily},
 			})
 		}
 	}
@@ -46,6 +154,71 @@ func ValidateConfig(cfg models.ServerConfig) []ValidationError {
 		errors = append(errors, ValidationError{
 			Field:   "idleTimeout",
 			Message: "must be non-negative",
+			Key:     "validation.idle_timeout",
+		})
+	}
+
+	// Version must be a supported iperf implementation
+	switch cfg.Version {
+	case "", models.IperfVersion3:
+	case models.IperfVersion2:
+		if cfg.OneOff {
+			errors = append(errors, ValidationError{
+				Field:   "oneOff",
+				Message: "not supported by iperf2",
+				Key:     "validation.oneoff_iperf2",
+			})
+		}
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "version",
+			Message: fmt.Sprintf("must be %q or %q", models.IperfVersion3, models.IperfVersion2),
+			Key:     "validation.version",
+			Params:  i18n.Params{"iperf3": models.IperfVersion3, "iperf2": models.IperfVersion2},
+		})
+	}
+
+	// OutputMode must be empty, auto, text or json; iperf2 only has text
+	switch cfg.OutputMode {
+	case "", models.OutputModeAuto, models.OutputModeText:
+	case models.OutputModeJSON:
+		if cfg.Version == models.IperfVersion2 {
+			errors = append(errors, ValidationError{
+				Field:   "outputMode",
+				Message: "not supported by iperf2",
+				Key:     "validation.output_mode_iperf2",
+			})
+		}
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "outputMode",
+			Message: fmt.Sprintf("must be %q, %q or %q", models.OutputModeAuto, models.OutputModeText, models.OutputModeJSON),
+			Key:     "validation.output_mode",
+			Params:  i18n.Params{"auto": models.OutputModeAuto, "text": models.OutputModeText, "json": models.OutputModeJSON},
+		})
+	}
+
+	// IntervalSeconds must be zero or within what the iperf version accepts
+	minInterval := MinInterval
+	if cfg.Version == models.IperfVersion2 {
+		minInterval = MinIperf2Interval
+	}
+	if cfg.IntervalSeconds != 0 && (cfg.IntervalSeconds < minInterval || cfg.IntervalSeconds > MaxInterval) {
+		errors = append(errors, ValidationError{
+			Field:   "intervalSeconds",
+			Message: fmt.Sprintf("must be between %g and %g seconds", minInterval, float64(MaxInterval)),
+			Key:     "validation.interval_range",
+			Params:  i18n.Params{"min": minInterval, "max": MaxInterval},
+		})
+	}
+
+	// A longer allowlist is rejected without checking each entry
+	if len(cfg.Allowlist) > MaxAllowlistEntries {
+		return append(errors, ValidationError{
+			Field:   "allowlist",
+			Message: fmt.Sprintf("must have at most %d entries", MaxAllowlistEntries),
+			Key:     "validation.allowlist_length",
+			Params:  i18n.Params{"max": MaxAllowlistEntries},
 		})
 	}
 
@@ -55,6 +228,8 @@ func ValidateConfig(cfg models.ServerConfig) []ValidationError {
 			errors = append(errors, ValidationError{
 				Field:   fmt.Sprintf("allowlist[%d]", i),
 				Message: fmt.Sprintf("invalid IP or CIDR: %s", entry),
+				Key:     "validation.allowlist_entry",
+				Params:  i18n.Params{"entry": entry},
 			})
 		}
 	}
@@ -62,23 +237,34 @@ func ValidateConfig(cfg models.ServerConfig) []ValidationError {
 	return errors
 }
 
-// isValidIPOrCIDR returns true if s is a valid IP address or CIDR notation
+// addressFamily returns the family of ip; IPv4-mapped IPv6 addresses are IPv4.
+func addressFamily(ip net.IP) models.AddressFamily {
+	if ip.To4() != nil {
+		return models.AddressFamilyIPv4
+	}
+	return models.AddressFamilyIPv6
+}
+
+// isValidIPOrCIDR returns true if s is a valid IP address, optionally with
+// an IPv6 zone, or CIDR notation
 func isValidIPOrCIDR(s string) bool {
-	// Check if it's a valid IP address
-	if net.ParseIP(s) != nil {
+	if _, err := netip.ParseAddr(s); err == nil {
 		return true
 	}
-
-	// Check if it's a valid CIDR notation
-	_, _, err := net.ParseCIDR(s)
+	_, err := netip.ParsePrefix(s)
 	return err == nil
 }
 
 // BuildArgs builds the command-line arguments for iperf3 based on the configuration
 func BuildArgs(cfg models.ServerConfig) []string {
+	if cfg.Version == models.IperfVersion2 {
+		return buildIperf2Args(cfg)
+	}
+
 	args := []string{
-		"-s",                          // server mode
-		"--forceflush",                // flush output per line
+		"-s",                         // server mode
+		"--forceflush",               // flush output per line
+		"-V",                         // verbose: report requested test parameters
 		"-p", strconv.Itoa(cfg.Port), // port
 	}
 
@@ -92,35 +278,86 @@ func BuildArgs(cfg models.ServerConfig) []string {
 		args = append(args, "-1")
 	}
 
+	// Restrict to one address family if set
+	switch cfg.AddressFamily {
+	case models.AddressFamilyIPv4:
+		args = append(args, "-4")
+	case models.AddressFamilyIPv6:
+		args = append(args, "-6")
+	}
+
+	// Report at the configured interval rather than every second
+	if cfg.IntervalSeconds > 0 {
+		args = append(args, "-i", formatInterval(cfg.IntervalSeconds))
+	}
+
 	// Note: UDP is auto-detected by iperf3 server, no flag needed
 
 	return args
 }
 
-// IsClientAllowed checks if a client IP is allowed based on the allowlist
+// IsClientAllowed checks if a client IP is allowed based on the allowlist.
+// IPv4-mapped IPv6 addresses match IPv4 entries. An entry with a zone, such
+// as fe80::1%eth0, only matches that zone; entries without one and CIDR
+// ranges match any zone.
 func IsClientAllowed(clientIP string, allowlist []string) bool {
 	// Empty allowlist means all clients are allowed
 	if len(allowlist) == 0 {
 		return true
 	}
 
-	parsedClientIP := net.ParseIP(clientIP)
-	if parsedClientIP == nil {
+	client, err := netip.ParseAddr(clientIP)
+	if err != nil {
 		return false
 	}
+	client = client.Unmap()
 
 	for _, entry := range allowlist {
 		// Check for exact IP match
-		if entry == clientIP {
-			return true
+		if ip, err := netip.ParseAddr(entry); err == nil {
+			ip = ip.Unmap()
+			if ip == client || (ip.Zone() == "" && ip == client.WithZone("")) {
+				return true
+			}
+			continue
 		}
 
 		// Check for CIDR match
-		_, network, err := net.ParseCIDR(entry)
-		if err == nil && network.Contains(parsedClientIP) {
+		if network, err := netip.ParsePrefix(entry); err == nil && network.Contains(client.WithZone("")) {
 			return true
 		}
 	}
 
 	return false
 }
+
+// buildIperf2Args builds the command-line arguments for a classic iperf (v2) server
+func buildIperf2Args(cfg models.ServerConfig) []string {
+	args := []string{
+		"-s",                                       // server mode
+		"-i", formatInterval(cfg.ReportInterval()), // interval reports, every second by default
+		"-p", strconv.Itoa(cfg.Port), // port
+	}
+
+	// iperf2 servers must be told to listen for UDP
+	if cfg.Protocol == models.ProtocolUDP {
+		args = append(args, "-u")
+	}
+
+	// Add bind address if not empty or "0.0.0.0"
+	if cfg.BindAddress != "" && cfg.BindAddress != "0.0.0.0" {
+		args = append(args, "-B", cfg.BindAddress)
+	}
+
+	// iperf2 listens on IPv4 unless told to use IPv6
+	if cfg.AddressFamily == models.AddressFamilyIPv6 {
+		args = append(args, "-V")
+	}
+
+	return args
+}
+
+// formatInterval formats a report interval in seconds as iperf's -i takes it.
+func formatInterval(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
@@ -1,8 +1,11 @@
 package iperf
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
 	"github.com/Tom-Oram/fak/backend/internal/models"
 )
 
@@ -24,6 +27,9 @@ func TestBuildArgs_NoJSON_HasForceflush(t *testing.T) {
 	if !hasForceflush {
 		t.Error("expected --forceflush in args, not found")
 	}
+	if !strings.Contains(strings.Join(args, " "), "-V") {
+		t.Error("expected -V in args so client test parameters are reported")
+	}
 	if hasJSON {
 		t.Error("-J should not be in args")
 	}
@@ -44,3 +50,380 @@ func TestBuildArgs_ServerMode(t *testing.T) {
 		t.Error("expected -s in args, not found")
 	}
 }
+
+func TestBuildArgs_Iperf2(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	cfg.Version = models.IperfVersion2
+	cfg.Protocol = models.ProtocolUDP
+	cfg.BindAddress = "10.0.0.2"
+
+	args := strings.Join(BuildArgs(cfg), " ")
+
+	for _, want := range []string{"-s", "-i 1", "-p 5201", "-u", "-B 10.0.0.2"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q missing %q", args, want)
+		}
+	}
+	if strings.Contains(args, "--forceflush") {
+		t.Errorf("iperf2 args %q must not contain iperf3-only --forceflush", args)
+	}
+}
+
+func TestBuildArgs_Iperf2_TCPHasNoUDPFlag(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	cfg.Version = models.IperfVersion2
+
+	for _, arg := range BuildArgs(cfg) {
+		if arg == "-u" {
+			t.Error("-u should only be passed for UDP")
+		}
+	}
+}
+
+func TestBuildArgs_AddressFamily(t *testing.T) {
+	tests := []struct {
+		version models.IperfVersion
+		family  models.AddressFamily
+		bind    string
+		want    []string
+		absent  []string
+	}{
+		{models.IperfVersion3, models.AddressFamilyAny, "", nil, []string{"-4", "-6"}},
+		{models.IperfVersion3, models.AddressFamilyIPv4, "", []string{"-4"}, []string{"-6"}},
+		{models.IperfVersion3, models.AddressFamilyIPv6, "2001:db8::1", []string{"-6", "-B 2001:db8::1"}, []string{"-4"}},
+		{models.IperfVersion2, models.AddressFamilyIPv6, "", []string{"-V"}, []string{"-6"}},
+		{models.IperfVersion2, models.AddressFamilyIPv4, "", nil, []string{"-V", "-4"}},
+	}
+	for _, tt := range tests {
+		cfg := models.DefaultServerConfig()
+		cfg.Version = tt.version
+		cfg.AddressFamily = tt.family
+		cfg.BindAddress = tt.bind
+		args := " " + strings.Join(BuildArgs(cfg), " ") + " "
+		for _, want := range tt.want {
+			if !strings.Contains(args, " "+want+" ") {
+				t.Errorf("%s %q: args %q missing %q", tt.version, tt.family, args, want)
+			}
+		}
+		for _, absent := range tt.absent {
+			if strings.Contains(args, " "+absent+" ") {
+				t.Errorf("%s %q: args %q contain %q", tt.version, tt.family, args, absent)
+			}
+		}
+	}
+}
+
+func TestValidateConfig_AddressFamily(t *testing.T) {
+	tests := []struct {
+		family models.AddressFamily
+		bind   string
+		field  string
+	}{
+		{models.AddressFamilyIPv6, "2001:db8::1", ""},
+		{models.AddressFamilyIPv6, "0.0.0.0", ""},
+		{models.AddressFamilyIPv6, "::", ""},
+		{models.AddressFamilyIPv4, "10.0.0.2", ""},
+		{models.AddressFamilyAny, "fe80::1", ""},
+		{models.AddressFamilyIPv6, "10.0.0.2", "bindAddress"},
+		{models.AddressFamilyIPv6, "::ffff:10.0.0.2", "bindAddress"},
+		{models.AddressFamilyIPv4, "::", "bindAddress"},
+		{"ipv5", "", "addressFamily"},
+	}
+	for _, tt := range tests {
+		cfg := models.DefaultServerConfig()
+		cfg.AddressFamily = tt.family
+		cfg.BindAddress = tt.bind
+		errs := ValidateConfig(cfg)
+		if tt.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("%q with %q: unexpected errors %v", tt.family, tt.bind, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("%q with %q: errors = %v, want one on %s", tt.family, tt.bind, errs, tt.field)
+			continue
+		}
+		key, params := errs[0].MessageKey()
+		if got := i18n.MustNew().Translate("en", key, params); got != errs[0].Error() {
+			t.Errorf("catalog text %q does not match Error() %q", got, errs[0].Error())
+		}
+	}
+}
+
+func TestValidateConfig_Version(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   models.IperfVersion
+		oneOff    bool
+		wantField string
+	}{
+		{"default", "", false, ""},
+		{"iperf3", models.IperfVersion3, true, ""},
+		{"iperf2", models.IperfVersion2, false, ""},
+		{"iperf2 one-off", models.IperfVersion2, true, "oneOff"},
+		{"unknown", "iperf4", false, "version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := models.DefaultServerConfig()
+			cfg.Version = tt.version
+			cfg.OneOff = tt.oneOff
+
+			errs := ValidateConfig(cfg)
+			if tt.wantField == "" {
+				if len(errs) != 0 {
+					t.Errorf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantField {
+				t.Errorf("errors = %v, want one on %q", errs, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidationError_MessageKeyMatchesEnglish(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	cfg.Port = 0
+	cfg.BindAddress = "not-an-ip"
+	cfg.IdleTimeout = -1
+	cfg.Version = "iperf4"
+	cfg.Allowlist = []string{"bad"}
+
+	errs := ValidateConfig(cfg)
+	if len(errs) != 5 {
+		t.Fatalf("got %d errors, want 5: %v", len(errs), errs)
+	}
+
+	catalogs := i18n.MustNew()
+	for _, e := range errs {
+		key, params := e.MessageKey()
+		if got := catalogs.Translate("en", key, params); got != e.Error() {
+			t.Errorf("catalog text %q does not match Error() %q", got, e.Error())
+		}
+		if got := catalogs.Translate("de", key, params); got == key || got == e.Error() {
+			t.Errorf("%s has no German translation", key)
+		}
+	}
+}
+
+func TestValidateConfig_AutoRearm(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	cfg.AutoRearm = true
+
+	errs := ValidateConfig(cfg)
+	if len(errs) != 1 || errs[0].Field != "autoRearm" {
+		t.Fatalf("errors = %v, want one on autoRearm", errs)
+	}
+	key, params := errs[0].MessageKey()
+	if got := i18n.MustNew().Translate("en", key, params); got != errs[0].Error() {
+		t.Errorf("catalog text %q does not match Error() %q", got, errs[0].Error())
+	}
+
+	cfg.OneOff = true
+	if errs := ValidateConfig(cfg); len(errs) != 0 {
+		t.Errorf("one-off with auto-rearm: unexpected errors %v", errs)
+	}
+
+	// Rearming needs one-off mode, which a port pool cannot run
+	cfg.PortCount = 3
+	if errs := ValidateConfig(cfg); len(errs) != 1 || errs[0].Field != "oneOff" {
+		t.Errorf("auto-rearm pool: errors = %v, want one on oneOff", errs)
+	}
+}
+
+func TestValidateConfig_PortPool(t *testing.T) {
+	tests := []struct {
+		name      string
+		port      int
+		portCount int
+		oneOff    bool
+		wantField string
+	}{
+		{"single", 5201, 1, true, ""},
+		{"pool", 5201, 10, false, ""},
+		{"negative", 5201, -1, false, "portCount"},
+		{"too many", 5201, MaxPortCount + 1, false, "portCount"},
+		{"past 65535", 65530, 10, false, "portCount"},
+		{"pool one-off", 5201, 10, true, "oneOff"},
+	}
+
+	catalogs := i18n.MustNew()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := models.DefaultServerConfig()
+			cfg.Port = tt.port
+			cfg.PortCount = tt.portCount
+			cfg.OneOff = tt.oneOff
+
+			errs := ValidateConfig(cfg)
+			if tt.wantField == "" {
+				if len(errs) != 0 {
+					t.Errorf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantField {
+				t.Fatalf("errors = %v, want one on %q", errs, tt.wantField)
+			}
+			key, params := errs[0].MessageKey()
+			if got := catalogs.Translate("en", key, params); got != errs[0].Error() {
+				t.Errorf("catalog text %q does not match Error() %q", got, errs[0].Error())
+			}
+		})
+	}
+}
+
+func TestIsClientAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32", "fe80::1%eth0", "fe80::9"}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"192.168.1.5", true},
+		{"::ffff:192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"fe80::1%eth0", true},
+		{"fe80::1%eth1", false},
+		{"fe80::9%eth1", true},
+		{"fe80::1", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := IsClientAllowed(tt.ip, allowlist); got != tt.want {
+			t.Errorf("IsClientAllowed(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if !IsClientAllowed("fe80::1%eth0", nil) {
+		t.Error("empty allowlist should allow every client")
+	}
+}
+
+func TestValidateConfig_IPv6Allowlist(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	cfg.Allowlist = []string{"2001:db8::/32", "fe80::1%eth0", "::1"}
+	if errs := ValidateConfig(cfg); len(errs) != 0 {
+		t.Errorf("ValidateConfig() = %v, want no errors", errs)
+	}
+	cfg.Allowlist = []string{"fe80::/10%eth0"}
+	if errs := ValidateConfig(cfg); len(errs) != 1 {
+		t.Errorf("ValidateConfig() = %v, want a zoned range rejected", errs)
+	}
+}
+
+func TestBuildArgs_Interval(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	if args := strings.Join(BuildArgs(cfg), " "); strings.Contains(args, "-i") {
+		t.Errorf("default args %q set an interval", args)
+	}
+	cfg.IntervalSeconds = 0.5
+	if args := strings.Join(BuildArgs(cfg), " "); !strings.Contains(args, "-i 0.5") {
+		t.Errorf("args %q missing -i 0.5", args)
+	}
+	cfg.Version = models.IperfVersion2
+	cfg.IntervalSeconds = 2
+	if args := strings.Join(BuildArgs(cfg), " "); !strings.Contains(args, "-i 2 ") {
+		t.Errorf("iperf2 args %q missing -i 2", args)
+	}
+}
+
+func TestValidateConfig_Interval(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		version models.IperfVersion
+		valid   bool
+	}{
+		{0, models.IperfVersion3, true},
+		{0.1, models.IperfVersion3, true},
+		{60, models.IperfVersion3, true},
+		{0.05, models.IperfVersion3, false},
+		{61, models.IperfVersion3, false},
+		{-1, models.IperfVersion3, false},
+		{0.5, models.IperfVersion2, true},
+		{0.2, models.IperfVersion2, false},
+	}
+	for _, tt := range tests {
+		cfg := models.DefaultServerConfig()
+		cfg.IntervalSeconds = tt.seconds
+		cfg.Version = tt.version
+		errs := ValidateConfig(cfg)
+		if tt.valid {
+			if len(errs) != 0 {
+				t.Errorf("%v s on %s: unexpected errors %v", tt.seconds, tt.version, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != "intervalSeconds" {
+			t.Errorf("%v s on %s: errors = %v, want one on intervalSeconds", tt.seconds, tt.version, errs)
+			continue
+		}
+		key, params := errs[0].MessageKey()
+		if got := i18n.MustNew().Translate("en", key, params); got != errs[0].Error() {
+			t.Errorf("catalog text %q does not match Error() %q", got, errs[0].Error())
+		}
+	}
+}
+
+func TestValidateConfig_AllowlistLength(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	for i := 0; i < MaxAllowlistEntries; i++ {
+		cfg.Allowlist = append(cfg.Allowlist, fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	if errs := ValidateConfig(cfg); len(errs) != 0 {
+		t.Errorf("%d entries: unexpected errors %v", len(cfg.Allowlist), errs)
+	}
+
+	// One too many is a single error, however many entries are invalid
+	cfg.Allowlist = append(cfg.Allowlist, "not-an-ip", "also-not")
+	errs := ValidateConfig(cfg)
+	if len(errs) != 1 || errs[0].Field != "allowlist" {
+		t.Fatalf("errors = %v, want one on allowlist", errs)
+	}
+	key, params := errs[0].MessageKey()
+	if got := i18n.MustNew().Translate("en", key, params); got != errs[0].Error() {
+		t.Errorf("catalog text %q does not match Error() %q", got, errs[0].Error())
+	}
+}
+
+func TestValidateConfig_OutputMode(t *testing.T) {
+	tests := []struct {
+		mode    models.OutputMode
+		version models.IperfVersion
+		field   string
+	}{
+		{"", models.IperfVersion3, ""},
+		{models.OutputModeAuto, models.IperfVersion3, ""},
+		{models.OutputModeJSON, models.IperfVersion3, ""},
+		{models.OutputModeAuto, models.IperfVersion2, ""},
+		{models.OutputModeText, models.IperfVersion2, ""},
+		{models.OutputModeJSON, models.IperfVersion2, "outputMode"},
+		{"xml", models.IperfVersion3, "outputMode"},
+	}
+	for _, tt := range tests {
+		cfg := models.DefaultServerConfig()
+		cfg.OutputMode = tt.mode
+		cfg.Version = tt.version
+		errs := ValidateConfig(cfg)
+		if tt.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("%q on %s: unexpected errors %v", tt.mode, tt.version, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("%q on %s: errors = %v, want one on %s", tt.mode, tt.version, errs, tt.field)
+			continue
+		}
+		key, params := errs[0].MessageKey()
+		if got := i18n.MustNew().Translate("en", key, params); got != errs[0].Error() {
+			t.Errorf("catalog text %q does not match Error() %q", got, errs[0].Error())
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package iperf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the corpus golden files")
+
+// corpusEvent is one event in a golden file: what a parser made of a line of
+// captured server output.
+type corpusEvent struct {
+	Line            int                     `json:"line"`
+	Event           string                  `json:"event"`
+	ConnectionEvent *models.ConnectionEvent `json:"connectionEvent,omitempty"`
+	BandwidthUpdate *models.BandwidthUpdate `json:"bandwidthUpdate,omitempty"`
+	TestResult      *models.TestResult      `json:"testResult,omitempty"`
+	Collision       *models.Collision       `json:"collision,omitempty"`
+	ErrorMessage    string                  `json:"errorMessage,omitempty"`
+}
+
+var eventNames = map[ParseEvent]string{
+	EventClientConnected: "connected",
+	EventBandwidthUpdate: "bandwidth",
+	EventTestComplete:    "complete",
+	EventError:           "error",
+	EventCollision:       "collision",
+}
+
+// corpusOutput is what the captures in a testdata/corpus directory are:
+// output of which iperf version, in which format.
+type corpusOutput struct {
+	version models.IperfVersion
+	format  outputFormat
+	ext     string
+}
+
+var corpusDirs = map[string]corpusOutput{
+	"iperf3":      {models.IperfVersion3, formatText, ".txt"},
+	"iperf2":      {models.IperfVersion2, formatText, ".txt"},
+	"iperf3-json": {models.IperfVersion3, formatJSON, ".json"},
+}
+
+// TestParserCorpus feeds each captured server output under testdata/corpus
+// through the parser for its iperf version and format and compares the
+// events against the neighbouring .golden file. Run with -update to rewrite
+// the goldens after an intended change, and review the diff.
+func TestParserCorpus(t *testing.T) {
+	for dir, output := range corpusDirs {
+		files := corpusFiles(t, dir)
+		for _, file := range files {
+			t.Run(dir+"/"+strings.TrimSuffix(filepath.Base(file), output.ext), func(t *testing.T) {
+				got := runCorpus(t, file, output)
+				golden := strings.TrimSuffix(file, output.ext) + ".golden"
+				if *update {
+					if err := os.WriteFile(golden, got, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("read golden (run with -update to create it): %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("events differ from %s (run with -update and review the diff):\n%s", golden, got)
+				}
+			})
+		}
+	}
+}
+
+// corpusFiles returns the captures in a testdata/corpus directory.
+func corpusFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "corpus", dir, "*"+corpusDirs[dir].ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no %s corpus files", dir)
+	}
+	return files
+}
+
+// runCorpus parses file and returns the resulting events as indented JSON.
+func runCorpus(t *testing.T, file string, output corpusOutput) []byte {
+	t.Helper()
+	out, err := json.MarshalIndent(corpusEvents(t, file, output), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(out, '\n')
+}
+
+// corpusEvents parses file line by line and returns the resulting events.
+// An unfinished session at the end is aborted and recorded as an "aborted"
+// event. Timestamps the parser took from the wall clock rather than from
+// iperf's output are zeroed so the goldens are stable.
+func corpusEvents(t *testing.T, file string, output corpusOutput) []corpusEvent {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n := 0
+	newID := func() string {
+		n++
+		return fmt.Sprintf("session-%d", n)
+	}
+	began := time.Now()
+	parser := newParser(output.version, output.format, newID, 0)
+
+	events := []corpusEvent{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		r := parser.ParseLine(scanner.Text())
+		if r.Event == EventNone {
+			continue
+		}
+		events = append(events, corpusEvent{
+			Line:            line,
+			Event:           eventNames[r.Event],
+			ConnectionEvent: r.ConnectionEvent,
+			BandwidthUpdate: r.BandwidthUpdate,
+			TestResult:      r.TestResult,
+			Collision:       r.Collision,
+			ErrorMessage:    r.ErrorMessage,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if parser.InSession() {
+		result := parser.AbortSession(models.TestStatusAborted, "end of output")
+		events = append(events, corpusEvent{Line: line, Event: "aborted", TestResult: result})
+	}
+
+	for _, e := range events {
+		if e.ConnectionEvent != nil {
+			normalizeTime(&e.ConnectionEvent.Timestamp, began)
+		}
+		if e.BandwidthUpdate != nil {
+			normalizeTime(&e.BandwidthUpdate.Timestamp, began)
+		}
+		if e.TestResult != nil {
+			normalizeTime(&e.TestResult.Timestamp, began)
+		}
+		if e.Collision != nil {
+			normalizeTime(&e.Collision.Timestamp, began)
+		}
+	}
+	return events
+}
+
+// normalizeTime zeroes a timestamp taken from the wall clock during the run.
+func normalizeTime(ts *time.Time, began time.Time) {
+	if !ts.Before(began) {
+		*ts = time.Time{}
+	}
+}
+
+// TestParserCorpus_FormatsAgree parses each -J capture under
+// testdata/corpus/iperf3-json and the text capture of the same test under
+// testdata/corpus/iperf3, and checks the two parsers make the same results
+// of them. Text output rounds to three significant figures where -J is
+// exact, so measurements agree within that rounding. -J also reports the
+// client's end of a test, which text output from iperf3 3.7 on leaves out,
+// so the ends compared are those text output reports.
+func TestParserCorpus_FormatsAgree(t *testing.T) {
+	for _, file := range corpusFiles(t, "iperf3-json") {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			text := filepath.Join("testdata", "corpus", "iperf3", name+".txt")
+			if _, err := os.Stat(text); err != nil {
+				t.Fatalf("no text capture of the test: %v", err)
+			}
+			want := corpusOutcomes(corpusEvents(t, text, corpusDirs["iperf3"]))
+			got := corpusOutcomes(corpusEvents(t, file, corpusDirs["iperf3-json"]))
+			if len(got) != len(want) {
+				t.Fatalf("-J made %d results and errors, text %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Event != want[i].Event || got[i].ErrorMessage != want[i].ErrorMessage {
+					t.Errorf("-J %s %q, text %s %q", got[i].Event, got[i].ErrorMessage, want[i].Event, want[i].ErrorMessage)
+				}
+				if want[i].TestResult != nil && got[i].TestResult != nil {
+					compareResults(t, got[i].TestResult, want[i].TestResult)
+				}
+			}
+		})
+	}
+}
+
+// corpusOutcomes returns the results and errors among a capture's events.
+func corpusOutcomes(events []corpusEvent) []corpusEvent {
+	var outcomes []corpusEvent
+	for _, e := range events {
+		if e.TestResult != nil || e.Event == "error" {
+			outcomes = append(outcomes, e)
+		}
+	}
+	return outcomes
+}
+
+// compareResults reports where a result parsed from -J output differs from
+// the one parsed from text output of the same test.
+func compareResults(t *testing.T, got, want *models.TestResult) {
+	t.Helper()
+	if got.Status != want.Status || got.Direction != want.Direction || got.Protocol != want.Protocol ||
+		got.ClientIP != want.ClientIP || got.ClientPort != want.ClientPort || !got.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("-J %s %s %s test from %s:%d at %s, text %s %s %s test from %s:%d at %s",
+			got.Status, got.Direction, got.Protocol, got.ClientIP, got.ClientPort, got.Timestamp,
+			want.Status, want.Direction, want.Protocol, want.ClientIP, want.ClientPort, want.Timestamp)
+	}
+	// Text output prints hundredths of a second
+	if math.Abs(got.Duration-want.Duration) > 0.005 {
+		t.Errorf("duration: -J %v, text %v", got.Duration, want.Duration)
+	}
+	agree(t, "bytes", float64(got.BytesTransferred), float64(want.BytesTransferred))
+	agree(t, "average", got.AvgBandwidth, want.AvgBandwidth)
+	agree(t, "minimum", got.MinBandwidth, want.MinBandwidth)
+	agree(t, "maximum", got.MaxBandwidth, want.MaxBandwidth)
+	agreeOptional(t, "jitter", got.Jitter, want.Jitter, 0.0005)
+	agreeOptional(t, "packet loss", got.PacketLoss, want.PacketLoss, 0.005)
+	agreeOptional(t, "host CPU", got.HostCPUTotal, want.HostCPUTotal, 0.05)
+	agreeOptional(t, "remote CPU", got.RemoteCPUTotal, want.RemoteCPUTotal, 0.05)
+	agreeOptional(t, "requested duration", got.RequestedDuration, want.RequestedDuration, 0)
+	if want.Retransmits != nil && (got.Retransmits == nil || *got.Retransmits != *want.Retransmits) {
+		t.Errorf("retransmits: -J %s, text %d", fmtOptional(got.Retransmits), *want.Retransmits)
+	}
+	for _, end := range []struct {
+		role      string
+		got, want *models.SideStats
+	}{{"sender", got.Sender, want.Sender}, {"receiver", got.Receiver, want.Receiver}} {
+		if end.want == nil {
+			continue
+		}
+		if end.got == nil {
+			t.Errorf("-J has no %s end", end.role)
+			continue
+		}
+		agree(t, end.role+" bytes", float64(end.got.Bytes), float64(end.want.Bytes))
+		agree(t, end.role+" bandwidth", end.got.Bandwidth, end.want.Bandwidth)
+		if r := end.want.Retransmits; r != nil && (end.got.Retransmits == nil || *end.got.Retransmits != *r) {
+			t.Errorf("%s retransmits: -J %s, text %d", end.role, fmtOptional(end.got.Retransmits), *r)
+		}
+	}
+	// -J reports the server's default MSS where text output reports what
+	// the client set, so the MSS is left out
+	if g, w := got.Client, want.Client; (g == nil) != (w == nil) || g != nil &&
+		(g.Protocol != w.Protocol || g.Streams != w.Streams || g.BlockSize != w.BlockSize || g.Duration != w.Duration) {
+		t.Errorf("client: -J %+v, text %+v", g, w)
+	}
+}
+
+// agree reports a measurement of -J output that is not within the rounding
+// of text output's three significant figures of it.
+func agree(t *testing.T, what string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 0.005*math.Max(math.Abs(got), math.Abs(want)) {
+		t.Errorf("%s: -J %v, text %v", what, got, want)
+	}
+}
+
+// agreeOptional reports a measurement reported by only one of the formats,
+// or by both but more than tolerance apart.
+func agreeOptional(t *testing.T, what string, got, want *float64, tolerance float64) {
+	t.Helper()
+	if (got == nil) != (want == nil) || got != nil && math.Abs(*got-*want) > tolerance {
+		t.Errorf("%s: -J %v, text %v", what, fmtOptional(got), fmtOptional(want))
+	}
+}
+
+// fmtOptional formats a measurement a format may not report.
+func fmtOptional[T int | float64](v *T) string {
+	if v == nil {
+		return "none"
+	}
+	return fmt.Sprint(*v)
+}
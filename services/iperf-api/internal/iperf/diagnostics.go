@@ -0,0 +1,63 @@
+package iperf
+
+import (
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Diagnostics returns the processes behind the running server, how many
+// times it was restarted automatically and how the last process exited.
+func (m *Manager) Diagnostics() *models.ProcessDiagnostics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.diagnosticsLocked()
+}
+
+// diagnosticsLocked builds the process diagnostics (must be called with lock
+// held).
+func (m *Manager) diagnosticsLocked() *models.ProcessDiagnostics {
+	d := &models.ProcessDiagnostics{
+		Processes: []models.ProcessInfo{},
+		Restarts:  m.restarts,
+	}
+	if m.lastExit != nil {
+		exit := *m.lastExit
+		d.LastExit = &exit
+	}
+	if m.status != models.ServerStatusRunning {
+		return d
+	}
+
+	now := time.Now()
+	for _, l := range m.listeners {
+		info := models.ProcessInfo{
+			Port:      l.port,
+			Path:      l.cmd.Path,
+			Argv:      append([]string(nil), l.cmd.Args...),
+			StartedAt: l.startedAt,
+			Uptime:    now.Sub(l.startedAt).Seconds(),
+		}
+		if l.cmd.Process != nil {
+			info.PID = l.cmd.Process.Pid
+		}
+		d.Processes = append(d.Processes, info)
+	}
+	return d
+}
+
+// recordExitLocked notes how a listener's process ended (must be called with
+// lock held, after the process was waited for).
+func (m *Manager) recordExitLocked(l *listener) {
+	state := l.cmd.ProcessState
+	if state == nil {
+		return
+	}
+	m.lastExit = &models.ProcessExit{
+		Port:     l.port,
+		PID:      state.Pid(),
+		ExitCode: state.ExitCode(),
+		State:    state.String(),
+		At:       time.Now(),
+	}
+}
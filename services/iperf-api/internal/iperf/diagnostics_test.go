@@ -0,0 +1,88 @@
+package iperf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestManager_Diagnostics(t *testing.T) {
+	bin := fakeIperf(t, "exec sleep 30\n")
+	m := NewManager(nil, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.PortCount = 2
+
+	if d := m.Diagnostics(); len(d.Processes) != 0 || d.LastExit != nil {
+		t.Errorf("diagnostics before start = %+v, want none", d)
+	}
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	d := m.Diagnostics()
+	if len(d.Processes) != 2 {
+		t.Fatalf("processes = %+v, want one per port", d.Processes)
+	}
+	p := d.Processes[1]
+	if p.Port != cfg.Port+1 || p.PID <= 0 || p.Path != bin || p.Uptime < 0 || p.StartedAt.IsZero() {
+		t.Errorf("process = %+v, want a running process on port %d", p, cfg.Port+1)
+	}
+	if argv := strings.Join(p.Argv, " "); !strings.HasPrefix(argv, bin+" ") || !strings.Contains(argv, "-p 5202") {
+		t.Errorf("argv = %q, want the binary with -p 5202", argv)
+	}
+
+	m.Stop()
+	if !m.WaitExited(5 * time.Second) {
+		t.Fatal("processes did not exit")
+	}
+	d = m.Diagnostics()
+	if len(d.Processes) != 0 {
+		t.Errorf("processes after stop = %+v, want none", d.Processes)
+	}
+	// Stopping asks the process to exit
+	if d.LastExit == nil || d.LastExit.ExitCode != -1 || d.LastExit.State != "signal: terminated" || d.LastExit.PID <= 0 {
+		t.Errorf("last exit = %+v, want terminated by a signal", d.LastExit)
+	}
+}
+
+func TestManager_DiagnosticsExitCode(t *testing.T) {
+	bin := fakeIperf(t, "exit 3\n")
+	m := NewManager(nil, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	m.WaitExited(5 * time.Second)
+
+	waitUntil(t, "exit recorded", func() bool { return m.Diagnostics().LastExit != nil })
+	if exit := m.Diagnostics().LastExit; exit.ExitCode != 3 || exit.State != "exit status 3" || exit.Port != cfg.Port {
+		t.Errorf("last exit = %+v, want exit status 3 on port %d", exit, cfg.Port)
+	}
+}
+
+func TestManager_DiagnosticsCountsRestarts(t *testing.T) {
+	bin, count := crashingIperf(t, 2)
+	m := NewManager(nil, WithBinaryPath(bin), WithRestartPolicy(RestartPolicy{
+		MaxRetries: 3,
+		Backoff:    10 * time.Millisecond,
+		ResetAfter: time.Hour,
+	}))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	waitUntil(t, "two restarts", func() bool { return launches(t, count) == "3" && m.GetStatus() == models.ServerStatusRunning })
+	if d := m.Diagnostics(); d.Restarts != 2 || len(d.Processes) != 1 {
+		t.Errorf("diagnostics = %+v, want 2 restarts and one process", d)
+	}
+}
@@ -0,0 +1,200 @@
+package iperf
+
+import (
+	"net"
+	"os/exec"
+	"strconv"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Checks made by DryRun
+const (
+	CheckConfig = "config"
+	CheckState  = "state"
+	CheckBinary = "binary"
+	CheckPort   = "port"
+)
+
+// DryRunCheck is the outcome of one check; Err is nil when it passed.
+// Detail is the resolved binary path, the address probed or the allowlist
+// entry checked.
+type DryRunCheck struct {
+	Name string
+	Port int
+	// Field is the config field a config or allowlist check is about
+	Field  string
+	Detail string
+	// Skipped marks a port the running server holds, which a queued job
+	// gets once the server stops
+	Skipped bool
+	Err     error
+	// Warning flags a likely mistake that does not fail the check
+	Warning error
+}
+
+// DryRunPlan is what Start would do with a config.
+type DryRunPlan struct {
+	Checks   []DryRunCheck
+	Commands []models.DryRunCommand
+}
+
+// OK reports whether every check passed.
+func (p DryRunPlan) OK() bool {
+	for _, c := range p.Checks {
+		if c.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// command returns the binary and arguments that serve cfg on port.
+func (m *Manager) command(cfg models.ServerConfig, port int) (string, []string) {
+	binary, args, _ := m.commandFormat(cfg, port)
+	return binary, args
+}
+
+// commandFormat is command, also returning the output format the arguments
+// select.
+func (m *Manager) commandFormat(cfg models.ServerConfig, port int) (string, []string, outputFormat) {
+	binary := m.binaryPath
+	if cfg.Version == models.IperfVersion2 {
+		binary = m.iperf2Path
+	}
+	cfg.Port = port
+	args := BuildArgs(cfg)
+	if m.debug && cfg.Version != models.IperfVersion2 {
+		args = append(args, "--debug")
+	}
+	if m.usesSimulator(cfg) {
+		binary, args = m.simulator.Binary, m.simulator.simulatorArgs(args)
+	}
+	format := m.outputFormat(cfg, binary)
+	return binary, append(args, format.args()...), format
+}
+
+// DryRun makes the checks Start would, plus binary and port availability
+// checks, and returns the exact commands Start would run without launching
+// anything. queued skips the running-server check for queued jobs, which
+// wait for the server; ports the running server holds are not probed then.
+// Without queued, ports are not probed while the server runs.
+func (m *Manager) DryRun(cfg models.ServerConfig, queued bool) DryRunPlan {
+	var plan DryRunPlan
+
+	errs := ValidateConfig(cfg)
+	plan.Checks = configChecks(errs)
+	if len(errs) > 0 {
+		return plan
+	}
+
+	running, held := m.heldPorts()
+
+	if !queued {
+		check := DryRunCheck{Name: CheckState}
+		if running {
+			check.Err = ErrAlreadyRunning
+		}
+		plan.Checks = append(plan.Checks, check)
+	}
+
+	binary, _ := m.command(cfg, cfg.Port)
+	path, err := exec.LookPath(binary)
+	check := DryRunCheck{Name: CheckBinary, Detail: path}
+	if err != nil {
+		check.Err = i18n.NewError("dryrun.binary_not_found", i18n.Params{"binary": binary, "error": err})
+		path = binary
+	}
+	plan.Checks = append(plan.Checks, check)
+
+	for _, port := range cfg.Ports() {
+		_, args := m.command(cfg, port)
+		plan.Commands = append(plan.Commands, models.DryRunCommand{
+			Port: port,
+			Argv: append([]string{path}, args...),
+		})
+		if running && !queued {
+			// Start would fail before binding any port
+			continue
+		}
+
+		plan.Checks = append(plan.Checks, portCheck(cfg, port, held))
+	}
+	return plan
+}
+
+// configChecks returns a failed config check for each validation error, or
+// one passing check if there are none.
+func configChecks(errs []ValidationError) []DryRunCheck {
+	if len(errs) == 0 {
+		return []DryRunCheck{{Name: CheckConfig}}
+	}
+	checks := make([]DryRunCheck, 0, len(errs))
+	for _, err := range errs {
+		checks = append(checks, DryRunCheck{Name: CheckConfig, Field: err.Field, Err: err})
+	}
+	return checks
+}
+
+// heldPorts reports whether the server is running and the ports it holds.
+func (m *Manager) heldPorts() (bool, map[int]bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	running := m.status == models.ServerStatusRunning
+	held := make(map[int]bool)
+	if running {
+		for _, p := range m.config.Ports() {
+			held[p] = true
+		}
+	}
+	return running, held
+}
+
+// portCheck probes port unless the running server holds it.
+func portCheck(cfg models.ServerConfig, port int, held map[int]bool) DryRunCheck {
+	check := DryRunCheck{Name: CheckPort, Port: port, Detail: listenAddr(cfg, port)}
+	if held[port] {
+		check.Skipped = true
+	} else {
+		check.Err = probePort(check.Detail, cfg.Protocol, cfg.AddressFamily)
+	}
+	return check
+}
+
+// listenAddr returns the address iperf binds for port.
+func listenAddr(cfg models.ServerConfig, port int) string {
+	host := cfg.BindAddress
+	if host == "0.0.0.0" {
+		host = ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// probePort binds addr and releases it again, reporting an error if it is in
+// use or cannot be bound.
+func probePort(addr string, protocol models.Protocol, family models.AddressFamily) error {
+	suffix := ""
+	switch family {
+	case models.AddressFamilyIPv4:
+		suffix = "4"
+	case models.AddressFamilyIPv6:
+		suffix = "6"
+	}
+
+	ln, err := net.Listen("tcp"+suffix, addr)
+	if err != nil {
+		return i18n.NewError("dryrun.port_unavailable", i18n.Params{"address": addr, "error": err})
+	}
+	ln.Close()
+
+	// UDP tests send data to the same port
+	if protocol == models.ProtocolUDP {
+		pc, err := net.ListenPacket("udp"+suffix, addr)
+		if err != nil {
+			return i18n.NewError("dryrun.port_unavailable", i18n.Params{"address": addr, "error": err})
+		}
+		pc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package iperf
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// freePort returns a TCP port that is free on the loopback address.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// checks reports, for each check name in a plan, whether all such checks passed.
+func checks(plan DryRunPlan) map[string]bool {
+	got := make(map[string]bool)
+	for _, c := range plan.Checks {
+		if passed, seen := got[c.Name]; !seen || passed {
+			got[c.Name] = c.Err == nil
+		}
+	}
+	return got
+}
+
+func TestManager_DryRun(t *testing.T) {
+	bin := fakeIperf(t, "exec sleep 30\n")
+	m := NewManager(nil, WithBinaryPath(bin), WithCollisionDetection(true))
+	cfg := models.DefaultServerConfig()
+	cfg.BindAddress = "127.0.0.1"
+	cfg.Port = freePort(t)
+
+	plan := m.DryRun(cfg, false)
+	if !plan.OK() {
+		t.Fatalf("plan = %+v, want every check to pass", plan)
+	}
+	want := map[string]bool{CheckConfig: true, CheckState: true, CheckBinary: true, CheckPort: true}
+	if got := checks(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %v, want %v", got, want)
+	}
+	if len(plan.Commands) != 1 {
+		t.Fatalf("commands = %+v, want one", plan.Commands)
+	}
+	// Collision detection adds --debug to what BuildArgs returns
+	_, args := m.command(cfg, cfg.Port)
+	if argv := plan.Commands[0].Argv; argv[0] != bin || !reflect.DeepEqual(argv[1:], args) || argv[len(argv)-1] != "--debug" {
+		t.Errorf("argv = %v, want %s %v", argv, bin, args)
+	}
+	if m.GetStatus() != models.ServerStatusStopped {
+		t.Error("dry run changed the server status")
+	}
+
+	// A port in use fails its check
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cfg.Port = ln.Addr().(*net.TCPAddr).Port
+	if plan := m.DryRun(cfg, false); plan.OK() || checks(plan)[CheckPort] {
+		t.Errorf("plan with a busy port = %+v, want the port check to fail", plan.Checks)
+	}
+}
+
+func TestManager_DryRunInvalid(t *testing.T) {
+	m := NewManager(nil, WithBinaryPath("/nonexistent/iperf3"))
+
+	cfg := models.DefaultServerConfig()
+	cfg.Port = 0
+	cfg.IdleTimeout = -1
+	plan := m.DryRun(cfg, false)
+	if len(plan.Checks) != 2 || len(plan.Commands) != 0 {
+		t.Errorf("plan = %+v, want two config errors and no commands", plan)
+	}
+
+	cfg = models.DefaultServerConfig()
+	cfg.Port = freePort(t)
+	plan = m.DryRun(cfg, false)
+	if checks(plan)[CheckBinary] || plan.OK() {
+		t.Errorf("checks = %+v, want the binary check to fail", plan.Checks)
+	}
+	if argv := plan.Commands[0].Argv; argv[0] != "/nonexistent/iperf3" {
+		t.Errorf("argv = %v, want the configured binary", argv)
+	}
+}
+
+func TestManager_DryRunWhileRunning(t *testing.T) {
+	bin := fakeIperf(t, "exec sleep 30\n")
+	m := NewManager(nil, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.Port = freePort(t)
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer func() {
+		m.Stop()
+		m.WaitExited(5 * time.Second)
+	}()
+
+	plan := m.DryRun(cfg, false)
+	if got := checks(plan); got[CheckState] || len(plan.Commands) != 1 {
+		t.Errorf("plan = %+v, want a failed state check", plan)
+	}
+	for _, c := range plan.Checks {
+		if c.Name == CheckPort {
+			t.Errorf("port %d probed though start would fail first", c.Port)
+		}
+	}
+
+	// A queued job waits for the server, so its ports are not probed
+	plan = m.DryRun(cfg, true)
+	if !plan.OK() {
+		t.Fatalf("queued plan = %+v, want it to pass", plan.Checks)
+	}
+	last := plan.Checks[len(plan.Checks)-1]
+	if last.Name != CheckPort || !last.Skipped {
+		t.Errorf("port check = %+v, want it skipped", last)
+	}
+}
+
+func TestManager_CommandSimulator(t *testing.T) {
+	m := NewManager(nil, WithSimulator(SimulatorConfig{
+		Binary:   "/opt/iperf3sim",
+		Scenario: "tcp,udp",
+		Interval: 100 * time.Millisecond,
+	}))
+	cfg := models.DefaultServerConfig()
+	binary, args := m.command(cfg, 5202)
+	want := append(BuildArgs(cfg)[:3], "-p", "5202", "--sim-scenario", "tcp,udp", "--sim-interval", "100ms")
+	if binary != "/opt/iperf3sim" || !reflect.DeepEqual(args, want) {
+		t.Errorf("command = %s %v, want /opt/iperf3sim %v", binary, args, want)
+	}
+
+	// iperf2 servers still run iperf
+	cfg.Version = models.IperfVersion2
+	if binary, _ := m.command(cfg, 5202); binary != "iperf" {
+		t.Errorf("iperf2 binary = %s, want iperf", binary)
+	}
+}
+
+func TestManager_CommandOutputMode(t *testing.T) {
+	// The fake counts how often it is asked its version
+	versionOf := func(version string) (string, string) {
+		asked := filepath.Join(t.TempDir(), "asked")
+		return fakeIperf(t, fmt.Sprintf("[ \"$1\" = -v ] && echo x >> %s && echo 'iperf %s (cJSON 1.7.15)'\n", asked, version)), asked
+	}
+	current, asked := versionOf("3.17.1")
+	old, _ := versionOf("3.12")
+
+	tests := []struct {
+		binary   string
+		fallback models.OutputMode
+		mode     models.OutputMode
+		version  models.IperfVersion
+		want     []string
+		format   outputFormat
+	}{
+		{current, "", "", models.IperfVersion3, nil, formatText},
+		{current, "", models.OutputModeJSON, models.IperfVersion3, []string{"-J", "--json-stream"}, formatJSONStream},
+		{old, "", models.OutputModeJSON, models.IperfVersion3, []string{"-J"}, formatJSON},
+		{current, models.OutputModeAuto, "", models.IperfVersion3, []string{"-J", "--json-stream"}, formatJSONStream},
+		{old, models.OutputModeAuto, "", models.IperfVersion3, nil, formatText},
+		{current, models.OutputModeJSON, models.OutputModeText, models.IperfVersion3, nil, formatText},
+		{"/nonexistent/iperf3", models.OutputModeAuto, "", models.IperfVersion3, nil, formatText},
+		{current, models.OutputModeAuto, "", models.IperfVersion2, nil, formatText},
+	}
+	for _, tt := range tests {
+		m := NewManager(nil, WithBinaryPath(tt.binary), WithOutputMode(tt.fallback))
+		cfg := models.DefaultServerConfig()
+		cfg.OutputMode = tt.mode
+		cfg.Version = tt.version
+		_, args, format := m.commandFormat(cfg, cfg.Port)
+		want := append(BuildArgs(cfg), tt.want...)
+		if format != tt.format || !reflect.DeepEqual(args, want) {
+			t.Errorf("%s %q/%q: args %v (format %d), want %v (format %d)", filepath.Base(filepath.Dir(tt.binary)), tt.fallback, tt.mode, args, format, want, tt.format)
+		}
+	}
+
+	// Text and iperf2 servers never ask, and a manager asks each binary
+	// once: two managers above asked, and this one asks for its first call
+	m := NewManager(nil, WithBinaryPath(current), WithOutputMode(models.OutputModeAuto))
+	for i := 0; i < 3; i++ {
+		m.command(models.DefaultServerConfig(), 5201)
+	}
+	probes, _ := os.ReadFile(asked)
+	if got := strings.Count(string(probes), "x"); got != 3 {
+		t.Errorf("version asked %d times, want 3", got)
+	}
+}
+
+func TestManager_Binary(t *testing.T) {
+	iperf3 := fakeIperf(t, "[ \"$1\" = -v ] && echo 'iperf 3.17.1 (cJSON 1.7.15)'\n")
+	// iperf2 reports its version on stderr
+	iperf2 := fakeIperf(t, "[ \"$1\" = -v ] && echo 'iperf version 2.1.9 (14 March 2023) pthreads' >&2\n")
+	m := NewManager(nil, WithBinaryPath(iperf3), WithIperf2BinaryPath(iperf2), WithOutputMode(models.OutputModeAuto))
+
+	cfg := models.DefaultServerConfig()
+	want := models.IperfBinaryInfo{Path: iperf3, Version: "3.17.1", OutputMode: models.OutputModeAuto, Parser: models.IperfParserJSONStream}
+	if got := m.binaryInfo(cfg); got != want {
+		t.Errorf("auto = %+v, want %+v", got, want)
+	}
+	cfg.OutputMode = models.OutputModeText
+	want.OutputMode, want.Parser = models.OutputModeText, models.IperfParserText
+	if got := m.binaryInfo(cfg); got != want {
+		t.Errorf("text = %+v, want %+v", got, want)
+	}
+
+	cfg = models.DefaultServerConfig()
+	cfg.Version = models.IperfVersion2
+	want = models.IperfBinaryInfo{Path: iperf2, Version: "2.1.9", OutputMode: models.OutputModeText, Parser: models.IperfParserIperf2}
+	if got := m.binaryInfo(cfg); got != want {
+		t.Errorf("iperf2 = %+v, want %+v", got, want)
+	}
+
+	// A binary that cannot be asked has no version
+	m = NewManager(nil, WithBinaryPath("/nonexistent/iperf3"))
+	if got := m.Binary(); got.Path != "/nonexistent/iperf3" || got.Version != "" || got.Parser != models.IperfParserText {
+		t.Errorf("missing = %+v", got)
+	}
+}
@@ -0,0 +1,156 @@
+package iperf
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Fields of the verbose "Starting Test:" line, which differs for time, byte
+// and block limited tests:
+// "Starting Test: protocol: TCP, 4 streams, 131072 byte blocks, omitting 0 seconds, 10 second test, tos 0"
+// "Starting Test: protocol: UDP, 1 streams, 1460 byte blocks, omitting 0 seconds, 1048576 bytes to send, tos 0"
+var (
+	reStartProtocol = regexp.MustCompile(`protocol: (\w+)`)
+	reStartStreams  = regexp.MustCompile(`(\d+) streams`)
+	reStartBlkSize  = regexp.MustCompile(`(\d+) byte blocks`)
+	reStartOmit     = regexp.MustCompile(`omitting (\d+) seconds`)
+	reStartDuration = regexp.MustCompile(`(\d+) second test`)
+	reStartBytes    = regexp.MustCompile(`(\d+) bytes to send`)
+	reStartBlocks   = regexp.MustCompile(`(\d+) blocks to send`)
+	reStartTOS      = regexp.MustCompile(`tos (\d+)`)
+)
+
+// clientFeatures are the control-connection parameters the client only sends
+// when the option is enabled, reported by name in a fingerprint.
+var clientFeatures = []string{
+	"bidirectional",
+	"dont_fragment",
+	"get_server_output",
+	"nodelay",
+	"repeating_payload",
+	"reverse",
+	"udp_counters_64bit",
+}
+
+// fingerprint returns the session's client fingerprint, creating it if needed.
+func (s *sessionState) fingerprint() *models.ClientFingerprint {
+	if s.client == nil {
+		s.client = &models.ClientFingerprint{}
+	}
+	return s.client
+}
+
+// parseStartingTest records the test parameters from a "Starting Test:" line.
+func (s *sessionState) parseStartingTest(line string) {
+	fp := s.fingerprint()
+	if m := reStartProtocol.FindStringSubmatch(line); m != nil {
+		fp.Protocol = models.Protocol(strings.ToLower(m[1]))
+	}
+	fp.Streams = atoiMatch(reStartStreams, line, fp.Streams)
+	fp.BlockSize = atoiMatch(reStartBlkSize, line, fp.BlockSize)
+	fp.Omit = atoiMatch(reStartOmit, line, fp.Omit)
+	fp.Duration = atoiMatch(reStartDuration, line, fp.Duration)
+	fp.TOS = atoiMatch(reStartTOS, line, fp.TOS)
+	if m := reStartBytes.FindStringSubmatch(line); m != nil {
+		fp.Bytes, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	if m := reStartBlocks.FindStringSubmatch(line); m != nil {
+		fp.Blocks, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+
+	if fp.Duration > 0 {
+		s.requested = float64(fp.Duration)
+	}
+}
+
+// atoiMatch returns the first submatch of re in line as an int, or def.
+func atoiMatch(re *regexp.Regexp, line string, def int) int {
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		return def
+	}
+	v, err := strconv.Atoi(m[1])
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// parseClientParameters records the parameters JSON the client sent on the
+// control connection, which iperf3 prints in --debug mode. It is the only
+// source of the client's version and optional features.
+func (s *sessionState) parseClientParameters(data string) {
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &params); err != nil {
+		return
+	}
+
+	fp := s.fingerprint()
+	if v, ok := params["client_version"].(string); ok {
+		fp.Version = v
+	}
+	for _, proto := range []models.Protocol{models.ProtocolTCP, models.ProtocolUDP} {
+		if truthy(params[string(proto)]) {
+			fp.Protocol = proto
+		}
+	}
+	if v := number(params["parallel"]); v > 0 {
+		fp.Streams = int(v)
+	}
+	if v := number(params["len"]); v > 0 {
+		fp.BlockSize = int(v)
+	}
+	if v := number(params["time"]); v > 0 {
+		fp.Duration = int(v)
+		s.requested = v
+	}
+	fp.Omit = int(number(params["omit"]))
+	fp.Bytes = int64(number(params["num"]))
+	fp.Blocks = int64(number(params["blockcount"]))
+	fp.TOS = int(number(params["TOS"]))
+	fp.Window = int(number(params["window"]))
+	fp.Bandwidth = int64(number(params["bandwidth"]))
+	if v := number(params["MSS"]); v > 0 {
+		fp.MSS = int(v)
+	}
+	if v, ok := params["congestion"].(string); ok {
+		fp.Congestion = v
+	}
+
+	fp.Features = nil
+	for _, name := range clientFeatures {
+		if truthy(params[name]) {
+			fp.Features = append(fp.Features, name)
+		}
+	}
+}
+
+// truthy reports whether a JSON parameter is set; iperf3 sends flags as
+// true or as 1 depending on the option.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// number returns a numeric JSON parameter, or 0.
+func number(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
+
+// setClient copies the client fingerprint onto a result, if one was captured.
+func (s *sessionState) setClient(result *models.TestResult) {
+	if s.client != nil {
+		client := *s.client
+		result.Client = &client
+	}
+}
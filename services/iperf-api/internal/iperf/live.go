@@ -0,0 +1,78 @@
+package iperf
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// liveWindow is how far back the live throughput average looks
+const liveWindow = 10 * time.Second
+
+// liveGauge keeps the latest bandwidth update and the intervals reported
+// within liveWindow, across all ports
+type liveGauge struct {
+	mu        sync.Mutex
+	latest    *models.LiveUpdate
+	intervals []liveInterval
+}
+
+// liveInterval is one reported interval: when it arrived, the bits moved and
+// the seconds it covered
+type liveInterval struct {
+	at      time.Time
+	bits    float64
+	seconds float64
+}
+
+// record notes a bandwidth update from clientIP received at now. Omitted
+// intervals update the latest value but not the average.
+func (g *liveGauge) record(u models.BandwidthUpdate, clientIP string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.latest = &models.LiveUpdate{BandwidthUpdate: u, ClientIP: clientIP}
+	g.pruneLocked(now)
+	if seconds := u.IntervalEnd - u.IntervalStart; !u.Omitted && seconds > 0 {
+		g.intervals = append(g.intervals, liveInterval{at: now, bits: float64(u.Bytes) * 8, seconds: seconds})
+	}
+}
+
+// pruneLocked drops intervals older than the window (must be called with
+// lock held).
+func (g *liveGauge) pruneLocked(now time.Time) {
+	cutoff := now.Add(-liveWindow)
+	i := 0
+	for i < len(g.intervals) && !g.intervals[i].at.After(cutoff) {
+		i++
+	}
+	g.intervals = g.intervals[i:]
+}
+
+// snapshot returns the gauge as of now
+func (g *liveGauge) snapshot(now time.Time) models.LiveThroughput {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked(now)
+	live := models.LiveThroughput{WindowSeconds: liveWindow.Seconds()}
+	if g.latest != nil {
+		latest := *g.latest
+		live.Latest = &latest
+	}
+	var bits, seconds float64
+	for _, iv := range g.intervals {
+		bits += iv.bits
+		seconds += iv.seconds
+	}
+	if seconds > 0 {
+		live.AverageBitsPerSecond = bits / seconds
+	}
+	return live
+}
+
+// Live returns the most recent bandwidth update of any test and the average
+// throughput over the last ten seconds. The latest update is kept after its
+// test ends; the average falls to zero once no intervals arrive.
+func (m *Manager) Live() models.LiveThroughput {
+	return m.live.snapshot(time.Now())
+}
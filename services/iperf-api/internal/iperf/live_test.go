@@ -0,0 +1,74 @@
+package iperf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestLiveGauge(t *testing.T) {
+	var g liveGauge
+	now := time.Now()
+
+	if live := g.snapshot(now); live.Latest != nil || live.AverageBitsPerSecond != 0 || live.WindowSeconds != 10 {
+		t.Fatalf("empty gauge = %+v", live)
+	}
+
+	interval := func(start, end float64, bytes int64, omitted bool) models.BandwidthUpdate {
+		return models.BandwidthUpdate{
+			SessionID: "s1", IntervalStart: start, IntervalEnd: end,
+			Bytes: bytes, BitsPerSecond: float64(bytes) * 8 / (end - start), Omitted: omitted,
+		}
+	}
+	// An omitted interval is the latest value but not part of the average
+	g.record(interval(0, 1, 1e9, true), "10.0.0.1", now)
+	g.record(interval(0, 1, 100e6, false), "10.0.0.1", now.Add(time.Second))
+	g.record(interval(1, 1.5, 100e6, false), "10.0.0.1", now.Add(1500*time.Millisecond))
+
+	live := g.snapshot(now.Add(2 * time.Second))
+	if live.Latest == nil || live.Latest.ClientIP != "10.0.0.1" || live.Latest.IntervalEnd != 1.5 {
+		t.Errorf("latest = %+v", live.Latest)
+	}
+	// 1600 Mbit over 1.5 s, weighted by interval length
+	if want := 1600e6 / 1.5; live.AverageBitsPerSecond != want {
+		t.Errorf("average = %v, want %v", live.AverageBitsPerSecond, want)
+	}
+
+	// Intervals leave the window; the latest update stays
+	live = g.snapshot(now.Add(11 * time.Second))
+	if want := 800e6 / 0.5; live.AverageBitsPerSecond != want {
+		t.Errorf("average after first interval left the window = %v, want %v", live.AverageBitsPerSecond, want)
+	}
+	live = g.snapshot(now.Add(12 * time.Second))
+	if live.AverageBitsPerSecond != 0 || live.Latest == nil {
+		t.Errorf("gauge after the window passed = %+v", live)
+	}
+}
+
+func TestManager_Live(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "Server listening on 5201"
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec"
+exec sleep 5
+`)
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+	rec.waitFor(t, models.WSMessageTypeBandwidthUpdate)
+
+	live := m.Live()
+	if live.Latest == nil || live.Latest.ClientIP != "10.0.0.1" || live.Latest.ServerPort != 5201 || live.Latest.SessionID == "" {
+		t.Fatalf("latest = %+v", live.Latest)
+	}
+	if live.AverageBitsPerSecond != 100*1024*1024*8 {
+		t.Errorf("average = %v, want %v", live.AverageBitsPerSecond, 100*1024*1024*8)
+	}
+}
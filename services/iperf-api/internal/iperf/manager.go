@@ -2,38 +2,211 @@ package iperf
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
 	"github.com/Tom-Oram/fak/backend/internal/models"
 )
 
+// Errors returned for a start or stop that conflicts with the server's state
+var (
+	ErrAlreadyRunning = i18n.NewError("server.already_running", nil)
+	ErrNotRunning     = i18n.NewError("server.not_running", nil)
+)
+
 // EventHandler is a callback function that handles WebSocket messages
 type EventHandler func(models.WSMessage)
 
-// Manager manages the iperf3 server process
+// ProcessManager runs the iperf server for the API. Manager implements it;
+// tests substitute a fake so handlers run without an iperf binary.
+type ProcessManager interface {
+	Start(cfg models.ServerConfig) error
+	Stop() error
+	GetStatus() models.ServerStatus
+	GetConfig() models.ServerConfig
+	WaitExited(timeout time.Duration) bool
+	Ports() []models.PortStatus
+	Live() models.LiveThroughput
+	Diagnostics() *models.ProcessDiagnostics
+	Supervisor() *models.SupervisorStatus
+	DryRun(cfg models.ServerConfig, queued bool) DryRunPlan
+	Validate(cfg models.ServerConfig) DryRunPlan
+	Reparse(output []byte) *models.TestResult
+	Binary() models.IperfBinaryInfo
+}
+
+var _ ProcessManager = (*Manager)(nil)
+
+// Manager manages the iperf3 server processes, one per listening port
 type Manager struct {
 	mu           sync.RWMutex
-	cmd          *exec.Cmd
+	listeners    []*listener
 	cancel       context.CancelFunc
+	runCtx       context.Context
 	config       models.ServerConfig
 	status       models.ServerStatus
 	eventHandler EventHandler
 	idleTimer    *time.Timer
+	binaryPath   string
+	iperf2Path   string
+	watchdog     WatchdogConfig
+	simulator    *SimulatorConfig
+	outputMode   models.OutputMode
+	debug        bool
+	newID        ids.Generator
+	warmup       float64
+	rawOutput    RawOutputHandler
+	live         liveGauge
+	smoothing    int
+	stopGrace    time.Duration
+
+	restartPolicy RestartPolicy
+	supervisor    supervisorState
+	restarts      int
+	lastExit      *models.ProcessExit
+	slot          int
+
+	// versions caches the version each iperf binary reports
+	versionsMu sync.Mutex
+	versions   map[string]binaryVersion
+}
+
+// listener is the iperf process serving one port of a running server
+type listener struct {
+	port      int
+	cmd       *exec.Cmd
+	sp        *sessionParser
+	exited    chan struct{}
+	startedAt time.Time
+	// lastOutput is guarded by Manager.mu
+	lastOutput time.Time
+	// stopping is set once iperf has been asked to stop, after which it
+	// reports the test it cut short
+	stopping atomic.Bool
+}
+
+// ManagerOption configures optional Manager behaviour
+type ManagerOption func(*Manager)
+
+// WithBinaryPath sets the iperf3 executable to launch (default "iperf3" on PATH)
+func WithBinaryPath(path string) ManagerOption {
+	return func(m *Manager) {
+		if path != "" {
+			m.binaryPath = path
+		}
+	}
+}
+
+// WithIperf2BinaryPath sets the classic iperf executable used for ServerConfig.Version "iperf2" (default "iperf" on PATH)
+func WithIperf2BinaryPath(path string) ManagerOption {
+	return func(m *Manager) {
+		if path != "" {
+			m.iperf2Path = path
+		}
+	}
+}
+
+// WithClientParameters runs iperf3 with --debug so the parameters each client
+// sends, including its version and optional features, are captured. Debug
+// output is verbose; iperf2 servers are unaffected.
+func WithClientParameters(enabled bool) ManagerOption {
+	return func(m *Manager) {
+		m.debug = m.debug || enabled
+	}
+}
+
+// WithIDGenerator sets how test session IDs, which become result IDs, are
+// generated (default random UUIDs)
+func WithIDGenerator(gen ids.Generator) ManagerOption {
+	return func(m *Manager) {
+		m.newID = gen
+	}
+}
+
+// WithWarmup leaves intervals starting in the first seconds of each test,
+// after any iperf3 omitted, out of the result's minimum, maximum and
+// average bandwidth (default 0, none)
+func WithWarmup(seconds float64) ManagerOption {
+	return func(m *Manager) {
+		m.warmup = seconds
+	}
+}
+
+// WithSmoothing adds to each bandwidth update an exponentially weighted
+// moving average of its test's bitrate over about window intervals, so
+// graphs of bursty tests stay readable. The raw bitrate is kept. A window of
+// 1 or less disables smoothing (default).
+func WithSmoothing(window int) ManagerOption {
+	return func(m *Manager) {
+		m.smoothing = window
+	}
+}
+
+// DefaultStopGrace is how long a stopped iperf has to report the test in
+// progress before it is killed
+const DefaultStopGrace = 2 * time.Second
+
+// WithStopGrace sets how long iperf has to exit after being asked to stop,
+// reporting the test in progress, before it is killed. Zero kills it at
+// once, losing the test's summary (default DefaultStopGrace).
+func WithStopGrace(grace time.Duration) ManagerOption {
+	return func(m *Manager) {
+		if grace >= 0 {
+			m.stopGrace = grace
+		}
+	}
+}
+
+// RawOutputHandler receives the server output a result was parsed from,
+// once the result has been sent
+type RawOutputHandler func(resultID string, output []byte)
+
+// maxRawOutput caps the output kept for one result; later lines are dropped
+const maxRawOutput = 4 << 20
+
+// WithRawOutput captures the output of each test session, from the end of
+// the previous session to the line that completed its result, and passes it
+// to handler so parsing can be checked or repeated later
+func WithRawOutput(handler RawOutputHandler) ManagerOption {
+	return func(m *Manager) {
+		m.rawOutput = handler
+	}
+}
+
+// WithCollisionDetection runs iperf3 with --debug so connections turned away
+// while a test is running are reported as collisions. iperf3 only logs these
+// rejections in debug output; iperf2 servers are unaffected.
+func WithCollisionDetection(enabled bool) ManagerOption {
+	return func(m *Manager) {
+		m.debug = m.debug || enabled
+	}
 }
 
 // NewManager creates a new Manager with the given event handler
-func NewManager(handler EventHandler) *Manager {
-	return &Manager{
+func NewManager(handler EventHandler, opts ...ManagerOption) *Manager {
+	m := &Manager{
 		status:       models.ServerStatusStopped,
 		config:       models.DefaultServerConfig(),
 		eventHandler: handler,
+		binaryPath:   "iperf3",
+		iperf2Path:   "iperf",
+		outputMode:   models.OutputModeText,
+		stopGrace:    DefaultStopGrace,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 // GetStatus returns the current server status
@@ -50,72 +223,146 @@ func (m *Manager) GetConfig() models.ServerConfig {
 	return m.config
 }
 
-// Start starts the iperf3 server with the given configuration
+// Start starts the iperf3 server with the given configuration, running one
+// process per port of the pool. Starting closes the restart circuit and
+// cancels any pending automatic restart.
 func (m *Manager) Start(cfg models.ServerConfig) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if err := m.startLocked(cfg); err != nil {
+		return err
+	}
+	m.resetSupervisorLocked()
+	return nil
+}
+
+// startLocked starts the server (must be called with lock held).
+func (m *Manager) startLocked(cfg models.ServerConfig) error {
 	// Check not already running
 	if m.status == models.ServerStatusRunning {
-		return fmt.Errorf("server is already running")
+		return ErrAlreadyRunning
 	}
 
-	// Validate config (return first error)
-	if errors := ValidateConfig(cfg); len(errors) > 0 {
-		return errors[0]
+	if errs := ValidateConfig(cfg); len(errs) > 0 {
+		return ConfigErrors(errs)
 	}
 
 	// Create context with cancel
 	ctx, cancel := context.WithCancel(context.Background())
 	m.cancel = cancel
-
-	// Build args and exec iperf3 with context
-	args := BuildArgs(cfg)
-	cmd := exec.CommandContext(ctx, "iperf3", args...)
-	m.cmd = cmd
+	m.runCtx = ctx
 	m.config = cfg
+	m.slot = 0
+
+	// Start a process per port; cancelling ctx stops any already started if
+	// a later one fails
+	var listeners []*listener
+	for _, port := range cfg.Ports() {
+		l, err := m.startListener(ctx, cfg, port)
+		if err != nil {
+			cancel()
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	m.listeners = listeners
+	m.supervisor.startedAt = time.Now()
+
+	// Set status to Running, send status update
+	m.status = models.ServerStatusRunning
+	m.sendStatusUpdateLocked()
+	if cfg.AutoRearm {
+		for _, l := range listeners {
+			m.sendSlotReadyLocked(l)
+		}
+	}
+
+	// Start watchdogs if configured
+	if m.watchdog.Timeout > 0 {
+		for _, l := range listeners {
+			go m.runWatchdog(ctx, l)
+		}
+	}
+
+	// Start idle timer if configured
+	if cfg.IdleTimeout > 0 {
+		m.idleTimer = time.AfterFunc(time.Duration(cfg.IdleTimeout)*time.Second, func() {
+			m.Stop()
+		})
+	}
+
+	return nil
+}
+
+// startListener launches the iperf process for one port along with the
+// goroutines that read its output and wait for it to exit. Called with the
+// lock held.
+func (m *Manager) startListener(ctx context.Context, cfg models.ServerConfig, port int) (*listener, error) {
+	// Pick the implementation and matching output parser
+	binary, args, format := m.commandFormat(cfg, port)
+	parser := newParser(cfg.Version, format, m.newID, m.warmup)
+
+	// Exec iperf with context
+	cmd := exec.CommandContext(ctx, binary, args...)
+	l := &listener{
+		port:   port,
+		cmd:    cmd,
+		sp:     &sessionParser{parser: parser},
+		exited: make(chan struct{}),
+	}
+
+	// Cancelling ctx asks iperf to stop, which makes it print the summary of
+	// a test in progress, and kills it if it has not exited within the grace
+	// period
+	if m.stopGrace > 0 {
+		cmd.Cancel = func() error {
+			l.stopping.Store(true)
+			return cmd.Process.Signal(syscall.SIGTERM)
+		}
+		cmd.WaitDelay = m.stopGrace
+	}
 
 	// Get stdout pipe
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		cancel()
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
 	}
 
 	// Get stderr pipe
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		cancel()
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
+		return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
 	}
 
 	// Start process
 	if err := cmd.Start(); err != nil {
-		cancel()
-		return fmt.Errorf("failed to start iperf3: %w", err)
+		return nil, fmt.Errorf("failed to start %s: %w", binary, err)
 	}
 
-	// Set status to Running, send status update
-	m.status = models.ServerStatusRunning
-	m.sendStatusUpdateLocked()
+	// stdout and stderr share one parser so errors reported on stderr can
+	// end the test session tracked from stdout
+	l.startedAt = time.Now()
+	l.lastOutput = l.startedAt
+	var readers sync.WaitGroup
+	readers.Add(2)
 
 	// Start parseOutput goroutine
-	go m.parseOutput(stdout)
+	go func() {
+		defer readers.Done()
+		m.parseOutput(stdout, l)
+	}()
 
 	// Start readStderr goroutine
-	go m.readStderr(stderr)
+	go func() {
+		defer readers.Done()
+		m.readStderr(stderr, l)
+	}()
 
 	// Start monitorProcess goroutine
-	go m.monitorProcess()
-
-	// Start idle timer if configured
-	if cfg.IdleTimeout > 0 {
-		m.idleTimer = time.AfterFunc(time.Duration(cfg.IdleTimeout)*time.Second, func() {
-			m.Stop()
-		})
-	}
+	go m.monitorProcess(l, &readers)
 
-	return nil
+	return l, nil
 }
 
 // Stop stops the iperf3 server
@@ -123,10 +370,17 @@ func (m *Manager) Stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Check is running
+	// Check is running; stopping a crashed server cancels its pending restart
 	if m.status != models.ServerStatusRunning {
-		return fmt.Errorf("server is not running")
+		if m.supervisor.timer == nil {
+			return ErrNotRunning
+		}
+		m.resetSupervisorLocked()
+		m.status = models.ServerStatusStopped
+		m.sendStatusUpdateLocked()
+		return nil
 	}
+	m.resetSupervisorLocked()
 
 	// Cancel context
 	if m.cancel != nil {
@@ -147,109 +401,386 @@ func (m *Manager) Stop() error {
 	return nil
 }
 
-// parseOutput reads iperf3 text output line-by-line and dispatches events.
-func (m *Manager) parseOutput(stdout io.ReadCloser) {
-	defer stdout.Close()
+// WaitExited blocks until the most recently started iperf processes have
+// exited or timeout elapses, and reports whether they exited. It returns true
+// at once if no process was started.
+func (m *Manager) WaitExited(timeout time.Duration) bool {
+	m.mu.RLock()
+	listeners := m.listeners
+	m.mu.RUnlock()
+
+	deadline := time.After(timeout)
+	for _, l := range listeners {
+		select {
+		case <-l.exited:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
 
-	parser := NewTextParser()
-	scanner := bufio.NewScanner(stdout)
+// Ports reports whether each listener of the running server is free or busy
+// with a test. It returns nil while the server is not running.
+func (m *Manager) Ports() []models.PortStatus {
+	m.mu.RLock()
+	running := m.status == models.ServerStatusRunning
+	listeners := m.listeners
+	m.mu.RUnlock()
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	if !running {
+		return nil
+	}
 
-		// Reset idle timer on any output
-		m.resetIdleTimer()
+	ports := make([]models.PortStatus, 0, len(listeners))
+	for _, l := range listeners {
+		l.sp.mu.Lock()
+		sessionID := l.sp.parser.SessionID()
+		clientIP := l.sp.parser.ClientIP()
+		// A session has an ID from the client connecting until its result
+		busy := sessionID != "" || l.sp.parser.InSession()
+		l.sp.mu.Unlock()
+
+		p := models.PortStatus{Port: l.port, State: models.PortStateFree}
+		if busy {
+			p.State = models.PortStateBusy
+			p.SessionID = sessionID
+			p.ClientIP = clientIP
+		}
+		ports = append(ports, p)
+	}
+	return ports
+}
 
-		result := parser.ParseLine(line)
+// ownsLocked reports whether l belongs to the most recently started server
+// (must be called with lock held).
+func (m *Manager) ownsLocked(l *listener) bool {
+	for _, current := range m.listeners {
+		if current == l {
+			return true
+		}
+	}
+	return false
+}
 
-		switch result.Event {
-		case EventClientConnected:
-			// Check allowlist
-			m.mu.RLock()
-			allowlist := m.config.Allowlist
-			m.mu.RUnlock()
+// sessionParser guards a LineParser shared by the stdout and stderr readers
+// of one process, and the output captured for its current session.
+type sessionParser struct {
+	mu     sync.Mutex
+	parser LineParser
+	raw    bytes.Buffer
+	// smoothed is the moving average of smoothedSession's bitrate
+	smoothed        float64
+	smoothedSession string
+}
 
-			if !IsClientAllowed(result.ConnectionEvent.ClientIP, allowlist) {
-				m.sendError(fmt.Sprintf("client %s not in allowlist", result.ConnectionEvent.ClientIP))
-				continue
-			}
+// smooth sets an update's moving average bitrate over about window
+// intervals, starting afresh with each session (must be called with mu
+// held). Omitted intervals are left out.
+func (sp *sessionParser) smooth(u *models.BandwidthUpdate, window int) {
+	if u.Omitted {
+		return
+	}
+	if u.SessionID != sp.smoothedSession {
+		sp.smoothedSession = u.SessionID
+		sp.smoothed = u.BitsPerSecond
+	} else {
+		alpha := 2 / float64(window+1)
+		sp.smoothed += alpha * (u.BitsPerSecond - sp.smoothed)
+	}
+	smoothed := sp.smoothed
+	u.SmoothedBitsPerSecond = &smoothed
+}
 
-			m.sendEvent(models.WSMessage{
-				Type:    models.WSMessageTypeClientConnected,
-				Payload: result.ConnectionEvent,
-			})
+// capture keeps a line of output for the session's raw output (must be
+// called with mu held).
+func (sp *sessionParser) capture(line string) {
+	if sp.raw.Len()+len(line) < maxRawOutput {
+		sp.raw.WriteString(line)
+		sp.raw.WriteByte('\n')
+	}
+}
 
-		case EventBandwidthUpdate:
-			m.sendEvent(models.WSMessage{
-				Type:    models.WSMessageTypeBandwidthUpdate,
-				Payload: result.BandwidthUpdate,
-			})
+// takeRaw returns and forgets the output captured since the last result
+// (must be called with mu held, or once the readers are done).
+func (sp *sessionParser) takeRaw() []byte {
+	raw := bytes.Clone(sp.raw.Bytes())
+	sp.raw.Reset()
+	return raw
+}
 
-		case EventTestComplete:
-			m.sendEvent(models.WSMessage{
-				Type:    models.WSMessageTypeTestComplete,
-				Payload: result.TestResult,
-			})
+// sendRawOutput passes a sent result's captured output to the raw output
+// handler, if there is one.
+func (m *Manager) sendRawOutput(l *listener, result *models.TestResult) {
+	if m.rawOutput == nil || result.ID == "" {
+		return
+	}
+	m.rawOutput(result.ID, l.sp.takeRaw())
+}
 
-		case EventError:
-			m.sendError(result.ErrorMessage)
-		}
+// parseOutput reads iperf3 text output line-by-line and dispatches events.
+func (m *Manager) parseOutput(stdout io.ReadCloser, l *listener) {
+	defer stdout.Close()
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Reset idle timer on any output
+		m.recordActivity(l)
+
+		m.handleLine(l, line)
 	}
 }
 
-// readStderr reads stderr lines and sends them as error messages.
-func (m *Manager) readStderr(stderr io.ReadCloser) {
+// readStderr reads stderr lines, dispatching recognised events and sending
+// everything else as error messages.
+func (m *Manager) readStderr(stderr io.ReadCloser, l *listener) {
 	defer stderr.Close()
 
 	scanner := bufio.NewScanner(stderr)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
+		if line == "" {
+			continue
+		}
+		m.recordActivity(l)
+		// Once iperf is asked to stop, stderr has its interrupt message,
+		// which would otherwise end the session before the summary it
+		// prints on stdout is read
+		if l.stopping.Load() {
+			continue
+		}
+		if !m.handleLine(l, line) {
 			m.sendError(fmt.Sprintf("iperf3: %s", line))
 		}
 	}
 }
 
-// monitorProcess waits for the iperf3 process to exit
-func (m *Manager) monitorProcess() {
-	if m.cmd == nil {
-		return
+// handleLine parses a line and dispatches the resulting event, tagged with
+// the listener's port, returning false if the line produced no event.
+func (m *Manager) handleLine(l *listener, line string) bool {
+	l.sp.mu.Lock()
+	defer l.sp.mu.Unlock()
+
+	if m.rawOutput != nil {
+		l.sp.capture(line)
+	}
+	result := l.sp.parser.ParseLine(line)
+
+	// Errors iperf reports as it stops, such as its interrupt message, are
+	// expected
+	if result.Event == EventError && l.stopping.Load() {
+		return true
 	}
 
-	err := m.cmd.Wait()
+	switch result.Event {
+	case EventClientConnected:
+		result.ConnectionEvent.ServerPort = l.port
+
+		// Check allowlist
+		m.mu.RLock()
+		allowlist := m.config.Allowlist
+		m.mu.RUnlock()
+
+		if !IsClientAllowed(result.ConnectionEvent.ClientIP, allowlist) {
+			m.sendError(fmt.Sprintf("client %s not in allowlist", result.ConnectionEvent.ClientIP))
+			return true
+		}
+
+		m.sendEvent(models.WSMessage{
+			Type:    models.WSMessageTypeClientConnected,
+			Payload: result.ConnectionEvent,
+		})
+
+	case EventBandwidthUpdate:
+		result.BandwidthUpdate.ServerPort = l.port
+		m.mu.RLock()
+		result.BandwidthUpdate.Interval = m.config.ReportInterval()
+		m.mu.RUnlock()
+		if m.smoothing > 1 {
+			l.sp.smooth(result.BandwidthUpdate, m.smoothing)
+		}
+		m.live.record(*result.BandwidthUpdate, l.sp.parser.ClientIP(), time.Now())
+		m.sendEvent(models.WSMessage{
+			Type:    models.WSMessageTypeBandwidthUpdate,
+			Payload: result.BandwidthUpdate,
+		})
+
+	case EventTestComplete:
+		result.TestResult.ServerPort = l.port
+		if l.stopping.Load() {
+			abortStopped(result.TestResult)
+			result.ErrorMessage = ""
+		}
+		m.sendEvent(models.WSMessage{
+			Type:    models.WSMessageTypeTestComplete,
+			Payload: result.TestResult,
+		})
+		m.sendRawOutput(l, result.TestResult)
+		if result.ErrorMessage != "" {
+			m.sendError(result.ErrorMessage)
+		}
+
+	case EventCollision:
+		result.Collision.ServerPort = l.port
+		m.sendEvent(models.WSMessage{
+			Type:    models.WSMessageTypeCollision,
+			Payload: result.Collision,
+		})
+
+	case EventError:
+		m.sendError(result.ErrorMessage)
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// monitorProcess waits for a listener's iperf process to exit. The pool runs
+// as one server: a listener exiting on its own stops the others.
+func (m *Manager) monitorProcess(l *listener, readers *sync.WaitGroup) {
+	defer close(l.exited)
+
+	// Drain output before Wait closes the pipes
+	readers.Wait()
+	err := l.cmd.Wait()
+
+	m.mu.Lock()
+	current := m.ownsLocked(l)
+	stopped := !current || m.status != models.ServerStatusRunning
+	if current {
+		m.recordExitLocked(l)
+	}
+	m.mu.Unlock()
+
+	// A test still in progress when the process exits never gets a summary;
+	// record what was measured so far. The parser is shared with Ports.
+	l.sp.mu.Lock()
+	if l.sp.parser.InSession() {
+		status := models.TestStatusFailed
+		reason := "iperf3 exited unexpectedly"
+		if stopped {
+			status = models.TestStatusAborted
+			reason = reasonStopped
+		} else if err != nil {
+			reason = fmt.Sprintf("iperf3 exited unexpectedly: %v", err)
+		}
+		result := l.sp.parser.AbortSession(status, reason)
+		result.ServerPort = l.port
+		if l.stopping.Load() {
+			abortStopped(result)
+		}
+		m.sendEvent(models.WSMessage{
+			Type:    models.WSMessageTypeTestComplete,
+			Payload: result,
+		})
+		m.sendRawOutput(l, result)
+	}
+	l.sp.mu.Unlock()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// A newer server has been started; leave its state alone
+	if !current {
+		return
+	}
+
 	// Only update status if we're still running (not manually stopped)
 	if m.status == models.ServerStatusRunning {
-		if err != nil {
-			// Check if it was killed by context cancellation
-			if m.cmd.ProcessState != nil && m.cmd.ProcessState.Exited() {
-				// Process exited normally or was terminated
-				m.status = models.ServerStatusStopped
-			} else {
-				m.status = models.ServerStatusError
+		// A process that exits by itself, as one-off servers do after their
+		// test, stops the server; being killed by a signal is a crash
+		exited := err == nil || (l.cmd.ProcessState != nil && l.cmd.ProcessState.Exited())
+		if exited && m.config.AutoRearm {
+			// Listen again for the next test instead
+			rearmErr := m.rearmLocked(l)
+			if rearmErr == nil {
+				return
 			}
-		} else {
+			m.status = models.ServerStatusError
+			m.sendEventLocked(models.WSMessage{
+				Type: models.WSMessageTypeError,
+				Payload: map[string]string{
+					"message": fmt.Sprintf("failed to rearm one-off server: %v", rearmErr),
+				},
+			})
+		} else if exited {
 			m.status = models.ServerStatusStopped
+		} else {
+			m.status = models.ServerStatusError
+			m.superviseCrashLocked(err)
 		}
 		m.sendStatusUpdateLocked()
 	}
 
 	// Clean up
-	m.cmd = nil
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
 	if m.idleTimer != nil {
 		m.idleTimer.Stop()
 		m.idleTimer = nil
 	}
 }
 
-// resetIdleTimer resets the idle timer to IdleTimeout seconds
-func (m *Manager) resetIdleTimer() {
+// reasonStopped is the error message of a test cut short by stopping the
+// server
+const reasonStopped = "server stopped during test"
+
+// abortStopped marks a result iperf reported after being asked to stop as
+// aborted, keeping what it measured.
+func abortStopped(result *models.TestResult) {
+	result.Status = models.TestStatusAborted
+	result.ErrorMessage = reasonStopped
+}
+
+// rearmLocked replaces an auto-rearming one-off server's exited listener
+// with a new process on the same port (must be called with lock held).
+func (m *Manager) rearmLocked(old *listener) error {
+	l, err := m.startListener(m.runCtx, m.config, old.port)
+	if err != nil {
+		return err
+	}
+	for i, current := range m.listeners {
+		if current == old {
+			m.listeners[i] = l
+		}
+	}
+	if m.watchdog.Timeout > 0 {
+		go m.runWatchdog(m.runCtx, l)
+	}
+	m.sendSlotReadyLocked(l)
+	return nil
+}
+
+// sendSlotReadyLocked announces that a listener is ready for the next test
+// (must be called with lock held).
+func (m *Manager) sendSlotReadyLocked(l *listener) {
+	m.slot++
+	m.sendEventLocked(models.WSMessage{
+		Type: models.WSMessageTypeTestSlotReady,
+		Payload: &models.TestSlotReady{
+			Timestamp:  time.Now(),
+			ServerPort: l.port,
+			Slot:       m.slot,
+			PID:        l.cmd.Process.Pid,
+		},
+	})
+}
+
+// recordActivity notes that a listener's iperf3 produced output and resets
+// the idle timer to IdleTimeout seconds
+func (m *Manager) recordActivity(l *listener) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	l.lastOutput = time.Now()
+
 	if m.idleTimer != nil && m.config.IdleTimeout > 0 {
 		m.idleTimer.Reset(time.Duration(m.config.IdleTimeout) * time.Second)
 	}
@@ -259,7 +790,7 @@ func (m *Manager) resetIdleTimer() {
 func (m *Manager) sendStatusUpdateLocked() {
 	listenAddr := ""
 	if m.status == models.ServerStatusRunning {
-		listenAddr = fmt.Sprintf("%s:%d", m.config.BindAddress, m.config.Port)
+		listenAddr = m.config.ListenAddr()
 	}
 
 	m.sendEventLocked(models.WSMessage{
@@ -268,6 +799,8 @@ func (m *Manager) sendStatusUpdateLocked() {
 			Status:     m.status,
 			Config:     &m.config,
 			ListenAddr: listenAddr,
+			Supervisor: m.supervisorStatusLocked(),
+			Process:    m.diagnosticsLocked(),
 		},
 	})
 }
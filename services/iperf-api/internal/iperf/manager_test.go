@@ -0,0 +1,531 @@
+package iperf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// fakeIperf writes a shell script standing in for iperf3 and returns its path.
+func fakeIperf(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "iperf3")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// eventRecorder collects messages emitted by a Manager.
+type eventRecorder struct {
+	mu   sync.Mutex
+	msgs []models.WSMessage
+}
+
+func (r *eventRecorder) handle(msg models.WSMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+}
+
+// waitFor polls until a message of the given type has been recorded.
+func (r *eventRecorder) waitFor(t *testing.T, typ models.WSMessageType) models.WSMessage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		for _, msg := range r.msgs {
+			if msg.Type == typ {
+				r.mu.Unlock()
+				return msg
+			}
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s message", typ)
+	return models.WSMessage{}
+}
+
+func TestManager_ProcessDeathMidTestRecordsFailedResult(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "Server listening on 5201"
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 50001"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec"
+exit 1
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	msg := rec.waitFor(t, models.WSMessageTypeTestComplete)
+	result, ok := msg.Payload.(*models.TestResult)
+	if !ok {
+		t.Fatalf("payload type = %T, want *models.TestResult", msg.Payload)
+	}
+	if result.Status != models.TestStatusFailed {
+		t.Errorf("Status = %q, want %q", result.Status, models.TestStatusFailed)
+	}
+	if result.ErrorMessage == "" {
+		t.Error("expected an error message on failed result")
+	}
+	if result.Duration != 1.0 {
+		t.Errorf("Duration = %v, want 1.0", result.Duration)
+	}
+}
+
+func TestManager_RawOutput(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "Server listening on 5201"
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec"
+echo "- - - - - - - - - - - - - - - - - - - - - - - - -"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec                  receiver"
+echo "Server listening on 5201"
+echo "Accepted connection from 10.0.0.2, port 50002"
+echo "[  5]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec"
+exit 1
+`)
+
+	type archived struct {
+		id     string
+		output string
+	}
+	outputs := make(chan archived, 2)
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithRawOutput(func(id string, output []byte) {
+		// The result is sent before its output
+		rec.mu.Lock()
+		sent := len(rec.msgs) > 0 && rec.msgs[len(rec.msgs)-1].Type == models.WSMessageTypeTestComplete
+		rec.mu.Unlock()
+		if !sent {
+			t.Errorf("raw output of %s arrived before its result", id)
+		}
+		outputs <- archived{id, string(output)}
+	}))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	for i, want := range []struct{ first, last string }{
+		{"Server listening on 5201", "receiver"},
+		// A session the process died in keeps what it printed
+		{"Server listening on 5201", "419 Mbits/sec"},
+	} {
+		select {
+		case got := <-outputs:
+			lines := strings.Split(strings.TrimSuffix(got.output, "\n"), "\n")
+			if got.id == "" || lines[0] != want.first || !strings.HasSuffix(lines[len(lines)-1], want.last) {
+				t.Errorf("session %d: raw output of %q =\n%s", i, got.id, got.output)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for raw output of session %d", i)
+		}
+	}
+}
+
+func TestManager_StderrErrorEndsSession(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec"
+sleep 0.2
+echo "iperf3: error - the client has unexpectedly closed the connection" >&2
+exec sleep 5
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	msg := rec.waitFor(t, models.WSMessageTypeTestComplete)
+	result := msg.Payload.(*models.TestResult)
+	if result.Status != models.TestStatusFailed {
+		t.Errorf("Status = %q, want %q", result.Status, models.TestStatusFailed)
+	}
+	if result.ErrorMessage != "the client has unexpectedly closed the connection" {
+		t.Errorf("ErrorMessage = %q", result.ErrorMessage)
+	}
+}
+
+func TestManager_ErrorBetweenTestsIsReported(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "iperf3: error - unable to receive control message: Connection reset by peer"
+exec sleep 5
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	msg := rec.waitFor(t, models.WSMessageTypeError)
+	payload, ok := msg.Payload.(map[string]string)
+	if !ok {
+		t.Fatalf("payload type = %T, want map[string]string", msg.Payload)
+	}
+	if payload["message"] != "unable to receive control message: Connection reset by peer" {
+		t.Errorf("message = %q", payload["message"])
+	}
+}
+
+func TestManager_CollisionDetection(t *testing.T) {
+	bin := fakeIperf(t, `
+case "$*" in *--debug*) ;; *) echo "missing --debug" >&2; exit 1 ;; esac
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "successfully sent ACCESS_DENIED to an unsolicited connection request during active test"
+exec sleep 5
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithCollisionDetection(true), WithClientParameters(false))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	msg := rec.waitFor(t, models.WSMessageTypeCollision)
+	c, ok := msg.Payload.(*models.Collision)
+	if !ok {
+		t.Fatalf("payload type = %T, want *models.Collision", msg.Payload)
+	}
+	if c.ServerPort != cfg.Port || c.BusyClientIP != "10.0.0.1" || c.BusySessionID == "" {
+		t.Errorf("collision = %+v, want port %d busy with 10.0.0.1", *c, cfg.Port)
+	}
+}
+
+func TestManager_AutoRearm(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "launches")
+	// The first test ends cleanly, the second with an error exit; the third
+	// launch waits for a client
+	bin := fakeIperf(t, fmt.Sprintf(`
+n=$(cat %[1]s 2>/dev/null || echo 0)
+echo $((n+1)) > %[1]s
+if [ "$n" -ge 2 ]; then exec sleep 30; fi
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec                  receiver"
+exit $n
+`, count))
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.OneOff = true
+	cfg.AutoRearm = true
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	waitUntil(t, "third slot", func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		slots := 0
+		for _, msg := range rec.msgs {
+			if msg.Type == models.WSMessageTypeTestSlotReady {
+				slots++
+				if ready := msg.Payload.(*models.TestSlotReady); ready.Slot != slots || ready.ServerPort != cfg.Port || ready.PID <= 0 {
+					t.Errorf("slot event = %+v, want slot %d on port %d", ready, slots, cfg.Port)
+				}
+			}
+		}
+		return slots == 3
+	})
+
+	if status := m.GetStatus(); status != models.ServerStatusRunning {
+		t.Errorf("status = %s, want running between tests", status)
+	}
+	rec.mu.Lock()
+	for _, msg := range rec.msgs {
+		if msg.Type == models.WSMessageTypeServerStatus && msg.Payload.(models.ServerStatusPayload).Status != models.ServerStatusRunning {
+			t.Errorf("status message %+v while rearming", msg.Payload)
+		}
+	}
+	rec.mu.Unlock()
+	if d := m.Diagnostics(); len(d.Processes) != 1 || d.LastExit == nil || d.LastExit.ExitCode != 1 {
+		t.Errorf("diagnostics = %+v, want the rearmed process and the error exit", d)
+	}
+}
+
+func TestManager_Iperf2UsesIperf2BinaryAndParser(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "Server listening on TCP port 5001"
+echo "[  4] local 10.0.0.2 port 5001 connected with 10.0.0.1 port 54321"
+echo "[  4]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec"
+echo "[  4]  0.0- 2.0 sec   224 MBytes   941 Mbits/sec"
+exec sleep 30
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath("/nonexistent/iperf3"), WithIperf2BinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.Version = models.IperfVersion2
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	msg := rec.waitFor(t, models.WSMessageTypeTestComplete)
+	result := msg.Payload.(*models.TestResult)
+	if result.Status != models.TestStatusCompleted || result.Duration != 2.0 {
+		t.Errorf("result = %+v, want completed 2s test", result)
+	}
+}
+
+func TestManager_PortPool(t *testing.T) {
+	// Only the listener on 5202 gets a client
+	bin := fakeIperf(t, `
+case "$*" in
+*"-p 5202"*)
+	echo "Accepted connection from 10.0.0.1, port 50000"
+	echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec"
+	;;
+esac
+exec sleep 30
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.PortCount = 3
+	cfg.IntervalSeconds = 0.5
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	status := rec.waitFor(t, models.WSMessageTypeServerStatus).Payload.(models.ServerStatusPayload)
+	if status.ListenAddr != "0.0.0.0:5201-5203" {
+		t.Errorf("ListenAddr = %q, want 0.0.0.0:5201-5203", status.ListenAddr)
+	}
+
+	update := rec.waitFor(t, models.WSMessageTypeBandwidthUpdate).Payload.(*models.BandwidthUpdate)
+	if update.ServerPort != 5202 || update.Interval != 0.5 {
+		t.Errorf("update ServerPort = %d, Interval = %v, want 5202 every 0.5s", update.ServerPort, update.Interval)
+	}
+
+	ports := m.Ports()
+	if len(ports) != 3 {
+		t.Fatalf("Ports() = %+v, want 3 listeners", ports)
+	}
+	for _, p := range ports {
+		want := models.PortStateFree
+		if p.Port == 5202 {
+			want = models.PortStateBusy
+		}
+		if p.State != want {
+			t.Errorf("port %d is %s, want %s", p.Port, p.State, want)
+		}
+	}
+	if ports[1].ClientIP != "10.0.0.1" || ports[1].SessionID == "" {
+		t.Errorf("busy port = %+v, want its client and session", ports[1])
+	}
+
+	if err := m.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if !m.WaitExited(5 * time.Second) {
+		t.Fatal("listeners did not exit")
+	}
+	if ports := m.Ports(); ports != nil {
+		t.Errorf("Ports() after Stop = %+v, want nil", ports)
+	}
+
+	// The session in progress on 5202 is recorded as aborted on its port
+	result := rec.waitFor(t, models.WSMessageTypeTestComplete).Payload.(*models.TestResult)
+	if result.ServerPort != 5202 || result.Status != models.TestStatusAborted {
+		t.Errorf("result port %d status %s, want 5202 aborted", result.ServerPort, result.Status)
+	}
+}
+
+func TestManager_StopReportsTestInProgress(t *testing.T) {
+	// Like iperf3, print the summary so far when asked to stop, then exit
+	// with the interrupt error
+	bin := fakeIperf(t, `
+trap 'echo "- - - - - - - - - - - - -"
+echo "[  5]   0.00-1.50   sec   150 MBytes   839 Mbits/sec                  receiver"
+echo "iperf3: interrupt - the server has terminated" >&2
+exit 1' TERM
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec   100 MBytes   839 Mbits/sec"
+while :; do sleep 0.05; done
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	rec.waitFor(t, models.WSMessageTypeBandwidthUpdate)
+	if err := m.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if !m.WaitExited(5 * time.Second) {
+		t.Fatal("iperf3 did not exit")
+	}
+
+	// The summary iperf3 printed is kept, as a test the stop cut short
+	result := rec.waitFor(t, models.WSMessageTypeTestComplete).Payload.(*models.TestResult)
+	if result.Status != models.TestStatusAborted || result.BytesTransferred != 150<<20 || result.ErrorMessage != reasonStopped {
+		t.Errorf("result = %+v, want the summary, aborted", result)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, msg := range rec.msgs {
+		if msg.Type == models.WSMessageTypeError {
+			t.Errorf("unexpected error %+v", msg.Payload)
+		}
+	}
+}
+
+func TestManager_StopKillsAfterGrace(t *testing.T) {
+	// A process that ignores the request to stop is killed, and its test
+	// recorded from the intervals
+	bin := fakeIperf(t, `
+trap '' TERM
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec   100 MBytes   839 Mbits/sec"
+while :; do sleep 0.05; done
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithStopGrace(200*time.Millisecond))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	rec.waitFor(t, models.WSMessageTypeBandwidthUpdate)
+	stopped := time.Now()
+	m.Stop()
+	if !m.WaitExited(5 * time.Second) {
+		t.Fatal("iperf3 was not killed")
+	}
+	if waited := time.Since(stopped); waited < 200*time.Millisecond {
+		t.Errorf("killed after %s, want the grace period", waited)
+	}
+	result := rec.waitFor(t, models.WSMessageTypeTestComplete).Payload.(*models.TestResult)
+	if result.Status != models.TestStatusAborted || result.BytesTransferred != 100<<20 {
+		t.Errorf("result = %+v, want the interval so far, aborted", result)
+	}
+	if d := m.Diagnostics(); d.LastExit == nil || d.LastExit.State != "signal: killed" {
+		t.Errorf("last exit = %+v, want killed", d.LastExit)
+	}
+}
+
+func TestManager_PortPoolStopsWhenAListenerExits(t *testing.T) {
+	bin := fakeIperf(t, `
+case "$*" in
+*"-p 5202"*) exit 1 ;;
+esac
+exec sleep 30
+`)
+
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.PortCount = 3
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if !m.WaitExited(5 * time.Second) {
+		t.Fatal("remaining listeners were not stopped")
+	}
+	if status := m.GetStatus(); status == models.ServerStatusRunning {
+		t.Errorf("status = %s, want the pool stopped", status)
+	}
+}
+
+func TestManager_Smoothing(t *testing.T) {
+	bin := fakeIperf(t, `
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec  100 MBytes   800 Mbits/sec"
+echo "[  5]   1.00-2.00   sec  50.0 MBytes   400 Mbits/sec"
+echo "[  5]   2.00-3.00   sec  50.0 MBytes   400 Mbits/sec"
+echo "- - - - - - - - - - - - - - - - - - - - - - - - -"
+echo "[  5]   0.00-3.00   sec  200 MBytes   533 Mbits/sec                  receiver"
+echo "Server listening on 5201"
+echo "Accepted connection from 10.0.0.2, port 50002"
+echo "[  5]   0.00-1.00   sec  25.0 MBytes   200 Mbits/sec"
+exec sleep 5
+`)
+	rec := &eventRecorder{}
+	// A window of 3 intervals weighs each new interval by a half
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithSmoothing(3))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	var updates []*models.BandwidthUpdate
+	deadline := time.Now().Add(5 * time.Second)
+	for len(updates) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		updates = updates[:0]
+		rec.mu.Lock()
+		for _, msg := range rec.msgs {
+			if msg.Type == models.WSMessageTypeBandwidthUpdate {
+				updates = append(updates, msg.Payload.(*models.BandwidthUpdate))
+			}
+		}
+		rec.mu.Unlock()
+	}
+	if len(updates) != 4 {
+		t.Fatalf("got %d bandwidth updates, want 4", len(updates))
+	}
+
+	// The next session starts afresh from its first interval
+	for i, want := range []float64{800e6, 600e6, 500e6, 200e6} {
+		u := updates[i]
+		if u.SmoothedBitsPerSecond == nil || *u.SmoothedBitsPerSecond != want {
+			t.Errorf("update %d smoothed = %v, want %v", i, u.SmoothedBitsPerSecond, want)
+		}
+	}
+	if updates[1].BitsPerSecond != 400e6 {
+		t.Errorf("raw bitrate = %v, want it kept", updates[1].BitsPerSecond)
+	}
+}
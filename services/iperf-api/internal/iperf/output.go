@@ -0,0 +1,167 @@
+package iperf
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// outputFormat is how a server's iperf3 reports tests
+type outputFormat int
+
+const (
+	formatText outputFormat = iota
+	// formatJSON is -J: an object per test, printed when the test ends
+	formatJSON
+	// formatJSONStream is -J --json-stream: a line of JSON per event as the
+	// test runs
+	formatJSONStream
+)
+
+// args returns the flags that select the format.
+func (f outputFormat) args() []string {
+	switch f {
+	case formatJSON:
+		return []string{"-J"}
+	case formatJSONStream:
+		return []string{"-J", "--json-stream"}
+	}
+	return nil
+}
+
+// versionProbeTimeout bounds how long asking iperf3 its version may take
+const versionProbeTimeout = 2 * time.Second
+
+// reIperfVersion matches the first line of iperf3 -v, "iperf 3.17.1 (cJSON
+// 1.7.15)", and of iperf2's, "iperf version 2.1.9 (14 March 2023) pthreads"
+var reIperfVersion = regexp.MustCompile(`(?m)^iperf (?:version )?((\d+)\.(\d+)\S*)`)
+
+// binaryVersion is the version an iperf binary reports
+type binaryVersion struct {
+	text         string
+	major, minor int
+}
+
+// WithOutputMode sets how iperf3 reports tests for server configs that do
+// not choose (default text)
+func WithOutputMode(mode models.OutputMode) ManagerOption {
+	return func(m *Manager) {
+		if mode != "" {
+			m.outputMode = mode
+		}
+	}
+}
+
+// outputFormat resolves cfg's output mode for the iperf3 binary it runs.
+// JSON streams as the test runs where binary supports --json-stream; auto
+// falls back to text where it does not. iperf2 servers always use text.
+func (m *Manager) outputFormat(cfg models.ServerConfig, binary string) outputFormat {
+	if cfg.Version == models.IperfVersion2 {
+		return formatText
+	}
+	mode := cfg.OutputMode
+	if mode == "" {
+		mode = m.outputMode
+	}
+	switch mode {
+	case models.OutputModeJSON:
+		if m.streamsJSON(binary) {
+			return formatJSONStream
+		}
+		return formatJSON
+	case models.OutputModeAuto:
+		if m.streamsJSON(binary) {
+			return formatJSONStream
+		}
+	}
+	return formatText
+}
+
+// streamsJSON reports whether binary is iperf3 3.17 or later, which added
+// --json-stream.
+func (m *Manager) streamsJSON(binary string) bool {
+	v, ok := m.binaryVersion(binary)
+	return ok && (v.major > 3 || v.major == 3 && v.minor >= 17)
+}
+
+// binaryVersion asks binary its version. A version that was read is
+// remembered; a binary that could not be asked is asked again next time.
+func (m *Manager) binaryVersion(binary string) (binaryVersion, bool) {
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+
+	if v, ok := m.versions[binary]; ok {
+		return v, true
+	}
+	v, ok := iperfVersion(binary)
+	if !ok {
+		return binaryVersion{}, false
+	}
+	if m.versions == nil {
+		m.versions = make(map[string]binaryVersion)
+	}
+	m.versions[binary] = v
+	return v, true
+}
+
+// iperfVersion runs binary -v and returns the version it reports. iperf2
+// reports on stderr.
+func iperfVersion(binary string) (binaryVersion, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), versionProbeTimeout)
+	defer cancel()
+	out, _ := exec.CommandContext(ctx, binary, "-v").CombinedOutput()
+	v := reIperfVersion.FindSubmatch(out)
+	if v == nil {
+		return binaryVersion{}, false
+	}
+	major, _ := strconv.Atoi(string(v[2]))
+	minor, _ := strconv.Atoi(string(v[3]))
+	return binaryVersion{text: string(v[1]), major: major, minor: minor}, true
+}
+
+// parser names the parser reading output in format f.
+func (f outputFormat) parser() models.IperfParser {
+	switch f {
+	case formatJSON:
+		return models.IperfParserJSON
+	case formatJSONStream:
+		return models.IperfParserJSONStream
+	}
+	return models.IperfParserText
+}
+
+// Binary describes the binary the current config runs: its version, and
+// the output mode configured and the parser it resolves to.
+func (m *Manager) Binary() models.IperfBinaryInfo {
+	return m.binaryInfo(m.GetConfig())
+}
+
+// binaryInfo describes the binary cfg runs.
+func (m *Manager) binaryInfo(cfg models.ServerConfig) models.IperfBinaryInfo {
+	binary, _, format := m.commandFormat(cfg, cfg.Port)
+	info := models.IperfBinaryInfo{
+		Path:       binary,
+		Simulated:  m.usesSimulator(cfg),
+		OutputMode: models.OutputModeText,
+		Parser:     format.parser(),
+	}
+	if path, err := exec.LookPath(binary); err == nil {
+		info.Path = path
+	}
+	if v, ok := m.binaryVersion(binary); ok {
+		info.Version = v.text
+	}
+	switch {
+	case cfg.Version == models.IperfVersion2:
+		info.Parser = models.IperfParserIperf2
+	case cfg.OutputMode != "":
+		info.OutputMode = cfg.OutputMode
+	case m.outputMode != "":
+		info.OutputMode = m.outputMode
+	}
+	return info
+}
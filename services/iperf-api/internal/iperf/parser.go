@@ -1,6 +1,8 @@
 package iperf
 
 import (
+	"math"
+	"net/netip"
 	"regexp"
 	"strconv"
 	"strings"
@@ -18,6 +20,7 @@ const (
 	EventBandwidthUpdate            // per-interval bandwidth line
 	EventTestComplete               // summary sender/receiver line
 	EventError                      // iperf3 error line
+	EventCollision                  // connection turned away during a test
 )
 
 // ParseResult is the output of parsing a single line.
@@ -26,6 +29,7 @@ type ParseResult struct {
 	ConnectionEvent *models.ConnectionEvent
 	BandwidthUpdate *models.BandwidthUpdate
 	TestResult      *models.TestResult
+	Collision       *models.Collision
 	ErrorMessage    string
 }
 
@@ -39,27 +43,64 @@ type TextParser struct {
 	reInterval    *regexp.Regexp
 	reSummary     *regexp.Regexp
 	reListening   *regexp.Regexp
+	reError       *regexp.Regexp
+	reStarting    *regexp.Regexp
+	reMSS         *regexp.Regexp
+	reDenied      *regexp.Regexp
+	reBusy        *regexp.Regexp
+	reTime        *regexp.Regexp
+	reVerboseEnd  *regexp.Regexp
+	reCPU         *regexp.Regexp
 
 	// per-test session state
-	clientIP     string
-	clientPort   int
-	protocol     models.Protocol
-	inSummary    bool
-	minBandwidth float64
-	maxBandwidth float64
-	intervals    int
+	sessionState
+	inSummary bool
+	// streams counts the session's data connections. The result of a
+	// parallel test is measured from its [SUM] summary line.
+	streams int
+	// slice sums the lines of the interval being reported, one per stream,
+	// until a line for another interval records it
+	slice intervalSlice
+
+	// verboseSummary is set by the verbose summary heading, after which the
+	// CPU utilization follows the summary lines. pending holds the session's
+	// result until then.
+	verboseSummary bool
+	pending        *models.TestResult
+
+	// intervalRounding is the sum of the squared rounding steps of the
+	// intervals in totalBytes, to weigh their total against the summary's
+	intervalRounding float64
+
+	// client parameters JSON being collected in --debug mode
+	inParams bool
+	params   strings.Builder
+}
+
+// intervalSlice is one reporting interval of a test, summed over its streams
+// and, in a bidirectional test, over both directions.
+type intervalSlice struct {
+	open       bool
+	start, end float64
+	omitted    bool
+	bytes      int64
+	bps        float64
+	// rounding is the sum of the squared rounding steps of the lines
+	rounding float64
 }
 
 // NewTextParser creates a TextParser with compiled regex patterns.
 func NewTextParser() *TextParser {
 	return &TextParser{
 		// "Accepted connection from 10.0.0.1, port 54321"
+		// "Accepted connection from fe80::1%eth0, port 54321"
 		reAccepted: regexp.MustCompile(
-			`Accepted connection from ([^,]+), port (\d+)`),
+			`Accepted connection from \[?([^\],\s]+)\]?, port (\d+)`),
 
 		// "[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 54321"
+		// "[  5] local [2001:db8::2] port 5201 connected to [2001:db8::1] port 54321"
 		reConnectedTo: regexp.MustCompile(
-			`\[\s*\d+\]\s+local\s+\S+\s+port\s+\d+\s+connected to\s+(\S+)\s+port\s+(\d+)`),
+			`\[\s*\d+\]\s+local\s+\S+\s+port\s+\d+\s+connected to\s+\[?([^\]\s]+)\]?\s+port\s+(\d+)`),
 
 		// "[ ID] Interval           Transfer     Bitrate         Jitter    Lost/Total Datagrams"
 		reUDPHeader: regexp.MustCompile(
@@ -71,18 +112,61 @@ func NewTextParser() *TextParser {
 
 		// "[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec"
 		// "[  5]   0.00-1.00   sec  1.25 MBytes  10.5 Mbits/sec  0.123 ms  0/856 (0%)"
+		// "[SUM]   0.00-1.00   sec   110 MBytes   924 Mbits/sec"
+		// "[  8][TX-S]   0.00-1.00   sec   109 MBytes   914 Mbits/sec    0   1.12 MBytes"
 		reInterval: regexp.MustCompile(
-			`\[\s*\d+\]\s+([\d.]+)-([\d.]+)\s+sec\s+([\d.]+)\s+(\S?Bytes)\s+([\d.]+)\s+(\S?bits/sec)(?:\s+([\d.]+)\s+ms\s+(\d+)/(\d+)\s+\(([\d.]+)%\))?`),
+			`\[\s*(?:\d+|SUM)\](?:\[[RT]X-[SC]\])?\s+([\d.]+)-([\d.]+)\s+sec\s+([\d.]+)\s+(\S?Bytes)\s+([\d.]+)\s+(\S?bits/sec)(?:\s+([\d.]+)\s+ms\s+(\d+)/(\d+)\s+\(([\d.]+)%\))?`),
 
-		// Same as interval but with sender/receiver suffix
+		// Same as interval but with sender/receiver suffix, and a TCP
+		// sender's retransmits:
+		// "[  5]   0.00-10.00  sec  1.10 GBytes   941 Mbits/sec   12             sender"
+		// "[SUM]   0.00-10.00  sec  4.39 GBytes  3.77 Gbits/sec   48             sender"
+		// "[  5][RX-S]   0.00-2.00   sec   221 MBytes   927 Mbits/sec                  receiver"
 		reSummary: regexp.MustCompile(
-			`\[\s*\d+\]\s+([\d.]+)-([\d.]+)\s+sec\s+([\d.]+)\s+(\S?Bytes)\s+([\d.]+)\s+(\S?bits/sec)(?:\s+([\d.]+)\s+ms\s+(\d+)/(\d+)\s+\(([\d.]+)%\))?\s+(sender|receiver)`),
+			`\[\s*(?:\d+|SUM)\](?:\[[RT]X-[SC]\])?\s+([\d.]+)-([\d.]+)\s+sec\s+([\d.]+)\s+(\S?Bytes)\s+([\d.]+)\s+(\S?bits/sec)(?:\s+([\d.]+)\s+ms\s+(\d+)/(\d+)\s+\(([\d.]+)%\))?(?:\s+(\d+))?\s+(sender|receiver)`),
 
 		// "Server listening on 5201 (test #2)"  or  "Server listening on 5201"
 		reListening: regexp.MustCompile(
 			`Server listening on (\d+)`),
 
-		protocol: models.ProtocolTCP,
+		// "iperf3: error - the client has unexpectedly closed the connection"
+		// "iperf3: the client has unexpectedly closed the connection"
+		// "iperf3: interrupt - the server has terminated"
+		reError: regexp.MustCompile(
+			`^(?:iperf3: (?:error - )?|error - )(.+)$`),
+
+		// "Starting Test: protocol: TCP, 1 streams, 131072 byte blocks, omitting 0 seconds, 10 second test, tos 0"
+		reStarting: regexp.MustCompile(
+			`Starting Test: `),
+
+		// "      TCP MSS: 1448 (default)"
+		reMSS: regexp.MustCompile(
+			`TCP MSS: (\d+)`),
+
+		// --debug only: "successfully sent ACCESS_DENIED to an unsolicited connection request during active test"
+		// (or "failed to send ..."); the client is turned away either way
+		reDenied: regexp.MustCompile(
+			`ACCESS_DENIED to an unsolicited connection request`),
+
+		// "iperf3: error - the server is busy running a test. try again later"
+		reBusy: regexp.MustCompile(
+			`the server is busy running a test`),
+
+		// -V only, just before "Accepted connection": "Time: Fri, 31 Jan 2026 12:00:00 GMT"
+		// (the text form of start.timestamp in JSON output)
+		reTime: regexp.MustCompile(
+			`^Time: (.+)$`),
+
+		// -V only, before the summary: "Test Complete. Summary Results:"
+		reVerboseEnd: regexp.MustCompile(
+			`^Test Complete\. Summary Results:`),
+
+		// -V only, after the summary:
+		// "CPU Utilization: local/receiver 5.6% (0.3%u/5.3%s), remote/sender 12.1% (1.2%u/10.9%s)"
+		reCPU: regexp.MustCompile(
+			`CPU Utilization: local/\w+ ([\d.]+)% .*remote/\w+ ([\d.]+)%`),
+
+		sessionState: sessionState{protocol: models.ProtocolTCP, precision: models.PrecisionRounded},
 	}
 }
 
@@ -90,28 +174,115 @@ func NewTextParser() *TextParser {
 func (p *TextParser) ParseLine(line string) ParseResult {
 	line = strings.TrimRight(line, "\r\n")
 
+	// Client parameters printed by --debug, up to the closing brace
+	if p.inParams {
+		if p.params.Len() > 0 || strings.HasPrefix(line, "{") {
+			p.params.WriteString(line)
+			p.params.WriteByte('\n')
+			if line == "}" {
+				p.parseClientParameters(p.params.String())
+				p.inParams = false
+			}
+			return ParseResult{Event: EventNone}
+		}
+		p.inParams = false
+	}
+	if line == "get_parameters:" {
+		p.inParams = true
+		p.params.Reset()
+		return ParseResult{Event: EventNone}
+	}
+
+	// Another client tried to connect while a test was running; the session
+	// in progress is unaffected
+	if p.reDenied.MatchString(line) || p.reBusy.MatchString(line) {
+		return ParseResult{
+			Event: EventCollision,
+			Collision: &models.Collision{
+				Timestamp:     time.Now(),
+				BusySessionID: p.id,
+				BusyClientIP:  p.clientIP,
+			},
+		}
+	}
+
 	// Check for summary line first (has sender/receiver suffix)
 	if m := p.reSummary.FindStringSubmatch(line); m != nil && p.inSummary {
-		return p.buildTestComplete(m)
+		return p.buildTestComplete(m, isSum(line), bidirHalf(line))
+	}
+
+	if p.reVerboseEnd.MatchString(line) {
+		p.verboseSummary = true
+		return ParseResult{Event: EventNone}
+	}
+
+	// CPU utilization completes the result held since the summary
+	if m := p.reCPU.FindStringSubmatch(line); m != nil && p.pending != nil {
+		host, _ := strconv.ParseFloat(m[1], 64)
+		remote, _ := strconv.ParseFloat(m[2], 64)
+		p.pending.HostCPUTotal = &host
+		p.pending.RemoteCPUTotal = &remote
+		return p.takePending()
+	}
+
+	// iperf3 error while a test is in progress ends the session as failed;
+	// between tests it is reported on its own
+	if m := p.reError.FindStringSubmatch(line); m != nil {
+		if !p.active {
+			return ParseResult{Event: EventError, ErrorMessage: m[1]}
+		}
+		return ParseResult{
+			Event:        EventTestComplete,
+			TestResult:   p.AbortSession(models.TestStatusFailed, m[1]),
+			ErrorMessage: m[1],
+		}
+	}
+
+	// When the next client connected, in iperf3's own clock
+	if m := p.reTime.FindStringSubmatch(line); m != nil {
+		if t, err := time.Parse(time.RFC1123, m[1]); err == nil {
+			p.started = t.UTC()
+		}
+		return ParseResult{Event: EventNone}
 	}
 
 	// "Accepted connection from ..."
 	if m := p.reAccepted.FindStringSubmatch(line); m != nil {
-		ip := m[1]
+		ip := clientAddress(m[1])
+		p.clientIP = ip
+		p.active = true
+		p.newSession()
 		return ParseResult{
 			Event: EventClientConnected,
 			ConnectionEvent: &models.ConnectionEvent{
-				Timestamp: time.Now(),
+				SessionID: p.id,
+				Timestamp: p.startTime(),
 				ClientIP:  ip,
 				EventType: "connected",
 			},
 		}
 	}
 
-	// "connected to <IP> port <PORT>" — updates parser state
+	// "connected to <IP> port <PORT>" — updates parser state; the client's
+	// port is that of its first stream
 	if m := p.reConnectedTo.FindStringSubmatch(line); m != nil {
-		p.clientIP = m[1]
-		p.clientPort, _ = strconv.Atoi(m[2])
+		p.clientIP = clientAddress(m[1])
+		if p.streams == 0 {
+			p.clientPort, _ = strconv.Atoi(m[2])
+		}
+		p.streams++
+		p.active = true
+		return ParseResult{Event: EventNone}
+	}
+
+	// Requested test parameters (verbose output only)
+	if p.reStarting.MatchString(line) {
+		p.parseStartingTest(line)
+		return ParseResult{Event: EventNone}
+	}
+
+	if m := p.reMSS.FindStringSubmatch(line); m != nil && p.active {
+		p.fingerprint().MSS, _ = strconv.Atoi(m[1])
 		return ParseResult{Event: EventNone}
 	}
 
@@ -127,22 +298,31 @@ func (p *TextParser) ParseLine(line string) ParseResult {
 		return ParseResult{Event: EventNone}
 	}
 
-	// Server listening — reset session state for next test
+	// Server listening — reset session state for next test, completing a
+	// result whose CPU utilization never came
 	if p.reListening.MatchString(line) {
+		result := p.takePending()
 		p.resetSession()
-		return ParseResult{Event: EventNone}
+		return result
 	}
 
-	// Interval line (not in summary)
-	if m := p.reInterval.FindStringSubmatch(line); m != nil && !p.inSummary {
-		return p.buildBandwidthUpdate(m)
+	// Interval line, marked "(omitted)" during -O. Parallel streams are
+	// followed by a separator every interval, so an interval after one shows
+	// the summary has not begun.
+	if m := p.reInterval.FindStringSubmatch(line); m != nil {
+		p.inSummary = false
+		return p.buildBandwidthUpdate(m, isSum(line), strings.HasSuffix(strings.TrimSpace(line), "(omitted)"))
 	}
 
 	return ParseResult{Event: EventNone}
 }
 
 // buildBandwidthUpdate creates a BandwidthUpdate from an interval regex match.
-func (p *TextParser) buildBandwidthUpdate(m []string) ParseResult {
+// Omitted intervals are reported but left out of the result, as iperf3 leaves
+// them out of its summary. The streams of a parallel or bidirectional test
+// are reported one by one, and the result measured from each interval's
+// total; the [SUM] lines of parallel streams repeat it.
+func (p *TextParser) buildBandwidthUpdate(m []string, sum, omitted bool) ParseResult {
 	start, _ := strconv.ParseFloat(m[1], 64)
 	end, _ := strconv.ParseFloat(m[2], 64)
 	transferVal, _ := strconv.ParseFloat(m[3], 64)
@@ -152,101 +332,355 @@ func (p *TextParser) buildBandwidthUpdate(m []string) ParseResult {
 
 	bytes := int64(convertBytes(transferVal, transferUnit))
 	bps := convertBitrate(bitrateVal, bitrateUnit)
+	if sum {
+		return ParseResult{Event: EventNone}
+	}
 
-	// Track min/max for test complete
-	if p.intervals == 0 {
-		p.minBandwidth = bps
-		p.maxBandwidth = bps
-	} else {
-		if bps < p.minBandwidth {
-			p.minBandwidth = bps
+	// Track min/max for test complete, once each interval is complete
+	if s := p.slice; s.open && (s.start != start || s.end != end || s.omitted != omitted) {
+		p.recordSlice()
+	}
+	step := roundingStep(m[3], transferUnit)
+	p.slice.open, p.slice.start, p.slice.end, p.slice.omitted = true, start, end, omitted
+	p.slice.bytes += bytes
+	p.slice.bps += bps
+	p.slice.rounding += step * step
+	p.active = true
+
+	update := &models.BandwidthUpdate{
+		SessionID:     p.id,
+		Timestamp:     p.intervalTime(end),
+		IntervalStart: start,
+		IntervalEnd:   end,
+		Bytes:         bytes,
+		BitsPerSecond: bps,
+		Omitted:       omitted,
+	}
+
+	return ParseResult{
+		Event:           EventBandwidthUpdate,
+		BandwidthUpdate: update,
+	}
+}
+
+// recordSlice records the interval summed so far, if any.
+func (p *TextParser) recordSlice() {
+	s := p.slice
+	if !s.open {
+		return
+	}
+	p.slice = intervalSlice{}
+	if s.omitted {
+		p.recordOmitted(s.start, s.end)
+		return
+	}
+	p.recordInterval(s.start, s.end, s.bytes, s.bps)
+	p.intervalRounding += s.rounding
+}
+
+// buildTestComplete creates a TestResult from a summary regex match.
+func (p *TextParser) buildTestComplete(m []string, sum bool, half string) ParseResult {
+	p.recordSlice()
+	if half != "" {
+		return p.addBidirSummary(m, sum, half)
+	}
+	bytes, bps := summaryTotals(m)
+	role := m[12]
+
+	// The streams' lines add up to both ends of a parallel test; its [SUM]
+	// line for the end the server was gives the test's measurements
+	if sum {
+		if p.pending != nil && role == ownRole(p.pending) {
+			p.applySummary(p.pending, m)
 		}
-		if bps > p.maxBandwidth {
-			p.maxBandwidth = bps
+		return ParseResult{Event: EventNone}
+	}
+	side := &models.SideStats{Bytes: bytes, Bandwidth: bps}
+	if m[11] != "" {
+		retransmits, _ := strconv.Atoi(m[11])
+		side.Retransmits = &retransmits
+	}
+
+	// Further summary lines of a held result add to its ends: the other end,
+	// or the session's other streams. iperf3 before 3.7 prints the end the
+	// server was not as zero bytes, sometimes first; the end that moved data
+	// describes the test, and the zero end is dropped.
+	if p.pending != nil {
+		if p.pending.BytesTransferred == 0 && bytes > 0 {
+			if p.pending.Direction == "download" {
+				p.pending.Sender = nil
+				p.pending.Retransmits = nil
+			} else {
+				p.pending.Receiver = nil
+			}
+			addSide(p.pending, role, side)
+			p.applySummary(p.pending, m)
+			return ParseResult{Event: EventNone}
 		}
+		addSide(p.pending, role, side)
+		return ParseResult{Event: EventNone}
+	}
+
+	result := p.newResult()
+	addSide(result, role, side)
+	p.applySummary(result, m)
+
+	p.active = false
+
+	// In verbose output the first result waits for the CPU utilization,
+	// and a parallel test's for the lines of its other streams
+	if (p.verboseSummary || p.streams > 1) && p.pending == nil && result.ID != "" {
+		p.pending = result
+		return ParseResult{Event: EventNone}
 	}
-	p.intervals++
 
 	return ParseResult{
-		Event: EventBandwidthUpdate,
-		BandwidthUpdate: &models.BandwidthUpdate{
-			Timestamp:     time.Now(),
-			IntervalStart: start,
-			IntervalEnd:   end,
-			Bytes:         bytes,
-			BitsPerSecond: bps,
-		},
+		Event:      EventTestComplete,
+		TestResult: result,
 	}
 }
 
-// buildTestComplete creates a TestResult from a summary regex match.
-func (p *TextParser) buildTestComplete(m []string) ParseResult {
+// newResult starts the result of the session's test.
+func (p *TextParser) newResult() *models.TestResult {
+	result := &models.TestResult{
+		ID:         p.takeID(),
+		Timestamp:  p.startTime(),
+		ClientIP:   p.clientIP,
+		ClientPort: p.clientPort,
+		Protocol:   p.protocol,
+		Status:     models.TestStatusCompleted,
+	}
+	p.setRequested(result)
+	p.setClient(result)
+	return result
+}
+
+// addBidirSummary adds a summary line of a --bidir test to its result, which
+// is held for the other direction's. The server's own end of each half is
+// kept: the receiver of its RX streams and the sender of its TX streams.
+// The result's totals are those of both directions together.
+func (p *TextParser) addBidirSummary(m []string, sum bool, half string) ParseResult {
+	role := m[12]
+	if sum || role != map[string]string{"RX": "receiver", "TX": "sender"}[half] {
+		return ParseResult{Event: EventNone}
+	}
+	result := p.pending
+	if result == nil {
+		result = p.newResult()
+		result.Direction = "bidirectional"
+		result.Precision = models.PrecisionRounded
+		p.pending = result
+		p.active = false
+	}
+
+	bytes, bps := summaryTotals(m)
+	side := &models.SideStats{Bytes: bytes, Bandwidth: bps}
+	if m[11] != "" {
+		retransmits, _ := strconv.Atoi(m[11])
+		side.Retransmits = &retransmits
+	}
+	addSide(result, role, side)
+
 	start, _ := strconv.ParseFloat(m[1], 64)
 	end, _ := strconv.ParseFloat(m[2], 64)
+	result.Duration = end - start
+	result.BytesTransferred, result.AvgBandwidth = 0, 0
+	for _, s := range []*models.SideStats{result.Sender, result.Receiver} {
+		if s != nil {
+			result.BytesTransferred += s.Bytes
+			result.AvgBandwidth += s.Bandwidth
+		}
+	}
+	result.MinBandwidth, result.MaxBandwidth = result.AvgBandwidth, result.AvgBandwidth
+	if p.counted > 0 {
+		result.MinBandwidth = p.minBandwidth
+		result.MaxBandwidth = p.maxBandwidth
+	}
+	if avg, ok := p.warmupAverage(); ok {
+		result.AvgBandwidth = avg
+	}
+
+	// A UDP receiver measures the jitter and loss of the half it received
+	if p.protocol == models.ProtocolUDP && half == "RX" && m[7] != "" {
+		jitter, _ := strconv.ParseFloat(m[7], 64)
+		result.Jitter = &jitter
+		lostPct, _ := strconv.ParseFloat(m[10], 64)
+		result.PacketLoss = &lostPct
+	}
+	return ParseResult{Event: EventNone}
+}
+
+// bidirHalf returns "RX" or "TX" for a line of a --bidir test's server
+// receiving or sending streams, and "" for other lines.
+func bidirHalf(line string) string {
+	switch {
+	case strings.Contains(line, "][RX-"):
+		return "RX"
+	case strings.Contains(line, "][TX-"):
+		return "TX"
+	}
+	return ""
+}
+
+// isSum reports whether an interval or summary line is the sum of a
+// parallel test's streams.
+func isSum(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "[SUM]")
+}
+
+// ownRole returns the role of the end the server was in a result's test:
+// the receiver of an upload and the sender of a download.
+func ownRole(result *models.TestResult) string {
+	if result.Direction == "download" {
+		return "sender"
+	}
+	return "receiver"
+}
+
+// summaryTotals returns the bytes and bits per second of a summary regex
+// match.
+func summaryTotals(m []string) (int64, float64) {
 	transferVal, _ := strconv.ParseFloat(m[3], 64)
-	transferUnit := m[4]
 	bitrateVal, _ := strconv.ParseFloat(m[5], 64)
-	bitrateUnit := m[6]
+	return int64(convertBytes(transferVal, m[4])), convertBitrate(bitrateVal, m[6])
+}
 
-	bytes := int64(convertBytes(transferVal, transferUnit))
-	bps := convertBitrate(bitrateVal, bitrateUnit)
-	duration := end - start
+// applySummary sets a result's direction and measurements from a summary
+// regex match. Minimum and maximum come from the intervals tracked, and the
+// average from those after the warm-up, if one is set.
+func (p *TextParser) applySummary(result *models.TestResult, m []string) {
+	start, _ := strconv.ParseFloat(m[1], 64)
+	end, _ := strconv.ParseFloat(m[2], 64)
+	bytes, bps := summaryTotals(m)
 
 	// Direction: on the server side, "receiver" = upload, "sender" = download
-	role := m[11]
-	direction := "upload"
-	if role == "sender" {
-		direction = "download"
+	result.Direction = "upload"
+	if m[12] == "sender" {
+		result.Direction = "download"
 	}
+	result.Duration = end - start
+	result.BytesTransferred = bytes
+	result.AvgBandwidth = bps
+	p.correctBytes(result, m)
 
-	result := &models.TestResult{
-		Timestamp:        time.Now(),
-		ClientIP:         p.clientIP,
-		ClientPort:       p.clientPort,
-		Protocol:         p.protocol,
-		Duration:         duration,
-		BytesTransferred: bytes,
-		AvgBandwidth:     bps,
-		Direction:        direction,
-	}
-
-	// Min/max from tracked intervals
-	if p.intervals > 0 {
+	if p.counted > 0 {
 		result.MinBandwidth = p.minBandwidth
 		result.MaxBandwidth = p.maxBandwidth
 	} else {
 		result.MinBandwidth = bps
 		result.MaxBandwidth = bps
 	}
+	if avg, ok := p.warmupAverage(); ok {
+		result.AvgBandwidth = avg
+	}
 
 	// UDP-specific fields
 	if p.protocol == models.ProtocolUDP && m[7] != "" {
 		jitter, _ := strconv.ParseFloat(m[7], 64)
 		result.Jitter = &jitter
-
-		lost, _ := strconv.Atoi(m[8])
-		total, _ := strconv.Atoi(m[9])
 		lostPct, _ := strconv.ParseFloat(m[10], 64)
-		_ = lost
-		_ = total
 		result.PacketLoss = &lostPct
 	}
+}
+
+// correctBytes replaces a result's bytes, rounded to the summary's three
+// significant figures, with the total of its intervals where that is the
+// more precise of the two. Interval lines are rounded too, so their total is
+// used only while its rounding is the smaller and it agrees with the summary
+// within the summary's rounding, which the intervals of one stream of a
+// parallel test do not.
+func (p *TextParser) correctBytes(result *models.TestResult, m []string) {
+	result.Precision = models.PrecisionRounded
+	step := roundingStep(m[3], m[4])
+	diff := math.Abs(float64(p.totalBytes - result.BytesTransferred))
+	if p.intervals == 0 || p.intervalRounding >= step*step || diff > step/2 {
+		return
+	}
+	result.BytesTransferred = p.totalBytes
+	result.Precision = models.PrecisionCorrected
+	own := result.Receiver
+	if m[12] == "sender" {
+		own = result.Sender
+	}
+	if own != nil {
+		own.Bytes = p.totalBytes
+	}
+}
 
-	return ParseResult{
-		Event:      EventTestComplete,
-		TestResult: result,
+// roundingStep returns the size in bytes of the last digit iperf printed of
+// a transfer value.
+func roundingStep(value, unit string) float64 {
+	decimals := 0
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		decimals = len(value) - i - 1
+	}
+	return convertBytes(math.Pow10(-decimals), unit)
+}
+
+// addSide adds a summary line's totals to the end of the test it reports,
+// taking the result's retransmits from the sender.
+func addSide(result *models.TestResult, role string, side *models.SideStats) {
+	total := &result.Receiver
+	if role == "sender" {
+		total = &result.Sender
+	}
+	if prev := *total; prev != nil {
+		side.Bytes += prev.Bytes
+		side.Bandwidth += prev.Bandwidth
+		if prev.Retransmits != nil {
+			sum := *prev.Retransmits
+			if side.Retransmits != nil {
+				sum += *side.Retransmits
+			}
+			side.Retransmits = &sum
+		}
+	}
+	*total = side
+	if role == "sender" && side.Retransmits != nil {
+		retransmits := *side.Retransmits
+		result.Retransmits = &retransmits
+	}
+}
+
+// takePending returns the result held for its CPU utilization as a
+// completed test, or no event if there is none.
+func (p *TextParser) takePending() ParseResult {
+	if p.pending == nil {
+		return ParseResult{Event: EventNone}
 	}
+	result := p.pending
+	p.pending = nil
+	return ParseResult{Event: EventTestComplete, TestResult: result}
+}
+
+// InSession reports whether a test is in progress and has not yet produced
+// a result, including one whose result waits for its CPU utilization.
+func (p *TextParser) InSession() bool {
+	return p.active || p.pending != nil
+}
+
+// AbortSession ends the in-progress test and returns what was measured so
+// far. A result waiting for its CPU utilization is returned as it is.
+func (p *TextParser) AbortSession(status models.TestStatus, reason string) *models.TestResult {
+	p.recordSlice()
+	if p.pending != nil {
+		result := p.pending
+		p.pending = nil
+		return result
+	}
+	return p.sessionState.AbortSession(status, reason)
 }
 
 // resetSession clears per-test state for the next test session.
 func (p *TextParser) resetSession() {
-	p.clientIP = ""
-	p.clientPort = 0
-	p.protocol = models.ProtocolTCP
+	p.sessionState.reset()
 	p.inSummary = false
-	p.minBandwidth = 0
-	p.maxBandwidth = 0
-	p.intervals = 0
+	p.streams = 0
+	p.slice = intervalSlice{}
+	p.inParams = false
+	p.verboseSummary = false
+	p.pending = nil
+	p.intervalRounding = 0
 }
 
 // convertBytes converts a transfer value with unit to bytes.
@@ -278,3 +712,14 @@ func convertBitrate(value float64, unit string) float64 {
 		return value
 	}
 }
+
+// clientAddress normalises an address from iperf output: brackets are
+// removed and IPv4-mapped IPv6 addresses are reported as IPv4. Zone IDs are
+// kept, since a link-local address is ambiguous without one.
+func clientAddress(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if addr, err := netip.ParseAddr(s); err == nil && addr.Is4In6() {
+		return addr.Unmap().String()
+	}
+	return s
+}
@@ -0,0 +1,186 @@
+package iperf
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Iperf2Parser parses classic iperf (v2) server output line-by-line.
+//
+// iperf2 has no summary separator: the final report is an interval line
+// starting at 0.0 that spans more than one reporting interval.
+type Iperf2Parser struct {
+	reListening *regexp.Regexp
+	reConnected *regexp.Regexp
+	reUDPHeader *regexp.Regexp
+	reInterval  *regexp.Regexp
+
+	// protocol the server listens on; iperf2 cannot auto-detect UDP
+	listenProtocol models.Protocol
+	intervalLen    float64
+
+	sessionState
+}
+
+// NewIperf2Parser creates an Iperf2Parser with compiled regex patterns.
+func NewIperf2Parser() *Iperf2Parser {
+	return &Iperf2Parser{
+		// "Server listening on TCP port 5001"
+		reListening: regexp.MustCompile(
+			`Server listening on (TCP|UDP) port (\d+)`),
+
+		// "[  4] local 10.0.0.2 port 5001 connected with 10.0.0.1 port 54321"
+		// "[  4] local ::ffff:10.0.0.2 port 5001 connected with ::ffff:10.0.0.1 port 54321"
+		reConnected: regexp.MustCompile(
+			`\[\s*\d+\]\s+local\s+\S+\s+port\s+\d+\s+connected with\s+\[?([^\]\s]+)\]?\s+port\s+(\d+)`),
+
+		// "[ ID] Interval       Transfer     Bandwidth        Jitter   Lost/Total Datagrams"
+		reUDPHeader: regexp.MustCompile(
+			`\[\s*ID\].*Jitter.*Lost/Total`),
+
+		// "[  4]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec"
+		// "[  3]  0.0- 1.0 sec   128 KBytes  1.05 Mbits/sec   0.012 ms    0/   89 (0%)"
+		reInterval: regexp.MustCompile(
+			`\[\s*\d+\]\s+([\d.]+)\s*-\s*([\d.]+)\s+sec\s+([\d.]+)\s+(\S?Bytes)\s+([\d.]+)\s+(\S?bits/sec)(?:\s+([\d.]+)\s+ms\s+(\d+)/\s*(\d+)\s+\(([\d.eE+-]+)%\))?`),
+
+		listenProtocol: models.ProtocolTCP,
+		sessionState:   sessionState{protocol: models.ProtocolTCP, precision: models.PrecisionRounded},
+	}
+}
+
+// ParseLine parses a single line of iperf2 output and returns a result.
+func (p *Iperf2Parser) ParseLine(line string) ParseResult {
+	line = strings.TrimRight(line, "\r\n")
+
+	if m := p.reListening.FindStringSubmatch(line); m != nil {
+		p.listenProtocol = models.ProtocolTCP
+		if m[1] == "UDP" {
+			p.listenProtocol = models.ProtocolUDP
+		}
+		p.startSession("", 0)
+		return ParseResult{Event: EventNone}
+	}
+
+	// iperf2 reports the connection and its data port on one line
+	if m := p.reConnected.FindStringSubmatch(line); m != nil {
+		ip := clientAddress(m[1])
+		port, _ := strconv.Atoi(m[2])
+		p.startSession(ip, port)
+		p.newSession()
+		return ParseResult{
+			Event: EventClientConnected,
+			ConnectionEvent: &models.ConnectionEvent{
+				SessionID: p.id,
+				Timestamp: time.Now(),
+				ClientIP:  ip,
+				EventType: "connected",
+			},
+		}
+	}
+
+	if p.reUDPHeader.MatchString(line) {
+		p.protocol = models.ProtocolUDP
+		return ParseResult{Event: EventNone}
+	}
+
+	if m := p.reInterval.FindStringSubmatch(line); m != nil && p.active {
+		start, _ := strconv.ParseFloat(m[1], 64)
+		end, _ := strconv.ParseFloat(m[2], 64)
+		if p.isSummary(start, end) {
+			return p.buildTestComplete(m)
+		}
+		return p.buildBandwidthUpdate(m)
+	}
+
+	return ParseResult{Event: EventNone}
+}
+
+// startSession resets state for a new client connection.
+func (p *Iperf2Parser) startSession(ip string, port int) {
+	p.sessionState.reset()
+	p.protocol = p.listenProtocol
+	p.clientIP = ip
+	p.clientPort = port
+	p.active = ip != ""
+	p.intervalLen = 0
+}
+
+// isSummary reports whether an interval line is the final report: it starts
+// at zero and is longer than the reporting interval seen so far.
+func (p *Iperf2Parser) isSummary(start, end float64) bool {
+	if start != 0 || p.intervals == 0 {
+		return false
+	}
+	return end-start > p.intervalLen+0.05
+}
+
+// buildBandwidthUpdate creates a BandwidthUpdate from an interval regex match.
+func (p *Iperf2Parser) buildBandwidthUpdate(m []string) ParseResult {
+	start, _ := strconv.ParseFloat(m[1], 64)
+	end, _ := strconv.ParseFloat(m[2], 64)
+	transferVal, _ := strconv.ParseFloat(m[3], 64)
+	bitrateVal, _ := strconv.ParseFloat(m[5], 64)
+
+	bytes := int64(convertBytes(transferVal, m[4]))
+	bps := convertBitrate(bitrateVal, m[6])
+
+	p.intervalLen = math.Max(p.intervalLen, end-start)
+	p.recordInterval(start, end, bytes, bps)
+
+	return ParseResult{
+		Event: EventBandwidthUpdate,
+		BandwidthUpdate: &models.BandwidthUpdate{
+			SessionID:     p.id,
+			Timestamp:     time.Now(),
+			IntervalStart: start,
+			IntervalEnd:   end,
+			Bytes:         bytes,
+			BitsPerSecond: bps,
+		},
+	}
+}
+
+// buildTestComplete creates a TestResult from the final report line.
+func (p *Iperf2Parser) buildTestComplete(m []string) ParseResult {
+	end, _ := strconv.ParseFloat(m[2], 64)
+	transferVal, _ := strconv.ParseFloat(m[3], 64)
+	bitrateVal, _ := strconv.ParseFloat(m[5], 64)
+
+	result := &models.TestResult{
+		ID:               p.takeID(),
+		Timestamp:        time.Now(),
+		ClientIP:         p.clientIP,
+		ClientPort:       p.clientPort,
+		Protocol:         p.protocol,
+		Duration:         end,
+		BytesTransferred: int64(convertBytes(transferVal, m[4])),
+		AvgBandwidth:     convertBitrate(bitrateVal, m[6]),
+		MinBandwidth:     p.minBandwidth,
+		MaxBandwidth:     p.maxBandwidth,
+		Direction:        "upload",
+		Status:           models.TestStatusCompleted,
+		Precision:        p.precision,
+	}
+	if avg, ok := p.warmupAverage(); ok {
+		result.AvgBandwidth = avg
+	}
+
+	if p.protocol == models.ProtocolUDP && m[7] != "" {
+		jitter, _ := strconv.ParseFloat(m[7], 64)
+		result.Jitter = &jitter
+		lostPct, _ := strconv.ParseFloat(m[10], 64)
+		result.PacketLoss = &lostPct
+	}
+
+	p.active = false
+
+	return ParseResult{
+		Event:      EventTestComplete,
+		TestResult: result,
+	}
+}
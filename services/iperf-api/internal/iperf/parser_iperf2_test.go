@@ -0,0 +1,160 @@
+package iperf
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestIperf2Parser_TCPSession(t *testing.T) {
+	p := NewIperf2Parser()
+
+	lines := []struct {
+		line      string
+		wantEvent ParseEvent
+	}{
+		{"------------------------------------------------------------", EventNone},
+		{"Server listening on TCP port 5001", EventNone},
+		{"TCP window size:  128 KByte (default)", EventNone},
+		{"------------------------------------------------------------", EventNone},
+		{"[  4] local 10.0.0.2 port 5001 connected with 10.0.0.1 port 54321", EventClientConnected},
+		{"[ ID] Interval       Transfer     Bandwidth", EventNone},
+		{"[  4]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec", EventBandwidthUpdate},
+		{"[  4]  1.0- 2.0 sec   100 MBytes   839 Mbits/sec", EventBandwidthUpdate},
+		{"[  4]  2.0- 3.0 sec   112 MBytes   941 Mbits/sec", EventBandwidthUpdate},
+		{"[  4]  0.0- 3.0 sec   324 MBytes   907 Mbits/sec", EventTestComplete},
+	}
+
+	var result *models.TestResult
+	for _, tt := range lines {
+		r := p.ParseLine(tt.line)
+		if r.Event != tt.wantEvent {
+			t.Errorf("ParseLine(%q): event = %v, want %v", tt.line, r.Event, tt.wantEvent)
+		}
+		if r.Event == EventClientConnected && r.ConnectionEvent.ClientIP != "10.0.0.1" {
+			t.Errorf("ConnectionEvent.ClientIP = %q, want 10.0.0.1", r.ConnectionEvent.ClientIP)
+		}
+		if r.Event == EventTestComplete {
+			result = r.TestResult
+		}
+	}
+
+	if result == nil {
+		t.Fatal("no test result produced")
+	}
+	if result.ClientIP != "10.0.0.1" || result.ClientPort != 54321 {
+		t.Errorf("client = %s:%d, want 10.0.0.1:54321", result.ClientIP, result.ClientPort)
+	}
+	if result.Protocol != models.ProtocolTCP {
+		t.Errorf("Protocol = %q, want tcp", result.Protocol)
+	}
+	if result.Duration != 3.0 {
+		t.Errorf("Duration = %v, want 3.0", result.Duration)
+	}
+	if math.Abs(result.AvgBandwidth-907e6) > 1 {
+		t.Errorf("AvgBandwidth = %v, want 907e6", result.AvgBandwidth)
+	}
+	if math.Abs(result.MinBandwidth-839e6) > 1 || math.Abs(result.MaxBandwidth-941e6) > 1 {
+		t.Errorf("Min/Max = %v/%v, want 839e6/941e6", result.MinBandwidth, result.MaxBandwidth)
+	}
+	if result.Status != models.TestStatusCompleted {
+		t.Errorf("Status = %q, want completed", result.Status)
+	}
+	if p.InSession() {
+		t.Error("session should be finished after final report")
+	}
+}
+
+func TestIperf2Parser_IPv6Client(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{"[  4] local 2001:db8::2 port 5001 connected with 2001:db8::1 port 54321", "2001:db8::1"},
+		{"[  4] local fe80::2%eth0 port 5001 connected with fe80::1%eth0 port 54321", "fe80::1%eth0"},
+		{"[  4] local ::ffff:10.0.0.2 port 5001 connected with ::ffff:10.0.0.1 port 54321", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		p := NewIperf2Parser()
+		p.ParseLine("Server listening on TCP port 5001")
+		r := p.ParseLine(tt.line)
+		if r.Event != EventClientConnected || r.ConnectionEvent.ClientIP != tt.want {
+			t.Errorf("ParseLine(%q) = %+v, want client %s", tt.line, r.ConnectionEvent, tt.want)
+		}
+	}
+}
+
+func TestIperf2Parser_UDPSession(t *testing.T) {
+	p := NewIperf2Parser()
+
+	p.ParseLine("Server listening on UDP port 5001")
+	p.ParseLine("Receiving 1470 byte datagrams")
+	p.ParseLine("[  3] local 10.0.0.2 port 5001 connected with 10.0.0.1 port 45678")
+	p.ParseLine("[ ID] Interval       Transfer     Bandwidth        Jitter   Lost/Total Datagrams")
+	r := p.ParseLine("[  3]  0.0- 1.0 sec   128 KBytes  1.05 Mbits/sec   0.012 ms    0/   89 (0%)")
+	if r.Event != EventBandwidthUpdate {
+		t.Fatalf("interval event = %v, want EventBandwidthUpdate", r.Event)
+	}
+	p.ParseLine("[  3]  1.0- 2.0 sec   128 KBytes  1.05 Mbits/sec   0.014 ms    1/   89 (1.1%)")
+	r = p.ParseLine("[  3]  0.0- 2.0 sec   256 KBytes  1.05 Mbits/sec   0.015 ms    1/  178 (0.56%)")
+
+	if r.Event != EventTestComplete {
+		t.Fatalf("summary event = %v, want EventTestComplete", r.Event)
+	}
+	if r.TestResult.Protocol != models.ProtocolUDP {
+		t.Errorf("Protocol = %q, want udp", r.TestResult.Protocol)
+	}
+	if r.TestResult.Jitter == nil || math.Abs(*r.TestResult.Jitter-0.015) > 0.0001 {
+		t.Errorf("Jitter = %v, want 0.015", r.TestResult.Jitter)
+	}
+	if r.TestResult.PacketLoss == nil || math.Abs(*r.TestResult.PacketLoss-0.56) > 0.0001 {
+		t.Errorf("PacketLoss = %v, want 0.56", r.TestResult.PacketLoss)
+	}
+}
+
+func TestIperf2Parser_NewConnectionResetsSession(t *testing.T) {
+	p := NewIperf2Parser()
+
+	p.ParseLine("Server listening on TCP port 5001")
+	p.ParseLine("[  4] local 10.0.0.2 port 5001 connected with 10.0.0.1 port 50000")
+	p.ParseLine("[  4]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec")
+
+	// Client vanished; next client connects without a final report
+	r := p.ParseLine("[  5] local 10.0.0.2 port 5001 connected with 10.0.0.3 port 50001")
+	if r.Event != EventClientConnected {
+		t.Fatalf("event = %v, want EventClientConnected", r.Event)
+	}
+	if p.intervals != 0 || p.ClientIP() != "10.0.0.3" {
+		t.Errorf("session not reset: intervals=%d client=%q", p.intervals, p.ClientIP())
+	}
+
+	// The first interval of the new session is not mistaken for a summary
+	if r := p.ParseLine("[  5]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec"); r.Event != EventBandwidthUpdate {
+		t.Errorf("event = %v, want EventBandwidthUpdate", r.Event)
+	}
+}
+
+func TestIperf2Parser_IgnoresIntervalsWithoutConnection(t *testing.T) {
+	p := NewIperf2Parser()
+
+	if r := p.ParseLine("[  4]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec"); r.Event != EventNone {
+		t.Errorf("event = %v, want EventNone", r.Event)
+	}
+}
+
+func TestIperf2Parser_SessionIDs(t *testing.T) {
+	p := NewIperf2Parser()
+
+	p.ParseLine("Server listening on TCP port 5001")
+	conn := p.ParseLine("[  4] local 10.0.0.2 port 5001 connected with 10.0.0.1 port 54321")
+	id := conn.ConnectionEvent.SessionID
+	if id == "" {
+		t.Fatal("ClientConnected has no session ID")
+	}
+	bw := p.ParseLine("[  4]  0.0- 1.0 sec   112 MBytes   941 Mbits/sec")
+	if bw.BandwidthUpdate.SessionID != id {
+		t.Errorf("BandwidthUpdate.SessionID = %q, want %q", bw.BandwidthUpdate.SessionID, id)
+	}
+	done := p.ParseLine("[  4]  0.0- 3.0 sec   324 MBytes   907 Mbits/sec")
+	if done.TestResult.ID != id {
+		t.Errorf("result ID = %q, want the session ID %q", done.TestResult.ID, id)
+	}
+}
@@ -0,0 +1,448 @@
+package iperf
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// JSONParser parses iperf3 -J server output. Without --json-stream iperf3
+// prints an indented object per test once the test ends, so the parser
+// reports only its result; with --json-stream (iperf3 3.17 and later) each
+// event is a line of JSON, and connections and intervals are reported as
+// they happen, as with text output.
+type JSONParser struct {
+	reError  *regexp.Regexp
+	reDenied *regexp.Regexp
+	reBusy   *regexp.Regexp
+
+	// per-test session state
+	sessionState
+	reverse bool
+	bidir   bool
+
+	// the indented object of a -J test being collected
+	inTest bool
+	test   strings.Builder
+
+	// client parameters JSON being collected in --debug mode
+	inParams bool
+	params   strings.Builder
+}
+
+// iperf3JSON is the object iperf3 -J prints for a test, with the fields
+// results are built from
+type iperf3JSON struct {
+	Start     iperf3JSONStart      `json:"start"`
+	Intervals []iperf3JSONInterval `json:"intervals"`
+	End       iperf3JSONEnd        `json:"end"`
+	Error     string               `json:"error"`
+}
+
+type iperf3JSONStart struct {
+	Connected []struct {
+		RemoteHost string `json:"remote_host"`
+		RemotePort int    `json:"remote_port"`
+	} `json:"connected"`
+	Timestamp struct {
+		Timesecs int64 `json:"timesecs"`
+	} `json:"timestamp"`
+	AcceptedConnection *struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	} `json:"accepted_connection"`
+	TCPMSSDefault int `json:"tcp_mss_default"`
+	TestStart     *struct {
+		Protocol   string `json:"protocol"`
+		NumStreams int    `json:"num_streams"`
+		Blksize    int    `json:"blksize"`
+		Omit       int    `json:"omit"`
+		Duration   int    `json:"duration"`
+		Bytes      int64  `json:"bytes"`
+		Blocks     int64  `json:"blocks"`
+		Reverse    int    `json:"reverse"`
+		Bidir      int    `json:"bidir"`
+		Tos        int    `json:"tos"`
+	} `json:"test_start"`
+}
+
+type iperf3JSONInterval struct {
+	Sum iperf3JSONSum `json:"sum"`
+	// SumBidirReverse is the sum of a --bidir test's streams from the
+	// server to the client; Sum is of those from the client
+	SumBidirReverse *iperf3JSONSum `json:"sum_bidir_reverse"`
+}
+
+// total returns the sum of an interval's streams in both directions.
+func (i iperf3JSONInterval) total() iperf3JSONSum {
+	return *bothWays(&i.Sum, i.SumBidirReverse)
+}
+
+// iperf3JSONSum is the sum of a test's streams over an interval or the
+// whole test
+type iperf3JSONSum struct {
+	Start         float64  `json:"start"`
+	End           float64  `json:"end"`
+	Bytes         int64    `json:"bytes"`
+	BitsPerSecond float64  `json:"bits_per_second"`
+	Retransmits   *int     `json:"retransmits"`
+	JitterMs      *float64 `json:"jitter_ms"`
+	LostPercent   *float64 `json:"lost_percent"`
+	Omitted       bool     `json:"omitted"`
+}
+
+type iperf3JSONEnd struct {
+	SumSent     *iperf3JSONSum `json:"sum_sent"`
+	SumReceived *iperf3JSONSum `json:"sum_received"`
+	Sum         *iperf3JSONSum `json:"sum"`
+	// The ends of a --bidir test's streams from the server to the client
+	SumSentBidirReverse     *iperf3JSONSum `json:"sum_sent_bidir_reverse"`
+	SumReceivedBidirReverse *iperf3JSONSum `json:"sum_received_bidir_reverse"`
+	CPU                     *struct {
+		HostTotal   float64 `json:"host_total"`
+		RemoteTotal float64 `json:"remote_total"`
+	} `json:"cpu_utilization_percent"`
+}
+
+// iperf3JSONEvent is a line of --json-stream output
+type iperf3JSONEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// NewJSONParser creates a JSONParser.
+func NewJSONParser() *JSONParser {
+	return &JSONParser{
+		// Errors iperf3 prints on stderr, outside the JSON
+		reError: regexp.MustCompile(
+			`^(?:iperf3: (?:error - )?|error - )(.+)$`),
+
+		// --debug only, as in text output
+		reDenied: regexp.MustCompile(
+			`ACCESS_DENIED to an unsolicited connection request`),
+		reBusy: regexp.MustCompile(
+			`the server is busy running a test`),
+
+		sessionState: sessionState{protocol: models.ProtocolTCP, precision: models.PrecisionExact},
+	}
+}
+
+// ParseLine parses a single line of iperf3 JSON output and returns a result.
+func (p *JSONParser) ParseLine(line string) ParseResult {
+	line = strings.TrimRight(line, "\r\n")
+
+	// Client parameters printed by --debug, up to the closing brace
+	if p.inParams {
+		if p.params.Len() > 0 || strings.HasPrefix(line, "{") {
+			p.params.WriteString(line)
+			p.params.WriteByte('\n')
+			if line == "}" {
+				p.parseClientParameters(p.params.String())
+				p.inParams = false
+			}
+			return ParseResult{Event: EventNone}
+		}
+		p.inParams = false
+	}
+	if line == "get_parameters:" {
+		p.inParams = true
+		p.params.Reset()
+		return ParseResult{Event: EventNone}
+	}
+
+	// A -J test's object, from its opening to its closing brace
+	if p.inTest || line == "{" {
+		p.inTest = true
+		p.test.WriteString(line)
+		p.test.WriteByte('\n')
+		if line != "}" {
+			return ParseResult{Event: EventNone}
+		}
+		p.inTest = false
+		data := p.test.String()
+		p.test.Reset()
+		var test iperf3JSON
+		if err := json.Unmarshal([]byte(data), &test); err != nil {
+			return p.fail("unreadable iperf3 JSON output: " + err.Error())
+		}
+		return p.parseTest(test)
+	}
+
+	// A --json-stream event
+	if strings.HasPrefix(line, "{") {
+		var event iperf3JSONEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return p.fail("unreadable iperf3 JSON output: " + err.Error())
+		}
+		return p.parseEvent(event)
+	}
+
+	if p.reDenied.MatchString(line) || p.reBusy.MatchString(line) {
+		return ParseResult{
+			Event: EventCollision,
+			Collision: &models.Collision{
+				Timestamp:     time.Now(),
+				BusySessionID: p.id,
+				BusyClientIP:  p.clientIP,
+			},
+		}
+	}
+
+	if m := p.reError.FindStringSubmatch(line); m != nil {
+		return p.fail(m[1])
+	}
+	return ParseResult{Event: EventNone}
+}
+
+// parseTest turns a -J test's object into its result, or into an error for
+// a connection that failed before its test started.
+func (p *JSONParser) parseTest(test iperf3JSON) ParseResult {
+	if len(test.Start.Connected) == 0 && test.Start.AcceptedConnection == nil {
+		if test.Error == "" {
+			return ParseResult{Event: EventNone}
+		}
+		return p.fail(test.Error)
+	}
+	p.begin(test.Start)
+	for _, interval := range test.Intervals {
+		p.interval(interval.total())
+	}
+	if test.Error != "" {
+		return p.fail(test.Error)
+	}
+	return p.complete(test.End)
+}
+
+// parseEvent handles a --json-stream event.
+func (p *JSONParser) parseEvent(event iperf3JSONEvent) ParseResult {
+	switch event.Event {
+	case "start":
+		var start iperf3JSONStart
+		if err := json.Unmarshal(event.Data, &start); err != nil {
+			return ParseResult{Event: EventNone}
+		}
+		p.begin(start)
+		return ParseResult{
+			Event: EventClientConnected,
+			ConnectionEvent: &models.ConnectionEvent{
+				SessionID: p.id,
+				Timestamp: p.startTime(),
+				ClientIP:  p.clientIP,
+				EventType: "connected",
+			},
+		}
+	case "interval":
+		var interval iperf3JSONInterval
+		if err := json.Unmarshal(event.Data, &interval); err != nil || !p.active {
+			return ParseResult{Event: EventNone}
+		}
+		return ParseResult{Event: EventBandwidthUpdate, BandwidthUpdate: p.interval(interval.total())}
+	case "end":
+		var end iperf3JSONEnd
+		if err := json.Unmarshal(event.Data, &end); err != nil || !p.active {
+			return ParseResult{Event: EventNone}
+		}
+		return p.complete(end)
+	case "error":
+		var msg string
+		if err := json.Unmarshal(event.Data, &msg); err != nil {
+			msg = string(event.Data)
+		}
+		return p.fail(msg)
+	}
+	return ParseResult{Event: EventNone}
+}
+
+// begin starts a session from a test's start object. The client parameters
+// printed by --debug before it are kept.
+func (p *JSONParser) begin(start iperf3JSONStart) {
+	p.active = true
+	if p.id == "" {
+		p.newSession()
+	}
+	if len(start.Connected) > 0 {
+		p.clientIP = clientAddress(start.Connected[0].RemoteHost)
+		p.clientPort = start.Connected[0].RemotePort
+	} else if c := start.AcceptedConnection; c != nil {
+		p.clientIP = clientAddress(c.Host)
+		p.clientPort = c.Port
+	}
+	if start.Timestamp.Timesecs > 0 {
+		p.started = time.Unix(start.Timestamp.Timesecs, 0).UTC()
+	}
+
+	ts := start.TestStart
+	if ts == nil {
+		return
+	}
+	fp := p.fingerprint()
+	fp.Protocol = models.Protocol(strings.ToLower(ts.Protocol))
+	fp.Streams = ts.NumStreams
+	fp.BlockSize = ts.Blksize
+	fp.Omit = ts.Omit
+	fp.Duration = ts.Duration
+	fp.Bytes = ts.Bytes
+	fp.Blocks = ts.Blocks
+	fp.TOS = ts.Tos
+	if fp.MSS == 0 {
+		fp.MSS = start.TCPMSSDefault
+	}
+	if ts.Duration > 0 {
+		p.requested = float64(ts.Duration)
+	}
+	if fp.Protocol == models.ProtocolUDP {
+		p.protocol = models.ProtocolUDP
+	}
+	p.reverse = ts.Reverse != 0
+	p.bidir = ts.Bidir != 0
+}
+
+// interval records the sum of an interval's streams in both directions. Omitted intervals are
+// reported but left out of the result, as iperf3 leaves them out of its end.
+func (p *JSONParser) interval(sum iperf3JSONSum) *models.BandwidthUpdate {
+	if sum.Omitted {
+		p.recordOmitted(sum.Start, sum.End)
+	} else {
+		p.recordInterval(sum.Start, sum.End, sum.Bytes, sum.BitsPerSecond)
+	}
+	return &models.BandwidthUpdate{
+		SessionID:     p.id,
+		Timestamp:     p.intervalTime(sum.End),
+		IntervalStart: sum.Start,
+		IntervalEnd:   sum.End,
+		Bytes:         sum.Bytes,
+		BitsPerSecond: sum.BitsPerSecond,
+		Omitted:       sum.Omitted,
+	}
+}
+
+// complete builds the session's result from a test's end object. The
+// server's own end describes the test: the receiver of an upload and the
+// sender of a download. A --bidir test's server is both, and the test is
+// described by the two together. iperf3 before 3.7 reports the end the
+// server was not as zero bytes; that end is dropped, as in text output.
+// Minimum and maximum come from the intervals.
+func (p *JSONParser) complete(end iperf3JSONEnd) ParseResult {
+	result := &models.TestResult{
+		ID:         p.takeID(),
+		Timestamp:  p.startTime(),
+		ClientIP:   p.clientIP,
+		ClientPort: p.clientPort,
+		Protocol:   p.protocol,
+		Direction:  "upload",
+		Status:     models.TestStatusCompleted,
+		Precision:  p.precision,
+	}
+	sent, received := end.SumSent, end.SumReceived
+	own := received
+	switch {
+	case p.bidir:
+		// The server received the streams from the client and sent the
+		// reverse ones
+		result.Direction = "bidirectional"
+		sent = end.SumSentBidirReverse
+		own = bothWays(received, sent)
+	case p.reverse:
+		result.Direction = "download"
+		own = sent
+	}
+	if own == nil {
+		own = end.Sum
+	}
+	if own == nil {
+		own = &iperf3JSONSum{}
+	}
+	if s := sent; s != nil && (s.Bytes > 0 || p.reverse || p.bidir) {
+		result.Sender = &models.SideStats{Bytes: s.Bytes, Bandwidth: s.BitsPerSecond, Retransmits: s.Retransmits}
+		if s.Retransmits != nil {
+			retransmits := *s.Retransmits
+			result.Retransmits = &retransmits
+		}
+	}
+	if s := received; s != nil && (s.Bytes > 0 || !p.reverse) {
+		result.Receiver = &models.SideStats{Bytes: s.Bytes, Bandwidth: s.BitsPerSecond}
+	}
+	if result.Sender == nil && result.Receiver == nil {
+		side := &models.SideStats{Bytes: own.Bytes, Bandwidth: own.BitsPerSecond}
+		if p.reverse {
+			result.Sender = side
+		} else {
+			result.Receiver = side
+		}
+	}
+
+	result.Duration = own.End - own.Start
+	result.BytesTransferred = own.Bytes
+	result.AvgBandwidth = own.BitsPerSecond
+	if p.counted > 0 {
+		result.MinBandwidth = p.minBandwidth
+		result.MaxBandwidth = p.maxBandwidth
+	} else {
+		result.MinBandwidth = own.BitsPerSecond
+		result.MaxBandwidth = own.BitsPerSecond
+	}
+	if avg, ok := p.warmupAverage(); ok {
+		result.AvgBandwidth = avg
+	}
+
+	if p.protocol == models.ProtocolUDP {
+		udp := end.Sum
+		if udp == nil {
+			udp = own
+		}
+		result.Jitter = udp.JitterMs
+		result.PacketLoss = udp.LostPercent
+	}
+	if cpu := end.CPU; cpu != nil {
+		host, remote := cpu.HostTotal, cpu.RemoteTotal
+		result.HostCPUTotal = &host
+		result.RemoteCPUTotal = &remote
+	}
+	p.setRequested(result)
+	p.setClient(result)
+
+	p.resetSession()
+	return ParseResult{Event: EventTestComplete, TestResult: result}
+}
+
+// fail ends the session in progress as failed; between tests the error is
+// reported on its own.
+func (p *JSONParser) fail(msg string) ParseResult {
+	if !p.active {
+		p.resetSession()
+		return ParseResult{Event: EventError, ErrorMessage: msg}
+	}
+	result := p.AbortSession(models.TestStatusFailed, msg)
+	if p.bidir {
+		result.Direction = "bidirectional"
+	} else if p.reverse {
+		result.Direction = "download"
+	}
+	p.resetSession()
+	return ParseResult{Event: EventTestComplete, TestResult: result, ErrorMessage: msg}
+}
+
+// resetSession clears per-test state for the next test session.
+func (p *JSONParser) resetSession() {
+	p.sessionState.reset()
+	p.reverse = false
+	p.bidir = false
+}
+
+// bothWays returns the total of a --bidir test's two directions, over the
+// first's interval.
+func bothWays(a, b *iperf3JSONSum) *iperf3JSONSum {
+	if a == nil || b == nil {
+		if a == nil {
+			return b
+		}
+		return a
+	}
+	sum := *a
+	sum.Bytes += b.Bytes
+	sum.BitsPerSecond += b.BitsPerSecond
+	return &sum
+}
@@ -0,0 +1,125 @@
+package iperf
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// parseJSONFile runs a testdata/json file through a JSONParser and returns
+// the results of every line.
+func parseJSONFile(t *testing.T, p *JSONParser, name string) []ParseResult {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "json", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var results []ParseResult
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r := p.ParseLine(scanner.Text()); r.Event != EventNone {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+func TestJSONParser_Test(t *testing.T) {
+	p := NewJSONParser()
+	// Client parameters printed by --debug before the test's object are kept
+	for _, line := range []string{"get_parameters:", "{", `	"tcp":	true,`, `	"time":	3,`, `	"client_version":	"3.12"`, "}"} {
+		p.ParseLine(line)
+	}
+	results := parseJSONFile(t, p, "3.12-tcp-upload.json")
+	if len(results) != 2 || results[0].Event != EventTestComplete || results[1].Event != EventError {
+		t.Fatalf("results = %+v, want a result then an error", results)
+	}
+
+	r := results[0].TestResult
+	if r.ID == "" || r.ClientIP != "10.0.0.1" || r.ClientPort != 50001 || r.Direction != "upload" || r.Status != models.TestStatusCompleted {
+		t.Errorf("result = %+v", r)
+	}
+	if !r.Timestamp.Equal(time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("timestamp = %s", r.Timestamp)
+	}
+	// Exact figures from the end, extremes from the intervals
+	if r.BytesTransferred != 357302272 || r.Duration != 3.040532 || r.MinBandwidth != 933230000 || r.MaxBandwidth != 958120000 {
+		t.Errorf("bytes %d over %v s, min %v, max %v", r.BytesTransferred, r.Duration, r.MinBandwidth, r.MaxBandwidth)
+	}
+	if r.Precision != models.PrecisionExact {
+		t.Errorf("precision = %q, want exact", r.Precision)
+	}
+	// The sender's zero bytes are what the server did not measure
+	if r.Receiver == nil || r.Receiver.Bytes != r.BytesTransferred || r.Sender != nil {
+		t.Errorf("sender %+v, receiver %+v", r.Sender, r.Receiver)
+	}
+	if r.HostCPUTotal == nil || *r.HostCPUTotal != 5.6 || r.RemoteCPUTotal == nil || *r.RemoteCPUTotal != 12.1 {
+		t.Errorf("cpu %v/%v", r.HostCPUTotal, r.RemoteCPUTotal)
+	}
+	if c := r.Client; c == nil || c.Version != "3.12" || c.MSS != 1448 || c.Duration != 3 || r.RequestedDuration == nil || *r.RequestedDuration != 3 {
+		t.Errorf("client %+v, requested %v", c, r.RequestedDuration)
+	}
+
+	// A connection that failed before its test is only an error
+	if msg := results[1].ErrorMessage; msg != "unable to receive control message: Connection reset by peer" {
+		t.Errorf("error = %q", msg)
+	}
+	if p.InSession() || p.SessionID() != "" {
+		t.Error("session left open")
+	}
+}
+
+func TestJSONParser_Stream(t *testing.T) {
+	p := NewJSONParser()
+	results := parseJSONFile(t, p, "3.17-tcp-reverse.jsonl")
+	want := []ParseEvent{EventClientConnected, EventBandwidthUpdate, EventBandwidthUpdate, EventTestComplete}
+	if len(results) != len(want) {
+		t.Fatalf("got %d events, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Event != want[i] {
+			t.Errorf("event %d = %v, want %v", i, r.Event, want[i])
+		}
+	}
+
+	id := results[0].ConnectionEvent.SessionID
+	if u := results[1].BandwidthUpdate; u.SessionID != id || u.BitsPerSecond != 939524096 || !u.Timestamp.Equal(time.Date(2026, 1, 30, 12, 0, 1, 0, time.UTC)) {
+		t.Errorf("update = %+v", u)
+	}
+	r := results[3].TestResult
+	if r.ID != id || r.Direction != "download" || r.BytesTransferred != 233832448 || r.AvgBandwidth != 935267000 {
+		t.Errorf("result = %+v", r)
+	}
+	if r.Retransmits == nil || *r.Retransmits != 5 || r.Sender == nil || r.Sender.Retransmits == nil {
+		t.Errorf("retransmits %v, sender %+v", r.Retransmits, r.Sender)
+	}
+
+	// An error mid-test fails it with what was measured
+	p.ParseLine(`{"event":"start","data":{"connected":[{"remote_host":"::ffff:10.0.0.9","remote_port":40000}],"test_start":{"protocol":"UDP","duration":10}}}`)
+	p.ParseLine(`{"event":"interval","data":{"sum":{"start":0,"end":1,"bytes":131072,"bits_per_second":1048576,"jitter_ms":0.02,"lost_percent":0}}}`)
+	failed := p.ParseLine(`{"event":"error","data":"the client has unexpectedly closed the connection"}`)
+	if r := failed.TestResult; failed.Event != EventTestComplete || r.Status != models.TestStatusFailed || r.ClientIP != "10.0.0.9" ||
+		r.Protocol != models.ProtocolUDP || r.BytesTransferred != 131072 || r.Precision != models.PrecisionExact || r.ErrorMessage != failed.ErrorMessage {
+		t.Errorf("failed = %+v", failed)
+	}
+}
+
+func TestJSONParser_TextLines(t *testing.T) {
+	p := NewJSONParser()
+	if r := p.ParseLine("iperf3: error - unable to start listener for connections: Address already in use"); r.Event != EventError ||
+		r.ErrorMessage != "unable to start listener for connections: Address already in use" {
+		t.Errorf("error line = %+v", r)
+	}
+	if r := p.ParseLine("successfully sent ACCESS_DENIED to an unsolicited connection request during active test"); r.Event != EventCollision {
+		t.Errorf("collision line = %+v", r)
+	}
+	if r := p.ParseLine(`{"event":`); r.Event != EventError || !strings.HasPrefix(r.ErrorMessage, "unreadable iperf3 JSON output") {
+		t.Errorf("broken line = %+v", r)
+	}
+}
@@ -1,8 +1,10 @@
 package iperf
 
 import (
+	"fmt"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/Tom-Oram/fak/backend/internal/models"
 )
@@ -313,6 +315,55 @@ func TestFullTCPSession(t *testing.T) {
 	}
 }
 
+func TestFullTCPSession_IPv6(t *testing.T) {
+	tests := []struct {
+		name      string
+		accepted  string
+		connected string
+		wantIP    string
+	}{
+		{
+			"link-local with zone",
+			"Accepted connection from fe80::1%eth0, port 5000",
+			"[  5] local fe80::2%eth0 port 5201 connected to fe80::1%eth0 port 5001",
+			"fe80::1%eth0",
+		},
+		{
+			"bracketed",
+			"Accepted connection from [2001:db8::1], port 5000",
+			"[  5] local [2001:db8::2] port 5201 connected to [2001:db8::1] port 5001",
+			"2001:db8::1",
+		},
+		{
+			"IPv4-mapped",
+			"Accepted connection from ::ffff:10.0.0.1, port 5000",
+			"[  5] local ::ffff:10.0.0.2 port 5201 connected to ::ffff:10.0.0.1 port 5001",
+			"10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewTextParser()
+			p.ParseLine("Server listening on 5201")
+			r := p.ParseLine(tt.accepted)
+			if r.Event != EventClientConnected || r.ConnectionEvent.ClientIP != tt.wantIP {
+				t.Fatalf("accepted = %+v, want client %s", r.ConnectionEvent, tt.wantIP)
+			}
+			p.ParseLine(tt.connected)
+			p.ParseLine("[  5]   0.00-1.00   sec  1.10 GBytes  9.45 Gbits/sec")
+			p.ParseLine("- - - - - - - - - - - - -")
+			r = p.ParseLine("[  5]   0.00-1.00   sec  1.10 GBytes  9.45 Gbits/sec                  receiver")
+			if r.Event != EventTestComplete {
+				t.Fatalf("event = %v, want EventTestComplete", r.Event)
+			}
+			if r.TestResult.ClientIP != tt.wantIP || r.TestResult.ClientPort != 5001 {
+				t.Errorf("client = %s port %d, want %s port 5001", r.TestResult.ClientIP, r.TestResult.ClientPort, tt.wantIP)
+			}
+		})
+	}
+}
+
 func TestFullUDPSession(t *testing.T) {
 	p := NewTextParser()
 
@@ -446,3 +497,647 @@ func TestMultipleTestSessions(t *testing.T) {
 		t.Errorf("test 2: ClientIP = %q, want %q", r2.TestResult.ClientIP, "10.0.0.2")
 	}
 }
+
+func TestParseLine_ErrorMidTest_FailsSession(t *testing.T) {
+	p := NewTextParser()
+
+	p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+	p.ParseLine("[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 50001")
+	p.ParseLine("[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec")
+	p.ParseLine("[  5]   1.00-2.00   sec  50.0 MBytes   419 Mbits/sec")
+
+	result := p.ParseLine("iperf3: error - the client has unexpectedly closed the connection")
+
+	if result.Event != EventTestComplete {
+		t.Fatalf("expected EventTestComplete, got %v", result.Event)
+	}
+	if result.ErrorMessage != "the client has unexpectedly closed the connection" {
+		t.Errorf("ErrorMessage = %q", result.ErrorMessage)
+	}
+
+	r := result.TestResult
+	if r.Status != models.TestStatusFailed {
+		t.Errorf("Status = %q, want %q", r.Status, models.TestStatusFailed)
+	}
+	if r.ErrorMessage != result.ErrorMessage {
+		t.Errorf("TestResult.ErrorMessage = %q, want %q", r.ErrorMessage, result.ErrorMessage)
+	}
+	if r.ClientIP != "10.0.0.1" || r.ClientPort != 50001 {
+		t.Errorf("client = %s:%d, want 10.0.0.1:50001", r.ClientIP, r.ClientPort)
+	}
+	if r.Duration != 2.0 {
+		t.Errorf("Duration = %v, want 2.0", r.Duration)
+	}
+	wantBytes := int64(150 * 1024 * 1024)
+	if r.BytesTransferred != wantBytes {
+		t.Errorf("BytesTransferred = %d, want %d", r.BytesTransferred, wantBytes)
+	}
+	if math.Abs(r.MinBandwidth-419e6) > 1.0 || math.Abs(r.MaxBandwidth-839e6) > 1.0 {
+		t.Errorf("Min/Max = %v/%v, want 419e6/839e6", r.MinBandwidth, r.MaxBandwidth)
+	}
+	if p.InSession() {
+		t.Error("session should be finished after failure")
+	}
+}
+
+func TestParseLine_ErrorOutsideSession(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"iperf3: error - unable to start listener for connections: Address already in use",
+			"unable to start listener for connections: Address already in use"},
+		{"iperf3: error - unable to receive control message: Connection reset by peer",
+			"unable to receive control message: Connection reset by peer"},
+		{"iperf3: the client has unexpectedly closed the connection",
+			"the client has unexpectedly closed the connection"},
+		{"iperf3: interrupt - the server has terminated",
+			"interrupt - the server has terminated"},
+		{"error - unable to bind to server address",
+			"unable to bind to server address"},
+	}
+
+	for _, tt := range tests {
+		p := NewTextParser()
+		result := p.ParseLine(tt.line)
+		if result.Event != EventError {
+			t.Errorf("%q: expected EventError, got %v", tt.line, result.Event)
+			continue
+		}
+		if result.ErrorMessage != tt.want {
+			t.Errorf("%q: ErrorMessage = %q, want %q", tt.line, result.ErrorMessage, tt.want)
+		}
+		if result.TestResult != nil {
+			t.Errorf("%q: unexpected result outside a session", tt.line)
+		}
+	}
+}
+
+func TestParseLine_ErrorWithoutPrefixFailsSession(t *testing.T) {
+	p := NewTextParser()
+	p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+	p.ParseLine("[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec")
+
+	result := p.ParseLine("iperf3: the client has unexpectedly closed the connection")
+
+	if result.Event != EventTestComplete {
+		t.Fatalf("expected EventTestComplete, got %v", result.Event)
+	}
+	if result.TestResult.Status != models.TestStatusFailed {
+		t.Errorf("Status = %q, want %q", result.TestResult.Status, models.TestStatusFailed)
+	}
+	if result.ErrorMessage != "the client has unexpectedly closed the connection" {
+		t.Errorf("ErrorMessage = %q", result.ErrorMessage)
+	}
+}
+
+func TestParseLine_CompletedSessionStatus(t *testing.T) {
+	p := NewTextParser()
+
+	p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+	p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec")
+	if !p.InSession() {
+		t.Fatal("expected session in progress after interval")
+	}
+
+	p.ParseLine("- - - - - - - - - - - - -")
+	result := p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  receiver")
+
+	if result.TestResult.Status != models.TestStatusCompleted {
+		t.Errorf("Status = %q, want %q", result.TestResult.Status, models.TestStatusCompleted)
+	}
+	if p.InSession() {
+		t.Error("session should be finished after summary")
+	}
+
+	// An error after the summary is reported, but must not produce a second
+	// result
+	r := p.ParseLine("iperf3: error - the client has unexpectedly closed the connection")
+	if r.Event != EventError || r.TestResult != nil {
+		t.Errorf("expected EventError without a result after completed session, got %v", r.Event)
+	}
+}
+
+func TestAbortSession_NoIntervals(t *testing.T) {
+	p := NewTextParser()
+	p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+
+	r := p.AbortSession(models.TestStatusAborted, "server stopped during test")
+
+	if r.Status != models.TestStatusAborted {
+		t.Errorf("Status = %q, want %q", r.Status, models.TestStatusAborted)
+	}
+	if r.ClientIP != "10.0.0.1" {
+		t.Errorf("ClientIP = %q, want %q", r.ClientIP, "10.0.0.1")
+	}
+	if r.AvgBandwidth != 0 || r.BytesTransferred != 0 {
+		t.Errorf("expected zero throughput, got avg=%v bytes=%d", r.AvgBandwidth, r.BytesTransferred)
+	}
+}
+
+func TestParseLine_StartingTest_RecordsRequestedDuration(t *testing.T) {
+	p := NewTextParser()
+
+	p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+	p.ParseLine("Starting Test: protocol: TCP, 1 streams, 131072 byte blocks, omitting 0 seconds, 30 second test, tos 0")
+	p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec")
+	p.ParseLine("- - - - - - - - - - - - -")
+	result := p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  receiver")
+
+	if result.TestResult.RequestedDuration == nil {
+		t.Fatal("RequestedDuration is nil")
+	}
+	if *result.TestResult.RequestedDuration != 30 {
+		t.Errorf("RequestedDuration = %v, want 30", *result.TestResult.RequestedDuration)
+	}
+
+	p.ParseLine("Server listening on 5201")
+	if p.requested != 0 {
+		t.Errorf("requested = %v after reset, want 0", p.requested)
+	}
+}
+
+func TestParseLine_VerboseFingerprint(t *testing.T) {
+	p := NewTextParser()
+
+	p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+	p.ParseLine("      Cookie: abcdefghijklmnopqrstuvwxyz234567abcd")
+	p.ParseLine("      TCP MSS: 1448 (default)")
+	p.ParseLine("[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 50002")
+	p.ParseLine("Starting Test: protocol: TCP, 4 streams, 131072 byte blocks, omitting 2 seconds, 1073741824 bytes to send, tos 16")
+	p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec")
+	p.ParseLine("- - - - - - - - - - - - -")
+	result := p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  receiver")
+
+	fp := result.TestResult.Client
+	if fp == nil {
+		t.Fatal("Client fingerprint is nil")
+	}
+	want := models.ClientFingerprint{
+		Protocol:  models.ProtocolTCP,
+		Streams:   4,
+		BlockSize: 131072,
+		Omit:      2,
+		Bytes:     1073741824,
+		TOS:       16,
+		MSS:       1448,
+	}
+	if fp.Protocol != want.Protocol || fp.Streams != want.Streams || fp.BlockSize != want.BlockSize ||
+		fp.Omit != want.Omit || fp.Bytes != want.Bytes || fp.TOS != want.TOS || fp.MSS != want.MSS || fp.Duration != 0 {
+		t.Errorf("fingerprint = %+v, want %+v", *fp, want)
+	}
+	if result.TestResult.RequestedDuration != nil {
+		t.Errorf("RequestedDuration = %v for a byte-limited test, want nil", *result.TestResult.RequestedDuration)
+	}
+
+	p.ParseLine("Server listening on 5201")
+	if p.client != nil {
+		t.Error("fingerprint not reset for the next test")
+	}
+}
+
+func TestParseLine_DebugClientParameters(t *testing.T) {
+	p := NewTextParser()
+
+	lines := []string{
+		"Accepted connection from 10.0.0.1, port 50000",
+		"get_parameters:",
+		"{",
+		"\t\"udp\":\ttrue,",
+		"\t\"omit\":\t0,",
+		"\t\"time\":\t20,",
+		"\t\"num\":\t0,",
+		"\t\"blockcount\":\t0,",
+		"\t\"parallel\":\t2,",
+		"\t\"reverse\":\ttrue,",
+		"\t\"len\":\t1400,",
+		"\t\"bandwidth\":\t100000000,",
+		"\t\"pacing_timer\":\t1000,",
+		"\t\"udp_counters_64bit\":\t1,",
+		"\t\"client_version\":\t\"3.16\"",
+		"}",
+		"Starting Test: protocol: UDP, 2 streams, 1400 byte blocks, omitting 0 seconds, 20 second test, tos 0",
+	}
+	for _, line := range lines {
+		if r := p.ParseLine(line); r.Event != EventNone && r.Event != EventClientConnected {
+			t.Errorf("line %q produced event %v", line, r.Event)
+		}
+	}
+	result := p.AbortSession(models.TestStatusAborted, "stopped")
+
+	fp := result.Client
+	if fp == nil {
+		t.Fatal("Client fingerprint is nil")
+	}
+	if fp.Version != "3.16" || fp.Protocol != models.ProtocolUDP || fp.Streams != 2 || fp.Duration != 20 || fp.Bandwidth != 100000000 {
+		t.Errorf("fingerprint = %+v", *fp)
+	}
+	if len(fp.Features) != 2 || fp.Features[0] != "reverse" || fp.Features[1] != "udp_counters_64bit" {
+		t.Errorf("Features = %v, want [reverse udp_counters_64bit]", fp.Features)
+	}
+	if result.RequestedDuration == nil || *result.RequestedDuration != 20 {
+		t.Errorf("RequestedDuration = %v, want 20", result.RequestedDuration)
+	}
+}
+
+func TestParseLine_Collision(t *testing.T) {
+	p := NewTextParser()
+
+	conn := p.ParseLine("Accepted connection from 10.0.0.1, port 50000")
+	p.ParseLine("[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 50001")
+
+	for _, line := range []string{
+		"successfully sent ACCESS_DENIED to an unsolicited connection request during active test",
+		"failed to send ACCESS_DENIED to an unsolicited connection request during active test",
+		"iperf3: error - the server is busy running a test. try again later",
+	} {
+		r := p.ParseLine(line)
+		if r.Event != EventCollision {
+			t.Fatalf("line %q produced event %v, want EventCollision", line, r.Event)
+		}
+		if r.Collision.BusySessionID != conn.ConnectionEvent.SessionID || r.Collision.BusyClientIP != "10.0.0.1" {
+			t.Errorf("collision = %+v, want busy session %s from 10.0.0.1", *r.Collision, conn.ConnectionEvent.SessionID)
+		}
+	}
+
+	if !p.InSession() || p.SessionID() != conn.ConnectionEvent.SessionID {
+		t.Error("collision disturbed the session in progress")
+	}
+}
+
+func TestTextParser_SessionIDs(t *testing.T) {
+	p := NewTextParser()
+
+	p.ParseLine("Server listening on 5201")
+	conn := p.ParseLine("Accepted connection from 192.168.1.10, port 45678")
+	id := conn.ConnectionEvent.SessionID
+	if id == "" {
+		t.Fatal("ClientConnected has no session ID")
+	}
+	if got := p.SessionID(); got != id {
+		t.Errorf("SessionID() = %q, want %q", got, id)
+	}
+
+	bw := p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec")
+	if bw.BandwidthUpdate.SessionID != id {
+		t.Errorf("BandwidthUpdate.SessionID = %q, want %q", bw.BandwidthUpdate.SessionID, id)
+	}
+
+	p.ParseLine("- - - - - - - - - - - - -")
+	first := p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  sender")
+	if first.TestResult.ID != id {
+		t.Errorf("result ID = %q, want the session ID %q", first.TestResult.ID, id)
+	}
+	// A second summary line must not reuse the ID of the saved result
+	second := p.ParseLine("[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  receiver")
+	if second.Event == EventTestComplete && second.TestResult.ID != "" {
+		t.Errorf("second result ID = %q, want empty", second.TestResult.ID)
+	}
+
+	p.ParseLine("Server listening on 5201")
+	next := p.ParseLine("Accepted connection from 192.168.1.10, port 45680")
+	if next.ConnectionEvent.SessionID == "" || next.ConnectionEvent.SessionID == id {
+		t.Errorf("next session ID = %q, want a new ID", next.ConnectionEvent.SessionID)
+	}
+}
+
+func TestTextParser_IDGenerator(t *testing.T) {
+	p := NewTextParser()
+	n := 0
+	p.newID = func() string {
+		n++
+		return fmt.Sprintf("session-%d", n)
+	}
+
+	for _, want := range []string{"session-1", "session-2"} {
+		p.ParseLine("Server listening on 5201")
+		conn := p.ParseLine("Accepted connection from 192.168.1.10, port 45678")
+		if got := conn.ConnectionEvent.SessionID; got != want {
+			t.Errorf("SessionID = %q, want %q from the generator", got, want)
+		}
+	}
+}
+
+func TestParseLine_TimestampsFromIperfClock(t *testing.T) {
+	p := NewTextParser()
+	start := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	p.ParseLine("Server listening on 5201")
+	p.ParseLine("Time: Sat, 31 Jan 2026 12:00:00 GMT")
+	conn := p.ParseLine("Accepted connection from 10.0.0.1, port 45678")
+	if !conn.ConnectionEvent.Timestamp.Equal(start) {
+		t.Errorf("connection timestamp = %v, want %v", conn.ConnectionEvent.Timestamp, start)
+	}
+	p.ParseLine("[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45679")
+	update := p.ParseLine("[  5]   1.00-2.50   sec  2.50 GBytes  21.5 Gbits/sec")
+	if want := start.Add(2500 * time.Millisecond); !update.BandwidthUpdate.Timestamp.Equal(want) {
+		t.Errorf("interval timestamp = %v, want %v", update.BandwidthUpdate.Timestamp, want)
+	}
+	p.ParseLine("- - - - - - - - - - - - -")
+	result := p.ParseLine("[  5]   0.00-2.50   sec  2.50 GBytes  21.5 Gbits/sec                  receiver")
+	if !result.TestResult.Timestamp.Equal(start) {
+		t.Errorf("result timestamp = %v, want %v", result.TestResult.Timestamp, start)
+	}
+
+	// The next session without a reported time falls back to the clock
+	p.ParseLine("Server listening on 5201")
+	p.ParseLine("Accepted connection from 10.0.0.1, port 45680")
+	before := time.Now()
+	aborted := p.AbortSession(models.TestStatusAborted, "gone")
+	if aborted.Timestamp.Before(before) {
+		t.Errorf("timestamp %v carried over from the previous session", aborted.Timestamp)
+	}
+}
+
+func TestParseLine_VerboseCPUUtilization(t *testing.T) {
+	p := NewTextParser()
+	session := []string{
+		"Server listening on 5201",
+		"Accepted connection from 10.0.0.1, port 45678",
+		"[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45679",
+		"[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec",
+		"- - - - - - - - - - - - -",
+		"Test Complete. Summary Results:",
+		"[ ID] Interval           Transfer     Bitrate",
+		"[  5] (sender statistics not available)",
+		"[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  receiver",
+	}
+	for _, line := range session {
+		if got := p.ParseLine(line); got.Event == EventTestComplete {
+			t.Fatalf("ParseLine(%q) completed the test before its CPU utilization", line)
+		}
+	}
+	if !p.InSession() {
+		t.Error("session with a held result not reported in progress")
+	}
+
+	got := p.ParseLine("CPU Utilization: local/receiver 97.5% (3.1%u/94.4%s), remote/sender 12.0% (1.2%u/10.8%s)")
+	if got.Event != EventTestComplete {
+		t.Fatalf("CPU utilization line: event = %v, want EventTestComplete", got.Event)
+	}
+	r := got.TestResult
+	if r.ID == "" || r.HostCPUTotal == nil || *r.HostCPUTotal != 97.5 || r.RemoteCPUTotal == nil || *r.RemoteCPUTotal != 12 {
+		t.Errorf("result = %+v, want host 97.5%% and remote 12%%", r)
+	}
+	if p.InSession() {
+		t.Error("session still in progress after its result")
+	}
+	if got := p.ParseLine("Server listening on 5201"); got.Event != EventNone {
+		t.Errorf("listening after the result: event = %v", got.Event)
+	}
+}
+
+func TestParseLine_VerboseWithoutCPUUtilization(t *testing.T) {
+	// A summary whose CPU utilization never comes completes with the next
+	// listening line, or when the session is aborted
+	for _, end := range []string{"listening", "abort"} {
+		p := NewTextParser()
+		for _, line := range []string{
+			"Accepted connection from 10.0.0.1, port 45678",
+			"- - - - - - - - - - - - -",
+			"Test Complete. Summary Results:",
+			"[  5]   0.00-1.00   sec  2.47 GBytes  21.2 Gbits/sec                  receiver",
+		} {
+			p.ParseLine(line)
+		}
+
+		var r *models.TestResult
+		if end == "listening" {
+			r = p.ParseLine("Server listening on 5201 (test #2)").TestResult
+		} else {
+			r = p.AbortSession(models.TestStatusFailed, "iperf3 exited unexpectedly")
+		}
+		if r == nil || r.Status != models.TestStatusCompleted || r.BytesTransferred == 0 || r.HostCPUTotal != nil {
+			t.Errorf("%s: result = %+v, want the completed summary", end, r)
+		}
+		if p.InSession() {
+			t.Errorf("%s: session still in progress", end)
+		}
+	}
+}
+
+func TestParseLine_BothEnds(t *testing.T) {
+	p := NewTextParser()
+	for _, line := range []string{
+		"Accepted connection from 10.0.0.1, port 45678",
+		"[  5]   0.00-1.00   sec   112 MBytes   941 Mbits/sec    3    395 KBytes",
+		"- - - - - - - - - - - - -",
+		"Test Complete. Summary Results:",
+		"[ ID] Interval           Transfer     Bitrate         Retr",
+		"[  5]   0.00-10.00  sec  1.00 GBytes   859 Mbits/sec   12             sender",
+		"[  7]   0.00-10.00  sec  1.00 GBytes   859 Mbits/sec    3             sender",
+		"[  5]   0.00-10.04  sec  0.50 GBytes   428 Mbits/sec                  receiver",
+		"[  7]   0.00-10.04  sec  0.50 GBytes   428 Mbits/sec                  receiver",
+		"[SUM]   0.00-10.00  sec  2.00 GBytes  1.72 Gbits/sec   15             sender",
+	} {
+		if got := p.ParseLine(line); got.Event != EventNone && got.Event != EventClientConnected && got.Event != EventBandwidthUpdate {
+			t.Fatalf("ParseLine(%q): event = %v", line, got.Event)
+		}
+	}
+
+	got := p.ParseLine("CPU Utilization: local/sender 5.0% (0.5%u/4.5%s), remote/receiver 9.0% (1.0%u/8.0%s)")
+	r := got.TestResult
+	if r == nil || r.Direction != "download" {
+		t.Fatalf("result = %+v, want a download", r)
+	}
+	if r.Sender == nil || r.Sender.Bytes != 2<<30 || r.Sender.Bandwidth != 1718e6 ||
+		r.Sender.Retransmits == nil || *r.Sender.Retransmits != 15 {
+		t.Errorf("sender = %+v, want both streams summed", r.Sender)
+	}
+	if r.Receiver == nil || r.Receiver.Bytes != 1<<30 || r.Receiver.Bandwidth != 856e6 || r.Receiver.Retransmits != nil {
+		t.Errorf("receiver = %+v", r.Receiver)
+	}
+	if r.Retransmits == nil || *r.Retransmits != 15 {
+		t.Errorf("Retransmits = %v, want the sender's 15", r.Retransmits)
+	}
+}
+
+func TestParseLine_Parallel(t *testing.T) {
+	p := NewTextParser()
+	var updates int
+	for _, line := range []string{
+		"Accepted connection from 10.0.0.1, port 45678",
+		"[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45680",
+		"[  7] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45682",
+		"[ ID] Interval           Transfer     Bitrate",
+		"[  5]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec",
+		"[  7]   0.00-1.00   sec  60.0 MBytes   503 Mbits/sec",
+		"[SUM]   0.00-1.00   sec   110 MBytes   923 Mbits/sec",
+		"- - - - - - - - - - - - -",
+		"[  5]   1.00-2.00   sec  40.0 MBytes   336 Mbits/sec",
+		"[  7]   1.00-2.00   sec  50.0 MBytes   419 Mbits/sec",
+		"[SUM]   1.00-2.00   sec  90.0 MBytes   755 Mbits/sec",
+		"- - - - - - - - - - - - -",
+		"[ ID] Interval           Transfer     Bitrate",
+		"[  5]   0.00-2.00   sec  90.0 MBytes   377 Mbits/sec                  receiver",
+		"[  7]   0.00-2.00   sec   110 MBytes   461 Mbits/sec                  receiver",
+		"[SUM]   0.00-2.00   sec   200 MBytes   839 Mbits/sec                  receiver",
+	} {
+		got := p.ParseLine(line)
+		switch got.Event {
+		case EventBandwidthUpdate:
+			updates++
+		case EventNone, EventClientConnected:
+		default:
+			t.Fatalf("ParseLine(%q): event = %v, want the result held for the other streams", line, got.Event)
+		}
+	}
+	if updates != 4 {
+		t.Errorf("bandwidth updates = %d, want one per stream and interval", updates)
+	}
+
+	r := p.ParseLine("Server listening on 5201 (test #2)").TestResult
+	if r == nil {
+		t.Fatal("no result")
+	}
+	if r.BytesTransferred != 200<<20 || r.AvgBandwidth != 839e6 || r.ClientPort != 45680 {
+		t.Errorf("result = %+v, want the [SUM] line's totals", r)
+	}
+	if r.MinBandwidth != 755e6 || r.MaxBandwidth != 922e6 {
+		t.Errorf("min/max = %v/%v, want those of the streams summed per interval", r.MinBandwidth, r.MaxBandwidth)
+	}
+	if r.Receiver == nil || r.Receiver.Bytes != 200<<20 {
+		t.Errorf("receiver = %+v, want both streams summed", r.Receiver)
+	}
+}
+
+func TestParseLine_BidirParallel(t *testing.T) {
+	p := NewTextParser()
+	for _, line := range []string{
+		"Accepted connection from 10.0.0.1, port 45678",
+		"[  5] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45680",
+		"[  7] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45682",
+		"[  9] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45684",
+		"[ 11] local 10.0.0.2 port 5201 connected to 10.0.0.1 port 45686",
+		"[ ID][Role] Interval           Transfer     Bitrate         Retr  Cwnd",
+		"[  5][RX-S]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec",
+		"[  7][RX-S]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec",
+		"[SUM][RX-S]   0.00-1.00   sec   100 MBytes   839 Mbits/sec",
+		"[  9][TX-S]   0.00-1.00   sec  25.0 MBytes   210 Mbits/sec    1   1.12 MBytes",
+		"[ 11][TX-S]   0.00-1.00   sec  25.0 MBytes   210 Mbits/sec    2   1.04 MBytes",
+		"[SUM][TX-S]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec    3",
+		"- - - - - - - - - - - - -",
+		"[ ID][Role] Interval           Transfer     Bitrate         Retr",
+		"[  5][RX-S]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec                  receiver",
+		"[  7][RX-S]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec                  receiver",
+		"[SUM][RX-S]   0.00-1.00   sec   100 MBytes   839 Mbits/sec                  receiver",
+		"[  9][TX-S]   0.00-1.00   sec  25.0 MBytes   210 Mbits/sec    1             sender",
+		"[ 11][TX-S]   0.00-1.00   sec  25.0 MBytes   210 Mbits/sec    2             sender",
+		"[SUM][TX-S]   0.00-1.00   sec  50.0 MBytes   419 Mbits/sec    3             sender",
+	} {
+		if got := p.ParseLine(line); got.Event == EventTestComplete {
+			t.Fatalf("ParseLine(%q): result before the session ended", line)
+		}
+	}
+
+	r := p.ParseLine("Server listening on 5201 (test #2)").TestResult
+	if r == nil || r.Direction != "bidirectional" {
+		t.Fatalf("result = %+v, want a bidirectional test", r)
+	}
+	if r.Receiver == nil || r.Receiver.Bytes != 100<<20 || r.Sender == nil || r.Sender.Bytes != 50<<20 ||
+		r.Retransmits == nil || *r.Retransmits != 3 {
+		t.Errorf("receiver = %+v, sender = %+v, want each half's streams summed once", r.Receiver, r.Sender)
+	}
+	if r.BytesTransferred != 150<<20 || r.AvgBandwidth != 1258e6 || r.MaxBandwidth != 1258e6 {
+		t.Errorf("result = %+v, want both directions together", r)
+	}
+}
+
+func TestParseLine_OmittedIntervals(t *testing.T) {
+	p := NewTextParser()
+	p.ParseLine("Time: Sat, 31 Jan 2026 12:00:00 GMT")
+	p.ParseLine("Accepted connection from 10.0.0.1, port 45678")
+
+	omitted := p.ParseLine("[  5]   0.00-1.00   sec  10.0 MBytes  83.9 Mbits/sec                  (omitted)")
+	if omitted.Event != EventBandwidthUpdate || !omitted.BandwidthUpdate.Omitted {
+		t.Fatalf("omitted interval = %+v", omitted.BandwidthUpdate)
+	}
+	// The interval clock restarts after the omit period
+	update := p.ParseLine("[  5]   0.00-1.00   sec   100 MBytes   839 Mbits/sec")
+	if update.BandwidthUpdate.Omitted {
+		t.Error("interval after the omit period marked omitted")
+	}
+	if want := time.Date(2026, 1, 31, 12, 0, 2, 0, time.UTC); !update.BandwidthUpdate.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %v, want %v", update.BandwidthUpdate.Timestamp, want)
+	}
+	p.ParseLine("[  5]   1.00-2.00   sec   120 MBytes  1007 Mbits/sec")
+
+	r := p.AbortSession(models.TestStatusFailed, "gone")
+	if r.MinBandwidth != 839e6 || r.MaxBandwidth != 1007e6 || r.BytesTransferred != 220<<20 || r.Duration != 2 {
+		t.Errorf("result = %+v, want the omitted interval left out", r)
+	}
+}
+
+func TestParseLine_Warmup(t *testing.T) {
+	p := NewTextParser()
+	p.warmup = 1
+	for _, line := range []string{
+		"Accepted connection from 10.0.0.1, port 45678",
+		"[  5]   0.00-1.00   sec  10.0 MBytes  83.9 Mbits/sec",
+		"[  5]   1.00-2.00   sec   100 MBytes   839 Mbits/sec",
+		"[  5]   2.00-3.00   sec   120 MBytes  1007 Mbits/sec",
+		"- - - - - - - - - - - - -",
+	} {
+		p.ParseLine(line)
+	}
+	r := p.ParseLine("[  5]   0.00-3.00   sec   230 MBytes   643 Mbits/sec                  receiver").TestResult
+	if r.MinBandwidth != 839e6 || r.MaxBandwidth != 1007e6 {
+		t.Errorf("min/max = %v/%v, want the first second left out", r.MinBandwidth, r.MaxBandwidth)
+	}
+	if want := float64(220<<20) * 8 / 2; r.AvgBandwidth != want {
+		t.Errorf("AvgBandwidth = %v, want %v over the last two seconds", r.AvgBandwidth, want)
+	}
+	if r.BytesTransferred != 230<<20 || r.Duration != 3 {
+		t.Errorf("totals = %d bytes over %vs, want the whole test", r.BytesTransferred, r.Duration)
+	}
+}
+
+func TestParseLine_CorrectsRoundedBytes(t *testing.T) {
+	lines := []string{"Accepted connection from 10.0.0.1, port 45678"}
+	for i := 0; i < 10; i++ {
+		lines = append(lines, fmt.Sprintf("[  5]   %d.00-%d.00   sec   112 MBytes   940 Mbits/sec", i, i+1))
+	}
+	lines = append(lines, "- - - - - - - - - - - - -")
+
+	tests := []struct {
+		name          string
+		summary       string
+		wantBytes     int64
+		wantPrecision models.Precision
+	}{
+		// The intervals' 1120 MBytes is within the summary's rounding and
+		// rounded less: ten steps of 1 MByte against one of 10.24
+		{"corrected", "[  5]   0.00-10.00  sec  1.09 GBytes   940 Mbits/sec                  receiver", 1120 << 20, models.PrecisionCorrected},
+		// The summary's last digit is finer than the intervals'
+		{"finer summary", "[  5]   0.00-10.00  sec  1120 MBytes   940 Mbits/sec                  receiver", 1120 << 20, models.PrecisionRounded},
+		// One stream of a parallel test disagrees with the summary
+		{"disagrees", "[  5]   0.00-10.00  sec  2.19 GBytes  1.88 Gbits/sec                  receiver", int64(convertBytes(2.19, "GBytes")), models.PrecisionRounded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewTextParser()
+			for _, line := range lines {
+				p.ParseLine(line)
+			}
+			r := p.ParseLine(tt.summary).TestResult
+			if r == nil {
+				t.Fatal("no result")
+			}
+			if r.BytesTransferred != tt.wantBytes || r.Precision != tt.wantPrecision {
+				t.Errorf("bytes = %d (%s), want %d (%s)", r.BytesTransferred, r.Precision, tt.wantBytes, tt.wantPrecision)
+			}
+			if r.Receiver == nil || r.Receiver.Bytes != r.BytesTransferred {
+				t.Errorf("receiver = %+v, want the result's bytes", r.Receiver)
+			}
+		})
+	}
+
+	// A test cut short has only its rounded intervals
+	p := NewTextParser()
+	for _, line := range lines[:3] {
+		p.ParseLine(line)
+	}
+	if r := p.AbortSession(models.TestStatusFailed, "gone"); r.Precision != models.PrecisionRounded {
+		t.Errorf("aborted precision = %q", r.Precision)
+	}
+}
@@ -0,0 +1,46 @@
+package iperf
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// reIperf2Connected matches iperf2's connection line, which iperf3 words
+// differently, to tell the versions apart in archived output
+var reIperf2Connected = regexp.MustCompile(`\[\s*\d+\]\s+local\s+\S+\s+port\s+\d+\s+connected with\s`)
+
+// reJSONOutput matches a -J test object's start section or a --json-stream
+// event, which text output lacks
+var reJSONOutput = regexp.MustCompile(`(?m)^(?:\t"start":|\{"event":)`)
+
+// Reparse runs archived server output through the current parser for its
+// iperf version and output format, with the manager's warm-up, and returns the last result it
+// produces. Output that ends mid-test gives what was measured so far, as a
+// failed result; output without a test gives nil. The result's ID and
+// timestamp are not those of the original.
+func (m *Manager) Reparse(output []byte) *models.TestResult {
+	version := models.IperfVersion3
+	if reIperf2Connected.Match(output) {
+		version = models.IperfVersion2
+	}
+	format := formatText
+	if reJSONOutput.Match(output) {
+		format = formatJSON
+	}
+	parser := newParser(version, format, m.newID, m.warmup)
+
+	var result *models.TestResult
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if r := parser.ParseLine(scanner.Text()); r.Event == EventTestComplete {
+			result = r.TestResult
+		}
+	}
+	if parser.InSession() {
+		result = parser.AbortSession(models.TestStatusFailed, "")
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package iperf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestManager_Reparse(t *testing.T) {
+	m := NewManager(nil)
+	read := func(name string) []byte {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join("testdata", "corpus", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	r := m.Reparse(read("iperf3/3.12-tcp-reverse.txt"))
+	if r == nil || r.Direction != "download" || r.Retransmits == nil || *r.Retransmits != 6 || r.MinBandwidth != 933e6 {
+		t.Errorf("iperf3 result = %+v, want the reverse test with 6 retransmits", r)
+	}
+
+	// iperf2 output is told apart by its connection line
+	r = m.Reparse(read("iperf2/2.1-tcp.txt"))
+	if r == nil || r.Duration != 3.0123 || r.MinBandwidth != 940e6 {
+		t.Errorf("iperf2 result = %+v, want the 3.0123 s test", r)
+	}
+
+	// Output cut off mid-test gives what was measured
+	output := string(read("iperf3/3.12-tcp-upload.txt"))
+	cut := output[:strings.Index(output, "- - -")]
+	r = m.Reparse([]byte(cut))
+	if r == nil || r.Status != models.TestStatusFailed || r.Duration != 5.04 {
+		t.Errorf("cut-off result = %+v, want a failed 5.04 s result", r)
+	}
+
+	// JSON output is told apart by its structure
+	data, err := os.ReadFile(filepath.Join("testdata", "json", "3.12-tcp-upload.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Reparse(data); r == nil || r.BytesTransferred != 357302272 || r.Duration != 3.040532 {
+		t.Errorf("JSON result = %+v, want the 3.040532 s upload", r)
+	}
+
+	if r := m.Reparse([]byte("Server listening on 5201\n")); r != nil {
+		t.Errorf("output without a test gave %+v", r)
+	}
+
+	// The manager's warm-up applies
+	warm := NewManager(nil, WithWarmup(2))
+	if r := warm.Reparse(read("iperf3/3.12-tcp-reverse.txt")); r == nil || r.MaxBandwidth != 943e6 {
+		t.Errorf("with warm-up, max = %+v, want 943e6 from the intervals after it", r)
+	}
+}
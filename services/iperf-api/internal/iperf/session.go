@@ -0,0 +1,241 @@
+package iperf
+
+import (
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// LineParser turns iperf server output into events, one line at a time.
+type LineParser interface {
+	// ParseLine parses a single line of output and returns a result
+	ParseLine(line string) ParseResult
+	// InSession reports whether a test is in progress and has not yet produced a result
+	InSession() bool
+	// ClientIP returns the address of the client in the current session
+	ClientIP() string
+	// SessionID returns the ID of the current session, which becomes its result's ID
+	SessionID() string
+	// AbortSession ends the in-progress test and returns what was measured so far
+	AbortSession(status models.TestStatus, reason string) *models.TestResult
+}
+
+// NewParser returns the output parser for servers of the given iperf
+// version. Session IDs are generated by newID, or are random UUIDs if it is
+// nil, and intervals starting in the first warmup seconds of each test are
+// left out of results.
+func NewParser(version models.IperfVersion, newID ids.Generator, warmup float64) LineParser {
+	if version == models.IperfVersion2 {
+		p := NewIperf2Parser()
+		p.newID = newID
+		p.warmup = warmup
+		return p
+	}
+	p := NewTextParser()
+	p.newID = newID
+	p.warmup = warmup
+	return p
+}
+
+// newParser is NewParser for a server reporting in format.
+func newParser(version models.IperfVersion, format outputFormat, newID ids.Generator, warmup float64) LineParser {
+	if format == formatText {
+		return NewParser(version, newID, warmup)
+	}
+	p := NewJSONParser()
+	p.newID = newID
+	p.warmup = warmup
+	return p
+}
+
+// sessionState tracks one test session across interval lines. It is shared by
+// the iperf3 and iperf2 parsers.
+type sessionState struct {
+	// newID generates session IDs; nil uses random UUIDs
+	newID        ids.Generator
+	id           string
+	clientIP     string
+	clientPort   int
+	protocol     models.Protocol
+	active       bool
+	minBandwidth float64
+	maxBandwidth float64
+	intervals    int
+	totalBytes   int64
+	lastEnd      float64
+	requested    float64
+	client       *models.ClientFingerprint
+	// started is the wall-clock time iperf reported for the client's
+	// connection; zero when the output has none
+	started time.Time
+	// counted, countedBytes and countedSpan cover the intervals the
+	// minimum, maximum and warm-up average are taken from: those past the
+	// warm-up
+	counted      int
+	countedBytes int64
+	countedSpan  float64
+	// omittedSpan is the time spent in intervals iperf3 omitted (-O), after
+	// which its interval clock restarts at zero
+	omittedSpan float64
+	// warmup leaves intervals starting in the first warmup seconds out of
+	// the minimum, maximum and average. It is kept across sessions.
+	warmup float64
+	// precision is how exactly the parser's output gives byte counts. It is
+	// kept across sessions.
+	precision models.Precision
+}
+
+// InSession reports whether a test is in progress and has not yet produced a result.
+func (s *sessionState) InSession() bool {
+	return s.active
+}
+
+// ClientIP returns the address of the client in the current session.
+func (s *sessionState) ClientIP() string {
+	return s.clientIP
+}
+
+// SessionID returns the ID of the current session, which becomes its result's ID.
+func (s *sessionState) SessionID() string {
+	return s.id
+}
+
+// newSession assigns an ID to a session when its client connects.
+func (s *sessionState) newSession() {
+	if s.newID == nil {
+		s.id = ids.UUID()
+		return
+	}
+	s.id = s.newID()
+}
+
+// takeID returns the session ID for its result. Only the first result of a
+// session takes the ID, so extra summary lines cannot reuse it.
+func (s *sessionState) takeID() string {
+	id := s.id
+	s.id = ""
+	return id
+}
+
+// startTime returns when the session started, as reported by iperf, or the
+// current time if it was not reported.
+func (s *sessionState) startTime() time.Time {
+	if s.started.IsZero() {
+		return time.Now()
+	}
+	return s.started
+}
+
+// intervalTime returns the wall-clock time of an offset in seconds into the
+// session, or the current time if the start was not reported. Deriving it
+// from the start keeps output that is parsed late from skewing timestamps.
+func (s *sessionState) intervalTime(offset float64) time.Time {
+	if s.started.IsZero() {
+		return time.Now()
+	}
+	return s.started.Add(time.Duration((s.omittedSpan + offset) * float64(time.Second)))
+}
+
+// recordInterval accumulates an interval measurement into the session.
+// Intervals in the warm-up count towards the totals but not the minimum and
+// maximum.
+func (s *sessionState) recordInterval(start, end float64, bytes int64, bps float64) {
+	s.intervals++
+	s.totalBytes += bytes
+	s.lastEnd = end
+	s.active = true
+	if start < s.warmup {
+		return
+	}
+
+	if s.counted == 0 {
+		s.minBandwidth = bps
+		s.maxBandwidth = bps
+	} else {
+		if bps < s.minBandwidth {
+			s.minBandwidth = bps
+		}
+		if bps > s.maxBandwidth {
+			s.maxBandwidth = bps
+		}
+	}
+	s.counted++
+	s.countedBytes += bytes
+	s.countedSpan += end - start
+}
+
+// recordOmitted notes an interval iperf3 omitted from its own totals, which
+// the session leaves out too.
+func (s *sessionState) recordOmitted(start, end float64) {
+	s.omittedSpan += end - start
+	s.active = true
+}
+
+// warmupAverage returns the average bandwidth after the warm-up, if one is
+// set and intervals after it were seen.
+func (s *sessionState) warmupAverage() (float64, bool) {
+	if s.warmup <= 0 || s.countedSpan <= 0 {
+		return 0, false
+	}
+	return float64(s.countedBytes) * 8 / s.countedSpan, true
+}
+
+// AbortSession builds a partial TestResult from the intervals seen so far for a
+// test that ended without a summary, and marks the session as finished.
+func (s *sessionState) AbortSession(status models.TestStatus, reason string) *models.TestResult {
+	result := &models.TestResult{
+		ID:               s.takeID(),
+		Timestamp:        s.startTime(),
+		ClientIP:         s.clientIP,
+		ClientPort:       s.clientPort,
+		Protocol:         s.protocol,
+		Duration:         s.lastEnd,
+		BytesTransferred: s.totalBytes,
+		MinBandwidth:     s.minBandwidth,
+		MaxBandwidth:     s.maxBandwidth,
+		Direction:        "upload",
+		Status:           status,
+		ErrorMessage:     reason,
+		Precision:        s.precision,
+	}
+	if avg, ok := s.warmupAverage(); ok {
+		result.AvgBandwidth = avg
+	} else if s.lastEnd > 0 {
+		result.AvgBandwidth = float64(s.totalBytes) * 8 / s.lastEnd
+	}
+	s.setRequested(result)
+	s.setClient(result)
+
+	s.active = false
+	return result
+}
+
+// setRequested copies the requested test duration onto a result, if known.
+func (s *sessionState) setRequested(result *models.TestResult) {
+	if s.requested > 0 {
+		requested := s.requested
+		result.RequestedDuration = &requested
+	}
+}
+
+// reset clears per-test state for the next test session.
+func (s *sessionState) reset() {
+	s.id = ""
+	s.clientIP = ""
+	s.clientPort = 0
+	s.protocol = models.ProtocolTCP
+	s.active = false
+	s.minBandwidth = 0
+	s.maxBandwidth = 0
+	s.intervals = 0
+	s.totalBytes = 0
+	s.lastEnd = 0
+	s.requested = 0
+	s.client = nil
+	s.started = time.Time{}
+	s.counted = 0
+	s.countedBytes = 0
+	s.countedSpan = 0
+	s.omittedSpan = 0
+}
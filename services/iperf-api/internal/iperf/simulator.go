@@ -0,0 +1,52 @@
+package iperf
+
+import (
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SimulatorConfig runs the iperf3sim command in place of iperf3, so the API
+// can be developed and tested on machines without iperf3
+type SimulatorConfig struct {
+	// Binary is the iperf3sim executable
+	Binary string
+	// Scenario is the comma-separated list of tests the simulator plays in
+	// turn, such as "tcp,udp,parallel"; empty plays its default
+	Scenario string
+	// Interval is the time between interval reports; zero keeps the
+	// simulator's default of a second
+	Interval time.Duration
+	// Pause is the time between tests; zero keeps the simulator's default
+	Pause time.Duration
+}
+
+// WithSimulator launches the iperf3 simulator instead of iperf3. iperf2
+// servers are unaffected.
+func WithSimulator(cfg SimulatorConfig) ManagerOption {
+	return func(m *Manager) {
+		if cfg.Binary != "" {
+			m.simulator = &cfg
+		}
+	}
+}
+
+// simulatorArgs appends the simulator's own flags to the iperf3 arguments
+// it is launched with.
+func (c *SimulatorConfig) simulatorArgs(args []string) []string {
+	if c.Scenario != "" {
+		args = append(args, "--sim-scenario", c.Scenario)
+	}
+	if c.Interval > 0 {
+		args = append(args, "--sim-interval", c.Interval.String())
+	}
+	if c.Pause > 0 {
+		args = append(args, "--sim-pause", c.Pause.String())
+	}
+	return args
+}
+
+// usesSimulator reports whether cfg's server would run the simulator.
+func (m *Manager) usesSimulator(cfg models.ServerConfig) bool {
+	return m.simulator != nil && cfg.Version != models.IperfVersion2
+}
@@ -0,0 +1,193 @@
+package iperf
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// RestartPolicy controls automatic restarts of a server whose iperf process
+// crashes, leaving it in the error state. Zero MaxRetries disables restarts.
+type RestartPolicy struct {
+	// MaxRetries is how many consecutive restarts are attempted before the
+	// circuit opens and the server is left down
+	MaxRetries int
+	// Backoff is the delay before the first restart; it doubles for each
+	// consecutive restart up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// ResetAfter is how long a restarted server must run for a later crash
+	// to start a fresh series of retries
+	ResetAfter time.Duration
+}
+
+// delay returns the backoff before restart attempt n, counting from 1.
+func (p RestartPolicy) delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// WithRestartPolicy restarts crashed servers with exponential backoff.
+// One-off servers are not restarted.
+func WithRestartPolicy(p RestartPolicy) ManagerOption {
+	return func(m *Manager) {
+		if p.Backoff <= 0 {
+			p.Backoff = time.Second
+		}
+		if p.MaxBackoff < p.Backoff {
+			p.MaxBackoff = p.Backoff
+		}
+		m.restartPolicy = p
+	}
+}
+
+// supervisorState tracks automatic restarts; it is guarded by Manager.mu.
+type supervisorState struct {
+	circuit     models.CircuitState
+	restarts    int
+	nextRestart time.Time
+	lastError   string
+	startedAt   time.Time
+	timer       *time.Timer
+	// gen identifies the pending restart, so a timer that fires after being
+	// cancelled does nothing
+	gen int
+}
+
+// resetSupervisorLocked cancels any pending restart and closes the circuit
+// (must be called with lock held).
+func (m *Manager) resetSupervisorLocked() {
+	sv := &m.supervisor
+	if sv.timer != nil {
+		sv.timer.Stop()
+		sv.timer = nil
+	}
+	sv.gen++
+	sv.circuit = models.CircuitClosed
+	sv.restarts = 0
+	sv.nextRestart = time.Time{}
+	sv.lastError = ""
+}
+
+// superviseCrashLocked schedules a restart after the server crashed (must be
+// called with lock held).
+func (m *Manager) superviseCrashLocked(err error) {
+	if m.restartPolicy.MaxRetries <= 0 || m.config.OneOff {
+		return
+	}
+	// A crash after a stable run starts a fresh series of retries
+	if time.Since(m.supervisor.startedAt) >= m.restartPolicy.ResetAfter {
+		m.supervisor.restarts = 0
+	}
+	m.scheduleRestartLocked(fmt.Sprintf("iperf3 exited unexpectedly: %v", err))
+}
+
+// scheduleRestartLocked schedules the next restart attempt, or opens the
+// circuit when retries are exhausted (must be called with lock held).
+func (m *Manager) scheduleRestartLocked(reason string) {
+	p := m.restartPolicy
+	sv := &m.supervisor
+	sv.lastError = reason
+	event := &models.RestartEvent{
+		Timestamp:  time.Now(),
+		MaxRetries: p.MaxRetries,
+		Reason:     reason,
+	}
+
+	if sv.restarts >= p.MaxRetries {
+		sv.circuit = models.CircuitOpen
+		sv.nextRestart = time.Time{}
+		event.Attempt = sv.restarts
+		event.CircuitOpen = true
+		log.Printf("Supervisor: giving up after %d restarts: %s", sv.restarts, reason)
+		m.sendEventLocked(models.WSMessage{Type: models.WSMessageTypeRestart, Payload: event})
+		return
+	}
+
+	sv.restarts++
+	delay := p.delay(sv.restarts)
+	sv.circuit = models.CircuitHalfOpen
+	sv.nextRestart = event.Timestamp.Add(delay)
+	sv.gen++
+	gen, cfg := sv.gen, m.config
+	sv.timer = time.AfterFunc(delay, func() {
+		m.autoRestart(gen, cfg)
+	})
+
+	event.Attempt = sv.restarts
+	event.Delay = delay.Seconds()
+	log.Printf("Supervisor: restarting in %s (attempt %d of %d): %s", delay, sv.restarts, p.MaxRetries, reason)
+	m.sendEventLocked(models.WSMessage{Type: models.WSMessageTypeRestart, Payload: event})
+}
+
+// autoRestart starts the server again with the config it crashed with,
+// unless the restart was cancelled in the meantime. A failed start counts as
+// another crash.
+func (m *Manager) autoRestart(gen int, cfg models.ServerConfig) {
+	// The rest of a pool must release its ports first
+	if !m.WaitExited(restartWait) {
+		log.Printf("Supervisor: old iperf3 process did not exit within %s", restartWait)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sv := &m.supervisor
+	if sv.gen != gen || sv.timer == nil {
+		return
+	}
+	sv.timer = nil
+	sv.nextRestart = time.Time{}
+
+	if err := m.startLocked(cfg); err != nil {
+		m.scheduleRestartLocked(fmt.Sprintf("restart failed: %v", err))
+		m.sendStatusUpdateLocked()
+		return
+	}
+	m.restarts++
+}
+
+// Supervisor returns the automatic restart state, or nil when no restart
+// policy is set.
+func (m *Manager) Supervisor() *models.SupervisorStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.supervisorStatusLocked()
+}
+
+// supervisorStatusLocked builds the restart state (must be called with lock
+// held).
+func (m *Manager) supervisorStatusLocked() *models.SupervisorStatus {
+	if m.restartPolicy.MaxRetries <= 0 {
+		return nil
+	}
+	sv := m.supervisor
+	status := &models.SupervisorStatus{
+		Circuit:    sv.circuit,
+		Restarts:   sv.restarts,
+		MaxRetries: m.restartPolicy.MaxRetries,
+		LastError:  sv.lastError,
+	}
+	if status.Circuit == "" {
+		status.Circuit = models.CircuitClosed
+	}
+	// A restarted server that has stayed up long enough is healthy again
+	if sv.circuit == models.CircuitHalfOpen && sv.timer == nil &&
+		m.status == models.ServerStatusRunning && time.Since(sv.startedAt) >= m.restartPolicy.ResetAfter {
+		status.Circuit = models.CircuitClosed
+		status.Restarts = 0
+	}
+	if !sv.nextRestart.IsZero() {
+		next := sv.nextRestart
+		status.NextRestartAt = &next
+	}
+	return status
+}
@@ -0,0 +1,182 @@
+package iperf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// crashingIperf is a fake iperf3 that kills itself on its first crashes
+// launches and then runs normally. It returns the script and the file
+// counting launches.
+func crashingIperf(t *testing.T, crashes int) (string, string) {
+	t.Helper()
+	count := filepath.Join(t.TempDir(), "launches")
+	bin := fakeIperf(t, fmt.Sprintf(`
+n=$(cat %[1]s 2>/dev/null || echo 0)
+echo $((n+1)) > %[1]s
+if [ "$n" -lt %[2]d ]; then kill -9 $$; fi
+exec sleep 30
+`, count, crashes))
+	return bin, count
+}
+
+func launches(t *testing.T, count string) string {
+	t.Helper()
+	data, _ := os.ReadFile(count)
+	return strings.TrimSpace(string(data))
+}
+
+// waitUntil polls cond until it holds.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRestartPolicy_Delay(t *testing.T) {
+	p := RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestManager_RestartsCrashedServer(t *testing.T) {
+	bin, count := crashingIperf(t, 1)
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithRestartPolicy(RestartPolicy{
+		MaxRetries: 3,
+		Backoff:    10 * time.Millisecond,
+		ResetAfter: time.Hour,
+	}))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	event := rec.waitFor(t, models.WSMessageTypeRestart).Payload.(*models.RestartEvent)
+	if event.Attempt != 1 || event.MaxRetries != 3 || event.CircuitOpen || event.Reason == "" {
+		t.Errorf("restart event = %+v, want attempt 1 of 3 with a reason", event)
+	}
+
+	waitUntil(t, "restart", func() bool { return launches(t, count) == "2" && m.GetStatus() == models.ServerStatusRunning })
+	sv := m.Supervisor()
+	if sv == nil || sv.Circuit != models.CircuitHalfOpen || sv.Restarts != 1 || sv.NextRestartAt != nil {
+		t.Errorf("supervisor = %+v, want half-open after one restart", sv)
+	}
+}
+
+func TestManager_RestartCircuitOpens(t *testing.T) {
+	bin, count := crashingIperf(t, 100)
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithRestartPolicy(RestartPolicy{
+		MaxRetries: 2,
+		Backoff:    10 * time.Millisecond,
+		ResetAfter: time.Hour,
+	}))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	waitUntil(t, "circuit to open", func() bool {
+		sv := m.Supervisor()
+		return sv != nil && sv.Circuit == models.CircuitOpen
+	})
+	if got := launches(t, count); got != "3" {
+		t.Errorf("iperf3 launched %s times, want 3", got)
+	}
+	if status := m.GetStatus(); status != models.ServerStatusError {
+		t.Errorf("status = %s, want error", status)
+	}
+
+	rec.mu.Lock()
+	last := rec.msgs[len(rec.msgs)-1]
+	var restarts []*models.RestartEvent
+	for _, msg := range rec.msgs {
+		if msg.Type == models.WSMessageTypeRestart {
+			restarts = append(restarts, msg.Payload.(*models.RestartEvent))
+		}
+	}
+	rec.mu.Unlock()
+	if len(restarts) != 3 || !restarts[2].CircuitOpen || restarts[2].Attempt != 2 {
+		t.Errorf("restart events = %+v, want two attempts then the circuit opening", restarts)
+	}
+	if status, ok := last.Payload.(models.ServerStatusPayload); !ok || status.Supervisor == nil || status.Supervisor.Circuit != models.CircuitOpen {
+		t.Errorf("last message = %+v, want a status update with the circuit open", last)
+	}
+}
+
+func TestManager_StopCancelsPendingRestart(t *testing.T) {
+	bin, count := crashingIperf(t, 100)
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithRestartPolicy(RestartPolicy{
+		MaxRetries: 3,
+		Backoff:    200 * time.Millisecond,
+	}))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	rec.waitFor(t, models.WSMessageTypeRestart)
+
+	if err := m.Stop(); err != nil {
+		t.Fatalf("Stop with a pending restart: %v", err)
+	}
+	if status := m.GetStatus(); status != models.ServerStatusStopped {
+		t.Errorf("status = %s, want stopped", status)
+	}
+	if sv := m.Supervisor(); sv.Circuit != models.CircuitClosed || sv.NextRestartAt != nil {
+		t.Errorf("supervisor = %+v, want closed with no restart pending", sv)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if got := launches(t, count); got != "1" {
+		t.Errorf("iperf3 launched %s times, want the restart cancelled", got)
+	}
+}
+
+func TestManager_OneOffServerIsNotRestarted(t *testing.T) {
+	bin, count := crashingIperf(t, 100)
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle, WithBinaryPath(bin), WithRestartPolicy(RestartPolicy{
+		MaxRetries: 3,
+		Backoff:    10 * time.Millisecond,
+	}))
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+	cfg.OneOff = true
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitUntil(t, "crash", func() bool { return m.GetStatus() == models.ServerStatusError })
+	time.Sleep(100 * time.Millisecond)
+
+	if got := launches(t, count); got != "1" {
+		t.Errorf("iperf3 launched %s times, want no restart", got)
+	}
+	if sv := m.Supervisor(); sv.Circuit != models.CircuitClosed {
+		t.Errorf("supervisor = %+v, want closed", sv)
+	}
+}
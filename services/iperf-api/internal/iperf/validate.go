@@ -0,0 +1,120 @@
+package iperf
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// CheckAllowlist is the check Validate makes of each allowlist entry.
+const CheckAllowlist = "allowlist"
+
+// Validate checks cfg for a configuration form without launching anything.
+// Unlike DryRun it does not stop at config errors: it reports every one
+// with its field, probes the ports whenever the port and address fields are
+// valid, and warns about allowlist entries no client can match or that
+// another entry already covers. Ports the running server holds are
+// skipped, as for a queued job.
+func (m *Manager) Validate(cfg models.ServerConfig) DryRunPlan {
+	errs := ValidateConfig(cfg)
+	plan := DryRunPlan{Checks: configChecks(errs)}
+	plan.Checks = append(plan.Checks, checkAllowlist(cfg)...)
+
+	// Ports are only probed at an address Start could use
+	for _, err := range errs {
+		switch err.Field {
+		case "port", "portCount", "bindAddress", "addressFamily":
+			return plan
+		}
+	}
+	_, held := m.heldPorts()
+	for _, port := range cfg.Ports() {
+		plan.Checks = append(plan.Checks, portCheck(cfg, port, held))
+	}
+	return plan
+}
+
+// allowlistEntry is an allowlist entry as the range of clients it matches.
+type allowlistEntry struct {
+	prefix netip.Prefix
+	// zone is set for an address that only matches clients in one zone
+	zone string
+}
+
+// parseAllowlistEntry parses an entry as IsClientAllowed matches it.
+func parseAllowlistEntry(entry string) (allowlistEntry, bool) {
+	if ip, err := netip.ParseAddr(entry); err == nil {
+		ip = ip.Unmap()
+		return allowlistEntry{prefix: netip.PrefixFrom(ip.WithZone(""), ip.BitLen()), zone: ip.Zone()}, true
+	}
+	if network, err := netip.ParsePrefix(entry); err == nil {
+		return allowlistEntry{prefix: network.Masked()}, true
+	}
+	return allowlistEntry{}, false
+}
+
+// coveredBy reports whether every client e matches is also matched by other.
+func (e allowlistEntry) coveredBy(other allowlistEntry) bool {
+	if other.zone != "" && other.zone != e.zone {
+		return false
+	}
+	return other.prefix.Bits() <= e.prefix.Bits() && other.prefix.Contains(e.prefix.Addr())
+}
+
+// checkAllowlist returns a check for each valid allowlist entry, with a
+// warning if the entry can never match a client or is redundant. Invalid
+// entries are reported by ValidateConfig.
+func checkAllowlist(cfg models.ServerConfig) []DryRunCheck {
+	entries := make([]allowlistEntry, len(cfg.Allowlist))
+	valid := make([]bool, len(cfg.Allowlist))
+	for i, entry := range cfg.Allowlist {
+		entries[i], valid[i] = parseAllowlistEntry(entry)
+	}
+
+	// A specific bind address limits clients to its family
+	family := cfg.AddressFamily
+	bind, err := netip.ParseAddr(cfg.BindAddress)
+	if err == nil && !bind.IsUnspecified() {
+		bind = bind.Unmap()
+		family = models.AddressFamilyIPv4
+		if bind.Is6() {
+			family = models.AddressFamilyIPv6
+		}
+	} else {
+		bind = netip.Addr{}
+	}
+
+	var checks []DryRunCheck
+	for i, entry := range cfg.Allowlist {
+		if !valid[i] {
+			continue
+		}
+		e := entries[i]
+		check := DryRunCheck{Name: CheckAllowlist, Field: fmt.Sprintf("allowlist[%d]", i), Detail: entry}
+		addr := e.prefix.Addr()
+		switch {
+		case addr.Is4In6():
+			// Clients' IPv4-mapped addresses are unmapped before matching
+			check.Warning = i18n.NewError("validate.allowlist_mapped", i18n.Params{"entry": entry})
+		case family == models.AddressFamilyIPv4 && addr.Is6(), family == models.AddressFamilyIPv6 && addr.Is4():
+			check.Warning = i18n.NewError("validate.allowlist_family", i18n.Params{"entry": entry, "family": family})
+		case bind.IsLoopback() && !addr.IsLoopback():
+			check.Warning = i18n.NewError("validate.allowlist_loopback", i18n.Params{"entry": entry, "address": cfg.BindAddress})
+		default:
+			for j, other := range entries {
+				// Of identical entries, only the later ones are redundant
+				if j == i || !valid[j] || (j > i && other == e) {
+					continue
+				}
+				if e.coveredBy(other) {
+					check.Warning = i18n.NewError("validate.allowlist_redundant", i18n.Params{"entry": entry, "other": cfg.Allowlist[j]})
+					break
+				}
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,118 @@
+package iperf
+
+import (
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestManager_ValidateReportsEveryError(t *testing.T) {
+	m := NewManager(nil, WithBinaryPath("/nonexistent/iperf3"))
+
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = -1
+	cfg.Allowlist = []string{"10.0.0.0/8", "bogus"}
+	cfg.Port = freePort(t)
+	plan := m.Validate(cfg)
+	if plan.OK() {
+		t.Fatal("plan passed with invalid fields")
+	}
+
+	fields := map[string]bool{}
+	probed := false
+	for _, c := range plan.Checks {
+		if c.Err != nil {
+			fields[c.Field] = true
+		}
+		if c.Name == CheckPort {
+			probed = c.Err == nil
+		}
+		if c.Name == CheckBinary || c.Name == CheckState {
+			t.Errorf("unexpected %s check", c.Name)
+		}
+	}
+	if !fields["idleTimeout"] || !fields["allowlist[1]"] || len(fields) != 2 {
+		t.Errorf("failed fields = %v, want idleTimeout and allowlist[1]", fields)
+	}
+	if !probed {
+		t.Error("port not probed despite a valid port")
+	}
+
+	// An invalid port is not probed
+	cfg.Port = 0
+	for _, c := range m.Validate(cfg).Checks {
+		if c.Name == CheckPort {
+			t.Errorf("port %d probed", c.Port)
+		}
+	}
+}
+
+func TestCheckAllowlist(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   models.ServerConfig
+		warns map[string]string
+	}{
+		{
+			name:  "clean",
+			cfg:   models.ServerConfig{Allowlist: []string{"10.0.0.0/8", "2001:db8::/32", "fe80::1%eth0"}},
+			warns: map[string]string{},
+		},
+		{
+			name: "redundant",
+			cfg:  models.ServerConfig{Allowlist: []string{"10.1.2.3", "10.0.0.0/8", "10.0.0.0/8", "fe80::1%eth0", "fe80::1"}},
+			warns: map[string]string{
+				"allowlist[0]": "validate.allowlist_redundant",
+				"allowlist[2]": "validate.allowlist_redundant",
+				"allowlist[3]": "validate.allowlist_redundant",
+			},
+		},
+		{
+			name:  "family",
+			cfg:   models.ServerConfig{AddressFamily: models.AddressFamilyIPv4, Allowlist: []string{"10.0.0.1", "2001:db8::1"}},
+			warns: map[string]string{"allowlist[1]": "validate.allowlist_family"},
+		},
+		{
+			name:  "bind family",
+			cfg:   models.ServerConfig{BindAddress: "2001:db8::5", Allowlist: []string{"2001:db8::1", "::ffff:10.0.0.2"}},
+			warns: map[string]string{"allowlist[1]": "validate.allowlist_family"},
+		},
+		{
+			name:  "mapped range",
+			cfg:   models.ServerConfig{Allowlist: []string{"::ffff:10.0.0.0/104"}},
+			warns: map[string]string{"allowlist[0]": "validate.allowlist_mapped"},
+		},
+		{
+			name:  "loopback",
+			cfg:   models.ServerConfig{BindAddress: "127.0.0.1", Allowlist: []string{"127.0.0.1", "192.0.2.1"}},
+			warns: map[string]string{"allowlist[1]": "validate.allowlist_loopback"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := checkAllowlist(tt.cfg)
+			if len(checks) != len(tt.cfg.Allowlist) {
+				t.Fatalf("checks = %+v, want one per entry", checks)
+			}
+			got := map[string]string{}
+			for _, c := range checks {
+				if c.Err != nil {
+					t.Errorf("%s failed: %v", c.Field, c.Err)
+				}
+				if c.Warning != nil {
+					key, _ := c.Warning.(*i18n.Error).MessageKey()
+					got[c.Field] = key
+				}
+			}
+			if len(got) != len(tt.warns) {
+				t.Errorf("warnings = %v, want %v", got, tt.warns)
+			}
+			for field, key := range tt.warns {
+				if got[field] != key {
+					t.Errorf("%s warning = %q, want %q", field, got[field], key)
+				}
+			}
+		})
+	}
+}
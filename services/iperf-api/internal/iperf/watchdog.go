@@ -0,0 +1,165 @@
+package iperf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// WatchdogConfig controls detection of test sessions that stop producing output
+type WatchdogConfig struct {
+	// Timeout is how long a session may go without output before it is
+	// considered stuck. Zero disables the watchdog.
+	Timeout time.Duration
+	// Restart cycles the iperf3 process after a stall is detected
+	Restart bool
+	// DiagnosticsDir receives goroutine dumps; when empty dumps are logged
+	DiagnosticsDir string
+}
+
+// restartWait bounds how long a watchdog restart waits for the old process to exit
+const restartWait = 10 * time.Second
+
+// WithWatchdog enables the liveness watchdog
+func WithWatchdog(cfg WatchdogConfig) ManagerOption {
+	return func(m *Manager) {
+		m.watchdog = cfg
+	}
+}
+
+// stallTimeout returns how long a session of cfg's server may go without
+// output: the watchdog's timeout, extended by however much longer than
+// iperf's default second the server waits between reports.
+func stallTimeout(timeout time.Duration, cfg models.ServerConfig) time.Duration {
+	if extra := cfg.ReportInterval() - 1; extra > 0 {
+		return timeout + time.Duration(extra*float64(time.Second))
+	}
+	return timeout
+}
+
+// runWatchdog checks a listener for stalled sessions until ctx is cancelled
+// or its process exits. A warning is emitted once per stall; output resuming
+// re-arms it.
+func (m *Manager) runWatchdog(ctx context.Context, l *listener) {
+	interval := m.watchdog.Timeout / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.exited:
+			return
+		case <-ticker.C:
+		}
+
+		l.sp.mu.Lock()
+		inSession := l.sp.parser.InSession()
+		clientIP := l.sp.parser.ClientIP()
+		sessionID := l.sp.parser.SessionID()
+		l.sp.mu.Unlock()
+
+		m.mu.RLock()
+		running := m.status == models.ServerStatusRunning
+		idle := time.Since(l.lastOutput)
+		timeout := stallTimeout(m.watchdog.Timeout, m.config)
+		m.mu.RUnlock()
+
+		if !running || !inSession || idle < timeout {
+			stalled = false
+			continue
+		}
+		if stalled {
+			continue
+		}
+		stalled = true
+
+		m.reportStall(sessionID, clientIP, idle)
+
+		if m.watchdog.Restart {
+			m.restart()
+			return
+		}
+	}
+}
+
+// reportStall captures diagnostics and emits a warning event.
+func (m *Manager) reportStall(sessionID, clientIP string, idle time.Duration) {
+	warning := &models.WatchdogWarning{
+		SessionID:  sessionID,
+		Timestamp:  time.Now(),
+		Message:    fmt.Sprintf("no iperf3 output for %s during an active test", idle.Round(time.Second)),
+		ClientIP:   clientIP,
+		StalledFor: idle.Seconds(),
+		Restarting: m.watchdog.Restart,
+	}
+
+	path, err := m.captureDiagnostics()
+	if err != nil {
+		log.Printf("Watchdog: failed to capture diagnostics: %v", err)
+	}
+	warning.Diagnostics = path
+
+	log.Printf("Watchdog: %s (client %s)", warning.Message, clientIP)
+	m.sendEvent(models.WSMessage{
+		Type:    models.WSMessageTypeWarning,
+		Payload: warning,
+	})
+}
+
+// captureDiagnostics writes a goroutine dump to DiagnosticsDir and returns its
+// path, or logs the dump when no directory is configured.
+func (m *Manager) captureDiagnostics() (string, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		return "", err
+	}
+
+	if m.watchdog.DiagnosticsDir == "" {
+		log.Printf("Watchdog goroutine dump:\n%s", buf.String())
+		return "", nil
+	}
+
+	if err := os.MkdirAll(m.watchdog.DiagnosticsDir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("watchdog-%s.txt", time.Now().UTC().Format("20060102T150405.000Z"))
+	path := filepath.Join(m.watchdog.DiagnosticsDir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// restart stops the iperf3 processes and starts them again with the same
+// config once the old processes have exited.
+func (m *Manager) restart() {
+	m.mu.RLock()
+	cfg := m.config
+	m.mu.RUnlock()
+
+	if err := m.Stop(); err != nil {
+		log.Printf("Watchdog: restart aborted: %v", err)
+		return
+	}
+
+	if !m.WaitExited(restartWait) {
+		log.Printf("Watchdog: old iperf3 process did not exit within %s", restartWait)
+	}
+
+	if err := m.Start(cfg); err != nil {
+		m.sendError(fmt.Sprintf("watchdog restart failed: %v", err))
+	}
+}
@@ -0,0 +1,127 @@
+package iperf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// stalledSession is a fake iperf3 that starts a test and then goes silent.
+const stalledSession = `
+echo "Accepted connection from 10.0.0.1, port 50000"
+echo "[  5]   0.00-1.00   sec  100 MBytes   839 Mbits/sec"
+exec sleep 30
+`
+
+func TestWatchdog_WarnsOnStalledSession(t *testing.T) {
+	dir := t.TempDir()
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle,
+		WithBinaryPath(fakeIperf(t, stalledSession)),
+		WithWatchdog(WatchdogConfig{Timeout: 200 * time.Millisecond, DiagnosticsDir: dir}),
+	)
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	msg := rec.waitFor(t, models.WSMessageTypeWarning)
+	warning, ok := msg.Payload.(*models.WatchdogWarning)
+	if !ok {
+		t.Fatalf("payload type = %T, want *models.WatchdogWarning", msg.Payload)
+	}
+	if warning.ClientIP != "10.0.0.1" {
+		t.Errorf("ClientIP = %q, want %q", warning.ClientIP, "10.0.0.1")
+	}
+	if warning.StalledFor < 0.2 {
+		t.Errorf("StalledFor = %v, want >= 0.2", warning.StalledFor)
+	}
+	if warning.Restarting {
+		t.Error("Restarting = true, want false")
+	}
+
+	dump, err := os.ReadFile(warning.Diagnostics)
+	if err != nil {
+		t.Fatalf("reading diagnostics: %v", err)
+	}
+	if !strings.Contains(string(dump), "goroutine") {
+		t.Error("diagnostics file does not contain a goroutine dump")
+	}
+	if filepath.Dir(warning.Diagnostics) != dir {
+		t.Errorf("diagnostics written to %s, want %s", warning.Diagnostics, dir)
+	}
+}
+
+func TestWatchdog_IgnoresIdleServer(t *testing.T) {
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle,
+		WithBinaryPath(fakeIperf(t, "echo 'Server listening on 5201'\nexec sleep 30\n")),
+		WithWatchdog(WatchdogConfig{Timeout: 100 * time.Millisecond, DiagnosticsDir: t.TempDir()}),
+	)
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	time.Sleep(500 * time.Millisecond)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, msg := range rec.msgs {
+		if msg.Type == models.WSMessageTypeWarning {
+			t.Fatal("watchdog fired with no client connected")
+		}
+	}
+}
+
+func TestWatchdog_RestartsProcess(t *testing.T) {
+	starts := filepath.Join(t.TempDir(), "starts")
+	rec := &eventRecorder{}
+	m := NewManager(rec.handle,
+		WithBinaryPath(fakeIperf(t, "echo start >> "+starts+"\n"+stalledSession)),
+		WithWatchdog(WatchdogConfig{Timeout: 200 * time.Millisecond, Restart: true, DiagnosticsDir: t.TempDir()}),
+	)
+	cfg := models.DefaultServerConfig()
+	cfg.IdleTimeout = 0
+
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(starts)
+		if strings.Count(string(data), "start") >= 2 && m.GetStatus() == models.ServerStatusRunning {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("iperf3 was not restarted after stall")
+}
+
+func TestStallTimeout(t *testing.T) {
+	cfg := models.DefaultServerConfig()
+	if got := stallTimeout(30*time.Second, cfg); got != 30*time.Second {
+		t.Errorf("default interval: %v, want the timeout", got)
+	}
+	cfg.IntervalSeconds = 0.5
+	if got := stallTimeout(30*time.Second, cfg); got != 30*time.Second {
+		t.Errorf("short interval: %v, want the timeout", got)
+	}
+	// A minute between reports is not a stall
+	cfg.IntervalSeconds = 60
+	if got := stallTimeout(30*time.Second, cfg); got != 89*time.Second {
+		t.Errorf("long interval: %v, want 89s", got)
+	}
+}
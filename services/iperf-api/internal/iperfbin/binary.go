@@ -0,0 +1,83 @@
+// Package iperfbin locates the iperf3 executable used by the server manager.
+//
+// Release builds may embed a statically linked iperf3 for the target
+// architecture (build tag "embediperf3"). When no iperf3 is found on PATH the
+// embedded copy is extracted to the data directory and used instead, so a
+// single binary can be deployed on hosts without an iperf3 package.
+package iperfbin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SystemBinary is the name looked up on PATH.
+const SystemBinary = "iperf3"
+
+// ErrNotFound is returned when neither a system nor an embedded iperf3 exists.
+var ErrNotFound = errors.New("iperf3 not found on PATH and no embedded binary available")
+
+// embedded holds the architecture-specific iperf3 binary when built with the
+// embediperf3 tag. It is nil otherwise.
+var embedded []byte
+
+// HasEmbedded reports whether this build carries an embedded iperf3 binary.
+func HasEmbedded() bool {
+	return len(embedded) > 0
+}
+
+// Resolve returns the path to the iperf3 binary to execute. The system binary
+// on PATH takes precedence; otherwise the embedded binary is extracted to
+// dataDir/bin/iperf3 (rewritten only if its contents differ).
+func Resolve(dataDir string) (string, error) {
+	if path, err := exec.LookPath(SystemBinary); err == nil {
+		return path, nil
+	}
+
+	if !HasEmbedded() {
+		return "", ErrNotFound
+	}
+
+	return extract(filepath.Join(dataDir, "bin"))
+}
+
+// extract writes the embedded binary into dir and returns its path.
+func extract(dir string) (string, error) {
+	path := filepath.Join(dir, SystemBinary)
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, embedded) {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	// Write to a temporary file and rename so a concurrent start never
+	// executes a partially written binary.
+	tmp, err := os.CreateTemp(dir, SystemBinary+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(embedded); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write embedded iperf3: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write embedded iperf3: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", fmt.Errorf("failed to make iperf3 executable: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to install embedded iperf3: %w", err)
+	}
+
+	return path, nil
+}
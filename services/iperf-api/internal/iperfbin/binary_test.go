@@ -0,0 +1,101 @@
+package iperfbin
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withEmbedded(t *testing.T, data []byte) {
+	t.Helper()
+	orig := embedded
+	embedded = data
+	t.Cleanup(func() { embedded = orig })
+}
+
+func TestResolve_NoBinaryAvailable(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	withEmbedded(t, nil)
+
+	_, err := Resolve(t.TempDir())
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestResolve_PrefersSystemBinary(t *testing.T) {
+	pathDir := t.TempDir()
+	system := filepath.Join(pathDir, SystemBinary)
+	if err := os.WriteFile(system, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", pathDir)
+	withEmbedded(t, []byte("embedded"))
+
+	dataDir := t.TempDir()
+	got, err := Resolve(dataDir)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got != system {
+		t.Errorf("Resolve = %q, want %q", got, system)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "bin", SystemBinary)); !os.IsNotExist(err) {
+		t.Error("embedded binary should not be extracted when iperf3 is on PATH")
+	}
+}
+
+func TestResolve_ExtractsEmbedded(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	content := []byte("#!/bin/sh\necho iperf 3.16\n")
+	withEmbedded(t, content)
+
+	dataDir := t.TempDir()
+	got, err := Resolve(dataDir)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	want := filepath.Join(dataDir, "bin", SystemBinary)
+	if got != want {
+		t.Fatalf("Resolve = %q, want %q", got, want)
+	}
+
+	info, err := os.Stat(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("extracted binary is not executable: %v", info.Mode())
+	}
+	data, _ := os.ReadFile(got)
+	if !bytes.Equal(data, content) {
+		t.Errorf("extracted content = %q, want %q", data, content)
+	}
+}
+
+func TestResolve_ReplacesStaleExtraction(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	dataDir := t.TempDir()
+	binDir := filepath.Join(dataDir, "bin")
+	os.MkdirAll(binDir, 0755)
+	os.WriteFile(filepath.Join(binDir, SystemBinary), []byte("old"), 0755)
+
+	withEmbedded(t, []byte("new"))
+
+	got, err := Resolve(dataDir)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	data, _ := os.ReadFile(got)
+	if string(data) != "new" {
+		t.Errorf("extracted content = %q, want %q", data, "new")
+	}
+
+	entries, _ := os.ReadDir(binDir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the binary in %s, found %d entries", binDir, len(entries))
+	}
+}
@@ -0,0 +1,12 @@
+//go:build embediperf3
+
+package iperfbin
+
+import _ "embed"
+
+//go:embed assets/iperf3-linux-amd64
+var iperf3LinuxAMD64 []byte
+
+func init() {
+	embedded = iperf3LinuxAMD64
+}
@@ -0,0 +1,12 @@
+//go:build embediperf3
+
+package iperfbin
+
+import _ "embed"
+
+//go:embed assets/iperf3-linux-arm64
+var iperf3LinuxARM64 []byte
+
+func init() {
+	embedded = iperf3LinuxARM64
+}
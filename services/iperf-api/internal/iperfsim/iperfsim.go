@@ -0,0 +1,446 @@
+// Package iperfsim simulates an iperf3 server. It writes the output
+// iperf3 -s would for a scripted scenario of tests, so the API, the
+// dashboard and end-to-end tests run without iperf3 or any clients.
+package iperfsim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Kind is a test the simulator plays.
+type Kind string
+
+const (
+	// TCP is a single stream upload from the client
+	TCP Kind = "tcp"
+	// Reverse is a TCP download to the client, as iperf3 -R runs, with
+	// retransmits
+	Reverse Kind = "reverse"
+	// UDP is a UDP upload with jitter and some loss
+	UDP Kind = "udp"
+	// Parallel is a TCP upload over several streams, as iperf3 -P runs
+	Parallel Kind = "parallel"
+	// Closed is a TCP upload the client abandons partway
+	Closed Kind = "closed"
+	// ControlError is a connection that fails before a test starts
+	ControlError Kind = "control-error"
+)
+
+// Kinds lists every kind of test, in the order documented.
+var Kinds = []Kind{TCP, Reverse, UDP, Parallel, Closed, ControlError}
+
+// ParseScenario reads a comma-separated list of kinds, such as
+// "tcp,udp,parallel".
+func ParseScenario(s string) ([]Kind, error) {
+	var scenario []Kind
+	for _, name := range strings.Split(s, ",") {
+		kind := Kind(strings.ToLower(strings.TrimSpace(name)))
+		if kind == "" {
+			continue
+		}
+		known := false
+		for _, k := range Kinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown test %q; use %s", kind, kindList())
+		}
+		scenario = append(scenario, kind)
+	}
+	if len(scenario) == 0 {
+		return nil, fmt.Errorf("empty scenario; use %s", kindList())
+	}
+	return scenario, nil
+}
+
+func kindList() string {
+	names := make([]string, len(Kinds))
+	for i, k := range Kinds {
+		names[i] = string(k)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Config configures a simulated server.
+type Config struct {
+	// Port and BindAddress are those iperf3 was given
+	Port        int
+	BindAddress string
+	// IPv6 gives the simulated clients IPv6 addresses
+	IPv6 bool
+	// Verbose adds what iperf3 -V prints: the version, connection times,
+	// test parameters and CPU utilization
+	Verbose bool
+	// JSON writes each test as the object iperf3 -J prints once it ends, in
+	// place of text
+	JSON bool
+	// Scenario is the tests played, in turn
+	Scenario []Kind
+	// Duration is each test's length in seconds
+	Duration int
+	// Streams is the number of streams of a parallel test
+	Streams int
+	// Bitrate and UDPBitrate are the mean rates of TCP and UDP tests, in
+	// bits per second
+	Bitrate    float64
+	UDPBitrate float64
+	// ReportInterval is the seconds of test time each interval report
+	// covers, as iperf3's -i sets
+	ReportInterval float64
+	// Interval is the real time between interval reports, and Pause the
+	// time before each client connects
+	Interval time.Duration
+	Pause    time.Duration
+	// Seed makes the measurements of a run repeatable
+	Seed int64
+	// Now is the clock connection times are read from; nil uses time.Now
+	Now func() time.Time
+}
+
+// DefaultConfig returns the config of a server on port 5201 playing ten
+// second TCP uploads of about 941 Mbits/sec, reporting each second.
+func DefaultConfig() Config {
+	return Config{
+		Port:           5201,
+		Scenario:       []Kind{TCP},
+		Duration:       10,
+		Streams:        4,
+		Bitrate:        941e6,
+		UDPBitrate:     1.05e6,
+		ReportInterval: 1,
+		Interval:       time.Second,
+		Pause:          2 * time.Second,
+		Seed:           1,
+	}
+}
+
+// Simulator plays a scenario, writing output as iperf3 does: results to
+// stdout and errors to stderr.
+type Simulator struct {
+	cfg    Config
+	stdout io.Writer
+	stderr io.Writer
+	rand   *rand.Rand
+	// test is the number of the next test, as iperf3 counts them
+	test int
+}
+
+// New creates a simulator for cfg. Zero values in cfg take the defaults.
+func New(cfg Config, stdout, stderr io.Writer) *Simulator {
+	defaults := DefaultConfig()
+	if cfg.Port == 0 {
+		cfg.Port = defaults.Port
+	}
+	if len(cfg.Scenario) == 0 {
+		cfg.Scenario = defaults.Scenario
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = defaults.Duration
+	}
+	if cfg.Streams <= 1 {
+		cfg.Streams = defaults.Streams
+	}
+	if cfg.Bitrate <= 0 {
+		cfg.Bitrate = defaults.Bitrate
+	}
+	if cfg.UDPBitrate <= 0 {
+		cfg.UDPBitrate = defaults.UDPBitrate
+	}
+	if cfg.ReportInterval <= 0 {
+		cfg.ReportInterval = defaults.ReportInterval
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Simulator{
+		cfg:    cfg,
+		stdout: stdout,
+		stderr: stderr,
+		rand:   rand.New(rand.NewSource(cfg.Seed)),
+		test:   1,
+	}
+}
+
+// Messages iperf3 prints when a test or the server ends early.
+const (
+	msgClosed    = "the client has unexpectedly closed the connection"
+	msgControl   = "unable to receive control message: Connection reset by peer"
+	msgInterrupt = "interrupt - the server has terminated"
+)
+
+// Run plays the scenario's tests in turn until ctx is done or, when tests
+// is positive, that many have been played, as iperf3 -1 stops after one.
+// When ctx is done it reports the interrupt as iperf3 reports a signal,
+// failing any test in progress, and returns true.
+func (s *Simulator) Run(ctx context.Context, tests int) (interrupted bool) {
+	banner := true
+	for played := 0; tests <= 0 || played < tests; played++ {
+		kind := s.cfg.Scenario[played%len(s.cfg.Scenario)]
+		if !s.cfg.JSON && banner {
+			s.banner(true)
+		}
+		banner = true
+		if !s.sleep(ctx, s.cfg.Pause) {
+			s.interrupt(nil)
+			return true
+		}
+
+		// iperf3 listens again for the same test after a failed handshake
+		if kind == ControlError {
+			if s.cfg.JSON {
+				s.writeJSON(s.failedJSON(msgControl))
+			} else {
+				s.fail(msgControl)
+				s.banner(false)
+				banner = false
+			}
+			continue
+		}
+
+		t := s.generate(kind)
+		s.test++
+		play := s.playText
+		if s.cfg.JSON {
+			play = s.playJSON
+		}
+		if !play(ctx, t) {
+			s.interrupt(t)
+			return true
+		}
+	}
+	return false
+}
+
+// interrupt reports that the server was terminated, during t if set.
+func (s *Simulator) interrupt(t *test) {
+	switch {
+	case !s.cfg.JSON:
+		fmt.Fprintln(s.stderr, "iperf3: "+msgInterrupt)
+	case t == nil:
+		s.writeJSON(s.failedJSON(msgInterrupt))
+	default:
+		doc := s.testJSON(t, t.played)
+		doc.Error = msgInterrupt
+		s.writeJSON(doc)
+	}
+}
+
+// sleep waits d, reporting false if ctx is done first.
+func (s *Simulator) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// test is one simulated test, generated before it is played.
+type test struct {
+	kind     Kind
+	started  time.Time
+	protocol string
+	reverse  bool
+	blksize  int
+	cookie   string
+
+	client      string
+	clientPort  int
+	local       string
+	sockets     []int
+	streamPorts []int
+
+	// intervals holds each interval's samples, one per stream
+	intervals [][]sample
+	// failAfter is how many intervals are reported before the client goes
+	// away; negative when the test runs to the end
+	failAfter int
+	// played counts the intervals reported so far
+	played int
+
+	hostCPU, remoteCPU cpu
+}
+
+// sample is one stream's measurements over an interval.
+type sample struct {
+	start, end  float64
+	bytes       int64
+	retransmits int
+	cwnd        int64
+	jitter      float64
+	lost        int
+	packets     int
+}
+
+// cpu is the utilization iperf3 reports for an end, in percent.
+type cpu struct {
+	total, user, system float64
+}
+
+// udpBlockSize is the datagram size iperf3 picks on a standard MTU.
+const udpBlockSize = 1448
+
+// generate draws a test of kind.
+func (s *Simulator) generate(kind Kind) *test {
+	t := &test{
+		kind:       kind,
+		started:    s.cfg.Now().UTC().Truncate(time.Second),
+		protocol:   "TCP",
+		reverse:    kind == Reverse,
+		blksize:    131072,
+		cookie:     s.cookie(),
+		client:     "10.0.0.1",
+		clientPort: 40000 + s.rand.Intn(20000),
+		local:      s.cfg.BindAddress,
+		failAfter:  -1,
+	}
+	if s.cfg.IPv6 {
+		t.client = "2001:db8::1"
+	}
+	if t.local == "" || t.local == "0.0.0.0" || t.local == "::" {
+		t.local = "10.0.0.2"
+		if s.cfg.IPv6 {
+			t.local = "2001:db8::2"
+		}
+	}
+	streams, rate := 1, s.cfg.Bitrate
+	switch kind {
+	case UDP:
+		t.protocol, t.blksize, rate = "UDP", udpBlockSize, s.cfg.UDPBitrate
+	case Parallel:
+		streams = s.cfg.Streams
+	}
+	for i := 0; i < streams; i++ {
+		t.sockets = append(t.sockets, 5+2*i)
+		t.streamPorts = append(t.streamPorts, t.clientPort+2+2*i)
+	}
+
+	// Reports cover the report interval, the last cut short at the test's
+	// end. A receiving server reports a short last interval too, as the
+	// final data arrives after the client's clock has run out.
+	duration := float64(s.cfg.Duration)
+	var bounds [][2]float64
+	for i := 0; float64(i)*s.cfg.ReportInterval < duration-1e-9; i++ {
+		start := float64(i) * s.cfg.ReportInterval
+		bounds = append(bounds, [2]float64{start, math.Min(start+s.cfg.ReportInterval, duration)})
+	}
+	if kind == Closed {
+		t.failAfter = (len(bounds) + 1) / 2
+	}
+	if !t.reverse {
+		bounds = append(bounds, [2]float64{float64(s.cfg.Duration), float64(s.cfg.Duration) + 0.04})
+	}
+	perStream := rate / float64(streams)
+	for _, b := range bounds {
+		samples := make([]sample, streams)
+		for i := range samples {
+			samples[i] = s.sample(t, b[0], b[1], perStream)
+		}
+		t.intervals = append(t.intervals, samples)
+	}
+
+	t.hostCPU = s.cpu(5)
+	t.remoteCPU = s.cpu(11)
+	if t.protocol == "UDP" {
+		t.hostCPU, t.remoteCPU = s.cpu(0.4), s.cpu(0.6)
+	}
+	return t
+}
+
+// sample draws one stream's measurements over [start, end) around rate.
+func (s *Simulator) sample(t *test, start, end, rate float64) sample {
+	seconds := end - start
+	bps := rate * math.Max(0.5, 1+0.02*s.rand.NormFloat64())
+	smp := sample{start: start, end: end, bytes: int64(bps * seconds / 8)}
+	switch {
+	case t.protocol == "UDP":
+		smp.packets = int(math.Round(float64(smp.bytes) / udpBlockSize))
+		smp.bytes = int64(smp.packets) * udpBlockSize
+		if smp.packets >= 10 && s.rand.Float64() < 0.3 {
+			smp.lost = 1 + s.rand.Intn(2)
+		}
+		smp.jitter = math.Max(0.001, 0.02*(1+0.2*s.rand.NormFloat64()))
+	case t.reverse:
+		if s.rand.Float64() < 0.3 {
+			smp.retransmits = 1 + s.rand.Intn(5)
+		}
+		smp.cwnd = int64((1 + 2*s.rand.Float64()) * 1024 * 1024)
+	}
+	return smp
+}
+
+// cpu draws a utilization around total percent.
+func (s *Simulator) cpu(total float64) cpu {
+	v := math.Max(0.1, total*(1+0.1*s.rand.NormFloat64()))
+	user := v * (0.05 + 0.1*s.rand.Float64())
+	return cpu{total: v, user: user, system: v - user}
+}
+
+// cookie draws the session cookie a client sends.
+func (s *Simulator) cookie() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 32)
+	for i := range b {
+		b[i] = alphabet[s.rand.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// total sums a stream's samples, or every stream's when stream is negative,
+// over the intervals reported.
+func (t *test) total(stream int) sample {
+	intervals := t.reported()
+	sum := sample{jitter: -1}
+	for _, samples := range intervals {
+		for i, smp := range samples {
+			if stream >= 0 && i != stream {
+				continue
+			}
+			sum.bytes += smp.bytes
+			sum.retransmits += smp.retransmits
+			sum.lost += smp.lost
+			sum.packets += smp.packets
+			sum.jitter = smp.jitter
+		}
+	}
+	if len(intervals) > 0 {
+		sum.end = intervals[len(intervals)-1][0].end
+	}
+	return sum
+}
+
+// reported returns the intervals reported before the test ended.
+func (t *test) reported() [][]sample {
+	if t.failAfter >= 0 && t.failAfter < len(t.intervals) {
+		return t.intervals[:t.failAfter]
+	}
+	return t.intervals
+}
+
+// bitsPerSecond returns a sample's rate.
+func (smp sample) bitsPerSecond() float64 {
+	seconds := smp.end - smp.start
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(smp.bytes) * 8 / seconds
+}
+
+// lostPercent returns the share of datagrams lost, in percent.
+func (smp sample) lostPercent() float64 {
+	if smp.packets == 0 {
+		return 0
+	}
+	return float64(smp.lost) / float64(smp.packets) * 100
+}
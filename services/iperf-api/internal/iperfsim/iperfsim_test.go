@@ -0,0 +1,291 @@
+package iperfsim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// play runs a scenario without delays and returns its stdout and stderr
+// interleaved, as the manager reads them.
+func play(t *testing.T, cfg Config, tests int) string {
+	t.Helper()
+	cfg.Interval, cfg.Pause = 0, 0
+	cfg.Now = func() time.Time { return time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC) }
+	var out bytes.Buffer
+	if New(cfg, &out, &out).Run(context.Background(), tests) {
+		t.Fatal("Run reported an interrupt")
+	}
+	return out.String()
+}
+
+// parse feeds output through the API's iperf3 parser, returning the results
+// and the errors reported between tests.
+func parse(t *testing.T, output string) ([]*models.TestResult, []string) {
+	t.Helper()
+	parser := iperf.NewParser(models.IperfVersion3, ids.UUID, 0)
+	var results []*models.TestResult
+	var errs []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		r := parser.ParseLine(scanner.Text())
+		switch r.Event {
+		case iperf.EventTestComplete:
+			results = append(results, r.TestResult)
+		case iperf.EventError:
+			errs = append(errs, r.ErrorMessage)
+		}
+	}
+	return results, errs
+}
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= want*tolerance
+}
+
+func TestParseScenario(t *testing.T) {
+	got, err := ParseScenario(" TCP, udp,,parallel ")
+	if err != nil || len(got) != 3 || got[0] != TCP || got[1] != UDP || got[2] != Parallel {
+		t.Errorf("ParseScenario = %v, %v", got, err)
+	}
+	for _, bad := range []string{"", " , ", "tcp,sctp"} {
+		if _, err := ParseScenario(bad); err == nil {
+			t.Errorf("ParseScenario(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSimulator_ParsesAsIperf3(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Verbose = true
+	cfg.Duration = 4
+	cfg.Scenario = []Kind{TCP, Reverse, UDP, Parallel}
+	out := play(t, cfg, 4)
+	if strings.Contains(out, "iperf3: error") {
+		t.Errorf("unexpected error in output:\n%s", out)
+	}
+	results, errs := parse(t, out)
+	if len(results) != 4 || len(errs) != 0 {
+		t.Fatalf("got %d results and errors %v, want 4 results", len(results), errs)
+	}
+
+	tcp := results[0]
+	if tcp.Status != models.TestStatusCompleted || tcp.Protocol != models.ProtocolTCP || tcp.Direction != "upload" {
+		t.Errorf("tcp = %+v", tcp)
+	}
+	if !near(tcp.AvgBandwidth, cfg.Bitrate, 0.05) || !near(tcp.Duration, 4.04, 0.001) {
+		t.Errorf("tcp bandwidth %.0f over %.2fs", tcp.AvgBandwidth, tcp.Duration)
+	}
+	if tcp.ClientIP != "10.0.0.1" || tcp.HostCPUTotal == nil || !tcp.Timestamp.Equal(time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("tcp client %s, cpu %v, time %s", tcp.ClientIP, tcp.HostCPUTotal, tcp.Timestamp)
+	}
+
+	reverse := results[1]
+	if reverse.Direction != "download" || reverse.Retransmits == nil || !near(reverse.Duration, 4, 0.001) {
+		t.Errorf("reverse = %+v", reverse)
+	}
+
+	udp := results[2]
+	if udp.Protocol != models.ProtocolUDP || udp.Jitter == nil || udp.PacketLoss == nil || !near(udp.AvgBandwidth, cfg.UDPBitrate, 0.1) {
+		t.Errorf("udp = %+v", udp)
+	}
+
+	// The parser records the total the SUM lines carry
+	parallel := results[3]
+	if parallel.Status != models.TestStatusCompleted || !near(parallel.AvgBandwidth, cfg.Bitrate, 0.05) || !near(float64(parallel.BytesTransferred), cfg.Bitrate*4.04/8, 0.05) {
+		t.Errorf("parallel bandwidth %.0f, bytes %d", parallel.AvgBandwidth, parallel.BytesTransferred)
+	}
+	if !strings.Contains(out, "[SUM]   0.00-1.00   sec") || strings.Count(out, "(sender statistics not available)") != 1+cfg.Streams {
+		t.Errorf("parallel output lacks per-stream and sum lines:\n%s", out)
+	}
+}
+
+func TestSimulator_ReportInterval(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Duration = 2
+	cfg.ReportInterval = 0.75
+	cfg.Scenario = []Kind{Reverse}
+	out := play(t, cfg, 1)
+	// The last report is cut short at the end of the test
+	for _, want := range []string{"0.00-0.75", "0.75-1.50", "1.50-2.00"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks the %s interval:\n%s", want, out)
+		}
+	}
+	results, _ := parse(t, out)
+	if len(results) != 1 || !near(results[0].Duration, 2, 0.001) || !near(results[0].AvgBandwidth, cfg.Bitrate, 0.05) {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestSimulator_Errors(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Verbose = true
+	cfg.Duration = 4
+	cfg.Scenario = []Kind{Closed, ControlError, TCP}
+	out := play(t, cfg, 3)
+	results, errs := parse(t, out)
+
+	if len(results) != 2 || len(errs) != 1 {
+		t.Fatalf("got %d results and errors %v, want 2 results and 1 error\n%s", len(results), errs, out)
+	}
+	if closed := results[0]; closed.Status != models.TestStatusFailed || closed.ErrorMessage != msgClosed {
+		t.Errorf("closed = %+v", closed)
+	}
+	if errs[0] != msgControl {
+		t.Errorf("error = %q", errs[0])
+	}
+	if results[1].Status != models.TestStatusCompleted {
+		t.Errorf("test after errors = %+v", results[1])
+	}
+	// A failed handshake does not use up a test number
+	if !strings.Contains(out, "(test #2)") || strings.Contains(out, "(test #3)") {
+		t.Errorf("test numbers:\n%s", out)
+	}
+}
+
+func TestSimulator_Repeatable(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Scenario = Kinds
+	first := play(t, cfg, len(Kinds))
+	second := play(t, cfg, len(Kinds))
+	if first != second {
+		t.Error("the same seed gave different output")
+	}
+	cfg.Seed = 2
+	if other := play(t, cfg, len(Kinds)); other == first {
+		t.Error("another seed gave the same output")
+	}
+}
+
+func TestSimulator_JSON(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JSON = true
+	cfg.Duration = 3
+	cfg.Scenario = []Kind{UDP, Closed, ControlError}
+	out := play(t, cfg, 3)
+
+	var docs []map[string]json.RawMessage
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var doc map[string]json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			t.Fatalf("decoding output: %v\n%s", err, out)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) != 3 {
+		t.Fatalf("got %d objects, want 3", len(docs))
+	}
+
+	var end struct {
+		Sum struct {
+			Bytes   int64   `json:"bytes"`
+			Seconds float64 `json:"seconds"`
+			Packets int     `json:"packets"`
+		} `json:"sum"`
+	}
+	var intervals []json.RawMessage
+	json.Unmarshal(docs[0]["end"], &end)
+	json.Unmarshal(docs[0]["intervals"], &intervals)
+	if end.Sum.Packets == 0 || end.Sum.Bytes != int64(end.Sum.Packets)*udpBlockSize || len(intervals) != 4 || docs[0]["error"] != nil {
+		t.Errorf("udp: end %+v, %d intervals", end.Sum, len(intervals))
+	}
+
+	var msg string
+	json.Unmarshal(docs[1]["error"], &msg)
+	json.Unmarshal(docs[1]["intervals"], &intervals)
+	if msg != msgClosed || len(intervals) != 2 {
+		t.Errorf("closed: error %q, %d intervals", msg, len(intervals))
+	}
+	json.Unmarshal(docs[2]["error"], &msg)
+	if msg != msgControl {
+		t.Errorf("control error: error %q", msg)
+	}
+}
+
+func TestSimulator_JSONParses(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JSON = true
+	cfg.Duration = 3
+	cfg.Scenario = []Kind{Reverse, UDP, Closed, ControlError}
+	out := play(t, cfg, 4)
+
+	parser := iperf.NewJSONParser()
+	var results []*models.TestResult
+	var errs []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		r := parser.ParseLine(scanner.Text())
+		switch r.Event {
+		case iperf.EventTestComplete:
+			results = append(results, r.TestResult)
+		case iperf.EventError:
+			errs = append(errs, r.ErrorMessage)
+		}
+	}
+	if len(results) != 3 || len(errs) != 1 || errs[0] != msgControl {
+		t.Fatalf("got %d results and errors %v, want 3 results and a control error", len(results), errs)
+	}
+	if r := results[0]; r.Direction != "download" || r.Retransmits == nil || !near(r.AvgBandwidth, cfg.Bitrate, 0.05) || r.HostCPUTotal == nil {
+		t.Errorf("reverse = %+v", r)
+	}
+	if r := results[1]; r.Protocol != models.ProtocolUDP || r.Jitter == nil || r.PacketLoss == nil || !near(r.AvgBandwidth, cfg.UDPBitrate, 0.1) {
+		t.Errorf("udp = %+v", r)
+	}
+	if r := results[2]; r.Status != models.TestStatusFailed || r.ErrorMessage != msgClosed || r.BytesTransferred == 0 {
+		t.Errorf("closed = %+v", r)
+	}
+}
+
+func TestSimulator_Interrupt(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Verbose = true
+	cfg.Pause = 0
+	cfg.Interval = time.Hour
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- New(cfg, &out, &out).Run(ctx, 0) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case interrupted := <-done:
+		if !interrupted {
+			t.Error("Run did not report the interrupt")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	results, _ := parse(t, out.String())
+	if len(results) != 1 || results[0].Status != models.TestStatusFailed || results[0].ErrorMessage != msgInterrupt {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	for _, tc := range []struct {
+		got, want string
+	}{
+		{transfer(0), "0.00 Bytes"},
+		{transfer(115343360), " 110 MBytes"},
+		{transfer(4718592), "4.50 MBytes"},
+		{bitrate(923e6), " 923 Mbits/sec"},
+		{bitrate(1.05e6), "1.05 Mbits/sec"},
+		{bitrate(21.2e9), "21.2 Gbits/sec"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("got %q, want %q", tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package iperfsim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonTest is the object iperf3 -J prints for a test, with the fields the
+// simulator fills in.
+type jsonTest struct {
+	Start     jsonStart      `json:"start"`
+	Intervals []jsonInterval `json:"intervals"`
+	End       jsonEnd        `json:"end"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type jsonStart struct {
+	Connected          []jsonConnected `json:"connected"`
+	Version            string          `json:"version"`
+	SystemInfo         string          `json:"system_info"`
+	Timestamp          *jsonTimestamp  `json:"timestamp,omitempty"`
+	AcceptedConnection *jsonHost       `json:"accepted_connection,omitempty"`
+	Cookie             string          `json:"cookie,omitempty"`
+	TCPMSSDefault      int             `json:"tcp_mss_default,omitempty"`
+	TestStart          *jsonTestStart  `json:"test_start,omitempty"`
+}
+
+type jsonConnected struct {
+	Socket     int    `json:"socket"`
+	LocalHost  string `json:"local_host"`
+	LocalPort  int    `json:"local_port"`
+	RemoteHost string `json:"remote_host"`
+	RemotePort int    `json:"remote_port"`
+}
+
+type jsonTimestamp struct {
+	Time     string `json:"time"`
+	Timesecs int64  `json:"timesecs"`
+}
+
+type jsonHost struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type jsonTestStart struct {
+	Protocol   string `json:"protocol"`
+	NumStreams int    `json:"num_streams"`
+	Blksize    int    `json:"blksize"`
+	Omit       int    `json:"omit"`
+	Duration   int    `json:"duration"`
+	Bytes      int    `json:"bytes"`
+	Blocks     int    `json:"blocks"`
+	Reverse    int    `json:"reverse"`
+	Tos        int    `json:"tos"`
+}
+
+type jsonInterval struct {
+	Streams []jsonStream `json:"streams"`
+	Sum     jsonStream   `json:"sum"`
+}
+
+// jsonStream is a stream's or a sum's measurements; the TCP and UDP
+// fields are set for those protocols only.
+type jsonStream struct {
+	Socket        int      `json:"socket,omitempty"`
+	Start         float64  `json:"start"`
+	End           float64  `json:"end"`
+	Seconds       float64  `json:"seconds"`
+	Bytes         int64    `json:"bytes"`
+	BitsPerSecond float64  `json:"bits_per_second"`
+	Retransmits   *int     `json:"retransmits,omitempty"`
+	SndCwnd       *int64   `json:"snd_cwnd,omitempty"`
+	JitterMs      *float64 `json:"jitter_ms,omitempty"`
+	LostPackets   *int     `json:"lost_packets,omitempty"`
+	Packets       *int     `json:"packets,omitempty"`
+	LostPercent   *float64 `json:"lost_percent,omitempty"`
+	Omitted       *bool    `json:"omitted,omitempty"`
+	Sender        bool     `json:"sender"`
+}
+
+type jsonEnd struct {
+	Streams            []jsonEndStream `json:"streams,omitempty"`
+	SumSent            *jsonStream     `json:"sum_sent,omitempty"`
+	SumReceived        *jsonStream     `json:"sum_received,omitempty"`
+	Sum                *jsonStream     `json:"sum,omitempty"`
+	CPU                *jsonCPU        `json:"cpu_utilization_percent,omitempty"`
+	SenderCongestion   string          `json:"sender_tcp_congestion,omitempty"`
+	ReceiverCongestion string          `json:"receiver_tcp_congestion,omitempty"`
+}
+
+type jsonEndStream struct {
+	Sender   *jsonStream `json:"sender,omitempty"`
+	Receiver *jsonStream `json:"receiver,omitempty"`
+	UDP      *jsonStream `json:"udp,omitempty"`
+}
+
+type jsonCPU struct {
+	HostTotal    float64 `json:"host_total"`
+	HostUser     float64 `json:"host_user"`
+	HostSystem   float64 `json:"host_system"`
+	RemoteTotal  float64 `json:"remote_total"`
+	RemoteUser   float64 `json:"remote_user"`
+	RemoteSystem float64 `json:"remote_system"`
+}
+
+// playJSON waits out t's intervals and prints it as iperf3 -J does once it
+// ends, reporting false if ctx is done first.
+func (s *Simulator) playJSON(ctx context.Context, t *test) bool {
+	reported := len(t.reported())
+	for i := 0; i < reported; i++ {
+		if !s.sleep(ctx, s.cfg.Interval) {
+			return false
+		}
+		t.played++
+	}
+	doc := s.testJSON(t, reported)
+	if reported < len(t.intervals) {
+		doc.Error = msgClosed
+	} else {
+		doc.End = t.endJSON()
+	}
+	s.writeJSON(doc)
+	return true
+}
+
+// failedJSON is what iperf3 -J prints for a connection that failed before a
+// test started.
+func (s *Simulator) failedJSON(msg string) jsonTest {
+	return jsonTest{
+		Start:     jsonStart{Connected: []jsonConnected{}, Version: Version, SystemInfo: SystemInfo},
+		Intervals: []jsonInterval{},
+		Error:     msg,
+	}
+}
+
+func (s *Simulator) writeJSON(doc jsonTest) {
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		fmt.Fprintf(s.stderr, "iperf3: error - %v\n", err)
+		return
+	}
+	fmt.Fprintln(s.stdout, string(data))
+}
+
+// testJSON returns t's start and its first n intervals; the end is left
+// for a test that finished.
+func (s *Simulator) testJSON(t *test, n int) jsonTest {
+	doc := jsonTest{
+		Start: jsonStart{
+			Version:            Version,
+			SystemInfo:         SystemInfo,
+			Timestamp:          &jsonTimestamp{Time: t.started.Format(timeLayout), Timesecs: t.started.Unix()},
+			AcceptedConnection: &jsonHost{Host: t.client, Port: t.clientPort},
+			Cookie:             t.cookie,
+			TestStart: &jsonTestStart{
+				Protocol:   t.protocol,
+				NumStreams: len(t.sockets),
+				Blksize:    t.blksize,
+				Duration:   s.cfg.Duration,
+			},
+		},
+		Intervals: []jsonInterval{},
+	}
+	if t.protocol == "TCP" {
+		doc.Start.TCPMSSDefault = 1448
+	}
+	if t.reverse {
+		doc.Start.TestStart.Reverse = 1
+	}
+	for i, socket := range t.sockets {
+		doc.Start.Connected = append(doc.Start.Connected, jsonConnected{
+			Socket: socket, LocalHost: t.local, LocalPort: s.cfg.Port,
+			RemoteHost: t.client, RemotePort: t.streamPorts[i],
+		})
+	}
+	if n > len(t.intervals) {
+		n = len(t.intervals)
+	}
+	for _, samples := range t.intervals[:n] {
+		var interval jsonInterval
+		for j, smp := range samples {
+			interval.Streams = append(interval.Streams, t.streamJSON(t.sockets[j], smp, true))
+		}
+		interval.Sum = t.streamJSON(0, sumSamples(samples), true)
+		doc.Intervals = append(doc.Intervals, interval)
+	}
+	return doc
+}
+
+// streamJSON converts a sample. The server's own end is the sender of a
+// reverse test; interval samples are marked as not omitted.
+func (t *test) streamJSON(socket int, smp sample, interval bool) jsonStream {
+	js := jsonStream{
+		Socket:        socket,
+		Start:         smp.start,
+		End:           smp.end,
+		Seconds:       smp.end - smp.start,
+		Bytes:         smp.bytes,
+		BitsPerSecond: smp.bitsPerSecond(),
+		Sender:        t.reverse,
+	}
+	if interval {
+		omitted := false
+		js.Omitted = &omitted
+	}
+	switch {
+	case t.protocol == "UDP":
+		jitter, lost, packets, pct := smp.jitter, smp.lost, smp.packets, smp.lostPercent()
+		js.JitterMs, js.LostPackets, js.Packets, js.LostPercent = &jitter, &lost, &packets, &pct
+	case t.reverse:
+		retransmits := smp.retransmits
+		js.Retransmits = &retransmits
+		if interval {
+			cwnd := smp.cwnd
+			js.SndCwnd = &cwnd
+		}
+	}
+	return js
+}
+
+// endJSON returns the totals of a finished test.
+func (t *test) endJSON() jsonEnd {
+	var end jsonEnd
+	for i, socket := range t.sockets {
+		total := t.streamJSON(socket, t.total(i), false)
+		switch {
+		case t.protocol == "UDP":
+			end.Streams = append(end.Streams, jsonEndStream{UDP: &total})
+		default:
+			sent, received := total, total
+			sent.Sender, received.Sender = true, false
+			received.Retransmits = nil
+			end.Streams = append(end.Streams, jsonEndStream{Sender: &sent, Receiver: &received})
+		}
+	}
+	sum := t.streamJSON(0, t.total(-1), false)
+	if t.protocol == "UDP" {
+		end.Sum = &sum
+	} else {
+		sent, received := sum, sum
+		sent.Sender, received.Sender = true, false
+		received.Retransmits = nil
+		end.SumSent, end.SumReceived = &sent, &received
+		if t.reverse {
+			end.SenderCongestion = "cubic"
+		} else {
+			end.ReceiverCongestion = "cubic"
+		}
+	}
+	end.CPU = &jsonCPU{
+		HostTotal: t.hostCPU.total, HostUser: t.hostCPU.user, HostSystem: t.hostCPU.system,
+		RemoteTotal: t.remoteCPU.total, RemoteUser: t.remoteCPU.user, RemoteSystem: t.remoteCPU.system,
+	}
+	return end
+}
@@ -0,0 +1,230 @@
+package iperfsim
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Version and SystemInfo are what the simulator reports as its iperf3 build
+// and host, as iperf3 -V prints them.
+const (
+	Version    = "iperf 3.12"
+	SystemInfo = "Linux iperf3sim 6.1.0-sim #1 SMP PREEMPT_DYNAMIC x86_64"
+)
+
+const (
+	separator        = "-----------------------------------------------------------"
+	intervalSep      = "- - - - - - - - - - - - - - - - - - - - - - - - -"
+	headerPlain      = "[ ID] Interval           Transfer     Bitrate"
+	headerRetrCwnd   = "[ ID] Interval           Transfer     Bitrate         Retr  Cwnd"
+	headerRetr       = "[ ID] Interval           Transfer     Bitrate         Retr"
+	headerUDP        = "[ ID] Interval           Transfer     Bitrate         Jitter    Lost/Total Datagrams"
+	timeLayout       = "Mon, 02 Jan 2006 15:04:05 GMT"
+	receiverPadding  = "                  receiver"
+	senderRetrFormat = "  %3d             sender"
+)
+
+func (s *Simulator) println(line string) {
+	fmt.Fprintln(s.stdout, line)
+}
+
+// fail prints an iperf3 error to stderr.
+func (s *Simulator) fail(msg string) {
+	fmt.Fprintln(s.stderr, "iperf3: error - "+msg)
+}
+
+// banner prints what iperf3 prints while it waits for a client: with full,
+// the version first when verbose.
+func (s *Simulator) banner(full bool) {
+	if full && s.cfg.Verbose {
+		s.println(Version)
+		s.println(SystemInfo)
+	}
+	s.println(separator)
+	s.println(fmt.Sprintf("Server listening on %d (test #%d)", s.cfg.Port, s.test))
+	s.println(separator)
+}
+
+// playText prints t as iperf3 prints a test, an interval at a time,
+// reporting false if ctx is done before it ends.
+func (s *Simulator) playText(ctx context.Context, t *test) bool {
+	if s.cfg.Verbose {
+		s.println("Time: " + t.started.Format(timeLayout))
+	}
+	s.println(fmt.Sprintf("Accepted connection from %s, port %d", t.client, t.clientPort))
+	if s.cfg.Verbose {
+		s.println("      Cookie: " + t.cookie)
+		if t.protocol == "TCP" {
+			s.println("      TCP MSS: 0 (default)")
+		}
+	}
+	for i, socket := range t.sockets {
+		s.println(fmt.Sprintf("[%3d] local %s port %d connected to %s port %d", socket, t.local, s.cfg.Port, t.client, t.streamPorts[i]))
+	}
+	if s.cfg.Verbose {
+		s.println(fmt.Sprintf("Starting Test: protocol: %s, %d streams, %d byte blocks, omitting 0 seconds, %d second test, tos 0",
+			t.protocol, len(t.sockets), t.blksize, s.cfg.Duration))
+	}
+	s.println(t.intervalHeader())
+
+	multi := len(t.sockets) > 1
+	for i, samples := range t.intervals {
+		if !s.sleep(ctx, s.cfg.Interval) {
+			return false
+		}
+		if i == t.failAfter {
+			s.fail(msgClosed)
+			return true
+		}
+		for j, smp := range samples {
+			s.println(t.intervalLine(strconv.Itoa(t.sockets[j]), smp))
+		}
+		if multi {
+			s.println(t.intervalLine("SUM", sumSamples(samples)))
+			s.println(intervalSep)
+		}
+		t.played++
+	}
+	if !multi {
+		s.println(intervalSep)
+	}
+
+	if s.cfg.Verbose {
+		s.println("Test Complete. Summary Results:")
+	}
+	s.println(t.summaryHeader())
+	for i, socket := range t.sockets {
+		for _, line := range t.summaryLines(strconv.Itoa(socket), t.total(i)) {
+			s.println(line)
+		}
+	}
+	if multi {
+		sum := t.total(-1)
+		s.println(t.summaryLine("SUM", sum))
+	}
+	if s.cfg.Verbose {
+		local, remote := "receiver", "sender"
+		if t.reverse {
+			local, remote = remote, local
+		}
+		s.println(fmt.Sprintf("CPU Utilization: local/%s %.1f%% (%.1f%%u/%.1f%%s), remote/%s %.1f%% (%.1f%%u/%.1f%%s)",
+			local, t.hostCPU.total, t.hostCPU.user, t.hostCPU.system,
+			remote, t.remoteCPU.total, t.remoteCPU.user, t.remoteCPU.system))
+		switch {
+		case t.protocol == "UDP":
+		case t.reverse:
+			s.println("snd_tcp_congestion cubic")
+		default:
+			s.println("rcv_tcp_congestion cubic")
+		}
+	}
+	return true
+}
+
+// sumSamples adds up the streams of an interval.
+func sumSamples(samples []sample) sample {
+	sum := samples[0]
+	for _, smp := range samples[1:] {
+		sum.bytes += smp.bytes
+		sum.retransmits += smp.retransmits
+		sum.cwnd += smp.cwnd
+		sum.lost += smp.lost
+		sum.packets += smp.packets
+	}
+	return sum
+}
+
+func (t *test) intervalHeader() string {
+	switch {
+	case t.protocol == "UDP":
+		return headerUDP
+	case t.reverse:
+		return headerRetrCwnd
+	}
+	return headerPlain
+}
+
+func (t *test) summaryHeader() string {
+	switch {
+	case t.protocol == "UDP":
+		return headerUDP
+	case t.reverse:
+		return headerRetr
+	}
+	return headerPlain
+}
+
+// intervalLine formats a sample as iperf3 reports an interval.
+func (t *test) intervalLine(id string, smp sample) string {
+	line := rateLine(id, smp)
+	switch {
+	case t.protocol == "UDP":
+		line += udpColumns(smp)
+	case t.reverse:
+		line += fmt.Sprintf("  %3d   %s", smp.retransmits, transfer(float64(smp.cwnd)))
+	}
+	return line
+}
+
+// summaryLines formats a stream's totals as iperf3 summarises them: a TCP
+// server only has the statistics of its own end.
+func (t *test) summaryLines(id string, total sample) []string {
+	switch {
+	case t.protocol == "UDP":
+		return []string{t.summaryLine(id, total)}
+	case t.reverse:
+		return []string{t.summaryLine(id, total), fmt.Sprintf("[%3s] (receiver statistics not available)", id)}
+	}
+	return []string{fmt.Sprintf("[%3s] (sender statistics not available)", id), t.summaryLine(id, total)}
+}
+
+// summaryLine formats totals as the summary line of the server's end.
+func (t *test) summaryLine(id string, total sample) string {
+	line := rateLine(id, total)
+	switch {
+	case t.protocol == "UDP":
+		return line + udpColumns(total) + "  receiver"
+	case t.reverse:
+		return line + fmt.Sprintf(senderRetrFormat, total.retransmits)
+	}
+	return line + receiverPadding
+}
+
+// rateLine formats the interval, transfer and bitrate columns.
+func rateLine(id string, smp sample) string {
+	return fmt.Sprintf("[%3s] %6.2f-%-6.2f sec  %s  %s", id, smp.start, smp.end, transfer(float64(smp.bytes)), bitrate(smp.bitsPerSecond()))
+}
+
+// udpColumns formats the jitter and loss columns.
+func udpColumns(smp sample) string {
+	return fmt.Sprintf("  %5.3f ms  %d/%d (%.2g%%)", smp.jitter, smp.lost, smp.packets, smp.lostPercent())
+}
+
+// transfer formats bytes as iperf3 does, in binary units.
+func transfer(bytes float64) string {
+	return adaptive(bytes, 1024, []string{"Bytes", "KBytes", "MBytes", "GBytes"})
+}
+
+// bitrate formats bits per second as iperf3 does, in decimal units.
+func bitrate(bps float64) string {
+	return adaptive(bps, 1000, []string{"bits/sec", "Kbits/sec", "Mbits/sec", "Gbits/sec"})
+}
+
+// adaptive picks the largest unit v reaches and shows three significant
+// figures, as iperf3's unit_snprintf does.
+func adaptive(v, base float64, units []string) string {
+	i := 0
+	for v >= base && i < len(units)-1 {
+		v /= base
+		i++
+	}
+	format := "%4.0f %s"
+	switch {
+	case v < 9.995:
+		format = "%4.2f %s"
+	case v < 99.95:
+		format = "%4.1f %s"
+	}
+	return fmt.Sprintf(format, v, units[i])
+}
@@ -0,0 +1,95 @@
+// Package linkload measures how busy the probe's uplink is, from interface
+// counters on the host or an SNMP agent on the upstream router, so scheduled
+// tests can wait rather than measure or add to congested traffic.
+package linkload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Counters are an interface's cumulative byte counters.
+type Counters struct {
+	RxBytes uint64
+	TxBytes uint64
+	// SpeedBps is the link speed in bits per second, or 0 if unknown
+	SpeedBps float64
+}
+
+// Source reads an interface's counters.
+type Source interface {
+	Name() string
+	Counters(ctx context.Context) (Counters, error)
+}
+
+// DefaultInterval is the time between the two counter readings of a
+// measurement.
+const DefaultInterval = 2 * time.Second
+
+// Monitor measures link utilisation against a threshold.
+type Monitor struct {
+	source    Source
+	capacity  float64
+	interval  time.Duration
+	threshold float64
+}
+
+// NewMonitor creates a Monitor. capacityBps overrides the link speed the
+// source reports; threshold is the utilisation, in percent, above which the
+// link is busy.
+func NewMonitor(source Source, capacityBps float64, interval time.Duration, threshold float64) *Monitor {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Monitor{source: source, capacity: capacityBps, interval: interval, threshold: threshold}
+}
+
+// Measure reads the counters twice, interval apart, and returns the
+// utilisation of the busier direction.
+func (m *Monitor) Measure(ctx context.Context) (models.LinkUtilization, error) {
+	first, err := m.source.Counters(ctx)
+	if err != nil {
+		return models.LinkUtilization{}, err
+	}
+	start := time.Now()
+
+	select {
+	case <-time.After(m.interval):
+	case <-ctx.Done():
+		return models.LinkUtilization{}, ctx.Err()
+	}
+
+	second, err := m.source.Counters(ctx)
+	if err != nil {
+		return models.LinkUtilization{}, err
+	}
+	elapsed := time.Since(start).Seconds()
+	if second.RxBytes < first.RxBytes || second.TxBytes < first.TxBytes {
+		return models.LinkUtilization{}, errors.New("interface counters went backwards")
+	}
+
+	capacity := m.capacity
+	if capacity <= 0 {
+		capacity = second.SpeedBps
+	}
+	if capacity <= 0 {
+		return models.LinkUtilization{}, fmt.Errorf("%s: link speed unknown; set the capacity", m.source.Name())
+	}
+
+	u := models.LinkUtilization{
+		Source:          m.source.Name(),
+		MeasuredAt:      time.Now(),
+		RxBitsPerSecond: float64(second.RxBytes-first.RxBytes) * 8 / elapsed,
+		TxBitsPerSecond: float64(second.TxBytes-first.TxBytes) * 8 / elapsed,
+		CapacityBps:     capacity,
+		Threshold:       m.threshold,
+	}
+	u.Percent = math.Max(u.RxBitsPerSecond, u.TxBitsPerSecond) / capacity * 100
+	u.Busy = m.threshold > 0 && u.Percent > m.threshold
+	return u, nil
+}
@@ -0,0 +1,198 @@
+package linkload
+
+import (
+	"context"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rampSource adds a fixed number of bytes per read.
+type rampSource struct {
+	rx, tx   uint64
+	step     uint64
+	speedBps float64
+}
+
+func (r *rampSource) Name() string { return "ramp" }
+
+func (r *rampSource) Counters(context.Context) (Counters, error) {
+	c := Counters{RxBytes: r.rx, TxBytes: r.tx, SpeedBps: r.speedBps}
+	r.rx += r.step
+	r.tx += r.step / 2
+	return c, nil
+}
+
+func TestMonitor_Measure(t *testing.T) {
+	// 12.5 MB received in about 100ms is about 1 Gbit/s
+	src := &rampSource{step: 12_500_000, speedBps: 10e9}
+	m := NewMonitor(src, 0, 100*time.Millisecond, 5)
+
+	u, err := m.Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Measure: %v", err)
+	}
+	if u.Source != "ramp" || u.CapacityBps != 10e9 || u.Threshold != 5 {
+		t.Errorf("reading = %+v", u)
+	}
+	if u.Percent < 7 || u.Percent > 10.5 {
+		t.Errorf("Percent = %.2f, want about 10", u.Percent)
+	}
+	if math.Abs(u.RxBitsPerSecond-2*u.TxBitsPerSecond) > 1 {
+		t.Errorf("rx %.0f, tx %.0f: want rx twice tx", u.RxBitsPerSecond, u.TxBitsPerSecond)
+	}
+	if !u.Busy {
+		t.Error("Busy = false above the threshold")
+	}
+
+	// A configured capacity overrides the reported speed
+	m = NewMonitor(&rampSource{step: 12_500_000, speedBps: 10e9}, 100e9, 100*time.Millisecond, 5)
+	if u, err := m.Measure(context.Background()); err != nil || u.Busy || u.CapacityBps != 100e9 {
+		t.Errorf("Measure = %+v, %v; want idle against 100 Gbit/s", u, err)
+	}
+
+	// Without a speed or capacity there is nothing to compare against
+	m = NewMonitor(&rampSource{step: 1}, 0, time.Millisecond, 5)
+	if _, err := m.Measure(context.Background()); err == nil {
+		t.Error("Measure succeeded without a link speed")
+	}
+}
+
+func TestInterface(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "eth0")
+	os.MkdirAll(filepath.Join(dir, "statistics"), 0o755)
+	os.WriteFile(filepath.Join(dir, "statistics", "rx_bytes"), []byte("123456\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "statistics", "tx_bytes"), []byte("654321\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "speed"), []byte("1000\n"), 0o644)
+
+	i, err := NewInterface(root, "eth0")
+	if err != nil {
+		t.Fatalf("NewInterface: %v", err)
+	}
+	c, err := i.Counters(context.Background())
+	if err != nil {
+		t.Fatalf("Counters: %v", err)
+	}
+	if c != (Counters{RxBytes: 123456, TxBytes: 654321, SpeedBps: 1e9}) {
+		t.Errorf("Counters = %+v", c)
+	}
+
+	// Virtual interfaces report an unknown speed as -1
+	os.WriteFile(filepath.Join(dir, "speed"), []byte("-1\n"), 0o644)
+	if c, _ := i.Counters(context.Background()); c.SpeedBps != 0 {
+		t.Errorf("SpeedBps = %v, want 0 when unknown", c.SpeedBps)
+	}
+
+	if _, err := NewInterface(root, "eth1"); err == nil {
+		t.Error("NewInterface succeeded for a missing interface")
+	}
+}
+
+// fakeAgent answers SNMP GETs with fixed values for ifIndex 3.
+func fakeAgent(t *testing.T, in, out uint64, highSpeed uint64) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg, _, _ := expect(buf[:n], tagSequence)
+			_, msg, _ = expect(msg, tagInteger)
+			community, msg, _ := expect(msg, tagOctetString)
+			pdu, _, _ := expect(msg, tagGetRequest)
+			id, _, _ := expect(pdu, tagInteger)
+			if string(community) != "secret" {
+				continue
+			}
+
+			var bindings []byte
+			for _, v := range []struct {
+				oid   string
+				tag   byte
+				value uint64
+			}{
+				{oidIfHCInOctets + ".3", tagCounter64, in},
+				{oidIfHCOutOctets + ".3", tagCounter64, out},
+				{oidIfHighSpeed + ".3", tagGauge32, highSpeed},
+			} {
+				name, _ := encodeOID(v.oid)
+				value := encodeInt(int64(v.value))[2:]
+				bindings = append(bindings, tlv(tagSequence, append(name, tlv(v.tag, value)...))...)
+			}
+			resp := tlv(tagInteger, id)
+			resp = append(resp, encodeInt(0)...)
+			resp = append(resp, encodeInt(0)...)
+			resp = append(resp, tlv(tagSequence, bindings)...)
+			reply := append(encodeInt(snmpV2c), tlv(tagOctetString, community)...)
+			reply = append(reply, tlv(tagResponse, resp)...)
+			conn.WriteTo(tlv(tagSequence, reply), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSNMP(t *testing.T) {
+	addr := fakeAgent(t, 1<<40, 300, 10000)
+
+	s := NewSNMP(addr, "secret", 3, time.Second)
+	c, err := s.Counters(context.Background())
+	if err != nil {
+		t.Fatalf("Counters: %v", err)
+	}
+	if c != (Counters{RxBytes: 1 << 40, TxBytes: 300, SpeedBps: 10e9}) {
+		t.Errorf("Counters = %+v", c)
+	}
+
+	// The agent ignores other communities, so the request times out
+	s = NewSNMP(addr, "public", 3, 100*time.Millisecond)
+	if _, err := s.Counters(context.Background()); err == nil {
+		t.Error("Counters succeeded with the wrong community")
+	}
+}
+
+func TestEncodeOID(t *testing.T) {
+	got, err := encodeOID("1.3.6.1.2.1.31.1.1.1.6.200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{tagOID, 12, 0x2b, 6, 1, 2, 1, 31, 1, 1, 1, 6, 0x81, 0x48}
+	if string(got) != string(want) {
+		t.Errorf("encodeOID = % x, want % x", got, want)
+	}
+	if _, err := encodeOID("1.x"); err == nil {
+		t.Error("encodeOID accepted a bad OID")
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		wantErr string
+	}{
+		{Config{Kind: "interface"}, "name is required"},
+		{Config{Kind: "snmp", Target: "192.0.2.1"}, "interface index"},
+		{Config{Kind: "netflow", Target: "x"}, "unknown link source"},
+	}
+	for _, tt := range tests {
+		if _, err := NewSource(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("NewSource(%+v) err = %v, want %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+	src, err := NewSource(Config{Kind: "snmp", Target: "192.0.2.1", IfIndex: 4})
+	if err != nil || src.Name() != "192.0.2.1:161 ifIndex 4" {
+		t.Errorf("NewSource snmp = %v, %v", src, err)
+	}
+}
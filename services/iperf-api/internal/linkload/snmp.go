@@ -0,0 +1,303 @@
+package linkload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ifXTable columns, indexed by the interface's ifIndex.
+const (
+	oidIfHCInOctets  = "1.3.6.1.2.1.31.1.1.1.6"
+	oidIfHCOutOctets = "1.3.6.1.2.1.31.1.1.1.10"
+	oidIfHighSpeed   = "1.3.6.1.2.1.31.1.1.1.15"
+)
+
+// SNMP reads a router interface's 64-bit counters with an SNMPv2c GET.
+type SNMP struct {
+	addr      string
+	community string
+	ifIndex   int
+	timeout   time.Duration
+}
+
+// NewSNMP creates a source for interface ifIndex on the agent at addr
+// (host[:port], port 161 by default).
+func NewSNMP(addr, community string, ifIndex int, timeout time.Duration) *SNMP {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "161")
+	}
+	if community == "" {
+		community = "public"
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &SNMP{addr: addr, community: community, ifIndex: ifIndex, timeout: timeout}
+}
+
+// Name implements Source.
+func (s *SNMP) Name() string {
+	return fmt.Sprintf("%s ifIndex %d", s.addr, s.ifIndex)
+}
+
+// Counters implements Source.
+func (s *SNMP) Counters(ctx context.Context) (Counters, error) {
+	idx := "." + strconv.Itoa(s.ifIndex)
+	values, err := s.get(ctx, oidIfHCInOctets+idx, oidIfHCOutOctets+idx, oidIfHighSpeed+idx)
+	if err != nil {
+		return Counters{}, fmt.Errorf("%s: %w", s.Name(), err)
+	}
+	c := Counters{RxBytes: values[0], TxBytes: values[1]}
+	// ifHighSpeed is in units of 1,000,000 bits per second
+	c.SpeedBps = float64(values[2]) * 1e6
+	return c, nil
+}
+
+// get requests oids and returns their integer values in order.
+func (s *SNMP) get(ctx context.Context, oids ...string) ([]uint64, error) {
+	requestID := rand.Int31()
+	req, err := encodeGetRequest(s.community, requestID, oids)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		id, values, err := decodeResponse(buf[:n], len(oids))
+		if err != nil {
+			return nil, err
+		}
+		// A late reply to an earlier request is skipped
+		if id == requestID {
+			return values, nil
+		}
+	}
+}
+
+// BER tags used by SNMP.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagCounter64   = 0x46
+	tagNoSuchObj   = 0x80
+	tagNoSuchInst  = 0x81
+	tagEndOfMib    = 0x82
+	tagGetRequest  = 0xa0
+	tagResponse    = 0xa2
+)
+
+// snmpV2c is the version field's value for SNMPv2c.
+const snmpV2c = 1
+
+func encodeGetRequest(community string, requestID int32, oids []string) ([]byte, error) {
+	var bindings []byte
+	for _, oid := range oids {
+		name, err := encodeOID(oid)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, tlv(tagSequence, append(name, tagNull, 0))...)
+	}
+	pdu := encodeInt(int64(requestID))
+	pdu = append(pdu, encodeInt(0)...) // error-status
+	pdu = append(pdu, encodeInt(0)...) // error-index
+	pdu = append(pdu, tlv(tagSequence, bindings)...)
+
+	msg := encodeInt(snmpV2c)
+	msg = append(msg, tlv(tagOctetString, []byte(community))...)
+	msg = append(msg, tlv(tagGetRequest, pdu)...)
+	return tlv(tagSequence, msg), nil
+}
+
+func tlv(tag byte, value []byte) []byte {
+	b := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, value...)
+}
+
+func encodeInt(v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		// Stop once the remaining bits are only the sign extension
+		if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return tlv(tagInteger, b)
+}
+
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = v
+	}
+	b := base128(arcs[0]*40 + arcs[1])
+	for _, arc := range arcs[2:] {
+		b = append(b, base128(arc)...)
+	}
+	return tlv(tagOID, b), nil
+}
+
+func base128(v uint64) []byte {
+	b := []byte{byte(v & 0x7f)}
+	for v >>= 7; v > 0; v >>= 7 {
+		b = append([]byte{byte(v&0x7f) | 0x80}, b...)
+	}
+	return b
+}
+
+var errMalformed = errors.New("malformed SNMP response")
+
+// readTLV splits the first element off b.
+func readTLV(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 2 || len(b) < size {
+			return 0, nil, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// expect reads an element that must have tag.
+func expect(b []byte, tag byte) (value, rest []byte, err error) {
+	got, value, rest, err := readTLV(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if got != tag {
+		return nil, nil, errMalformed
+	}
+	return value, rest, nil
+}
+
+func decodeUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// decodeResponse returns a Response PDU's request ID and its want integer
+// values.
+func decodeResponse(b []byte, want int) (int32, []uint64, error) {
+	msg, _, err := expect(b, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, msg, err = expect(msg, tagInteger); err != nil { // version
+		return 0, nil, err
+	}
+	if _, msg, err = expect(msg, tagOctetString); err != nil { // community
+		return 0, nil, err
+	}
+	pdu, _, err := expect(msg, tagResponse)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, pdu, err := expect(pdu, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	status, pdu, err := expect(pdu, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, pdu, err = expect(pdu, tagInteger); err != nil { // error-index
+		return 0, nil, err
+	}
+	if s := decodeUint(status); s != 0 {
+		return 0, nil, fmt.Errorf("agent returned error status %d", s)
+	}
+	bindings, _, err := expect(pdu, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var values []uint64
+	for len(bindings) > 0 {
+		var binding []byte
+		if binding, bindings, err = expect(bindings, tagSequence); err != nil {
+			return 0, nil, err
+		}
+		if _, binding, err = expect(binding, tagOID); err != nil {
+			return 0, nil, err
+		}
+		tag, value, _, err := readTLV(binding)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch tag {
+		case tagCounter32, tagGauge32, tagCounter64, tagInteger:
+			values = append(values, decodeUint(value))
+		case tagNoSuchObj, tagNoSuchInst, tagEndOfMib:
+			return 0, nil, errors.New("agent has no such interface counter")
+		default:
+			return 0, nil, fmt.Errorf("unexpected value type 0x%02x", tag)
+		}
+	}
+	if len(values) != want {
+		return 0, nil, errMalformed
+	}
+	return int32(decodeUint(id)), values, nil
+}
@@ -0,0 +1,99 @@
+package linkload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSysfsRoot is where Linux exposes network interfaces.
+const DefaultSysfsRoot = "/sys/class/net"
+
+// Interface reads a local interface's counters from sysfs.
+type Interface struct {
+	dir  string
+	name string
+}
+
+// NewInterface creates a source for the named interface under root,
+// checking that its counters are readable.
+func NewInterface(root, name string) (*Interface, error) {
+	if root == "" {
+		root = DefaultSysfsRoot
+	}
+	i := &Interface{dir: filepath.Join(root, name), name: name}
+	if _, err := i.read("statistics/rx_bytes"); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Name implements Source.
+func (i *Interface) Name() string {
+	return i.name
+}
+
+// Counters implements Source. The speed is unknown for virtual and
+// wireless interfaces, which report none or -1.
+func (i *Interface) Counters(ctx context.Context) (Counters, error) {
+	var c Counters
+	var err error
+	if c.RxBytes, err = i.read("statistics/rx_bytes"); err != nil {
+		return Counters{}, err
+	}
+	if c.TxBytes, err = i.read("statistics/tx_bytes"); err != nil {
+		return Counters{}, err
+	}
+	if data, err := os.ReadFile(filepath.Join(i.dir, "speed")); err == nil {
+		if mbps, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && mbps > 0 {
+			c.SpeedBps = float64(mbps) * 1e6
+		}
+	}
+	return c, nil
+}
+
+func (i *Interface) read(name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(i.dir, name))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", i.name, err)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: parsing %s: %w", i.name, name, err)
+	}
+	return v, nil
+}
+
+// Config selects and configures a Source.
+type Config struct {
+	// Kind is "interface" or "snmp"
+	Kind string
+	// Target is the interface name, or the SNMP agent's host[:port]
+	Target string
+	// Community and IfIndex identify the router interface for SNMP
+	Community string
+	IfIndex   int
+	Timeout   time.Duration
+}
+
+// NewSource creates the Source described by cfg.
+func NewSource(cfg Config) (Source, error) {
+	switch cfg.Kind {
+	case "interface":
+		if cfg.Target == "" {
+			return nil, fmt.Errorf("interface: name is required")
+		}
+		return NewInterface("", cfg.Target)
+	case "snmp":
+		if cfg.Target == "" || cfg.IfIndex <= 0 {
+			return nil, fmt.Errorf("snmp: agent address and interface index are required")
+		}
+		return NewSNMP(cfg.Target, cfg.Community, cfg.IfIndex, cfg.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown link source %q", cfg.Kind)
+	}
+}
@@ -1,6 +1,13 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // ServerStatus represents the current state of the iPerf server
 type ServerStatus string
@@ -19,6 +26,40 @@ const (
 	ProtocolUDP Protocol = "udp"
 )
 
+// IperfVersion selects which iperf implementation the server runs
+type IperfVersion string
+
+const (
+	IperfVersion3 IperfVersion = "iperf3"
+	IperfVersion2 IperfVersion = "iperf2"
+)
+
+// AddressFamily restricts the server to one IP version
+type AddressFamily string
+
+const (
+	// AddressFamilyAny accepts both IPv4 and IPv6 clients where the host
+	// allows it
+	AddressFamilyAny  AddressFamily = ""
+	AddressFamilyIPv4 AddressFamily = "ipv4"
+	AddressFamilyIPv6 AddressFamily = "ipv6"
+)
+
+// OutputMode selects how iperf3 reports tests
+type OutputMode string
+
+const (
+	// OutputModeAuto uses JSON where iperf3 can stream it as the test runs
+	// (3.17 and later) and text otherwise
+	OutputModeAuto OutputMode = "auto"
+	// OutputModeText parses iperf3's text output, reported as each interval
+	// ends
+	OutputModeText OutputMode = "text"
+	// OutputModeJSON runs iperf3 with -J for exact figures. Before 3.17 the
+	// JSON only arrives when a test ends, so there are no live intervals.
+	OutputModeJSON OutputMode = "json"
+)
+
 // ServerConfig holds the configuration for the iPerf server
 type ServerConfig struct {
 	Port        int      `json:"port"`
@@ -27,6 +68,53 @@ type ServerConfig struct {
 	OneOff      bool     `json:"oneOff"`
 	IdleTimeout int      `json:"idleTimeout"`
 	Allowlist   []string `json:"allowlist,omitempty"`
+	// AutoRearm relaunches a one-off server after each test so it stays
+	// running, one client at a time
+	AutoRearm bool `json:"autoRearm,omitempty"`
+	// Version selects iperf3 (default) or classic iperf2 for legacy clients
+	Version IperfVersion `json:"version,omitempty"`
+	// PortCount runs a pool of listeners on consecutive ports from Port so
+	// several clients can test at once; 0 or 1 runs a single listener
+	PortCount int `json:"portCount,omitempty"`
+	// AddressFamily listens on IPv4 or IPv6 only; empty listens on both
+	AddressFamily AddressFamily `json:"addressFamily,omitempty"`
+	// OutputMode selects text or JSON iperf3 output; empty uses the
+	// server's default
+	OutputMode OutputMode `json:"outputMode,omitempty"`
+	// IntervalSeconds is the time between iperf's interval reports (-i),
+	// which may be under a second; zero reports every second
+	IntervalSeconds float64 `json:"intervalSeconds,omitempty"`
+}
+
+// ReportInterval returns the seconds between the server's interval reports.
+func (c ServerConfig) ReportInterval() float64 {
+	if c.IntervalSeconds > 0 {
+		return c.IntervalSeconds
+	}
+	return 1
+}
+
+// Ports returns the ports the server listens on.
+func (c ServerConfig) Ports() []int {
+	n := c.PortCount
+	if n < 1 {
+		n = 1
+	}
+	ports := make([]int, n)
+	for i := range ports {
+		ports[i] = c.Port + i
+	}
+	return ports
+}
+
+// ListenAddr returns the address the server listens on, with the range of
+// ports for a pool, e.g. "0.0.0.0:5201-5210" or "[2001:db8::1]:5201".
+func (c ServerConfig) ListenAddr() string {
+	addr := net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port))
+	if c.PortCount > 1 {
+		addr += fmt.Sprintf("-%d", c.Port+c.PortCount-1)
+	}
+	return addr
 }
 
 // DefaultServerConfig returns a ServerConfig with sensible defaults
@@ -38,29 +126,184 @@ func DefaultServerConfig() ServerConfig {
 		OneOff:      false,
 		IdleTimeout: 300,
 		Allowlist:   nil,
+		Version:     IperfVersion3,
 	}
 }
 
+// TestStatus represents how an iPerf test session ended
+type TestStatus string
+
+const (
+	TestStatusCompleted TestStatus = "completed"
+	TestStatusAborted   TestStatus = "aborted"
+	TestStatusFailed    TestStatus = "failed"
+)
+
+// QualityFlag marks a result whose measurements are suspect
+type QualityFlag string
+
+const (
+	QualityFlagShortDuration    QualityFlag = "short_duration"
+	QualityFlagZeroBytes        QualityFlag = "zero_bytes"
+	QualityFlagZeroMinBandwidth QualityFlag = "zero_min_bandwidth"
+	QualityFlagClockSkew        QualityFlag = "clock_skew"
+)
+
+// Valid reports whether the flag is one results are marked with.
+func (f QualityFlag) Valid() bool {
+	switch f {
+	case QualityFlagShortDuration, QualityFlagZeroBytes, QualityFlagZeroMinBandwidth, QualityFlagClockSkew:
+		return true
+	}
+	return false
+}
+
+// Precision says how exactly a result's byte counts were measured
+type Precision string
+
+const (
+	// PrecisionExact counts are iperf3's own counters, from JSON output
+	PrecisionExact Precision = "exact"
+	// PrecisionCorrected counts are the total of text output's interval
+	// lines, which was more precise than the summary line and within its
+	// rounding
+	PrecisionCorrected Precision = "corrected"
+	// PrecisionRounded counts were read back from text output, which iperf
+	// rounds to three significant figures
+	PrecisionRounded Precision = "rounded"
+)
+
 // TestResult represents the results of a completed iPerf test
 type TestResult struct {
-	ID               string    `json:"id"`
-	Timestamp        time.Time `json:"timestamp"`
-	ClientIP         string    `json:"clientIp"`
-	ClientPort       int       `json:"clientPort"`
-	Protocol         Protocol  `json:"protocol"`
-	Duration         float64   `json:"duration"`
-	BytesTransferred int64     `json:"bytesTransferred"`
-	AvgBandwidth     float64   `json:"avgBandwidth"`
-	MaxBandwidth     float64   `json:"maxBandwidth"`
-	MinBandwidth     float64   `json:"minBandwidth"`
-	Retransmits      *int      `json:"retransmits,omitempty"`
-	Jitter           *float64  `json:"jitter,omitempty"`
-	PacketLoss       *float64  `json:"packetLoss,omitempty"`
-	Direction        string    `json:"direction"`
+	ID string `json:"id"`
+	// Timestamp is when the client connected by iperf3's own clock, or when
+	// the result was parsed if the output does not say
+	Timestamp        time.Time  `json:"timestamp"`
+	ClientIP         string     `json:"clientIp"`
+	ClientPort       int        `json:"clientPort"`
+	ServerPort       int        `json:"serverPort,omitempty"`
+	Protocol         Protocol   `json:"protocol"`
+	Duration         float64    `json:"duration"`
+	BytesTransferred int64      `json:"bytesTransferred"`
+	AvgBandwidth     float64    `json:"avgBandwidth"`
+	MaxBandwidth     float64    `json:"maxBandwidth"`
+	MinBandwidth     float64    `json:"minBandwidth"`
+	Retransmits      *int       `json:"retransmits,omitempty"`
+	Jitter           *float64   `json:"jitter,omitempty"`
+	PacketLoss       *float64   `json:"packetLoss,omitempty"`
+	Direction        string     `json:"direction"`
+	Status           TestStatus `json:"status"`
+	ErrorMessage     string     `json:"errorMessage,omitempty"`
+	// RequestedDuration is the test length the client asked for, when iperf3 reports it
+	RequestedDuration *float64      `json:"requestedDuration,omitempty"`
+	QualityFlags      []QualityFlag `json:"qualityFlags,omitempty"`
+	// EnergyJoules is the host energy used during the test, when a power meter is configured
+	EnergyJoules *float64 `json:"energyJoules,omitempty"`
+	JoulesPerGB  *float64 `json:"joulesPerGb,omitempty"`
+	// HostCPUTotal and RemoteCPUTotal are the CPU utilization percentages
+	// iperf3 reported for this server and the client, from its verbose
+	// output. A value near 100 suggests a CPU-bound test.
+	HostCPUTotal   *float64 `json:"hostCpuTotal,omitempty"`
+	RemoteCPUTotal *float64 `json:"remoteCpuTotal,omitempty"`
+	// Sender and Receiver are the totals iperf3 reported for each end of
+	// the test, whichever this server was. Bytes sent but not received were
+	// lost or still buffered when the test ended.
+	Sender   *SideStats `json:"sender,omitempty"`
+	Receiver *SideStats `json:"receiver,omitempty"`
+	// Precision is how exactly BytesTransferred and the test's own end were
+	// measured; empty for results saved before it was recorded
+	Precision Precision `json:"precision,omitempty"`
+	// Percentiles summarise the bandwidth of the test's intervals, for
+	// results saved with interval samples
+	Percentiles *BandwidthPercentiles `json:"percentiles,omitempty"`
+	// RetransmitsPerGB, GoodputRatio and JitterRatio are derived when the
+	// result is saved: TCP retransmits per gigabyte transferred, the share
+	// of the configured link speed the test achieved, and UDP jitter in
+	// milliseconds per Mbps of bandwidth
+	RetransmitsPerGB *float64 `json:"retransmitsPerGb,omitempty"`
+	GoodputRatio     *float64 `json:"goodputRatio,omitempty"`
+	JitterRatio      *float64 `json:"jitterRatio,omitempty"`
+	// LinkCapacity is the declared link speed of the client, in bits per
+	// second, and Utilization the average bandwidth as a percentage of it,
+	// for clients with a declared capacity when the result was saved
+	LinkCapacity *float64 `json:"linkCapacity,omitempty"`
+	Utilization  *float64 `json:"utilization,omitempty"`
+	// Client is what the control connection revealed about the client's settings
+	Client *ClientFingerprint `json:"client,omitempty"`
+	// Source and CorrelationID identify the queued job that ran the test
+	Source        JobSource `json:"source,omitempty"`
+	CorrelationID string    `json:"correlationId,omitempty"`
+	// Geo is where the client is, when GeoIP databases are configured
+	Geo *GeoInfo `json:"geo,omitempty"`
+	// Tags and Note are added by users after the test
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+	// Site is the federation peer the result was pulled from; results of
+	// this instance's own tests have none
+	Site string `json:"site,omitempty"`
+	// Node is the name of the deployment that ran the test, which pulled
+	// and imported results keep
+	Node string `json:"node,omitempty"`
+}
+
+// SideStats is what one end of a test measured over the whole test, summed
+// over its streams
+type SideStats struct {
+	Bytes     int64   `json:"bytes"`
+	Bandwidth float64 `json:"bandwidth"`
+	// Retransmits is reported by a TCP sender on platforms that count them
+	Retransmits *int `json:"retransmits,omitempty"`
+}
+
+// ClientFingerprint describes the client and the test parameters it requested.
+// Version, Window, Bandwidth, Congestion and Features are only known when the
+// server runs iperf3 with --debug.
+type ClientFingerprint struct {
+	Version   string   `json:"version,omitempty"`
+	Protocol  Protocol `json:"protocol,omitempty"`
+	Streams   int      `json:"streams,omitempty"`
+	BlockSize int      `json:"blockSize,omitempty"`
+	Omit      int      `json:"omit,omitempty"`
+	Duration  int      `json:"duration,omitempty"`
+	Bytes     int64    `json:"bytes,omitempty"`
+	Blocks    int64    `json:"blocks,omitempty"`
+	TOS       int      `json:"tos,omitempty"`
+	MSS       int      `json:"mss,omitempty"`
+	Window    int      `json:"window,omitempty"`
+	Bandwidth int64    `json:"bandwidth,omitempty"`
+	// Congestion is the TCP congestion control algorithm the client requested
+	Congestion string   `json:"congestion,omitempty"`
+	Features   []string `json:"features,omitempty"`
+	// MAC and Vendor identify the client's network interface when it is on
+	// a directly attached network and neighbor lookup is enabled
+	MAC    string `json:"mac,omitempty"`
+	Vendor string `json:"vendor,omitempty"`
 }
 
 // BandwidthUpdate represents a real-time bandwidth measurement
 type BandwidthUpdate struct {
+	SessionID     string    `json:"sessionId,omitempty"`
+	ServerPort    int       `json:"serverPort,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	IntervalStart float64   `json:"intervalStart"`
+	IntervalEnd   float64   `json:"intervalEnd"`
+	Bytes         int64     `json:"bytes"`
+	BitsPerSecond float64   `json:"bitsPerSecond"`
+	// Omitted marks an interval in iperf3's omit period (-O), which is not
+	// part of the result. Interval times restart at zero after it.
+	Omitted bool `json:"omitted,omitempty"`
+	// Interval is the server's configured reporting interval in seconds.
+	// The last interval of a test may be shorter.
+	Interval float64 `json:"interval,omitempty"`
+	// SmoothedBitsPerSecond is the exponentially weighted moving average of
+	// the test's bitrate up to this interval, when smoothing is enabled. It
+	// is not set on omitted intervals.
+	SmoothedBitsPerSecond *float64 `json:"smoothedBitsPerSecond,omitempty"`
+}
+
+// IntervalSample is one reporting interval of a stored test result, kept
+// from the test's bandwidth updates
+type IntervalSample struct {
 	Timestamp     time.Time `json:"timestamp"`
 	IntervalStart float64   `json:"intervalStart"`
 	IntervalEnd   float64   `json:"intervalEnd"`
@@ -68,12 +311,379 @@ type BandwidthUpdate struct {
 	BitsPerSecond float64   `json:"bitsPerSecond"`
 }
 
+// BandwidthPercentiles are the 50th, 95th and 99th percentiles of a test's
+// interval bandwidths in bits per second, by nearest rank
+type BandwidthPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// SamplePercentiles returns the percentiles of the samples' bandwidths, or
+// nil if there are none.
+func SamplePercentiles(samples []IntervalSample) *BandwidthPercentiles {
+	if len(samples) == 0 {
+		return nil
+	}
+	values := make([]float64, len(samples))
+	for i, smp := range samples {
+		values[i] = smp.BitsPerSecond
+	}
+	sort.Float64s(values)
+	rank := func(p int) float64 {
+		// The smallest value at least p percent of the values are at or below
+		i := (p*len(values)+99)/100 - 1
+		return values[max(i, 0)]
+	}
+	return &BandwidthPercentiles{P50: rank(50), P95: rank(95), P99: rank(99)}
+}
+
+// TestSummary is the payload of the test_summary message: a test's result
+// and its interval series, sent once the test completes
+type TestSummary struct {
+	Result  *TestResult      `json:"result"`
+	Samples []IntervalSample `json:"samples"`
+}
+
 // ConnectionEvent represents a client connection or disconnection event
 type ConnectionEvent struct {
+	// SessionID identifies the test session; it becomes the result's ID
+	SessionID  string    `json:"sessionId,omitempty"`
+	ServerPort int       `json:"serverPort,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	ClientIP   string    `json:"clientIp"`
+	EventType  string    `json:"eventType"`
+	Details    string    `json:"details,omitempty"`
+	// Geo is where the client is, when GeoIP databases are configured
+	Geo *GeoInfo `json:"geo,omitempty"`
+	// Neighbor is the client's entry in the neighbor table, when neighbor
+	// lookup is enabled and the client is directly attached
+	Neighbor *Neighbor `json:"neighbor,omitempty"`
+}
+
+// Neighbor is an entry in the host's neighbor (ARP) table
+type Neighbor struct {
+	IP     string `json:"ip"`
+	MAC    string `json:"mac"`
+	Device string `json:"device,omitempty"`
+	// Vendor is who the MAC address's OUI is registered to
+	Vendor string `json:"vendor,omitempty"`
+}
+
+// Location is a site's position in WGS 84 degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Valid reports whether the coordinates are within range.
+func (l Location) Valid() bool {
+	return l.Latitude >= -90 && l.Latitude <= 90 && l.Longitude >= -180 && l.Longitude <= 180
+}
+
+// ParseLocation parses "latitude,longitude", e.g. "51.5072,-0.1276".
+func ParseLocation(s string) (Location, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return Location{}, fmt.Errorf("location %q must be latitude,longitude", s)
+	}
+	var l Location
+	var err error
+	if l.Latitude, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil {
+		return Location{}, fmt.Errorf("invalid latitude in %q", s)
+	}
+	if l.Longitude, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil {
+		return Location{}, fmt.Errorf("invalid longitude in %q", s)
+	}
+	if !l.Valid() {
+		return Location{}, fmt.Errorf("location %q is out of range", s)
+	}
+	return l, nil
+}
+
+// GeoInfo is what GeoIP databases know about a client address
+type GeoInfo struct {
+	// Country is the ISO 3166-1 alpha-2 code
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	ISP     string `json:"isp,omitempty"`
+}
+
+// WatchdogWarning is the payload sent when a test session stops producing output
+type WatchdogWarning struct {
+	SessionID   string    `json:"sessionId,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Message     string    `json:"message"`
+	ClientIP    string    `json:"clientIp,omitempty"`
+	StalledFor  float64   `json:"stalledFor"`
+	Diagnostics string    `json:"diagnostics,omitempty"`
+	Restarting  bool      `json:"restarting"`
+}
+
+// SlowConsumerWarning is sent to a WebSocket client whose queue is filling
+// up; it is disconnected if the queue overflows
+type SlowConsumerWarning struct {
 	Timestamp time.Time `json:"timestamp"`
-	ClientIP  string    `json:"clientIp"`
-	EventType string    `json:"eventType"`
-	Details   string    `json:"details,omitempty"`
+	Queued    int       `json:"queued"`
+	Capacity  int       `json:"capacity"`
+}
+
+// AlertRule defines thresholds that raise an alert when a saved result breaches them.
+// Nil thresholds are not checked.
+type AlertRule struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// ClientIP limits the rule to one client; empty applies it to every client
+	ClientIP        string   `json:"clientIp,omitempty"`
+	MinAvgBandwidth *float64 `json:"minAvgBandwidth,omitempty"`
+	MaxPacketLoss   *float64 `json:"maxPacketLoss,omitempty"`
+	MaxJitter       *float64 `json:"maxJitter,omitempty"`
+	MaxRetransmits  *int     `json:"maxRetransmits,omitempty"`
+	// Thresholds on the derived metrics and on utilization, in percent of
+	// the client's declared link capacity; results without the metric, such
+	// as UDP results for retransmits, are not checked
+	MaxRetransmitsPerGB *float64  `json:"maxRetransmitsPerGb,omitempty"`
+	MinGoodputRatio     *float64  `json:"minGoodputRatio,omitempty"`
+	MaxJitterRatio      *float64  `json:"maxJitterRatio,omitempty"`
+	MinUtilization      *float64  `json:"minUtilization,omitempty"`
+	WebhookURL          string    `json:"webhookUrl,omitempty"`
+	Enabled             bool      `json:"enabled"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+// Alert is the payload sent when a result breaches an alert rule
+type Alert struct {
+	Timestamp  time.Time `json:"timestamp"`
+	RuleID     int64     `json:"ruleId"`
+	RuleName   string    `json:"ruleName"`
+	ResultID   string    `json:"resultId"`
+	ClientIP   string    `json:"clientIp"`
+	Violations []string  `json:"violations"`
+}
+
+// JobSource identifies what submitted a queued test run
+type JobSource string
+
+const (
+	JobSourceAdHoc     JobSource = "adhoc"
+	JobSourceCI        JobSource = "ci"
+	JobSourceScheduled JobSource = "scheduled"
+)
+
+// JobState is the lifecycle state of a queued test run
+type JobState string
+
+const (
+	JobStateQueued    JobState = "queued"
+	JobStateRunning   JobState = "running"
+	JobStateCompleted JobState = "completed"
+	JobStateFailed    JobState = "failed"
+	JobStateCancelled JobState = "cancelled"
+	// JobStateDeferred is a queued job held back until DeferredUntil
+	JobStateDeferred JobState = "deferred"
+)
+
+// QueueJob is a test run waiting for, or holding, the iPerf server
+type QueueJob struct {
+	ID     string    `json:"id"`
+	Source JobSource `json:"source"`
+	// CorrelationID is the caller's reference for the job, unique per source
+	CorrelationID string       `json:"correlationId,omitempty"`
+	Priority      int          `json:"priority"`
+	Config        ServerConfig `json:"config"`
+	State         JobState     `json:"state"`
+	// Position is 0 for the running job and 1-based for queued jobs
+	Position    int `json:"position"`
+	Timeout     int `json:"timeout"`
+	Preemptions int `json:"preemptions"`
+	// Deferrals counts how often the job was held back, most recently
+	// because of DeferReason
+	Deferrals     int        `json:"deferrals,omitempty"`
+	DeferReason   string     `json:"deferReason,omitempty"`
+	DeferredUntil *time.Time `json:"deferredUntil,omitempty"`
+	ResultID      string     `json:"resultId,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	EnqueuedAt    time.Time  `json:"enqueuedAt"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+}
+
+// QueuePosition is the payload sent when a job's place in the queue changes
+type QueuePosition struct {
+	JobID    string   `json:"jobId"`
+	State    JobState `json:"state"`
+	Position int      `json:"position"`
+}
+
+// LinkUtilization is a measurement of how busy the uplink is. Percent is
+// the busier direction's share of CapacityBps.
+type LinkUtilization struct {
+	Source          string    `json:"source"`
+	MeasuredAt      time.Time `json:"measuredAt"`
+	RxBitsPerSecond float64   `json:"rxBitsPerSecond"`
+	TxBitsPerSecond float64   `json:"txBitsPerSecond"`
+	CapacityBps     float64   `json:"capacityBps"`
+	Percent         float64   `json:"percent"`
+	// Threshold is the percentage above which the link is Busy, 0 if none
+	Threshold float64 `json:"threshold"`
+	Busy      bool    `json:"busy"`
+}
+
+// ConfigVersion is an immutable record of a server configuration when it was
+// applied. A new version is only recorded when a field that affects results
+// changes.
+type ConfigVersion struct {
+	ID        int64        `json:"id"`
+	AppliedAt time.Time    `json:"appliedAt"`
+	Config    ServerConfig `json:"config"`
+}
+
+// ConfigChange is one field that differs between consecutive config versions
+type ConfigChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// AnnotationKind identifies what an annotation records
+type AnnotationKind string
+
+const (
+	AnnotationKindConfigChange AnnotationKind = "config_change"
+)
+
+// Annotation marks a point in time that may explain a shift in results
+type Annotation struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Kind      AnnotationKind `json:"kind"`
+	Version   int64          `json:"version"`
+	Changes   []ConfigChange `json:"changes"`
+}
+
+// DesiredState declares how the server should be running. Each declaration
+// is an immutable version; the latest one is reconciled against the server.
+type DesiredState struct {
+	Version    int64        `json:"version"`
+	DeclaredAt time.Time    `json:"declaredAt"`
+	Running    bool         `json:"running"`
+	Config     ServerConfig `json:"config"`
+	// AutoCorrect lets the reconciler restore the desired state on drift
+	AutoCorrect bool `json:"autoCorrect"`
+}
+
+// Profile is a named server configuration operators can start the server
+// with instead of sending the configuration each time
+type Profile struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Config      ServerConfig `json:"config"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+}
+
+// Peer is a remote iperf-api deployment this instance federates with
+type Peer struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	APIKey string `json:"apiKey,omitempty"`
+	// Location places the site on the results map
+	Location *Location `json:"location,omitempty"`
+}
+
+// ConfigBundleVersion is the format version of exported configuration bundles
+const ConfigBundleVersion = 1
+
+// ConfigBundle is an instance's configuration without its results, for
+// setting up a replacement. Alert rules carry their webhooks and the desired
+// state's config carries the client allowlist.
+type ConfigBundle struct {
+	Version               int                    `json:"version"`
+	ExportedAt            time.Time              `json:"exportedAt"`
+	AlertRules            []AlertRule            `json:"alertRules"`
+	CostCenterAssignments []CostCenterAssignment `json:"costCenterAssignments"`
+	DesiredState          *DesiredState          `json:"desiredState,omitempty"`
+	// Profiles is absent from bundles exported before profiles existed;
+	// importing those leaves the profiles alone
+	Profiles []Profile `json:"profiles"`
+	// LinkCapacities likewise is absent from older bundles
+	LinkCapacities []LinkCapacity `json:"linkCapacities"`
+}
+
+// DriftField is one way the actual server state differs from the desired one
+type DriftField struct {
+	Field   string `json:"field"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+}
+
+// DriftAction is what the reconciler did to correct drift
+type DriftAction string
+
+const (
+	DriftActionStart   DriftAction = "start"
+	DriftActionStop    DriftAction = "stop"
+	DriftActionRestart DriftAction = "restart"
+)
+
+// DriftReport is the outcome of comparing the server with its desired state
+type DriftReport struct {
+	CheckedAt      time.Time    `json:"checkedAt"`
+	DesiredVersion int64        `json:"desiredVersion"`
+	InSync         bool         `json:"inSync"`
+	Drift          []DriftField `json:"drift,omitempty"`
+	Action         DriftAction  `json:"action,omitempty"`
+	// Deferred is set when correction waits for a test or queued job to finish
+	Deferred bool   `json:"deferred,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AuditAction names a control-plane action recorded in the audit log
+type AuditAction string
+
+const (
+	AuditActionServerStart       AuditAction = "server.start"
+	AuditActionServerStop        AuditAction = "server.stop"
+	AuditActionConfigChange      AuditAction = "server.config_change"
+	AuditActionQueueEnqueue      AuditAction = "queue.enqueue"
+	AuditActionQueueCancel       AuditAction = "queue.cancel"
+	AuditActionQueueDefer        AuditAction = "queue.defer"
+	AuditActionAlertRuleCreate   AuditAction = "alert_rule.create"
+	AuditActionAlertRuleUpdate   AuditAction = "alert_rule.update"
+	AuditActionAlertRuleDelete   AuditAction = "alert_rule.delete"
+	AuditActionCostCenterSave    AuditAction = "cost_center.save"
+	AuditActionCostCenterDelete  AuditAction = "cost_center.delete"
+	AuditActionEmailConfigUpdate AuditAction = "email_config.update"
+	AuditActionDesiredState      AuditAction = "desired_state.declare"
+	AuditActionDriftCorrect      AuditAction = "drift.correct"
+	AuditActionConfigImport      AuditAction = "config.import"
+	AuditActionStatsRebuild      AuditAction = "stats.rebuild"
+	AuditActionBackupCreate      AuditAction = "backup.create"
+	AuditActionResultAnnotate    AuditAction = "result.annotate"
+	AuditActionHistoryImport     AuditAction = "history.import"
+	AuditActionProfileSave       AuditAction = "profile.save"
+	AuditActionProfileDelete     AuditAction = "profile.delete"
+	AuditActionPeerSave          AuditAction = "peer.save"
+	AuditActionPeerDelete        AuditAction = "peer.delete"
+	AuditActionResultsReparse    AuditAction = "results.reparse"
+	AuditActionLatencyStart      AuditAction = "latency.start"
+	AuditActionLatencyStop       AuditAction = "latency.stop"
+	AuditActionMTUDiscover       AuditAction = "mtu.discover"
+	AuditActionTracerouteStart   AuditAction = "traceroute.start"
+	AuditActionReportSend        AuditAction = "report.send"
+	AuditActionCapacitySave      AuditAction = "capacity.save"
+	AuditActionCapacityDelete    AuditAction = "capacity.delete"
+)
+
+// AuditEntry records who performed a control-plane action and with what
+// parameters
+type AuditEntry struct {
+	ID        int64       `json:"id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Action    AuditAction `json:"action"`
+	// APIKey identifies the API key presented, as a hash prefix; never the key itself
+	APIKey     string                 `json:"apiKey,omitempty"`
+	RemoteIP   string                 `json:"remoteIp"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
 }
 
 // WSMessageType represents the type of WebSocket message
@@ -85,12 +695,60 @@ const (
 	WSMessageTypeBandwidthUpdate WSMessageType = "bandwidth_update"
 	WSMessageTypeTestComplete    WSMessageType = "test_complete"
 	WSMessageTypeError           WSMessageType = "error"
+	WSMessageTypeWarning         WSMessageType = "warning"
+	WSMessageTypeAlert           WSMessageType = "alert"
+	WSMessageTypeQueuePosition   WSMessageType = "queue_position"
+	WSMessageTypeDrift           WSMessageType = "drift"
+	WSMessageTypeRestart         WSMessageType = "restart"
+	WSMessageTypeCollision       WSMessageType = "collision"
+	WSMessageTypeTestSlotReady   WSMessageType = "test_slot_ready"
+	WSMessageTypeSlowConsumer    WSMessageType = "slow_consumer"
+	WSMessageTypeLatencyPing     WSMessageType = "latency_ping"
+	WSMessageTypeLatencyResult   WSMessageType = "latency_result"
+	WSMessageTypeTracerouteHop   WSMessageType = "traceroute_hop"
+	WSMessageTypeTracerouteDone  WSMessageType = "traceroute_complete"
+	WSMessageTypeHello           WSMessageType = "hello"
+	WSMessageTypeSnapshot        WSMessageType = "snapshot"
+	WSMessageTypeTestSummary     WSMessageType = "test_summary"
 )
 
 // WSMessage is the wrapper for all WebSocket messages
 type WSMessage struct {
 	Type    WSMessageType `json:"type"`
 	Payload interface{}   `json:"payload"`
+	// Node is the name of the deployment that sent the message
+	Node string `json:"node,omitempty"`
+	// Version is the message schema version the client negotiated; version
+	// 1 messages leave it out
+	Version int `json:"version,omitempty"`
+}
+
+// WebSocket message schema versions. Version 1 is the format before
+// versioning; version 2 adds the version field and the hello exchange.
+const (
+	WSVersion1 = 1
+	WSVersion2 = 2
+	// WSVersionCurrent is what clients get unless they ask for another
+	WSVersionCurrent = WSVersion2
+)
+
+// WSHello is the payload of the hello message, sent when a client connects
+// and in answer to a client's hello, with the version its messages use from
+// then on and the versions the server speaks.
+type WSHello struct {
+	Version  int   `json:"version"`
+	Versions []int `json:"versions"`
+}
+
+// WSSnapshot is the payload of the snapshot message, sent to a client that
+// resumes its paused stream in place of the messages it missed
+type WSSnapshot struct {
+	Status ServerStatusPayload `json:"status"`
+	Live   LiveThroughput      `json:"live"`
+	// Sessions are the IDs of the test sessions in progress
+	Sessions []string `json:"sessions"`
+	// Missed is how many messages were not sent while paused
+	Missed int `json:"missed"`
 }
 
 // ServerStatusPayload is the payload for server status WebSocket messages
@@ -99,4 +757,492 @@ type ServerStatusPayload struct {
 	Config     *ServerConfig `json:"config,omitempty"`
 	ListenAddr string        `json:"listenAddr,omitempty"`
 	ErrorMsg   string        `json:"errorMsg,omitempty"`
+	// Supervisor is the automatic restart state, when a restart policy is set
+	Supervisor *SupervisorStatus `json:"supervisor,omitempty"`
+	// Process describes the iperf processes behind the server
+	Process *ProcessDiagnostics `json:"process,omitempty"`
+	// Tunnel is the management tunnel to the relay, when one is configured
+	Tunnel *TunnelStatus `json:"tunnel,omitempty"`
+}
+
+// TunnelState is the state of the outbound management tunnel
+type TunnelState string
+
+const (
+	// TunnelStateConnecting means the relay is being dialled
+	TunnelStateConnecting TunnelState = "connecting"
+	// TunnelStateConnected means the relay can reach the API
+	TunnelStateConnected TunnelState = "connected"
+	// TunnelStateDisconnected means the connection dropped; it is retried
+	// with backoff
+	TunnelStateDisconnected TunnelState = "disconnected"
+)
+
+// TunnelStatus describes the outbound management tunnel to a relay
+type TunnelStatus struct {
+	// Relay is the relay URL without credentials
+	Relay          string      `json:"relay"`
+	ProbeID        string      `json:"probeId"`
+	State          TunnelState `json:"state"`
+	ConnectedSince *time.Time  `json:"connectedSince,omitempty"`
+	LastError      string      `json:"lastError,omitempty"`
+	// Reconnects counts connection attempts after the first
+	Reconnects int `json:"reconnects"`
+}
+
+// DryRunCommand is the exact command line a start would run for one port,
+// with the binary resolved on PATH
+type DryRunCommand struct {
+	Port int      `json:"port"`
+	Argv []string `json:"argv"`
+}
+
+// DryRunCheck is the outcome of one check made by a dry run or validation:
+// "config", "state", "binary", "port" or "allowlist"
+type DryRunCheck struct {
+	Name string `json:"name"`
+	Port int    `json:"port,omitempty"`
+	OK   bool   `json:"ok"`
+	// Field is the config field a config or allowlist check is about
+	Field string `json:"field,omitempty"`
+	// Detail is the resolved binary path, the address probed or the
+	// allowlist entry checked
+	Detail string `json:"detail,omitempty"`
+	// Skipped marks a port held by the running server, which a queued job
+	// gets once the server stops
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Warning is a likely mistake that does not fail the check
+	Warning string `json:"warning,omitempty"`
+}
+
+// DryRunReport is what a start would do, without launching anything
+type DryRunReport struct {
+	OK       bool            `json:"ok"`
+	Checks   []DryRunCheck   `json:"checks"`
+	Commands []DryRunCommand `json:"commands"`
+}
+
+// BuildInfo identifies the build of the running server
+type BuildInfo struct {
+	// Version is the release, or "dev" for a build without one
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	// Modified is set for a build from a tree with uncommitted changes
+	Modified  bool   `json:"modified,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// IperfParser names the parser reading iperf's output
+type IperfParser string
+
+const (
+	IperfParserText       IperfParser = "text"
+	IperfParserJSON       IperfParser = "json"
+	IperfParserJSONStream IperfParser = "json-stream"
+	IperfParserIperf2     IperfParser = "iperf2"
+)
+
+// IperfBinaryInfo describes the binary the current server config runs
+type IperfBinaryInfo struct {
+	Path string `json:"path"`
+	// Version is what the binary reports to -v; empty when it could not be
+	// asked
+	Version   string `json:"version,omitempty"`
+	Simulated bool   `json:"simulated,omitempty"`
+	// OutputMode is the mode configured; Parser is what it resolves to for
+	// this binary
+	OutputMode OutputMode  `json:"outputMode"`
+	Parser     IperfParser `json:"parser"`
+}
+
+// VersionInfo is the response of GET /api/version
+type VersionInfo struct {
+	BuildInfo
+	Iperf IperfBinaryInfo `json:"iperf"`
+}
+
+// ProcessDiagnostics is the state of the iperf processes behind the server,
+// for debugging a server that reports running but does not answer
+type ProcessDiagnostics struct {
+	// Processes lists the process serving each port while the server runs
+	Processes []ProcessInfo `json:"processes"`
+	// Restarts counts automatic restarts since the API started
+	Restarts int          `json:"restarts"`
+	LastExit *ProcessExit `json:"lastExit,omitempty"`
+}
+
+// ProcessInfo describes the iperf process serving one port. Uptime is in
+// seconds; Argv is the exact command line, starting with the binary name.
+type ProcessInfo struct {
+	Port      int       `json:"port"`
+	PID       int       `json:"pid"`
+	Path      string    `json:"path"`
+	Argv      []string  `json:"argv"`
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    float64   `json:"uptime"`
+}
+
+// ProcessExit records how the last iperf process ended. ExitCode is -1 when
+// it was killed by a signal; State reads e.g. "exit status 1" or
+// "signal: killed".
+type ProcessExit struct {
+	Port     int       `json:"port"`
+	PID      int       `json:"pid"`
+	ExitCode int       `json:"exitCode"`
+	State    string    `json:"state"`
+	At       time.Time `json:"at"`
+}
+
+// CircuitState is the state of the automatic restart circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed means the server has not crashed since it was started
+	// or has run long enough since its last restart
+	CircuitClosed CircuitState = "closed"
+	// CircuitHalfOpen means a restart is pending or the restarted server is
+	// on trial
+	CircuitHalfOpen CircuitState = "half_open"
+	// CircuitOpen means retries are exhausted; the server stays down until
+	// it is started again
+	CircuitOpen CircuitState = "open"
+)
+
+// SupervisorStatus reports automatic restarts of a crashed server
+type SupervisorStatus struct {
+	Circuit CircuitState `json:"circuit"`
+	// Restarts counts consecutive automatic restarts
+	Restarts      int        `json:"restarts"`
+	MaxRetries    int        `json:"maxRetries"`
+	NextRestartAt *time.Time `json:"nextRestartAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// RestartEvent is the payload sent when a crashed server is scheduled for an
+// automatic restart, or when retries run out
+type RestartEvent struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Attempt    int       `json:"attempt"`
+	MaxRetries int       `json:"maxRetries"`
+	// Delay is the backoff in seconds before the attempt
+	Delay  float64 `json:"delay"`
+	Reason string  `json:"reason,omitempty"`
+	// CircuitOpen is set when no restart follows
+	CircuitOpen bool `json:"circuitOpen,omitempty"`
+}
+
+// TestSlotReady is the payload sent when an auto-rearming one-off server is
+// listening for its next test. Slot counts the tests it has been armed for
+// since it was started, from 1.
+type TestSlotReady struct {
+	Timestamp  time.Time `json:"timestamp"`
+	ServerPort int       `json:"serverPort"`
+	Slot       int       `json:"slot"`
+	PID        int       `json:"pid"`
+}
+
+// PortState reports whether a listener is free for a new test
+type PortState string
+
+const (
+	PortStateFree PortState = "free"
+	PortStateBusy PortState = "busy"
+)
+
+// PortStatus describes one listener of a running server
+type PortStatus struct {
+	Port      int       `json:"port"`
+	State     PortState `json:"state"`
+	SessionID string    `json:"sessionId,omitempty"`
+	ClientIP  string    `json:"clientIp,omitempty"`
+}
+
+// Collision records a connection turned away because a test was already
+// running on the port. iperf3 does not report the rejected client's address,
+// so the collision is identified by the session that was in progress.
+type Collision struct {
+	ID            int64     `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	ServerPort    int       `json:"serverPort,omitempty"`
+	BusySessionID string    `json:"busySessionId,omitempty"`
+	BusyClientIP  string    `json:"busyClientIp,omitempty"`
+}
+
+// CostCenterAssignment attributes clients matching an IP or CIDR to a cost center
+type CostCenterAssignment struct {
+	ID         int64     `json:"id"`
+	Match      string    `json:"match"`
+	CostCenter string    `json:"costCenter"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// LinkCapacity declares the link speed of clients matching an IP or CIDR,
+// which their results' utilization is measured against
+type LinkCapacity struct {
+	ID    int64  `json:"id"`
+	Match string `json:"match"`
+	// Capacity is in bits per second
+	Capacity  float64   `json:"capacity"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AccountingEntry aggregates test usage attributed to one cost center
+type AccountingEntry struct {
+	CostCenter       string `json:"costCenter"`
+	Tests            int    `json:"tests"`
+	BytesTransferred int64  `json:"bytesTransferred"`
+	Clients          int    `json:"clients"`
+}
+
+// ResultStat is the hourly rollup of test results from one client on one
+// listener port, precomputed for the statistics endpoints
+type ResultStat struct {
+	// Hour is the start of the hour, in UTC
+	Hour             time.Time `json:"hour"`
+	ClientIP         string    `json:"clientIp"`
+	ServerPort       int       `json:"serverPort"`
+	Tests            int       `json:"tests"`
+	BytesTransferred int64     `json:"bytesTransferred"`
+}
+
+// StatsRollup accumulates results into hourly ResultStats. The zero value is
+// ready to use.
+type StatsRollup struct {
+	index map[statsKey]int
+	stats []ResultStat
+}
+
+type statsKey struct {
+	hour time.Time
+	ip   string
+	port int
+}
+
+// Add counts one result.
+func (r *StatsRollup) Add(result TestResult) {
+	k := statsKey{result.Timestamp.UTC().Truncate(time.Hour), result.ClientIP, result.ServerPort}
+	if r.index == nil {
+		r.index = make(map[statsKey]int)
+	}
+	i, ok := r.index[k]
+	if !ok {
+		i = len(r.stats)
+		r.index[k] = i
+		r.stats = append(r.stats, ResultStat{Hour: k.hour, ClientIP: k.ip, ServerPort: k.port})
+	}
+	r.stats[i].Tests++
+	r.stats[i].BytesTransferred += result.BytesTransferred
+}
+
+// Stats returns the rollup ordered by hour, client and port.
+func (r *StatsRollup) Stats() []ResultStat {
+	stats := append([]ResultStat(nil), r.stats...)
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		if a.ClientIP != b.ClientIP {
+			return a.ClientIP < b.ClientIP
+		}
+		return a.ServerPort < b.ServerPort
+	})
+	return stats
+}
+
+// HourlyStats rolls results up by hour, client and port.
+func HourlyStats(results []TestResult) []ResultStat {
+	var rollup StatsRollup
+	for _, r := range results {
+		rollup.Add(r)
+	}
+	return rollup.Stats()
+}
+
+// StorageState is whether the database can be used
+type StorageState string
+
+const (
+	StorageStateOK          StorageState = "ok"
+	StorageStateReadOnly    StorageState = "read-only"
+	StorageStateUnavailable StorageState = "unavailable"
+)
+
+// StorageStatus reports whether the database is open. Storage that failed
+// to open is retried in the background while the API runs without it.
+type StorageStatus struct {
+	State StorageState `json:"state"`
+	// Error is why the last attempt to open storage failed
+	Error string `json:"error,omitempty"`
+	// Attempts counts the failed attempts since startup
+	Attempts  int        `json:"attempts,omitempty"`
+	NextRetry *time.Time `json:"nextRetry,omitempty"`
+}
+
+// StatsCacheStatus reports the state of the precomputed statistics
+type StatsCacheStatus struct {
+	// Ready is set once the rollup matches the stored results; until then
+	// statistics are computed from the results directly
+	Ready      bool `json:"ready"`
+	Rebuilding bool `json:"rebuilding"`
+	// Rows is the size of the rollup after the last check or rebuild
+	Rows        int        `json:"rows"`
+	LastRebuild *time.Time `json:"lastRebuild,omitempty"`
+	// LastDurationMs is how long the last rebuild took
+	LastDurationMs int64  `json:"lastDurationMs"`
+	LastError      string `json:"lastError,omitempty"`
+}
+
+// LiveUpdate is the most recent bandwidth update of any test, with the
+// address of the client that sent it
+type LiveUpdate struct {
+	BandwidthUpdate
+	ClientIP string `json:"clientIp,omitempty"`
+}
+
+// LiveThroughput is the latest bandwidth update and the throughput over the
+// last WindowSeconds seconds, for widgets that poll rather than subscribe
+type LiveThroughput struct {
+	// Latest is nil until a test has reported an interval
+	Latest *LiveUpdate `json:"latest"`
+	// AverageBitsPerSecond is the mean rate of the intervals reported within
+	// the window, weighted by interval length; 0 when there were none
+	AverageBitsPerSecond float64 `json:"averageBitsPerSecond"`
+	WindowSeconds        float64 `json:"windowSeconds"`
+}
+
+// ProbeMethod is how a latency probe measures round-trip time
+type ProbeMethod string
+
+const (
+	// ProbeMethodICMP sends ICMP echo requests
+	ProbeMethodICMP ProbeMethod = "icmp"
+	// ProbeMethodTCP times TCP connection setup
+	ProbeMethodTCP ProbeMethod = "tcp"
+	// ProbeMethodUDP sends UDP datagrams to a closed port, which the target
+	// answers with ICMP port unreachable; used for path MTU discovery
+	ProbeMethodUDP ProbeMethod = "udp"
+)
+
+// ProbeSpec is what a latency probe measures and how often. Interval and
+// Timeout are in seconds.
+type ProbeSpec struct {
+	// Target is a host name or address; for TCP it may include a port
+	Target   string      `json:"target"`
+	Method   ProbeMethod `json:"method"`
+	Count    int         `json:"count"`
+	Interval float64     `json:"interval"`
+	Timeout  float64     `json:"timeout"`
+	// Continuous repeats rounds of Count pings until the probe is stopped
+	Continuous bool `json:"continuous,omitempty"`
+}
+
+// LatencyProbe is a running latency probe
+type LatencyProbe struct {
+	ID string `json:"id"`
+	ProbeSpec
+	StartedAt time.Time `json:"startedAt"`
+	// Rounds counts the rounds completed so far
+	Rounds int `json:"rounds"`
+}
+
+// LatencyPing is one ping of a latency probe, streamed as it completes.
+// RTT is in milliseconds and is nil when the ping got no answer.
+type LatencyPing struct {
+	ProbeID   string    `json:"probeId"`
+	Target    string    `json:"target"`
+	Seq       int       `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	RTT       *float64  `json:"rtt,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// LatencyResult summarises one round of a latency probe. Round-trip times
+// are in milliseconds and are nil when no ping was answered; PacketLoss is
+// a percentage.
+type LatencyResult struct {
+	ID         int64       `json:"id"`
+	ProbeID    string      `json:"probeId"`
+	Target     string      `json:"target"`
+	Method     ProbeMethod `json:"method"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Sent       int         `json:"sent"`
+	Received   int         `json:"received"`
+	PacketLoss float64     `json:"packetLoss"`
+	RTTMin     *float64    `json:"rttMin,omitempty"`
+	RTTAvg     *float64    `json:"rttAvg,omitempty"`
+	RTTMax     *float64    `json:"rttMax,omitempty"`
+	RTTStdDev  *float64    `json:"rttStdDev,omitempty"`
+	// Error is the last ping's error when none was answered
+	Error string `json:"error,omitempty"`
+}
+
+// MTURequest asks for the path MTU to a target. Min and Max bound the
+// search, in bytes of whole IP packets; Timeout is seconds per probe.
+type MTURequest struct {
+	Target  string      `json:"target"`
+	Method  ProbeMethod `json:"method"`
+	Min     int         `json:"min"`
+	Max     int         `json:"max"`
+	Timeout float64     `json:"timeout"`
+}
+
+// MTUResult is the outcome of a path MTU discovery. PathMTU is the largest
+// IP packet, headers included, that reached the target without
+// fragmentation, or 0 when none did.
+type MTUResult struct {
+	ID     int64  `json:"id"`
+	Target string `json:"target"`
+	// Address is the address Target resolved to
+	Address   string      `json:"address"`
+	Method    ProbeMethod `json:"method"`
+	Timestamp time.Time   `json:"timestamp"`
+	PathMTU   int         `json:"pathMtu"`
+	Min       int         `json:"min"`
+	Max       int         `json:"max"`
+	// Probes counts the packet sizes tried
+	Probes int    `json:"probes"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TracerouteRequest asks for the path to a target. Timeout is seconds per
+// probe.
+type TracerouteRequest struct {
+	// Target is a host name or address; it defaults to the client of the
+	// result named by ResultID
+	Target string `json:"target"`
+	// ResultID ties the trace to a test result, to keep the path a test took
+	ResultID string  `json:"resultId,omitempty"`
+	MaxHops  int     `json:"maxHops"`
+	Queries  int     `json:"queries"`
+	Timeout  float64 `json:"timeout"`
+}
+
+// Traceroute is the path to a target, one hop per TTL
+type Traceroute struct {
+	ID string `json:"id"`
+	TracerouteRequest
+	// Address is the address Target resolved to
+	Address   string          `json:"address,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Hops      []TracerouteHop `json:"hops"`
+	// Reached is set when the target itself answered
+	Reached bool `json:"reached"`
+	// Done is set once the trace has finished, successfully or not
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// TracerouteHop is the router or host that answered the probes sent with
+// one TTL. RTTs holds each probe's round-trip time in milliseconds, nil for
+// probes that got no answer; Address is empty when none did.
+type TracerouteHop struct {
+	TraceID string     `json:"traceId"`
+	TTL     int        `json:"ttl"`
+	Address string     `json:"address,omitempty"`
+	RTTs    []*float64 `json:"rtts"`
+	// Error names an unreachable error the hop reported, which ends the trace
+	Error string `json:"error,omitempty"`
 }
@@ -0,0 +1,105 @@
+// Package neighbor reads the host's IPv4 neighbor (ARP) table so clients on
+// directly attached networks can be identified by MAC address and vendor.
+package neighbor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/oui"
+)
+
+// DefaultPath is the Linux kernel's ARP table.
+const DefaultPath = "/proc/net/arp"
+
+// Table resolves addresses through the neighbor table, naming vendors from
+// an OUI database.
+type Table struct {
+	path    string
+	vendors *oui.Database
+}
+
+// NewTable creates a Table reading the ARP table at path, in the
+// /proc/net/arp format.
+func NewTable(path string, vendors *oui.Database) *Table {
+	return &Table{path: path, vendors: vendors}
+}
+
+// List returns the complete entries in the table.
+func (t *Table) List() ([]models.Neighbor, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	neighbors, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	for i := range neighbors {
+		neighbors[i].Vendor = t.vendors.Lookup(neighbors[i].MAC)
+	}
+	return neighbors, nil
+}
+
+// Lookup returns the entry for ip, or nil if there is none, as for clients
+// beyond a router, IPv6 clients or when the table cannot be read.
+func (t *Table) Lookup(ip string) *models.Neighbor {
+	if t == nil {
+		return nil
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return nil
+	}
+	neighbors, err := t.List()
+	if err != nil {
+		return nil
+	}
+	for _, n := range neighbors {
+		if net.ParseIP(n.IP).Equal(addr) {
+			return &n
+		}
+	}
+	return nil
+}
+
+// atfCom is the flag of a resolved entry; incomplete entries lack it.
+const atfCom = 0x2
+
+// parse reads /proc/net/arp:
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	192.168.1.1      0x1         0x2         52:54:00:12:34:56     *        eth0
+func parse(r io.Reader) ([]models.Neighbor, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	var neighbors []models.Neighbor
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		var flags int
+		if _, err := fmt.Sscanf(fields[2], "0x%x", &flags); err != nil || flags&atfCom == 0 {
+			continue
+		}
+		hw, err := net.ParseMAC(fields[3])
+		if err != nil {
+			continue
+		}
+		neighbors = append(neighbors, models.Neighbor{
+			IP:     fields[0],
+			MAC:    hw.String(),
+			Device: fields[5],
+		})
+	}
+	return neighbors, scanner.Err()
+}
@@ -0,0 +1,62 @@
+package neighbor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/oui"
+)
+
+const arpTable = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         00:50:56:AB:CD:EF     *        eth0
+192.168.1.20     0x1         0x2         b8:27:eb:00:11:22     *        eth0
+192.168.1.30     0x1         0x0         00:00:00:00:00:00     *        eth0
+10.8.0.5         0x1         0x6         06:11:22:33:44:55     *        wg0
+`
+
+func writeTable(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "arp")
+	if err := os.WriteFile(path, []byte(arpTable), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestList(t *testing.T) {
+	table := NewTable(writeTable(t), oui.Builtin())
+	neighbors, err := table.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(neighbors) != 3 {
+		t.Fatalf("got %d neighbors, want 3 without the incomplete entry: %+v", len(neighbors), neighbors)
+	}
+	if n := neighbors[0]; n.IP != "192.168.1.1" || n.MAC != "00:50:56:ab:cd:ef" || n.Device != "eth0" || n.Vendor != "VMware, Inc." {
+		t.Errorf("neighbors[0] = %+v", n)
+	}
+	if n := neighbors[2]; n.Vendor != "" {
+		t.Errorf("locally administered address has vendor %q", n.Vendor)
+	}
+
+	if _, err := NewTable(filepath.Join(t.TempDir(), "missing"), nil).List(); err == nil {
+		t.Error("List succeeded on a missing table")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := NewTable(writeTable(t), oui.Builtin())
+	if n := table.Lookup("::ffff:192.168.1.20"); n == nil || n.Vendor != "Raspberry Pi Foundation" {
+		t.Errorf("Lookup = %+v, want the Raspberry Pi", n)
+	}
+	for _, ip := range []string{"192.168.1.30", "203.0.113.9", "2001:db8::1", "bogus"} {
+		if n := table.Lookup(ip); n != nil {
+			t.Errorf("Lookup(%q) = %+v, want nil", ip, n)
+		}
+	}
+	var unset *Table
+	if n := unset.Lookup("192.168.1.1"); n != nil {
+		t.Errorf("nil table Lookup = %+v", n)
+	}
+}
@@ -0,0 +1,163 @@
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Limits and defaults of path MTU discovery. Sizes are whole IP packets in
+// bytes; the timeout is in seconds.
+const (
+	// MinMTUv4 and MinMTUv6 are the smallest MTUs each IP version allows,
+	// and the default lower bounds of a search
+	MinMTUv4 = 68
+	MinMTUv6 = 1280
+	// DefaultMaxMTU is Ethernet's MTU
+	DefaultMaxMTU = 1500
+	MaxMTU        = 65535
+	// DefaultMTUTimeout is how long to wait for each probe's answer
+	DefaultMTUTimeout = 1.0
+	// MTUUDPPort is where UDP probes are sent: the first traceroute port,
+	// which is rarely open, so the target answers with port unreachable
+	MTUUDPPort = 33434
+)
+
+// errMTUUnsupported is returned on platforms that cannot set the
+// don't-fragment flag.
+var errMTUUnsupported = errors.New("path MTU discovery is only supported on Linux")
+
+// pathProbe sends packets of a given size with fragmentation disabled.
+type pathProbe interface {
+	// fits reports whether a packet of size bytes reached the target
+	fits(ctx context.Context, size int) (bool, error)
+	Close() error
+}
+
+// ValidateMTU checks a path MTU request and fills in defaults for the
+// method, upper bound and timeout. The lower bound defaults once the target
+// is resolved, as it depends on the IP version.
+func ValidateMTU(req *models.MTURequest) error {
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Target == "" || strings.ContainsAny(req.Target, " \t/") {
+		return i18n.NewError("latency.invalid_target", i18n.Params{"target": req.Target})
+	}
+	if req.Method == "" {
+		req.Method = models.ProbeMethodICMP
+	}
+	if req.Method != models.ProbeMethodICMP && req.Method != models.ProbeMethodUDP {
+		return i18n.NewError("mtu.invalid_method", i18n.Params{"method": req.Method})
+	}
+	if req.Max == 0 {
+		req.Max = DefaultMaxMTU
+	}
+	if req.Max > MaxMTU || (req.Min != 0 && (req.Min < MinMTUv4 || req.Min >= req.Max)) || req.Max <= MinMTUv4 {
+		return i18n.NewError("mtu.invalid_range", i18n.Params{"floor": MinMTUv4, "ceiling": MaxMTU})
+	}
+	if req.Timeout == 0 {
+		req.Timeout = DefaultMTUTimeout
+	}
+	if req.Timeout < 0 || req.Timeout > MaxTimeout {
+		return i18n.NewError("latency.invalid_timeout", i18n.Params{"max": MaxTimeout})
+	}
+	return nil
+}
+
+// DiscoverMTU finds the largest packet that reaches req.Target with the
+// don't-fragment flag set, by binary search between req.Min and req.Max.
+// A probe that gets no answer, even after a retry, counts as too big, since
+// paths that drop oversized packets silently are what this is for. A target
+// that answers no probe at all gives a result with an error rather than a
+// path MTU. Failing to resolve the target or open a socket is an error.
+func DiscoverMTU(ctx context.Context, req models.MTURequest) (*models.MTUResult, error) {
+	if err := ValidateMTU(&req); err != nil {
+		return nil, err
+	}
+	ip, err := resolve(ctx, strings.Trim(req.Target, "[]"))
+	if err != nil {
+		return nil, err
+	}
+	floor := MinMTUv4
+	if ip.To4() == nil {
+		floor = MinMTUv6
+	}
+	if req.Min == 0 {
+		req.Min = floor
+	}
+	if req.Min < floor || req.Min >= req.Max {
+		return nil, i18n.NewError("mtu.invalid_range", i18n.Params{"floor": floor, "ceiling": MaxMTU})
+	}
+
+	probe, err := openPathProbe(req.Method, ip, seconds(req.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer probe.Close()
+
+	result := &models.MTUResult{
+		Target:    req.Target,
+		Address:   ip.String(),
+		Method:    req.Method,
+		Timestamp: time.Now().UTC(),
+		Min:       req.Min,
+		Max:       req.Max,
+	}
+	result.PathMTU, result.Probes, err = searchMTU(ctx, req.Min, req.Max, probe.fits)
+	if errors.Is(err, errNoAnswer) {
+		result.Error = fmt.Sprintf("no answer to %d-byte probes", req.Min)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// errNoAnswer reports a target that answered not even the smallest probe.
+var errNoAnswer = errors.New("no answer")
+
+// searchMTU returns the largest size in [lo, hi] that fits, trying hi first
+// since most paths carry the full size, and how many sizes it tried.
+func searchMTU(ctx context.Context, lo, hi int, fits func(ctx context.Context, size int) (bool, error)) (int, int, error) {
+	probes := 1
+	ok, err := fits(ctx, hi)
+	if err != nil || ok {
+		return hi, probes, err
+	}
+	probes++
+	if ok, err := fits(ctx, lo); err != nil || !ok {
+		if err == nil {
+			err = errNoAnswer
+		}
+		return 0, probes, err
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		probes++
+		ok, err := fits(ctx, mid)
+		if err != nil {
+			return 0, probes, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, probes, nil
+}
+
+// headerSize is the size of the IP and ICMP or UDP headers, which both
+// take 8 bytes, in front of a probe's payload.
+func headerSize(ip net.IP) int {
+	if ip.To4() == nil {
+		return 40 + 8
+	}
+	return 20 + 8
+}
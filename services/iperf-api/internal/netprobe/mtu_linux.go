@@ -0,0 +1,166 @@
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// dfProbe is a datagram socket connected to the target with the
+// don't-fragment flag set, so the kernel refuses packets larger than the
+// path MTU it knows of instead of fragmenting them.
+type dfProbe struct {
+	conn    net.Conn
+	method  models.ProbeMethod
+	ip      net.IP
+	timeout time.Duration
+	seq     int
+}
+
+// openPathProbe opens a UDP socket, or for ICMP an unprivileged ping
+// socket, to ip with path MTU discovery forced on.
+func openPathProbe(method models.ProbeMethod, ip net.IP, timeout time.Duration) (pathProbe, error) {
+	family, level, option, value := syscall.AF_INET, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO
+	protocol := syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr
+	if ip4 := ip.To4(); ip4 != nil {
+		addr := &syscall.SockaddrInet4{}
+		copy(addr.Addr[:], ip4)
+		sa = addr
+	} else {
+		family, level, option, value = syscall.AF_INET6, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO
+		protocol = syscall.IPPROTO_ICMPV6
+		addr := &syscall.SockaddrInet6{}
+		copy(addr.Addr[:], ip.To16())
+		sa = addr
+	}
+	if method == models.ProbeMethodUDP {
+		protocol = syscall.IPPROTO_UDP
+		switch addr := sa.(type) {
+		case *syscall.SockaddrInet4:
+			addr.Port = MTUUDPPort
+		case *syscall.SockaddrInet6:
+			addr.Port = MTUUDPPort
+		}
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "mtu-probe")
+	defer f.Close()
+	if err := syscall.SetsockoptInt(fd, level, option, value); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Connect(fd, sa); err != nil {
+		return nil, os.NewSyscallError("connect", err)
+	}
+	// FileConn takes its own copy of the descriptor
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return &dfProbe{conn: conn, method: method, ip: ip, timeout: timeout}, nil
+}
+
+// fits sends a packet of size bytes and waits for the target's answer. The
+// kernel refuses a packet larger than a path MTU it has learned, which it
+// may only learn from the router's answer to the first attempt, so a probe
+// that gets no answer is sent once more.
+func (p *dfProbe) fits(ctx context.Context, size int) (bool, error) {
+	packet, err := p.packet(size - headerSize(p.ip))
+	if err != nil {
+		return false, err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		_, err := p.conn.Write(packet)
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return false, nil
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// Left over from an earlier probe's port unreachable
+			_, err = p.conn.Write(packet)
+		}
+		if err != nil {
+			return false, err
+		}
+		answered, err := p.await(ctx)
+		if err != nil || answered {
+			return answered, err
+		}
+	}
+	return false, nil
+}
+
+// packet builds a probe with a payload of n bytes: an echo request for
+// ICMP, zeros for UDP.
+func (p *dfProbe) packet(n int) ([]byte, error) {
+	if p.method == models.ProbeMethodUDP {
+		return make([]byte, n), nil
+	}
+	p.seq++
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if p.ip.To4() == nil {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: p.seq & 0xffff, Data: make([]byte, n)}}
+	return msg.Marshal(nil)
+}
+
+// await waits up to the probe timeout for an answer to the last packet:
+// port unreachable or any reply for UDP, the matching echo reply for ICMP.
+// It returns false without an error when none came.
+func (p *dfProbe) await(ctx context.Context) (bool, error) {
+	deadline := time.Now().Add(p.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	p.conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { p.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	protocol, reply := 1, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if p.ip.To4() == nil {
+		protocol, reply = 58, ipv6.ICMPTypeEchoReply
+	}
+	buf := make([]byte, MaxMTU)
+	for {
+		n, err := p.conn.Read(buf)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		var timeout net.Error
+		switch {
+		case errors.As(err, &timeout) && timeout.Timeout():
+			return false, nil
+		case p.method == models.ProbeMethodUDP && (err == nil || errors.Is(err, syscall.ECONNREFUSED)):
+			return true, nil
+		case err != nil:
+			return false, err
+		}
+		answer, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || answer.Type != reply {
+			continue
+		}
+		if echo, ok := answer.Body.(*icmp.Echo); ok && echo.Seq == p.seq&0xffff {
+			return true, nil
+		}
+	}
+}
+
+// Close closes the socket.
+func (p *dfProbe) Close() error {
+	return p.conn.Close()
+}
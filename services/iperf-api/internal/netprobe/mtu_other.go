@@ -0,0 +1,16 @@
+//go:build !linux
+
+package netprobe
+
+import (
+	"net"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// openPathProbe fails: only Linux lets an unprivileged socket set the
+// don't-fragment flag and report oversized packets.
+func openPathProbe(method models.ProbeMethod, ip net.IP, timeout time.Duration) (pathProbe, error) {
+	return nil, errMTUUnsupported
+}
@@ -0,0 +1,82 @@
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestValidateMTU(t *testing.T) {
+	req := models.MTURequest{Target: "192.0.2.1"}
+	if err := ValidateMTU(&req); err != nil {
+		t.Fatalf("ValidateMTU: %v", err)
+	}
+	want := models.MTURequest{Target: "192.0.2.1", Method: models.ProbeMethodICMP, Max: DefaultMaxMTU, Timeout: DefaultMTUTimeout}
+	if req != want {
+		t.Errorf("defaults = %+v, want %+v", req, want)
+	}
+
+	for _, req := range []models.MTURequest{
+		{},
+		{Target: "example.com", Method: models.ProbeMethodTCP},
+		{Target: "example.com", Max: MaxMTU + 1},
+		{Target: "example.com", Max: MinMTUv4},
+		{Target: "example.com", Min: 40},
+		{Target: "example.com", Min: 1500, Max: 1500},
+		{Target: "example.com", Timeout: -1},
+	} {
+		if err := ValidateMTU(&req); err == nil {
+			t.Errorf("ValidateMTU(%+v) accepted an invalid request", req)
+		}
+	}
+}
+
+func TestSearchMTU(t *testing.T) {
+	path := func(mtu int) func(context.Context, int) (bool, error) {
+		return func(_ context.Context, size int) (bool, error) { return size <= mtu, nil }
+	}
+	for _, tc := range []struct {
+		name       string
+		mtu        int
+		want       int
+		wantProbes int
+		wantErr    error
+	}{
+		{name: "full size", mtu: 9000, want: 1500, wantProbes: 1},
+		{name: "tunnel", mtu: 1420, want: 1420},
+		{name: "minimum", mtu: 68, want: 68},
+		{name: "no answer", mtu: 0, wantErr: errNoAnswer, wantProbes: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, probes, err := searchMTU(context.Background(), 68, 1500, path(tc.mtu))
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("searchMTU = %d, %v; want %d, %v", got, err, tc.want, tc.wantErr)
+			}
+			if tc.wantProbes != 0 && probes != tc.wantProbes {
+				t.Errorf("probes = %d, want %d", probes, tc.wantProbes)
+			}
+			// A binary search over 1432 sizes takes at most 2 + 11 probes
+			if probes > 13 {
+				t.Errorf("probes = %d, want at most 13", probes)
+			}
+		})
+	}
+}
+
+func TestDiscoverMTU_LoopbackUDP(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("path MTU discovery needs Linux")
+	}
+	// Loopback carries 64 KiB packets, so the whole range fits
+	result, err := DiscoverMTU(context.Background(), models.MTURequest{Target: "127.0.0.1", Method: models.ProbeMethodUDP, Max: 9000})
+	if err != nil {
+		t.Fatalf("DiscoverMTU: %v", err)
+	}
+	if result.PathMTU != 9000 || result.Probes != 1 || result.Address != "127.0.0.1" || result.Min != MinMTUv4 || result.Error != "" {
+		t.Errorf("result = %+v", result)
+	}
+
+}
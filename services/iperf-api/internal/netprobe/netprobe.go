@@ -0,0 +1,292 @@
+// Package netprobe measures round-trip latency to a target, with ICMP echo
+// requests or TCP connection setup, once or continuously, alongside the
+// throughput tests the iPerf server runs.
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Limits and defaults of a probe spec. Intervals and timeouts are in
+// seconds.
+const (
+	DefaultCount    = 5
+	MaxCount        = 100
+	DefaultInterval = 1.0
+	MinInterval     = 0.1
+	DefaultTimeout  = 2.0
+	MaxTimeout      = 30.0
+	// MaxProbes bounds the probes running at once
+	MaxProbes = 16
+)
+
+// ErrProbeNotFound is returned when stopping a probe that is not running.
+var ErrProbeNotFound = errors.New("latency probe not found")
+
+// ErrTooManyProbes is returned when starting a probe while MaxProbes run.
+var ErrTooManyProbes = errors.New("too many latency probes running")
+
+// PingFunc measures one round trip to target. seq numbers the pings of a
+// probe.
+type PingFunc func(ctx context.Context, method models.ProbeMethod, target string, seq int, timeout time.Duration) (time.Duration, error)
+
+// Handler receives a latency_ping message for each ping and a
+// latency_result message for each round. It is called from the probe's
+// goroutine, so a result can be saved, setting its ID, before it is sent on.
+type Handler func(msg models.WSMessage)
+
+// Prober runs latency probes.
+type Prober struct {
+	mu      sync.Mutex
+	probes  map[string]*probe
+	newID   ids.Generator
+	handler Handler
+	ping    PingFunc
+}
+
+// probe is a running probe and the means to stop it.
+type probe struct {
+	info   models.LatencyProbe
+	cancel context.CancelFunc
+	// seq numbers the probe's pings across rounds
+	seq int
+}
+
+// New creates a Prober. Probe IDs come from newID, or are random UUIDs if it
+// is nil.
+func New(newID ids.Generator, handler Handler) *Prober {
+	if newID == nil {
+		newID = ids.UUID
+	}
+	return &Prober{
+		probes:  make(map[string]*probe),
+		newID:   newID,
+		handler: handler,
+		ping:    Ping,
+	}
+}
+
+// Validate checks a probe spec and fills in defaults for the count,
+// interval, timeout and method.
+func Validate(spec *models.ProbeSpec) error {
+	spec.Target = strings.TrimSpace(spec.Target)
+	if spec.Target == "" || strings.ContainsAny(spec.Target, " \t/") {
+		return i18n.NewError("latency.invalid_target", i18n.Params{"target": spec.Target})
+	}
+	if spec.Method == "" {
+		spec.Method = models.ProbeMethodICMP
+	}
+	if spec.Method != models.ProbeMethodICMP && spec.Method != models.ProbeMethodTCP {
+		return i18n.NewError("latency.invalid_method", i18n.Params{"method": spec.Method})
+	}
+	if spec.Count == 0 {
+		spec.Count = DefaultCount
+	}
+	if spec.Count < 1 || spec.Count > MaxCount {
+		return i18n.NewError("latency.invalid_count", i18n.Params{"max": MaxCount})
+	}
+	if spec.Interval == 0 {
+		spec.Interval = DefaultInterval
+	}
+	if spec.Interval < MinInterval {
+		return i18n.NewError("latency.invalid_interval", i18n.Params{"min": MinInterval})
+	}
+	if spec.Timeout == 0 {
+		spec.Timeout = DefaultTimeout
+	}
+	if spec.Timeout < 0 || spec.Timeout > MaxTimeout {
+		return i18n.NewError("latency.invalid_timeout", i18n.Params{"max": MaxTimeout})
+	}
+	return nil
+}
+
+// Start validates spec and starts a probe in the background. A one-off
+// probe ends after one round of spec.Count pings; a continuous one runs
+// rounds until stopped.
+func (p *Prober) Start(spec models.ProbeSpec) (models.LatencyProbe, error) {
+	if err := Validate(&spec); err != nil {
+		return models.LatencyProbe{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.probes) >= MaxProbes {
+		return models.LatencyProbe{}, ErrTooManyProbes
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pr := &probe{
+		info: models.LatencyProbe{
+			ID:        p.newID(),
+			ProbeSpec: spec,
+			StartedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+	p.probes[pr.info.ID] = pr
+	go p.run(ctx, pr)
+	return pr.info, nil
+}
+
+// Stop stops a running probe. The pings of its current round are still
+// summarised.
+func (p *Prober) Stop(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, ok := p.probes[id]
+	if !ok {
+		return ErrProbeNotFound
+	}
+	pr.cancel()
+	delete(p.probes, id)
+	return nil
+}
+
+// Close stops every running probe.
+func (p *Prober) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pr := range p.probes {
+		pr.cancel()
+		delete(p.probes, id)
+	}
+}
+
+// List returns the running probes, oldest first.
+func (p *Prober) List() []models.LatencyProbe {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	probes := make([]models.LatencyProbe, 0, len(p.probes))
+	for _, pr := range p.probes {
+		probes = append(probes, pr.info)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		if !probes[i].StartedAt.Equal(probes[j].StartedAt) {
+			return probes[i].StartedAt.Before(probes[j].StartedAt)
+		}
+		return probes[i].ID < probes[j].ID
+	})
+	return probes
+}
+
+// run runs a probe's rounds until it is done or stopped.
+func (p *Prober) run(ctx context.Context, pr *probe) {
+	defer func() {
+		p.mu.Lock()
+		if p.probes[pr.info.ID] == pr {
+			delete(p.probes, pr.info.ID)
+		}
+		p.mu.Unlock()
+		pr.cancel()
+	}()
+
+	spec := pr.info.ProbeSpec
+	interval := seconds(spec.Interval)
+	for {
+		result := p.round(ctx, pr)
+		if result.Sent > 0 {
+			p.mu.Lock()
+			pr.info.Rounds++
+			p.mu.Unlock()
+			p.handler(models.WSMessage{Type: models.WSMessageTypeLatencyResult, Payload: result})
+		}
+		if !spec.Continuous {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// round sends one round of pings and summarises them. Pings cut short by
+// the probe stopping are not counted.
+func (p *Prober) round(ctx context.Context, pr *probe) *models.LatencyResult {
+	spec := pr.info.ProbeSpec
+	result := &models.LatencyResult{
+		ProbeID:   pr.info.ID,
+		Target:    spec.Target,
+		Method:    spec.Method,
+		Timestamp: time.Now().UTC(),
+	}
+	var rtts []float64
+	for i := 0; i < spec.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(seconds(spec.Interval)):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		pr.seq++
+		ping := &models.LatencyPing{
+			ProbeID:   pr.info.ID,
+			Target:    spec.Target,
+			Seq:       pr.seq,
+			Timestamp: time.Now().UTC(),
+		}
+		rtt, err := p.ping(ctx, spec.Method, spec.Target, pr.seq, seconds(spec.Timeout))
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		result.Sent++
+		if err != nil {
+			ping.Error = err.Error()
+			result.Error = ping.Error
+		} else {
+			ms := float64(rtt) / float64(time.Millisecond)
+			ping.RTT = &ms
+			rtts = append(rtts, ms)
+		}
+		p.handler(models.WSMessage{Type: models.WSMessageTypeLatencyPing, Payload: ping})
+	}
+	summarize(result, rtts)
+	return result
+}
+
+// summarize fills in a result's received count, loss and round-trip
+// statistics from the answered pings' round-trip times in milliseconds. The
+// standard deviation is the population one, as ping reports it.
+func summarize(result *models.LatencyResult, rtts []float64) {
+	result.Received = len(rtts)
+	if result.Sent > 0 {
+		result.PacketLoss = float64(result.Sent-result.Received) / float64(result.Sent) * 100
+	}
+	if len(rtts) == 0 {
+		return
+	}
+	result.Error = ""
+
+	lo, hi, sum := rtts[0], rtts[0], 0.0
+	for _, rtt := range rtts {
+		lo = math.Min(lo, rtt)
+		hi = math.Max(hi, rtt)
+		sum += rtt
+	}
+	avg := sum / float64(len(rtts))
+	variance := 0.0
+	for _, rtt := range rtts {
+		variance += (rtt - avg) * (rtt - avg)
+	}
+	stddev := math.Sqrt(variance / float64(len(rtts)))
+	result.RTTMin, result.RTTAvg, result.RTTMax, result.RTTStdDev = &lo, &avg, &hi, &stddev
+}
+
+// seconds converts a spec's seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
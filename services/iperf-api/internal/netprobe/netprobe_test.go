@@ -0,0 +1,225 @@
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"golang.org/x/net/icmp"
+)
+
+func TestValidate(t *testing.T) {
+	spec := models.ProbeSpec{Target: " 192.0.2.1 "}
+	if err := Validate(&spec); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	want := models.ProbeSpec{Target: "192.0.2.1", Method: models.ProbeMethodICMP, Count: DefaultCount, Interval: DefaultInterval, Timeout: DefaultTimeout}
+	if spec != want {
+		t.Errorf("defaults = %+v, want %+v", spec, want)
+	}
+
+	for _, spec := range []models.ProbeSpec{
+		{},
+		{Target: "http://example.com"},
+		{Target: "example.com", Method: "udp"},
+		{Target: "example.com", Count: MaxCount + 1},
+		{Target: "example.com", Count: -1},
+		{Target: "example.com", Interval: 0.01},
+		{Target: "example.com", Timeout: MaxTimeout + 1},
+	} {
+		if err := Validate(&spec); err == nil {
+			t.Errorf("Validate(%+v) accepted an invalid spec", spec)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	result := &models.LatencyResult{Sent: 4, Error: "timed out"}
+	summarize(result, []float64{10, 20, 30})
+	if result.Received != 3 || result.PacketLoss != 25 || result.Error != "" {
+		t.Errorf("result = %+v", result)
+	}
+	if *result.RTTMin != 10 || *result.RTTAvg != 20 || *result.RTTMax != 30 {
+		t.Errorf("min/avg/max = %v/%v/%v, want 10/20/30", *result.RTTMin, *result.RTTAvg, *result.RTTMax)
+	}
+	if math.Abs(*result.RTTStdDev-math.Sqrt(200.0/3)) > 1e-9 {
+		t.Errorf("stddev = %v", *result.RTTStdDev)
+	}
+
+	// Nothing answered: no statistics, the error is kept
+	lost := &models.LatencyResult{Sent: 2, Error: "timed out"}
+	summarize(lost, nil)
+	if lost.PacketLoss != 100 || lost.RTTAvg != nil || lost.Error != "timed out" {
+		t.Errorf("lost = %+v", lost)
+	}
+}
+
+// recorder collects a Prober's messages.
+type recorder struct {
+	mu      sync.Mutex
+	pings   []*models.LatencyPing
+	results chan *models.LatencyResult
+}
+
+func newRecorder() *recorder {
+	return &recorder{results: make(chan *models.LatencyResult, 16)}
+}
+
+func (r *recorder) handle(msg models.WSMessage) {
+	switch p := msg.Payload.(type) {
+	case *models.LatencyPing:
+		r.mu.Lock()
+		r.pings = append(r.pings, p)
+		r.mu.Unlock()
+	case *models.LatencyResult:
+		r.results <- p
+	}
+}
+
+func (r *recorder) next(t *testing.T) *models.LatencyResult {
+	t.Helper()
+	select {
+	case result := <-r.results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a latency result")
+		return nil
+	}
+}
+
+func TestProber_OneOff(t *testing.T) {
+	rec := newRecorder()
+	p := New(func() string { return "probe-1" }, rec.handle)
+	// Every third ping is lost
+	p.ping = func(ctx context.Context, method models.ProbeMethod, target string, seq int, timeout time.Duration) (time.Duration, error) {
+		if seq%3 == 0 {
+			return 0, errTimeout
+		}
+		return time.Duration(seq) * time.Millisecond, nil
+	}
+
+	probe, err := p.Start(models.ProbeSpec{Target: "192.0.2.1", Method: models.ProbeMethodTCP, Count: 4, Interval: MinInterval})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if probe.ID != "probe-1" || probe.Timeout != DefaultTimeout {
+		t.Errorf("probe = %+v", probe)
+	}
+
+	result := rec.next(t)
+	if result.ProbeID != "probe-1" || result.Target != "192.0.2.1" || result.Method != models.ProbeMethodTCP {
+		t.Errorf("result = %+v", result)
+	}
+	if result.Sent != 4 || result.Received != 3 || result.PacketLoss != 25 || *result.RTTMin != 1 || *result.RTTMax != 4 {
+		t.Errorf("result = %+v, want 3 of 4 answered in 1-4 ms", result)
+	}
+	rec.mu.Lock()
+	if len(rec.pings) != 4 || rec.pings[2].RTT != nil || rec.pings[2].Error != "timed out" || *rec.pings[3].RTT != 4 {
+		t.Errorf("pings = %+v", rec.pings)
+	}
+	rec.mu.Unlock()
+
+	// A one-off probe is gone once its round is done
+	deadline := time.Now().Add(time.Second)
+	for len(p.List()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if probes := p.List(); len(probes) != 0 {
+		t.Errorf("running probes = %+v, want none", probes)
+	}
+	if err := p.Stop("probe-1"); !errors.Is(err, ErrProbeNotFound) {
+		t.Errorf("Stop of a finished probe = %v, want ErrProbeNotFound", err)
+	}
+}
+
+func TestProber_Continuous(t *testing.T) {
+	rec := newRecorder()
+	p := New(nil, rec.handle)
+	defer p.Close()
+	p.ping = func(ctx context.Context, method models.ProbeMethod, target string, seq int, timeout time.Duration) (time.Duration, error) {
+		return time.Millisecond, nil
+	}
+
+	probe, err := p.Start(models.ProbeSpec{Target: "192.0.2.1", Count: 1, Interval: MinInterval, Continuous: true})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	first, second := rec.next(t), rec.next(t)
+	if first.Sent != 1 || second.Sent != 1 || !second.Timestamp.After(first.Timestamp) {
+		t.Errorf("rounds = %+v, %+v", first, second)
+	}
+	if probes := p.List(); len(probes) != 1 || probes[0].ID != probe.ID || probes[0].Rounds < 2 {
+		t.Errorf("running probes = %+v", probes)
+	}
+
+	if err := p.Stop(probe.ID); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if probes := p.List(); len(probes) != 0 {
+		t.Errorf("running probes after Stop = %+v", probes)
+	}
+}
+
+func TestProber_Limit(t *testing.T) {
+	p := New(nil, func(models.WSMessage) {})
+	defer p.Close()
+	p.ping = func(ctx context.Context, method models.ProbeMethod, target string, seq int, timeout time.Duration) (time.Duration, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	for i := 0; i < MaxProbes; i++ {
+		if _, err := p.Start(models.ProbeSpec{Target: "192.0.2.1", Continuous: true}); err != nil {
+			t.Fatalf("Start %d: %v", i, err)
+		}
+	}
+	if _, err := p.Start(models.ProbeSpec{Target: "192.0.2.1"}); !errors.Is(err, ErrTooManyProbes) {
+		t.Errorf("Start beyond the limit = %v, want ErrTooManyProbes", err)
+	}
+}
+
+func TestPing_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	rtt, err := Ping(context.Background(), models.ProbeMethodTCP, ln.Addr().String(), 1, time.Second)
+	if err != nil || rtt <= 0 {
+		t.Errorf("Ping = %v, %v; want a round trip", rtt, err)
+	}
+
+	// Nothing listens on a closed listener's port
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err := Ping(context.Background(), models.ProbeMethodTCP, addr, 2, time.Second); err == nil {
+		t.Error("Ping of a closed port succeeded")
+	}
+}
+
+func TestPing_ICMPLoopback(t *testing.T) {
+	conn, err := icmp.ListenPacket("udp4", "")
+	if err != nil {
+		t.Skipf("unprivileged ICMP sockets are not allowed here: %v", err)
+	}
+	conn.Close()
+
+	rtt, err := Ping(context.Background(), models.ProbeMethodICMP, "127.0.0.1", 1, time.Second)
+	if err != nil || rtt <= 0 {
+		t.Errorf("Ping = %v, %v; want a round trip", rtt, err)
+	}
+}
@@ -0,0 +1,141 @@
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultTCPPort is connected to by TCP probes whose target has no port.
+const DefaultTCPPort = "443"
+
+// errTimeout reports a ping that got no answer in time.
+var errTimeout = errors.New("timed out")
+
+// Ping measures one round trip to target by method, waiting up to timeout
+// for the answer. The target's name is resolved first, so resolution time is
+// not counted.
+func Ping(ctx context.Context, method models.ProbeMethod, target string, seq int, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var rtt time.Duration
+	var err error
+	switch method {
+	case models.ProbeMethodTCP:
+		rtt, err = pingTCP(ctx, target)
+	case models.ProbeMethodICMP:
+		rtt, err = pingICMP(ctx, target, seq)
+	default:
+		return 0, fmt.Errorf("unknown probe method %q", method)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, errTimeout
+	}
+	return rtt, err
+}
+
+// pingTCP times a TCP handshake with target, on DefaultTCPPort unless it
+// names a port. A refused connection is an error, not an answer.
+func pingTCP(ctx context.Context, target string) (time.Duration, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = strings.Trim(target, "[]"), DefaultTCPPort
+	}
+	ip, err := resolve(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+
+	var d net.Dialer
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt, nil
+}
+
+// pingICMP sends an ICMP echo request to target and waits for its reply. It
+// uses an unprivileged datagram socket, which on Linux needs the process's
+// group to be in net.ipv4.ping_group_range.
+func pingICMP(ctx context.Context, target string, seq int) (time.Duration, error) {
+	ip, err := resolve(ctx, strings.Trim(target, "[]"))
+	if err != nil {
+		return 0, err
+	}
+	network, protocol := "udp4", 1
+	var request, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, protocol = "udp6", 58
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		return 0, fmt.Errorf("opening ICMP socket: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	seq &= 0xffff
+	msg := icmp.Message{
+		Type: request,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("fak-netprobe")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(data, &net.UDPAddr{IP: ip}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		// The kernel sets the echo ID of a datagram socket, so replies
+		// are matched by sequence number
+		answer, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || answer.Type != reply {
+			continue
+		}
+		if echo, ok := answer.Body.(*icmp.Echo); ok && echo.Seq == seq {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// resolve returns the first address of host.
+func resolve(ctx context.Context, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	return addrs[0].IP, nil
+}
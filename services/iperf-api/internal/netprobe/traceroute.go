@@ -0,0 +1,140 @@
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Limits and defaults of a traceroute. The timeout is in seconds per probe.
+const (
+	DefaultMaxHops = 30
+	MaxHops        = 64
+	DefaultQueries = 3
+	MaxQueries     = 5
+	// DefaultTraceTimeout is how long to wait for each probe's answer
+	DefaultTraceTimeout = 1.0
+	// MaxTraceroutes is how many traceroutes may run at once
+	MaxTraceroutes = 4
+	// TracerouteUDPPort is the port of the first probe; each probe after it
+	// goes to the next port, which is how answers are matched to probes
+	TracerouteUDPPort = 33434
+)
+
+// errTracerouteUnsupported is returned on platforms without an unprivileged
+// way to read the routers' answers.
+var errTracerouteUnsupported = errors.New("traceroute is only supported on Linux")
+
+// hopReply is the answer to one traceroute probe. A probe that got no
+// answer has no addr.
+type hopReply struct {
+	addr net.IP
+	rtt  time.Duration
+	// reached is set when the target itself answered
+	reached bool
+	// unreachable names an unreachable error, which ends the trace
+	unreachable string
+}
+
+// hopProbe sends probes with a limited TTL.
+type hopProbe interface {
+	// probe sends probe seq with the given TTL and waits up to timeout for
+	// its answer
+	probe(ctx context.Context, ttl, seq int, timeout time.Duration) (hopReply, error)
+	Close() error
+}
+
+// ValidateTraceroute checks a traceroute request and fills in defaults.
+func ValidateTraceroute(req *models.TracerouteRequest) error {
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Target == "" || strings.ContainsAny(req.Target, " \t/") {
+		return i18n.NewError("latency.invalid_target", i18n.Params{"target": req.Target})
+	}
+	if req.MaxHops == 0 {
+		req.MaxHops = DefaultMaxHops
+	}
+	if req.MaxHops < 1 || req.MaxHops > MaxHops {
+		return i18n.NewError("traceroute.invalid_max_hops", i18n.Params{"max": MaxHops})
+	}
+	if req.Queries == 0 {
+		req.Queries = DefaultQueries
+	}
+	if req.Queries < 1 || req.Queries > MaxQueries {
+		return i18n.NewError("traceroute.invalid_queries", i18n.Params{"max": MaxQueries})
+	}
+	if req.Timeout == 0 {
+		req.Timeout = DefaultTraceTimeout
+	}
+	if req.Timeout < 0 || req.Timeout > MaxTimeout {
+		return i18n.NewError("latency.invalid_timeout", i18n.Params{"max": MaxTimeout})
+	}
+	return nil
+}
+
+// Traceroute finds the path to trace.Target by sending UDP probes with
+// increasing TTLs, each hop's probes one after the other, and calls onHop
+// with every hop as it resolves. It stops once the target answers, a hop
+// reports the target unreachable or trace.MaxHops is reached, recording the
+// address, hops and whether the target was reached in trace. The request
+// in trace must have been validated.
+func Traceroute(ctx context.Context, trace *models.Traceroute, onHop func(models.TracerouteHop)) error {
+	ip, err := resolve(ctx, strings.Trim(trace.Target, "[]"))
+	if err != nil {
+		return err
+	}
+	trace.Address = ip.String()
+
+	probe, err := openHopProbe(ip)
+	if err != nil {
+		return err
+	}
+	defer probe.Close()
+	return tracePath(ctx, trace, probe, onHop)
+}
+
+// tracePath probes hop after hop with probe until the trace ends.
+func tracePath(ctx context.Context, trace *models.Traceroute, probe hopProbe, onHop func(models.TracerouteHop)) error {
+	timeout := seconds(trace.Timeout)
+	seq := 0
+	for ttl := 1; ttl <= trace.MaxHops; ttl++ {
+		hop := models.TracerouteHop{TraceID: trace.ID, TTL: ttl, RTTs: make([]*float64, 0, trace.Queries)}
+		reached := false
+		for q := 0; q < trace.Queries; q++ {
+			reply, err := probe.probe(ctx, ttl, seq, timeout)
+			seq++
+			if err != nil {
+				return err
+			}
+			if reply.unreachable != "" {
+				hop.Error = reply.unreachable
+			}
+			if reply.addr == nil {
+				hop.RTTs = append(hop.RTTs, nil)
+				continue
+			}
+			ms := float64(reply.rtt) / float64(time.Millisecond)
+			hop.RTTs = append(hop.RTTs, &ms)
+			if hop.Address == "" {
+				hop.Address = reply.addr.String()
+			}
+			reached = reached || reply.reached
+		}
+		trace.Hops = append(trace.Hops, hop)
+		if onHop != nil {
+			onHop(hop)
+		}
+		if reached {
+			trace.Reached = true
+			return nil
+		}
+		if hop.Error != "" {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package netprobe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ICMP types and codes a traceroute answer can carry.
+const (
+	icmpv4TimeExceeded    = 11
+	icmpv4Unreachable     = 3
+	icmpv4PortUnreachable = 3
+	icmpv6TimeExceeded    = 3
+	icmpv6Unreachable     = 1
+	icmpv6PortUnreachable = 4
+
+	// soEEOrigin* say where an error queue entry came from, see
+	// linux/errqueue.h
+	soEEOriginLocal = 1
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+// unreachableV4 and unreachableV6 name the destination unreachable codes
+// that end a trace.
+var (
+	unreachableV4 = map[byte]string{
+		0: "network unreachable", 1: "host unreachable", 2: "protocol unreachable",
+		4: "fragmentation needed", 9: "network prohibited", 10: "host prohibited",
+		13: "administratively prohibited",
+	}
+	unreachableV6 = map[byte]string{
+		0: "no route", 1: "administratively prohibited", 3: "address unreachable",
+	}
+)
+
+// errqueueProbe is an unconnected UDP socket with IP_RECVERR set, so the
+// ICMP errors routers send back are queued on the socket for an
+// unprivileged process to read, as tracepath does.
+type errqueueProbe struct {
+	fd int
+	ip net.IP
+}
+
+// openHopProbe opens a UDP socket to trace the path to ip.
+func openHopProbe(ip net.IP) (hopProbe, error) {
+	family, level, option := syscall.AF_INET, syscall.IPPROTO_IP, syscall.IP_RECVERR
+	if ip.To4() == nil {
+		family, level, option = syscall.AF_INET6, syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.SetsockoptInt(fd, level, option, 1); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	return &errqueueProbe{fd: fd, ip: ip}, nil
+}
+
+// probe sends an empty datagram with the given TTL to the port for seq and
+// polls the error queue for the answer until timeout.
+func (p *errqueueProbe) probe(ctx context.Context, ttl, seq int, timeout time.Duration) (hopReply, error) {
+	port := TracerouteUDPPort + seq
+	var sa syscall.Sockaddr
+	level, option := syscall.IPPROTO_IP, syscall.IP_TTL
+	if ip4 := p.ip.To4(); ip4 != nil {
+		addr := &syscall.SockaddrInet4{Port: port}
+		copy(addr.Addr[:], ip4)
+		sa = addr
+	} else {
+		level, option = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+		addr := &syscall.SockaddrInet6{Port: port}
+		copy(addr.Addr[:], p.ip.To16())
+		sa = addr
+	}
+	if err := syscall.SetsockoptInt(p.fd, level, option, ttl); err != nil {
+		return hopReply{}, os.NewSyscallError("setsockopt", err)
+	}
+
+	sent := time.Now()
+	if err := syscall.Sendto(p.fd, make([]byte, 32), 0, sa); err != nil {
+		// An error answering an earlier probe can surface here; the entry
+		// stays queued for readError to discard
+		if err := syscall.Sendto(p.fd, make([]byte, 32), 0, sa); err != nil {
+			return hopReply{}, os.NewSyscallError("sendto", err)
+		}
+	}
+
+	deadline := sent.Add(timeout)
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		reply, ok, err := p.readError(port)
+		if err != nil || ok {
+			reply.rtt = time.Since(sent)
+			return reply, err
+		}
+		if time.Now().After(deadline) {
+			return hopReply{}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return hopReply{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// readError takes entries off the error queue until it finds the answer to
+// the probe sent to port, discarding answers to earlier probes. It returns
+// false when the queue runs dry first.
+func (p *errqueueProbe) readError(port int) (hopReply, bool, error) {
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	for {
+		_, oobn, _, from, err := syscall.Recvmsg(p.fd, buf, oob, syscall.MSG_ERRQUEUE)
+		if errors.Is(err, syscall.EAGAIN) {
+			return hopReply{}, false, nil
+		}
+		if err != nil {
+			return hopReply{}, false, os.NewSyscallError("recvmsg", err)
+		}
+		// The error queue gives back the address the probe was sent to
+		var to int
+		switch sa := from.(type) {
+		case *syscall.SockaddrInet4:
+			to = sa.Port
+		case *syscall.SockaddrInet6:
+			to = sa.Port
+		}
+		if to != port {
+			continue
+		}
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return hopReply{}, false, err
+		}
+		for _, m := range msgs {
+			if (m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR) ||
+				(m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) {
+				if reply, ok := parseExtendedErr(m.Data); ok {
+					return reply, true, nil
+				}
+			}
+		}
+	}
+}
+
+// parseExtendedErr reads a struct sock_extended_err and the address of the
+// host that sent it, which follows it.
+func parseExtendedErr(data []byte) (hopReply, bool) {
+	if len(data) < 16 {
+		return hopReply{}, false
+	}
+	errno := binary.NativeEndian.Uint32(data[0:4])
+	origin, typ, code := data[4], data[5], data[6]
+
+	var reply hopReply
+	offender := data[16:]
+	if len(offender) >= 2 {
+		switch binary.NativeEndian.Uint16(offender[0:2]) {
+		case syscall.AF_INET:
+			if len(offender) >= 8 {
+				reply.addr = net.IP(append([]byte(nil), offender[4:8]...))
+			}
+		case syscall.AF_INET6:
+			if len(offender) >= 24 {
+				reply.addr = net.IP(append([]byte(nil), offender[8:24]...))
+			}
+		}
+	}
+
+	switch origin {
+	case soEEOriginICMP:
+		switch {
+		case typ == icmpv4TimeExceeded:
+		case typ == icmpv4Unreachable && code == icmpv4PortUnreachable:
+			reply.reached = true
+		case typ == icmpv4Unreachable:
+			reply.unreachable = unreachableName(unreachableV4, code)
+		default:
+			return hopReply{}, false
+		}
+	case soEEOriginICMP6:
+		switch {
+		case typ == icmpv6TimeExceeded:
+		case typ == icmpv6Unreachable && code == icmpv6PortUnreachable:
+			reply.reached = true
+		case typ == icmpv6Unreachable:
+			reply.unreachable = unreachableName(unreachableV6, code)
+		default:
+			return hopReply{}, false
+		}
+	case soEEOriginLocal:
+		// The local stack refused the probe, for example for lack of a route
+		reply.unreachable = syscall.Errno(errno).Error()
+	default:
+		return hopReply{}, false
+	}
+	if reply.addr == nil && reply.unreachable == "" {
+		return hopReply{}, false
+	}
+	return reply, true
+}
+
+// unreachableName names an unreachable code, falling back to the number.
+func unreachableName(names map[byte]string, code byte) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unreachable (code %d)", code)
+}
+
+// Close closes the socket.
+func (p *errqueueProbe) Close() error {
+	return syscall.Close(p.fd)
+}
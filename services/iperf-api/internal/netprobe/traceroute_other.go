@@ -0,0 +1,11 @@
+//go:build !linux
+
+package netprobe
+
+import "net"
+
+// openHopProbe fails: only Linux lets an unprivileged socket read the ICMP
+// errors routers send back.
+func openHopProbe(ip net.IP) (hopProbe, error) {
+	return nil, errTracerouteUnsupported
+}
@@ -0,0 +1,133 @@
+package netprobe
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestValidateTraceroute(t *testing.T) {
+	req := models.TracerouteRequest{Target: " 192.0.2.1 "}
+	if err := ValidateTraceroute(&req); err != nil {
+		t.Fatalf("ValidateTraceroute: %v", err)
+	}
+	want := models.TracerouteRequest{Target: "192.0.2.1", MaxHops: DefaultMaxHops, Queries: DefaultQueries, Timeout: DefaultTraceTimeout}
+	if req != want {
+		t.Errorf("defaults = %+v, want %+v", req, want)
+	}
+
+	for _, req := range []models.TracerouteRequest{
+		{},
+		{Target: "a b"},
+		{Target: "example.com", MaxHops: MaxHops + 1},
+		{Target: "example.com", MaxHops: -1},
+		{Target: "example.com", Queries: MaxQueries + 1},
+		{Target: "example.com", Timeout: MaxTimeout + 1},
+	} {
+		if err := ValidateTraceroute(&req); err == nil {
+			t.Errorf("ValidateTraceroute(%+v) accepted an invalid request", req)
+		}
+	}
+}
+
+// pathProber answers traceroute probes from a fixed path: routers[i]
+// answers TTL i+1, nil for a silent hop, and the hop after the last router
+// is the target.
+type pathProber struct {
+	routers []net.IP
+	target  net.IP
+	// unreachable, if set, is reported by the last router instead
+	unreachable string
+	seqs        []int
+}
+
+func (p *pathProber) probe(_ context.Context, ttl, seq int, _ time.Duration) (hopReply, error) {
+	p.seqs = append(p.seqs, seq)
+	if ttl > len(p.routers) {
+		return hopReply{addr: p.target, rtt: time.Millisecond, reached: true}, nil
+	}
+	if p.routers[ttl-1] == nil {
+		return hopReply{}, nil
+	}
+	reply := hopReply{addr: p.routers[ttl-1], rtt: time.Millisecond}
+	if ttl == len(p.routers) {
+		reply.unreachable = p.unreachable
+	}
+	return reply, nil
+}
+
+func (p *pathProber) Close() error { return nil }
+
+func TestTracePath(t *testing.T) {
+	router := net.ParseIP("192.0.2.1")
+	target := net.ParseIP("198.51.100.7")
+
+	t.Run("reached", func(t *testing.T) {
+		trace := &models.Traceroute{ID: "t1", TracerouteRequest: models.TracerouteRequest{MaxHops: 10, Queries: 2, Timeout: 1}}
+		probe := &pathProber{routers: []net.IP{router, nil}, target: target}
+		var streamed []models.TracerouteHop
+		if err := tracePath(context.Background(), trace, probe, func(h models.TracerouteHop) { streamed = append(streamed, h) }); err != nil {
+			t.Fatalf("tracePath: %v", err)
+		}
+		if !trace.Reached || len(trace.Hops) != 3 || len(streamed) != 3 {
+			t.Fatalf("trace = %+v, streamed %d hops", trace, len(streamed))
+		}
+		if h := trace.Hops[0]; h.TraceID != "t1" || h.TTL != 1 || h.Address != "192.0.2.1" || len(h.RTTs) != 2 || h.RTTs[0] == nil || *h.RTTs[0] != 1 {
+			t.Errorf("first hop = %+v", h)
+		}
+		if h := trace.Hops[1]; h.Address != "" || len(h.RTTs) != 2 || h.RTTs[0] != nil || h.RTTs[1] != nil {
+			t.Errorf("silent hop = %+v", h)
+		}
+		if h := trace.Hops[2]; h.Address != "198.51.100.7" {
+			t.Errorf("last hop = %+v", h)
+		}
+		// Every probe goes to a port of its own
+		for i, seq := range probe.seqs {
+			if seq != i {
+				t.Fatalf("probe sequence = %v", probe.seqs)
+			}
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		trace := &models.Traceroute{TracerouteRequest: models.TracerouteRequest{MaxHops: 10, Queries: 1, Timeout: 1}}
+		probe := &pathProber{routers: []net.IP{router, router}, target: target, unreachable: "host unreachable"}
+		if err := tracePath(context.Background(), trace, probe, nil); err != nil {
+			t.Fatalf("tracePath: %v", err)
+		}
+		if trace.Reached || len(trace.Hops) != 2 || trace.Hops[1].Error != "host unreachable" {
+			t.Errorf("trace = %+v", trace)
+		}
+	})
+
+	t.Run("max hops", func(t *testing.T) {
+		trace := &models.Traceroute{TracerouteRequest: models.TracerouteRequest{MaxHops: 3, Queries: 1, Timeout: 1}}
+		probe := &pathProber{routers: make([]net.IP, 5), target: target}
+		if err := tracePath(context.Background(), trace, probe, nil); err != nil {
+			t.Fatalf("tracePath: %v", err)
+		}
+		if trace.Reached || len(trace.Hops) != 3 {
+			t.Errorf("trace = %+v", trace)
+		}
+	})
+}
+
+func TestTraceroute_Loopback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("traceroute is only supported on Linux")
+	}
+	trace := &models.Traceroute{TracerouteRequest: models.TracerouteRequest{Target: "127.0.0.1"}}
+	if err := ValidateTraceroute(&trace.TracerouteRequest); err != nil {
+		t.Fatal(err)
+	}
+	if err := Traceroute(context.Background(), trace, nil); err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+	if !trace.Reached || len(trace.Hops) != 1 || trace.Hops[0].Address != "127.0.0.1" || trace.Address != "127.0.0.1" {
+		t.Errorf("trace = %+v", trace)
+	}
+}
@@ -0,0 +1,129 @@
+// Package oui maps MAC addresses to the vendor their organizationally unique
+// identifier (OUI) is registered to, using the IEEE registry.
+package oui
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// builtin is a small extract of the IEEE registry covering common
+// virtualisation, single-board and network vendors; load the full registry
+// for anything else.
+//
+//go:embed oui.csv
+var builtin []byte
+
+// assignmentLengths are the prefix lengths in hex digits of MA-S (36 bit),
+// MA-M (28 bit) and MA-L (24 bit) assignments, longest first.
+var assignmentLengths = []int{9, 7, 6}
+
+// Database maps MAC address prefixes to vendor names.
+type Database struct {
+	vendors map[string]string
+}
+
+// Builtin returns the database embedded in the binary.
+func Builtin() *Database {
+	db, err := Parse(bytes.NewReader(builtin))
+	if err != nil {
+		panic(fmt.Sprintf("oui: invalid builtin database: %v", err))
+	}
+	return db
+}
+
+// Load reads a registry file in the IEEE CSV format, such as oui.csv,
+// mam.csv or oui36.csv from standards-oui.ieee.org.
+func Load(path string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Parse reads a registry in the IEEE CSV format: a header, then rows of
+// registry, assignment (hex prefix) and organization name.
+func Parse(r io.Reader) (*Database, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if len(header) < 3 || !strings.EqualFold(strings.TrimSpace(header[1]), "Assignment") {
+		return nil, fmt.Errorf("unexpected header %q", strings.Join(header, ","))
+	}
+
+	db := &Database{vendors: make(map[string]string)}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 3 {
+			continue
+		}
+		prefix := strings.ToUpper(strings.TrimSpace(row[1]))
+		if !validPrefix(prefix) {
+			return nil, fmt.Errorf("invalid assignment %q", row[1])
+		}
+		db.vendors[prefix] = strings.TrimSpace(row[2])
+	}
+	return db, nil
+}
+
+func validPrefix(prefix string) bool {
+	switch len(prefix) {
+	case 6, 7, 9:
+	default:
+		return false
+	}
+	for _, c := range prefix {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of assignments in the database.
+func (db *Database) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.vendors)
+}
+
+// Lookup returns the vendor of mac, or "" if it is unknown, not a valid
+// address or locally administered, as randomised addresses are.
+func (db *Database) Lookup(mac string) string {
+	if db == nil {
+		return ""
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) < 6 || hw[0]&0x02 != 0 {
+		return ""
+	}
+	digits := strings.ToUpper(fmt.Sprintf("%x", []byte(hw)))
+	for _, n := range assignmentLengths {
+		if vendor, ok := db.vendors[digits[:n]]; ok {
+			return vendor
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package oui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltin(t *testing.T) {
+	db := Builtin()
+	if db.Len() == 0 {
+		t.Fatal("builtin database is empty")
+	}
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		{"00:50:56:12:34:56", "VMware, Inc."},
+		{"b8-27-eb-00-11-22", "Raspberry Pi Foundation"},
+		{"0800.2712.3456", "PCS Systemtechnik GmbH"},
+		// Locally administered, as randomised addresses are
+		{"02:50:56:12:34:56", ""},
+		{"ff:ff:ff:00:00:00", ""},
+		{"not a mac", ""},
+	}
+	for _, tt := range tests {
+		if got := db.Lookup(tt.mac); got != tt.want {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.mac, got, tt.want)
+		}
+	}
+
+	var unset *Database
+	if got := unset.Lookup("00:50:56:12:34:56"); got != "" {
+		t.Errorf("nil database Lookup = %q", got)
+	}
+}
+
+func TestLoad_LongestPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oui.csv")
+	os.WriteFile(path, []byte(`Registry,Assignment,Organization Name,Organization Address
+MA-L,70B3D5,IEEE Registration Authority,"445 Hoes Lane Piscataway NJ US 08554 "
+MA-S,70B3D5123,"Example Sensors, Ltd",Somewhere
+MA-M,8C1F640,Example Cameras,
+`), 0o644)
+	db, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if db.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", db.Len())
+	}
+	for mac, want := range map[string]string{
+		"70:b3:d5:12:34:56": "Example Sensors, Ltd",
+		"70:b3:d5:99:34:56": "IEEE Registration Authority",
+		"8c:1f:64:0a:bc:de": "Example Cameras",
+		"8c:1f:64:1a:bc:de": "",
+	} {
+		if got := db.Lookup(mac); got != want {
+			t.Errorf("Lookup(%q) = %q, want %q", mac, got, want)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty":      "",
+		"no header":  "MA-L,000C29,VMware\n",
+		"bad prefix": "Registry,Assignment,Organization Name\nMA-L,XYZ123,Nobody\n",
+	} {
+		if _, err := Parse(strings.NewReader(content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
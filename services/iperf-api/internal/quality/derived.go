@@ -0,0 +1,24 @@
+package quality
+
+import "github.com/Tom-Oram/fak/backend/internal/models"
+
+// Derive sets the result's derived metrics from its measurements. Each is
+// left nil when the result lacks what it needs: retransmits per GB needs
+// TCP retransmits and data, the goodput ratio a linkSpeed in bits per
+// second and a duration, and the jitter ratio UDP jitter and bandwidth.
+func Derive(r *models.TestResult, linkSpeed float64) {
+	r.RetransmitsPerGB, r.GoodputRatio, r.JitterRatio = nil, nil, nil
+
+	if r.Retransmits != nil && r.BytesTransferred > 0 {
+		v := float64(*r.Retransmits) / (float64(r.BytesTransferred) / 1e9)
+		r.RetransmitsPerGB = &v
+	}
+	if linkSpeed > 0 && r.Duration > 0 {
+		v := float64(r.BytesTransferred) * 8 / r.Duration / linkSpeed
+		r.GoodputRatio = &v
+	}
+	if r.Jitter != nil && r.AvgBandwidth > 0 {
+		v := *r.Jitter / (r.AvgBandwidth / 1e6)
+		r.JitterRatio = &v
+	}
+}
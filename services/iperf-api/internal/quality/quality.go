@@ -0,0 +1,58 @@
+// Package quality flags test results whose measurements are likely invalid,
+// so they can be filtered out of history and aggregates, and derives
+// efficiency metrics from their measurements.
+package quality
+
+import (
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Options tunes the quality checks.
+type Options struct {
+	// ExpectedDuration is the test length assumed when iperf3 did not report
+	// the requested duration. Zero skips the check for such results.
+	ExpectedDuration float64
+	// ShortDurationRatio flags results shorter than this fraction of the
+	// requested or expected duration.
+	ShortDurationRatio float64
+	// MaxClockSkew flags results timestamped further than this in the future.
+	MaxClockSkew time.Duration
+}
+
+// DefaultOptions returns Options with sensible defaults.
+func DefaultOptions() Options {
+	return Options{
+		ExpectedDuration:   0,
+		ShortDurationRatio: 0.5,
+		MaxClockSkew:       5 * time.Minute,
+	}
+}
+
+// Assess returns the quality flags for a result measured relative to now.
+func Assess(r *models.TestResult, opts Options, now time.Time) []models.QualityFlag {
+	var flags []models.QualityFlag
+
+	expected := opts.ExpectedDuration
+	if r.RequestedDuration != nil {
+		expected = *r.RequestedDuration
+	}
+	if expected > 0 && r.Duration < expected*opts.ShortDurationRatio {
+		flags = append(flags, models.QualityFlagShortDuration)
+	}
+
+	if r.BytesTransferred == 0 {
+		flags = append(flags, models.QualityFlagZeroBytes)
+	}
+
+	if r.MinBandwidth == 0 {
+		flags = append(flags, models.QualityFlagZeroMinBandwidth)
+	}
+
+	if opts.MaxClockSkew > 0 && r.Timestamp.After(now.Add(opts.MaxClockSkew)) {
+		flags = append(flags, models.QualityFlagClockSkew)
+	}
+
+	return flags
+}
@@ -0,0 +1,134 @@
+package quality
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func goodResult(now time.Time) *models.TestResult {
+	return &models.TestResult{
+		Timestamp:        now,
+		Duration:         10.0,
+		BytesTransferred: 1 << 30,
+		AvgBandwidth:     900e6,
+		MinBandwidth:     850e6,
+		MaxBandwidth:     950e6,
+	}
+}
+
+func TestAssess_CleanResult(t *testing.T) {
+	now := time.Now()
+	opts := DefaultOptions()
+	opts.ExpectedDuration = 10
+
+	if flags := Assess(goodResult(now), opts, now); len(flags) != 0 {
+		t.Errorf("flags = %v, want none", flags)
+	}
+}
+
+func TestAssess_ShortDuration(t *testing.T) {
+	now := time.Now()
+	requested := 30.0
+
+	tests := []struct {
+		name      string
+		duration  float64
+		requested *float64
+		expected  float64
+		want      bool
+	}{
+		{"requested duration used", 10, &requested, 0, true},
+		{"requested overrides expected", 10, &requested, 10, true},
+		{"expected fallback", 4, nil, 10, true},
+		{"at ratio boundary", 5, nil, 10, false},
+		{"no reference duration", 1, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := goodResult(now)
+			r.Duration = tt.duration
+			r.RequestedDuration = tt.requested
+			opts := DefaultOptions()
+			opts.ExpectedDuration = tt.expected
+
+			got := hasFlag(Assess(r, opts, now), models.QualityFlagShortDuration)
+			if got != tt.want {
+				t.Errorf("short_duration flagged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssess_ZeroTransfer(t *testing.T) {
+	now := time.Now()
+	r := goodResult(now)
+	r.BytesTransferred = 0
+	r.MinBandwidth = 0
+
+	got := Assess(r, DefaultOptions(), now)
+	want := []models.QualityFlag{models.QualityFlagZeroBytes, models.QualityFlagZeroMinBandwidth}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flags = %v, want %v", got, want)
+	}
+}
+
+func TestAssess_ClockSkew(t *testing.T) {
+	now := time.Now()
+
+	future := goodResult(now)
+	future.Timestamp = now.Add(time.Hour)
+	if !hasFlag(Assess(future, DefaultOptions(), now), models.QualityFlagClockSkew) {
+		t.Error("expected clock_skew for result an hour in the future")
+	}
+
+	past := goodResult(now)
+	past.Timestamp = now.Add(-24 * time.Hour)
+	if hasFlag(Assess(past, DefaultOptions(), now), models.QualityFlagClockSkew) {
+		t.Error("historical results must not be flagged as clock skew")
+	}
+}
+
+func hasFlag(flags []models.QualityFlag, flag models.QualityFlag) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDerive(t *testing.T) {
+	retransmits, jitter := 50, 0.8
+	tcp := &models.TestResult{Protocol: models.ProtocolTCP, Duration: 10, BytesTransferred: 1e9, AvgBandwidth: 800e6, Retransmits: &retransmits}
+	Derive(tcp, 1e9)
+	if tcp.RetransmitsPerGB == nil || *tcp.RetransmitsPerGB != 50 {
+		t.Errorf("RetransmitsPerGB = %v, want 50", tcp.RetransmitsPerGB)
+	}
+	if tcp.GoodputRatio == nil || *tcp.GoodputRatio != 0.8 {
+		t.Errorf("GoodputRatio = %v, want 0.8", tcp.GoodputRatio)
+	}
+	if tcp.JitterRatio != nil {
+		t.Errorf("TCP JitterRatio = %v, want nil", *tcp.JitterRatio)
+	}
+
+	udp := &models.TestResult{Protocol: models.ProtocolUDP, Duration: 10, BytesTransferred: 125e6, AvgBandwidth: 100e6, Jitter: &jitter}
+	Derive(udp, 0)
+	if udp.JitterRatio == nil || *udp.JitterRatio != 0.008 {
+		t.Errorf("JitterRatio = %v, want 0.008", udp.JitterRatio)
+	}
+	// Without a link speed there is no goodput ratio
+	if udp.RetransmitsPerGB != nil || udp.GoodputRatio != nil {
+		t.Errorf("UDP derived = %v, %v, want nil", udp.RetransmitsPerGB, udp.GoodputRatio)
+	}
+
+	// Metrics the measurements no longer support are cleared
+	udp.Jitter = nil
+	Derive(udp, 0)
+	if udp.JitterRatio != nil {
+		t.Errorf("JitterRatio after jitter cleared = %v", *udp.JitterRatio)
+	}
+}
@@ -0,0 +1,700 @@
+// Package queue serialises test runs that compete for the single iPerf
+// server. Each job holds the server for one test; higher-priority jobs are
+// run first and preempt a running lower-priority job, which is requeued.
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/i18n"
+	"github.com/Tom-Oram/fak/backend/internal/ids"
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// exitWait bounds how long a job waits for the previous iperf process to
+// release the port before starting.
+const exitWait = 10 * time.Second
+
+// ErrJobNotFound is returned when cancelling a job that is not queued or running.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrCorrelationInUse is returned when enqueueing a job whose correlation ID
+// is already used by a job or result from the same source.
+var ErrCorrelationInUse = errors.New("correlation ID already in use")
+
+// MaxCorrelationIDLength bounds the correlation IDs callers may supply.
+const MaxCorrelationIDLength = 128
+
+// Runner controls the iPerf server. *iperf.Manager satisfies it.
+type Runner interface {
+	Start(cfg models.ServerConfig) error
+	Stop() error
+	GetStatus() models.ServerStatus
+	WaitExited(timeout time.Duration) bool
+}
+
+// Options configures queue behaviour.
+type Options struct {
+	// Priorities maps each job source to its priority; higher runs first
+	Priorities map[models.JobSource]int
+	// JobTimeout is how long a started job may wait for its test to finish
+	// when the job does not set its own timeout
+	JobTimeout time.Duration
+	// HistorySize is the number of finished jobs kept for introspection
+	HistorySize int
+	// NewID generates job IDs; nil uses random UUIDs
+	NewID ids.Generator
+	// Recorded reports whether a stored result already carries a source's
+	// correlation ID; nil only checks the jobs the queue knows of
+	Recorded func(source models.JobSource, correlationID string) (bool, error)
+	// Defer, if set, is asked before a scheduled job starts and may block,
+	// e.g. to measure the link. A non-empty reason holds the job back for
+	// DeferInterval; a job held back MaxDeferrals times fails at the next
+	// non-empty reason
+	Defer         func(job models.QueueJob) string
+	DeferInterval time.Duration
+	MaxDeferrals  int
+}
+
+// DefaultOptions returns Options with ad-hoc > CI > scheduled priorities.
+func DefaultOptions() Options {
+	return Options{
+		Priorities: map[models.JobSource]int{
+			models.JobSourceAdHoc:     30,
+			models.JobSourceCI:        20,
+			models.JobSourceScheduled: 10,
+		},
+		JobTimeout:    10 * time.Minute,
+		HistorySize:   50,
+		NewID:         ids.UUID,
+		DeferInterval: 5 * time.Minute,
+		MaxDeferrals:  12,
+	}
+}
+
+// ParsePriorities parses "source=priority" pairs separated by commas, e.g.
+// "adhoc=30,ci=20,scheduled=10". Sources not listed keep their defaults.
+func ParsePriorities(spec string) (map[models.JobSource]int, error) {
+	priorities := DefaultOptions().Priorities
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid priority %q: want source=priority", pair)
+		}
+		source := models.JobSource(strings.TrimSpace(name))
+		if _, known := priorities[source]; !known {
+			return nil, fmt.Errorf("unknown job source %q", source)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority for %s: %w", source, err)
+		}
+		priorities[source] = n
+	}
+	return priorities, nil
+}
+
+// stopReason records why the queue stopped the running job.
+type stopReason int
+
+const (
+	stopNone stopReason = iota
+	stopPreempt
+	stopTimeout
+	stopCancel
+)
+
+// entry is a job plus the bookkeeping needed to order and time it.
+type entry struct {
+	job   *models.QueueJob
+	seq   uint64
+	timer *time.Timer
+
+	// Last position and state sent to clients
+	published      bool
+	publishedState models.JobState
+}
+
+// Queue runs jobs one at a time on a Runner.
+type Queue struct {
+	runner Runner
+	notify func(models.WSMessage)
+	opts   Options
+
+	mu       sync.Mutex
+	pending  []*entry
+	running  *entry
+	stopping stopReason
+	history  []models.QueueJob
+	seq      uint64
+
+	kick      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a Queue. notify receives queue_position messages. Call Run to
+// start dispatching.
+func New(runner Runner, notify func(models.WSMessage), opts Options) *Queue {
+	defaults := DefaultOptions()
+	if opts.Priorities == nil {
+		opts.Priorities = defaults.Priorities
+	}
+	if opts.JobTimeout <= 0 {
+		opts.JobTimeout = defaults.JobTimeout
+	}
+	if opts.HistorySize <= 0 {
+		opts.HistorySize = defaults.HistorySize
+	}
+	if opts.NewID == nil {
+		opts.NewID = defaults.NewID
+	}
+	if opts.DeferInterval <= 0 {
+		opts.DeferInterval = defaults.DeferInterval
+	}
+	if opts.MaxDeferrals <= 0 {
+		opts.MaxDeferrals = defaults.MaxDeferrals
+	}
+
+	return &Queue{
+		runner: runner,
+		notify: notify,
+		opts:   opts,
+		kick:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Run dispatches jobs until Close is called. It should be run in a goroutine.
+func (q *Queue) Run() {
+	for {
+		select {
+		case <-q.kick:
+			q.dispatch()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// Close stops dispatching. Running and queued jobs are left as they are.
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
+
+// wake asks Run to dispatch the next job.
+func (q *Queue) wake() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// ValidateJob checks a job's source, timeout and correlation ID as Enqueue
+// does.
+func (q *Queue) ValidateJob(source models.JobSource, timeout int, correlationID string) error {
+	if err := q.validate(source, timeout, correlationID); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.checkCorrelationLocked(source, correlationID)
+}
+
+// validate checks the parts of a job that do not depend on other jobs.
+func (q *Queue) validate(source models.JobSource, timeout int, correlationID string) error {
+	if _, ok := q.opts.Priorities[source]; !ok {
+		return i18n.NewError("queue.invalid_source", i18n.Params{"source": source})
+	}
+	if timeout < 0 {
+		return i18n.NewError("queue.invalid_timeout", nil)
+	}
+	if !validCorrelationID(correlationID) {
+		return i18n.NewError("queue.invalid_correlation_id", i18n.Params{"max": MaxCorrelationIDLength})
+	}
+	return nil
+}
+
+// validCorrelationID reports whether id is empty or up to
+// MaxCorrelationIDLength printable characters without spaces.
+func validCorrelationID(id string) bool {
+	if len(id) > MaxCorrelationIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCorrelationLocked returns ErrCorrelationInUse if a queued or running
+// job, a finished job with a result, or a stored result already uses the
+// source's correlation ID.
+func (q *Queue) checkCorrelationLocked(source models.JobSource, correlationID string) error {
+	if correlationID == "" {
+		return nil
+	}
+	uses := func(job *models.QueueJob) bool {
+		return job.Source == source && job.CorrelationID == correlationID
+	}
+	if q.running != nil && uses(q.running.job) {
+		return ErrCorrelationInUse
+	}
+	for _, e := range q.pending {
+		if uses(e.job) {
+			return ErrCorrelationInUse
+		}
+	}
+	// Finished jobs without a result leave the ID free for a retry
+	for i := range q.history {
+		if uses(&q.history[i]) && q.history[i].ResultID != "" {
+			return ErrCorrelationInUse
+		}
+	}
+	if q.opts.Recorded != nil {
+		recorded, err := q.opts.Recorded(source, correlationID)
+		if err != nil {
+			return fmt.Errorf("checking correlation ID: %w", err)
+		}
+		if recorded {
+			return ErrCorrelationInUse
+		}
+	}
+	return nil
+}
+
+// Enqueue validates and queues a job. timeout is in seconds; zero uses the
+// queue default. correlationID, if set, is an external reference such as a
+// ticket number or CI run ID; it is copied to the job's result and must be
+// unique per source. A job that outranks the running job preempts it.
+func (q *Queue) Enqueue(source models.JobSource, cfg models.ServerConfig, timeout int, correlationID string) (*models.QueueJob, error) {
+	if err := q.validate(source, timeout, correlationID); err != nil {
+		return nil, err
+	}
+	priority := q.opts.Priorities[source]
+	if errs := iperf.ValidateConfig(cfg); len(errs) > 0 {
+		return nil, iperf.ConfigErrors(errs)
+	}
+	if timeout == 0 {
+		timeout = int(q.opts.JobTimeout / time.Second)
+	}
+
+	job := &models.QueueJob{
+		ID:            q.opts.NewID(),
+		Source:        source,
+		CorrelationID: correlationID,
+		Priority:      priority,
+		Config:        cfg,
+		State:         models.JobStateQueued,
+		Timeout:       timeout,
+		EnqueuedAt:    time.Now(),
+	}
+
+	q.mu.Lock()
+	if err := q.checkCorrelationLocked(source, correlationID); err != nil {
+		q.mu.Unlock()
+		return nil, err
+	}
+	q.seq++
+	q.insertLocked(&entry{job: job, seq: q.seq})
+
+	preempt := q.running != nil && q.stopping == stopNone && priority > q.running.job.Priority
+	if preempt {
+		q.stopping = stopPreempt
+	}
+	q.publishLocked()
+	snapshot := *job
+	q.mu.Unlock()
+
+	if preempt {
+		go q.runner.Stop()
+	}
+	q.wake()
+	return &snapshot, nil
+}
+
+// insertLocked adds e to pending, ordered by priority then submission order.
+func (q *Queue) insertLocked(e *entry) {
+	i := sort.Search(len(q.pending), func(i int) bool {
+		p := q.pending[i]
+		if p.job.Priority != e.job.Priority {
+			return p.job.Priority < e.job.Priority
+		}
+		return p.seq > e.seq
+	})
+	q.pending = append(q.pending, nil)
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = e
+}
+
+// Cancel removes a queued job or stops the running one.
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+
+	for i, e := range q.pending {
+		if e.job.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.finishLocked(e, models.JobStateCancelled, "")
+			q.publishLocked()
+			q.mu.Unlock()
+			return nil
+		}
+	}
+
+	if q.running != nil && q.running.job.ID == id {
+		if q.stopping == stopNone || q.stopping == stopPreempt {
+			q.stopping = stopCancel
+		}
+		q.mu.Unlock()
+		go q.runner.Stop()
+		return nil
+	}
+
+	q.mu.Unlock()
+	return ErrJobNotFound
+}
+
+// dispatch starts the next job if the server is free.
+func (q *Queue) dispatch() {
+	// Checked before taking q.mu: the runner calls HandleEvent while holding
+	// its own lock, so the queue never calls the runner with q.mu held
+	if q.runner.GetStatus() == models.ServerStatusRunning {
+		return
+	}
+
+	q.mu.Lock()
+	e := q.nextLocked()
+	if q.running != nil || e == nil {
+		q.mu.Unlock()
+		return
+	}
+	check := q.opts.Defer != nil && e.job.Source == models.JobSourceScheduled
+	job := *e.job
+	q.mu.Unlock()
+
+	if check {
+		if reason := q.opts.Defer(job); reason != "" {
+			q.deferJob(e, reason)
+			q.wake()
+			return
+		}
+	}
+
+	q.mu.Lock()
+	// Another job may have been queued ahead, or this one cancelled, while
+	// the job was checked
+	if q.running != nil || q.nextLocked() != e {
+		q.mu.Unlock()
+		q.wake()
+		return
+	}
+	q.removeLocked(e)
+	now := time.Now()
+	e.job.State = models.JobStateRunning
+	e.job.StartedAt = &now
+	e.job.DeferredUntil = nil
+	q.running = e
+	q.stopping = stopNone
+	q.publishLocked()
+	cfg := e.job.Config
+	q.mu.Unlock()
+
+	// A job is one test on one port; one-off mode keeps other clients off
+	// the server during it and ends the job when the test does
+	cfg.PortCount = 0
+	cfg.AutoRearm = false
+	if cfg.Version != models.IperfVersion2 {
+		cfg.OneOff = true
+	}
+
+	q.runner.WaitExited(exitWait)
+	err := q.runner.Start(cfg)
+	busy := err != nil && q.runner.GetStatus() == models.ServerStatusRunning
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The job may already have ended if the process exited straight away
+	if q.running != e {
+		return
+	}
+
+	switch {
+	case busy:
+		// Started outside the queue; wait for it to stop
+		q.requeueLocked(e, false)
+	case err != nil:
+		q.running = nil
+		q.finishLocked(e, models.JobStateFailed, err.Error())
+		q.wake()
+	default:
+		e.timer = time.AfterFunc(time.Duration(e.job.Timeout)*time.Second, func() { q.expire(e) })
+		// A preempting job arrived while starting; its Stop may have run first
+		if q.stopping == stopPreempt {
+			go q.runner.Stop()
+		}
+	}
+	q.publishLocked()
+}
+
+// nextLocked returns the first queued job that is not deferred, or nil.
+func (q *Queue) nextLocked() *entry {
+	now := time.Now()
+	for _, e := range q.pending {
+		if e.job.DeferredUntil == nil || !now.Before(*e.job.DeferredUntil) {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeLocked takes e out of pending and reports whether it was there.
+func (q *Queue) removeLocked(e *entry) bool {
+	for i, p := range q.pending {
+		if p == e {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// deferJob holds a queued job back for DeferInterval, or fails it once it
+// has been deferred MaxDeferrals times.
+func (q *Queue) deferJob(e *entry, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Cancelled while it was checked
+	if !q.removeLocked(e) {
+		return
+	}
+	e.job.DeferReason = reason
+	if e.job.Deferrals >= q.opts.MaxDeferrals {
+		q.finishLocked(e, models.JobStateFailed,
+			fmt.Sprintf("deferred %d times: %s", e.job.Deferrals, reason))
+		q.publishLocked()
+		return
+	}
+
+	until := time.Now().Add(q.opts.DeferInterval)
+	e.job.Deferrals++
+	e.job.State = models.JobStateDeferred
+	e.job.DeferredUntil = &until
+	e.timer = time.AfterFunc(q.opts.DeferInterval, q.wake)
+	q.insertLocked(e)
+	q.publishLocked()
+}
+
+// expire stops a job that has held the server past its timeout.
+func (q *Queue) expire(e *entry) {
+	q.mu.Lock()
+	if q.running != e || q.stopping != stopNone {
+		q.mu.Unlock()
+		return
+	}
+	q.stopping = stopTimeout
+	q.mu.Unlock()
+
+	q.runner.Stop()
+}
+
+// Attribute copies the running job's source and correlation ID to a result
+// before it is saved. Only the job's first result is attributed.
+func (q *Queue) Attribute(result *models.TestResult) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := q.running
+	if e == nil || e.job.State != models.JobStateRunning || e.job.ResultID != "" {
+		return
+	}
+	result.Source = e.job.Source
+	result.CorrelationID = e.job.CorrelationID
+}
+
+// HandleEvent tracks the running job from manager events. Call it after test
+// results have been saved so ResultID is known.
+func (q *Queue) HandleEvent(msg models.WSMessage) {
+	switch msg.Type {
+	case models.WSMessageTypeTestComplete:
+		result, ok := msg.Payload.(*models.TestResult)
+		if !ok {
+			return
+		}
+		q.mu.Lock()
+		e := q.running
+		if e != nil && e.job.State == models.JobStateRunning && e.job.ResultID == "" {
+			e.job.ResultID = result.ID
+			if result.Status != models.TestStatusCompleted {
+				e.job.Error = result.ErrorMessage
+			}
+		}
+		stopNow := e != nil && e.job.Config.Version == models.IperfVersion2
+		q.mu.Unlock()
+
+		// iperf2 has no one-off mode, so end the job explicitly
+		if stopNow {
+			go q.runner.Stop()
+		}
+
+	case models.WSMessageTypeServerStatus:
+		status, ok := msg.Payload.(models.ServerStatusPayload)
+		if !ok || status.Status == models.ServerStatusRunning {
+			return
+		}
+		q.mu.Lock()
+		q.endRunningLocked(status)
+		q.mu.Unlock()
+		q.wake()
+	}
+}
+
+// endRunningLocked settles the running job once the server has stopped.
+func (q *Queue) endRunningLocked(status models.ServerStatusPayload) {
+	e := q.running
+	if e == nil || e.job.State != models.JobStateRunning {
+		return
+	}
+	reason := q.stopping
+	q.stopping = stopNone
+
+	switch {
+	case e.job.ResultID != "" && reason != stopCancel:
+		q.running = nil
+		if e.job.Error != "" {
+			q.finishLocked(e, models.JobStateFailed, e.job.Error)
+		} else {
+			q.finishLocked(e, models.JobStateCompleted, "")
+		}
+	case reason == stopPreempt:
+		q.requeueLocked(e, true)
+	case reason == stopCancel:
+		q.running = nil
+		q.finishLocked(e, models.JobStateCancelled, "")
+	case reason == stopTimeout:
+		q.running = nil
+		q.finishLocked(e, models.JobStateFailed,
+			fmt.Sprintf("no test completed within %ds", e.job.Timeout))
+	default:
+		msg := "server stopped before a test completed"
+		if status.Status == models.ServerStatusError {
+			msg = "server entered error state before a test completed"
+			if status.ErrorMsg != "" {
+				msg += ": " + status.ErrorMsg
+			}
+		}
+		q.running = nil
+		q.finishLocked(e, models.JobStateFailed, msg)
+	}
+	q.publishLocked()
+}
+
+// requeueLocked returns the running job to the queue, keeping its original
+// place among jobs of the same priority.
+func (q *Queue) requeueLocked(e *entry, preempted bool) {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.job.State = models.JobStateQueued
+	e.job.StartedAt = nil
+	e.job.ResultID = ""
+	e.job.Error = ""
+	if preempted {
+		e.job.Preemptions++
+	}
+	q.running = nil
+	q.insertLocked(e)
+}
+
+// finishLocked records a job's final state and moves it to history.
+func (q *Queue) finishLocked(e *entry, state models.JobState, errMsg string) {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	now := time.Now()
+	e.job.State = state
+	e.job.Error = errMsg
+	e.job.FinishedAt = &now
+	e.job.Position = 0
+
+	q.history = append([]models.QueueJob{*e.job}, q.history...)
+	if len(q.history) > q.opts.HistorySize {
+		q.history = q.history[:q.opts.HistorySize]
+	}
+	q.notify(models.WSMessage{
+		Type:    models.WSMessageTypeQueuePosition,
+		Payload: models.QueuePosition{JobID: e.job.ID, State: state},
+	})
+}
+
+// publishLocked renumbers jobs and sends a queue_position message for each
+// job whose position or state changed.
+func (q *Queue) publishLocked() {
+	if q.running != nil {
+		q.publishEntryLocked(q.running, 0)
+	}
+	for i, e := range q.pending {
+		q.publishEntryLocked(e, i+1)
+	}
+}
+
+// publishEntryLocked sets e's position and notifies if it changed.
+func (q *Queue) publishEntryLocked(e *entry, position int) {
+	if e.published && e.job.Position == position && e.publishedState == e.job.State {
+		return
+	}
+	e.job.Position = position
+	e.published = true
+	e.publishedState = e.job.State
+	q.notify(models.WSMessage{
+		Type:    models.WSMessageTypeQueuePosition,
+		Payload: models.QueuePosition{JobID: e.job.ID, State: e.job.State, Position: position},
+	})
+}
+
+// Snapshot is the state of the queue for introspection.
+type Snapshot struct {
+	Running *models.QueueJob  `json:"running"`
+	Pending []models.QueueJob `json:"pending"`
+	Recent  []models.QueueJob `json:"recent"`
+	// Priorities lists the priority of each job source
+	Priorities map[models.JobSource]int `json:"priorities"`
+}
+
+// Snapshot returns copies of the running, queued and recently finished jobs.
+func (q *Queue) Snapshot() Snapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := Snapshot{
+		Pending:    make([]models.QueueJob, len(q.pending)),
+		Recent:     append([]models.QueueJob{}, q.history...),
+		Priorities: make(map[models.JobSource]int, len(q.opts.Priorities)),
+	}
+	if q.running != nil {
+		job := *q.running.job
+		s.Running = &job
+	}
+	for i, e := range q.pending {
+		s.Pending[i] = *e.job
+	}
+	for k, v := range q.opts.Priorities {
+		s.Priorities[k] = v
+	}
+	return s
+}
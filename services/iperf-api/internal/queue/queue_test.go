@@ -0,0 +1,474 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// fakeRunner mimics iperf.Manager: Start and Stop report status changes
+// through the queue's HandleEvent, as the API server's event handler does.
+type fakeRunner struct {
+	mu     sync.Mutex
+	status models.ServerStatus
+	starts []models.ServerConfig
+	q      *Queue
+}
+
+func (f *fakeRunner) Start(cfg models.ServerConfig) error {
+	f.mu.Lock()
+	if f.status == models.ServerStatusRunning {
+		f.mu.Unlock()
+		return errors.New("server is already running")
+	}
+	f.status = models.ServerStatusRunning
+	f.starts = append(f.starts, cfg)
+	f.mu.Unlock()
+
+	f.q.HandleEvent(statusMessage(models.ServerStatusRunning))
+	return nil
+}
+
+func (f *fakeRunner) Stop() error {
+	f.mu.Lock()
+	if f.status != models.ServerStatusRunning {
+		f.mu.Unlock()
+		return errors.New("server is not running")
+	}
+	f.status = models.ServerStatusStopped
+	f.mu.Unlock()
+
+	f.q.HandleEvent(statusMessage(models.ServerStatusStopped))
+	return nil
+}
+
+func (f *fakeRunner) GetStatus() models.ServerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *fakeRunner) WaitExited(time.Duration) bool { return true }
+
+// completeTest simulates a one-off iperf3 server finishing a test and exiting.
+func (f *fakeRunner) completeTest(resultID string) {
+	f.q.HandleEvent(models.WSMessage{
+		Type:    models.WSMessageTypeTestComplete,
+		Payload: &models.TestResult{ID: resultID, Status: models.TestStatusCompleted},
+	})
+	f.mu.Lock()
+	f.status = models.ServerStatusStopped
+	f.mu.Unlock()
+	f.q.HandleEvent(statusMessage(models.ServerStatusStopped))
+}
+
+func (f *fakeRunner) startCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.starts)
+}
+
+func statusMessage(status models.ServerStatus) models.WSMessage {
+	return models.WSMessage{
+		Type:    models.WSMessageTypeServerStatus,
+		Payload: models.ServerStatusPayload{Status: status},
+	}
+}
+
+type positionRecorder struct {
+	mu        sync.Mutex
+	positions []models.QueuePosition
+}
+
+func (r *positionRecorder) notify(msg models.WSMessage) {
+	if p, ok := msg.Payload.(models.QueuePosition); ok {
+		r.mu.Lock()
+		r.positions = append(r.positions, p)
+		r.mu.Unlock()
+	}
+}
+
+func (r *positionRecorder) saw(want models.QueuePosition) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.positions {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestQueue(t *testing.T, opts Options) (*Queue, *fakeRunner, *positionRecorder) {
+	t.Helper()
+	runner := &fakeRunner{status: models.ServerStatusStopped}
+	rec := &positionRecorder{}
+	q := New(runner, rec.notify, opts)
+	runner.q = q
+	go q.Run()
+	t.Cleanup(q.Close)
+	return q, runner, rec
+}
+
+// findJob returns the job with id from a snapshot.
+func findJob(s Snapshot, id string) (models.QueueJob, bool) {
+	if s.Running != nil && s.Running.ID == id {
+		return *s.Running, true
+	}
+	for _, jobs := range [][]models.QueueJob{s.Pending, s.Recent} {
+		for _, j := range jobs {
+			if j.ID == id {
+				return j, true
+			}
+		}
+	}
+	return models.QueueJob{}, false
+}
+
+func waitForState(t *testing.T, q *Queue, id string, state models.JobState) models.QueueJob {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := findJob(q.Snapshot(), id); ok && job.State == state {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	job, _ := findJob(q.Snapshot(), id)
+	t.Fatalf("job %s: state %q, want %q", id, job.State, state)
+	return job
+}
+
+func enqueue(t *testing.T, q *Queue, source models.JobSource, timeout int) *models.QueueJob {
+	t.Helper()
+	job, err := q.Enqueue(source, models.DefaultServerConfig(), timeout, "")
+	if err != nil {
+		t.Fatalf("Enqueue(%s): %v", source, err)
+	}
+	return job
+}
+
+func TestQueue_OrdersByPriority(t *testing.T) {
+	q, runner, rec := newTestQueue(t, DefaultOptions())
+
+	// A server started outside the queue holds the port
+	runner.status = models.ServerStatusRunning
+
+	scheduled := enqueue(t, q, models.JobSourceScheduled, 0)
+	ci := enqueue(t, q, models.JobSourceCI, 0)
+	adhoc := enqueue(t, q, models.JobSourceAdHoc, 0)
+
+	pending := q.Snapshot().Pending
+	if len(pending) != 3 || pending[0].ID != adhoc.ID || pending[1].ID != ci.ID || pending[2].ID != scheduled.ID {
+		t.Fatalf("pending order = %+v, want adhoc, ci, scheduled", pending)
+	}
+	if !rec.saw(models.QueuePosition{JobID: scheduled.ID, State: models.JobStateQueued, Position: 3}) {
+		t.Error("no queue_position event moving scheduled job to position 3")
+	}
+
+	runner.Stop()
+	waitForState(t, q, adhoc.ID, models.JobStateRunning)
+	if !runner.starts[0].OneOff {
+		t.Error("queued jobs should run iperf3 in one-off mode")
+	}
+
+	runner.completeTest("r1")
+	job := waitForState(t, q, adhoc.ID, models.JobStateCompleted)
+	if job.ResultID != "r1" {
+		t.Errorf("ResultID = %q, want r1", job.ResultID)
+	}
+	waitForState(t, q, ci.ID, models.JobStateRunning)
+	if !rec.saw(models.QueuePosition{JobID: scheduled.ID, State: models.JobStateQueued, Position: 1}) {
+		t.Error("no queue_position event moving scheduled job to position 1")
+	}
+}
+
+func TestQueue_PreemptsLowerPriority(t *testing.T) {
+	q, runner, _ := newTestQueue(t, DefaultOptions())
+
+	scheduled := enqueue(t, q, models.JobSourceScheduled, 0)
+	waitForState(t, q, scheduled.ID, models.JobStateRunning)
+
+	// Same priority does not preempt
+	other := enqueue(t, q, models.JobSourceScheduled, 0)
+	adhoc := enqueue(t, q, models.JobSourceAdHoc, 0)
+
+	waitForState(t, q, adhoc.ID, models.JobStateRunning)
+	job := waitForState(t, q, scheduled.ID, models.JobStateQueued)
+	if job.Preemptions != 1 || job.Position != 1 {
+		t.Errorf("preempted job = %+v, want 1 preemption at position 1", job)
+	}
+
+	runner.completeTest("r-adhoc")
+	waitForState(t, q, adhoc.ID, models.JobStateCompleted)
+
+	// The preempted job keeps its place ahead of later jobs of its priority
+	waitForState(t, q, scheduled.ID, models.JobStateRunning)
+	if job, _ := findJob(q.Snapshot(), other.ID); job.Position != 1 {
+		t.Errorf("later scheduled job position = %d, want 1", job.Position)
+	}
+	if runner.startCount() != 3 {
+		t.Errorf("runner started %d times, want 3", runner.startCount())
+	}
+}
+
+func TestQueue_TimesOutWithoutTest(t *testing.T) {
+	q, _, _ := newTestQueue(t, DefaultOptions())
+
+	job := enqueue(t, q, models.JobSourceCI, 1)
+	waitForState(t, q, job.ID, models.JobStateRunning)
+
+	done := waitForState(t, q, job.ID, models.JobStateFailed)
+	if done.Error != "no test completed within 1s" {
+		t.Errorf("Error = %q", done.Error)
+	}
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	q, runner, _ := newTestQueue(t, DefaultOptions())
+
+	running := enqueue(t, q, models.JobSourceAdHoc, 0)
+	waitForState(t, q, running.ID, models.JobStateRunning)
+	queued := enqueue(t, q, models.JobSourceCI, 0)
+
+	if err := q.Cancel(queued.ID); err != nil {
+		t.Fatalf("Cancel queued: %v", err)
+	}
+	waitForState(t, q, queued.ID, models.JobStateCancelled)
+
+	if err := q.Cancel(running.ID); err != nil {
+		t.Fatalf("Cancel running: %v", err)
+	}
+	waitForState(t, q, running.ID, models.JobStateCancelled)
+	if runner.GetStatus() != models.ServerStatusStopped {
+		t.Error("cancelling the running job should stop the server")
+	}
+
+	if err := q.Cancel(running.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel finished job err = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestQueue_ManualStopFailsRunningJob(t *testing.T) {
+	q, runner, _ := newTestQueue(t, DefaultOptions())
+
+	job := enqueue(t, q, models.JobSourceAdHoc, 0)
+	waitForState(t, q, job.ID, models.JobStateRunning)
+
+	runner.Stop()
+	done := waitForState(t, q, job.ID, models.JobStateFailed)
+	if done.Error != "server stopped before a test completed" {
+		t.Errorf("Error = %q", done.Error)
+	}
+}
+
+func TestEnqueue_Validation(t *testing.T) {
+	q, _, _ := newTestQueue(t, DefaultOptions())
+
+	if _, err := q.Enqueue("cron", models.DefaultServerConfig(), 0, ""); err == nil {
+		t.Error("unknown source accepted")
+	}
+	cfg := models.DefaultServerConfig()
+	cfg.Port = 0
+	if _, err := q.Enqueue(models.JobSourceCI, cfg, 0, ""); err == nil {
+		t.Error("invalid config accepted")
+	}
+	if _, err := q.Enqueue(models.JobSourceCI, models.DefaultServerConfig(), -1, ""); err == nil {
+		t.Error("negative timeout accepted")
+	}
+	if _, err := q.Enqueue(models.JobSourceCI, models.DefaultServerConfig(), 0, "run 42"); err == nil {
+		t.Error("correlation ID with a space accepted")
+	}
+}
+
+func TestQueue_CorrelationIDs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.NewID = func() string { return "job-1" }
+	opts.Recorded = func(source models.JobSource, id string) (bool, error) {
+		return source == models.JobSourceCI && id == "stored", nil
+	}
+	q, runner, _ := newTestQueue(t, opts)
+
+	job, err := q.Enqueue(models.JobSourceCI, models.DefaultServerConfig(), 0, "run-42")
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if job.ID != "job-1" || job.CorrelationID != "run-42" {
+		t.Errorf("job = %+v, want the generated ID and correlation ID", job)
+	}
+	waitForState(t, q, job.ID, models.JobStateRunning)
+
+	// Unique per source, across jobs and stored results
+	if _, err := q.Enqueue(models.JobSourceCI, models.DefaultServerConfig(), 0, "run-42"); !errors.Is(err, ErrCorrelationInUse) {
+		t.Errorf("duplicate of a running job: err = %v, want ErrCorrelationInUse", err)
+	}
+	if err := q.ValidateJob(models.JobSourceCI, 0, "stored"); !errors.Is(err, ErrCorrelationInUse) {
+		t.Errorf("duplicate of a stored result: err = %v, want ErrCorrelationInUse", err)
+	}
+	if err := q.ValidateJob(models.JobSourceScheduled, 0, "run-42"); err != nil {
+		t.Errorf("same ID from another source: %v", err)
+	}
+
+	// The running job's result carries its source and correlation ID
+	result := &models.TestResult{ID: "r1", Status: models.TestStatusCompleted}
+	q.Attribute(result)
+	if result.Source != models.JobSourceCI || result.CorrelationID != "run-42" {
+		t.Errorf("result = %+v, want it attributed to the job", result)
+	}
+	runner.completeTest("r1")
+	waitForState(t, q, job.ID, models.JobStateCompleted)
+
+	if err := q.ValidateJob(models.JobSourceCI, 0, "run-42"); !errors.Is(err, ErrCorrelationInUse) {
+		t.Errorf("duplicate of a finished job: err = %v, want ErrCorrelationInUse", err)
+	}
+
+	// Results outside a job are left alone
+	other := &models.TestResult{ID: "r2"}
+	q.Attribute(other)
+	if other.Source != "" || other.CorrelationID != "" {
+		t.Errorf("result = %+v, want no job attribution", other)
+	}
+}
+
+func TestQueue_CorrelationIDFreeAfterFailure(t *testing.T) {
+	q, runner, _ := newTestQueue(t, DefaultOptions())
+
+	job, err := q.Enqueue(models.JobSourceCI, models.DefaultServerConfig(), 0, "run-7")
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitForState(t, q, job.ID, models.JobStateRunning)
+	runner.Stop()
+	waitForState(t, q, job.ID, models.JobStateFailed)
+
+	// A job that produced no result can be retried under the same ID
+	if _, err := q.Enqueue(models.JobSourceCI, models.DefaultServerConfig(), 0, "run-7"); err != nil {
+		t.Errorf("retry after failure: %v", err)
+	}
+}
+
+func TestParsePriorities(t *testing.T) {
+	got, err := ParsePriorities("scheduled=40, ci=5")
+	if err != nil {
+		t.Fatalf("ParsePriorities: %v", err)
+	}
+	if got[models.JobSourceScheduled] != 40 || got[models.JobSourceCI] != 5 || got[models.JobSourceAdHoc] != 30 {
+		t.Errorf("priorities = %v", got)
+	}
+
+	for _, bad := range []string{"cron=1", "ci", "ci=high"} {
+		if _, err := ParsePriorities(bad); err == nil {
+			t.Errorf("ParsePriorities(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestQueue_JobsRunOnOnePort(t *testing.T) {
+	q, runner, _ := newTestQueue(t, DefaultOptions())
+
+	cfg := models.DefaultServerConfig()
+	cfg.PortCount = 5
+	job, err := q.Enqueue(models.JobSourceCI, cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitForState(t, q, job.ID, models.JobStateRunning)
+
+	if got := runner.starts[0]; got.PortCount != 0 || !got.OneOff {
+		t.Errorf("job started with portCount %d, oneOff %v; want a single one-off listener", got.PortCount, got.OneOff)
+	}
+}
+
+func TestQueue_JobsDoNotRearm(t *testing.T) {
+	q, runner, _ := newTestQueue(t, DefaultOptions())
+
+	cfg := models.DefaultServerConfig()
+	cfg.OneOff = true
+	cfg.AutoRearm = true
+	job, err := q.Enqueue(models.JobSourceCI, cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitForState(t, q, job.ID, models.JobStateRunning)
+
+	if got := runner.starts[0]; got.AutoRearm {
+		t.Error("job started with auto-rearm; it would never end")
+	}
+}
+
+func TestQueue_DefersScheduledJobs(t *testing.T) {
+	var mu sync.Mutex
+	reason := "link 92% busy"
+	checked := map[models.JobSource]int{}
+	opts := DefaultOptions()
+	opts.DeferInterval = 20 * time.Millisecond
+	opts.MaxDeferrals = 100
+	opts.Defer = func(job models.QueueJob) string {
+		mu.Lock()
+		defer mu.Unlock()
+		checked[job.Source]++
+		return reason
+	}
+	q, runner, rec := newTestQueue(t, opts)
+
+	scheduled := enqueue(t, q, models.JobSourceScheduled, 0)
+	job := waitForState(t, q, scheduled.ID, models.JobStateDeferred)
+	if job.DeferReason != reason || job.DeferredUntil == nil || job.Deferrals < 1 {
+		t.Errorf("deferred job = %+v", job)
+	}
+	if !rec.saw(models.QueuePosition{JobID: scheduled.ID, State: models.JobStateDeferred, Position: 1}) {
+		t.Error("no queue_position event for the deferral")
+	}
+
+	// Other sources are not held back, and run ahead of a deferred job
+	adhoc := enqueue(t, q, models.JobSourceAdHoc, 0)
+	waitForState(t, q, adhoc.ID, models.JobStateRunning)
+	runner.completeTest("r-adhoc")
+	waitForState(t, q, adhoc.ID, models.JobStateCompleted)
+
+	mu.Lock()
+	reason = ""
+	if checked[models.JobSourceAdHoc] != 0 {
+		t.Errorf("ad-hoc job was checked %d times", checked[models.JobSourceAdHoc])
+	}
+	mu.Unlock()
+
+	job = waitForState(t, q, scheduled.ID, models.JobStateRunning)
+	if job.DeferredUntil != nil || job.Deferrals < 1 {
+		t.Errorf("started job = %+v, want the deferrals kept and no deferral pending", job)
+	}
+}
+
+func TestQueue_FailsAfterMaxDeferrals(t *testing.T) {
+	opts := DefaultOptions()
+	opts.DeferInterval = 10 * time.Millisecond
+	opts.MaxDeferrals = 2
+	opts.Defer = func(models.QueueJob) string { return "link busy" }
+	q, runner, _ := newTestQueue(t, opts)
+
+	job := enqueue(t, q, models.JobSourceScheduled, 0)
+	done := waitForState(t, q, job.ID, models.JobStateFailed)
+	if done.Deferrals != 2 || done.Error != "deferred 2 times: link busy" {
+		t.Errorf("failed job = %+v", done)
+	}
+	if runner.startCount() != 0 {
+		t.Error("a job deferred too often should never start")
+	}
+}
+
+func TestQueue_CancelDeferredJob(t *testing.T) {
+	opts := DefaultOptions()
+	opts.DeferInterval = time.Hour
+	opts.Defer = func(models.QueueJob) string { return "link busy" }
+	q, _, _ := newTestQueue(t, opts)
+
+	job := enqueue(t, q, models.JobSourceScheduled, 0)
+	waitForState(t, q, job.ID, models.JobStateDeferred)
+	if err := q.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	waitForState(t, q, job.ID, models.JobStateCancelled)
+}
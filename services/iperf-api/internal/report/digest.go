@@ -0,0 +1,175 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Digest is a scheduled summary of a period: its totals and how each
+// client's bandwidth compares with the period before.
+type Digest struct {
+	Period    Period    `json:"period"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Generated time.Time `json:"generated"`
+	Node      string    `json:"node,omitempty"`
+
+	Tests        int     `json:"tests"`
+	Completed    int     `json:"completed"`
+	Failed       int     `json:"failed"`
+	Aborted      int     `json:"aborted"`
+	Bytes        int64   `json:"bytes"`
+	AvgBandwidth float64 `json:"avgBandwidth"`
+	// Jitter and PacketLoss average the UDP tests that reported them
+	Jitter     *float64 `json:"jitter,omitempty"`
+	PacketLoss *float64 `json:"packetLoss,omitempty"`
+
+	Clients []ClientTrend `json:"clients"`
+}
+
+// ClientTrend is one client's tests in a digest. Change is the percentage
+// change in average bandwidth from the previous period, nil if the client
+// completed no tests in either.
+type ClientTrend struct {
+	ClientIP             string   `json:"clientIp"`
+	Tests                int      `json:"tests"`
+	AvgBandwidth         float64  `json:"avgBandwidth"`
+	PreviousAvgBandwidth *float64 `json:"previousAvgBandwidth,omitempty"`
+	Change               *float64 `json:"change,omitempty"`
+	// Degraded is set when bandwidth fell by at least the configured
+	// degradation percentage
+	Degraded bool `json:"degraded"`
+}
+
+// BuildDigest summarises the results of [from, to) and compares each
+// client with its results of the previous period. Clients are listed by
+// change, the worst first, then those with nothing to compare.
+func BuildDigest(period Period, from, to time.Time, current, previous []models.TestResult, degradation float64) Digest {
+	summary := Summarize(current)
+	d := Digest{
+		Period:       period,
+		From:         from,
+		To:           to,
+		Tests:        summary.Tests,
+		Completed:    summary.Completed,
+		Failed:       summary.Failed,
+		Aborted:      summary.Aborted,
+		Bytes:        summary.Bytes,
+		AvgBandwidth: summary.AvgBandwidth,
+		Jitter:       summary.Jitter,
+		PacketLoss:   summary.PacketLoss,
+		Clients:      []ClientTrend{},
+	}
+
+	before := make(map[string]Summary)
+	for ip, results := range byClient(previous) {
+		before[ip] = Summarize(results)
+	}
+	for ip, results := range byClient(current) {
+		now := Summarize(results)
+		trend := ClientTrend{ClientIP: ip, Tests: now.Tests, AvgBandwidth: now.AvgBandwidth}
+		if prev, ok := before[ip]; ok && prev.Completed > 0 {
+			avg := prev.AvgBandwidth
+			trend.PreviousAvgBandwidth = &avg
+			if now.Completed > 0 && avg > 0 {
+				change := (now.AvgBandwidth - avg) / avg * 100
+				trend.Change = &change
+				trend.Degraded = change <= -degradation
+			}
+		}
+		d.Clients = append(d.Clients, trend)
+	}
+
+	sort.Slice(d.Clients, func(i, j int) bool {
+		a, b := d.Clients[i], d.Clients[j]
+		if (a.Change == nil) != (b.Change == nil) {
+			return a.Change != nil
+		}
+		if a.Change != nil && *a.Change != *b.Change {
+			return *a.Change < *b.Change
+		}
+		return a.ClientIP < b.ClientIP
+	})
+	return d
+}
+
+func byClient(results []models.TestResult) map[string][]models.TestResult {
+	out := make(map[string][]models.TestResult)
+	for _, r := range results {
+		out[r.ClientIP] = append(out[r.ClientIP], r)
+	}
+	return out
+}
+
+// Degraded returns the clients whose bandwidth fell.
+func (d Digest) Degraded() []ClientTrend {
+	var out []ClientTrend
+	for _, c := range d.Clients {
+		if c.Degraded {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Subject is the digest's email subject.
+func (d Digest) Subject() string {
+	subject := fmt.Sprintf("[iPerf] %s report %s to %s", title(d.Period), d.From.Format("2006-01-02"), d.To.Format("2006-01-02"))
+	if n := len(d.Degraded()); n > 0 {
+		subject += fmt.Sprintf(": %d degraded", n)
+	}
+	return subject
+}
+
+// Text is the digest as a plain-text email body.
+func (d Digest) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s report for %s to %s", title(d.Period), d.From.Format("2006-01-02 15:04 MST"), d.To.Format("2006-01-02 15:04 MST"))
+	if d.Node != "" {
+		fmt.Fprintf(&b, " on %s", d.Node)
+	}
+	b.WriteString("\n\n")
+
+	fmt.Fprintf(&b, "Tests:             %d (%d completed, %d failed, %d aborted)\n", d.Tests, d.Completed, d.Failed, d.Aborted)
+	fmt.Fprintf(&b, "Clients:           %d\n", len(d.Clients))
+	if d.Completed > 0 {
+		fmt.Fprintf(&b, "Data transferred:  %s\n", FormatBytes(d.Bytes))
+		fmt.Fprintf(&b, "Average bandwidth: %s\n", FormatBandwidth(d.AvgBandwidth))
+	}
+	if d.Jitter != nil {
+		fmt.Fprintf(&b, "Average jitter:    %.3f ms\n", *d.Jitter)
+	}
+	if d.PacketLoss != nil {
+		fmt.Fprintf(&b, "Average loss:      %.2f %%\n", *d.PacketLoss)
+	}
+
+	if degraded := d.Degraded(); len(degraded) > 0 {
+		b.WriteString("\nDegraded clients:\n")
+		for _, c := range degraded {
+			fmt.Fprintf(&b, "  - %s: %s, down %.1f%% from %s\n", c.ClientIP, FormatBandwidth(c.AvgBandwidth), -*c.Change, FormatBandwidth(*c.PreviousAvgBandwidth))
+		}
+	}
+	if len(d.Clients) > 0 {
+		b.WriteString("\nClients:\n")
+		for _, c := range d.Clients {
+			fmt.Fprintf(&b, "  - %s: %d tests, %s", c.ClientIP, c.Tests, FormatBandwidth(c.AvgBandwidth))
+			if c.Change != nil {
+				fmt.Fprintf(&b, " (%+.1f%%)", *c.Change)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// title capitalises a period for headings.
+func title(p Period) string {
+	if p == "" {
+		return ""
+	}
+	return strings.ToUpper(string(p[:1])) + string(p[1:])
+}
@@ -0,0 +1,244 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Page layout, in millimetres on A4 portrait.
+const (
+	margin      = 15.0
+	pageWidth   = 210.0
+	chartHeight = 55.0
+	histBins    = 10
+)
+
+// WritePDF renders the report: the period, the summary, a chart of each
+// test's bandwidth over time and their distribution, and the fastest and
+// slowest tests.
+func WritePDF(w io.Writer, rep Report) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(true, margin)
+	pdf.SetCreationDate(rep.Generated)
+	pdf.SetTitle("iperf link report", true)
+	pdf.SetCreator("iperf-api", true)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, "Link Test Report", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	period := fmt.Sprintf("%s to %s (UTC)", rep.From.UTC().Format("2006-01-02 15:04"), rep.To.UTC().Format("2006-01-02 15:04"))
+	line(pdf, "Period", period)
+	if rep.ClientIP != "" {
+		line(pdf, "Client", rep.ClientIP)
+	}
+	if rep.Node != "" {
+		line(pdf, "Node", tr(rep.Node))
+	}
+	line(pdf, "Generated", rep.Generated.UTC().Format(time.RFC3339))
+	pdf.Ln(4)
+
+	summary := Summarize(rep.Results)
+	heading(pdf, "Summary")
+	line(pdf, "Tests", fmt.Sprintf("%d (%d completed, %d failed, %d aborted)", summary.Tests, summary.Completed, summary.Failed, summary.Aborted))
+	line(pdf, "Clients", fmt.Sprint(summary.Clients))
+	if summary.Completed == 0 {
+		pdf.Ln(2)
+		pdf.CellFormat(0, 6, "No completed tests in this period.", "", 1, "L", false, 0, "")
+		return pdf.Output(w)
+	}
+	line(pdf, "Data transferred", FormatBytes(summary.Bytes))
+	line(pdf, "Average bandwidth", FormatBandwidth(summary.AvgBandwidth))
+	line(pdf, "Median bandwidth", FormatBandwidth(summary.MedianBandwidth))
+	line(pdf, "Range", FormatBandwidth(summary.MinBandwidth)+" to "+FormatBandwidth(summary.MaxBandwidth))
+	line(pdf, "Retransmits", fmt.Sprint(summary.Retransmits))
+	if summary.Jitter != nil {
+		line(pdf, "Average jitter", fmt.Sprintf("%.3f ms", *summary.Jitter))
+	}
+	if summary.PacketLoss != nil {
+		line(pdf, "Average packet loss", fmt.Sprintf("%.2f %%", *summary.PacketLoss))
+	}
+	pdf.Ln(4)
+
+	heading(pdf, "Bandwidth over time")
+	timeChart(pdf, rep)
+	heading(pdf, "Bandwidth distribution")
+	histogram(pdf, rep.Results, summary)
+
+	top, bottom := Ranked(rep.Results, RankedTests)
+	heading(pdf, "Fastest tests")
+	table(pdf, tr, top)
+	heading(pdf, "Slowest tests")
+	table(pdf, tr, bottom)
+
+	return pdf.Output(w)
+}
+
+func heading(pdf *gofpdf.Fpdf, text string) {
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.CellFormat(0, 8, text, "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+}
+
+func line(pdf *gofpdf.Fpdf, label, value string) {
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(45, 6, label, "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, value, "", 1, "L", false, 0, "")
+}
+
+// completed returns the tests a chart plots.
+func completed(results []models.TestResult) []models.TestResult {
+	var out []models.TestResult
+	for _, r := range results {
+		if r.Status != models.TestStatusFailed && r.Status != models.TestStatusAborted {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// chartArea reserves space for a chart below the cursor, starting a new
+// page if it does not fit, and draws its frame.
+func chartArea(pdf *gofpdf.Fpdf) (x, y, w, h float64) {
+	_, pageHeight := pdf.GetPageSize()
+	if pdf.GetY()+chartHeight+10 > pageHeight-margin {
+		pdf.AddPage()
+	}
+	x, y = margin+18, pdf.GetY()+2
+	w, h = pageWidth-2*margin-18, chartHeight
+	pdf.SetDrawColor(160, 160, 160)
+	pdf.Rect(x, y, w, h, "D")
+	return x, y, w, h
+}
+
+// axisMax rounds the largest bandwidth up to a value the axis can label.
+func axisMax(bps float64) float64 {
+	if bps <= 0 {
+		return 1
+	}
+	step := math.Pow(10, math.Floor(math.Log10(bps)))
+	return math.Ceil(bps/step) * step
+}
+
+// yLabels writes the bandwidth scale beside a chart.
+func yLabels(pdf *gofpdf.Fpdf, x, y, h, max float64) {
+	pdf.SetFont("Helvetica", "", 7)
+	for i := 0; i <= 4; i++ {
+		v := max * float64(i) / 4
+		pdf.Text(margin, y+h-h*float64(i)/4+1, FormatBandwidth(v))
+	}
+	pdf.SetFont("Helvetica", "", 10)
+}
+
+// timeChart plots each completed test's average bandwidth at its time.
+func timeChart(pdf *gofpdf.Fpdf, rep Report) {
+	tests := completed(rep.Results)
+	x, y, w, h := chartArea(pdf)
+	max := axisMax(Summarize(tests).MaxBandwidth)
+	yLabels(pdf, x, y, h, max)
+
+	span := rep.To.Sub(rep.From).Seconds()
+	point := func(r models.TestResult) (float64, float64) {
+		fx := 0.5
+		if span > 0 {
+			fx = r.Timestamp.Sub(rep.From).Seconds() / span
+		}
+		fx = math.Min(math.Max(fx, 0), 1)
+		return x + fx*w, y + h - r.AvgBandwidth/max*h
+	}
+	pdf.SetDrawColor(37, 99, 235)
+	pdf.SetFillColor(37, 99, 235)
+	pdf.SetLineWidth(0.3)
+	for i, r := range tests {
+		px, py := point(r)
+		if i > 0 {
+			prevX, prevY := point(tests[i-1])
+			pdf.Line(prevX, prevY, px, py)
+		}
+		pdf.Circle(px, py, 0.7, "F")
+	}
+	pdf.SetLineWidth(0.2)
+
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.Text(x, y+h+4, rep.From.UTC().Format("2006-01-02 15:04"))
+	end := rep.To.UTC().Format("2006-01-02 15:04")
+	pdf.Text(x+w-pdf.GetStringWidth(end), y+h+4, end)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetY(y + h + 8)
+}
+
+// histogram counts the completed tests in equal bandwidth ranges.
+func histogram(pdf *gofpdf.Fpdf, results []models.TestResult, summary Summary) {
+	tests := completed(results)
+	var counts [histBins]int
+	max := axisMax(summary.MaxBandwidth)
+	for _, r := range tests {
+		bin := int(r.AvgBandwidth / max * histBins)
+		if bin >= histBins {
+			bin = histBins - 1
+		}
+		if bin < 0 {
+			bin = 0
+		}
+		counts[bin]++
+	}
+	most := 1
+	for _, c := range counts {
+		if c > most {
+			most = c
+		}
+	}
+
+	x, y, w, h := chartArea(pdf)
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.Text(margin, y+3, fmt.Sprintf("%d tests", most))
+	pdf.Text(margin, y+h+1, "0")
+	pdf.SetFillColor(37, 99, 235)
+	barWidth := w / histBins
+	for i, c := range counts {
+		barHeight := float64(c) / float64(most) * h
+		if c > 0 {
+			pdf.Rect(x+float64(i)*barWidth+1, y+h-barHeight, barWidth-2, barHeight, "F")
+		}
+	}
+	pdf.Text(x, y+h+4, "0")
+	label := FormatBandwidth(max)
+	pdf.Text(x+w-pdf.GetStringWidth(label), y+h+4, label)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetY(y + h + 8)
+}
+
+// table lists tests with their time, client and bandwidth.
+func table(pdf *gofpdf.Fpdf, tr func(string) string, tests []models.TestResult) {
+	widths := []float64{42, 45, 22, 25, 46}
+	headers := []string{"Time (UTC)", "Client", "Protocol", "Direction", "Average bandwidth"}
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 6, h, "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 9)
+	for _, r := range tests {
+		cells := []string{
+			r.Timestamp.UTC().Format("2006-01-02 15:04:05"),
+			tr(r.ClientIP),
+			string(r.Protocol),
+			r.Direction,
+			FormatBandwidth(r.AvgBandwidth),
+		}
+		for i, c := range cells {
+			pdf.CellFormat(widths[i], 6, c, "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(4)
+}
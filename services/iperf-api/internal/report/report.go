@@ -0,0 +1,159 @@
+// Package report summarises the tests of a period, rendering the summary
+// as a PDF to attach to a ticket or sending a digest of each week or month
+// to notification channels.
+package report
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// RankedTests is how many of the fastest and slowest tests a report lists.
+const RankedTests = 5
+
+// Report is what a PDF report covers: the tests between From and To,
+// optionally of one client.
+type Report struct {
+	From, To  time.Time
+	ClientIP  string
+	Node      string
+	Generated time.Time
+	Results   []models.TestResult
+}
+
+// Summary is the statistics of a report's tests. Bandwidths are over the
+// completed tests; Jitter and PacketLoss are averaged over the UDP tests
+// that reported them and nil if none did.
+type Summary struct {
+	Tests     int
+	Completed int
+	Failed    int
+	Aborted   int
+	Clients   int
+	Bytes     int64
+
+	AvgBandwidth    float64
+	MedianBandwidth float64
+	MinBandwidth    float64
+	MaxBandwidth    float64
+
+	Retransmits int
+	Jitter      *float64
+	PacketLoss  *float64
+}
+
+// Summarize computes the statistics of results.
+func Summarize(results []models.TestResult) Summary {
+	var s Summary
+	clients := make(map[string]bool)
+	var bandwidths []float64
+	var jitter, loss []float64
+	for _, r := range results {
+		s.Tests++
+		clients[r.ClientIP] = true
+		switch r.Status {
+		case models.TestStatusFailed:
+			s.Failed++
+			continue
+		case models.TestStatusAborted:
+			s.Aborted++
+			continue
+		}
+		s.Completed++
+		s.Bytes += r.BytesTransferred
+		bandwidths = append(bandwidths, r.AvgBandwidth)
+		if r.Retransmits != nil {
+			s.Retransmits += *r.Retransmits
+		}
+		if r.Jitter != nil {
+			jitter = append(jitter, *r.Jitter)
+		}
+		if r.PacketLoss != nil {
+			loss = append(loss, *r.PacketLoss)
+		}
+	}
+	s.Clients = len(clients)
+
+	if len(bandwidths) > 0 {
+		sort.Float64s(bandwidths)
+		s.MinBandwidth, s.MaxBandwidth = bandwidths[0], bandwidths[len(bandwidths)-1]
+		s.AvgBandwidth = mean(bandwidths)
+		mid := len(bandwidths) / 2
+		s.MedianBandwidth = bandwidths[mid]
+		if len(bandwidths)%2 == 0 {
+			s.MedianBandwidth = (bandwidths[mid-1] + bandwidths[mid]) / 2
+		}
+	}
+	if len(jitter) > 0 {
+		v := mean(jitter)
+		s.Jitter = &v
+	}
+	if len(loss) > 0 {
+		v := mean(loss)
+		s.PacketLoss = &v
+	}
+	return s
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Ranked returns up to n of the completed tests with the highest average
+// bandwidth, fastest first, and up to n with the lowest, slowest first.
+func Ranked(results []models.TestResult, n int) (top, bottom []models.TestResult) {
+	var completed []models.TestResult
+	for _, r := range results {
+		if r.Status != models.TestStatusFailed && r.Status != models.TestStatusAborted {
+			completed = append(completed, r)
+		}
+	}
+	sort.SliceStable(completed, func(i, j int) bool {
+		return completed[i].AvgBandwidth > completed[j].AvgBandwidth
+	})
+	if n > len(completed) {
+		n = len(completed)
+	}
+	top = completed[:n]
+	for i := len(completed) - 1; i >= len(completed)-n; i-- {
+		bottom = append(bottom, completed[i])
+	}
+	return top, bottom
+}
+
+// FormatBandwidth formats bits per second in the largest unit the value
+// reaches, such as "941.2 Mbps".
+func FormatBandwidth(bps float64) string {
+	units := []struct {
+		label string
+		scale float64
+	}{{"Gbps", 1e9}, {"Mbps", 1e6}, {"Kbps", 1e3}}
+	for _, u := range units {
+		if bps >= u.scale {
+			return strconv.FormatFloat(bps/u.scale, 'f', 1, 64) + " " + u.label
+		}
+	}
+	return strconv.FormatFloat(bps, 'f', 0, 64) + " bps"
+}
+
+// FormatBytes formats a byte count in the largest binary unit it reaches.
+func FormatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64) + " " + units[i]
+}
@@ -0,0 +1,114 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func results() []models.TestResult {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	jitter, loss := 0.5, 1.5
+	retransmits := 3
+	var out []models.TestResult
+	for i, bw := range []float64{100e6, 400e6, 200e6, 300e6} {
+		out = append(out, models.TestResult{
+			ID:               string(rune('a' + i)),
+			Timestamp:        start.Add(time.Duration(i) * time.Hour),
+			ClientIP:         "10.0.0.1",
+			Protocol:         models.ProtocolTCP,
+			Direction:        "upload",
+			Status:           models.TestStatusCompleted,
+			AvgBandwidth:     bw,
+			BytesTransferred: 1000,
+			Retransmits:      &retransmits,
+		})
+	}
+	out = append(out, models.TestResult{
+		ID: "udp", Timestamp: start.Add(5 * time.Hour), ClientIP: "10.0.0.2", Protocol: models.ProtocolUDP,
+		Status: models.TestStatusCompleted, AvgBandwidth: 50e6, BytesTransferred: 500, Jitter: &jitter, PacketLoss: &loss,
+	})
+	out = append(out, models.TestResult{
+		ID: "failed", Timestamp: start.Add(6 * time.Hour), ClientIP: "10.0.0.3", Status: models.TestStatusFailed, AvgBandwidth: 900e6,
+	})
+	return out
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize(results())
+	if s.Tests != 6 || s.Completed != 5 || s.Failed != 1 || s.Clients != 3 {
+		t.Errorf("counts = %+v", s)
+	}
+	if s.Bytes != 4500 {
+		t.Errorf("Bytes = %d, want 4500", s.Bytes)
+	}
+	if s.AvgBandwidth != 210e6 || s.MedianBandwidth != 200e6 || s.MinBandwidth != 50e6 || s.MaxBandwidth != 400e6 {
+		t.Errorf("bandwidths = %+v", s)
+	}
+	if s.Retransmits != 12 {
+		t.Errorf("Retransmits = %d, want 12", s.Retransmits)
+	}
+	if s.Jitter == nil || *s.Jitter != 0.5 || s.PacketLoss == nil || *s.PacketLoss != 1.5 {
+		t.Errorf("Jitter = %v, PacketLoss = %v", s.Jitter, s.PacketLoss)
+	}
+
+	if empty := Summarize(nil); empty.Tests != 0 || empty.Jitter != nil || empty.AvgBandwidth != 0 {
+		t.Errorf("Summarize(nil) = %+v", empty)
+	}
+}
+
+func TestRanked(t *testing.T) {
+	top, bottom := Ranked(results(), 2)
+	ids := func(rs []models.TestResult) string {
+		var s string
+		for _, r := range rs {
+			s += r.ID + " "
+		}
+		return s
+	}
+	// The failed test is faster than any but is not ranked
+	if got := ids(top); got != "b d " {
+		t.Errorf("top = %q, want \"b d \"", got)
+	}
+	if got := ids(bottom); got != "udp a " {
+		t.Errorf("bottom = %q, want \"udp a \"", got)
+	}
+
+	top, bottom = Ranked(results()[:1], RankedTests)
+	if len(top) != 1 || len(bottom) != 1 {
+		t.Errorf("Ranked of one test = %d top, %d bottom", len(top), len(bottom))
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for bps, want := range map[float64]string{
+		941.23e6: "941.2 Mbps",
+		2.5e9:    "2.5 Gbps",
+		12e3:     "12.0 Kbps",
+		800:      "800 bps",
+	} {
+		if got := FormatBandwidth(bps); got != want {
+			t.Errorf("FormatBandwidth(%v) = %q, want %q", bps, got, want)
+		}
+	}
+	if got := FormatBytes(3 << 30); got != "3.0 GiB" {
+		t.Errorf("FormatBytes = %q, want 3.0 GiB", got)
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for name, rs := range map[string][]models.TestResult{"results": results(), "empty": nil} {
+		var buf bytes.Buffer
+		rep := Report{From: from, To: from.Add(24 * time.Hour), ClientIP: "10.0.0.1", Node: "lab", Generated: from, Results: rs}
+		if err := WritePDF(&buf, rep); err != nil {
+			t.Fatalf("%s: WritePDF: %v", name, err)
+		}
+		out := bytes.TrimSpace(buf.Bytes())
+		if !bytes.HasPrefix(out, []byte("%PDF-")) || !bytes.HasSuffix(out, []byte("%%EOF")) {
+			t.Errorf("%s: output is not a PDF: %q...", name, out[:min(len(out), 20)])
+		}
+	}
+}
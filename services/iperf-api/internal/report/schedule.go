@@ -0,0 +1,325 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/alerts"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Period is how often scheduled reports are sent, each covering the period
+// just ended.
+type Period string
+
+const (
+	// Weekly reports cover Monday to Monday
+	Weekly Period = "weekly"
+	// Monthly reports cover a calendar month
+	Monthly Period = "monthly"
+)
+
+// start returns when the period containing t began, at midnight in t's
+// location.
+func (p Period) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	if p == Monthly {
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	// Weekdays count from Sunday; weeks here start on Monday
+	back := (int(t.Weekday()) + 6) % 7
+	return time.Date(y, m, d-back, 0, 0, 0, 0, t.Location())
+}
+
+// Next returns when the period after the one containing t begins, which is
+// when its report is sent.
+func (p Period) Next(t time.Time) time.Time {
+	start := p.start(t)
+	if p == Monthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+// Last returns the last period to have ended by t.
+func (p Period) Last(t time.Time) (from, to time.Time) {
+	to = p.start(t)
+	return p.start(to.Add(-time.Nanosecond)), to
+}
+
+// Channel delivers digests.
+type Channel interface {
+	// Name says where digests go, for logs and status
+	Name() string
+	Deliver(ctx context.Context, d Digest) error
+}
+
+// EmailChannel emails digests to the notifier's recipients.
+type EmailChannel struct {
+	notifier *alerts.EmailNotifier
+}
+
+// NewEmailChannel creates a channel sending through n.
+func NewEmailChannel(n *alerts.EmailNotifier) *EmailChannel {
+	return &EmailChannel{notifier: n}
+}
+
+// Name implements Channel.
+func (c *EmailChannel) Name() string {
+	return "email"
+}
+
+// Deliver implements Channel.
+func (c *EmailChannel) Deliver(ctx context.Context, d Digest) error {
+	return c.notifier.SendMessage(ctx, d.Subject(), d.Text())
+}
+
+// WebhookChannel posts digests to a URL as JSON.
+type WebhookChannel struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookChannel creates a channel posting to rawURL, whose requests
+// time out after timeout.
+func NewWebhookChannel(rawURL string, timeout time.Duration) (*WebhookChannel, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	return &WebhookChannel{url: rawURL, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Name implements Channel. Only the host is named, as webhook paths often
+// hold tokens.
+func (c *WebhookChannel) Name() string {
+	u, _ := url.Parse(c.url)
+	return "webhook " + u.Host
+}
+
+// Deliver implements Channel, failing on a non-2xx response.
+func (c *WebhookChannel) Deliver(ctx context.Context, d Digest) error {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Results returns the test results with a timestamp in [from, to).
+type Results func(ctx context.Context, from, to time.Time) ([]models.TestResult, error)
+
+// ScheduleConfig configures scheduled reports.
+type ScheduleConfig struct {
+	Period Period
+	// Degradation is the fall in a client's average bandwidth, in percent
+	// of the previous period's, at which it is reported as degraded
+	Degradation float64
+	// Node names the deployment in reports
+	Node string
+	// Timeout bounds building and delivering each report
+	Timeout time.Duration
+}
+
+// Validate checks the period and applies defaults.
+func (c *ScheduleConfig) Validate() error {
+	switch c.Period {
+	case Weekly, Monthly:
+	default:
+		return fmt.Errorf("unknown report period %q; use weekly or monthly", c.Period)
+	}
+	if c.Degradation < 0 || c.Degradation > 100 {
+		return errors.New("the degradation percentage must be between 0 and 100")
+	}
+	if c.Degradation == 0 {
+		c.Degradation = 10
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Minute
+	}
+	return nil
+}
+
+// ScheduleStatus reports the scheduler's settings and how its last report
+// went.
+type ScheduleStatus struct {
+	Period      Period     `json:"period"`
+	Degradation float64    `json:"degradation"`
+	Channels    []string   `json:"channels"`
+	NextReport  *time.Time `json:"nextReport,omitempty"`
+	// LastReport is when the last report was sent, and LastErrors holds
+	// the channels it failed on
+	LastReport *time.Time        `json:"lastReport,omitempty"`
+	LastErrors map[string]string `json:"lastErrors,omitempty"`
+	// Reports counts the reports sent since startup
+	Reports int `json:"reports"`
+}
+
+// Scheduler sends a digest of each period to its channels when the period
+// ends.
+type Scheduler struct {
+	cfg      ScheduleConfig
+	results  Results
+	channels []Channel
+	now      func() time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	status ScheduleStatus
+}
+
+// NewScheduler creates a Scheduler for a validated config.
+func NewScheduler(cfg ScheduleConfig, results Results, channels ...Channel) *Scheduler {
+	names := make([]string, len(channels))
+	for i, c := range channels {
+		names[i] = c.Name()
+	}
+	return &Scheduler{
+		cfg:      cfg,
+		results:  results,
+		channels: channels,
+		now:      time.Now,
+		done:     make(chan struct{}),
+		status: ScheduleStatus{
+			Period:      cfg.Period,
+			Degradation: cfg.Degradation,
+			Channels:    names,
+		},
+	}
+}
+
+// Run sends a report each time a period ends until Close is called.
+func (s *Scheduler) Run() {
+	for {
+		next := s.cfg.Period.Next(s.now())
+		s.mu.Lock()
+		s.status.NextReport = &next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		if d, err := s.Send(ctx); err != nil {
+			log.Printf("Scheduled report failed: %v", err)
+		} else {
+			log.Printf("Sent the %s report for %s to %s", d.Period, d.From.Format("2006-01-02"), d.To.Format("2006-01-02"))
+		}
+		cancel()
+	}
+}
+
+// Close stops scheduled reports.
+func (s *Scheduler) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Status returns the settings and the outcome of the last report.
+func (s *Scheduler) Status() ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Channels = append([]string(nil), s.status.Channels...)
+	if status.NextReport != nil {
+		at := *status.NextReport
+		status.NextReport = &at
+	}
+	if status.LastReport != nil {
+		at := *status.LastReport
+		status.LastReport = &at
+	}
+	if status.LastErrors != nil {
+		status.LastErrors = make(map[string]string, len(s.status.LastErrors))
+		for k, v := range s.status.LastErrors {
+			status.LastErrors[k] = v
+		}
+	}
+	return status
+}
+
+// Build returns the digest of the last period to have ended.
+func (s *Scheduler) Build(ctx context.Context) (Digest, error) {
+	now := s.now()
+	from, to := s.cfg.Period.Last(now)
+	current, err := s.results(ctx, from, to)
+	if err != nil {
+		return Digest{}, err
+	}
+	prevFrom, _ := s.cfg.Period.Last(from)
+	previous, err := s.results(ctx, prevFrom, from)
+	if err != nil {
+		return Digest{}, err
+	}
+	d := BuildDigest(s.cfg.Period, from, to, current, previous, s.cfg.Degradation)
+	d.Generated = now
+	d.Node = s.cfg.Node
+	return d, nil
+}
+
+// Send builds the digest of the last period and delivers it to every
+// channel, returning the channels' errors together.
+func (s *Scheduler) Send(ctx context.Context) (Digest, error) {
+	d, err := s.Build(ctx)
+	if err != nil {
+		return d, err
+	}
+	failures := s.Deliver(ctx, d)
+	var errs []error
+	for _, c := range s.channels {
+		if failure, ok := failures[c.Name()]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s", c.Name(), failure))
+		}
+	}
+	return d, errors.Join(errs...)
+}
+
+// Deliver sends d to every channel and returns the error of each that
+// failed by name. A channel that fails does not stop the others.
+func (s *Scheduler) Deliver(ctx context.Context, d Digest) map[string]string {
+	failures := make(map[string]string)
+	for _, c := range s.channels {
+		if err := c.Deliver(ctx, d); err != nil {
+			failures[c.Name()] = err.Error()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := s.now()
+	s.status.LastReport = &sent
+	s.status.LastErrors = nil
+	if len(failures) > 0 {
+		s.status.LastErrors = failures
+	}
+	s.status.Reports++
+	return failures
+}
@@ -0,0 +1,161 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestPeriods(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 3, 6, 15, 30, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2024, m, d, 0, 0, 0, 0, time.UTC) }
+
+	if got := Weekly.Next(now); !got.Equal(day(3, 11)) {
+		t.Errorf("Weekly.Next = %v", got)
+	}
+	if from, to := Weekly.Last(now); !from.Equal(day(2, 26)) || !to.Equal(day(3, 4)) {
+		t.Errorf("Weekly.Last = %v to %v", from, to)
+	}
+	// On the Monday a week begins, the week just ended is reported
+	if from, to := Weekly.Last(day(3, 11)); !from.Equal(day(3, 4)) || !to.Equal(day(3, 11)) {
+		t.Errorf("Weekly.Last on Monday = %v to %v", from, to)
+	}
+	if got := Monthly.Next(now); !got.Equal(day(4, 1)) {
+		t.Errorf("Monthly.Next = %v", got)
+	}
+	if from, to := Monthly.Last(now); !from.Equal(day(2, 1)) || !to.Equal(day(3, 1)) {
+		t.Errorf("Monthly.Last = %v to %v", from, to)
+	}
+}
+
+func TestBuildDigest(t *testing.T) {
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	result := func(ip string, bw float64) models.TestResult {
+		return models.TestResult{ClientIP: ip, Status: models.TestStatusCompleted, AvgBandwidth: bw}
+	}
+	previous := []models.TestResult{result("10.0.0.1", 1000e6), result("10.0.0.2", 500e6), result("10.0.0.3", 100e6)}
+	current := []models.TestResult{
+		result("10.0.0.1", 700e6), result("10.0.0.1", 700e6),
+		result("10.0.0.2", 480e6),
+		result("10.0.0.3", 150e6),
+		result("10.0.0.4", 50e6),
+	}
+
+	d := BuildDigest(Weekly, from, from.AddDate(0, 0, 7), current, previous, 10)
+	if d.Tests != 5 || d.Completed != 5 || len(d.Clients) != 4 {
+		t.Fatalf("digest = %+v", d)
+	}
+	var order []string
+	for _, c := range d.Clients {
+		order = append(order, c.ClientIP)
+	}
+	if got := strings.Join(order, " "); got != "10.0.0.1 10.0.0.2 10.0.0.3 10.0.0.4" {
+		t.Errorf("clients in order %s", got)
+	}
+	first := d.Clients[0]
+	if !first.Degraded || first.Change == nil || *first.Change != -30 || first.Tests != 2 {
+		t.Errorf("10.0.0.1 = %+v", first)
+	}
+	// A 4% fall is within the threshold, and new clients have no trend
+	if d.Clients[1].Degraded || d.Clients[3].Change != nil || d.Clients[3].PreviousAvgBandwidth != nil {
+		t.Errorf("clients = %+v", d.Clients)
+	}
+	if got := d.Degraded(); len(got) != 1 {
+		t.Errorf("Degraded = %+v", got)
+	}
+
+	if !strings.HasSuffix(d.Subject(), ": 1 degraded") {
+		t.Errorf("Subject = %q", d.Subject())
+	}
+	if text := d.Text(); !strings.Contains(text, "10.0.0.1: 700.0 Mbps, down 30.0% from 1.0 Gbps") {
+		t.Errorf("Text missing the degraded client:\n%s", text)
+	}
+}
+
+// fakeChannel records digests and fails with err.
+type fakeChannel struct {
+	name    string
+	err     error
+	digests []Digest
+}
+
+func (c *fakeChannel) Name() string { return c.name }
+
+func (c *fakeChannel) Deliver(ctx context.Context, d Digest) error {
+	c.digests = append(c.digests, d)
+	return c.err
+}
+
+func TestSchedulerSend(t *testing.T) {
+	now := time.Date(2024, 3, 6, 15, 30, 0, 0, time.UTC)
+	var queried [][2]time.Time
+	results := func(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+		queried = append(queried, [2]time.Time{from, to})
+		return []models.TestResult{{ClientIP: "10.0.0.1", Status: models.TestStatusCompleted, AvgBandwidth: 1e9}}, nil
+	}
+	ok := &fakeChannel{name: "ok"}
+	broken := &fakeChannel{name: "broken", err: errors.New("refused")}
+	cfg := ScheduleConfig{Period: Weekly, Node: "lab"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(cfg, results, broken, ok)
+	s.now = func() time.Time { return now }
+
+	d, err := s.Send(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken: refused") {
+		t.Errorf("Send err = %v", err)
+	}
+	// The failing channel does not keep the other from its digest
+	if len(ok.digests) != 1 || ok.digests[0].Node != "lab" || !d.Generated.Equal(now) {
+		t.Errorf("delivered %+v", ok.digests)
+	}
+	week := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	if len(queried) != 2 || !queried[0][0].Equal(week.AddDate(0, 0, -7)) || !queried[1][1].Equal(week.AddDate(0, 0, -7)) {
+		t.Errorf("queried %v", queried)
+	}
+
+	status := s.Status()
+	if status.Reports != 1 || status.LastErrors["broken"] != "refused" || len(status.Channels) != 2 || status.Degradation != 10 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	received := make(chan Digest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d Digest
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- d
+	}))
+	defer srv.Close()
+
+	c, err := NewWebhookChannel(srv.URL+"/hooks/secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(c.Name(), "secret") {
+		t.Errorf("Name reveals the path: %q", c.Name())
+	}
+	if err := c.Deliver(context.Background(), Digest{Period: Monthly, Tests: 3, Clients: []ClientTrend{}}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if d := <-received; d.Period != Monthly || d.Tests != 3 {
+		t.Errorf("received %+v", d)
+	}
+
+	if _, err := NewWebhookChannel("ftp://example.com", time.Second); err == nil {
+		t.Error("accepted an ftp webhook")
+	}
+}
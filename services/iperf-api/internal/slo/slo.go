@@ -0,0 +1,252 @@
+// Package slo computes service level indicators from stored test results so
+// teams can track network objectives on top of the probe data.
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// DefaultWindowDays is the rolling window used when an objective sets none.
+const DefaultWindowDays = 28
+
+// Metric is the result field an objective is measured on.
+type Metric string
+
+const (
+	MetricAvgBandwidth Metric = "avg_bandwidth"
+	MetricMinBandwidth Metric = "min_bandwidth"
+	MetricPacketLoss   Metric = "packet_loss"
+	MetricJitter       Metric = "jitter"
+	MetricRetransmits  Metric = "retransmits"
+)
+
+// Objective defines which tests are good and the fraction of good tests
+// expected over a rolling window.
+type Objective struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Metric      Metric `json:"metric"`
+	// A test is good when its metric is at least Min or at most Max
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Target is the expected fraction of good tests, e.g. 0.99
+	Target     float64 `json:"target"`
+	WindowDays int     `json:"windowDays"`
+	// ClientIP limits the objective to one client; empty covers every client
+	ClientIP string `json:"clientIp,omitempty"`
+}
+
+// Window returns the objective's rolling window.
+func (o Objective) Window() time.Duration {
+	return time.Duration(o.WindowDays) * 24 * time.Hour
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Validate checks an objective and applies the default window.
+func (o *Objective) Validate() error {
+	if !validName.MatchString(o.Name) {
+		return fmt.Errorf("objective name %q must be lowercase letters, digits and dashes", o.Name)
+	}
+	switch o.Metric {
+	case MetricAvgBandwidth, MetricMinBandwidth, MetricPacketLoss, MetricJitter, MetricRetransmits:
+	default:
+		return fmt.Errorf("objective %q: unknown metric %q", o.Name, o.Metric)
+	}
+	if (o.Min == nil) == (o.Max == nil) {
+		return fmt.Errorf("objective %q: set exactly one of min and max", o.Name)
+	}
+	if o.Target <= 0 || o.Target >= 1 {
+		return fmt.Errorf("objective %q: target must be between 0 and 1", o.Name)
+	}
+	if o.WindowDays < 0 {
+		return fmt.Errorf("objective %q: windowDays must not be negative", o.Name)
+	}
+	if o.WindowDays == 0 {
+		o.WindowDays = DefaultWindowDays
+	}
+	return nil
+}
+
+// LoadObjectives reads a JSON array of objectives from path.
+func LoadObjectives(path string) ([]Objective, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var objectives []Objective
+	if err := json.Unmarshal(data, &objectives); err != nil {
+		return nil, fmt.Errorf("invalid objectives file %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range objectives {
+		if err := objectives[i].Validate(); err != nil {
+			return nil, err
+		}
+		if seen[objectives[i].Name] {
+			return nil, fmt.Errorf("duplicate objective %q", objectives[i].Name)
+		}
+		seen[objectives[i].Name] = true
+	}
+	return objectives, nil
+}
+
+// value returns the measured metric of a result, if it has one.
+func (o Objective) value(r *models.TestResult) (float64, bool) {
+	switch o.Metric {
+	case MetricAvgBandwidth:
+		return r.AvgBandwidth, true
+	case MetricMinBandwidth:
+		return r.MinBandwidth, true
+	case MetricPacketLoss:
+		if r.PacketLoss != nil {
+			return *r.PacketLoss, true
+		}
+	case MetricJitter:
+		if r.Jitter != nil {
+			return *r.Jitter, true
+		}
+	case MetricRetransmits:
+		if r.Retransmits != nil {
+			return float64(*r.Retransmits), true
+		}
+	}
+	return 0, false
+}
+
+// Classify reports whether a result is an event for the objective and, if
+// so, whether it is good. Failed tests are bad events; aborted tests and
+// tests without the metric, such as packet loss on TCP, are not events.
+func (o Objective) Classify(r *models.TestResult) (good, counted bool) {
+	if o.ClientIP != "" && r.ClientIP != o.ClientIP {
+		return false, false
+	}
+	switch r.Status {
+	case models.TestStatusAborted:
+		return false, false
+	case models.TestStatusFailed:
+		return false, true
+	}
+
+	v, ok := o.value(r)
+	if !ok {
+		return false, false
+	}
+	if o.Min != nil {
+		return v >= *o.Min, true
+	}
+	return v <= *o.Max, true
+}
+
+// Point is the SLI for one UTC day. RollingSLI covers the objective's window
+// ending with that day. SLIs are nil when there were no events.
+type Point struct {
+	Date       string   `json:"date"`
+	Total      int      `json:"total"`
+	Good       int      `json:"good"`
+	SLI        *float64 `json:"sli"`
+	RollingSLI *float64 `json:"rollingSli"`
+}
+
+// Report is an objective's SLI over a period with its daily series.
+type Report struct {
+	Objective Objective `json:"objective"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Total     int       `json:"total"`
+	Good      int       `json:"good"`
+	SLI       *float64  `json:"sli"`
+	// ErrorBudgetRemaining is the fraction of allowed bad tests not yet used
+	// in the period; negative once the objective is missed
+	ErrorBudgetRemaining *float64 `json:"errorBudgetRemaining"`
+	Series               []Point  `json:"series,omitempty"`
+}
+
+// Evaluate computes the objective's SLI over [from, to) with a daily series.
+// results must cover the objective's window before from so the first rolling
+// values are complete.
+func Evaluate(o Objective, results []models.TestResult, from, to time.Time) Report {
+	from, to = from.UTC(), to.UTC()
+	firstDay := day(from)
+	windowDays := o.WindowDays
+	if windowDays <= 0 {
+		windowDays = DefaultWindowDays
+	}
+	earliest := firstDay.AddDate(0, 0, -(windowDays - 1))
+
+	// Daily counts from the start of the first day's window
+	type counts struct{ total, good int }
+	var days []counts
+	for d := earliest; d.Before(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, counts{})
+	}
+
+	report := Report{Objective: o, From: from, To: to, Series: []Point{}}
+	for i := range results {
+		r := &results[i]
+		ts := r.Timestamp.UTC()
+		if ts.Before(earliest) || !ts.Before(to) {
+			continue
+		}
+		good, counted := o.Classify(r)
+		if !counted {
+			continue
+		}
+		idx := int(day(ts).Sub(earliest).Hours() / 24)
+		days[idx].total++
+		if good {
+			days[idx].good++
+		}
+		if !ts.Before(from) {
+			report.Total++
+			if good {
+				report.Good++
+			}
+		}
+	}
+
+	offset := windowDays - 1
+	for i := offset; i < len(days); i++ {
+		var rolling counts
+		for j := i - offset; j <= i; j++ {
+			rolling.total += days[j].total
+			rolling.good += days[j].good
+		}
+		report.Series = append(report.Series, Point{
+			Date:       earliest.AddDate(0, 0, i).Format("2006-01-02"),
+			Total:      days[i].total,
+			Good:       days[i].good,
+			SLI:        ratio(days[i].good, days[i].total),
+			RollingSLI: ratio(rolling.good, rolling.total),
+		})
+	}
+
+	report.SLI = ratio(report.Good, report.Total)
+	if report.Total > 0 {
+		allowed := (1 - o.Target) * float64(report.Total)
+		remaining := 1 - float64(report.Total-report.Good)/allowed
+		report.ErrorBudgetRemaining = &remaining
+	}
+	return report
+}
+
+// day truncates t to the start of its UTC day.
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func ratio(good, total int) *float64 {
+	if total == 0 {
+		return nil
+	}
+	v := float64(good) / float64(total)
+	return &v
+}
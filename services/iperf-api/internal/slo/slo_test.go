@@ -0,0 +1,145 @@
+package slo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestClassify(t *testing.T) {
+	o := Objective{Name: "bw", Metric: MetricAvgBandwidth, Min: float(100), Target: 0.9}
+	udp := Objective{Name: "loss", Metric: MetricPacketLoss, Max: float(1), Target: 0.9}
+
+	tests := []struct {
+		name          string
+		obj           Objective
+		result        models.TestResult
+		good, counted bool
+	}{
+		{"above min", o, models.TestResult{AvgBandwidth: 150, Status: models.TestStatusCompleted}, true, true},
+		{"below min", o, models.TestResult{AvgBandwidth: 50, Status: models.TestStatusCompleted}, false, true},
+		{"failed is bad", o, models.TestResult{AvgBandwidth: 150, Status: models.TestStatusFailed}, false, true},
+		{"aborted is excluded", o, models.TestResult{AvgBandwidth: 150, Status: models.TestStatusAborted}, false, false},
+		{"under max", udp, models.TestResult{PacketLoss: float(0.5), Status: models.TestStatusCompleted}, true, true},
+		{"missing metric", udp, models.TestResult{Status: models.TestStatusCompleted}, false, false},
+	}
+	for _, tt := range tests {
+		good, counted := tt.obj.Classify(&tt.result)
+		if good != tt.good || counted != tt.counted {
+			t.Errorf("%s: Classify = %v, %v, want %v, %v", tt.name, good, counted, tt.good, tt.counted)
+		}
+	}
+
+	scoped := o
+	scoped.ClientIP = "10.0.0.1"
+	if _, counted := scoped.Classify(&models.TestResult{ClientIP: "10.0.0.2", AvgBandwidth: 150}); counted {
+		t.Error("result from another client was counted")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	o := Objective{Name: "bw", Metric: MetricAvgBandwidth, Min: float(100), Target: 0.5, WindowDays: 2}
+	day1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	result := func(at time.Time, bw float64) models.TestResult {
+		return models.TestResult{Timestamp: at, AvgBandwidth: bw, Status: models.TestStatusCompleted}
+	}
+	results := []models.TestResult{
+		// Before the period: only feeds the first rolling value
+		result(day1.Add(-12*time.Hour), 50),
+		result(day1.Add(time.Hour), 150),
+		result(day1.Add(2*time.Hour), 150),
+		result(day1.Add(26*time.Hour), 50),
+	}
+
+	report := Evaluate(o, results, day1, day1.AddDate(0, 0, 3))
+	if report.Total != 3 || report.Good != 2 {
+		t.Fatalf("total, good = %d, %d, want 3, 2", report.Total, report.Good)
+	}
+	if report.ErrorBudgetRemaining == nil || *report.ErrorBudgetRemaining < 0.33 || *report.ErrorBudgetRemaining > 0.34 {
+		t.Errorf("errorBudgetRemaining = %v, want 1/3", report.ErrorBudgetRemaining)
+	}
+
+	if len(report.Series) != 3 {
+		t.Fatalf("series has %d points, want 3", len(report.Series))
+	}
+	want := []struct {
+		date    string
+		sli     *float64
+		rolling *float64
+	}{
+		{"2024-03-01", float(1), float(2.0 / 3)},
+		{"2024-03-02", float(0), float(2.0 / 3)},
+		{"2024-03-03", nil, float(0)},
+	}
+	for i, w := range want {
+		p := report.Series[i]
+		if p.Date != w.date || !equal(p.SLI, w.sli) || !equal(p.RollingSLI, w.rolling) {
+			t.Errorf("series[%d] = %s sli=%v rolling=%v, want %s sli=%v rolling=%v",
+				i, p.Date, deref(p.SLI), deref(p.RollingSLI), w.date, deref(w.sli), deref(w.rolling))
+		}
+	}
+}
+
+func TestEvaluate_NoEvents(t *testing.T) {
+	o := Objective{Name: "bw", Metric: MetricAvgBandwidth, Min: float(100), Target: 0.9, WindowDays: 1}
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	report := Evaluate(o, nil, from, from.AddDate(0, 0, 1))
+	if report.SLI != nil || report.ErrorBudgetRemaining != nil {
+		t.Errorf("sli = %v, budget = %v, want nil", report.SLI, report.ErrorBudgetRemaining)
+	}
+	if len(report.Series) != 1 || report.Series[0].SLI != nil {
+		t.Errorf("series = %+v", report.Series)
+	}
+}
+
+func TestLoadObjectives(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "slo.json")
+	os.WriteFile(valid, []byte(`[{"name":"branch-bw","metric":"avg_bandwidth","min":1e8,"target":0.99}]`), 0644)
+	objectives, err := LoadObjectives(valid)
+	if err != nil {
+		t.Fatalf("LoadObjectives: %v", err)
+	}
+	if len(objectives) != 1 || objectives[0].WindowDays != DefaultWindowDays {
+		t.Errorf("objectives = %+v", objectives)
+	}
+
+	invalid := map[string]string{
+		"bad name":       `[{"name":"Branch BW","metric":"jitter","max":1,"target":0.9}]`,
+		"unknown metric": `[{"name":"a","metric":"latency","max":1,"target":0.9}]`,
+		"no threshold":   `[{"name":"a","metric":"jitter","target":0.9}]`,
+		"both bounds":    `[{"name":"a","metric":"jitter","min":1,"max":2,"target":0.9}]`,
+		"target of one":  `[{"name":"a","metric":"jitter","max":1,"target":1}]`,
+		"duplicate":      `[{"name":"a","metric":"jitter","max":1,"target":0.9},{"name":"a","metric":"jitter","max":2,"target":0.9}]`,
+		"not json":       `{`,
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, "bad.json")
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadObjectives(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func equal(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	d := *a - *b
+	return d < 1e-9 && d > -1e-9
+}
+
+func deref(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
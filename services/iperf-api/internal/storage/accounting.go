@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// ErrNotFound is returned when a record to update or delete does not exist.
+var ErrNotFound = errors.New("not found")
+
+// SaveCostCenterAssignment stores an assignment, replacing the cost center of
+// an existing assignment with the same match.
+func (s *SQLiteStorage) SaveCostCenterAssignment(ctx context.Context, a *models.CostCenterAssignment) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return saveCostCenterAssignment(ctx, s.db, a)
+}
+
+func saveCostCenterAssignment(ctx context.Context, db execer, a *models.CostCenterAssignment) error {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now().UTC()
+	}
+
+	upsertSQL := `
+	INSERT INTO cost_center_assignments (match, cost_center, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(match) DO UPDATE SET cost_center = excluded.cost_center
+	RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(ctx, upsertSQL, a.Match, a.CostCenter, a.CreatedAt).Scan(&a.ID, &a.CreatedAt)
+}
+
+// ListCostCenterAssignments returns all assignments ordered by match.
+func (s *SQLiteStorage) ListCostCenterAssignments(ctx context.Context) ([]models.CostCenterAssignment, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, match, cost_center, created_at
+	FROM cost_center_assignments
+	ORDER BY match
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []models.CostCenterAssignment
+	for rows.Next() {
+		var a models.CostCenterAssignment
+		if err := rows.Scan(&a.ID, &a.Match, &a.CostCenter, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// DeleteCostCenterAssignment removes an assignment by ID.
+func (s *SQLiteStorage) DeleteCostCenterAssignment(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM cost_center_assignments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// GetTestResultsBetween returns every test result with a timestamp in
+// [from, to), oldest first.
+func (s *SQLiteStorage) GetTestResultsBetween(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT ` + testResultColumns + `
+	FROM test_results
+	WHERE timestamp >= ? AND timestamp < ?
+	ORDER BY timestamp ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTestResults(rows)
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestSaveCostCenterAssignment_UpsertsByMatch(t *testing.T) {
+	s := newTestStorage(t)
+
+	first := &models.CostCenterAssignment{Match: "10.0.0.0/24", CostCenter: "lab"}
+	if err := s.SaveCostCenterAssignment(context.Background(), first); err != nil {
+		t.Fatalf("SaveCostCenterAssignment: %v", err)
+	}
+	second := &models.CostCenterAssignment{Match: "10.0.0.0/24", CostCenter: "research"}
+	if err := s.SaveCostCenterAssignment(context.Background(), second); err != nil {
+		t.Fatalf("SaveCostCenterAssignment: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("upsert ID = %d, want %d", second.ID, first.ID)
+	}
+
+	assignments, err := s.ListCostCenterAssignments(context.Background())
+	if err != nil {
+		t.Fatalf("ListCostCenterAssignments: %v", err)
+	}
+	if len(assignments) != 1 || assignments[0].CostCenter != "research" {
+		t.Fatalf("assignments = %+v, want single research entry", assignments)
+	}
+
+	if err := s.DeleteCostCenterAssignment(context.Background(), first.ID); err != nil {
+		t.Fatalf("DeleteCostCenterAssignment: %v", err)
+	}
+	if err := s.DeleteCostCenterAssignment(context.Background(), first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetTestResultsBetween(t *testing.T) {
+	s := newTestStorage(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	offset := time.FixedZone("UTC+2", 2*60*60)
+
+	for i, ts := range []time.Time{
+		base.Add(-time.Hour),
+		base,
+		base.Add(time.Hour).In(offset),
+		base.Add(2 * time.Hour),
+	} {
+		r := &models.TestResult{
+			ID:        string(rune('a' + i)),
+			Timestamp: ts,
+			ClientIP:  "10.0.0.1",
+			Protocol:  models.ProtocolTCP,
+			Direction: "upload",
+		}
+		if err := s.SaveTestResult(context.Background(), r); err != nil {
+			t.Fatalf("SaveTestResult: %v", err)
+		}
+	}
+
+	results, err := s.GetTestResultsBetween(context.Background(), base, base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("GetTestResultsBetween: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != "b" || results[1].ID != "c" {
+		t.Errorf("results = %+v, want b and c", results)
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+const alertRuleColumns = `id, name, client_ip, min_avg_bandwidth, max_packet_loss,
+		max_jitter, max_retransmits, max_retransmits_per_gb, min_goodput_ratio,
+		max_jitter_ratio, min_utilization, webhook_url, enabled, created_at`
+
+// CreateAlertRule inserts a new alert rule and sets its ID.
+func (s *SQLiteStorage) CreateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return createAlertRule(ctx, s.db, rule)
+}
+
+func createAlertRule(ctx context.Context, db execer, rule *models.AlertRule) error {
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now().UTC()
+	}
+
+	res, err := db.ExecContext(ctx, `
+	INSERT INTO alert_rules (name, client_ip, min_avg_bandwidth, max_packet_loss,
+		max_jitter, max_retransmits, max_retransmits_per_gb, min_goodput_ratio,
+		max_jitter_ratio, min_utilization, webhook_url, enabled, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		rule.Name, rule.ClientIP, rule.MinAvgBandwidth, rule.MaxPacketLoss,
+		rule.MaxJitter, rule.MaxRetransmits, rule.MaxRetransmitsPerGB, rule.MinGoodputRatio,
+		rule.MaxJitterRatio, rule.MinUtilization, rule.WebhookURL, rule.Enabled, rule.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	rule.ID, err = res.LastInsertId()
+	return err
+}
+
+// UpdateAlertRule replaces the thresholds and settings of an existing rule.
+func (s *SQLiteStorage) UpdateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE alert_rules SET name = ?, client_ip = ?, min_avg_bandwidth = ?,
+		max_packet_loss = ?, max_jitter = ?, max_retransmits = ?,
+		max_retransmits_per_gb = ?, min_goodput_ratio = ?, max_jitter_ratio = ?,
+		min_utilization = ?, webhook_url = ?, enabled = ?
+	WHERE id = ?
+	`,
+		rule.Name, rule.ClientIP, rule.MinAvgBandwidth, rule.MaxPacketLoss,
+		rule.MaxJitter, rule.MaxRetransmits, rule.MaxRetransmitsPerGB, rule.MinGoodputRatio,
+		rule.MaxJitterRatio, rule.MinUtilization, rule.WebhookURL, rule.Enabled, rule.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(res); err != nil {
+		return err
+	}
+
+	stored, err := s.GetAlertRule(ctx, rule.ID)
+	if err != nil {
+		return err
+	}
+	rule.CreatedAt = stored.CreatedAt
+	return nil
+}
+
+// GetAlertRule returns the rule with the given ID.
+func (s *SQLiteStorage) GetAlertRule(ctx context.Context, id int64) (*models.AlertRule, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules, err := scanAlertRules(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rules[0], nil
+}
+
+// ListAlertRules returns all alert rules ordered by ID.
+func (s *SQLiteStorage) ListAlertRules(ctx context.Context) ([]models.AlertRule, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+alertRuleColumns+" FROM alert_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAlertRules(rows)
+}
+
+// GetEnabledAlertRulesForClient returns the enabled rules that apply to
+// clientIP, including rules that apply to every client.
+func (s *SQLiteStorage) GetEnabledAlertRulesForClient(ctx context.Context, clientIP string) ([]models.AlertRule, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+alertRuleColumns+`
+	FROM alert_rules
+	WHERE enabled = 1 AND (client_ip = '' OR client_ip = ?)
+	ORDER BY id
+	`, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAlertRules(rows)
+}
+
+// DeleteAlertRule removes an alert rule by ID.
+func (s *SQLiteStorage) DeleteAlertRule(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM alert_rules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// requireAffected returns ErrNotFound when a statement changed no rows.
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scanAlertRules scans rows selected with alertRuleColumns.
+func scanAlertRules(rows *sql.Rows) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+
+	for rows.Next() {
+		var r models.AlertRule
+		err := rows.Scan(
+			&r.ID,
+			&r.Name,
+			&r.ClientIP,
+			&r.MinAvgBandwidth,
+			&r.MaxPacketLoss,
+			&r.MaxJitter,
+			&r.MaxRetransmits,
+			&r.MaxRetransmitsPerGB,
+			&r.MinGoodputRatio,
+			&r.MaxJitterRatio,
+			&r.MinUtilization,
+			&r.WebhookURL,
+			&r.Enabled,
+			&r.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
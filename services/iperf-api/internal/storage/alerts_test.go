@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestAlertRules_CRUD(t *testing.T) {
+	s := newTestStorage(t)
+	minBandwidth := 500e6
+
+	global := &models.AlertRule{Name: "all clients", MinAvgBandwidth: &minBandwidth, Enabled: true}
+	scoped := &models.AlertRule{Name: "lab", ClientIP: "10.0.0.1", MinAvgBandwidth: &minBandwidth, Enabled: true}
+	other := &models.AlertRule{Name: "other", ClientIP: "10.0.0.2", MinAvgBandwidth: &minBandwidth, Enabled: true}
+	for _, r := range []*models.AlertRule{global, scoped, other} {
+		if err := s.CreateAlertRule(context.Background(), r); err != nil {
+			t.Fatalf("CreateAlertRule: %v", err)
+		}
+	}
+
+	rules, err := s.GetEnabledAlertRulesForClient(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("GetEnabledAlertRulesForClient: %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != global.ID || rules[1].ID != scoped.ID {
+		t.Errorf("rules for 10.0.0.1 = %+v, want global and scoped", rules)
+	}
+
+	scoped.Enabled = false
+	if err := s.UpdateAlertRule(context.Background(), scoped); err != nil {
+		t.Fatalf("UpdateAlertRule: %v", err)
+	}
+	rules, _ = s.GetEnabledAlertRulesForClient(context.Background(), "10.0.0.1")
+	if len(rules) != 1 {
+		t.Errorf("disabled rule still returned: %+v", rules)
+	}
+
+	got, err := s.GetAlertRule(context.Background(), scoped.ID)
+	if err != nil {
+		t.Fatalf("GetAlertRule: %v", err)
+	}
+	if got.Enabled || got.ClientIP != "10.0.0.1" || *got.MinAvgBandwidth != minBandwidth {
+		t.Errorf("GetAlertRule = %+v", got)
+	}
+
+	if err := s.DeleteAlertRule(context.Background(), other.ID); err != nil {
+		t.Fatalf("DeleteAlertRule: %v", err)
+	}
+	if _, err := s.GetAlertRule(context.Background(), other.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAlertRule after delete err = %v, want ErrNotFound", err)
+	}
+	if err := s.UpdateAlertRule(context.Background(), other); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateAlertRule after delete err = %v, want ErrNotFound", err)
+	}
+}
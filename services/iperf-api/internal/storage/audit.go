@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveAuditEntry appends an entry to the audit log and sets its ID. Entries
+// are never updated or deleted through the API.
+func (s *SQLiteStorage) SaveAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	e.Timestamp = e.Timestamp.UTC()
+
+	params := ""
+	if len(e.Parameters) > 0 {
+		data, err := json.Marshal(e.Parameters)
+		if err != nil {
+			return err
+		}
+		params = string(data)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+	INSERT INTO audit_log (timestamp, action, api_key, remote_ip, parameters)
+	VALUES (?, ?, ?, ?, ?)
+	`, e.Timestamp, e.Action, e.APIKey, e.RemoteIP, params)
+	if err != nil {
+		return err
+	}
+
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+// AuditFilter narrows audit log queries. Zero-valued fields are ignored.
+type AuditFilter struct {
+	Action models.AuditAction
+	// Principal matches either the API key hash or the remote IP
+	Principal string
+	From      time.Time
+	To        time.Time
+}
+
+// where returns the SQL WHERE clause and arguments for the filter.
+func (f AuditFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Action != "" {
+		conds = append(conds, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.Principal != "" {
+		conds = append(conds, "(api_key = ? OR remote_ip = ?)")
+		args = append(args, f.Principal, f.Principal)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "timestamp >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "timestamp <= ?")
+		args = append(args, f.To.UTC())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// QueryAuditLog returns audit entries matching the filter, newest first.
+func (s *SQLiteStorage) QueryAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, args := filter.where()
+	query := `
+	SELECT id, timestamp, action, api_key, remote_ip, parameters
+	FROM audit_log
+	` + where + `
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
+
+// CountAuditLog returns the number of audit entries matching the filter.
+func (s *SQLiteStorage) CountAuditLog(ctx context.Context, filter AuditFilter) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, args := filter.where()
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&count)
+	return count, err
+}
+
+func scanAuditEntries(rows *sql.Rows) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		var action, params string
+		if err := rows.Scan(&e.ID, &e.Timestamp, &action, &e.APIKey, &e.RemoteIP, &params); err != nil {
+			return nil, err
+		}
+		e.Action = models.AuditAction(action)
+		if params != "" {
+			if err := json.Unmarshal([]byte(params), &e.Parameters); err != nil {
+				return nil, fmt.Errorf("decoding audit entry %d: %w", e.ID, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestAuditLog_FilterAndCount(t *testing.T) {
+	s := newTestStorage(t)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*models.AuditEntry{
+		{Timestamp: base, Action: models.AuditActionServerStart, RemoteIP: "10.0.0.1",
+			Parameters: map[string]interface{}{"config": map[string]interface{}{"port": 5201}}},
+		{Timestamp: base.Add(time.Hour), Action: models.AuditActionAlertRuleCreate, APIKey: "sha256:abc", RemoteIP: "10.0.0.2"},
+		{Timestamp: base.Add(2 * time.Hour), Action: models.AuditActionServerStop, RemoteIP: "10.0.0.2"},
+	}
+	for _, e := range entries {
+		if err := s.SaveAuditEntry(context.Background(), e); err != nil {
+			t.Fatalf("SaveAuditEntry: %v", err)
+		}
+	}
+
+	all, err := s.QueryAuditLog(context.Background(), AuditFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("QueryAuditLog: %v", err)
+	}
+	if len(all) != 3 || all[0].Action != models.AuditActionServerStop {
+		t.Fatalf("entries = %+v, want 3 newest first", all)
+	}
+	port := all[2].Parameters["config"].(map[string]interface{})["port"]
+	if port != float64(5201) {
+		t.Errorf("parameters round trip: port = %v, want 5201", port)
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   int
+	}{
+		{"action", AuditFilter{Action: models.AuditActionServerStart}, 1},
+		{"api key principal", AuditFilter{Principal: "sha256:abc"}, 1},
+		{"ip principal", AuditFilter{Principal: "10.0.0.2"}, 2},
+		{"period", AuditFilter{From: base.Add(30 * time.Minute), To: base.Add(90 * time.Minute)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.QueryAuditLog(context.Background(), tt.filter, 10, 0)
+			if err != nil {
+				t.Fatalf("QueryAuditLog: %v", err)
+			}
+			count, err := s.CountAuditLog(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("CountAuditLog: %v", err)
+			}
+			if len(got) != tt.want || count != tt.want {
+				t.Errorf("got %d entries, count %d, want %d", len(got), count, tt.want)
+			}
+		})
+	}
+
+	page, err := s.QueryAuditLog(context.Background(), AuditFilter{}, 1, 1)
+	if err != nil {
+		t.Fatalf("QueryAuditLog: %v", err)
+	}
+	if len(page) != 1 || page[0].Action != models.AuditActionAlertRuleCreate {
+		t.Errorf("page = %+v, want the second newest entry", page)
+	}
+}
@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Backup writes a consistent snapshot of the database to path, which must
+// not exist yet. Results can still be saved while it runs. Like a
+// statistics rebuild, it is bounded only by ctx, since a large history
+// takes longer than the query timeout to copy.
+func (s *SQLiteStorage) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+	_, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
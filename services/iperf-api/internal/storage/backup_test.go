@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestBackup(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	if err := s.SaveTestResult(ctx, &models.TestResult{ID: "r1", ClientIP: "10.0.0.1"}); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := s.Backup(ctx, path); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err := s.Backup(ctx, path); err == nil {
+		t.Error("Backup overwrote an existing file")
+	}
+
+	restored, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("opening the backup: %v", err)
+	}
+	defer restored.Close()
+	if _, err := restored.GetTestResult(ctx, "r1"); err != nil {
+		t.Errorf("GetTestResult from the backup: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// ReplaceConfiguration replaces every alert rule and cost center assignment
+// with those in b, replaces the profiles and link capacities if b has any
+// field for them, and
+// declares its desired state, if any, as a new version.
+// It runs in one transaction, so a failed import changes nothing. Imported
+// records get new IDs and versions.
+func (s *SQLiteStorage) ReplaceConfiguration(ctx context.Context, b *models.ConfigBundle) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM alert_rules"); err != nil {
+		return err
+	}
+	for i := range b.AlertRules {
+		if err := createAlertRule(ctx, tx, &b.AlertRules[i]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM cost_center_assignments"); err != nil {
+		return err
+	}
+	for i := range b.CostCenterAssignments {
+		if err := saveCostCenterAssignment(ctx, tx, &b.CostCenterAssignments[i]); err != nil {
+			return err
+		}
+	}
+
+	if b.Profiles != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM profiles"); err != nil {
+			return err
+		}
+		for i := range b.Profiles {
+			if err := saveProfile(ctx, tx, &b.Profiles[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if b.LinkCapacities != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM link_capacities"); err != nil {
+			return err
+		}
+		for i := range b.LinkCapacities {
+			if err := saveLinkCapacity(ctx, tx, &b.LinkCapacities[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if b.DesiredState != nil {
+		if err := saveDesiredState(ctx, tx, b.DesiredState); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestReplaceConfiguration(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.CreateAlertRule(context.Background(), &models.AlertRule{Name: "old", Enabled: true}); err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+	if err := s.SaveCostCenterAssignment(context.Background(), &models.CostCenterAssignment{Match: "10.0.0.0/8", CostCenter: "old"}); err != nil {
+		t.Fatalf("SaveCostCenterAssignment: %v", err)
+	}
+	if err := s.SaveProfile(context.Background(), &models.Profile{Name: "kept", Config: models.DefaultServerConfig()}); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+
+	cfg := models.DefaultServerConfig()
+	cfg.Allowlist = []string{"192.168.0.0/16"}
+	bundle := &models.ConfigBundle{
+		AlertRules: []models.AlertRule{
+			{ID: 42, Name: "new", WebhookURL: "https://hooks.example.com/a", Enabled: true},
+		},
+		CostCenterAssignments: []models.CostCenterAssignment{
+			{Match: "192.168.0.0/16", CostCenter: "branch"},
+		},
+		DesiredState: &models.DesiredState{Running: true, Config: cfg},
+	}
+	if err := s.ReplaceConfiguration(context.Background(), bundle); err != nil {
+		t.Fatalf("ReplaceConfiguration: %v", err)
+	}
+
+	rules, err := s.ListAlertRules(context.Background())
+	if err != nil {
+		t.Fatalf("ListAlertRules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "new" || rules[0].WebhookURL != "https://hooks.example.com/a" {
+		t.Errorf("rules = %+v, want only the imported rule", rules)
+	}
+
+	assignments, err := s.ListCostCenterAssignments(context.Background())
+	if err != nil {
+		t.Fatalf("ListCostCenterAssignments: %v", err)
+	}
+	if len(assignments) != 1 || assignments[0].CostCenter != "branch" {
+		t.Errorf("assignments = %+v, want only the imported assignment", assignments)
+	}
+
+	// A bundle without profiles leaves them alone; an empty list clears them
+	if profiles, _ := s.ListProfiles(context.Background()); len(profiles) != 1 || profiles[0].Name != "kept" {
+		t.Errorf("profiles = %+v, want the existing profile kept", profiles)
+	}
+	if err := s.ReplaceConfiguration(context.Background(), &models.ConfigBundle{Profiles: []models.Profile{}}); err != nil {
+		t.Fatalf("ReplaceConfiguration: %v", err)
+	}
+	if profiles, _ := s.ListProfiles(context.Background()); len(profiles) != 0 {
+		t.Errorf("profiles = %+v, want none after importing an empty list", profiles)
+	}
+
+	desired, err := s.LatestDesiredState(context.Background())
+	if err != nil {
+		t.Fatalf("LatestDesiredState: %v", err)
+	}
+	if !desired.Running || len(desired.Config.Allowlist) != 1 || desired.Version != bundle.DesiredState.Version {
+		t.Errorf("desired state = %+v, want the imported declaration", desired)
+	}
+}
+
+func TestReplaceConfiguration_RollsBackOnFailure(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.CreateAlertRule(context.Background(), &models.AlertRule{Name: "kept", Enabled: true}); err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+
+	// Without the assignments table the import fails after replacing rules
+	bundle := &models.ConfigBundle{
+		AlertRules: []models.AlertRule{{Name: "replacement", Enabled: true}},
+	}
+	s.db.Exec("DROP TABLE cost_center_assignments")
+	if err := s.ReplaceConfiguration(context.Background(), bundle); err == nil {
+		t.Fatal("ReplaceConfiguration succeeded without the assignments table")
+	}
+
+	rules, err := s.ListAlertRules(context.Background())
+	if err != nil {
+		t.Fatalf("ListAlertRules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "kept" {
+		t.Errorf("rules = %+v, want the original rule after a failed import", rules)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveLinkCapacity stores a declared capacity, replacing the capacity of an
+// existing declaration with the same match.
+func (s *SQLiteStorage) SaveLinkCapacity(ctx context.Context, c *models.LinkCapacity) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return saveLinkCapacity(ctx, s.db, c)
+}
+
+func saveLinkCapacity(ctx context.Context, db execer, c *models.LinkCapacity) error {
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now().UTC()
+	}
+
+	upsertSQL := `
+	INSERT INTO link_capacities (match, capacity, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(match) DO UPDATE SET capacity = excluded.capacity
+	RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(ctx, upsertSQL, c.Match, c.Capacity, c.CreatedAt).Scan(&c.ID, &c.CreatedAt)
+}
+
+// ListLinkCapacities returns all declared capacities ordered by match.
+func (s *SQLiteStorage) ListLinkCapacities(ctx context.Context) ([]models.LinkCapacity, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, match, capacity, created_at
+	FROM link_capacities
+	ORDER BY match
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var capacities []models.LinkCapacity
+	for rows.Next() {
+		var c models.LinkCapacity
+		if err := rows.Scan(&c.ID, &c.Match, &c.Capacity, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		capacities = append(capacities, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return capacities, nil
+}
+
+// DeleteLinkCapacity removes a declared capacity by ID.
+func (s *SQLiteStorage) DeleteLinkCapacity(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM link_capacities WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveCollision records a connection turned away by a busy server and sets
+// its ID.
+func (s *SQLiteStorage) SaveCollision(ctx context.Context, c *models.Collision) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if c.Timestamp.IsZero() {
+		c.Timestamp = time.Now()
+	}
+	c.Timestamp = c.Timestamp.UTC()
+
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO collisions (timestamp, server_port, busy_session_id, busy_client_ip) VALUES (?, ?, ?, ?)",
+		c.Timestamp, c.ServerPort, c.BusySessionID, c.BusyClientIP,
+	)
+	if err != nil {
+		return err
+	}
+
+	c.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetCollisionsBetween returns collisions in [from, to), oldest first.
+func (s *SQLiteStorage) GetCollisionsBetween(ctx context.Context, from, to time.Time) ([]models.Collision, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, timestamp, server_port, busy_session_id, busy_client_ip
+	FROM collisions
+	WHERE timestamp >= ? AND timestamp < ?
+	ORDER BY timestamp, id
+	`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collisions []models.Collision
+	for rows.Next() {
+		var c models.Collision
+		if err := rows.Scan(&c.ID, &c.Timestamp, &c.ServerPort, &c.BusySessionID, &c.BusyClientIP); err != nil {
+			return nil, err
+		}
+		collisions = append(collisions, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collisions, nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestCollisions(t *testing.T) {
+	s := newTestStorage(t)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, port := range []int{5201, 5202, 5201} {
+		c := &models.Collision{
+			Timestamp:     base.Add(time.Duration(i) * time.Hour),
+			ServerPort:    port,
+			BusySessionID: "sess",
+			BusyClientIP:  "10.0.0.1",
+		}
+		if err := s.SaveCollision(context.Background(), c); err != nil {
+			t.Fatalf("SaveCollision: %v", err)
+		}
+		if c.ID == 0 {
+			t.Error("SaveCollision did not set ID")
+		}
+	}
+
+	got, err := s.GetCollisionsBetween(context.Background(), base.Add(30*time.Minute), base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("GetCollisionsBetween: %v", err)
+	}
+	if len(got) != 1 || got[0].ServerPort != 5202 || got[0].BusyClientIP != "10.0.0.1" || got[0].BusySessionID != "sess" {
+		t.Errorf("collisions = %+v, want the one on port 5202", got)
+	}
+	if !got[0].Timestamp.Equal(base.Add(time.Hour)) {
+		t.Errorf("timestamp = %v, want %v", got[0].Timestamp, base.Add(time.Hour))
+	}
+}
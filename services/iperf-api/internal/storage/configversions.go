@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveConfigVersion records a newly applied configuration and sets its ID.
+// Versions are never updated.
+func (s *SQLiteStorage) SaveConfigVersion(ctx context.Context, v *models.ConfigVersion) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if v.AppliedAt.IsZero() {
+		v.AppliedAt = time.Now()
+	}
+	v.AppliedAt = v.AppliedAt.UTC()
+
+	config, err := json.Marshal(v.Config)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO config_versions (applied_at, config) VALUES (?, ?)",
+		v.AppliedAt, string(config),
+	)
+	if err != nil {
+		return err
+	}
+
+	v.ID, err = res.LastInsertId()
+	return err
+}
+
+// LatestConfigVersion returns the most recently applied configuration, or
+// ErrNotFound if none has been recorded.
+func (s *SQLiteStorage) LatestConfigVersion(ctx context.Context) (*models.ConfigVersion, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, applied_at, config
+	FROM config_versions
+	ORDER BY id DESC
+	LIMIT 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions, err := scanConfigVersions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return &versions[0], nil
+}
+
+// GetConfigVersionsUntil returns all versions applied at or before to, oldest
+// first.
+func (s *SQLiteStorage) GetConfigVersionsUntil(ctx context.Context, to time.Time) ([]models.ConfigVersion, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, applied_at, config
+	FROM config_versions
+	WHERE applied_at <= ?
+	ORDER BY id
+	`, to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanConfigVersions(rows)
+}
+
+func scanConfigVersions(rows *sql.Rows) ([]models.ConfigVersion, error) {
+	var versions []models.ConfigVersion
+	for rows.Next() {
+		var v models.ConfigVersion
+		var config string
+		if err := rows.Scan(&v.ID, &v.AppliedAt, &config); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(config), &v.Config); err != nil {
+			return nil, fmt.Errorf("decoding config version %d: %w", v.ID, err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestConfigVersions(t *testing.T) {
+	s := newTestStorage(t)
+
+	if _, err := s.LatestConfigVersion(context.Background()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LatestConfigVersion on empty db err = %v, want ErrNotFound", err)
+	}
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := models.DefaultServerConfig()
+	for i, port := range []int{5201, 5202, 5203} {
+		cfg.Port = port
+		cfg.Allowlist = []string{"10.0.0.0/8"}
+		v := &models.ConfigVersion{AppliedAt: base.Add(time.Duration(i) * time.Hour), Config: cfg}
+		if err := s.SaveConfigVersion(context.Background(), v); err != nil {
+			t.Fatalf("SaveConfigVersion: %v", err)
+		}
+	}
+
+	latest, err := s.LatestConfigVersion(context.Background())
+	if err != nil {
+		t.Fatalf("LatestConfigVersion: %v", err)
+	}
+	if latest.Config.Port != 5203 || len(latest.Config.Allowlist) != 1 {
+		t.Errorf("latest = %+v, want port 5203 with allowlist", latest)
+	}
+
+	versions, err := s.GetConfigVersionsUntil(context.Background(), base.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("GetConfigVersionsUntil: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Config.Port != 5201 || versions[1].Config.Port != 5202 {
+		t.Errorf("versions = %+v, want ports 5201 and 5202", versions)
+	}
+}
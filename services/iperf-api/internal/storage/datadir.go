@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DatabaseFile is the name of the SQLite database in the data directory
+const DatabaseFile = "iperf.db"
+
+// OpenDataDir opens the database in dataDir, creating the directory and
+// database if needed. When dataDir is on a read-only filesystem an
+// existing database is opened read-only instead, and readOnly is set.
+func OpenDataDir(dataDir string, opts ...Option) (s *SQLiteStorage, readOnly bool, err error) {
+	dbPath := filepath.Join(dataDir, DatabaseFile)
+	err = os.MkdirAll(dataDir, 0755)
+	if err == nil {
+		err = probeWritable(dataDir)
+	}
+	if errors.Is(err, syscall.EROFS) {
+		if _, statErr := os.Stat(dbPath); statErr == nil {
+			s, err = NewSQLiteStorage(dbPath, append(opts, WithReadOnly())...)
+			return s, err == nil, err
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	s, err = NewSQLiteStorage(dbPath, opts...)
+	return s, false, err
+}
+
+// probeWritable creates and removes a file in dir, since a directory's
+// permissions do not say whether its filesystem is mounted read-only.
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
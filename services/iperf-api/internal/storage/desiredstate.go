@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveDesiredState records a new desired state declaration and sets its
+// version. Declarations are never updated.
+func (s *SQLiteStorage) SaveDesiredState(ctx context.Context, d *models.DesiredState) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return saveDesiredState(ctx, s.db, d)
+}
+
+func saveDesiredState(ctx context.Context, db execer, d *models.DesiredState) error {
+	if d.DeclaredAt.IsZero() {
+		d.DeclaredAt = time.Now()
+	}
+	d.DeclaredAt = d.DeclaredAt.UTC()
+
+	config, err := json.Marshal(d.Config)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.ExecContext(ctx,
+		"INSERT INTO desired_states (declared_at, running, auto_correct, config) VALUES (?, ?, ?, ?)",
+		d.DeclaredAt, d.Running, d.AutoCorrect, string(config),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.Version, err = res.LastInsertId()
+	return err
+}
+
+// LatestDesiredState returns the current desired state, or ErrNotFound if
+// none has been declared.
+func (s *SQLiteStorage) LatestDesiredState(ctx context.Context) (*models.DesiredState, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	states, err := s.queryDesiredStates(ctx, "ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, ErrNotFound
+	}
+	return &states[0], nil
+}
+
+// GetDesiredStates returns every declared version, newest first.
+func (s *SQLiteStorage) GetDesiredStates(ctx context.Context) ([]models.DesiredState, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.queryDesiredStates(ctx, "ORDER BY id DESC")
+}
+
+func (s *SQLiteStorage) queryDesiredStates(ctx context.Context, order string) ([]models.DesiredState, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, declared_at, running, auto_correct, config
+	FROM desired_states
+	`+order)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDesiredStates(rows)
+}
+
+func scanDesiredStates(rows *sql.Rows) ([]models.DesiredState, error) {
+	var states []models.DesiredState
+	for rows.Next() {
+		var d models.DesiredState
+		var config string
+		if err := rows.Scan(&d.Version, &d.DeclaredAt, &d.Running, &d.AutoCorrect, &config); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(config), &d.Config); err != nil {
+			return nil, fmt.Errorf("decoding desired state %d: %w", d.Version, err)
+		}
+		states = append(states, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
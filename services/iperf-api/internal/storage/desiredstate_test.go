@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestDesiredStates(t *testing.T) {
+	s := newTestStorage(t)
+
+	if _, err := s.LatestDesiredState(context.Background()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LatestDesiredState on empty db err = %v, want ErrNotFound", err)
+	}
+
+	cfg := models.DefaultServerConfig()
+	for _, port := range []int{5201, 5202} {
+		cfg.Port = port
+		d := &models.DesiredState{Running: true, AutoCorrect: port == 5202, Config: cfg}
+		if err := s.SaveDesiredState(context.Background(), d); err != nil {
+			t.Fatalf("SaveDesiredState: %v", err)
+		}
+		if d.Version == 0 || d.DeclaredAt.IsZero() {
+			t.Errorf("saved state = %+v, want version and declaredAt set", d)
+		}
+	}
+
+	latest, err := s.LatestDesiredState(context.Background())
+	if err != nil {
+		t.Fatalf("LatestDesiredState: %v", err)
+	}
+	if latest.Config.Port != 5202 || !latest.Running || !latest.AutoCorrect {
+		t.Errorf("latest = %+v, want running port 5202 with auto-correct", latest)
+	}
+
+	all, err := s.GetDesiredStates(context.Background())
+	if err != nil {
+		t.Fatalf("GetDesiredStates: %v", err)
+	}
+	if len(all) != 2 || all[1].Config.Port != 5201 || all[1].AutoCorrect {
+		t.Errorf("states = %+v, want 2 newest first", all)
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveLatencyResult records a round of a latency probe and sets its ID.
+func (s *SQLiteStorage) SaveLatencyResult(ctx context.Context, r *models.LatencyResult) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	r.Timestamp = r.Timestamp.UTC()
+
+	res, err := s.db.ExecContext(ctx, `
+	INSERT INTO latency_results (probe_id, target, method, timestamp, sent, received, packet_loss,
+		rtt_min, rtt_avg, rtt_max, rtt_stddev, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.ProbeID, r.Target, r.Method, r.Timestamp, r.Sent, r.Received, r.PacketLoss,
+		r.RTTMin, r.RTTAvg, r.RTTMax, r.RTTStdDev, r.Error)
+	if err != nil {
+		return err
+	}
+
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetLatencyResults returns latency results, newest first, for one target
+// or for all if target is empty.
+func (s *SQLiteStorage) GetLatencyResults(ctx context.Context, target string, limit, offset int) ([]models.LatencyResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, probe_id, target, method, timestamp, sent, received, packet_loss,
+		rtt_min, rtt_avg, rtt_max, rtt_stddev, error
+	FROM latency_results
+	WHERE ? = '' OR target = ?
+	ORDER BY timestamp DESC, id DESC
+	LIMIT ? OFFSET ?
+	`, target, target, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.LatencyResult
+	for rows.Next() {
+		var r models.LatencyResult
+		if err := rows.Scan(&r.ID, &r.ProbeID, &r.Target, &r.Method, &r.Timestamp, &r.Sent, &r.Received, &r.PacketLoss,
+			&r.RTTMin, &r.RTTAvg, &r.RTTMax, &r.RTTStdDev, &r.Error); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestLatencyResults(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rtt := 4.25
+	for i, target := range []string{"192.0.2.1", "example.com:443", "192.0.2.1"} {
+		r := &models.LatencyResult{
+			ProbeID: "probe", Target: target, Method: models.ProbeMethodTCP,
+			Timestamp: base.Add(time.Duration(i) * time.Minute), Sent: 4, Received: 4,
+			RTTMin: &rtt, RTTAvg: &rtt, RTTMax: &rtt, RTTStdDev: &rtt,
+		}
+		if i == 1 {
+			// Nothing answered
+			r.Received, r.PacketLoss, r.RTTMin, r.RTTAvg, r.RTTMax, r.RTTStdDev, r.Error = 0, 100, nil, nil, nil, nil, "timed out"
+		}
+		if err := s.SaveLatencyResult(ctx, r); err != nil {
+			t.Fatalf("SaveLatencyResult: %v", err)
+		}
+		if r.ID == 0 {
+			t.Error("SaveLatencyResult did not set ID")
+		}
+	}
+
+	got, err := s.GetLatencyResults(ctx, "192.0.2.1", 10, 0)
+	if err != nil {
+		t.Fatalf("GetLatencyResults: %v", err)
+	}
+	if len(got) != 2 || !got[0].Timestamp.Equal(base.Add(2*time.Minute)) || got[0].RTTAvg == nil || *got[0].RTTAvg != rtt {
+		t.Errorf("results for 192.0.2.1 = %+v, want two, newest first", got)
+	}
+
+	all, err := s.GetLatencyResults(ctx, "", 10, 0)
+	if err != nil {
+		t.Fatalf("GetLatencyResults: %v", err)
+	}
+	if len(all) != 3 || all[1].Target != "example.com:443" || all[1].RTTAvg != nil || all[1].Error != "timed out" || all[1].PacketLoss != 100 {
+		t.Errorf("all results = %+v", all)
+	}
+}
@@ -0,0 +1,1290 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Memory keeps everything in memory for disposable demo instances and
+// tests, with the same behavior as SQLiteStorage but nothing on disk and
+// no cgo. Records are copied in and out, so callers never share them.
+type Memory struct {
+	mu sync.RWMutex
+
+	results []models.TestResult
+	samples map[string][]models.IntervalSample
+	raw     map[string][]byte
+
+	assignments    []models.CostCenterAssignment
+	capacities     []models.LinkCapacity
+	alertRules     []models.AlertRule
+	audit          []models.AuditEntry
+	collisions     []models.Collision
+	latency        []models.LatencyResult
+	mtu            []models.MTUResult
+	traceroutes    []models.Traceroute
+	configVersions []models.ConfigVersion
+	desiredStates  []models.DesiredState
+	profiles       map[string]models.Profile
+	peers          map[string]models.Peer
+
+	// lastID holds each table's last assigned ID; like SQLite's
+	// AUTOINCREMENT, IDs are never reused after a delete
+	lastID map[string]int64
+
+	statusMu sync.Mutex
+	status   models.StatsCacheStatus
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		samples:  make(map[string][]models.IntervalSample),
+		raw:      make(map[string][]byte),
+		profiles: make(map[string]models.Profile),
+		peers:    make(map[string]models.Peer),
+		lastID:   make(map[string]int64),
+	}
+}
+
+// clone returns a deep copy of v by a JSON round trip, which also gives
+// back what SQLite would after storing v's JSON columns, such as audit
+// parameters decoded as JSON values. Test results, which have no JSON
+// column for most fields, are copied by copyResult.
+func clone[T any](v T) T {
+	var c T
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("storage: copying %T: %v", v, err))
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		panic(fmt.Sprintf("storage: copying %T: %v", v, err))
+	}
+	return c
+}
+
+// nextID assigns the next ID in table. The caller holds mu for writing.
+func (m *Memory) nextID(table string) int64 {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// page applies LIMIT and OFFSET as SQLite does: a negative limit is no
+// limit.
+func page[T any](items []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return nil
+		}
+		items = items[offset:]
+	}
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// GetTestResult returns a single test result by ID, or ErrNotFound.
+func (m *Memory) GetTestResult(ctx context.Context, id string) (*models.TestResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.resultIndex(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	r := copyResult(m.results[i])
+	return &r, nil
+}
+
+// resultIndex returns the position of the result with id, or -1. The
+// caller holds mu.
+func (m *Memory) resultIndex(id string) int {
+	for i := range m.results {
+		if m.results[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// SaveTestResult stores a test result, filling in its ID, timestamp and
+// status as SQLiteStorage does.
+func (m *Memory) SaveTestResult(ctx context.Context, result *models.TestResult) error {
+	return m.SaveTestResultWithSamples(ctx, result, nil)
+}
+
+// SaveTestResultWithSamples stores a result along with its interval
+// samples.
+func (m *Memory) SaveTestResultWithSamples(ctx context.Context, result *models.TestResult, samples []models.IntervalSample) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if result.ID == "" {
+		result.ID = uuid.New().String()
+	}
+	if result.Timestamp.IsZero() {
+		result.Timestamp = time.Now()
+	}
+	if result.Status == "" {
+		result.Status = models.TestStatusCompleted
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.resultIndex(result.ID) >= 0 {
+		return fmt.Errorf("test result %s already exists", result.ID)
+	}
+	if result.CorrelationID != "" {
+		for _, r := range m.results {
+			if r.Site == result.Site && r.Source == result.Source && r.CorrelationID == result.CorrelationID {
+				return fmt.Errorf("correlation ID %q is already used by result %s", result.CorrelationID, r.ID)
+			}
+		}
+	}
+
+	stored := copyResult(*result)
+	stored.Timestamp = stored.Timestamp.UTC()
+	m.results = append(m.results, stored)
+	if len(samples) > 0 {
+		copied := make([]models.IntervalSample, len(samples))
+		for i, smp := range samples {
+			smp.Timestamp = smp.Timestamp.UTC()
+			copied[i] = smp
+		}
+		m.samples[result.ID] = copied
+	}
+	return nil
+}
+
+// UpdateTestResultNotes replaces the tags and note of a stored result.
+func (m *Memory) UpdateTestResultNotes(ctx context.Context, id string, tags []string, note string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.resultIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.results[i].Tags = copySlice(tags)
+	m.results[i].Note = note
+	return nil
+}
+
+// UpdateTestResult replaces every field of a stored result.
+func (m *Memory) UpdateTestResult(ctx context.Context, result *models.TestResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.resultIndex(result.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	stored := copyResult(*result)
+	stored.Timestamp = stored.Timestamp.UTC()
+	m.results[i] = stored
+	return nil
+}
+
+// GetTestResults returns results newest first, with pagination.
+func (m *Memory) GetTestResults(ctx context.Context, limit, offset int) ([]models.TestResult, error) {
+	return m.QueryTestResults(ctx, HistoryFilter{}, limit, offset)
+}
+
+// GetTestResultsByClientIP returns one client's results newest first, with
+// pagination.
+func (m *Memory) GetTestResultsByClientIP(ctx context.Context, clientIP string, limit, offset int) ([]models.TestResult, error) {
+	return m.QueryTestResults(ctx, HistoryFilter{ClientIP: clientIP}, limit, offset)
+}
+
+// QueryTestResults returns results matching the filter newest first, with
+// pagination.
+func (m *Memory) QueryTestResults(ctx context.Context, filter HistoryFilter, limit, offset int) ([]models.TestResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []models.TestResult
+	for i := len(m.results) - 1; i >= 0; i-- {
+		if filter.matches(&m.results[i]) {
+			matched = append(matched, m.results[i])
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	return copyResults(page(matched, limit, offset)), nil
+}
+
+// matches reports whether r passes the filter, as the clause from where
+// would.
+func (f HistoryFilter) matches(r *models.TestResult) bool {
+	var geo models.GeoInfo
+	if r.Geo != nil {
+		geo = *r.Geo
+	}
+	switch {
+	case f.ClientIP != "" && r.ClientIP != f.ClientIP,
+		f.QualityFlag != "" && !contains(r.QualityFlags, f.QualityFlag),
+		f.ExcludeFlagged && len(r.QualityFlags) > 0,
+		f.Source != "" && r.Source != f.Source,
+		f.CorrelationID != "" && r.CorrelationID != f.CorrelationID,
+		f.Country != "" && geo.Country != strings.ToUpper(f.Country),
+		f.ASN != 0 && geo.ASN != f.ASN,
+		f.Tag != "" && !contains(r.Tags, f.Tag),
+		f.Site != "" && r.Site != f.Site,
+		f.Local && r.Site != "":
+		return false
+	}
+	return true
+}
+
+func contains[T comparable](items []T, v T) bool {
+	for _, item := range items {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// copyResult returns a deep copy of r. Like scanTestResults, it leaves
+// out an empty location.
+func copyResult(r models.TestResult) models.TestResult {
+	r.Retransmits = copyPtr(r.Retransmits)
+	r.Jitter = copyPtr(r.Jitter)
+	r.PacketLoss = copyPtr(r.PacketLoss)
+	r.RequestedDuration = copyPtr(r.RequestedDuration)
+	r.EnergyJoules = copyPtr(r.EnergyJoules)
+	r.JoulesPerGB = copyPtr(r.JoulesPerGB)
+	r.HostCPUTotal = copyPtr(r.HostCPUTotal)
+	r.RemoteCPUTotal = copyPtr(r.RemoteCPUTotal)
+	r.Sender = copySide(r.Sender)
+	r.Receiver = copySide(r.Receiver)
+	r.Percentiles = copyPtr(r.Percentiles)
+	r.RetransmitsPerGB = copyPtr(r.RetransmitsPerGB)
+	r.GoodputRatio = copyPtr(r.GoodputRatio)
+	r.JitterRatio = copyPtr(r.JitterRatio)
+	r.LinkCapacity = copyPtr(r.LinkCapacity)
+	r.Utilization = copyPtr(r.Utilization)
+	if r.Client != nil {
+		fp := *r.Client
+		fp.Features = copySlice(fp.Features)
+		r.Client = &fp
+	}
+	if r.Geo != nil && *r.Geo == (models.GeoInfo{}) {
+		r.Geo = nil
+	}
+	r.Geo = copyPtr(r.Geo)
+	r.QualityFlags = copySlice(r.QualityFlags)
+	r.Tags = copySlice(r.Tags)
+	return r
+}
+
+func copyResults(results []models.TestResult) []models.TestResult {
+	if len(results) == 0 {
+		return nil
+	}
+	copies := make([]models.TestResult, len(results))
+	for i, r := range results {
+		copies[i] = copyResult(r)
+	}
+	return copies
+}
+
+func copyPtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// copySide copies one end of a test.
+func copySide(s *models.SideStats) *models.SideStats {
+	if s == nil {
+		return nil
+	}
+	side := *s
+	side.Retransmits = copyPtr(s.Retransmits)
+	return &side
+}
+
+// copySlice copies items, giving nil for none as a column read back would.
+func copySlice[T any](items []T) []T {
+	if len(items) == 0 {
+		return nil
+	}
+	return append([]T(nil), items...)
+}
+
+func cloneAll[T any](items []T) []T {
+	if len(items) == 0 {
+		return nil
+	}
+	copies := make([]T, len(items))
+	for i, item := range items {
+		copies[i] = clone(item)
+	}
+	return copies
+}
+
+// GetTestResultsBetween returns every test result with a timestamp in
+// [from, to), oldest first.
+func (m *Memory) GetTestResultsBetween(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := m.resultsBetween(from, to)
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.Before(matched[j].Timestamp)
+	})
+	return copyResults(matched), nil
+}
+
+// resultsBetween returns the results in [from, to) in the order they were
+// saved. The caller holds mu.
+func (m *Memory) resultsBetween(from, to time.Time) []models.TestResult {
+	var matched []models.TestResult
+	for _, r := range m.results {
+		if !r.Timestamp.Before(from) && r.Timestamp.Before(to) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// GetTotalCount returns the number of stored results.
+func (m *Memory) GetTotalCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.results), nil
+}
+
+// LatestResultTime returns the timestamp of the newest result matching the
+// filter, or the zero time if none does.
+func (m *Memory) LatestResultTime(ctx context.Context, filter HistoryFilter) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest time.Time
+	for i := range m.results {
+		if filter.matches(&m.results[i]) && m.results[i].Timestamp.After(latest) {
+			latest = m.results[i].Timestamp
+		}
+	}
+	return latest, nil
+}
+
+// CountTestResults returns the number of results matching the filter.
+func (m *Memory) CountTestResults(ctx context.Context, filter HistoryFilter) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for i := range m.results {
+		if filter.matches(&m.results[i]) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetTestSamples returns the interval samples of a stored result in order.
+// A result saved without samples has none; an unknown result is ErrNotFound.
+func (m *Memory) GetTestSamples(ctx context.Context, resultID string) ([]models.IntervalSample, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.resultIndex(resultID) < 0 {
+		return nil, ErrNotFound
+	}
+	return append([]models.IntervalSample{}, m.samples[resultID]...), nil
+}
+
+// SaveRawOutput keeps the server output a stored result was parsed from,
+// replacing any kept before.
+func (m *Memory) SaveRawOutput(ctx context.Context, resultID string, output []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.resultIndex(resultID) < 0 {
+		return ErrNotFound
+	}
+	m.raw[resultID] = bytes.Clone(output)
+	return nil
+}
+
+// GetRawOutput returns the server output a stored result was parsed from. A
+// result without it, or an unknown result, is ErrNotFound.
+func (m *Memory) GetRawOutput(ctx context.Context, resultID string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	output, ok := m.raw[resultID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(output), nil
+}
+
+// ListRawOutputIDs returns the IDs of the results with archived output,
+// oldest first.
+func (m *Memory) ListRawOutputIDs(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var results []models.TestResult
+	for _, r := range m.results {
+		if _, ok := m.raw[r.ID]; ok {
+			results = append(results, r)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if !results[i].Timestamp.Equal(results[j].Timestamp) {
+			return results[i].Timestamp.Before(results[j].Timestamp)
+		}
+		return results[i].ID < results[j].ID
+	})
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids, nil
+}
+
+// ResultStatsStatus reports the statistics as always ready, since they are
+// counted from the results on every call.
+func (m *Memory) ResultStatsStatus() models.StatsCacheStatus {
+	m.mu.RLock()
+	var rollup models.StatsRollup
+	for _, r := range m.results {
+		rollup.Add(r)
+	}
+	m.mu.RUnlock()
+
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	status := m.status
+	status.Ready = true
+	status.Rows = len(rollup.Stats())
+	if status.LastRebuild != nil {
+		at := *status.LastRebuild
+		status.LastRebuild = &at
+	}
+	return status
+}
+
+// WarmResultStats has nothing to do; there is no rollup to bring up to
+// date.
+func (m *Memory) WarmResultStats(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// RebuildResultStats only records the rebuild, so the status reads as it
+// would after rebuilding SQLiteStorage's rollup.
+func (m *Memory) RebuildResultStats(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	at := time.Now()
+	m.status.LastRebuild = &at
+	m.status.LastDurationMs = 0
+	m.status.LastError = ""
+	return nil
+}
+
+// GetResultStatsBetween returns the hourly rollup of results with a timestamp
+// in [from, to), ordered by hour, client and port.
+func (m *Memory) GetResultStatsBetween(ctx context.Context, from, to time.Time) ([]models.ResultStat, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Counting every hour from the results gives what SQLiteStorage reads
+	// from its rollup
+	return m.countResultStats(from, to), nil
+}
+
+// countResultStats rolls up the results in [from, to). The caller holds mu.
+func (m *Memory) countResultStats(from, to time.Time) []models.ResultStat {
+	if !from.Before(to) {
+		return nil
+	}
+	var rollup models.StatsRollup
+	for _, r := range m.resultsBetween(from, to) {
+		rollup.Add(r)
+	}
+	return rollup.Stats()
+}
+
+// SaveCostCenterAssignment stores an assignment, replacing the cost center of
+// an existing assignment with the same match.
+func (m *Memory) SaveCostCenterAssignment(ctx context.Context, a *models.CostCenterAssignment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveCostCenterAssignment(a)
+	return nil
+}
+
+// saveCostCenterAssignment upserts a. The caller holds mu for writing.
+func (m *Memory) saveCostCenterAssignment(a *models.CostCenterAssignment) {
+	for i := range m.assignments {
+		if m.assignments[i].Match == a.Match {
+			m.assignments[i].CostCenter = a.CostCenter
+			a.ID, a.CreatedAt = m.assignments[i].ID, m.assignments[i].CreatedAt
+			return
+		}
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	a.CreatedAt = a.CreatedAt.UTC()
+	a.ID = m.nextID("cost_center_assignments")
+	m.assignments = append(m.assignments, *a)
+}
+
+// ListCostCenterAssignments returns all assignments ordered by match.
+func (m *Memory) ListCostCenterAssignments(ctx context.Context) ([]models.CostCenterAssignment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	assignments := cloneAll(m.assignments)
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].Match < assignments[j].Match })
+	return assignments, nil
+}
+
+// DeleteCostCenterAssignment removes an assignment by ID.
+func (m *Memory) DeleteCostCenterAssignment(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.assignments {
+		if m.assignments[i].ID == id {
+			m.assignments = append(m.assignments[:i], m.assignments[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// SaveLinkCapacity stores a declared capacity, replacing the capacity of an
+// existing declaration with the same match.
+func (m *Memory) SaveLinkCapacity(ctx context.Context, c *models.LinkCapacity) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveLinkCapacity(c)
+	return nil
+}
+
+// saveLinkCapacity upserts c. The caller holds mu for writing.
+func (m *Memory) saveLinkCapacity(c *models.LinkCapacity) {
+	// SQLite allocates an ID before finding the conflict, so replacing a
+	// declaration uses one up too
+	id := m.nextID("link_capacities")
+	for i := range m.capacities {
+		if m.capacities[i].Match == c.Match {
+			m.capacities[i].Capacity = c.Capacity
+			c.ID, c.CreatedAt = m.capacities[i].ID, m.capacities[i].CreatedAt
+			return
+		}
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	c.CreatedAt = c.CreatedAt.UTC()
+	c.ID = id
+	m.capacities = append(m.capacities, *c)
+}
+
+// ListLinkCapacities returns all declared capacities ordered by match.
+func (m *Memory) ListLinkCapacities(ctx context.Context) ([]models.LinkCapacity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	capacities := cloneAll(m.capacities)
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i].Match < capacities[j].Match })
+	return capacities, nil
+}
+
+// DeleteLinkCapacity removes a declared capacity by ID.
+func (m *Memory) DeleteLinkCapacity(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.capacities {
+		if m.capacities[i].ID == id {
+			m.capacities = append(m.capacities[:i], m.capacities[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// CreateAlertRule stores a new alert rule and sets its ID.
+func (m *Memory) CreateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.createAlertRule(rule)
+	return nil
+}
+
+// createAlertRule adds rule. The caller holds mu for writing.
+func (m *Memory) createAlertRule(rule *models.AlertRule) {
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+	rule.CreatedAt = rule.CreatedAt.UTC()
+	rule.ID = m.nextID("alert_rules")
+	m.alertRules = append(m.alertRules, clone(*rule))
+}
+
+// UpdateAlertRule replaces the thresholds and settings of an existing rule.
+func (m *Memory) UpdateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.alertRules {
+		if m.alertRules[i].ID == rule.ID {
+			rule.CreatedAt = m.alertRules[i].CreatedAt
+			m.alertRules[i] = clone(*rule)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetAlertRule returns the rule with the given ID.
+func (m *Memory) GetAlertRule(ctx context.Context, id int64) (*models.AlertRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rule := range m.alertRules {
+		if rule.ID == id {
+			rule = clone(rule)
+			return &rule, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListAlertRules returns all alert rules ordered by ID.
+func (m *Memory) ListAlertRules(ctx context.Context) ([]models.AlertRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return cloneAll(m.alertRules), nil
+}
+
+// GetEnabledAlertRulesForClient returns the enabled rules that apply to
+// clientIP, including rules that apply to every client.
+func (m *Memory) GetEnabledAlertRulesForClient(ctx context.Context, clientIP string) ([]models.AlertRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rules []models.AlertRule
+	for _, rule := range m.alertRules {
+		if rule.Enabled && (rule.ClientIP == "" || rule.ClientIP == clientIP) {
+			rules = append(rules, rule)
+		}
+	}
+	return cloneAll(rules), nil
+}
+
+// DeleteAlertRule removes an alert rule by ID.
+func (m *Memory) DeleteAlertRule(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.alertRules {
+		if m.alertRules[i].ID == id {
+			m.alertRules = append(m.alertRules[:i], m.alertRules[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// SaveAuditEntry appends an entry to the audit log and sets its ID.
+func (m *Memory) SaveAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	e.Timestamp = e.Timestamp.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = m.nextID("audit_log")
+	m.audit = append(m.audit, clone(*e))
+	return nil
+}
+
+// QueryAuditLog returns audit entries matching the filter, newest first.
+func (m *Memory) QueryAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []models.AuditEntry
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if filter.matches(&m.audit[i]) {
+			matched = append(matched, m.audit[i])
+		}
+	}
+	return cloneAll(page(matched, limit, offset)), nil
+}
+
+// CountAuditLog returns the number of audit entries matching the filter.
+func (m *Memory) CountAuditLog(ctx context.Context, filter AuditFilter) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for i := range m.audit {
+		if filter.matches(&m.audit[i]) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// matches reports whether e passes the filter, as the clause from where
+// would.
+func (f AuditFilter) matches(e *models.AuditEntry) bool {
+	switch {
+	case f.Action != "" && e.Action != f.Action,
+		f.Principal != "" && e.APIKey != f.Principal && e.RemoteIP != f.Principal,
+		!f.From.IsZero() && e.Timestamp.Before(f.From),
+		!f.To.IsZero() && e.Timestamp.After(f.To):
+		return false
+	}
+	return true
+}
+
+// SaveCollision records a connection turned away by a busy server and sets
+// its ID.
+func (m *Memory) SaveCollision(ctx context.Context, c *models.Collision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.Timestamp.IsZero() {
+		c.Timestamp = time.Now()
+	}
+	c.Timestamp = c.Timestamp.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.ID = m.nextID("collisions")
+	m.collisions = append(m.collisions, *c)
+	return nil
+}
+
+// GetCollisionsBetween returns collisions in [from, to), oldest first.
+func (m *Memory) GetCollisionsBetween(ctx context.Context, from, to time.Time) ([]models.Collision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var collisions []models.Collision
+	for _, c := range m.collisions {
+		if !c.Timestamp.Before(from) && c.Timestamp.Before(to) {
+			collisions = append(collisions, c)
+		}
+	}
+	sort.SliceStable(collisions, func(i, j int) bool {
+		return collisions[i].Timestamp.Before(collisions[j].Timestamp)
+	})
+	return collisions, nil
+}
+
+// SaveLatencyResult records a round of a latency probe and sets its ID.
+func (m *Memory) SaveLatencyResult(ctx context.Context, r *models.LatencyResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	r.Timestamp = r.Timestamp.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = m.nextID("latency_results")
+	m.latency = append(m.latency, clone(*r))
+	return nil
+}
+
+// GetLatencyResults returns latency results, newest first, for one target
+// or for all if target is empty.
+func (m *Memory) GetLatencyResults(ctx context.Context, target string, limit, offset int) ([]models.LatencyResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []models.LatencyResult
+	for i := len(m.latency) - 1; i >= 0; i-- {
+		if target == "" || m.latency[i].Target == target {
+			matched = append(matched, m.latency[i])
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	return clone(page(matched, limit, offset)), nil
+}
+
+// SaveMTUResult records a path MTU discovery and sets its ID.
+func (m *Memory) SaveMTUResult(ctx context.Context, r *models.MTUResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	r.Timestamp = r.Timestamp.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = m.nextID("mtu_results")
+	m.mtu = append(m.mtu, *r)
+	return nil
+}
+
+// GetMTUResults returns path MTU discoveries, newest first, for one target
+// or for all if target is empty.
+func (m *Memory) GetMTUResults(ctx context.Context, target string, limit, offset int) ([]models.MTUResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []models.MTUResult
+	for i := len(m.mtu) - 1; i >= 0; i-- {
+		if target == "" || m.mtu[i].Target == target {
+			matched = append(matched, m.mtu[i])
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	return page(matched, limit, offset), nil
+}
+
+// SaveTraceroute records a finished traceroute.
+func (m *Memory) SaveTraceroute(ctx context.Context, t *models.Traceroute) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t.Timestamp.IsZero() {
+		t.Timestamp = time.Now()
+	}
+	t.Timestamp = t.Timestamp.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.traceroutes = append(m.traceroutes, clone(*t))
+	return nil
+}
+
+// GetTraceroute returns the traceroute with the given ID.
+func (m *Memory) GetTraceroute(ctx context.Context, id string) (*models.Traceroute, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.traceroutes {
+		if t.ID == id {
+			t = clone(t)
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetTraceroutes returns traceroutes, newest first, for one test result or
+// for all if resultID is empty.
+func (m *Memory) GetTraceroutes(ctx context.Context, resultID string, limit, offset int) ([]models.Traceroute, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []models.Traceroute
+	for _, t := range m.traceroutes {
+		if resultID == "" || t.ResultID == resultID {
+			matched = append(matched, clone(t))
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].Timestamp.Equal(matched[j].Timestamp) {
+			return matched[i].Timestamp.After(matched[j].Timestamp)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+	return page(matched, limit, offset), nil
+}
+
+// SaveConfigVersion records a newly applied configuration and sets its ID.
+func (m *Memory) SaveConfigVersion(ctx context.Context, v *models.ConfigVersion) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if v.AppliedAt.IsZero() {
+		v.AppliedAt = time.Now()
+	}
+	v.AppliedAt = v.AppliedAt.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v.ID = m.nextID("config_versions")
+	m.configVersions = append(m.configVersions, clone(*v))
+	return nil
+}
+
+// LatestConfigVersion returns the most recently applied configuration, or
+// ErrNotFound if none has been recorded.
+func (m *Memory) LatestConfigVersion(ctx context.Context) (*models.ConfigVersion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.configVersions) == 0 {
+		return nil, ErrNotFound
+	}
+	v := clone(m.configVersions[len(m.configVersions)-1])
+	return &v, nil
+}
+
+// GetConfigVersionsUntil returns all versions applied at or before to, oldest
+// first.
+func (m *Memory) GetConfigVersionsUntil(ctx context.Context, to time.Time) ([]models.ConfigVersion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var versions []models.ConfigVersion
+	for _, v := range m.configVersions {
+		if !v.AppliedAt.After(to) {
+			versions = append(versions, v)
+		}
+	}
+	return cloneAll(versions), nil
+}
+
+// SaveDesiredState records a new desired state declaration and sets its
+// version.
+func (m *Memory) SaveDesiredState(ctx context.Context, d *models.DesiredState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveDesiredState(d)
+	return nil
+}
+
+// saveDesiredState adds d. The caller holds mu for writing.
+func (m *Memory) saveDesiredState(d *models.DesiredState) {
+	if d.DeclaredAt.IsZero() {
+		d.DeclaredAt = time.Now()
+	}
+	d.DeclaredAt = d.DeclaredAt.UTC()
+	d.Version = m.nextID("desired_states")
+	m.desiredStates = append(m.desiredStates, clone(*d))
+}
+
+// LatestDesiredState returns the current desired state, or ErrNotFound if
+// none has been declared.
+func (m *Memory) LatestDesiredState(ctx context.Context) (*models.DesiredState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.desiredStates) == 0 {
+		return nil, ErrNotFound
+	}
+	d := clone(m.desiredStates[len(m.desiredStates)-1])
+	return &d, nil
+}
+
+// GetDesiredStates returns every declared version, newest first.
+func (m *Memory) GetDesiredStates(ctx context.Context) ([]models.DesiredState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	states := cloneAll(m.desiredStates)
+	for i, j := 0, len(states)-1; i < j; i, j = i+1, j-1 {
+		states[i], states[j] = states[j], states[i]
+	}
+	return states, nil
+}
+
+// SaveProfile stores a profile, replacing the description and configuration
+// of an existing profile with the same name. It sets the profile's
+// timestamps.
+func (m *Memory) SaveProfile(ctx context.Context, p *models.Profile) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveProfile(p)
+	return nil
+}
+
+// saveProfile upserts p. The caller holds mu for writing.
+func (m *Memory) saveProfile(p *models.Profile) {
+	now := time.Now().UTC()
+	p.CreatedAt, p.UpdatedAt = now, now
+	if existing, ok := m.profiles[p.Name]; ok {
+		p.CreatedAt = existing.CreatedAt
+	}
+	m.profiles[p.Name] = clone(*p)
+}
+
+// GetProfile returns the profile with the given name.
+func (m *Memory) GetProfile(ctx context.Context, name string) (*models.Profile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.profiles[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	p = clone(p)
+	return &p, nil
+}
+
+// ListProfiles returns all profiles ordered by name.
+func (m *Memory) ListProfiles(ctx context.Context) ([]models.Profile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var profiles []models.Profile
+	for _, p := range m.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return cloneAll(profiles), nil
+}
+
+// DeleteProfile removes a profile by name.
+func (m *Memory) DeleteProfile(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.profiles[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.profiles, name)
+	return nil
+}
+
+// SavePeer registers a federation peer, replacing the one with the same
+// name.
+func (m *Memory) SavePeer(ctx context.Context, p *models.Peer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.peers[p.Name] = clone(*p)
+	return nil
+}
+
+// ListPeers returns the registered federation peers ordered by name.
+func (m *Memory) ListPeers(ctx context.Context) ([]models.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var peers []models.Peer
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	return cloneAll(peers), nil
+}
+
+// DeletePeer removes a registered peer by name. Results pulled from it are
+// kept.
+func (m *Memory) DeletePeer(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.peers[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.peers, name)
+	return nil
+}
+
+// ReplaceConfiguration replaces every alert rule and cost center assignment
+// with those in b, replaces the profiles if b has any field for them, and
+// declares its desired state, if any, as a new version. Imported records
+// get new IDs and versions.
+func (m *Memory) ReplaceConfiguration(ctx context.Context, b *models.ConfigBundle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.alertRules = nil
+	for i := range b.AlertRules {
+		m.createAlertRule(&b.AlertRules[i])
+	}
+
+	m.assignments = nil
+	for i := range b.CostCenterAssignments {
+		m.saveCostCenterAssignment(&b.CostCenterAssignments[i])
+	}
+
+	if b.Profiles != nil {
+		m.profiles = make(map[string]models.Profile)
+		for i := range b.Profiles {
+			m.saveProfile(&b.Profiles[i])
+		}
+	}
+
+	if b.LinkCapacities != nil {
+		m.capacities = nil
+		for i := range b.LinkCapacities {
+			m.saveLinkCapacity(&b.LinkCapacities[i])
+		}
+	}
+
+	if b.DesiredState != nil {
+		m.saveDesiredState(b.DesiredState)
+	}
+	return nil
+}
+
+// Close releases nothing; the data goes with the process.
+func (m *Memory) Close() error {
+	return nil
+}
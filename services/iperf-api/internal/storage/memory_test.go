@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// exercise runs the same calls against a store and returns what each
+// returned, encoded for comparison. Timestamps the stores set themselves
+// are left out.
+func exercise(t *testing.T, s Store) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	out := make(map[string]string)
+	record := func(name string, v interface{}, err error) {
+		t.Helper()
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, _ := json.Marshal(map[string]interface{}{"value": v, "notFound": errors.Is(err, ErrNotFound)})
+		out[name] = string(data)
+	}
+
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	jitter, loss := 0.5, 1.25
+	results := []*models.TestResult{
+		{ID: "a", Timestamp: base, ClientIP: "10.0.0.1", ServerPort: 5201, BytesTransferred: 100,
+			Protocol: models.ProtocolTCP, Direction: "upload", Tags: []string{"lab"}},
+		{ID: "b", Timestamp: base.Add(20 * time.Minute), ClientIP: "10.0.0.2", ServerPort: 5201, BytesTransferred: 200,
+			Protocol: models.ProtocolUDP, Direction: "upload", Jitter: &jitter, PacketLoss: &loss,
+			QualityFlags: []models.QualityFlag{models.QualityFlagZeroBytes}},
+		{ID: "c", Timestamp: base.Add(90 * time.Minute), ClientIP: "10.0.0.1", ServerPort: 5202, BytesTransferred: 300,
+			Protocol: models.ProtocolTCP, Direction: "download", Source: models.JobSourceCI, CorrelationID: "run-1",
+			Geo: &models.GeoInfo{Country: "GB", ASN: 64500}, Client: &models.ClientFingerprint{Streams: 4}},
+		{ID: "d", Timestamp: base.Add(3 * time.Hour).In(time.FixedZone("CET", 3600)), ClientIP: "10.0.0.3",
+			Protocol: models.ProtocolTCP, Direction: "upload", Status: models.TestStatusFailed},
+		// Pulled from a peer, whose correlation IDs are its own
+		{ID: "e", Timestamp: base.Add(time.Hour), ClientIP: "10.9.0.1", Protocol: models.ProtocolTCP, Direction: "upload",
+			Source: models.JobSourceCI, CorrelationID: "run-1", Site: "branch"},
+	}
+	for _, r := range results {
+		if err := s.SaveTestResultWithSamples(ctx, r, []models.IntervalSample{{Timestamp: r.Timestamp, IntervalEnd: 1, Bytes: 10}}); err != nil {
+			t.Fatalf("SaveTestResultWithSamples(%s): %v", r.ID, err)
+		}
+	}
+	dup := &models.TestResult{Source: models.JobSourceCI, CorrelationID: "run-1", Protocol: models.ProtocolTCP}
+	if err := s.SaveTestResult(ctx, dup); err == nil {
+		t.Error("a second result with the same correlation ID was saved")
+	}
+	record("notes", nil, s.UpdateTestResultNotes(ctx, "b", []string{"x", "y"}, "checked"))
+	record("notesMissing", nil, s.UpdateTestResultNotes(ctx, "zz", nil, ""))
+
+	get, err := s.GetTestResult(ctx, "c")
+	record("get", get, err)
+	get, err = s.GetTestResult(ctx, "zz")
+	record("getMissing", get, err)
+	for name, f := range map[string]HistoryFilter{
+		"all":      {},
+		"client":   {ClientIP: "10.0.0.1"},
+		"flag":     {QualityFlag: models.QualityFlagZeroBytes},
+		"clean":    {ExcludeFlagged: true},
+		"job":      {Source: models.JobSourceCI, CorrelationID: "run-1"},
+		"country":  {Country: "gb", ASN: 64500},
+		"tag":      {Tag: "y"},
+		"untagged": {Tag: "l"},
+		"site":     {Site: "branch"},
+		"local":    {Local: true, ClientIP: "10.0.0.1"},
+	} {
+		list, err := s.QueryTestResults(ctx, f, 10, 0)
+		record("query/"+name, list, err)
+		count, err := s.CountTestResults(ctx, f)
+		record("count/"+name, count, err)
+		latest, err := s.LatestResultTime(ctx, f)
+		record("latest/"+name, latest.UTC(), err)
+	}
+	list, err := s.GetTestResults(ctx, 2, 1)
+	record("page", list, err)
+	list, err = s.GetTestResults(ctx, -1, 3)
+	record("unlimited", list, err)
+	list, err = s.GetTestResultsByClientIP(ctx, "10.0.0.1", 1, 0)
+	record("byClient", list, err)
+	list, err = s.GetTestResultsBetween(ctx, base.Add(time.Minute), base.Add(3*time.Hour))
+	record("between", list, err)
+	count, err := s.GetTotalCount(ctx)
+	record("count", count, err)
+	count, err = s.CountTestResults(ctx, HistoryFilter{ClientIP: "10.0.0.1"})
+	record("countByClient", count, err)
+	stats, err := s.GetResultStatsBetween(ctx, base.Add(10*time.Minute), base.Add(4*time.Hour))
+	record("stats", stats, err)
+	samples, err := s.GetTestSamples(ctx, "a")
+	record("samples", samples, err)
+	samples, err = s.GetTestSamples(ctx, "zz")
+	record("samplesMissing", samples, err)
+	record("raw/save", nil, s.SaveRawOutput(ctx, "a", []byte("Server listening on 5201\n")))
+	record("raw/replace", nil, s.SaveRawOutput(ctx, "a", []byte("Accepted connection from 10.0.0.1, port 50000\n")))
+	record("raw/saveMissing", nil, s.SaveRawOutput(ctx, "zz", []byte("x")))
+	raw, err := s.GetRawOutput(ctx, "a")
+	record("raw", string(raw), err)
+	raw, err = s.GetRawOutput(ctx, "b")
+	record("rawNone", raw, err)
+	rawIDs, err := s.ListRawOutputIDs(ctx)
+	record("rawIDs", rawIDs, err)
+
+	updated, _ := s.GetTestResult(ctx, "b")
+	updated.BytesTransferred, updated.MinBandwidth = 250, 12.5
+	record("update", nil, s.UpdateTestResult(ctx, updated))
+	record("updateMissing", nil, s.UpdateTestResult(ctx, &models.TestResult{ID: "zz"}))
+	get, err = s.GetTestResult(ctx, "b")
+	record("updated", get, err)
+	stats, err = s.GetResultStatsBetween(ctx, base.Add(10*time.Minute), base.Add(4*time.Hour))
+	record("statsUpdated", stats, err)
+
+	for i, action := range []models.AuditAction{models.AuditActionServerStart, models.AuditActionServerStop, models.AuditActionServerStart} {
+		e := &models.AuditEntry{Timestamp: base.Add(time.Duration(i) * time.Minute), Action: action,
+			RemoteIP: "192.0.2.1", Parameters: map[string]interface{}{"port": 5201 + i}}
+		record("audit/save", nil, s.SaveAuditEntry(ctx, e))
+	}
+	filter := AuditFilter{Action: models.AuditActionServerStart, To: base.Add(2 * time.Minute)}
+	entries, err := s.QueryAuditLog(ctx, filter, 10, 0)
+	record("audit/query", entries, err)
+	n, err := s.CountAuditLog(ctx, AuditFilter{Principal: "192.0.2.1", From: base.Add(time.Minute)})
+	record("audit/count", n, err)
+
+	created := base.Add(-time.Hour)
+	rule := &models.AlertRule{Name: "slow", ClientIP: "10.0.0.1", Enabled: true, CreatedAt: created}
+	record("rule/create", nil, s.CreateAlertRule(ctx, rule))
+	record("rule/createAll", nil, s.CreateAlertRule(ctx, &models.AlertRule{Name: "all", Enabled: true, CreatedAt: created}))
+	record("rule/createOff", nil, s.CreateAlertRule(ctx, &models.AlertRule{Name: "off", CreatedAt: created}))
+	rule.Name = "slower"
+	record("rule/update", nil, s.UpdateAlertRule(ctx, rule))
+	record("rule/updateMissing", nil, s.UpdateAlertRule(ctx, &models.AlertRule{ID: 99}))
+	record("rule/delete", nil, s.DeleteAlertRule(ctx, 2))
+	rules, err := s.GetEnabledAlertRulesForClient(ctx, "10.0.0.1")
+	record("rule/enabled", rules, err)
+	rules, err = s.ListAlertRules(ctx)
+	record("rule/list", rules, err)
+
+	for _, a := range []*models.CostCenterAssignment{
+		{Match: "10.0.0.0/8", CostCenter: "lab", CreatedAt: created},
+		{Match: "10.0.0.1", CostCenter: "ci", CreatedAt: created},
+		{Match: "10.0.0.0/8", CostCenter: "ops", CreatedAt: created.Add(time.Hour)},
+	} {
+		record("assign/save", a, s.SaveCostCenterAssignment(ctx, a))
+	}
+	assignments, err := s.ListCostCenterAssignments(ctx)
+	record("assign/list", assignments, err)
+	record("assign/deleteMissing", nil, s.DeleteCostCenterAssignment(ctx, 99))
+
+	for _, c := range []*models.LinkCapacity{
+		{Match: "10.0.0.0/8", Capacity: 100e6, CreatedAt: created},
+		{Match: "10.0.0.1", Capacity: 1e9, CreatedAt: created},
+		{Match: "10.0.0.0/8", Capacity: 10e9, CreatedAt: created.Add(time.Hour)},
+	} {
+		record("capacity/save", c, s.SaveLinkCapacity(ctx, c))
+	}
+	capacities, err := s.ListLinkCapacities(ctx)
+	record("capacity/list", capacities, err)
+	record("capacity/deleteMissing", nil, s.DeleteLinkCapacity(ctx, 99))
+
+	record("collision", nil, s.SaveCollision(ctx, &models.Collision{Timestamp: base, ServerPort: 5201, BusyClientIP: "10.0.0.1"}))
+	collisions, err := s.GetCollisionsBetween(ctx, base, base.Add(time.Hour))
+	record("collisions", collisions, err)
+
+	rtt := 12.5
+	for i, target := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.1"} {
+		r := &models.LatencyResult{ProbeID: "p", Target: target, Method: models.ProbeMethodICMP,
+			Timestamp: base.Add(time.Duration(i) * time.Minute), Sent: 2, Received: 1, PacketLoss: 50, RTTAvg: &rtt}
+		record(fmt.Sprintf("latency%d", i), nil, s.SaveLatencyResult(ctx, r))
+		record(fmt.Sprintf("latencyID%d", i), r.ID, nil)
+	}
+	latency, err := s.GetLatencyResults(ctx, "192.0.2.1", 10, 0)
+	record("latency", latency, err)
+	latency, err = s.GetLatencyResults(ctx, "", 1, 1)
+	record("latencyPage", latency, err)
+
+	for i, target := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.1"} {
+		r := &models.MTUResult{Target: target, Address: target, Method: models.ProbeMethodUDP,
+			Timestamp: base.Add(time.Duration(i) * time.Minute), PathMTU: 1400 + i, Min: 68, Max: 1500, Probes: 12}
+		record(fmt.Sprintf("mtu%d", i), nil, s.SaveMTUResult(ctx, r))
+		record(fmt.Sprintf("mtuID%d", i), r.ID, nil)
+	}
+	mtu, err := s.GetMTUResults(ctx, "192.0.2.1", 10, 0)
+	record("mtu", mtu, err)
+	mtu, err = s.GetMTUResults(ctx, "", 1, 1)
+	record("mtuPage", mtu, err)
+
+	for i, resultID := range []string{"r1", "", "r1"} {
+		tr := &models.Traceroute{ID: fmt.Sprintf("trace%d", i), Address: "192.0.2.9", Timestamp: base.Add(time.Duration(i) * time.Minute),
+			TracerouteRequest: models.TracerouteRequest{Target: "192.0.2.9", ResultID: resultID, MaxHops: 30, Queries: 1, Timeout: 1},
+			Hops:              []models.TracerouteHop{{TraceID: fmt.Sprintf("trace%d", i), TTL: 1, Address: "192.0.2.9", RTTs: []*float64{&rtt}}},
+			Reached:           true, Done: true}
+		record(fmt.Sprintf("trace%d", i), nil, s.SaveTraceroute(ctx, tr))
+	}
+	trace, err := s.GetTraceroute(ctx, "trace1")
+	record("trace", trace, err)
+	trace, err = s.GetTraceroute(ctx, "missing")
+	record("traceMissing", trace, err)
+	traces, err := s.GetTraceroutes(ctx, "r1", 10, 0)
+	record("traces", traces, err)
+	traces, err = s.GetTraceroutes(ctx, "", 1, 1)
+	record("tracesPage", traces, err)
+
+	cfg := models.DefaultServerConfig()
+	cfg.Allowlist = []string{"10.0.0.1"}
+	for i := 0; i < 2; i++ {
+		record("version/save", nil, s.SaveConfigVersion(ctx, &models.ConfigVersion{AppliedAt: base.Add(time.Duration(i) * time.Hour), Config: cfg}))
+	}
+	versions, err := s.GetConfigVersionsUntil(ctx, base)
+	record("version/until", versions, err)
+	latest, err := s.LatestConfigVersion(ctx)
+	record("version/latest", latest, err)
+
+	record("desired/save", nil, s.SaveDesiredState(ctx, &models.DesiredState{DeclaredAt: base, Running: true, Config: cfg}))
+	record("profile/save", nil, s.SaveProfile(ctx, &models.Profile{Name: "b", Config: cfg}))
+	record("profile/deleteMissing", nil, s.DeleteProfile(ctx, "zz"))
+	record("replace", nil, s.ReplaceConfiguration(ctx, &models.ConfigBundle{
+		AlertRules:     []models.AlertRule{{Name: "imported", CreatedAt: created}},
+		Profiles:       []models.Profile{{Name: "a", Description: "imported", Config: cfg}},
+		LinkCapacities: []models.LinkCapacity{{Match: "192.0.2.0/24", Capacity: 50e6, CreatedAt: created}},
+		DesiredState:   &models.DesiredState{DeclaredAt: base.Add(time.Hour), AutoCorrect: true, Config: cfg},
+	}))
+	rules, err = s.ListAlertRules(ctx)
+	record("replace/rules", rules, err)
+	assignments, err = s.ListCostCenterAssignments(ctx)
+	record("replace/assignments", assignments, err)
+	capacities, err = s.ListLinkCapacities(ctx)
+	record("replace/capacities", capacities, err)
+	states, err := s.GetDesiredStates(ctx)
+	record("desired/all", states, err)
+	profiles, err := s.ListProfiles(ctx)
+	for i := range profiles {
+		profiles[i].CreatedAt, profiles[i].UpdatedAt = time.Time{}, time.Time{}
+	}
+	record("profile/list", profiles, err)
+	_, err = s.GetProfile(ctx, "b")
+	record("profile/replaced", nil, err)
+
+	record("peer/save", nil, s.SavePeer(ctx, &models.Peer{Name: "b", URL: "https://b.example.com"}))
+	record("peer/replace", nil, s.SavePeer(ctx, &models.Peer{Name: "b", URL: "https://b2.example.com", APIKey: "k",
+		Location: &models.Location{Latitude: 51.5, Longitude: -0.1}}))
+	record("peer/saveOther", nil, s.SavePeer(ctx, &models.Peer{Name: "a", URL: "https://a.example.com"}))
+	record("peer/delete", nil, s.DeletePeer(ctx, "a"))
+	record("peer/deleteMissing", nil, s.DeletePeer(ctx, "a"))
+	peers, err := s.ListPeers(ctx)
+	record("peer/list", peers, err)
+
+	return out
+}
+
+func TestMemory_MatchesSQLite(t *testing.T) {
+	want := exercise(t, newTestStorage(t))
+	got := exercise(t, NewMemory())
+
+	for name, w := range want {
+		if g := got[name]; g != w {
+			t.Errorf("%s:\nmemory %s\nsqlite %s", name, g, w)
+		}
+	}
+}
+
+func TestMemory_CopiesRecords(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	jitter := 1.0
+	r := &models.TestResult{ClientIP: "10.0.0.1", Jitter: &jitter, Tags: []string{"lab"}}
+	if err := s.SaveTestResult(ctx, r); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	jitter = 2
+	r.Tags[0] = "changed"
+
+	got, err := s.GetTestResult(ctx, r.ID)
+	if err != nil {
+		t.Fatalf("GetTestResult: %v", err)
+	}
+	if *got.Jitter != 1 || got.Tags[0] != "lab" {
+		t.Errorf("stored result changed with the caller's: jitter %v, tags %v", *got.Jitter, got.Tags)
+	}
+	got.Tags[0] = "changed"
+	if again, _ := s.GetTestResult(ctx, r.ID); again.Tags[0] != "lab" {
+		t.Errorf("stored result changed with a returned copy: tags %v", again.Tags)
+	}
+}
+
+func TestMemory_FollowsContext(t *testing.T) {
+	s := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.SaveTestResult(ctx, &models.TestResult{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SaveTestResult error = %v, want context.Canceled", err)
+	}
+	if _, err := s.GetTestResults(ctx, 10, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetTestResults error = %v, want context.Canceled", err)
+	}
+}
+
+func TestMemory_StatsAlwaysReady(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	s.SaveTestResult(ctx, &models.TestResult{ClientIP: "10.0.0.1"})
+
+	if err := s.WarmResultStats(ctx); err != nil {
+		t.Fatalf("WarmResultStats: %v", err)
+	}
+	if err := s.RebuildResultStats(ctx); err != nil {
+		t.Fatalf("RebuildResultStats: %v", err)
+	}
+	status := s.ResultStatsStatus()
+	if !status.Ready || status.Rows != 1 || status.LastRebuild == nil {
+		t.Errorf("status = %+v, want ready with 1 row after a rebuild", status)
+	}
+}
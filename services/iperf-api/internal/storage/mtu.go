@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveMTUResult records a path MTU discovery and sets its ID.
+func (s *SQLiteStorage) SaveMTUResult(ctx context.Context, r *models.MTUResult) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	r.Timestamp = r.Timestamp.UTC()
+
+	res, err := s.db.ExecContext(ctx, `
+	INSERT INTO mtu_results (target, address, method, timestamp, path_mtu, min_size, max_size, probes, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Target, r.Address, r.Method, r.Timestamp, r.PathMTU, r.Min, r.Max, r.Probes, r.Error)
+	if err != nil {
+		return err
+	}
+
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetMTUResults returns path MTU discoveries, newest first, for one target
+// or for all if target is empty.
+func (s *SQLiteStorage) GetMTUResults(ctx context.Context, target string, limit, offset int) ([]models.MTUResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, target, address, method, timestamp, path_mtu, min_size, max_size, probes, error
+	FROM mtu_results
+	WHERE ? = '' OR target = ?
+	ORDER BY timestamp DESC, id DESC
+	LIMIT ? OFFSET ?
+	`, target, target, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.MTUResult
+	for rows.Next() {
+		var r models.MTUResult
+		if err := rows.Scan(&r.ID, &r.Target, &r.Address, &r.Method, &r.Timestamp, &r.PathMTU, &r.Min, &r.Max, &r.Probes, &r.Error); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
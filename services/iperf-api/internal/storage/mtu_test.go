@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestMTUResults(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, target := range []string{"vpn.example.com", "192.0.2.1", "vpn.example.com"} {
+		r := &models.MTUResult{
+			Target: target, Address: "192.0.2.1", Method: models.ProbeMethodICMP,
+			Timestamp: base.Add(time.Duration(i) * time.Minute), PathMTU: 1420, Min: 68, Max: 1500, Probes: 12,
+		}
+		if i == 1 {
+			r.PathMTU, r.Error = 0, "no answer to 68-byte probes"
+		}
+		if err := s.SaveMTUResult(ctx, r); err != nil {
+			t.Fatalf("SaveMTUResult: %v", err)
+		}
+		if r.ID == 0 {
+			t.Error("SaveMTUResult did not set ID")
+		}
+	}
+
+	got, err := s.GetMTUResults(ctx, "vpn.example.com", 10, 0)
+	if err != nil {
+		t.Fatalf("GetMTUResults: %v", err)
+	}
+	if len(got) != 2 || !got[0].Timestamp.Equal(base.Add(2*time.Minute)) || got[0].PathMTU != 1420 || got[0].Address != "192.0.2.1" {
+		t.Errorf("results for vpn.example.com = %+v, want two, newest first", got)
+	}
+
+	all, err := s.GetMTUResults(ctx, "", 10, 0)
+	if err != nil {
+		t.Fatalf("GetMTUResults: %v", err)
+	}
+	if len(all) != 3 || all[1].PathMTU != 0 || all[1].Error == "" {
+		t.Errorf("all results = %+v", all)
+	}
+}
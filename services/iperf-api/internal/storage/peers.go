@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SavePeer registers a federation peer, replacing the one with the same
+// name.
+func (s *SQLiteStorage) SavePeer(ctx context.Context, p *models.Peer) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var lat, lon *float64
+	if p.Location != nil {
+		lat, lon = &p.Location.Latitude, &p.Location.Longitude
+	}
+
+	upsertSQL := `
+	INSERT INTO peers (name, url, api_key, latitude, longitude, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET url = excluded.url, api_key = excluded.api_key,
+		latitude = excluded.latitude, longitude = excluded.longitude
+	`
+
+	_, err := s.db.ExecContext(ctx, upsertSQL, p.Name, p.URL, p.APIKey, lat, lon, time.Now().UTC())
+	return err
+}
+
+// ListPeers returns the registered federation peers ordered by name.
+func (s *SQLiteStorage) ListPeers(ctx context.Context) ([]models.Peer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, url, api_key, latitude, longitude FROM peers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []models.Peer
+	for rows.Next() {
+		var p models.Peer
+		var lat, lon sql.NullFloat64
+		if err := rows.Scan(&p.Name, &p.URL, &p.APIKey, &lat, &lon); err != nil {
+			return nil, err
+		}
+		if lat.Valid && lon.Valid {
+			p.Location = &models.Location{Latitude: lat.Float64, Longitude: lon.Float64}
+		}
+		peers = append(peers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return peers, nil
+}
+
+// DeletePeer removes a registered peer by name. Results pulled from it are
+// kept.
+func (s *SQLiteStorage) DeletePeer(ctx context.Context, name string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM peers WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestPeers(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	if err := s.SavePeer(ctx, &models.Peer{Name: "hq", URL: "https://hq.example.com"}); err != nil {
+		t.Fatalf("SavePeer: %v", err)
+	}
+	// Saving again under the same name replaces the peer
+	branch := &models.Peer{Name: "branch", URL: "http://10.1.0.5:8082", APIKey: "viewer-key",
+		Location: &models.Location{Latitude: 53.48, Longitude: -2.24}}
+	for _, p := range []*models.Peer{{Name: "branch", URL: "http://old"}, branch} {
+		if err := s.SavePeer(ctx, p); err != nil {
+			t.Fatalf("SavePeer: %v", err)
+		}
+	}
+
+	peers, err := s.ListPeers(ctx)
+	if err != nil {
+		t.Fatalf("ListPeers: %v", err)
+	}
+	if len(peers) != 2 || peers[0].Name != "branch" || peers[1].Name != "hq" || peers[1].Location != nil {
+		t.Fatalf("peers = %+v, want both ordered by name", peers)
+	}
+	if got := peers[0]; got.URL != branch.URL || got.APIKey != "viewer-key" || got.Location == nil || *got.Location != *branch.Location {
+		t.Errorf("branch = %+v", got)
+	}
+
+	if err := s.DeletePeer(ctx, "hq"); err != nil {
+		t.Fatalf("DeletePeer: %v", err)
+	}
+	if err := s.DeletePeer(ctx, "hq"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeletePeer: %v, want ErrNotFound", err)
+	}
+	if peers, _ := s.ListPeers(ctx); len(peers) != 1 {
+		t.Errorf("peers after delete = %+v", peers)
+	}
+}
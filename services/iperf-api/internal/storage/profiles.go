@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// SaveProfile stores a profile, replacing the description and configuration
+// of an existing profile with the same name. It sets the profile's
+// timestamps.
+func (s *SQLiteStorage) SaveProfile(ctx context.Context, p *models.Profile) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return saveProfile(ctx, s.db, p)
+}
+
+func saveProfile(ctx context.Context, db execer, p *models.Profile) error {
+	config, err := json.Marshal(p.Config)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	upsertSQL := `
+	INSERT INTO profiles (name, description, config, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET description = excluded.description,
+		config = excluded.config, updated_at = excluded.updated_at
+	RETURNING created_at, updated_at
+	`
+
+	return db.QueryRowContext(ctx, upsertSQL, p.Name, p.Description, string(config), now, now).Scan(&p.CreatedAt, &p.UpdatedAt)
+}
+
+// GetProfile returns the profile with the given name.
+func (s *SQLiteStorage) GetProfile(ctx context.Context, name string) (*models.Profile, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, description, config, created_at, updated_at FROM profiles WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles, err := scanProfiles(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, ErrNotFound
+	}
+	return &profiles[0], nil
+}
+
+// ListProfiles returns all profiles ordered by name.
+func (s *SQLiteStorage) ListProfiles(ctx context.Context) ([]models.Profile, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, description, config, created_at, updated_at FROM profiles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanProfiles(rows)
+}
+
+// DeleteProfile removes a profile by name.
+func (s *SQLiteStorage) DeleteProfile(ctx context.Context, name string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM profiles WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func scanProfiles(rows *sql.Rows) ([]models.Profile, error) {
+	var profiles []models.Profile
+	for rows.Next() {
+		var p models.Profile
+		var config string
+		if err := rows.Scan(&p.Name, &p.Description, &config, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(config), &p.Config); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
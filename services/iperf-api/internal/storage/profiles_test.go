@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestProfiles(t *testing.T) {
+	s := newTestStorage(t)
+
+	cfg := models.DefaultServerConfig()
+	cfg.Protocol = models.ProtocolUDP
+	p := &models.Profile{Name: "UDP lab", Config: cfg}
+	if err := s.SaveProfile(context.Background(), p); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	created := p.CreatedAt
+
+	// Saving again under the same name replaces the profile
+	cfg.Port = 5301
+	replacement := &models.Profile{Name: "UDP lab", Description: "lab bench", Config: cfg}
+	if err := s.SaveProfile(context.Background(), replacement); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	if !replacement.CreatedAt.Equal(created) || replacement.UpdatedAt.Before(created) {
+		t.Errorf("timestamps = %v / %v, want the original creation time", replacement.CreatedAt, replacement.UpdatedAt)
+	}
+	if err := s.SaveProfile(context.Background(), &models.Profile{Name: "public TCP 5201", Config: models.DefaultServerConfig()}); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+
+	got, err := s.GetProfile(context.Background(), "UDP lab")
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if got.Description != "lab bench" || got.Config.Port != 5301 || got.Config.Protocol != models.ProtocolUDP {
+		t.Errorf("profile = %+v", got)
+	}
+
+	profiles, err := s.ListProfiles(context.Background())
+	if err != nil {
+		t.Fatalf("ListProfiles: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Name != "UDP lab" || profiles[1].Name != "public TCP 5201" {
+		t.Errorf("profiles = %+v, want both ordered by name", profiles)
+	}
+
+	if err := s.DeleteProfile(context.Background(), "UDP lab"); err != nil {
+		t.Fatalf("DeleteProfile: %v", err)
+	}
+	if _, err := s.GetProfile(context.Background(), "UDP lab"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetProfile after delete: %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteProfile(context.Background(), "UDP lab"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeleteProfile: %v, want ErrNotFound", err)
+	}
+}
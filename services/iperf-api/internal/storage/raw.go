@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+)
+
+// SaveRawOutput keeps the server output a stored result was parsed from,
+// gzip-compressed, replacing any kept before. An unknown result is
+// ErrNotFound.
+func (s *SQLiteStorage) SaveRawOutput(ctx context.Context, resultID string, output []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(output); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+	INSERT INTO raw_outputs (result_id, output)
+	SELECT id, ? FROM test_results WHERE id = ?
+	ON CONFLICT(result_id) DO UPDATE SET output = excluded.output
+	`, buf.Bytes(), resultID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// GetRawOutput returns the server output a stored result was parsed from. A
+// result without it, or an unknown result, is ErrNotFound.
+func (s *SQLiteStorage) GetRawOutput(ctx context.Context, resultID string) ([]byte, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var compressed []byte
+	err := s.db.QueryRowContext(ctx, "SELECT output FROM raw_outputs WHERE result_id = ?", resultID).Scan(&compressed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// ListRawOutputIDs returns the IDs of the results with archived output,
+// oldest first.
+func (s *SQLiteStorage) ListRawOutputIDs(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT r.id FROM raw_outputs o JOIN test_results r ON r.id = o.result_id
+	ORDER BY r.timestamp, r.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
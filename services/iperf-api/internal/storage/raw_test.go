@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestRawOutput(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	if err := s.SaveTestResult(ctx, &models.TestResult{ID: "r1", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"}); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	output := bytes.Repeat([]byte("[  5]   0.00-1.00   sec   112 MBytes   941 Mbits/sec\n"), 1000)
+	if err := s.SaveRawOutput(ctx, "r1", output); err != nil {
+		t.Fatalf("SaveRawOutput: %v", err)
+	}
+
+	got, err := s.GetRawOutput(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetRawOutput: %v", err)
+	}
+	if !bytes.Equal(got, output) {
+		t.Errorf("GetRawOutput returned %d bytes, want the %d saved", len(got), len(output))
+	}
+
+	// Stored compressed
+	var stored int
+	if err := s.db.QueryRow("SELECT length(output) FROM raw_outputs WHERE result_id = 'r1'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored >= len(output)/10 {
+		t.Errorf("stored %d bytes for %d of output, want it compressed", stored, len(output))
+	}
+
+	if err := s.SaveRawOutput(ctx, "missing", output); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveRawOutput for an unknown result: err = %v", err)
+	}
+	if _, err := s.GetRawOutput(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRawOutput of an unknown result: err = %v", err)
+	}
+}
+
+func TestUpdateTestResult_KeepsRollup(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	result := &models.TestResult{ID: "r1", Timestamp: base, ClientIP: "10.0.0.1", ServerPort: 5201,
+		BytesTransferred: 100, Protocol: models.ProtocolTCP, Direction: "upload"}
+	if err := s.SaveTestResult(ctx, result); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	if err := s.SaveRawOutput(ctx, "r1", []byte("x")); err != nil {
+		t.Fatalf("SaveRawOutput: %v", err)
+	}
+
+	result.BytesTransferred = 250
+	result.MinBandwidth = 10
+	if err := s.UpdateTestResult(ctx, result); err != nil {
+		t.Fatalf("UpdateTestResult: %v", err)
+	}
+	got, err := s.GetTestResult(ctx, "r1")
+	if err != nil || got.BytesTransferred != 250 || got.MinBandwidth != 10 {
+		t.Fatalf("updated result = %+v, %v", got, err)
+	}
+	stats, err := s.GetResultStatsBetween(ctx, base, base.Add(time.Hour))
+	if err != nil || len(stats) != 1 || stats[0].Tests != 1 || stats[0].BytesTransferred != 250 {
+		t.Errorf("rollup = %+v, %v, want one test of 250 bytes", stats, err)
+	}
+
+	// Moving the result to another hour leaves no empty hour behind
+	result.Timestamp = base.Add(2 * time.Hour)
+	if err := s.UpdateTestResult(ctx, result); err != nil {
+		t.Fatalf("UpdateTestResult: %v", err)
+	}
+	if stats, _ := s.GetResultStatsBetween(ctx, base, base.Add(4*time.Hour)); len(stats) != 1 || !stats[0].Hour.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("rollup after moving = %+v, want only the new hour", stats)
+	}
+
+	if ids, err := s.ListRawOutputIDs(ctx); err != nil || len(ids) != 1 || ids[0] != "r1" {
+		t.Errorf("ListRawOutputIDs = %v, %v", ids, err)
+	}
+	if err := s.UpdateTestResult(ctx, &models.TestResult{ID: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateTestResult of an unknown result: err = %v", err)
+	}
+}
@@ -0,0 +1,655 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// ErrUnavailable is returned by a Reconnecting store until its database
+// opens.
+var ErrUnavailable = errors.New("storage unavailable")
+
+// ErrReadOnly is returned for writes to storage opened read-only.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// Defaults for the delay between attempts to open storage.
+const (
+	DefaultRetryMin = time.Second
+	DefaultRetryMax = time.Minute
+)
+
+// Opener opens the store behind a Reconnecting store, reporting whether it
+// was opened read-only.
+type Opener func() (store Store, readOnly bool, err error)
+
+// Reconnecting is a Store that keeps trying to open its database, so the
+// API can start while storage is missing or not writable. Until the
+// database opens every call fails with ErrUnavailable; once it opens
+// read-only, writes fail with ErrReadOnly.
+type Reconnecting struct {
+	open               Opener
+	retryMin, retryMax time.Duration
+
+	mu       sync.RWMutex
+	store    Store
+	readOnly bool
+	status   models.StorageStatus
+
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReconnecting opens storage with open. When that fails it returns at
+// once and retries in the background, doubling the delay from retryMin up
+// to retryMax.
+func NewReconnecting(open Opener, retryMin, retryMax time.Duration) *Reconnecting {
+	if retryMin <= 0 {
+		retryMin = DefaultRetryMin
+	}
+	if retryMax < retryMin {
+		retryMax = retryMin
+	}
+	r := &Reconnecting{
+		open:     open,
+		retryMin: retryMin,
+		retryMax: retryMax,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if !r.attempt() {
+		go r.retry()
+	}
+	return r
+}
+
+// attempt opens storage once, reporting whether it opened.
+func (r *Reconnecting) attempt() bool {
+	store, readOnly, err := r.open()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.status.State = models.StorageStateUnavailable
+		r.status.Error = err.Error()
+		r.status.Attempts++
+		return false
+	}
+	select {
+	case <-r.done:
+		// Closed while opening
+		store.Close()
+		return true
+	default:
+	}
+	r.store, r.readOnly = store, readOnly
+	r.status = models.StorageStatus{State: models.StorageStateOK}
+	if readOnly {
+		r.status.State = models.StorageStateReadOnly
+	}
+	close(r.ready)
+	return true
+}
+
+// retry opens storage with growing delays until it opens or r is closed.
+func (r *Reconnecting) retry() {
+	delay := r.retryMin
+	for {
+		next := time.Now().Add(delay)
+		r.mu.Lock()
+		r.status.NextRetry = &next
+		r.mu.Unlock()
+		log.Printf("Storage unavailable, retrying in %s: %s", delay, r.Status().Error)
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+		if r.attempt() {
+			log.Printf("Storage opened after %d failed attempts", r.Status().Attempts)
+			return
+		}
+		delay = min(delay*2, r.retryMax)
+	}
+}
+
+// Status reports whether storage is open.
+func (r *Reconnecting) Status() models.StorageStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status
+}
+
+// Ready is closed once storage opens.
+func (r *Reconnecting) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// Store returns the opened store, or nil while storage is unavailable.
+func (r *Reconnecting) Store() Store {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store
+}
+
+// reader returns the store for a call that only reads.
+func (r *Reconnecting) reader() (Store, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.store == nil {
+		return nil, ErrUnavailable
+	}
+	return r.store, nil
+}
+
+// writer returns the store for a call that writes.
+func (r *Reconnecting) writer() (Store, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	switch {
+	case r.store == nil:
+		return nil, ErrUnavailable
+	case r.readOnly:
+		return nil, ErrReadOnly
+	}
+	return r.store, nil
+}
+
+// Backup writes a copy of the database to path, as SQLiteStorage.Backup
+// does. Backups read, so they work on storage opened read-only.
+func (r *Reconnecting) Backup(ctx context.Context, path string) error {
+	s, err := r.reader()
+	if err != nil {
+		return err
+	}
+	b, ok := s.(interface {
+		Backup(ctx context.Context, path string) error
+	})
+	if !ok {
+		return errors.New("storage does not support backups")
+	}
+	return b.Backup(ctx, path)
+}
+
+// Close stops retrying and closes storage if it opened.
+func (r *Reconnecting) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store == nil {
+		return nil
+	}
+	err := r.store.Close()
+	r.store = nil
+	r.status = models.StorageStatus{State: models.StorageStateUnavailable, Error: "storage closed"}
+	return err
+}
+
+func (r *Reconnecting) ResultStatsStatus() models.StatsCacheStatus {
+	s, err := r.reader()
+	if err != nil {
+		return models.StatsCacheStatus{LastError: err.Error()}
+	}
+	return s.ResultStatsStatus()
+}
+
+func (r *Reconnecting) GetTestResult(ctx context.Context, id string) (*models.TestResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTestResult(ctx, id)
+}
+
+func (r *Reconnecting) SaveTestResult(ctx context.Context, result *models.TestResult) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveTestResult(ctx, result)
+}
+
+func (r *Reconnecting) SaveTestResultWithSamples(ctx context.Context, result *models.TestResult, samples []models.IntervalSample) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveTestResultWithSamples(ctx, result, samples)
+}
+
+func (r *Reconnecting) UpdateTestResultNotes(ctx context.Context, id string, tags []string, note string) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.UpdateTestResultNotes(ctx, id, tags, note)
+}
+
+func (r *Reconnecting) UpdateTestResult(ctx context.Context, result *models.TestResult) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.UpdateTestResult(ctx, result)
+}
+
+func (r *Reconnecting) GetTestResults(ctx context.Context, limit, offset int) ([]models.TestResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTestResults(ctx, limit, offset)
+}
+
+func (r *Reconnecting) GetTestResultsByClientIP(ctx context.Context, clientIP string, limit, offset int) ([]models.TestResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTestResultsByClientIP(ctx, clientIP, limit, offset)
+}
+
+func (r *Reconnecting) QueryTestResults(ctx context.Context, filter HistoryFilter, limit, offset int) ([]models.TestResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryTestResults(ctx, filter, limit, offset)
+}
+
+func (r *Reconnecting) GetTestResultsBetween(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTestResultsBetween(ctx, from, to)
+}
+
+func (r *Reconnecting) GetTotalCount(ctx context.Context) (int, error) {
+	s, err := r.reader()
+	if err != nil {
+		return 0, err
+	}
+	return s.GetTotalCount(ctx)
+}
+
+func (r *Reconnecting) CountTestResults(ctx context.Context, filter HistoryFilter) (int, error) {
+	s, err := r.reader()
+	if err != nil {
+		return 0, err
+	}
+	return s.CountTestResults(ctx, filter)
+}
+
+func (r *Reconnecting) LatestResultTime(ctx context.Context, filter HistoryFilter) (time.Time, error) {
+	s, err := r.reader()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.LatestResultTime(ctx, filter)
+}
+
+func (r *Reconnecting) GetTestSamples(ctx context.Context, resultID string) ([]models.IntervalSample, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTestSamples(ctx, resultID)
+}
+
+func (r *Reconnecting) SaveRawOutput(ctx context.Context, resultID string, output []byte) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveRawOutput(ctx, resultID, output)
+}
+
+func (r *Reconnecting) GetRawOutput(ctx context.Context, resultID string) ([]byte, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRawOutput(ctx, resultID)
+}
+
+func (r *Reconnecting) ListRawOutputIDs(ctx context.Context) ([]string, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListRawOutputIDs(ctx)
+}
+
+func (r *Reconnecting) WarmResultStats(ctx context.Context) error {
+	s, err := r.reader()
+	if err != nil {
+		return err
+	}
+	return s.WarmResultStats(ctx)
+}
+
+func (r *Reconnecting) RebuildResultStats(ctx context.Context) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.RebuildResultStats(ctx)
+}
+
+func (r *Reconnecting) GetResultStatsBetween(ctx context.Context, from, to time.Time) ([]models.ResultStat, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetResultStatsBetween(ctx, from, to)
+}
+
+func (r *Reconnecting) SaveCostCenterAssignment(ctx context.Context, a *models.CostCenterAssignment) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveCostCenterAssignment(ctx, a)
+}
+
+func (r *Reconnecting) ListCostCenterAssignments(ctx context.Context) ([]models.CostCenterAssignment, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListCostCenterAssignments(ctx)
+}
+
+func (r *Reconnecting) DeleteCostCenterAssignment(ctx context.Context, id int64) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.DeleteCostCenterAssignment(ctx, id)
+}
+
+func (r *Reconnecting) SaveLinkCapacity(ctx context.Context, c *models.LinkCapacity) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveLinkCapacity(ctx, c)
+}
+
+func (r *Reconnecting) ListLinkCapacities(ctx context.Context) ([]models.LinkCapacity, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListLinkCapacities(ctx)
+}
+
+func (r *Reconnecting) DeleteLinkCapacity(ctx context.Context, id int64) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.DeleteLinkCapacity(ctx, id)
+}
+
+func (r *Reconnecting) CreateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.CreateAlertRule(ctx, rule)
+}
+
+func (r *Reconnecting) UpdateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.UpdateAlertRule(ctx, rule)
+}
+
+func (r *Reconnecting) GetAlertRule(ctx context.Context, id int64) (*models.AlertRule, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAlertRule(ctx, id)
+}
+
+func (r *Reconnecting) ListAlertRules(ctx context.Context) ([]models.AlertRule, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListAlertRules(ctx)
+}
+
+func (r *Reconnecting) GetEnabledAlertRulesForClient(ctx context.Context, clientIP string) ([]models.AlertRule, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetEnabledAlertRulesForClient(ctx, clientIP)
+}
+
+func (r *Reconnecting) DeleteAlertRule(ctx context.Context, id int64) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.DeleteAlertRule(ctx, id)
+}
+
+func (r *Reconnecting) SaveAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveAuditEntry(ctx, e)
+}
+
+func (r *Reconnecting) QueryAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryAuditLog(ctx, filter, limit, offset)
+}
+
+func (r *Reconnecting) CountAuditLog(ctx context.Context, filter AuditFilter) (int, error) {
+	s, err := r.reader()
+	if err != nil {
+		return 0, err
+	}
+	return s.CountAuditLog(ctx, filter)
+}
+
+func (r *Reconnecting) SaveCollision(ctx context.Context, c *models.Collision) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveCollision(ctx, c)
+}
+
+func (r *Reconnecting) GetCollisionsBetween(ctx context.Context, from, to time.Time) ([]models.Collision, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCollisionsBetween(ctx, from, to)
+}
+
+func (r *Reconnecting) SaveLatencyResult(ctx context.Context, result *models.LatencyResult) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveLatencyResult(ctx, result)
+}
+
+func (r *Reconnecting) GetLatencyResults(ctx context.Context, target string, limit, offset int) ([]models.LatencyResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetLatencyResults(ctx, target, limit, offset)
+}
+
+func (r *Reconnecting) SaveMTUResult(ctx context.Context, result *models.MTUResult) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveMTUResult(ctx, result)
+}
+
+func (r *Reconnecting) GetMTUResults(ctx context.Context, target string, limit, offset int) ([]models.MTUResult, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMTUResults(ctx, target, limit, offset)
+}
+
+func (r *Reconnecting) SaveTraceroute(ctx context.Context, t *models.Traceroute) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveTraceroute(ctx, t)
+}
+
+func (r *Reconnecting) GetTraceroute(ctx context.Context, id string) (*models.Traceroute, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTraceroute(ctx, id)
+}
+
+func (r *Reconnecting) GetTraceroutes(ctx context.Context, resultID string, limit, offset int) ([]models.Traceroute, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTraceroutes(ctx, resultID, limit, offset)
+}
+
+func (r *Reconnecting) SaveConfigVersion(ctx context.Context, v *models.ConfigVersion) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveConfigVersion(ctx, v)
+}
+
+func (r *Reconnecting) LatestConfigVersion(ctx context.Context) (*models.ConfigVersion, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.LatestConfigVersion(ctx)
+}
+
+func (r *Reconnecting) GetConfigVersionsUntil(ctx context.Context, to time.Time) ([]models.ConfigVersion, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetConfigVersionsUntil(ctx, to)
+}
+
+func (r *Reconnecting) SaveDesiredState(ctx context.Context, d *models.DesiredState) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveDesiredState(ctx, d)
+}
+
+func (r *Reconnecting) LatestDesiredState(ctx context.Context) (*models.DesiredState, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.LatestDesiredState(ctx)
+}
+
+func (r *Reconnecting) GetDesiredStates(ctx context.Context) ([]models.DesiredState, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetDesiredStates(ctx)
+}
+
+func (r *Reconnecting) SaveProfile(ctx context.Context, p *models.Profile) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SaveProfile(ctx, p)
+}
+
+func (r *Reconnecting) GetProfile(ctx context.Context, name string) (*models.Profile, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetProfile(ctx, name)
+}
+
+func (r *Reconnecting) ListProfiles(ctx context.Context) ([]models.Profile, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListProfiles(ctx)
+}
+
+func (r *Reconnecting) DeleteProfile(ctx context.Context, name string) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.DeleteProfile(ctx, name)
+}
+
+func (r *Reconnecting) SavePeer(ctx context.Context, p *models.Peer) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.SavePeer(ctx, p)
+}
+
+func (r *Reconnecting) ListPeers(ctx context.Context) ([]models.Peer, error) {
+	s, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListPeers(ctx)
+}
+
+func (r *Reconnecting) DeletePeer(ctx context.Context, name string) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.DeletePeer(ctx, name)
+}
+
+func (r *Reconnecting) ReplaceConfiguration(ctx context.Context, b *models.ConfigBundle) error {
+	s, err := r.writer()
+	if err != nil {
+		return err
+	}
+	return s.ReplaceConfiguration(ctx, b)
+}
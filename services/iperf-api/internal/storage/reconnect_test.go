@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestReconnecting_PassesThrough(t *testing.T) {
+	want := exercise(t, NewMemory())
+	got := exercise(t, NewReconnecting(func() (Store, bool, error) { return NewMemory(), false, nil }, 0, 0))
+
+	for name, w := range want {
+		if g := got[name]; g != w {
+			t.Errorf("%s:\nreconnecting %s\nmemory %s", name, g, w)
+		}
+	}
+}
+
+func TestReconnecting_RetriesUntilOpen(t *testing.T) {
+	ctx := context.Background()
+	failures := make(chan error, 2)
+	failures <- errors.New("permission denied")
+	failures <- errors.New("permission denied")
+	open := func() (Store, bool, error) {
+		select {
+		case err := <-failures:
+			return nil, false, err
+		default:
+			return NewMemory(), false, nil
+		}
+	}
+	r := NewReconnecting(open, 10*time.Millisecond, 20*time.Millisecond)
+	defer r.Close()
+
+	if st := r.Status(); st.State != models.StorageStateUnavailable || st.Error != "permission denied" || st.Attempts != 1 {
+		t.Errorf("status = %+v, want unavailable after one attempt", st)
+	}
+	if _, err := r.GetTestResults(ctx, 10, 0); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("read err = %v, want ErrUnavailable", err)
+	}
+	if err := r.SaveTestResult(ctx, &models.TestResult{ID: "a"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("write err = %v, want ErrUnavailable", err)
+	}
+
+	select {
+	case <-r.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("storage never opened")
+	}
+	if st := r.Status(); st.State != models.StorageStateOK || st.Error != "" {
+		t.Errorf("status = %+v, want ok", st)
+	}
+	if err := r.SaveTestResult(ctx, &models.TestResult{ID: "a"}); err != nil {
+		t.Errorf("SaveTestResult: %v", err)
+	}
+	if got, err := r.GetTestResult(ctx, "a"); err != nil || got.ID != "a" {
+		t.Errorf("GetTestResult = %+v, %v", got, err)
+	}
+}
+
+func TestReconnecting_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	mem.SaveTestResult(ctx, &models.TestResult{ID: "a"})
+	r := NewReconnecting(func() (Store, bool, error) { return mem, true, nil }, 0, 0)
+	defer r.Close()
+
+	if st := r.Status(); st.State != models.StorageStateReadOnly {
+		t.Errorf("state = %q, want read-only", st.State)
+	}
+	if got, err := r.GetTestResult(ctx, "a"); err != nil || got.ID != "a" {
+		t.Errorf("GetTestResult = %+v, %v", got, err)
+	}
+	if err := r.SaveTestResult(ctx, &models.TestResult{ID: "b"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("SaveTestResult err = %v, want ErrReadOnly", err)
+	}
+	if err := r.DeletePeer(ctx, "a"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeletePeer err = %v, want ErrReadOnly", err)
+	}
+}
+
+func TestReconnecting_CloseStopsRetrying(t *testing.T) {
+	attempts := make(chan struct{}, 100)
+	r := NewReconnecting(func() (Store, bool, error) {
+		attempts <- struct{}{}
+		return nil, false, errors.New("no disk")
+	}, time.Millisecond, time.Millisecond)
+	<-attempts
+	<-attempts
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// At most one attempt was already under way
+	time.Sleep(20 * time.Millisecond)
+	drained := len(attempts)
+	time.Sleep(20 * time.Millisecond)
+	if len(attempts) != drained {
+		t.Error("retrying continued after Close")
+	}
+}
+
+func TestOpenDataDir(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "data")
+	s, readOnly, err := OpenDataDir(dir)
+	if err != nil || readOnly {
+		t.Fatalf("OpenDataDir = %v, read-only %t", err, readOnly)
+	}
+	if err := s.SaveTestResult(ctx, &models.TestResult{ID: "a", Protocol: models.ProtocolTCP, Direction: "upload"}); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	s.Close()
+
+	// A read-only database reads what was saved and refuses writes
+	ro, err := NewSQLiteStorage(filepath.Join(dir, DatabaseFile), WithReadOnly())
+	if err != nil {
+		t.Fatalf("read-only open: %v", err)
+	}
+	defer ro.Close()
+	if got, err := ro.GetTestResult(ctx, "a"); err != nil || got.ID != "a" {
+		t.Errorf("GetTestResult = %+v, %v", got, err)
+	}
+	if err := ro.SaveTestResult(ctx, &models.TestResult{ID: "b", Protocol: models.ProtocolTCP, Direction: "upload"}); err == nil {
+		t.Error("read-only database accepted a write")
+	}
+
+	// Read-only opens nothing that does not exist
+	if _, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "missing.db"), WithReadOnly()); err == nil {
+		t.Error("read-only open created a database")
+	}
+
+	// A data directory that cannot be created is an error
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0644)
+	if _, _, err := OpenDataDir(filepath.Join(file, "data")); err == nil {
+		t.Error("OpenDataDir under a file succeeded")
+	}
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// saveSamples inserts a result's interval samples in order through one
+// prepared statement, since a long test at a short interval has thousands.
+func saveSamples(ctx context.Context, tx *sql.Tx, resultID string, samples []models.IntervalSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+	INSERT INTO test_samples (result_id, seq, timestamp, interval_start, interval_end, bytes, bits_per_second)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, smp := range samples {
+		if _, err := stmt.ExecContext(ctx, resultID, i, smp.Timestamp.UTC(),
+			smp.IntervalStart, smp.IntervalEnd, smp.Bytes, smp.BitsPerSecond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTestSamples returns the interval samples of a stored result in order.
+// A result saved without samples has none; an unknown result is ErrNotFound.
+func (s *SQLiteStorage) GetTestSamples(ctx context.Context, resultID string) ([]models.IntervalSample, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_results WHERE id = ?", resultID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT timestamp, interval_start, interval_end, bytes, bits_per_second
+	FROM test_samples
+	WHERE result_id = ?
+	ORDER BY seq
+	`, resultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []models.IntervalSample{}
+	for rows.Next() {
+		var smp models.IntervalSample
+		if err := rows.Scan(&smp.Timestamp, &smp.IntervalStart, &smp.IntervalEnd, &smp.Bytes, &smp.BitsPerSecond); err != nil {
+			return nil, err
+		}
+		samples = append(samples, smp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// backfillPercentiles computes the percentiles of results saved with
+// samples before percentiles were stored. Results saved since have them,
+// so once done it finds nothing to do.
+func (s *SQLiteStorage) backfillPercentiles() error {
+	rows, err := s.db.Query(`
+	SELECT id FROM test_results
+	WHERE p50_bandwidth IS NULL
+		AND EXISTS (SELECT 1 FROM test_samples WHERE result_id = test_results.id)
+	`)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		rows, err := s.db.Query("SELECT bits_per_second FROM test_samples WHERE result_id = ?", id)
+		if err != nil {
+			return err
+		}
+		var samples []models.IntervalSample
+		for rows.Next() {
+			var smp models.IntervalSample
+			if err := rows.Scan(&smp.BitsPerSecond); err != nil {
+				rows.Close()
+				return err
+			}
+			samples = append(samples, smp)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		p := models.SamplePercentiles(samples)
+		if _, err := s.db.Exec("UPDATE test_results SET p50_bandwidth = ?, p95_bandwidth = ?, p99_bandwidth = ? WHERE id = ?",
+			p.P50, p.P95, p.P99, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
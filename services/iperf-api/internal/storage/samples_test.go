@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func testSamples(n int) []models.IntervalSample {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	samples := make([]models.IntervalSample, n)
+	for i := range samples {
+		samples[i] = models.IntervalSample{
+			Timestamp:     start.Add(time.Duration(i) * 100 * time.Millisecond),
+			IntervalStart: float64(i) / 10,
+			IntervalEnd:   float64(i+1) / 10,
+			Bytes:         int64(1000 + i),
+			BitsPerSecond: float64(80000 + 8*i),
+		}
+	}
+	return samples
+}
+
+func TestSaveTestResultWithSamples(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	samples := testSamples(600)
+	result := &models.TestResult{ID: "r1", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"}
+	if err := s.SaveTestResultWithSamples(ctx, result, samples); err != nil {
+		t.Fatalf("SaveTestResultWithSamples: %v", err)
+	}
+
+	got, err := s.GetTestSamples(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetTestSamples: %v", err)
+	}
+	if len(got) != len(samples) {
+		t.Fatalf("got %d samples, want %d", len(got), len(samples))
+	}
+	for i := range got {
+		if !got[i].Timestamp.Equal(samples[i].Timestamp) || got[i].IntervalStart != samples[i].IntervalStart ||
+			got[i].Bytes != samples[i].Bytes || got[i].BitsPerSecond != samples[i].BitsPerSecond {
+			t.Fatalf("sample %d = %+v, want %+v", i, got[i], samples[i])
+		}
+	}
+	if stats, _ := s.GetResultStatsBetween(ctx, time.Time{}, time.Now().Add(time.Hour)); len(stats) != 1 || stats[0].Tests != 1 {
+		t.Errorf("rollup = %+v, want the result counted once", stats)
+	}
+
+	// A result saved without samples has an empty series
+	if err := s.SaveTestResult(ctx, &models.TestResult{ID: "r2", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"}); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	if got, err := s.GetTestSamples(ctx, "r2"); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("GetTestSamples without samples = %v, %v", got, err)
+	}
+	if _, err := s.GetTestSamples(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetTestSamples of an unknown result: err = %v", err)
+	}
+}
+
+func TestSaveTestResultWithSamples_AllOrNothing(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	// Make the last sample fail after the result and earlier samples went in
+	if _, err := s.db.Exec(`CREATE TRIGGER reject_sample BEFORE INSERT ON test_samples
+		WHEN NEW.bytes < 0 BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatal(err)
+	}
+	samples := testSamples(10)
+	samples[9].Bytes = -1
+
+	result := &models.TestResult{ID: "r1", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"}
+	if err := s.SaveTestResultWithSamples(ctx, result, samples); err == nil {
+		t.Fatal("SaveTestResultWithSamples succeeded with a rejected sample")
+	}
+	if n, _ := s.GetTotalCount(ctx); n != 0 {
+		t.Errorf("stored %d results, want none", n)
+	}
+	var stored int
+	s.db.QueryRow("SELECT COUNT(*) FROM test_samples").Scan(&stored)
+	if stored != 0 {
+		t.Errorf("%d samples remain, want none", stored)
+	}
+	if stats, _ := s.GetResultStatsBetween(ctx, time.Time{}, time.Now().Add(time.Hour)); len(stats) != 0 {
+		t.Errorf("rollup = %+v, want empty", stats)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	// 100 samples of 1 to 100 Mbps, shuffled
+	samples := make([]models.IntervalSample, 100)
+	for i := range samples {
+		samples[i] = models.IntervalSample{Timestamp: time.Now(), BitsPerSecond: float64((i*37)%100+1) * 1e6}
+	}
+	want := models.BandwidthPercentiles{P50: 50e6, P95: 95e6, P99: 99e6}
+	if got := models.SamplePercentiles(samples); got == nil || *got != want {
+		t.Fatalf("SamplePercentiles = %+v, want %+v", got, want)
+	}
+	if got := models.SamplePercentiles(samples[:1]); got == nil || got.P50 != got.P99 {
+		t.Errorf("SamplePercentiles of one sample = %+v", got)
+	}
+	if models.SamplePercentiles(nil) != nil {
+		t.Error("SamplePercentiles(nil) is not nil")
+	}
+
+	result := &models.TestResult{ID: "r1", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload",
+		Percentiles: models.SamplePercentiles(samples)}
+	if err := s.SaveTestResultWithSamples(ctx, result, samples); err != nil {
+		t.Fatalf("SaveTestResultWithSamples: %v", err)
+	}
+	got, err := s.GetTestResult(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetTestResult: %v", err)
+	}
+	if got.Percentiles == nil || *got.Percentiles != want {
+		t.Errorf("stored Percentiles = %+v, want %+v", got.Percentiles, want)
+	}
+
+	// Results stored with samples but no percentiles are filled in on open
+	if err := s.SaveTestResultWithSamples(ctx, &models.TestResult{ID: "old", ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"}, samples); err != nil {
+		t.Fatalf("SaveTestResultWithSamples: %v", err)
+	}
+	if err := s.backfillPercentiles(); err != nil {
+		t.Fatalf("backfillPercentiles: %v", err)
+	}
+	if got, _ := s.GetTestResult(ctx, "old"); got == nil || got.Percentiles == nil || *got.Percentiles != want {
+		t.Errorf("backfilled Percentiles = %+v", got)
+	}
+}
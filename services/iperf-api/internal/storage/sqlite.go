@@ -1,7 +1,14 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Tom-Oram/fak/backend/internal/models"
@@ -9,21 +16,141 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Defaults for the connection settings, each overridable by an Option.
+const (
+	// DefaultQueryTimeout bounds each storage call
+	DefaultQueryTimeout = 10 * time.Second
+	// DefaultJournalMode lets readers proceed while a result is written
+	DefaultJournalMode = "WAL"
+	// DefaultBusyTimeout is how long a write waits for another to finish
+	// before failing with "database is locked"
+	DefaultBusyTimeout = 5 * time.Second
+	// DefaultMaxOpenConns allows concurrent readers alongside the writer
+	DefaultMaxOpenConns = 8
+)
+
 // SQLiteStorage provides SQLite-based persistence for iPerf test results.
 type SQLiteStorage struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
+	conn         connOptions
+
+	stats statsCache
+}
+
+// connOptions are the settings applied to every database connection.
+type connOptions struct {
+	journalMode  string
+	busyTimeout  time.Duration
+	foreignKeys  bool
+	maxOpenConns int
+	maxIdleConns int
+	readOnly     bool
+}
+
+// Option configures a SQLiteStorage.
+type Option func(*SQLiteStorage)
+
+// WithQueryTimeout limits how long each storage call may run, so a slow
+// query fails instead of holding up its caller. Zero disables the limit,
+// leaving only the caller's context.
+func WithQueryTimeout(d time.Duration) Option {
+	return func(s *SQLiteStorage) {
+		s.queryTimeout = d
+	}
+}
+
+// WithJournalMode sets SQLite's journal mode, such as "WAL" or "DELETE".
+func WithJournalMode(mode string) Option {
+	return func(s *SQLiteStorage) {
+		s.conn.journalMode = mode
+	}
+}
+
+// WithBusyTimeout sets how long a statement waits for a lock held by
+// another connection. Zero fails at once.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(s *SQLiteStorage) {
+		s.conn.busyTimeout = d
+	}
+}
+
+// WithForeignKeys turns enforcement of foreign key constraints on or off.
+func WithForeignKeys(on bool) Option {
+	return func(s *SQLiteStorage) {
+		s.conn.foreignKeys = on
+	}
+}
+
+// WithMaxOpenConns limits the connection pool; n <= 0 means no limit. Idle
+// connections are kept up to the same number unless WithMaxIdleConns says
+// otherwise.
+func WithMaxOpenConns(n int) Option {
+	return func(s *SQLiteStorage) {
+		s.conn.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns sets how many unused connections are kept open; n < 0
+// keeps none.
+func WithMaxIdleConns(n int) Option {
+	return func(s *SQLiteStorage) {
+		s.conn.maxIdleConns = n
+	}
+}
+
+// WithReadOnly opens the database read-only and without migrating it, for
+// a database on a read-only filesystem. Writes fail.
+func WithReadOnly() Option {
+	return func(s *SQLiteStorage) {
+		s.conn.readOnly = true
+	}
+}
+
+// execer is implemented by *sql.DB and *sql.Tx, so writes can run on their
+// own or as part of a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 // NewSQLiteStorage opens a SQLite database at the given path, runs migrations,
 // and returns a ready-to-use storage instance.
-func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+func NewSQLiteStorage(dbPath string, opts ...Option) (*SQLiteStorage, error) {
+	storage := &SQLiteStorage{
+		queryTimeout: DefaultQueryTimeout,
+		conn: connOptions{
+			journalMode:  DefaultJournalMode,
+			busyTimeout:  DefaultBusyTimeout,
+			foreignKeys:  true,
+			maxOpenConns: DefaultMaxOpenConns,
+		},
+	}
+	for _, opt := range opts {
+		opt(storage)
+	}
+
+	db, err := sql.Open("sqlite3", storage.conn.dsn(dbPath))
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(storage.conn.maxOpenConns)
+	switch idle := storage.conn.maxIdleConns; {
+	case idle != 0:
+		db.SetMaxIdleConns(idle)
+	case storage.conn.maxOpenConns > 0:
+		db.SetMaxIdleConns(storage.conn.maxOpenConns)
+	}
+	storage.db = db
 
-	storage := &SQLiteStorage{db: db}
-
+	// A read-only database is used as it is, so it must already exist
+	if storage.conn.readOnly {
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return storage, nil
+	}
 	if err := storage.migrate(); err != nil {
 		db.Close()
 		return nil, err
@@ -32,6 +159,44 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 	return storage, nil
 }
 
+// dsn adds the connection settings to dbPath as go-sqlite3 parameters, so
+// every connection in the pool gets them when it is opened.
+func (o connOptions) dsn(dbPath string) string {
+	params := url.Values{}
+	if o.readOnly {
+		// Nothing can change a file on a read-only filesystem, so SQLite
+		// need not lock it or read a WAL it cannot create
+		params.Set("mode", "ro")
+		params.Set("immutable", "1")
+		if !strings.HasPrefix(dbPath, "file:") {
+			dbPath = "file:" + dbPath
+		}
+	} else if o.journalMode != "" {
+		params.Set("_journal_mode", o.journalMode)
+	}
+	params.Set("_busy_timeout", strconv.FormatInt(o.busyTimeout.Milliseconds(), 10))
+	if o.foreignKeys {
+		params.Set("_foreign_keys", "on")
+	} else {
+		params.Set("_foreign_keys", "off")
+	}
+
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return dbPath + sep + params.Encode()
+}
+
+// withTimeout bounds ctx by the query timeout. Cancelling it interrupts a
+// running statement.
+func (s *SQLiteStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
 // migrate creates the required tables and indexes if they don't exist.
 func (s *SQLiteStorage) migrate() error {
 	createTableSQL := `
@@ -53,16 +218,461 @@ func (s *SQLiteStorage) migrate() error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_timestamp ON test_results(timestamp);
 	CREATE INDEX IF NOT EXISTS idx_client_ip ON test_results(client_ip);
+
+	CREATE TABLE IF NOT EXISTS cost_center_assignments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		match TEXT NOT NULL UNIQUE,
+		cost_center TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS link_capacities (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		match TEXT NOT NULL UNIQUE,
+		capacity REAL NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		client_ip TEXT NOT NULL DEFAULT '',
+		min_avg_bandwidth REAL,
+		max_packet_loss REAL,
+		max_jitter REAL,
+		max_retransmits INTEGER,
+		webhook_url TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS config_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		applied_at DATETIME NOT NULL,
+		config TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS desired_states (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		declared_at DATETIME NOT NULL,
+		running INTEGER NOT NULL,
+		auto_correct INTEGER NOT NULL,
+		config TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		action TEXT NOT NULL,
+		api_key TEXT NOT NULL DEFAULT '',
+		remote_ip TEXT NOT NULL DEFAULT '',
+		parameters TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_timestamp ON audit_log(timestamp);
+
+	CREATE TABLE IF NOT EXISTS collisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		server_port INTEGER NOT NULL DEFAULT 0,
+		busy_session_id TEXT NOT NULL DEFAULT '',
+		busy_client_ip TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_collisions_timestamp ON collisions(timestamp);
+
+	CREATE TABLE IF NOT EXISTS profiles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		config TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS peers (
+		name TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		api_key TEXT NOT NULL DEFAULT '',
+		latitude REAL,
+		longitude REAL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS test_samples (
+		result_id TEXT NOT NULL REFERENCES test_results(id) ON DELETE CASCADE,
+		seq INTEGER NOT NULL,
+		timestamp DATETIME NOT NULL,
+		interval_start REAL NOT NULL,
+		interval_end REAL NOT NULL,
+		bytes INTEGER NOT NULL,
+		bits_per_second REAL NOT NULL,
+		PRIMARY KEY (result_id, seq)
+	);
+
+	CREATE TABLE IF NOT EXISTS raw_outputs (
+		result_id TEXT PRIMARY KEY REFERENCES test_results(id) ON DELETE CASCADE,
+		output BLOB NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS latency_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		probe_id TEXT NOT NULL,
+		target TEXT NOT NULL,
+		method TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		sent INTEGER NOT NULL,
+		received INTEGER NOT NULL,
+		packet_loss REAL NOT NULL,
+		rtt_min REAL,
+		rtt_avg REAL,
+		rtt_max REAL,
+		rtt_stddev REAL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_latency_results_target ON latency_results(target, timestamp);
+
+	CREATE TABLE IF NOT EXISTS mtu_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		target TEXT NOT NULL,
+		address TEXT NOT NULL,
+		method TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		path_mtu INTEGER NOT NULL,
+		min_size INTEGER NOT NULL,
+		max_size INTEGER NOT NULL,
+		probes INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_mtu_results_target ON mtu_results(target, timestamp);
+
+	CREATE TABLE IF NOT EXISTS traceroutes (
+		id TEXT PRIMARY KEY,
+		result_id TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL,
+		address TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		max_hops INTEGER NOT NULL,
+		queries INTEGER NOT NULL,
+		timeout REAL NOT NULL,
+		hops TEXT NOT NULL,
+		reached INTEGER NOT NULL,
+		done INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_traceroutes_result_id ON traceroutes(result_id, timestamp);
+
+	CREATE TABLE IF NOT EXISTS result_stats (
+		hour DATETIME NOT NULL,
+		client_ip TEXT NOT NULL,
+		server_port INTEGER NOT NULL,
+		tests INTEGER NOT NULL,
+		bytes_transferred INTEGER NOT NULL,
+		PRIMARY KEY (hour, client_ip, server_port)
+	);
 	`
 
-	_, err := s.db.Exec(createTableSQL)
+	if _, err := s.db.Exec(createTableSQL); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema; applied to existing databases
+	columns := []struct{ table, name, definition string }{
+		{"test_results", "status", "TEXT NOT NULL DEFAULT 'completed'"},
+		{"test_results", "error_message", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "requested_duration", "REAL"},
+		{"test_results", "quality_flags", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "energy_joules", "REAL"},
+		{"test_results", "joules_per_gb", "REAL"},
+		{"test_results", "client_fingerprint", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "server_port", "INTEGER NOT NULL DEFAULT 0"},
+		{"test_results", "source", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "correlation_id", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "geo_country", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "geo_asn", "INTEGER NOT NULL DEFAULT 0"},
+		{"test_results", "geo_isp", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "tags", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "note", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "site", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "node", "TEXT NOT NULL DEFAULT ''"},
+		{"test_results", "host_cpu_total", "REAL"},
+		{"test_results", "remote_cpu_total", "REAL"},
+		{"test_results", "sender_bytes", "INTEGER"},
+		{"test_results", "sender_bandwidth", "REAL"},
+		{"test_results", "sender_retransmits", "INTEGER"},
+		{"test_results", "receiver_bytes", "INTEGER"},
+		{"test_results", "receiver_bandwidth", "REAL"},
+		{"test_results", "receiver_retransmits", "INTEGER"},
+		{"test_results", "p50_bandwidth", "REAL"},
+		{"test_results", "p95_bandwidth", "REAL"},
+		{"test_results", "p99_bandwidth", "REAL"},
+		{"test_results", "retransmits_per_gb", "REAL"},
+		{"test_results", "goodput_ratio", "REAL"},
+		{"test_results", "jitter_ratio", "REAL"},
+		{"alert_rules", "max_retransmits_per_gb", "REAL"},
+		{"alert_rules", "min_goodput_ratio", "REAL"},
+		{"alert_rules", "max_jitter_ratio", "REAL"},
+		{"test_results", "link_capacity", "REAL"},
+		{"test_results", "utilization", "REAL"},
+		{"alert_rules", "min_utilization", "REAL"},
+		{"test_results", "byte_precision", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := s.addColumnIfMissing(c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// Correlation IDs are unique per site and source, and results are listed
+	// by site; indexed once the columns exist
+	if _, err := s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_site_correlation
+		ON test_results(site, source, correlation_id) WHERE correlation_id != '';
+		CREATE INDEX IF NOT EXISTS idx_test_results_site ON test_results(site, timestamp)`); err != nil {
+		return err
+	}
+
+	return s.backfillPercentiles()
+}
+
+// addColumnIfMissing adds a column to an existing table so databases created
+// by older versions are upgraded in place.
+func (s *SQLiteStorage) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
-// SaveTestResult inserts a test result into the database.
+// testResultColumns is the column list shared by test result inserts and
+// selects; scanTestResults reads columns in this order.
+const testResultColumns = `id, timestamp, client_ip, client_port, protocol, duration,
+		bytes_transferred, avg_bandwidth, max_bandwidth, min_bandwidth,
+		retransmits, jitter, packet_loss, direction, status, error_message,
+		requested_duration, quality_flags, energy_joules, joules_per_gb,
+		client_fingerprint, server_port, source, correlation_id,
+		geo_country, geo_asn, geo_isp, tags, note, site, node,
+		host_cpu_total, remote_cpu_total,
+		sender_bytes, sender_bandwidth, sender_retransmits,
+		receiver_bytes, receiver_bandwidth, receiver_retransmits,
+		p50_bandwidth, p95_bandwidth, p99_bandwidth,
+		retransmits_per_gb, goodput_ratio, jitter_ratio,
+		link_capacity, utilization, byte_precision`
+
+// testResultArgs returns the values of r in testResultColumns order.
+// Timestamps are stored in UTC so that range comparisons are consistent.
+func testResultArgs(r *models.TestResult) []interface{} {
+	var geo models.GeoInfo
+	if r.Geo != nil {
+		geo = *r.Geo
+	}
+	args := []interface{}{
+		r.ID,
+		r.Timestamp.UTC(),
+		r.ClientIP,
+		r.ClientPort,
+		r.Protocol,
+		r.Duration,
+		r.BytesTransferred,
+		r.AvgBandwidth,
+		r.MaxBandwidth,
+		r.MinBandwidth,
+		r.Retransmits,
+		r.Jitter,
+		r.PacketLoss,
+		r.Direction,
+		r.Status,
+		r.ErrorMessage,
+		r.RequestedDuration,
+		joinQualityFlags(r.QualityFlags),
+		r.EnergyJoules,
+		r.JoulesPerGB,
+		encodeFingerprint(r.Client),
+		r.ServerPort,
+		r.Source,
+		r.CorrelationID,
+		geo.Country,
+		geo.ASN,
+		geo.ISP,
+		strings.Join(r.Tags, ","),
+		r.Note,
+		r.Site,
+		r.Node,
+		r.HostCPUTotal,
+		r.RemoteCPUTotal,
+	}
+	args = append(args, sideArgs(r.Sender)...)
+	args = append(args, sideArgs(r.Receiver)...)
+	args = append(args, percentileArgs(r.Percentiles)...)
+	return append(args, r.RetransmitsPerGB, r.GoodputRatio, r.JitterRatio,
+		r.LinkCapacity, r.Utilization, r.Precision)
+}
+
+// sideArgs returns the bytes, bandwidth and retransmits columns of one end
+// of a test, all NULL if it was not reported.
+func sideArgs(s *models.SideStats) []interface{} {
+	if s == nil {
+		return []interface{}{nil, nil, nil}
+	}
+	return []interface{}{s.Bytes, s.Bandwidth, s.Retransmits}
+}
+
+// percentileArgs returns the percentile columns, all NULL for a result
+// without samples.
+func percentileArgs(p *models.BandwidthPercentiles) []interface{} {
+	if p == nil {
+		return []interface{}{nil, nil, nil}
+	}
+	return []interface{}{p.P50, p.P95, p.P99}
+}
+
+// percentileColumns scans the columns written by percentileArgs.
+type percentileColumns struct {
+	p50, p95, p99 *float64
+}
+
+// percentiles returns the scanned percentiles, or nil if there are none.
+func (c percentileColumns) percentiles() *models.BandwidthPercentiles {
+	if c.p50 == nil || c.p95 == nil || c.p99 == nil {
+		return nil
+	}
+	return &models.BandwidthPercentiles{P50: *c.p50, P95: *c.p95, P99: *c.p99}
+}
+
+// sideColumns scans the columns written by sideArgs.
+type sideColumns struct {
+	bytes       *int64
+	bandwidth   *float64
+	retransmits *int
+}
+
+// stats returns the scanned end of a test, or nil if it was not reported.
+func (c sideColumns) stats() *models.SideStats {
+	if c.bytes == nil {
+		return nil
+	}
+	s := &models.SideStats{Bytes: *c.bytes, Retransmits: c.retransmits}
+	if c.bandwidth != nil {
+		s.Bandwidth = *c.bandwidth
+	}
+	return s
+}
+
+// placeholders returns n comma-separated SQL bind parameters.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// joinQualityFlags encodes flags as a comma-separated column value.
+func joinQualityFlags(flags []models.QualityFlag) string {
+	parts := make([]string, len(flags))
+	for i, f := range flags {
+		parts[i] = string(f)
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitQualityFlags decodes a quality_flags column value.
+func splitQualityFlags(value string) []models.QualityFlag {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	flags := make([]models.QualityFlag, len(parts))
+	for i, p := range parts {
+		flags[i] = models.QualityFlag(p)
+	}
+	return flags
+}
+
+// encodeFingerprint encodes a client fingerprint as a JSON column value, or
+// an empty string when there is none.
+func encodeFingerprint(fp *models.ClientFingerprint) string {
+	if fp == nil {
+		return ""
+	}
+	data, err := json.Marshal(fp)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeFingerprint decodes a client_fingerprint column value.
+func decodeFingerprint(value string) (*models.ClientFingerprint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var fp models.ClientFingerprint
+	if err := json.Unmarshal([]byte(value), &fp); err != nil {
+		return nil, fmt.Errorf("decoding client fingerprint: %w", err)
+	}
+	return &fp, nil
+}
+
+// GetTestResult returns a single test result by ID, or ErrNotFound.
+func (s *SQLiteStorage) GetTestResult(ctx context.Context, id string) (*models.TestResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT ` + testResultColumns + `
+	FROM test_results
+	WHERE id = ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanTestResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+	return &results[0], nil
+}
+
+// SaveTestResult inserts a test result into the database and counts it in
+// the hourly statistics.
 // If the result has no ID, a new UUID is generated.
 // If the timestamp is zero, the current time is used.
-func (s *SQLiteStorage) SaveTestResult(result *models.TestResult) error {
+func (s *SQLiteStorage) SaveTestResult(ctx context.Context, result *models.TestResult) error {
+	return s.SaveTestResultWithSamples(ctx, result, nil)
+}
+
+// SaveTestResultWithSamples saves a result as SaveTestResult does, along
+// with its interval samples, in one transaction: the result is stored with
+// all of its samples or not at all.
+func (s *SQLiteStorage) SaveTestResultWithSamples(ctx context.Context, result *models.TestResult, samples []models.IntervalSample) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	if result.ID == "" {
 		result.ID = uuid.New().String()
 	}
@@ -71,70 +681,196 @@ func (s *SQLiteStorage) SaveTestResult(result *models.TestResult) error {
 		result.Timestamp = time.Now()
 	}
 
+	if result.Status == "" {
+		result.Status = models.TestStatusCompleted
+	}
+
+	args := testResultArgs(result)
 	insertSQL := `
-	INSERT INTO test_results (
-		id, timestamp, client_ip, client_port, protocol, duration,
-		bytes_transferred, avg_bandwidth, max_bandwidth, min_bandwidth,
-		retransmits, jitter, packet_loss, direction
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	INSERT INTO test_results (` + testResultColumns + `
+	) VALUES (` + placeholders(len(args)) + `)
 	`
 
-	_, err := s.db.Exec(
-		insertSQL,
-		result.ID,
-		result.Timestamp,
-		result.ClientIP,
-		result.ClientPort,
-		result.Protocol,
-		result.Duration,
-		result.BytesTransferred,
-		result.AvgBandwidth,
-		result.MaxBandwidth,
-		result.MinBandwidth,
-		result.Retransmits,
-		result.Jitter,
-		result.PacketLoss,
-		result.Direction,
-	)
+	// The hourly rollup is updated with the result so the two never disagree
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
 
-	return err
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, insertSQL, args...); err != nil {
+		return err
+	}
+	if err := addResultStat(ctx, tx, result); err != nil {
+		return err
+	}
+	if err := saveSamples(ctx, tx, result.ID, samples); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// GetTestResults retrieves test results ordered by timestamp descending,
-// with pagination support via limit and offset.
-func (s *SQLiteStorage) GetTestResults(limit, offset int) ([]models.TestResult, error) {
-	query := `
-	SELECT id, timestamp, client_ip, client_port, protocol, duration,
-		bytes_transferred, avg_bandwidth, max_bandwidth, min_bandwidth,
-		retransmits, jitter, packet_loss, direction
-	FROM test_results
-	ORDER BY timestamp DESC
-	LIMIT ? OFFSET ?
+// UpdateTestResult replaces every field of a stored result, keeping the
+// hourly rollup in step. An unknown result is ErrNotFound.
+func (s *SQLiteStorage) UpdateTestResult(ctx context.Context, result *models.TestResult) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	args := testResultArgs(result)
+	updateSQL := `
+	UPDATE test_results SET (` + testResultColumns + `
+	) = (` + placeholders(len(args)) + `)
+	WHERE id = ?
 	`
 
-	rows, err := s.db.Query(query, limit, offset)
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	return scanTestResults(rows)
+	var old models.TestResult
+	err = tx.QueryRowContext(ctx, "SELECT timestamp, client_ip, server_port, bytes_transferred FROM test_results WHERE id = ?", result.ID).
+		Scan(&old.Timestamp, &old.ClientIP, &old.ServerPort, &old.BytesTransferred)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, updateSQL, append(args, result.ID)...); err != nil {
+		return err
+	}
+	if err := removeResultStat(ctx, tx, &old); err != nil {
+		return err
+	}
+	if err := addResultStat(ctx, tx, result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateTestResultNotes replaces the tags and note of a stored result. Tags
+// must not contain commas.
+func (s *SQLiteStorage) UpdateTestResultNotes(ctx context.Context, id string, tags []string, note string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "UPDATE test_results SET tags = ?, note = ? WHERE id = ?", strings.Join(tags, ","), note, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// HistoryFilter narrows test result queries. Zero-valued fields are ignored.
+type HistoryFilter struct {
+	ClientIP string
+	// QualityFlag selects results carrying this flag
+	QualityFlag models.QualityFlag
+	// ExcludeFlagged drops results with any quality flag
+	ExcludeFlagged bool
+	// Source and CorrelationID select results of queued jobs
+	Source        models.JobSource
+	CorrelationID string
+	// Country and ASN select results by the client's GeoIP location
+	Country string
+	ASN     uint
+	// Tag selects results carrying this tag
+	Tag string
+	// Site selects results pulled from this federation peer, and Local
+	// this instance's own results
+	Site  string
+	Local bool
+}
+
+// where builds the SQL WHERE clause and arguments for the filter.
+func (f HistoryFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if f.ClientIP != "" {
+		conds = append(conds, "client_ip = ?")
+		args = append(args, f.ClientIP)
+	}
+	if f.QualityFlag != "" {
+		// instr rather than LIKE, whose wildcards would match other flags
+		conds = append(conds, "instr(',' || quality_flags || ',', ?) > 0")
+		args = append(args, ","+string(f.QualityFlag)+",")
+	}
+	if f.ExcludeFlagged {
+		conds = append(conds, "quality_flags = ''")
+	}
+	if f.Source != "" {
+		conds = append(conds, "source = ?")
+		args = append(args, f.Source)
+	}
+	if f.CorrelationID != "" {
+		conds = append(conds, "correlation_id = ?")
+		args = append(args, f.CorrelationID)
+	}
+	if f.Country != "" {
+		conds = append(conds, "geo_country = ?")
+		args = append(args, strings.ToUpper(f.Country))
+	}
+	if f.ASN != 0 {
+		conds = append(conds, "geo_asn = ?")
+		args = append(args, f.ASN)
+	}
+	if f.Tag != "" {
+		// instr rather than LIKE, since tags may contain underscores
+		conds = append(conds, "instr(',' || tags || ',', ?) > 0")
+		args = append(args, ","+f.Tag+",")
+	}
+	if f.Site != "" {
+		conds = append(conds, "site = ?")
+		args = append(args, f.Site)
+	}
+	if f.Local {
+		conds = append(conds, "site = ''")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// GetTestResults retrieves test results ordered by timestamp descending,
+// with pagination support via limit and offset.
+func (s *SQLiteStorage) GetTestResults(ctx context.Context, limit, offset int) ([]models.TestResult, error) {
+	return s.QueryTestResults(ctx, HistoryFilter{}, limit, offset)
 }
 
 // GetTestResultsByClientIP retrieves test results for a specific client IP,
 // ordered by timestamp descending with pagination support.
-func (s *SQLiteStorage) GetTestResultsByClientIP(clientIP string, limit, offset int) ([]models.TestResult, error) {
+func (s *SQLiteStorage) GetTestResultsByClientIP(ctx context.Context, clientIP string, limit, offset int) ([]models.TestResult, error) {
+	return s.QueryTestResults(ctx, HistoryFilter{ClientIP: clientIP}, limit, offset)
+}
+
+// QueryTestResults retrieves test results matching the filter, ordered by
+// timestamp descending with pagination support.
+func (s *SQLiteStorage) QueryTestResults(ctx context.Context, filter HistoryFilter, limit, offset int) ([]models.TestResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, args := filter.where()
 	query := `
-	SELECT id, timestamp, client_ip, client_port, protocol, duration,
-		bytes_transferred, avg_bandwidth, max_bandwidth, min_bandwidth,
-		retransmits, jitter, packet_loss, direction
+	SELECT ` + testResultColumns + `
 	FROM test_results
-	WHERE client_ip = ?
+	` + where + `
 	ORDER BY timestamp DESC
 	LIMIT ? OFFSET ?
 	`
 
-	rows, err := s.db.Query(query, clientIP, limit, offset)
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit, offset)...)
 	if err != nil {
 		return nil, err
 	}
@@ -144,12 +880,41 @@ func (s *SQLiteStorage) GetTestResultsByClientIP(clientIP string, limit, offset
 }
 
 // GetTotalCount returns the total number of test results in the database.
-func (s *SQLiteStorage) GetTotalCount() (int, error) {
+func (s *SQLiteStorage) GetTotalCount(ctx context.Context) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_results").Scan(&count)
+	return count, err
+}
+
+// CountTestResults returns the number of test results matching the filter.
+func (s *SQLiteStorage) CountTestResults(ctx context.Context, filter HistoryFilter) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, args := filter.where()
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM test_results").Scan(&count)
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_results "+where, args...).Scan(&count)
 	return count, err
 }
 
+// LatestResultTime returns the timestamp of the newest test result matching
+// the filter, or the zero time if none does.
+func (s *SQLiteStorage) LatestResultTime(ctx context.Context, filter HistoryFilter) (time.Time, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, args := filter.where()
+	var latest time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT timestamp FROM test_results "+where+" ORDER BY timestamp DESC LIMIT 1", args...).Scan(&latest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return latest, err
+}
+
 // Close closes the database connection.
 func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
@@ -161,7 +926,10 @@ func scanTestResults(rows *sql.Rows) ([]models.TestResult, error) {
 
 	for rows.Next() {
 		var r models.TestResult
-		var protocol string
+		var protocol, status, qualityFlags, fingerprint, source, tags, precision string
+		var geo models.GeoInfo
+		var sender, receiver sideColumns
+		var percentiles percentileColumns
 
 		err := rows.Scan(
 			&r.ID,
@@ -178,12 +946,62 @@ func scanTestResults(rows *sql.Rows) ([]models.TestResult, error) {
 			&r.Jitter,
 			&r.PacketLoss,
 			&r.Direction,
+			&status,
+			&r.ErrorMessage,
+			&r.RequestedDuration,
+			&qualityFlags,
+			&r.EnergyJoules,
+			&r.JoulesPerGB,
+			&fingerprint,
+			&r.ServerPort,
+			&source,
+			&r.CorrelationID,
+			&geo.Country,
+			&geo.ASN,
+			&geo.ISP,
+			&tags,
+			&r.Note,
+			&r.Site,
+			&r.Node,
+			&r.HostCPUTotal,
+			&r.RemoteCPUTotal,
+			&sender.bytes,
+			&sender.bandwidth,
+			&sender.retransmits,
+			&receiver.bytes,
+			&receiver.bandwidth,
+			&receiver.retransmits,
+			&percentiles.p50,
+			&percentiles.p95,
+			&percentiles.p99,
+			&r.RetransmitsPerGB,
+			&r.GoodputRatio,
+			&r.JitterRatio,
+			&r.LinkCapacity,
+			&r.Utilization,
+			&precision,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if r.Client, err = decodeFingerprint(fingerprint); err != nil {
+			return nil, err
+		}
 
 		r.Protocol = models.Protocol(protocol)
+		r.Status = models.TestStatus(status)
+		r.QualityFlags = splitQualityFlags(qualityFlags)
+		r.Source = models.JobSource(source)
+		r.Precision = models.Precision(precision)
+		if tags != "" {
+			r.Tags = strings.Split(tags, ",")
+		}
+		if geo != (models.GeoInfo{}) {
+			r.Geo = &geo
+		}
+		r.Sender = sender.stats()
+		r.Receiver = receiver.stats()
+		r.Percentiles = percentiles.percentiles()
 		results = append(results, r)
 	}
 
@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func newTestStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSaveTestResult_DefaultsStatusToCompleted(t *testing.T) {
+	s := newTestStorage(t)
+
+	result := &models.TestResult{ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"}
+	if err := s.SaveTestResult(context.Background(), result); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	if result.Status != models.TestStatusCompleted {
+		t.Errorf("Status = %q, want %q", result.Status, models.TestStatusCompleted)
+	}
+
+	results, err := s.GetTestResults(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatalf("GetTestResults: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != models.TestStatusCompleted {
+		t.Fatalf("stored results = %+v, want one completed result", results)
+	}
+}
+
+func TestSaveTestResult_PersistsFailure(t *testing.T) {
+	s := newTestStorage(t)
+
+	result := &models.TestResult{
+		ClientIP:     "10.0.0.1",
+		Protocol:     models.ProtocolTCP,
+		Direction:    "upload",
+		Status:       models.TestStatusFailed,
+		ErrorMessage: "the client has unexpectedly closed the connection",
+	}
+	if err := s.SaveTestResult(context.Background(), result); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+
+	results, err := s.GetTestResultsByClientIP(context.Background(), "10.0.0.1", 10, 0)
+	if err != nil {
+		t.Fatalf("GetTestResultsByClientIP: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if results[0].Status != models.TestStatusFailed {
+		t.Errorf("Status = %q, want %q", results[0].Status, models.TestStatusFailed)
+	}
+	if results[0].ErrorMessage != result.ErrorMessage {
+		t.Errorf("ErrorMessage = %q, want %q", results[0].ErrorMessage, result.ErrorMessage)
+	}
+}
+
+func TestMigrate_UpgradesLegacySchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Create a database with the original schema and one row
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+	CREATE TABLE test_results (
+		id TEXT PRIMARY KEY, timestamp DATETIME NOT NULL, client_ip TEXT NOT NULL,
+		client_port INTEGER NOT NULL, protocol TEXT NOT NULL, duration REAL NOT NULL,
+		bytes_transferred INTEGER NOT NULL, avg_bandwidth REAL NOT NULL,
+		max_bandwidth REAL NOT NULL, min_bandwidth REAL NOT NULL,
+		retransmits INTEGER, jitter REAL, packet_loss REAL, direction TEXT NOT NULL
+	);
+	INSERT INTO test_results VALUES ('legacy', ?, '10.0.0.9', 5000, 'tcp', 10, 1, 1, 1, 1, NULL, NULL, NULL, 'upload');
+	`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage on legacy db: %v", err)
+	}
+	defer s.Close()
+
+	results, err := s.GetTestResults(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatalf("GetTestResults: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "legacy" {
+		t.Fatalf("results = %+v, want legacy row", results)
+	}
+	if results[0].Status != models.TestStatusCompleted {
+		t.Errorf("legacy Status = %q, want %q", results[0].Status, models.TestStatusCompleted)
+	}
+
+	// Re-opening must not try to add the columns again
+	s.Close()
+	s2, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("reopening migrated db: %v", err)
+	}
+	s2.Close()
+}
+
+func TestQueryTestResults_QualityFilters(t *testing.T) {
+	s := newTestStorage(t)
+
+	base := time.Now()
+	fixtures := []*models.TestResult{
+		{ID: "clean", Timestamp: base, ClientIP: "10.0.0.1"},
+		{ID: "zero", Timestamp: base.Add(time.Second), ClientIP: "10.0.0.1",
+			QualityFlags: []models.QualityFlag{models.QualityFlagZeroBytes, models.QualityFlagZeroMinBandwidth}},
+		{ID: "short", Timestamp: base.Add(2 * time.Second), ClientIP: "10.0.0.2",
+			QualityFlags: []models.QualityFlag{models.QualityFlagShortDuration}},
+	}
+	for _, r := range fixtures {
+		r.Protocol = models.ProtocolTCP
+		r.Direction = "upload"
+		if err := s.SaveTestResult(context.Background(), r); err != nil {
+			t.Fatalf("SaveTestResult(%s): %v", r.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   []string
+	}{
+		{"no filter", HistoryFilter{}, []string{"short", "zero", "clean"}},
+		{"by flag", HistoryFilter{QualityFlag: models.QualityFlagZeroMinBandwidth}, []string{"zero"}},
+		{"flag is not a prefix match", HistoryFilter{QualityFlag: "zero"}, nil},
+		{"flag is not a pattern", HistoryFilter{QualityFlag: "%"}, nil},
+		{"exclude flagged", HistoryFilter{ExcludeFlagged: true}, []string{"clean"}},
+		{"client and flag", HistoryFilter{ClientIP: "10.0.0.2", QualityFlag: models.QualityFlagShortDuration}, []string{"short"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := s.QueryTestResults(context.Background(), tt.filter, 10, 0)
+			if err != nil {
+				t.Fatalf("QueryTestResults: %v", err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	results, _ := s.QueryTestResults(context.Background(), HistoryFilter{QualityFlag: models.QualityFlagZeroBytes}, 10, 0)
+	if len(results[0].QualityFlags) != 2 {
+		t.Errorf("QualityFlags = %v, want two flags round-tripped", results[0].QualityFlags)
+	}
+}
+
+func TestSaveTestResult_CorrelationIDUniquePerSource(t *testing.T) {
+	s := newTestStorage(t)
+
+	save := func(id string, source models.JobSource, correlationID string) error {
+		return s.SaveTestResult(context.Background(), &models.TestResult{
+			ID: id, Protocol: models.ProtocolTCP, Direction: "upload",
+			Source: source, CorrelationID: correlationID,
+		})
+	}
+	if err := save("a", models.JobSourceCI, "run-1"); err != nil {
+		t.Fatalf("SaveTestResult: %v", err)
+	}
+	if err := save("b", models.JobSourceScheduled, "run-1"); err != nil {
+		t.Errorf("same correlation ID from another source: %v", err)
+	}
+	if err := save("c", models.JobSourceCI, "run-1"); err == nil {
+		t.Error("duplicate correlation ID saved for the same source")
+	}
+	// Results without a correlation ID are not constrained
+	if err := save("d", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("e", "", ""); err != nil {
+		t.Errorf("second result without a correlation ID: %v", err)
+	}
+	// A result pulled from a peer carries the peer's correlation IDs
+	pulled := &models.TestResult{ID: "f", Protocol: models.ProtocolTCP, Direction: "upload",
+		Source: models.JobSourceCI, CorrelationID: "run-1", Site: "branch"}
+	if err := s.SaveTestResult(context.Background(), pulled); err != nil {
+		t.Errorf("same correlation ID from another site: %v", err)
+	}
+
+	results, err := s.QueryTestResults(context.Background(), HistoryFilter{Source: models.JobSourceCI, CorrelationID: "run-1", Local: true}, 10, 0)
+	if err != nil {
+		t.Fatalf("QueryTestResults: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "a" || results[0].Source != models.JobSourceCI || results[0].CorrelationID != "run-1" {
+		t.Errorf("results = %+v, want result a round-tripped", results)
+	}
+}
+
+func TestQueryTestResults_Geo(t *testing.T) {
+	s := newTestStorage(t)
+
+	for _, r := range []*models.TestResult{
+		{ID: "gb", Geo: &models.GeoInfo{Country: "GB", ASN: 64500, ISP: "Example Transit"}},
+		{ID: "de", Geo: &models.GeoInfo{Country: "DE", ASN: 64501}},
+		{ID: "lan"},
+	} {
+		r.Protocol, r.Direction = models.ProtocolTCP, "upload"
+		if err := s.SaveTestResult(context.Background(), r); err != nil {
+			t.Fatalf("SaveTestResult: %v", err)
+		}
+	}
+
+	results, err := s.QueryTestResults(context.Background(), HistoryFilter{Country: "gb"}, 10, 0)
+	if err != nil {
+		t.Fatalf("QueryTestResults: %v", err)
+	}
+	if len(results) != 1 || results[0].Geo == nil || *results[0].Geo != (models.GeoInfo{Country: "GB", ASN: 64500, ISP: "Example Transit"}) {
+		t.Errorf("results = %+v, want gb with its location", results)
+	}
+	if results, _ := s.QueryTestResults(context.Background(), HistoryFilter{ASN: 64501}, 10, 0); len(results) != 1 || results[0].ID != "de" {
+		t.Errorf("results = %+v, want de", results)
+	}
+	if lan, err := s.GetTestResult(context.Background(), "lan"); err != nil || lan.Geo != nil {
+		t.Errorf("lan = %+v, %v, want no location", lan, err)
+	}
+}
+
+func TestUpdateTestResultNotes(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.SaveTestResult(context.Background(), &models.TestResult{ID: "r", Protocol: models.ProtocolTCP, Direction: "upload"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UpdateTestResultNotes(context.Background(), "r", []string{"baseline", "lab-2"}, "before upgrade"); err != nil {
+		t.Fatalf("UpdateTestResultNotes: %v", err)
+	}
+	got, err := s.GetTestResult(context.Background(), "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tags) != 2 || got.Tags[1] != "lab-2" || got.Note != "before upgrade" {
+		t.Errorf("result = %+v", got)
+	}
+	for tag, want := range map[string]int{"lab-2": 1, "lab": 0, "base": 0} {
+		if results, _ := s.QueryTestResults(context.Background(), HistoryFilter{Tag: tag}, 10, 0); len(results) != want {
+			t.Errorf("tag %q: %d results, want %d", tag, len(results), want)
+		}
+	}
+
+	if err := s.UpdateTestResultNotes(context.Background(), "r", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetTestResult(context.Background(), "r"); got.Tags != nil || got.Note != "" {
+		t.Errorf("result = %+v, want tags and note cleared", got)
+	}
+	if err := s.UpdateTestResultNotes(context.Background(), "missing", nil, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing result: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetTestResult_ClientFingerprint(t *testing.T) {
+	s := newTestStorage(t)
+
+	r := &models.TestResult{
+		ClientIP:   "10.0.0.1",
+		ServerPort: 5203,
+		Protocol:   models.ProtocolTCP,
+		Direction:  "upload",
+		Client: &models.ClientFingerprint{
+			Version:  "3.16",
+			Streams:  4,
+			Features: []string{"reverse"},
+		},
+	}
+	plain := &models.TestResult{ClientIP: "10.0.0.2", Protocol: models.ProtocolTCP, Direction: "upload"}
+	for _, res := range []*models.TestResult{r, plain} {
+		if err := s.SaveTestResult(context.Background(), res); err != nil {
+			t.Fatalf("SaveTestResult: %v", err)
+		}
+	}
+
+	got, err := s.GetTestResult(context.Background(), r.ID)
+	if err != nil {
+		t.Fatalf("GetTestResult: %v", err)
+	}
+	if got.Client == nil || got.Client.Version != "3.16" || got.Client.Streams != 4 || len(got.Client.Features) != 1 {
+		t.Errorf("Client = %+v, want stored fingerprint", got.Client)
+	}
+	if got.ServerPort != 5203 {
+		t.Errorf("ServerPort = %d, want 5203", got.ServerPort)
+	}
+
+	if got, err := s.GetTestResult(context.Background(), plain.ID); err != nil || got.Client != nil {
+		t.Errorf("result without fingerprint: Client = %+v, err = %v", got.Client, err)
+	}
+
+	if _, err := s.GetTestResult(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing result err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestQueries_FollowContext(t *testing.T) {
+	s := newTestStorage(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.GetTotalCount(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetTotalCount with a cancelled context: err = %v", err)
+	}
+	if err := s.SaveTestResult(ctx, &models.TestResult{ClientIP: "10.0.0.1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SaveTestResult with a cancelled context: err = %v", err)
+	}
+
+	slow, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "slow.db"), WithQueryTimeout(time.Nanosecond))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer slow.Close()
+	if _, err := slow.ListProfiles(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListProfiles past the query timeout: err = %v", err)
+	}
+
+	// The rebuild reads every result, so only the caller's context bounds it
+	if err := slow.RebuildResultStats(context.Background()); err != nil {
+		t.Errorf("RebuildResultStats: %v", err)
+	}
+}
+
+func TestNewSQLiteStorage_ConnectionSettings(t *testing.T) {
+	s := newTestStorage(t)
+
+	// Check on several connections, since each must get the settings
+	conns := make([]*sql.Conn, 3)
+	for i := range conns {
+		conn, err := s.db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[i] = conn
+	}
+	for i, conn := range conns {
+		var mode string
+		var busy, fk int
+		conn.QueryRowContext(context.Background(), "PRAGMA journal_mode").Scan(&mode)
+		conn.QueryRowContext(context.Background(), "PRAGMA busy_timeout").Scan(&busy)
+		conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&fk)
+		if mode != "wal" || busy != 5000 || fk != 1 {
+			t.Errorf("connection %d: journal_mode %q, busy_timeout %d, foreign_keys %d", i, mode, busy, fk)
+		}
+	}
+	if got := s.db.Stats().MaxOpenConnections; got != DefaultMaxOpenConns {
+		t.Errorf("MaxOpenConnections = %d, want %d", got, DefaultMaxOpenConns)
+	}
+
+	custom, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "custom.db"),
+		WithJournalMode("DELETE"), WithBusyTimeout(250*time.Millisecond), WithForeignKeys(false), WithMaxOpenConns(2))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer custom.Close()
+	var mode string
+	var busy, fk int
+	custom.db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+	custom.db.QueryRow("PRAGMA busy_timeout").Scan(&busy)
+	custom.db.QueryRow("PRAGMA foreign_keys").Scan(&fk)
+	if mode != "delete" || busy != 250 || fk != 0 || custom.db.Stats().MaxOpenConnections != 2 {
+		t.Errorf("journal_mode %q, busy_timeout %d, foreign_keys %d, max open %d",
+			mode, busy, fk, custom.db.Stats().MaxOpenConnections)
+	}
+}
+
+func TestSaveTestResult_ConcurrentWithReads(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	const writers, perWriter = 4, 25
+	errs := make(chan error, writers*perWriter*2)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				errs <- s.SaveTestResult(ctx, &models.TestResult{ClientIP: "10.0.0.1", Protocol: models.ProtocolTCP, Direction: "upload"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				_, err := s.QueryTestResults(ctx, HistoryFilter{}, 50, 0)
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent access: %v", err)
+		}
+	}
+	if n, _ := s.GetTotalCount(ctx); n != writers*perWriter {
+		t.Errorf("stored %d results, want %d", n, writers*perWriter)
+	}
+}
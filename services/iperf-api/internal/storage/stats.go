@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// statsCache tracks the result_stats table, an hourly rollup of test results
+// per client and port that the statistics endpoints read instead of every
+// result in a period.
+type statsCache struct {
+	// mu is held for writing while the rollup changes, so readers never see
+	// a result without its rollup or a half-finished rebuild
+	mu sync.RWMutex
+
+	statusMu sync.Mutex
+	status   models.StatsCacheStatus
+}
+
+// addResultStat counts one result in the rollup.
+func addResultStat(ctx context.Context, db execer, r *models.TestResult) error {
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO result_stats (hour, client_ip, server_port, tests, bytes_transferred)
+	VALUES (?, ?, ?, 1, ?)
+	ON CONFLICT(hour, client_ip, server_port) DO UPDATE SET
+		tests = tests + 1,
+		bytes_transferred = bytes_transferred + excluded.bytes_transferred
+	`, r.Timestamp.UTC().Truncate(time.Hour), r.ClientIP, r.ServerPort, r.BytesTransferred)
+	return err
+}
+
+// removeResultStat takes one result out of the rollup, dropping an hour
+// left without tests.
+func removeResultStat(ctx context.Context, db execer, r *models.TestResult) error {
+	hour := r.Timestamp.UTC().Truncate(time.Hour)
+	if _, err := db.ExecContext(ctx, `
+	UPDATE result_stats SET tests = tests - 1, bytes_transferred = bytes_transferred - ?
+	WHERE hour = ? AND client_ip = ? AND server_port = ?
+	`, r.BytesTransferred, hour, r.ClientIP, r.ServerPort); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+	DELETE FROM result_stats
+	WHERE hour = ? AND client_ip = ? AND server_port = ? AND tests <= 0
+	`, hour, r.ClientIP, r.ServerPort)
+	return err
+}
+
+// ResultStatsStatus reports whether the rollup is in use and how its last
+// rebuild went.
+func (s *SQLiteStorage) ResultStatsStatus() models.StatsCacheStatus {
+	s.stats.statusMu.Lock()
+	defer s.stats.statusMu.Unlock()
+	status := s.stats.status
+	if status.LastRebuild != nil {
+		at := *status.LastRebuild
+		status.LastRebuild = &at
+	}
+	return status
+}
+
+func (s *SQLiteStorage) updateStatsStatus(update func(*models.StatsCacheStatus)) {
+	s.stats.statusMu.Lock()
+	defer s.stats.statusMu.Unlock()
+	update(&s.stats.status)
+}
+
+// WarmResultStats checks the rollup against the stored results and rebuilds
+// it if they disagree, as after a bulk import or an upgrade that added the
+// rollup. Until it returns, statistics are computed from the results. Like
+// a rebuild, it is not subject to the query timeout.
+func (s *SQLiteStorage) WarmResultStats(ctx context.Context) error {
+	s.stats.mu.RLock()
+	var counted, stored int
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(tests), 0) FROM result_stats").Scan(&counted)
+	if err == nil {
+		err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_results").Scan(&stored)
+	}
+	s.stats.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if counted != stored {
+		return s.RebuildResultStats(ctx)
+	}
+	var rows int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM result_stats").Scan(&rows); err != nil {
+		return err
+	}
+	s.updateStatsStatus(func(st *models.StatsCacheStatus) {
+		st.Ready = true
+		st.Rows = rows
+	})
+	return nil
+}
+
+// RebuildResultStats recomputes the rollup from every stored result. Results
+// saved meanwhile wait for it to finish. Only ctx bounds it; the query
+// timeout would cut short a rebuild of a large history.
+func (s *SQLiteStorage) RebuildResultStats(ctx context.Context) error {
+	s.updateStatsStatus(func(st *models.StatsCacheStatus) { st.Rebuilding = true })
+	started := time.Now()
+
+	s.stats.mu.Lock()
+	rows, err := s.rebuildResultStats(ctx)
+	s.stats.mu.Unlock()
+
+	s.updateStatsStatus(func(st *models.StatsCacheStatus) {
+		st.Rebuilding = false
+		if err != nil {
+			st.LastError = err.Error()
+			return
+		}
+		at := time.Now()
+		st.Ready = true
+		st.Rows = rows
+		st.LastRebuild = &at
+		st.LastDurationMs = time.Since(started).Milliseconds()
+		st.LastError = ""
+	})
+	return err
+}
+
+// rebuildResultStats replaces the rollup in one transaction and returns its
+// row count. The caller holds stats.mu.
+func (s *SQLiteStorage) rebuildResultStats(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT timestamp, client_ip, server_port, bytes_transferred FROM test_results")
+	if err != nil {
+		return 0, err
+	}
+	var rollup models.StatsRollup
+	for rows.Next() {
+		var r models.TestResult
+		if err := rows.Scan(&r.Timestamp, &r.ClientIP, &r.ServerPort, &r.BytesTransferred); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rollup.Add(r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM result_stats"); err != nil {
+		return 0, err
+	}
+	stats := rollup.Stats()
+	for _, st := range stats {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO result_stats (hour, client_ip, server_port, tests, bytes_transferred) VALUES (?, ?, ?, ?, ?)",
+			st.Hour, st.ClientIP, st.ServerPort, st.Tests, st.BytesTransferred,
+		); err != nil {
+			return 0, err
+		}
+	}
+	return len(stats), tx.Commit()
+}
+
+// GetResultStatsBetween returns the hourly rollup of results with a timestamp
+// in [from, to), ordered by hour, client and port. Whole hours are read from
+// the rollup once it is ready; partial hours at either end, and the whole
+// period before then, are counted from the results.
+func (s *SQLiteStorage) GetResultStatsBetween(ctx context.Context, from, to time.Time) ([]models.ResultStat, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	from, to = from.UTC(), to.UTC()
+
+	s.stats.mu.RLock()
+	defer s.stats.mu.RUnlock()
+
+	start := from.Truncate(time.Hour)
+	if start.Before(from) {
+		start = start.Add(time.Hour)
+	}
+	end := to.Truncate(time.Hour)
+	if !s.ResultStatsStatus().Ready || !start.Before(end) {
+		return s.countResultStats(ctx, from, to)
+	}
+
+	head, err := s.countResultStats(ctx, from, start)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.readResultStats(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	tail, err := s.countResultStats(ctx, end, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(head, body...), tail...), nil
+}
+
+// countResultStats rolls up the results in [from, to) directly.
+func (s *SQLiteStorage) countResultStats(ctx context.Context, from, to time.Time) ([]models.ResultStat, error) {
+	if !from.Before(to) {
+		return nil, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT timestamp, client_ip, server_port, bytes_transferred
+	FROM test_results
+	WHERE timestamp >= ? AND timestamp < ?
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollup models.StatsRollup
+	for rows.Next() {
+		var r models.TestResult
+		if err := rows.Scan(&r.Timestamp, &r.ClientIP, &r.ServerPort, &r.BytesTransferred); err != nil {
+			return nil, err
+		}
+		rollup.Add(r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rollup.Stats(), nil
+}
+
+// readResultStats reads the rollup for the hours in [from, to).
+func (s *SQLiteStorage) readResultStats(ctx context.Context, from, to time.Time) ([]models.ResultStat, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT hour, client_ip, server_port, tests, bytes_transferred
+	FROM result_stats
+	WHERE hour >= ? AND hour < ?
+	ORDER BY hour, client_ip, server_port
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.ResultStat
+	for rows.Next() {
+		var st models.ResultStat
+		if err := rows.Scan(&st.Hour, &st.ClientIP, &st.ServerPort, &st.Tests, &st.BytesTransferred); err != nil {
+			return nil, err
+		}
+		st.Hour = st.Hour.UTC()
+		stats = append(stats, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// checkStats compares the rollup for [from, to) with one computed from the
+// results directly.
+func checkStats(t *testing.T, s *SQLiteStorage, from, to time.Time) []models.ResultStat {
+	t.Helper()
+	got, err := s.GetResultStatsBetween(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("GetResultStatsBetween: %v", err)
+	}
+	results, err := s.GetTestResultsBetween(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("GetTestResultsBetween: %v", err)
+	}
+	if want := models.HourlyStats(results); !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	return got
+}
+
+func TestResultStats(t *testing.T) {
+	s := newTestStorage(t)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, offset := range []time.Duration{10 * time.Minute, 50 * time.Minute, 90 * time.Minute, 150 * time.Minute, 200 * time.Minute} {
+		r := &models.TestResult{
+			Timestamp:        base.Add(offset),
+			ClientIP:         []string{"10.0.0.1", "10.0.0.2"}[i%2],
+			ServerPort:       5201,
+			BytesTransferred: int64(1000 * (i + 1)),
+			Protocol:         models.ProtocolTCP,
+			Direction:        "upload",
+		}
+		if err := s.SaveTestResult(context.Background(), r); err != nil {
+			t.Fatalf("SaveTestResult: %v", err)
+		}
+	}
+
+	// Before warming, stats are counted from the results
+	if s.ResultStatsStatus().Ready {
+		t.Fatal("rollup ready before warming")
+	}
+	checkStats(t, s, base.Add(30*time.Minute), base.Add(170*time.Minute))
+
+	if err := s.WarmResultStats(context.Background()); err != nil {
+		t.Fatalf("WarmResultStats: %v", err)
+	}
+	if st := s.ResultStatsStatus(); !st.Ready || st.Rows != 5 || st.LastRebuild != nil {
+		t.Errorf("status = %+v, want ready with 5 rows and no rebuild", st)
+	}
+	got := checkStats(t, s, base.Add(30*time.Minute), base.Add(170*time.Minute))
+	if len(got) != 3 || got[0].Tests != 1 || got[0].BytesTransferred != 2000 {
+		t.Errorf("stats = %+v, want the edge hours counted from 12:30", got)
+	}
+	checkStats(t, s, base, base.Add(4*time.Hour))
+	checkStats(t, s, base.Add(5*time.Minute), base.Add(20*time.Minute))
+}
+
+func TestResultStats_RebuildAfterDirectWrites(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.WarmResultStats(context.Background()); err != nil {
+		t.Fatalf("WarmResultStats: %v", err)
+	}
+
+	// Rows imported behind the storage's back are missing from the rollup
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		r := &models.TestResult{
+			ID:               string(rune('a' + i)),
+			Timestamp:        base.Add(time.Duration(i) * time.Hour),
+			ClientIP:         "10.0.0.1",
+			BytesTransferred: 500,
+			Protocol:         models.ProtocolTCP,
+			Direction:        "upload",
+			Status:           models.TestStatusCompleted,
+		}
+		args := testResultArgs(r)
+		if _, err := s.db.Exec("INSERT INTO test_results ("+testResultColumns+") VALUES ("+placeholders(len(args))+")", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale, err := s.GetResultStatsBetween(context.Background(), base, base.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Fatalf("stats = %+v, want the stale empty rollup", stale)
+	}
+
+	if err := s.WarmResultStats(context.Background()); err != nil {
+		t.Fatalf("WarmResultStats: %v", err)
+	}
+	st := s.ResultStatsStatus()
+	if !st.Ready || st.Rows != 3 || st.LastRebuild == nil || st.LastError != "" {
+		t.Errorf("status = %+v, want a rebuild with 3 rows", st)
+	}
+	checkStats(t, s, base, base.Add(3*time.Hour))
+
+	// Saving after the rebuild keeps the rollup in step
+	if err := s.SaveTestResult(context.Background(), &models.TestResult{Timestamp: base.Add(time.Hour), ClientIP: "10.0.0.1", BytesTransferred: 100,
+		Protocol: models.ProtocolTCP, Direction: "upload"}); err != nil {
+		t.Fatal(err)
+	}
+	got := checkStats(t, s, base, base.Add(3*time.Hour))
+	if got[1].Tests != 2 || got[1].BytesTransferred != 600 {
+		t.Errorf("stats = %+v, want two tests in the second hour", got)
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Store is the persistence the API needs. SQLiteStorage keeps everything on
+// disk; Memory keeps it for the life of the process.
+type Store interface {
+	GetTestResult(ctx context.Context, id string) (*models.TestResult, error)
+	SaveTestResult(ctx context.Context, result *models.TestResult) error
+	SaveTestResultWithSamples(ctx context.Context, result *models.TestResult, samples []models.IntervalSample) error
+	UpdateTestResultNotes(ctx context.Context, id string, tags []string, note string) error
+	UpdateTestResult(ctx context.Context, result *models.TestResult) error
+	GetTestResults(ctx context.Context, limit, offset int) ([]models.TestResult, error)
+	GetTestResultsByClientIP(ctx context.Context, clientIP string, limit, offset int) ([]models.TestResult, error)
+	QueryTestResults(ctx context.Context, filter HistoryFilter, limit, offset int) ([]models.TestResult, error)
+	GetTestResultsBetween(ctx context.Context, from, to time.Time) ([]models.TestResult, error)
+	GetTotalCount(ctx context.Context) (int, error)
+	CountTestResults(ctx context.Context, filter HistoryFilter) (int, error)
+	LatestResultTime(ctx context.Context, filter HistoryFilter) (time.Time, error)
+	GetTestSamples(ctx context.Context, resultID string) ([]models.IntervalSample, error)
+	SaveRawOutput(ctx context.Context, resultID string, output []byte) error
+	GetRawOutput(ctx context.Context, resultID string) ([]byte, error)
+	ListRawOutputIDs(ctx context.Context) ([]string, error)
+
+	ResultStatsStatus() models.StatsCacheStatus
+	WarmResultStats(ctx context.Context) error
+	RebuildResultStats(ctx context.Context) error
+	GetResultStatsBetween(ctx context.Context, from, to time.Time) ([]models.ResultStat, error)
+
+	SaveCostCenterAssignment(ctx context.Context, a *models.CostCenterAssignment) error
+	ListCostCenterAssignments(ctx context.Context) ([]models.CostCenterAssignment, error)
+	DeleteCostCenterAssignment(ctx context.Context, id int64) error
+
+	SaveLinkCapacity(ctx context.Context, c *models.LinkCapacity) error
+	ListLinkCapacities(ctx context.Context) ([]models.LinkCapacity, error)
+	DeleteLinkCapacity(ctx context.Context, id int64) error
+
+	CreateAlertRule(ctx context.Context, rule *models.AlertRule) error
+	UpdateAlertRule(ctx context.Context, rule *models.AlertRule) error
+	GetAlertRule(ctx context.Context, id int64) (*models.AlertRule, error)
+	ListAlertRules(ctx context.Context) ([]models.AlertRule, error)
+	GetEnabledAlertRulesForClient(ctx context.Context, clientIP string) ([]models.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, id int64) error
+
+	SaveAuditEntry(ctx context.Context, e *models.AuditEntry) error
+	QueryAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEntry, error)
+	CountAuditLog(ctx context.Context, filter AuditFilter) (int, error)
+
+	SaveCollision(ctx context.Context, c *models.Collision) error
+	GetCollisionsBetween(ctx context.Context, from, to time.Time) ([]models.Collision, error)
+
+	SaveLatencyResult(ctx context.Context, r *models.LatencyResult) error
+	GetLatencyResults(ctx context.Context, target string, limit, offset int) ([]models.LatencyResult, error)
+	SaveMTUResult(ctx context.Context, r *models.MTUResult) error
+	GetMTUResults(ctx context.Context, target string, limit, offset int) ([]models.MTUResult, error)
+	SaveTraceroute(ctx context.Context, t *models.Traceroute) error
+	GetTraceroute(ctx context.Context, id string) (*models.Traceroute, error)
+	GetTraceroutes(ctx context.Context, resultID string, limit, offset int) ([]models.Traceroute, error)
+
+	SaveConfigVersion(ctx context.Context, v *models.ConfigVersion) error
+	LatestConfigVersion(ctx context.Context) (*models.ConfigVersion, error)
+	GetConfigVersionsUntil(ctx context.Context, to time.Time) ([]models.ConfigVersion, error)
+
+	SaveDesiredState(ctx context.Context, d *models.DesiredState) error
+	LatestDesiredState(ctx context.Context) (*models.DesiredState, error)
+	GetDesiredStates(ctx context.Context) ([]models.DesiredState, error)
+
+	SaveProfile(ctx context.Context, p *models.Profile) error
+	GetProfile(ctx context.Context, name string) (*models.Profile, error)
+	ListProfiles(ctx context.Context) ([]models.Profile, error)
+	DeleteProfile(ctx context.Context, name string) error
+
+	SavePeer(ctx context.Context, p *models.Peer) error
+	ListPeers(ctx context.Context) ([]models.Peer, error)
+	DeletePeer(ctx context.Context, name string) error
+
+	ReplaceConfiguration(ctx context.Context, b *models.ConfigBundle) error
+
+	Close() error
+}
+
+var (
+	_ Store = (*SQLiteStorage)(nil)
+	_ Store = (*Memory)(nil)
+	_ Store = (*Reconnecting)(nil)
+)
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+const tracerouteColumns = "id, result_id, target, address, timestamp, max_hops, queries, timeout, hops, reached, done, error"
+
+// SaveTraceroute records a finished traceroute.
+func (s *SQLiteStorage) SaveTraceroute(ctx context.Context, t *models.Traceroute) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	hops, err := json.Marshal(t.Hops)
+	if err != nil {
+		return err
+	}
+	if t.Timestamp.IsZero() {
+		t.Timestamp = time.Now()
+	}
+	t.Timestamp = t.Timestamp.UTC()
+
+	_, err = s.db.ExecContext(ctx, `
+	INSERT INTO traceroutes (`+tracerouteColumns+`)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.ID, t.ResultID, t.Target, t.Address, t.Timestamp, t.MaxHops, t.Queries, t.Timeout, string(hops), t.Reached, t.Done, t.Error)
+	return err
+}
+
+// GetTraceroute returns the traceroute with the given ID.
+func (s *SQLiteStorage) GetTraceroute(ctx context.Context, id string) (*models.Traceroute, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+tracerouteColumns+" FROM traceroutes WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	traces, err := scanTraceroutes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(traces) == 0 {
+		return nil, ErrNotFound
+	}
+	return &traces[0], nil
+}
+
+// GetTraceroutes returns traceroutes, newest first, for one test result or
+// for all if resultID is empty.
+func (s *SQLiteStorage) GetTraceroutes(ctx context.Context, resultID string, limit, offset int) ([]models.Traceroute, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+tracerouteColumns+`
+	FROM traceroutes
+	WHERE ? = '' OR result_id = ?
+	ORDER BY timestamp DESC, id DESC
+	LIMIT ? OFFSET ?
+	`, resultID, resultID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTraceroutes(rows)
+}
+
+func scanTraceroutes(rows *sql.Rows) ([]models.Traceroute, error) {
+	var traces []models.Traceroute
+	for rows.Next() {
+		var t models.Traceroute
+		var hops string
+		if err := rows.Scan(&t.ID, &t.ResultID, &t.Target, &t.Address, &t.Timestamp, &t.MaxHops, &t.Queries, &t.Timeout, &hops, &t.Reached, &t.Done, &t.Error); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(hops), &t.Hops); err != nil {
+			return nil, err
+		}
+		traces = append(traces, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return traces, nil
+}
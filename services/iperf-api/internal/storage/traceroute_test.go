@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestTraceroutes(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	rtt := 4.2
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, resultID := range []string{"result-1", "", "result-1"} {
+		tr := &models.Traceroute{
+			ID:                string(rune('a' + i)),
+			TracerouteRequest: models.TracerouteRequest{Target: "192.0.2.7", ResultID: resultID, MaxHops: 30, Queries: 2, Timeout: 1},
+			Address:           "192.0.2.7",
+			Timestamp:         base.Add(time.Duration(i) * time.Minute),
+			Hops: []models.TracerouteHop{
+				{TTL: 1, Address: "198.51.100.1", RTTs: []*float64{&rtt, nil}},
+				{TTL: 2, RTTs: []*float64{nil, nil}},
+			},
+			Done: true,
+		}
+		if err := s.SaveTraceroute(ctx, tr); err != nil {
+			t.Fatalf("SaveTraceroute: %v", err)
+		}
+	}
+
+	got, err := s.GetTraceroute(ctx, "b")
+	if err != nil {
+		t.Fatalf("GetTraceroute: %v", err)
+	}
+	if len(got.Hops) != 2 || got.Hops[0].Address != "198.51.100.1" || *got.Hops[0].RTTs[0] != rtt || got.Hops[0].RTTs[1] != nil || !got.Done {
+		t.Errorf("traceroute = %+v", got)
+	}
+	if _, err := s.GetTraceroute(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetTraceroute(missing) err = %v, want ErrNotFound", err)
+	}
+
+	forResult, err := s.GetTraceroutes(ctx, "result-1", 10, 0)
+	if err != nil {
+		t.Fatalf("GetTraceroutes: %v", err)
+	}
+	if len(forResult) != 2 || forResult[0].ID != "c" || forResult[1].ID != "a" {
+		t.Errorf("traceroutes for result-1 = %+v, want c then a", forResult)
+	}
+}
@@ -0,0 +1,37 @@
+package telemetry
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware traces and measures each request. Once chi has routed it, the
+// span is named after the route pattern, such as "GET /api/history/{id}",
+// so requests for different IDs group together, and the pattern is added
+// to the request metrics.
+func Middleware(next http.Handler) http.Handler {
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			return
+		}
+		pattern := rctx.RoutePattern()
+		if pattern == "" {
+			return
+		}
+		route := attribute.String("http.route", pattern)
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(route)
+		if labeler, ok := otelhttp.LabelerFromContext(r.Context()); ok {
+			labeler.Add(route)
+		}
+	})
+	return otelhttp.NewHandler(routed, "http.server")
+}
@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Observer turns the iperf manager's events into telemetry. The server's
+// time running is an "iperf.server" span, from starting to stopping or
+// failing, with restarts as span events. Each test is an "iperf.test" span
+// from the client connecting to its result. Tests, bytes, bitrates and
+// restarts are counted, and whether the server is running is a gauge.
+type Observer struct {
+	tracer trace.Tracer
+
+	tests     metric.Int64Counter
+	bytes     metric.Int64Counter
+	bandwidth metric.Float64Histogram
+	restarts  metric.Int64Counter
+	running   atomic.Int64
+
+	mu       sync.Mutex
+	server   trace.Span
+	sessions map[string]trace.Span
+}
+
+// NewObserver returns an Observer using the global tracer and meter
+// providers, so Setup must be called first.
+func NewObserver() *Observer {
+	return newObserver(otel.GetTracerProvider(), otel.GetMeterProvider())
+}
+
+func newObserver(tp trace.TracerProvider, mp metric.MeterProvider) *Observer {
+	meter := mp.Meter(instrumentationName)
+	o := &Observer{
+		tracer:   tp.Tracer(instrumentationName),
+		sessions: make(map[string]trace.Span),
+	}
+	o.tests, _ = meter.Int64Counter("iperf.tests",
+		metric.WithDescription("Tests finished, by status and protocol"), metric.WithUnit("{test}"))
+	o.bytes, _ = meter.Int64Counter("iperf.transferred",
+		metric.WithDescription("Bytes transferred by finished tests"), metric.WithUnit("By"))
+	o.bandwidth, _ = meter.Float64Histogram("iperf.test.bitrate",
+		metric.WithDescription("Average bitrate of completed tests"), metric.WithUnit("bit/s"))
+	o.restarts, _ = meter.Int64Counter("iperf.process.restarts",
+		metric.WithDescription("Automatic restarts of iperf processes"), metric.WithUnit("{restart}"))
+	meter.Int64ObservableGauge("iperf.server.running",
+		metric.WithDescription("1 while the iperf server is running, else 0"),
+		metric.WithInt64Callback(func(_ context.Context, obs metric.Int64Observer) error {
+			obs.Observe(o.running.Load())
+			return nil
+		}))
+	return o
+}
+
+// Observe records one manager event.
+func (o *Observer) Observe(msg models.WSMessage) {
+	switch payload := msg.Payload.(type) {
+	case models.ServerStatusPayload:
+		o.serverStatus(payload)
+	case *models.ConnectionEvent:
+		if msg.Type == models.WSMessageTypeClientConnected {
+			o.testStarted(payload)
+		}
+	case *models.TestResult:
+		o.testFinished(payload)
+	case *models.RestartEvent:
+		o.restarted(payload)
+	}
+}
+
+func (o *Observer) serverStatus(status models.ServerStatusPayload) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if status.Status == models.ServerStatusRunning {
+		o.running.Store(1)
+		if o.server == nil {
+			attrs := []attribute.KeyValue{attribute.String("iperf.listen_address", status.ListenAddr)}
+			if status.Config != nil {
+				attrs = append(attrs,
+					attribute.Int("iperf.port", status.Config.Port),
+					attribute.String("iperf.protocol", string(status.Config.Protocol)))
+			}
+			_, o.server = o.tracer.Start(context.Background(), "iperf.server", trace.WithAttributes(attrs...))
+		}
+		return
+	}
+
+	o.running.Store(0)
+	if o.server == nil {
+		return
+	}
+	if status.Status == models.ServerStatusError {
+		o.server.SetStatus(codes.Error, status.ErrorMsg)
+	}
+	o.server.End()
+	o.server = nil
+}
+
+func (o *Observer) testStarted(event *models.ConnectionEvent) {
+	if event.SessionID == "" {
+		return
+	}
+	_, span := o.tracer.Start(context.Background(), "iperf.test",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(event.Timestamp),
+		trace.WithAttributes(
+			attribute.String("iperf.session_id", event.SessionID),
+			attribute.String("client.address", event.ClientIP),
+			attribute.Int("server.port", event.ServerPort),
+		))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sessions[event.SessionID] = span
+}
+
+func (o *Observer) testFinished(result *models.TestResult) {
+	attrs := metric.WithAttributes(
+		attribute.String("iperf.status", string(result.Status)),
+		attribute.String("iperf.protocol", string(result.Protocol)),
+	)
+	ctx := context.Background()
+	o.tests.Add(ctx, 1, attrs)
+	o.bytes.Add(ctx, result.BytesTransferred, attrs)
+	if result.Status == models.TestStatusCompleted {
+		o.bandwidth.Record(ctx, result.AvgBandwidth, attrs)
+	}
+
+	o.mu.Lock()
+	span, ok := o.sessions[result.ID]
+	delete(o.sessions, result.ID)
+	o.mu.Unlock()
+	if !ok {
+		return
+	}
+	span.SetAttributes(
+		attribute.String("iperf.status", string(result.Status)),
+		attribute.String("iperf.protocol", string(result.Protocol)),
+		attribute.String("iperf.direction", result.Direction),
+		attribute.Int64("iperf.bytes", result.BytesTransferred),
+		attribute.Float64("iperf.bitrate", result.AvgBandwidth),
+	)
+	if result.Status != models.TestStatusCompleted {
+		span.SetStatus(codes.Error, result.ErrorMessage)
+	}
+	span.End()
+}
+
+func (o *Observer) restarted(event *models.RestartEvent) {
+	// The supervisor also reports giving up, which is no restart
+	if !event.CircuitOpen {
+		o.restarts.Add(context.Background(), 1)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.server != nil {
+		o.server.AddEvent("restart", trace.WithAttributes(
+			attribute.Int("iperf.restart.attempt", event.Attempt),
+			attribute.String("iperf.restart.reason", event.Reason),
+			attribute.Bool("iperf.restart.circuit_open", event.CircuitOpen),
+		))
+	}
+}
@@ -0,0 +1,451 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStore wraps a store with a span and a duration measurement for
+// each call that takes a context. The others pass through.
+type tracedStore struct {
+	storage.Store
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+}
+
+// Store returns s instrumented with the global tracer and meter providers,
+// so Setup must be called first.
+func Store(s storage.Store) storage.Store {
+	return newStore(s, otel.GetTracerProvider(), otel.GetMeterProvider())
+}
+
+func newStore(s storage.Store, tp trace.TracerProvider, mp metric.MeterProvider) *tracedStore {
+	duration, _ := mp.Meter(instrumentationName).Float64Histogram("storage.operation.duration",
+		metric.WithDescription("Duration of storage calls"), metric.WithUnit("s"))
+	return &tracedStore{Store: s, tracer: tp.Tracer(instrumentationName), duration: duration}
+}
+
+// start opens the span of a storage call and returns a function that ends
+// it with the call's error.
+func (s *tracedStore) start(ctx context.Context, op string) (context.Context, func(error)) {
+	operation := attribute.String("db.operation.name", op)
+	ctx, span := s.tracer.Start(ctx, "storage."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(operation))
+	began := time.Now()
+	return ctx, func(err error) {
+		attrs := []attribute.KeyValue{operation}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			attrs = append(attrs, attribute.String("error.type", "error"))
+		}
+		s.duration.Record(ctx, time.Since(began).Seconds(), metric.WithAttributes(attrs...))
+		span.End()
+	}
+}
+
+func (s *tracedStore) GetTestResult(ctx context.Context, id string) (*models.TestResult, error) {
+	ctx, end := s.start(ctx, "GetTestResult")
+	v, err := s.Store.GetTestResult(ctx, id)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveTestResult(ctx context.Context, result *models.TestResult) error {
+	ctx, end := s.start(ctx, "SaveTestResult")
+	err := s.Store.SaveTestResult(ctx, result)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) SaveTestResultWithSamples(ctx context.Context, result *models.TestResult, samples []models.IntervalSample) error {
+	ctx, end := s.start(ctx, "SaveTestResultWithSamples")
+	err := s.Store.SaveTestResultWithSamples(ctx, result, samples)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) UpdateTestResultNotes(ctx context.Context, id string, tags []string, note string) error {
+	ctx, end := s.start(ctx, "UpdateTestResultNotes")
+	err := s.Store.UpdateTestResultNotes(ctx, id, tags, note)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) UpdateTestResult(ctx context.Context, result *models.TestResult) error {
+	ctx, end := s.start(ctx, "UpdateTestResult")
+	err := s.Store.UpdateTestResult(ctx, result)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetTestResults(ctx context.Context, limit, offset int) ([]models.TestResult, error) {
+	ctx, end := s.start(ctx, "GetTestResults")
+	v, err := s.Store.GetTestResults(ctx, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetTestResultsByClientIP(ctx context.Context, clientIP string, limit, offset int) ([]models.TestResult, error) {
+	ctx, end := s.start(ctx, "GetTestResultsByClientIP")
+	v, err := s.Store.GetTestResultsByClientIP(ctx, clientIP, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) QueryTestResults(ctx context.Context, filter storage.HistoryFilter, limit, offset int) ([]models.TestResult, error) {
+	ctx, end := s.start(ctx, "QueryTestResults")
+	v, err := s.Store.QueryTestResults(ctx, filter, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetTestResultsBetween(ctx context.Context, from, to time.Time) ([]models.TestResult, error) {
+	ctx, end := s.start(ctx, "GetTestResultsBetween")
+	v, err := s.Store.GetTestResultsBetween(ctx, from, to)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetTotalCount(ctx context.Context) (int, error) {
+	ctx, end := s.start(ctx, "GetTotalCount")
+	v, err := s.Store.GetTotalCount(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) CountTestResults(ctx context.Context, filter storage.HistoryFilter) (int, error) {
+	ctx, end := s.start(ctx, "CountTestResults")
+	v, err := s.Store.CountTestResults(ctx, filter)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) LatestResultTime(ctx context.Context, filter storage.HistoryFilter) (time.Time, error) {
+	ctx, end := s.start(ctx, "LatestResultTime")
+	v, err := s.Store.LatestResultTime(ctx, filter)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetTestSamples(ctx context.Context, resultID string) ([]models.IntervalSample, error) {
+	ctx, end := s.start(ctx, "GetTestSamples")
+	v, err := s.Store.GetTestSamples(ctx, resultID)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveRawOutput(ctx context.Context, resultID string, output []byte) error {
+	ctx, end := s.start(ctx, "SaveRawOutput")
+	err := s.Store.SaveRawOutput(ctx, resultID, output)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetRawOutput(ctx context.Context, resultID string) ([]byte, error) {
+	ctx, end := s.start(ctx, "GetRawOutput")
+	v, err := s.Store.GetRawOutput(ctx, resultID)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) ListRawOutputIDs(ctx context.Context) ([]string, error) {
+	ctx, end := s.start(ctx, "ListRawOutputIDs")
+	v, err := s.Store.ListRawOutputIDs(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) WarmResultStats(ctx context.Context) error {
+	ctx, end := s.start(ctx, "WarmResultStats")
+	err := s.Store.WarmResultStats(ctx)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) RebuildResultStats(ctx context.Context) error {
+	ctx, end := s.start(ctx, "RebuildResultStats")
+	err := s.Store.RebuildResultStats(ctx)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetResultStatsBetween(ctx context.Context, from, to time.Time) ([]models.ResultStat, error) {
+	ctx, end := s.start(ctx, "GetResultStatsBetween")
+	v, err := s.Store.GetResultStatsBetween(ctx, from, to)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveCostCenterAssignment(ctx context.Context, a *models.CostCenterAssignment) error {
+	ctx, end := s.start(ctx, "SaveCostCenterAssignment")
+	err := s.Store.SaveCostCenterAssignment(ctx, a)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) ListCostCenterAssignments(ctx context.Context) ([]models.CostCenterAssignment, error) {
+	ctx, end := s.start(ctx, "ListCostCenterAssignments")
+	v, err := s.Store.ListCostCenterAssignments(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteCostCenterAssignment(ctx context.Context, id int64) error {
+	ctx, end := s.start(ctx, "DeleteCostCenterAssignment")
+	err := s.Store.DeleteCostCenterAssignment(ctx, id)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) SaveLinkCapacity(ctx context.Context, c *models.LinkCapacity) error {
+	ctx, end := s.start(ctx, "SaveLinkCapacity")
+	err := s.Store.SaveLinkCapacity(ctx, c)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) ListLinkCapacities(ctx context.Context) ([]models.LinkCapacity, error) {
+	ctx, end := s.start(ctx, "ListLinkCapacities")
+	v, err := s.Store.ListLinkCapacities(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteLinkCapacity(ctx context.Context, id int64) error {
+	ctx, end := s.start(ctx, "DeleteLinkCapacity")
+	err := s.Store.DeleteLinkCapacity(ctx, id)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) CreateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	ctx, end := s.start(ctx, "CreateAlertRule")
+	err := s.Store.CreateAlertRule(ctx, rule)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) UpdateAlertRule(ctx context.Context, rule *models.AlertRule) error {
+	ctx, end := s.start(ctx, "UpdateAlertRule")
+	err := s.Store.UpdateAlertRule(ctx, rule)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetAlertRule(ctx context.Context, id int64) (*models.AlertRule, error) {
+	ctx, end := s.start(ctx, "GetAlertRule")
+	v, err := s.Store.GetAlertRule(ctx, id)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) ListAlertRules(ctx context.Context) ([]models.AlertRule, error) {
+	ctx, end := s.start(ctx, "ListAlertRules")
+	v, err := s.Store.ListAlertRules(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetEnabledAlertRulesForClient(ctx context.Context, clientIP string) ([]models.AlertRule, error) {
+	ctx, end := s.start(ctx, "GetEnabledAlertRulesForClient")
+	v, err := s.Store.GetEnabledAlertRulesForClient(ctx, clientIP)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteAlertRule(ctx context.Context, id int64) error {
+	ctx, end := s.start(ctx, "DeleteAlertRule")
+	err := s.Store.DeleteAlertRule(ctx, id)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) SaveAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	ctx, end := s.start(ctx, "SaveAuditEntry")
+	err := s.Store.SaveAuditEntry(ctx, e)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) QueryAuditLog(ctx context.Context, filter storage.AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	ctx, end := s.start(ctx, "QueryAuditLog")
+	v, err := s.Store.QueryAuditLog(ctx, filter, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) CountAuditLog(ctx context.Context, filter storage.AuditFilter) (int, error) {
+	ctx, end := s.start(ctx, "CountAuditLog")
+	v, err := s.Store.CountAuditLog(ctx, filter)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveCollision(ctx context.Context, c *models.Collision) error {
+	ctx, end := s.start(ctx, "SaveCollision")
+	err := s.Store.SaveCollision(ctx, c)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetCollisionsBetween(ctx context.Context, from, to time.Time) ([]models.Collision, error) {
+	ctx, end := s.start(ctx, "GetCollisionsBetween")
+	v, err := s.Store.GetCollisionsBetween(ctx, from, to)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveLatencyResult(ctx context.Context, r *models.LatencyResult) error {
+	ctx, end := s.start(ctx, "SaveLatencyResult")
+	err := s.Store.SaveLatencyResult(ctx, r)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetLatencyResults(ctx context.Context, target string, limit, offset int) ([]models.LatencyResult, error) {
+	ctx, end := s.start(ctx, "GetLatencyResults")
+	v, err := s.Store.GetLatencyResults(ctx, target, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveMTUResult(ctx context.Context, r *models.MTUResult) error {
+	ctx, end := s.start(ctx, "SaveMTUResult")
+	err := s.Store.SaveMTUResult(ctx, r)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetMTUResults(ctx context.Context, target string, limit, offset int) ([]models.MTUResult, error) {
+	ctx, end := s.start(ctx, "GetMTUResults")
+	v, err := s.Store.GetMTUResults(ctx, target, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveTraceroute(ctx context.Context, t *models.Traceroute) error {
+	ctx, end := s.start(ctx, "SaveTraceroute")
+	err := s.Store.SaveTraceroute(ctx, t)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetTraceroute(ctx context.Context, id string) (*models.Traceroute, error) {
+	ctx, end := s.start(ctx, "GetTraceroute")
+	v, err := s.Store.GetTraceroute(ctx, id)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetTraceroutes(ctx context.Context, resultID string, limit, offset int) ([]models.Traceroute, error) {
+	ctx, end := s.start(ctx, "GetTraceroutes")
+	v, err := s.Store.GetTraceroutes(ctx, resultID, limit, offset)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveConfigVersion(ctx context.Context, v *models.ConfigVersion) error {
+	ctx, end := s.start(ctx, "SaveConfigVersion")
+	err := s.Store.SaveConfigVersion(ctx, v)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) LatestConfigVersion(ctx context.Context) (*models.ConfigVersion, error) {
+	ctx, end := s.start(ctx, "LatestConfigVersion")
+	v, err := s.Store.LatestConfigVersion(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetConfigVersionsUntil(ctx context.Context, to time.Time) ([]models.ConfigVersion, error) {
+	ctx, end := s.start(ctx, "GetConfigVersionsUntil")
+	v, err := s.Store.GetConfigVersionsUntil(ctx, to)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveDesiredState(ctx context.Context, d *models.DesiredState) error {
+	ctx, end := s.start(ctx, "SaveDesiredState")
+	err := s.Store.SaveDesiredState(ctx, d)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) LatestDesiredState(ctx context.Context) (*models.DesiredState, error) {
+	ctx, end := s.start(ctx, "LatestDesiredState")
+	v, err := s.Store.LatestDesiredState(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) GetDesiredStates(ctx context.Context) ([]models.DesiredState, error) {
+	ctx, end := s.start(ctx, "GetDesiredStates")
+	v, err := s.Store.GetDesiredStates(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) SaveProfile(ctx context.Context, p *models.Profile) error {
+	ctx, end := s.start(ctx, "SaveProfile")
+	err := s.Store.SaveProfile(ctx, p)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) GetProfile(ctx context.Context, name string) (*models.Profile, error) {
+	ctx, end := s.start(ctx, "GetProfile")
+	v, err := s.Store.GetProfile(ctx, name)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) ListProfiles(ctx context.Context) ([]models.Profile, error) {
+	ctx, end := s.start(ctx, "ListProfiles")
+	v, err := s.Store.ListProfiles(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteProfile(ctx context.Context, name string) error {
+	ctx, end := s.start(ctx, "DeleteProfile")
+	err := s.Store.DeleteProfile(ctx, name)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) SavePeer(ctx context.Context, p *models.Peer) error {
+	ctx, end := s.start(ctx, "SavePeer")
+	err := s.Store.SavePeer(ctx, p)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) ListPeers(ctx context.Context) ([]models.Peer, error) {
+	ctx, end := s.start(ctx, "ListPeers")
+	v, err := s.Store.ListPeers(ctx)
+	end(err)
+	return v, err
+}
+
+func (s *tracedStore) DeletePeer(ctx context.Context, name string) error {
+	ctx, end := s.start(ctx, "DeletePeer")
+	err := s.Store.DeletePeer(ctx, name)
+	end(err)
+	return err
+}
+
+func (s *tracedStore) ReplaceConfiguration(ctx context.Context, b *models.ConfigBundle) error {
+	ctx, end := s.start(ctx, "ReplaceConfiguration")
+	err := s.Store.ReplaceConfiguration(ctx, b)
+	end(err)
+	return err
+}
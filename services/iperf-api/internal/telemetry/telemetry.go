@@ -0,0 +1,91 @@
+// Package telemetry exports OpenTelemetry traces and metrics over OTLP and
+// instruments the HTTP API, storage calls and the iperf server lifecycle.
+// Exporting is configured by the standard OTEL_* environment variables;
+// without an OTLP endpoint the global providers stay no-ops.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ServiceName names the service unless OTEL_SERVICE_NAME says otherwise.
+const ServiceName = "iperf-api"
+
+// instrumentationName names the tracer and meter of this service's own
+// instrumentation.
+const instrumentationName = "github.com/Tom-Oram/fak/backend"
+
+// Signals reports which signals the environment asks to export: those with
+// an OTLP endpoint, general or their own, whose OTEL_*_EXPORTER is not
+// "none". OTEL_SDK_DISABLED=true turns both off.
+func Signals(getenv func(string) string) (traces, metrics bool) {
+	if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+		return false, false
+	}
+	general := getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != ""
+	traces = (general || getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "") && getenv("OTEL_TRACES_EXPORTER") != "none"
+	metrics = (general || getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT") != "") && getenv("OTEL_METRICS_EXPORTER") != "none"
+	return traces, metrics
+}
+
+// Setup installs global tracer and meter providers exporting the signals
+// Signals reports over OTLP/HTTP, and the W3C trace context propagator.
+// The exporters, sampler and export interval read their own OTEL_*
+// variables. It returns a function that flushes and stops the providers.
+func Setup(ctx context.Context, getenv func(string) string) (shutdown func(context.Context) error, err error) {
+	traces, metrics := Signals(getenv)
+	var shutdowns []func(context.Context) error
+	shutdown = func(ctx context.Context) error {
+		var errs []error
+		for _, fn := range shutdowns {
+			errs = append(errs, fn(ctx))
+		}
+		return errors.Join(errs...)
+	}
+	if !traces && !metrics {
+		return shutdown, nil
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if traces {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+		otel.SetTracerProvider(tp)
+		shutdowns = append(shutdowns, tp.Shutdown)
+	}
+	if metrics {
+		exporter, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			shutdown(ctx)
+			return nil, err
+		}
+		mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)), sdkmetric.WithResource(res))
+		otel.SetMeterProvider(mp)
+		shutdowns = append(shutdowns, mp.Shutdown)
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return shutdown, nil
+}
@@ -0,0 +1,179 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/Tom-Oram/fak/backend/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestSignals(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		vars            map[string]string
+		traces, metrics bool
+	}{
+		{"unset", nil, false, false},
+		{"endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, true},
+		{"traces only", map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/v1/traces"}, true, false},
+		{"metrics off", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_METRICS_EXPORTER": "none"}, true, false},
+		{"disabled", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_SDK_DISABLED": "TRUE"}, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			traces, metrics := Signals(env(tc.vars))
+			if traces != tc.traces || metrics != tc.metrics {
+				t.Errorf("Signals = %t, %t; want %t, %t", traces, metrics, tc.traces, tc.metrics)
+			}
+		})
+	}
+}
+
+func TestSetup_Unconfigured(t *testing.T) {
+	shutdown, err := Setup(context.Background(), env(nil))
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+// recorders returns a tracer provider and a meter provider whose spans and
+// metrics the test can read.
+func recorders() (*sdktrace.TracerProvider, *tracetest.SpanRecorder, *sdkmetric.MeterProvider, *sdkmetric.ManualReader) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)), spans,
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), reader
+}
+
+// sum returns the total of a counter's data points.
+func sum(t *testing.T, reader *sdkmetric.ManualReader, name string) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					total += dp.Value
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					total += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					total += int64(dp.Count)
+				}
+			}
+		}
+	}
+	return total
+}
+
+func TestMiddleware(t *testing.T) {
+	tp, spans, _, _ := recorders()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(previous)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/history/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/history/abc", nil))
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans, want 1", len(ended))
+	}
+	if name := ended[0].Name(); name != "GET /api/history/{id}" {
+		t.Errorf("span name = %q, want the route pattern", name)
+	}
+}
+
+func TestStore(t *testing.T) {
+	tp, spans, mp, reader := recorders()
+	s := newStore(storage.NewMemory(), tp, mp)
+	ctx := context.Background()
+
+	if err := s.SaveTestResult(ctx, &models.TestResult{ID: "r1", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTestResult(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetTestResult err = %v, want the store's error", err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 || ended[0].Name() != "storage.SaveTestResult" || ended[1].Name() != "storage.GetTestResult" {
+		t.Fatalf("spans = %v", ended)
+	}
+	if ended[0].Status().Code == codes.Error || ended[1].Status().Code != codes.Error {
+		t.Errorf("statuses = %v, %v; want only the failed call in error", ended[0].Status(), ended[1].Status())
+	}
+	if n := sum(t, reader, "storage.operation.duration"); n != 2 {
+		t.Errorf("recorded %d durations, want 2", n)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	tp, spans, mp, reader := recorders()
+	o := newObserver(tp, mp)
+	cfg := models.DefaultServerConfig()
+
+	o.Observe(models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{Status: models.ServerStatusRunning, Config: &cfg}})
+	if n := sum(t, reader, "iperf.server.running"); n != 1 {
+		t.Errorf("running = %d, want 1", n)
+	}
+	o.Observe(models.WSMessage{Type: models.WSMessageTypeClientConnected, Payload: &models.ConnectionEvent{SessionID: "s1", ClientIP: "10.0.0.5", Timestamp: time.Now()}})
+	o.Observe(models.WSMessage{Type: models.WSMessageTypeRestart, Payload: &models.RestartEvent{Attempt: 1, Reason: "exited"}})
+	o.Observe(models.WSMessage{Type: models.WSMessageTypeTestComplete, Payload: &models.TestResult{
+		ID: "s1", Status: models.TestStatusCompleted, Protocol: models.ProtocolTCP, BytesTransferred: 1000, AvgBandwidth: 8000,
+	}})
+	o.Observe(models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: models.ServerStatusPayload{Status: models.ServerStatusError, ErrorMsg: "boom"}})
+
+	ended := spans.Ended()
+	if len(ended) != 2 || ended[0].Name() != "iperf.test" || ended[1].Name() != "iperf.server" {
+		t.Fatalf("spans = %v", ended)
+	}
+	test := attribute.NewSet(ended[0].Attributes()...)
+	if v, _ := test.Value("client.address"); v.AsString() != "10.0.0.5" {
+		t.Errorf("test span attributes = %v", ended[0].Attributes())
+	}
+	if v, _ := test.Value("iperf.bytes"); v.AsInt64() != 1000 {
+		t.Errorf("test span attributes = %v", ended[0].Attributes())
+	}
+	if server := ended[1]; server.Status().Code != codes.Error || len(server.Events()) != 1 || server.Events()[0].Name != "restart" {
+		t.Errorf("server span status %v, events %v", server.Status(), server.Events())
+	}
+
+	for name, want := range map[string]int64{"iperf.tests": 1, "iperf.transferred": 1000, "iperf.process.restarts": 1, "iperf.server.running": 0} {
+		if got := sum(t, reader, name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+}
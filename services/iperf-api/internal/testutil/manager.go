@@ -0,0 +1,222 @@
+// Package testutil holds fakes for tests of packages that drive iperf, so
+// they run without an iperf binary.
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+// Manager is a fake iperf.ProcessManager. Start and Stop change its status
+// and send server_status events to its handler as iperf.Manager does, and
+// Emit sends any other event, such as a finished test. Configs are checked
+// with iperf.ValidateConfig; nothing is launched or probed.
+type Manager struct {
+	handler iperf.EventHandler
+
+	mu     sync.Mutex
+	status models.ServerStatus
+	config models.ServerConfig
+	starts []models.ServerConfig
+	stops  int
+	fails  int
+	failed error
+
+	// StartErr, when set, is returned by Start instead of starting, and
+	// Output is the result Reparse returns for any output. Set them before
+	// the fake is used.
+	StartErr error
+	Output   *models.TestResult
+}
+
+var _ iperf.ProcessManager = (*Manager)(nil)
+
+// NewManager creates a stopped fake sending events to handler.
+func NewManager(handler iperf.EventHandler) *Manager {
+	return &Manager{
+		handler: handler,
+		status:  models.ServerStatusStopped,
+		config:  models.DefaultServerConfig(),
+	}
+}
+
+// Factory returns a function creating the fake for api.WithProcessManager,
+// and a function returning the fake once it has been created.
+func Factory() (func(iperf.EventHandler) iperf.ProcessManager, func() *Manager) {
+	var m *Manager
+	return func(handler iperf.EventHandler) iperf.ProcessManager {
+			m = NewManager(handler)
+			return m
+		}, func() *Manager {
+			return m
+		}
+}
+
+// Start implements iperf.ProcessManager.
+func (m *Manager) Start(cfg models.ServerConfig) error {
+	if errs := iperf.ValidateConfig(cfg); len(errs) > 0 {
+		return iperf.ConfigErrors(errs)
+	}
+	m.mu.Lock()
+	if m.StartErr != nil {
+		err := m.StartErr
+		m.mu.Unlock()
+		return err
+	}
+	if m.fails > 0 {
+		m.fails--
+		m.mu.Unlock()
+		return m.failed
+	}
+	if m.status == models.ServerStatusRunning {
+		m.mu.Unlock()
+		return iperf.ErrAlreadyRunning
+	}
+	m.status, m.config = models.ServerStatusRunning, cfg
+	m.starts = append(m.starts, cfg)
+	m.mu.Unlock()
+
+	m.sendStatus()
+	return nil
+}
+
+// Stop implements iperf.ProcessManager.
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	if m.status != models.ServerStatusRunning {
+		m.mu.Unlock()
+		return iperf.ErrNotRunning
+	}
+	m.status = models.ServerStatusStopped
+	m.stops++
+	m.mu.Unlock()
+
+	m.sendStatus()
+	return nil
+}
+
+// sendStatus sends a server_status event for the current status.
+func (m *Manager) sendStatus() {
+	m.mu.Lock()
+	payload := models.ServerStatusPayload{Status: m.status}
+	if m.status == models.ServerStatusRunning {
+		cfg := m.config
+		payload.Config = &cfg
+		payload.ListenAddr = cfg.ListenAddr()
+	}
+	m.mu.Unlock()
+	m.Emit(models.WSMessage{Type: models.WSMessageTypeServerStatus, Payload: payload})
+}
+
+// Emit sends msg to the handler as if the server had produced it.
+func (m *Manager) Emit(msg models.WSMessage) {
+	if m.handler != nil {
+		m.handler(msg)
+	}
+}
+
+// FailStarts makes the next n calls to Start return err.
+func (m *Manager) FailStarts(n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fails, m.failed = n, err
+}
+
+// Starts returns the configs the fake was started with, oldest first.
+func (m *Manager) Starts() []models.ServerConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.ServerConfig(nil), m.starts...)
+}
+
+// Stops returns how many times the fake was stopped while running.
+func (m *Manager) Stops() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stops
+}
+
+// GetStatus implements iperf.ProcessManager.
+func (m *Manager) GetStatus() models.ServerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// GetConfig implements iperf.ProcessManager.
+func (m *Manager) GetConfig() models.ServerConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+// WaitExited implements iperf.ProcessManager. There are no processes to
+// wait for.
+func (m *Manager) WaitExited(timeout time.Duration) bool {
+	return true
+}
+
+// Ports implements iperf.ProcessManager, reporting every port of the
+// running server free.
+func (m *Manager) Ports() []models.PortStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.status != models.ServerStatusRunning {
+		return nil
+	}
+	var ports []models.PortStatus
+	for _, port := range m.config.Ports() {
+		ports = append(ports, models.PortStatus{Port: port, State: models.PortStateFree})
+	}
+	return ports
+}
+
+// Live implements iperf.ProcessManager with no throughput.
+func (m *Manager) Live() models.LiveThroughput {
+	return models.LiveThroughput{}
+}
+
+// Diagnostics implements iperf.ProcessManager; the fake has no processes.
+func (m *Manager) Diagnostics() *models.ProcessDiagnostics {
+	return nil
+}
+
+// Supervisor implements iperf.ProcessManager; the fake has no restart
+// policy.
+func (m *Manager) Supervisor() *models.SupervisorStatus {
+	return nil
+}
+
+// DryRun implements iperf.ProcessManager, checking only the config.
+func (m *Manager) DryRun(cfg models.ServerConfig, queued bool) iperf.DryRunPlan {
+	return m.Validate(cfg)
+}
+
+// Validate implements iperf.ProcessManager, checking only the config.
+func (m *Manager) Validate(cfg models.ServerConfig) iperf.DryRunPlan {
+	var plan iperf.DryRunPlan
+	for _, err := range iperf.ValidateConfig(cfg) {
+		plan.Checks = append(plan.Checks, iperf.DryRunCheck{Name: iperf.CheckConfig, Field: err.Field, Err: err})
+	}
+	return plan
+}
+
+// Reparse implements iperf.ProcessManager, returning a copy of Output.
+func (m *Manager) Reparse(output []byte) *models.TestResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Output == nil {
+		return nil
+	}
+	result := *m.Output
+	return &result
+}
+
+// Binary implements iperf.ProcessManager, describing an iperf3 that cannot
+// be asked its version and reports text.
+func (m *Manager) Binary() models.IperfBinaryInfo {
+	return models.IperfBinaryInfo{Path: "iperf3", OutputMode: models.OutputModeText, Parser: models.IperfParserText}
+}
@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Tom-Oram/fak/backend/internal/iperf"
+	"github.com/Tom-Oram/fak/backend/internal/models"
+)
+
+func TestManager(t *testing.T) {
+	var events []models.ServerStatus
+	m := NewManager(func(msg models.WSMessage) {
+		if payload, ok := msg.Payload.(models.ServerStatusPayload); ok {
+			events = append(events, payload.Status)
+		}
+	})
+
+	if err := m.Stop(); !errors.Is(err, iperf.ErrNotRunning) {
+		t.Errorf("Stop while stopped = %v, want ErrNotRunning", err)
+	}
+	cfg := models.DefaultServerConfig()
+	cfg.Port = 0
+	var invalid iperf.ConfigErrors
+	if err := m.Start(cfg); !errors.As(err, &invalid) {
+		t.Errorf("Start with port 0 = %v, want config errors", err)
+	}
+	if plan := m.DryRun(cfg, false); plan.OK() {
+		t.Error("dry run of an invalid config passed")
+	}
+
+	cfg.Port = 5201
+	if err := m.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := m.Start(cfg); !errors.Is(err, iperf.ErrAlreadyRunning) {
+		t.Errorf("second Start = %v, want ErrAlreadyRunning", err)
+	}
+	if m.GetStatus() != models.ServerStatusRunning || m.GetConfig().Port != 5201 || len(m.Ports()) != 1 {
+		t.Errorf("status %s, config %+v, ports %+v", m.GetStatus(), m.GetConfig(), m.Ports())
+	}
+	if err := m.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if len(m.Starts()) != 1 || m.Stops() != 1 || m.Ports() != nil {
+		t.Errorf("starts %d, stops %d, ports %+v", len(m.Starts()), m.Stops(), m.Ports())
+	}
+	want := []models.ServerStatus{models.ServerStatusRunning, models.ServerStatusStopped}
+	if len(events) != 2 || events[0] != want[0] || events[1] != want[1] {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+
+	m.StartErr = errors.New("boom")
+	if err := m.Start(cfg); err == nil || err.Error() != "boom" {
+		t.Errorf("Start with StartErr = %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+// Package tunnel keeps an outbound WebSocket connection to a central relay so
+// a probe behind NAT can be managed without inbound ports. The relay sends
+// API requests down the tunnel and receives the responses and every event
+// the probe broadcasts.
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// Frame types exchanged with the relay, one JSON object per text message.
+const (
+	// FrameRequest is an API request from the relay
+	FrameRequest = "request"
+	// FrameResponse answers the request with the same ID
+	FrameResponse = "response"
+	// FrameEvent carries a broadcast message, as sent to /ws clients
+	FrameEvent = "event"
+)
+
+// Frame is one message on the tunnel. Bodies are base64 in JSON.
+type Frame struct {
+	Type   string      `json:"type"`
+	ID     string      `json:"id,omitempty"`
+	Method string      `json:"method,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	// RemoteAddr is the caller at the relay, recorded in the audit log
+	RemoteAddr string          `json:"remoteAddr,omitempty"`
+	Status     int             `json:"status,omitempty"`
+	Body       []byte          `json:"body,omitempty"`
+	Event      json.RawMessage `json:"event,omitempty"`
+}
+
+// Config configures the tunnel.
+type Config struct {
+	// URL is the relay's ws:// or wss:// endpoint
+	URL string
+	// ProbeID identifies this probe to the relay
+	ProbeID string
+	// Token is sent as a bearer token when connecting
+	Token string
+	// Backoff is the delay before the first reconnect; it doubles up to
+	// MaxBackoff while the relay stays unreachable
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PingInterval keeps NAT mappings open; the connection is dropped when
+	// the relay does not answer within two intervals
+	PingInterval time.Duration
+}
+
+// Validate checks the relay URL and probe ID and applies defaults.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid relay URL: %w", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("relay URL %q must use ws or wss", c.URL)
+	}
+	if c.ProbeID == "" {
+		return errors.New("probe ID is required")
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = time.Second
+	}
+	if c.MaxBackoff < c.Backoff {
+		c.MaxBackoff = time.Minute
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = 30 * time.Second
+	}
+	return nil
+}
+
+// EventSource subscribes to broadcast messages. The channel is closed by
+// unsubscribe or when the subscriber falls behind.
+type EventSource func() (events <-chan []byte, unsubscribe func())
+
+// Tunnel maintains the connection to the relay.
+type Tunnel struct {
+	cfg    Config
+	dialer *websocket.Dialer
+
+	mu     sync.Mutex
+	status models.TunnelStatus
+}
+
+// New creates a tunnel for a validated config.
+func New(cfg Config) *Tunnel {
+	return &Tunnel{
+		cfg:    cfg,
+		dialer: websocket.DefaultDialer,
+		status: models.TunnelStatus{
+			Relay:   redact(cfg.URL),
+			ProbeID: cfg.ProbeID,
+			State:   models.TunnelStateConnecting,
+		},
+	}
+}
+
+// redact strips credentials and the query from a relay URL for display.
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// Status returns the connection state.
+func (t *Tunnel) Status() models.TunnelStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.status
+	if status.ConnectedSince != nil {
+		since := *status.ConnectedSince
+		status.ConnectedSince = &since
+	}
+	return status
+}
+
+func (t *Tunnel) setState(state models.TunnelState, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.State = state
+	switch state {
+	case models.TunnelStateConnected:
+		now := time.Now()
+		t.status.ConnectedSince = &now
+		t.status.LastError = ""
+	default:
+		t.status.ConnectedSince = nil
+	}
+	if err != nil {
+		t.status.LastError = err.Error()
+	}
+}
+
+// Run connects to the relay and serves requests with handler until ctx is
+// cancelled, reconnecting with backoff whenever the connection drops.
+func (t *Tunnel) Run(ctx context.Context, handler http.Handler, events EventSource) {
+	delay := t.cfg.Backoff
+	for {
+		t.setState(models.TunnelStateConnecting, nil)
+		connected, err := t.session(ctx, handler, events)
+		if ctx.Err() != nil {
+			t.setState(models.TunnelStateDisconnected, nil)
+			return
+		}
+		if connected {
+			delay = t.cfg.Backoff
+		}
+		log.Printf("Management tunnel to %s down, reconnecting in %s: %v", t.status.Relay, delay, err)
+		t.setState(models.TunnelStateDisconnected, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		t.mu.Lock()
+		t.status.Reconnects++
+		t.mu.Unlock()
+		if delay *= 2; delay > t.cfg.MaxBackoff {
+			delay = t.cfg.MaxBackoff
+		}
+	}
+}
+
+// session runs one connection until it fails, reporting whether it was
+// established.
+func (t *Tunnel) session(ctx context.Context, handler http.Handler, events EventSource) (bool, error) {
+	header := http.Header{}
+	header.Set("X-Probe-ID", t.cfg.ProbeID)
+	if t.cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+t.cfg.Token)
+	}
+	conn, resp, err := t.dialer.DialContext(ctx, t.cfg.URL, header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%w (HTTP %s)", err, resp.Status)
+		}
+		return false, err
+	}
+	defer conn.Close()
+
+	log.Printf("Management tunnel connected to %s as %s", t.status.Relay, t.cfg.ProbeID)
+	t.setState(models.TunnelStateConnected, nil)
+
+	sess := &session{conn: conn, handler: handler}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Close the connection to unblock the reader when ctx ends
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go sess.forwardEvents(ctx, events)
+	go sess.ping(ctx, t.cfg.PingInterval)
+
+	return true, sess.read(ctx, t.cfg.PingInterval)
+}
+
+// session is one connection to the relay. gorilla/websocket allows one
+// concurrent writer, so writes are serialised.
+type session struct {
+	conn    *websocket.Conn
+	handler http.Handler
+
+	writeMu sync.Mutex
+}
+
+// writeTimeout bounds each write to the relay.
+const writeTimeout = 10 * time.Second
+
+func (s *session) write(messageType int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return s.conn.WriteMessage(messageType, data)
+}
+
+func (s *session) writeFrame(f Frame) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.write(websocket.TextMessage, data)
+}
+
+// read handles frames from the relay until the connection fails.
+func (s *session) read(ctx context.Context, pingInterval time.Duration) error {
+	deadline := func() { s.conn.SetReadDeadline(time.Now().Add(2 * pingInterval)) }
+	deadline()
+	s.conn.SetPongHandler(func(string) error {
+		deadline()
+		return nil
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		deadline()
+
+		var f Frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			log.Printf("Management tunnel: ignoring malformed frame: %v", err)
+			continue
+		}
+		if f.Type != FrameRequest {
+			continue
+		}
+		go func() {
+			if err := s.writeFrame(s.serve(ctx, f)); err != nil {
+				log.Printf("Management tunnel: sending response to %s %s failed: %v", f.Method, f.Path, err)
+			}
+		}()
+	}
+}
+
+// serve runs a tunneled request through the API handler. WebSocket
+// endpoints cannot be tunneled; events arrive as event frames instead.
+func (s *session) serve(ctx context.Context, f Frame) Frame {
+	resp := Frame{Type: FrameResponse, ID: f.ID}
+	if !strings.HasPrefix(f.Path, "/") || strings.HasPrefix(f.Path, "/ws") {
+		resp.Status = http.StatusBadRequest
+		resp.Body = []byte(fmt.Sprintf("cannot tunnel %q\n", f.Path))
+		return resp
+	}
+
+	req, err := http.NewRequestWithContext(ctx, f.Method, f.Path, bytes.NewReader(f.Body))
+	if err != nil {
+		resp.Status = http.StatusBadRequest
+		resp.Body = []byte(err.Error() + "\n")
+		return resp
+	}
+	if f.Header != nil {
+		req.Header = f.Header
+	}
+	req.RemoteAddr = f.RemoteAddr
+	if req.RemoteAddr == "" {
+		req.RemoteAddr = "tunnel"
+	}
+
+	rec := newRecorder()
+	s.handler.ServeHTTP(rec, req)
+	resp.Status = rec.status
+	resp.Header = rec.header
+	resp.Body = rec.body.Bytes()
+	return resp
+}
+
+// forwardEvents sends every broadcast message to the relay, subscribing
+// again if the subscription is dropped for falling behind.
+func (s *session) forwardEvents(ctx context.Context, events EventSource) {
+	for ctx.Err() == nil {
+		ch, unsubscribe := events()
+		s.drain(ctx, ch)
+		unsubscribe()
+	}
+}
+
+func (s *session) drain(ctx context.Context, ch <-chan []byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := s.writeFrame(Frame{Type: FrameEvent, Event: data}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ping keeps the connection alive through NAT.
+func (s *session) ping(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.write(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// recorder buffers a handler's response for sending as one frame.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) Write(p []byte) (int, error) { return r.body.Write(p) }
+
+func (r *recorder) WriteHeader(status int) { r.status = status }
@@ -0,0 +1,256 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Oram/fak/backend/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// fakeRelay accepts one probe connection at a time and hands it to the test.
+type fakeRelay struct {
+	srv   *httptest.Server
+	conns chan *websocket.Conn
+	// headers receives the handshake headers of each connection
+	headers chan http.Header
+}
+
+func newFakeRelay(t *testing.T) *fakeRelay {
+	t.Helper()
+	relay := &fakeRelay{
+		conns:   make(chan *websocket.Conn, 4),
+		headers: make(chan http.Header, 4),
+	}
+	upgrader := websocket.Upgrader{}
+	relay.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		relay.headers <- r.Header
+		relay.conns <- conn
+	}))
+	t.Cleanup(relay.srv.Close)
+	return relay
+}
+
+func (f *fakeRelay) url() string {
+	return "ws" + strings.TrimPrefix(f.srv.URL, "http")
+}
+
+func (f *fakeRelay) accept(t *testing.T) *websocket.Conn {
+	t.Helper()
+	select {
+	case conn := <-f.conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("probe did not connect to the relay")
+		return nil
+	}
+}
+
+// readFrame reads frames until one of the given type arrives.
+func readFrame(t *testing.T, conn *websocket.Conn, typ string) Frame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var f Frame
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("reading frame: %v", err)
+		}
+		if f.Type == typ {
+			return f
+		}
+	}
+}
+
+// staticEvents is an EventSource fed by the test.
+func staticEvents(ch chan []byte) EventSource {
+	return func() (<-chan []byte, func()) {
+		return ch, func() {}
+	}
+}
+
+func waitForState(t *testing.T, tun *Tunnel, state models.TunnelState) models.TunnelStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := tun.Status(); st.State == state {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("tunnel state = %s, want %s", tun.Status().State, state)
+	return models.TunnelStatus{}
+}
+
+func startTunnel(t *testing.T, cfg Config, handler http.Handler, events EventSource) *Tunnel {
+	t.Helper()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	tun := New(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tun.Run(ctx, handler, events)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return tun
+}
+
+func TestTunnel_ServesRequestsAndForwardsEvents(t *testing.T) {
+	relay := newFakeRelay(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Key", r.Header.Get("X-API-Key"))
+		w.Header().Set("X-Remote", r.RemoteAddr)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Method + " " + r.URL.String()))
+	})
+	events := make(chan []byte, 1)
+	tun := startTunnel(t, Config{URL: relay.url(), ProbeID: "branch-7", Token: "s3cret"}, handler, staticEvents(events))
+
+	conn := relay.accept(t)
+	header := <-relay.headers
+	if got := header.Get("X-Probe-ID"); got != "branch-7" {
+		t.Errorf("X-Probe-ID = %q, want branch-7", got)
+	}
+	if got := header.Get("Authorization"); got != "Bearer s3cret" {
+		t.Errorf("Authorization = %q, want bearer token", got)
+	}
+
+	st := waitForState(t, tun, models.TunnelStateConnected)
+	if st.ConnectedSince == nil || st.ProbeID != "branch-7" {
+		t.Errorf("status = %+v", st)
+	}
+
+	req := Frame{
+		Type:       FrameRequest,
+		ID:         "r1",
+		Method:     http.MethodPost,
+		Path:       "/api/server/start?x=1",
+		Header:     http.Header{"X-Api-Key": {"k"}},
+		RemoteAddr: "198.51.100.4:443",
+	}
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatal(err)
+	}
+	resp := readFrame(t, conn, FrameResponse)
+	if resp.ID != "r1" || resp.Status != http.StatusCreated {
+		t.Errorf("response = %+v", resp)
+	}
+	if string(resp.Body) != "POST /api/server/start?x=1" {
+		t.Errorf("body = %q", resp.Body)
+	}
+	if resp.Header.Get("X-Seen-Key") != "k" || resp.Header.Get("X-Remote") != "198.51.100.4:443" {
+		t.Errorf("headers = %v", resp.Header)
+	}
+
+	events <- []byte(`{"type":"server_status"}`)
+	ev := readFrame(t, conn, FrameEvent)
+	if string(ev.Event) != `{"type":"server_status"}` {
+		t.Errorf("event = %s", ev.Event)
+	}
+}
+
+func TestTunnel_RejectsWebSocketPaths(t *testing.T) {
+	relay := newFakeRelay(t)
+	called := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+	startTunnel(t, Config{URL: relay.url(), ProbeID: "p"}, handler, staticEvents(make(chan []byte)))
+
+	conn := relay.accept(t)
+	conn.WriteJSON(Frame{Type: FrameRequest, ID: "w", Method: http.MethodGet, Path: "/ws"})
+	resp := readFrame(t, conn, FrameResponse)
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.Status)
+	}
+	if called {
+		t.Error("handler called for /ws")
+	}
+}
+
+func TestTunnel_ReconnectsAfterDrop(t *testing.T) {
+	relay := newFakeRelay(t)
+	handler := http.NotFoundHandler()
+	tun := startTunnel(t, Config{URL: relay.url(), ProbeID: "p", Backoff: 10 * time.Millisecond}, handler, staticEvents(make(chan []byte)))
+
+	first := relay.accept(t)
+	waitForState(t, tun, models.TunnelStateConnected)
+	first.Close()
+
+	second := relay.accept(t)
+	second.WriteJSON(Frame{Type: FrameRequest, ID: "again", Method: http.MethodGet, Path: "/api/status"})
+	if resp := readFrame(t, second, FrameResponse); resp.ID != "again" || resp.Status != http.StatusNotFound {
+		t.Errorf("response = %+v", resp)
+	}
+	if st := tun.Status(); st.Reconnects < 1 || st.LastError != "" {
+		t.Errorf("status after reconnect = %+v", st)
+	}
+}
+
+func TestTunnel_UnreachableRelay(t *testing.T) {
+	relay := newFakeRelay(t)
+	url := relay.url()
+	relay.srv.Close()
+
+	tun := startTunnel(t, Config{URL: url, ProbeID: "p", Backoff: 10 * time.Millisecond}, http.NotFoundHandler(), staticEvents(make(chan []byte)))
+	st := waitForState(t, tun, models.TunnelStateDisconnected)
+	if st.LastError == "" {
+		t.Error("expected the dial error in status")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"wss", Config{URL: "wss://relay.example.com/probes", ProbeID: "p"}, true},
+		{"http scheme", Config{URL: "https://relay.example.com", ProbeID: "p"}, false},
+		{"no probe id", Config{URL: "ws://relay"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			err := cfg.Validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("Validate() = %v, want ok=%v", err, tt.ok)
+			}
+			if tt.ok && (cfg.Backoff != time.Second || cfg.MaxBackoff != time.Minute || cfg.PingInterval != 30*time.Second) {
+				t.Errorf("defaults not applied: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestNew_RedactsRelayCredentials(t *testing.T) {
+	tun := New(Config{URL: "wss://user:pw@relay.example.com/probes?token=x", ProbeID: "p"})
+	if got := tun.Status().Relay; got != "wss://relay.example.com/probes" {
+		t.Errorf("Relay = %q", got)
+	}
+}
+
+// Frames must round-trip as JSON with base64 bodies.
+func TestFrameJSON(t *testing.T) {
+	data, err := json.Marshal(Frame{Type: FrameResponse, ID: "1", Status: 200, Body: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"body":"e30="`) {
+		t.Errorf("frame = %s", data)
+	}
+}
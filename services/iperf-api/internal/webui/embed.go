@@ -0,0 +1,15 @@
+//go:build embedwebui
+
+package webui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:assets
+var assets embed.FS
+
+func init() {
+	embedded, _ = fs.Sub(assets, "assets")
+}
@@ -0,0 +1,73 @@
+// Package webui serves the dashboard's static bundle from the backend.
+//
+// Builds may embed the bundle (build tag "embedwebui") so a single binary
+// serves both the API and the dashboard without a separate web server.
+package webui
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// embedded holds the bundle when built with the embedwebui tag. It is nil
+// otherwise.
+var embedded fs.FS
+
+// Embedded returns the bundle built into the binary, or nil if this build
+// has none.
+func Embedded() fs.FS {
+	if embedded == nil {
+		return nil
+	}
+	if _, err := fs.Stat(embedded, "index.html"); err != nil {
+		return nil
+	}
+	return embedded
+}
+
+// Handler serves the files in fsys. Paths without a file are routed by the
+// single-page app, so they get index.html; missing files with an extension,
+// such as a stale script, are 404s. Vite's content-hashed files under
+// assets/ are cached for good, index.html never is.
+func Handler(fsys fs.FS) http.Handler {
+	files := http.FileServer(http.FS(fsys))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+		if name != "" && name != "index.html" {
+			if info, err := fs.Stat(fsys, name); err == nil && !info.IsDir() {
+				if strings.HasPrefix(name, "assets/") {
+					w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+				}
+				files.ServeHTTP(w, r)
+				return
+			}
+			if path.Ext(name) != "" {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		serveIndex(w, r, fsys)
+	})
+}
+
+// serveIndex writes index.html directly, since http.FileServer redirects
+// requests for it to the directory.
+func serveIndex(w http.ResponseWriter, r *http.Request, fsys fs.FS) {
+	index, err := fs.ReadFile(fsys, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method != http.MethodHead {
+		w.Write(index)
+	}
+}
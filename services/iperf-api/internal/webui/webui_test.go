@@ -0,0 +1,68 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHandler(t *testing.T) {
+	bundle := fstest.MapFS{
+		"index.html":          {Data: []byte("<html>dashboard</html>")},
+		"favicon.svg":         {Data: []byte("<svg/>")},
+		"assets/index-1a.js":  {Data: []byte("console.log(1)")},
+		"assets/index-1a.css": {Data: []byte("body{}")},
+	}
+	h := Handler(bundle)
+
+	tests := []struct {
+		path      string
+		wantCode  int
+		wantBody  string
+		wantCache string
+	}{
+		{"/", http.StatusOK, "<html>dashboard</html>", "no-cache"},
+		{"/index.html", http.StatusOK, "<html>dashboard</html>", "no-cache"},
+		{"/iperf-server/history", http.StatusOK, "<html>dashboard</html>", "no-cache"},
+		{"/assets", http.StatusOK, "<html>dashboard</html>", "no-cache"},
+		{"/assets/index-1a.js", http.StatusOK, "console.log(1)", "public, max-age=31536000, immutable"},
+		{"/favicon.svg", http.StatusOK, "<svg/>", ""},
+		{"/assets/index-0f.js", http.StatusNotFound, "", ""},
+		{"/../../etc/passwd", http.StatusOK, "<html>dashboard</html>", "no-cache"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", tt.path, rec.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body %q, want %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != tt.wantCache {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.path, got, tt.wantCache)
+		}
+	}
+}
+
+func TestHandler_NoIndex(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(fstest.MapFS{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404 without index.html", rec.Code)
+	}
+}
+
+func TestEmbedded_WithoutBundle(t *testing.T) {
+	// Test builds have no bundle, and an empty assets directory is no bundle
+	if Embedded() != nil {
+		t.Skip("built with a web UI bundle")
+	}
+	embedded = fstest.MapFS{"README.md": {Data: []byte("placeholder")}}
+	defer func() { embedded = nil }()
+	if Embedded() != nil {
+		t.Error("Embedded() returned a bundle without index.html")
+	}
+}
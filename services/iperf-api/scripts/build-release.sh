@@ -0,0 +1,51 @@
+#!/usr/bin/env sh
+# Build single-file release binaries of the iperf-api service, each embedding a
+# statically linked iperf3 for its architecture.
+#
+# Usage: scripts/build-release.sh [platform ...]
+#   Default platforms: linux/amd64 linux/arm64 linux/arm/v7
+#
+# Environment:
+#   IPERF3_VERSION  iperf3 release to build (default 3.16)
+#   VERSION         version reported by /api/version (default from git describe)
+#   DIST_DIR        output directory (default ./dist)
+#
+# Requires docker buildx with QEMU emulation for foreign architectures.
+
+set -eu
+
+cd "$(dirname "$0")/.."
+
+IPERF3_VERSION="${IPERF3_VERSION:-3.16}"
+DIST_DIR="${DIST_DIR:-dist}"
+VERSION="${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}"
+COMMIT="$(git rev-parse HEAD 2>/dev/null || true)"
+BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
+if [ "$#" -gt 0 ]; then
+    PLATFORMS="$*"
+else
+    PLATFORMS="linux/amd64 linux/arm64 linux/arm/v7"
+fi
+
+for platform in $PLATFORMS; do
+    name="iperf-api-$(echo "$platform" | tr '/' '-')"
+    out="$DIST_DIR/.build-$name"
+
+    echo "==> Building $name $VERSION (iperf3 $IPERF3_VERSION)"
+    docker buildx build \
+        -f Dockerfile.release \
+        --platform "$platform" \
+        --build-arg IPERF3_VERSION="$IPERF3_VERSION" \
+        --build-arg VERSION="$VERSION" \
+        --build-arg COMMIT="$COMMIT" \
+        --build-arg BUILD_DATE="$BUILD_DATE" \
+        --output "type=local,dest=$out" \
+        .
+
+    mv "$out/iperf-api" "$DIST_DIR/$name"
+    rm -rf "$out"
+done
+
+(cd "$DIST_DIR" && sha256sum iperf-api-* > SHA256SUMS)
+echo "==> Release binaries written to $DIST_DIR"
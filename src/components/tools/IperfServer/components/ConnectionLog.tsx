@@ -1,6 +1,6 @@
 // src/components/tools/IperfServer/components/ConnectionLog.tsx
 import { useEffect, useRef } from 'react'
-import { Wifi, CheckCircle, XCircle, Info } from 'lucide-react'
+import { Wifi, CheckCircle, XCircle, Ban, Info } from 'lucide-react'
 import type { ConnectionEvent } from '../types'
 
 interface ConnectionLogProps {
@@ -62,6 +62,8 @@ function getEventIcon(type: ConnectionEvent['eventType']) {
       return Wifi
     case 'test_complete':
       return CheckCircle
+    case 'rejected':
+      return Ban
     case 'error':
       return XCircle
     default:
@@ -75,6 +77,8 @@ function getEventColor(type: ConnectionEvent['eventType']): string {
       return 'text-blue-500'
     case 'test_complete':
       return 'text-green-500'
+    case 'rejected':
+      return 'text-amber-500'
     case 'error':
       return 'text-red-500'
     default:
@@ -20,14 +20,17 @@ export default function LiveGraph({ data, onClear }: LiveGraphProps) {
   // Safely handle data - ensure it's always an array
   const safeData = Array.isArray(data) ? data : []
 
+  // Plot the smoothed bitrate when the server sends one
+  const rate = (d: BandwidthUpdate | undefined) => d?.smoothedBitsPerSecond ?? d?.bitsPerSecond ?? 0
+
   const chartData = safeData.map((d, i) => ({
     time: i,
-    bandwidth: d?.bitsPerSecond ?? 0,
-    label: formatBandwidth(d?.bitsPerSecond ?? 0),
+    bandwidth: rate(d),
+    label: formatBandwidth(rate(d)),
   }))
 
   // Calculate Y-axis domain with safe defaults
-  const bandwidthValues = safeData.map((d) => d?.bitsPerSecond ?? 0)
+  const bandwidthValues = safeData.map(rate)
   const maxBandwidth = bandwidthValues.length > 0
     ? Math.max(...bandwidthValues, 1e6)
     : 1e6
@@ -5,10 +5,24 @@ import type {
   ServerConfig,
   BandwidthUpdate,
   ConnectionEvent,
+  Collision,
   TestResult,
   WSMessage,
   ServerStatusPayload,
+  WSSnapshot,
+  ApiErrorResponse,
 } from '../types'
+import { WS_VERSION } from '../types'
+
+// errorMessage reads the message of an API error response
+async function errorMessage(response: Response): Promise<string> {
+  try {
+    const body: ApiErrorResponse = await response.json()
+    return body.error.message
+  } catch {
+    return `HTTP ${response.status}`
+  }
+}
 
 // Auto-detect URLs based on environment
 function getApiUrls() {
@@ -91,6 +105,14 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
         break
       }
 
+      case 'snapshot': {
+        const { status: payload } = message.payload as WSSnapshot
+        setStatus(payload.status)
+        setConfig(payload.config)
+        setListenAddr(payload.listenAddr ?? '')
+        break
+      }
+
       case 'client_connected': {
         const event = message.payload as ConnectionEvent
         setConnectionLog((prev) => [
@@ -104,8 +126,8 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
         const update = message.payload as BandwidthUpdate
         setBandwidthData((prev) => {
           const newData = [...prev, update]
-          // Keep last 60 seconds of data (assuming 1 update per second)
-          return newData.slice(-60)
+          // Keep last 60 seconds of data at the server's reporting interval
+          return newData.slice(-Math.ceil(60 / (update.interval || 1)))
         })
         break
       }
@@ -124,6 +146,20 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
         break
       }
 
+      case 'collision': {
+        const collision = message.payload as Collision
+        setConnectionLog((prev) => [
+          ...prev.slice(-499),
+          {
+            timestamp: collision.timestamp,
+            clientIp: '',
+            eventType: 'rejected',
+            details: `Connection turned away: busy with ${collision.busyClientIp || 'a test'}`,
+          },
+        ])
+        break
+      }
+
       case 'error': {
         const payload = message.payload as { message: string }
         setLastError(payload.message)
@@ -144,7 +180,7 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
   const connect = useCallback(() => {
     if (wsRef.current?.readyState === WebSocket.OPEN) return
 
-    const ws = new WebSocket(WS_URL)
+    const ws = new WebSocket(`${WS_URL}?version=${WS_VERSION}`)
 
     ws.onopen = () => {
       setIsConnected(true)
@@ -194,6 +230,17 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
     }
   }, [connect])
 
+  // Pause the stream while the tab is hidden rather than reconnecting
+  useEffect(() => {
+    const onVisibilityChange = () => {
+      const ws = wsRef.current
+      if (ws?.readyState !== WebSocket.OPEN) return
+      ws.send(JSON.stringify({ action: document.hidden ? 'pause' : 'resume' }))
+    }
+    document.addEventListener('visibilitychange', onVisibilityChange)
+    return () => document.removeEventListener('visibilitychange', onVisibilityChange)
+  }, [])
+
   const startServer = useCallback(async (newConfig: ServerConfig) => {
     const response = await fetch(`${API_URL}/api/start`, {
       method: 'POST',
@@ -202,8 +249,7 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
     })
 
     if (!response.ok) {
-      const text = await response.text()
-      throw new Error(text)
+      throw new Error(await errorMessage(response))
     }
   }, [])
 
@@ -213,8 +259,7 @@ export function useIperfWebSocket(): UseIperfWebSocketReturn {
     })
 
     if (!response.ok) {
-      const text = await response.text()
-      throw new Error(text)
+      throw new Error(await errorMessage(response))
     }
   }, [])
 
@@ -2,6 +2,11 @@
 
 export type ServerStatus = 'stopped' | 'running' | 'error'
 export type Protocol = 'tcp' | 'udp'
+export type IperfVersion = 'iperf3' | 'iperf2'
+export type AddressFamily = 'ipv4' | 'ipv6'
+export type OutputMode = 'auto' | 'text' | 'json'
+export type TestStatus = 'completed' | 'aborted' | 'failed'
+export type QualityFlag = 'short_duration' | 'zero_bytes' | 'zero_min_bandwidth' | 'clock_skew'
 
 export interface ServerConfig {
   port: number
@@ -10,6 +15,13 @@ export interface ServerConfig {
   oneOff: boolean
   idleTimeout: number
   allowlist: string[]
+  version?: IperfVersion
+  portCount?: number
+  autoRearm?: boolean
+  addressFamily?: AddressFamily
+  outputMode?: OutputMode
+  // Seconds between interval reports (-i); unset reports every second
+  intervalSeconds?: number
 }
 
 export const DEFAULT_CONFIG: ServerConfig = {
@@ -21,11 +33,24 @@ export const DEFAULT_CONFIG: ServerConfig = {
   allowlist: [],
 }
 
+export interface SideStats {
+  bytes: number
+  bandwidth: number
+  retransmits?: number
+}
+
+export interface BandwidthPercentiles {
+  p50: number
+  p95: number
+  p99: number
+}
+
 export interface TestResult {
   id: string
   timestamp: string
   clientIp: string
   clientPort: number
+  serverPort?: number
   protocol: Protocol
   duration: number
   bytesTransferred: number
@@ -35,22 +60,88 @@ export interface TestResult {
   retransmits?: number
   jitter?: number
   packetLoss?: number
-  direction: 'upload' | 'download'
+  direction: 'upload' | 'download' | 'bidirectional'
+  status: TestStatus
+  errorMessage?: string
+  requestedDuration?: number
+  qualityFlags?: QualityFlag[]
+  energyJoules?: number
+  joulesPerGb?: number
+  hostCpuTotal?: number
+  remoteCpuTotal?: number
+  sender?: SideStats
+  receiver?: SideStats
+  percentiles?: BandwidthPercentiles
+  // Derived when the result was saved
+  retransmitsPerGb?: number
+  goodputRatio?: number
+  jitterRatio?: number
+  // The client's declared link capacity (bits/sec) and the percentage of it achieved
+  linkCapacity?: number
+  utilization?: number
+  // How exactly bytesTransferred was measured; missing on older results
+  precision?: 'exact' | 'corrected' | 'rounded'
+  client?: ClientFingerprint
+  source?: JobSource
+  correlationId?: string
+  geo?: GeoInfo
+  tags?: string[]
+  note?: string
+  site?: string
+  node?: string
+}
+
+export interface IntervalSample {
+  timestamp: string
+  intervalStart: number
+  intervalEnd: number
+  bytes: number
+  bitsPerSecond: number
+}
+
+export interface SamplesResponse {
+  samples: IntervalSample[]
+}
+
+export interface GeoInfo {
+  country?: string
+  asn?: number
+  isp?: string
 }
 
 export interface BandwidthUpdate {
+  sessionId?: string
+  serverPort?: number
   timestamp: number
   intervalStart: number
   intervalEnd: number
   bytes: number
   bitsPerSecond: number
+  omitted?: boolean
+  // The server's reporting interval in seconds
+  interval?: number
+  smoothedBitsPerSecond?: number
+}
+
+export interface LiveUpdate extends BandwidthUpdate {
+  clientIp?: string
+}
+
+export interface LiveThroughput {
+  latest: LiveUpdate | null
+  averageBitsPerSecond: number
+  windowSeconds: number
 }
 
 export interface ConnectionEvent {
+  sessionId?: string
+  serverPort?: number
   timestamp: string
   clientIp: string
-  eventType: 'connected' | 'test_started' | 'test_complete' | 'error'
+  eventType: 'connected' | 'test_started' | 'test_complete' | 'rejected' | 'error'
   details: string
+  geo?: GeoInfo
+  neighbor?: Neighbor
 }
 
 export type WSMessageType =
@@ -59,10 +150,49 @@ export type WSMessageType =
   | 'bandwidth_update'
   | 'test_complete'
   | 'error'
+  | 'warning'
+  | 'alert'
+  | 'queue_position'
+  | 'drift'
+  | 'restart'
+  | 'collision'
+  | 'test_slot_ready'
+  | 'slow_consumer'
+  | 'latency_ping'
+  | 'latency_result'
+  | 'traceroute_hop'
+  | 'traceroute_complete'
+  | 'hello'
+  | 'snapshot'
+  | 'test_summary'
+
+// Message schema version this dashboard understands, sent as ?version=
+export const WS_VERSION = 2
 
 export interface WSMessage<T = unknown> {
   type: WSMessageType
   payload: T
+  node?: string
+  version?: number
+}
+
+export interface WSHello {
+  version: number
+  versions: number[]
+}
+
+// Sent after test_complete when WS_TEST_SUMMARY is set
+export interface TestSummary {
+  result: TestResult
+  samples: IntervalSample[]
+}
+
+// Sent in place of the messages missed while the stream was paused
+export interface WSSnapshot {
+  status: ServerStatusPayload
+  live: LiveThroughput
+  sessions: string[]
+  missed: number
 }
 
 export interface ServerStatusPayload {
@@ -70,6 +200,62 @@ export interface ServerStatusPayload {
   config: ServerConfig
   listenAddr?: string
   errorMsg?: string
+  supervisor?: SupervisorStatus
+  process?: ProcessDiagnostics
+  tunnel?: TunnelStatus
+}
+
+export type TunnelState = 'connecting' | 'connected' | 'disconnected'
+
+export interface TunnelStatus {
+  relay: string
+  probeId: string
+  state: TunnelState
+  connectedSince?: string
+  lastError?: string
+  reconnects: number
+}
+
+export interface ProcessDiagnostics {
+  processes: ProcessInfo[]
+  restarts: number
+  lastExit?: ProcessExit
+}
+
+export interface ProcessInfo {
+  port: number
+  pid: number
+  path: string
+  argv: string[]
+  startedAt: string
+  uptime: number
+}
+
+export interface ProcessExit {
+  port: number
+  pid: number
+  exitCode: number
+  state: string
+  at: string
+}
+
+export type CircuitState = 'closed' | 'half_open' | 'open'
+
+export interface SupervisorStatus {
+  circuit: CircuitState
+  restarts: number
+  maxRetries: number
+  nextRestartAt?: string
+  lastError?: string
+}
+
+export interface RestartEvent {
+  timestamp: string
+  attempt: number
+  maxRetries: number
+  delay: number
+  reason?: string
+  circuitOpen?: boolean
 }
 
 export interface HistoryResponse {
@@ -78,3 +264,590 @@ export interface HistoryResponse {
   limit: number
   offset: number
 }
+
+export interface CostCenterAssignment {
+  id: number
+  match: string
+  costCenter: string
+  createdAt: string
+}
+
+export interface LinkCapacity {
+  id: number
+  match: string
+  // Bits per second
+  capacity: number
+  createdAt: string
+}
+
+export interface AccountingEntry {
+  costCenter: string
+  tests: number
+  bytesTransferred: number
+  clients: number
+}
+
+export interface AccountingResponse {
+  from: string
+  to: string
+  entries: AccountingEntry[]
+  annotations: Annotation[]
+}
+
+export interface AlertRule {
+  id: number
+  name: string
+  clientIp?: string
+  minAvgBandwidth?: number
+  maxPacketLoss?: number
+  maxJitter?: number
+  maxRetransmits?: number
+  maxRetransmitsPerGb?: number
+  minGoodputRatio?: number
+  maxJitterRatio?: number
+  minUtilization?: number
+  webhookUrl?: string
+  enabled: boolean
+  createdAt: string
+}
+
+export interface Alert {
+  timestamp: string
+  ruleId: number
+  ruleName: string
+  resultId: string
+  clientIp: string
+  violations: string[]
+}
+
+export interface LabelsResponse {
+  language: string
+  languages: string[]
+  labels: Record<string, Record<string, string>>
+}
+
+export type JobSource = 'adhoc' | 'ci' | 'scheduled'
+
+export type JobState = 'queued' | 'running' | 'completed' | 'failed' | 'cancelled' | 'deferred'
+
+export interface QueueJob {
+  id: string
+  source: JobSource
+  correlationId?: string
+  priority: number
+  config: ServerConfig
+  state: JobState
+  position: number
+  timeout: number
+  preemptions: number
+  deferrals?: number
+  deferReason?: string
+  deferredUntil?: string
+  resultId?: string
+  error?: string
+  enqueuedAt: string
+  startedAt?: string
+  finishedAt?: string
+}
+
+export interface QueuePosition {
+  jobId: string
+  state: JobState
+  position: number
+}
+
+export interface QueueSnapshot {
+  running: QueueJob | null
+  pending: QueueJob[]
+  recent: QueueJob[]
+  priorities: Record<JobSource, number>
+}
+
+export interface LinkUtilization {
+  source: string
+  measuredAt: string
+  rxBitsPerSecond: number
+  txBitsPerSecond: number
+  capacityBps: number
+  percent: number
+  threshold: number
+  busy: boolean
+}
+
+export interface ConfigChange {
+  field: string
+  from: string
+  to: string
+}
+
+export interface Annotation {
+  timestamp: string
+  kind: 'config_change'
+  version: number
+  changes: ConfigChange[]
+}
+
+export interface AnnotationsResponse {
+  from: string
+  to: string
+  annotations: Annotation[]
+}
+
+export interface ClientFingerprint {
+  version?: string
+  protocol?: Protocol
+  streams?: number
+  blockSize?: number
+  omit?: number
+  duration?: number
+  bytes?: number
+  blocks?: number
+  tos?: number
+  mss?: number
+  window?: number
+  bandwidth?: number
+  congestion?: string
+  features?: string[]
+  mac?: string
+  vendor?: string
+}
+
+export type AuditAction =
+  | 'server.start'
+  | 'server.stop'
+  | 'server.config_change'
+  | 'queue.enqueue'
+  | 'queue.cancel'
+  | 'queue.defer'
+  | 'alert_rule.create'
+  | 'alert_rule.update'
+  | 'alert_rule.delete'
+  | 'cost_center.save'
+  | 'cost_center.delete'
+  | 'email_config.update'
+  | 'desired_state.declare'
+  | 'drift.correct'
+  | 'config.import'
+  | 'stats.rebuild'
+  | 'backup.create'
+  | 'result.annotate'
+  | 'history.import'
+  | 'profile.save'
+  | 'profile.delete'
+  | 'peer.save'
+  | 'peer.delete'
+  | 'results.reparse'
+  | 'latency.start'
+  | 'latency.stop'
+  | 'mtu.discover'
+  | 'traceroute.start'
+  | 'report.send'
+  | 'capacity.save'
+  | 'capacity.delete'
+
+export interface AuditEntry {
+  id: number
+  timestamp: string
+  action: AuditAction
+  apiKey?: string
+  remoteIp: string
+  parameters?: Record<string, unknown>
+}
+
+export interface AuditLogResponse {
+  entries: AuditEntry[]
+  total: number
+  limit: number
+  offset: number
+}
+
+export interface DesiredState {
+  version: number
+  declaredAt: string
+  running: boolean
+  config: ServerConfig
+  autoCorrect: boolean
+}
+
+export interface DriftField {
+  field: string
+  desired: string
+  actual: string
+}
+
+export interface DriftReport {
+  checkedAt: string
+  desiredVersion: number
+  inSync: boolean
+  drift?: DriftField[]
+  action?: 'start' | 'stop' | 'restart'
+  deferred?: boolean
+  error?: string
+}
+
+export type Role = 'viewer' | 'operator'
+
+export interface AuthInfo {
+  enabled: boolean
+  role: Role
+}
+
+export type SLOMetric = 'avg_bandwidth' | 'min_bandwidth' | 'packet_loss' | 'jitter' | 'retransmits'
+
+export interface SLObjective {
+  name: string
+  description?: string
+  metric: SLOMetric
+  min?: number
+  max?: number
+  target: number
+  windowDays: number
+  clientIp?: string
+}
+
+export interface SLIPoint {
+  date: string
+  total: number
+  good: number
+  sli: number | null
+  rollingSli: number | null
+}
+
+export interface SLOReport {
+  objective: SLObjective
+  from: string
+  to: string
+  total: number
+  good: number
+  sli: number | null
+  errorBudgetRemaining: number | null
+  series?: SLIPoint[]
+}
+
+export type PortState = 'free' | 'busy'
+
+export interface PortStatus {
+  port: number
+  state: PortState
+  sessionId?: string
+  clientIp?: string
+}
+
+export interface Collision {
+  id: number
+  timestamp: string
+  serverPort?: number
+  busySessionId?: string
+  busyClientIp?: string
+}
+
+export interface CollisionCount {
+  tests: number
+  collisions: number
+  rate: number | null
+}
+
+export interface CollisionPoint extends CollisionCount {
+  start: string
+}
+
+export interface PortCollisions extends CollisionCount {
+  port: number
+}
+
+export interface CollisionReport extends CollisionCount {
+  from: string
+  to: string
+  bucket: 'hour' | 'day'
+  series: CollisionPoint[]
+  ports: PortCollisions[]
+  recent: Collision[]
+}
+
+export interface ConfigBundle {
+  version: number
+  exportedAt: string
+  alertRules: AlertRule[]
+  costCenterAssignments: CostCenterAssignment[]
+  desiredState?: DesiredState
+  profiles?: Profile[]
+  linkCapacities?: LinkCapacity[]
+}
+
+export interface TestSlotReady {
+  timestamp: string
+  serverPort: number
+  slot: number
+  pid: number
+}
+
+export interface SlowConsumerWarning {
+  timestamp: string
+  queued: number
+  capacity: number
+}
+
+export interface CommunityAggregate {
+  region: string
+  isp: string
+  protocol: Protocol
+  direction: string
+  tests: number
+  p10: number
+  p50: number
+  p90: number
+}
+
+export interface CommunitySubmission {
+  from: string
+  to: string
+  aggregates: CommunityAggregate[]
+}
+
+export interface CommunityStatus {
+  endpoint: string
+  region: string
+  isp: string
+  lastSubmission?: string
+  lastError?: string
+  submissions: number
+}
+
+export interface CommunityComparison {
+  status: CommunityStatus
+  local: CommunitySubmission
+  community?: CommunityAggregate[]
+  error?: string
+}
+
+export interface DryRunCommand {
+  port: number
+  argv: string[]
+}
+
+export interface DryRunCheck {
+  name: 'config' | 'state' | 'binary' | 'port' | 'allowlist'
+  port?: number
+  ok: boolean
+  field?: string
+  detail?: string
+  skipped?: boolean
+  error?: string
+  warning?: string
+}
+
+export interface DryRunReport {
+  ok: boolean
+  checks: DryRunCheck[]
+  commands: DryRunCommand[]
+}
+
+export interface StatsCacheStatus {
+  ready: boolean
+  rebuilding: boolean
+  rows: number
+  lastRebuild?: string
+  lastDurationMs: number
+  lastError?: string
+}
+
+export interface BackupObject {
+  name: string
+  size: number
+  modified: string
+}
+
+export interface BackupStatus {
+  target: string
+  intervalSeconds: number
+  keep: number
+  running: boolean
+  nextBackup?: string
+  lastBackup?: BackupObject
+  lastDurationMs: number
+  lastError?: string
+  backups: number
+}
+
+export interface BackupsResponse {
+  status: BackupStatus
+  backups?: BackupObject[]
+  error?: string
+}
+
+export interface ImportRowError {
+  row: number
+  id?: string
+  field?: string
+  code: string
+  message: string
+}
+
+export interface ImportHistoryResponse {
+  imported: number
+  duplicates: number
+  errors: ImportRowError[]
+}
+
+export interface SiteProperties {
+  site: string
+  local: boolean
+  reachable: boolean
+  error?: string
+  tests: number
+  completed: number
+  avgBandwidth?: number
+  uploadBandwidth?: number
+  downloadBandwidth?: number
+  latestTest?: string
+}
+
+export interface SiteFeatureCollection {
+  type: 'FeatureCollection'
+  features: {
+    type: 'Feature'
+    geometry: { type: 'Point'; coordinates: [number, number] }
+    properties: SiteProperties
+  }[]
+}
+
+export interface PullStatus {
+  lastPull?: string
+  lastError?: string
+  pulled: number
+}
+
+export interface Peer {
+  name: string
+  url: string
+  apiKeySet: boolean
+  location?: { latitude: number; longitude: number }
+  registered: boolean
+  pull?: PullStatus
+}
+
+export interface PeersResponse {
+  peers: Peer[]
+  pulling: boolean
+}
+
+export interface Neighbor {
+  ip: string
+  mac: string
+  device?: string
+  vendor?: string
+}
+
+export interface Profile {
+  name: string
+  description?: string
+  config: ServerConfig
+  createdAt: string
+  updatedAt: string
+}
+
+export interface FieldError {
+  field: string
+  code: string
+  message: string
+}
+
+// Body of every API error response
+export interface ApiErrorResponse {
+  error: {
+    code: string
+    message: string
+    fields?: FieldError[]
+  }
+}
+
+export type ProbeMethod = 'icmp' | 'tcp' | 'udp'
+
+export interface ProbeSpec {
+  target: string
+  method: ProbeMethod
+  count: number
+  interval: number
+  timeout: number
+  continuous?: boolean
+}
+
+export interface LatencyProbe extends ProbeSpec {
+  id: string
+  startedAt: string
+  rounds: number
+}
+
+export interface LatencyPing {
+  probeId: string
+  target: string
+  seq: number
+  timestamp: string
+  rtt?: number
+  error?: string
+}
+
+export interface LatencyResult {
+  id: number
+  probeId: string
+  target: string
+  method: ProbeMethod
+  timestamp: string
+  sent: number
+  received: number
+  packetLoss: number
+  rttMin?: number
+  rttAvg?: number
+  rttMax?: number
+  rttStdDev?: number
+  error?: string
+}
+
+export interface MTURequest {
+  target: string
+  method?: 'icmp' | 'udp'
+  min?: number
+  max?: number
+  timeout?: number
+}
+
+export interface MTUResult {
+  id: number
+  target: string
+  address: string
+  method: ProbeMethod
+  timestamp: string
+  pathMtu: number
+  min: number
+  max: number
+  probes: number
+  error?: string
+}
+
+export interface TracerouteRequest {
+  target: string
+  resultId?: string
+  maxHops?: number
+  queries?: number
+  timeout?: number
+}
+
+export interface TracerouteHop {
+  traceId: string
+  ttl: number
+  address?: string
+  // Milliseconds per probe, null for probes that got no answer
+  rtts: (number | null)[]
+  error?: string
+}
+
+export interface Traceroute extends Required<Omit<TracerouteRequest, 'resultId'>> {
+  id: string
+  resultId?: string
+  address?: string
+  timestamp: string
+  hops: TracerouteHop[]
+  reached: boolean
+  done: boolean
+  error?: string
+}